@@ -12,11 +12,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"runtime"
+	"strconv"
 	"syscall"
-	"time"
 
 	"github.com/akamensky/argparse"
 
@@ -31,6 +30,7 @@ import (
 	"emu/plugins/icmp"
 	"emu/plugins/igmp"
 	"emu/plugins/ipfix"
+	"emu/plugins/ipfix_collector"
 	"emu/plugins/ipv6"
 	"emu/plugins/lldp"
 	"emu/plugins/mdns"
@@ -57,6 +57,7 @@ func RegisterPlugins(tctx *core.CThreadCtx) {
 	icmp.Register(tctx)
 	igmp.Register(tctx)
 	ipfix.Register(tctx)
+	ipfix_collector.Register(tctx)
 	ipv6.Register(tctx)
 	lldp.Register(tctx)
 	mdns.Register(tctx)
@@ -82,6 +83,9 @@ type MainArgs struct {
 	simulation     *bool
 	lockMainThread *bool
 	maxCores       *int
+	eventPort      *int    // Port of the events publisher, 0 to disable
+	metricsPort    *int    // Port of the Prometheus metrics HTTP server, 0 to disable
+	seed           *string // Seed of the random generator, empty for a seed by the time
 }
 
 func printVersion() {
@@ -125,6 +129,9 @@ func parseMainArgs() *MainArgs {
 	args.simulation = parser.Flag("s", "simulation", &argparse.Options{Default: false, Help: "Run server in simulation mode"})
 	args.lockMainThread = parser.Flag("", "lock-main-thread", &argparse.Options{Default: false, Help: "Run the main-thread in a dedicated OS thread"})
 	args.maxCores = parser.Int("", "max-cores", &argparse.Options{Default: 0, Help: "Set the max number of CPUs that can be executing simultaneously (GOMAXPROCS)"})
+	args.eventPort = parser.Int("e", "event-port", &argparse.Options{Default: 0, Help: "ZMQ PUB port for events, 0 to disable"})
+	args.metricsPort = parser.Int("", "metrics-port", &argparse.Options{Default: 0, Help: "HTTP port for Prometheus /metrics, 0 to disable"})
+	args.seed = parser.String("", "seed", &argparse.Options{Default: "", Help: "Seed of the random generator (any int64, 0 included), to replay a run with the printed seed. Without it the seed is taken from the time"})
 
 	err := parser.Parse(os.Args)
 	if err != nil {
@@ -204,8 +211,6 @@ func RunCoreZmq(args *MainArgs) {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: IPC, TX:IPC]\n", rpcPort)
 	}
 
-	rand.Seed(time.Now().UnixNano())
-
 	simulation = *args.simulation
 	dummyVeth = *args.dummyVeth || *args.kernelMode
 
@@ -218,6 +223,24 @@ func RunCoreZmq(args *MainArgs) {
 	tctx.SetVerbose(*args.verbose)
 	tctx.SetKernelMode(*args.kernelMode)
 	tctx.SetLockMainThread(*args.lockMainThread)
+	if *args.seed != "" {
+		seed, err := strconv.ParseInt(*args.seed, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid seed %q: %v", *args.seed, err)
+		}
+		tctx.SetSeed(seed)
+	}
+	fmt.Printf("Random seed: %d\n", tctx.GetSeed())
+	if *args.eventPort != 0 {
+		if err = tctx.StartEventPub(uint16(*args.eventPort)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *args.metricsPort != 0 {
+		if err = tctx.StartMetrics(uint16(*args.metricsPort)); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	if !dummyVeth {
 		zmqVeth.Create(tctx, uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
@@ -0,0 +1,383 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Bulk client add/remove.
+
+ctx_client_add gets a json object per client, which is too much for hundreds of thousands of clients. A bulk command
+gets a range instead: the start MAC/IPv4/IPv6, a step and a count. The clients are created (or removed) by a job in
+batches, one batch per tick of the timer wheel, so the RPC returns immediately and the main loop is not blocked.
+The progress of a job is read with ctx_client_bulk_get.
+
+The plugins json of a bulk add is a template. {index}, {mac}, {ipv4} and {ipv6} are replaced with the values of each
+client, for example a dot1x user name "user{index}".
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	clientBulkDefBatch   = 1000 // Default num of clients to handle in a tick
+	clientBulkJobsToKeep = 100  // Num of finished jobs to keep for ctx_client_bulk_get
+)
+
+const (
+	ClientBulkOpAdd    = "add"
+	ClientBulkOpRemove = "remove"
+
+	ClientBulkRunning = "running"
+	ClientBulkDone    = "done"
+	ClientBulkError   = "error"
+)
+
+// CClientBulkAddCmd is a range of clients to add.
+type CClientBulkAddCmd struct {
+	Mac    MACKey  `json:"mac" validate:"required"` // MAC of the first client
+	Ipv4   Ipv4Key `json:"ipv4"`                    // IPv4 of the first client, zero for no IPv4
+	DgIpv4 Ipv4Key `json:"ipv4_dg"`                 // Default gateway of all the clients
+	MTU    uint16  `json:"ipv4_mtu"`
+
+	Ipv6   Ipv6Key `json:"ipv6"`    // IPv6 of the first client, zero for no IPv6
+	DgIpv6 Ipv6Key `json:"dg_ipv6"` // Default gateway of all the clients
+
+	Step  uint32 `json:"step"`                           // Step between the addresses of two clients. Default=1.
+	Count uint32 `json:"count" validate:"required,gt=0"` // Num of clients
+	Batch uint32 `json:"batch"`                          // Num of clients to add in a tick. Default=1000.
+
+	Plugins *MapJsonPlugs `json:"plugs"` // Plugins template, the defaults of the namespace if not provided
+}
+
+// CClientBulkRemoveCmd is a range of clients to remove.
+type CClientBulkRemoveCmd struct {
+	Mac   MACKey `json:"mac" validate:"required"`        // MAC of the first client
+	Step  uint32 `json:"step"`                           // Step between the MACs of two clients. Default=1.
+	Count uint32 `json:"count" validate:"required,gt=0"` // Num of clients
+	Batch uint32 `json:"batch"`                          // Num of clients to remove in a tick. Default=1000.
+}
+
+// CClientBulkJobInfo is the progress of a bulk job.
+type CClientBulkJobInfo struct {
+	Id      uint32 `json:"id"`
+	Op      string `json:"op"`      // add or remove
+	Count   uint32 `json:"count"`   // Num of clients of the job
+	Done    uint32 `json:"done"`    // Num of clients handled
+	Skipped uint32 `json:"skipped"` // Num of clients that did not exist in a remove
+	State   string `json:"state"`   // running, done or error
+	Error   string `json:"error"`   // Error that stopped the job
+}
+
+// clientBulkJob is a bulk add/remove in progress.
+type clientBulkJob struct {
+	info   CClientBulkJobInfo
+	key    CTunnelKey // Namespace of the clients
+	batch  uint32
+	add    *CClientBulkAddCmd
+	remove *CClientBulkRemoveCmd
+}
+
+// clientBulk runs the bulk jobs of a thread, the first job that was added is run first.
+type clientBulk struct {
+	tctx   *CThreadCtx
+	timer  CHTimerObj
+	jobs   []*clientBulkJob     // Running jobs
+	done   []CClientBulkJobInfo // Last finished jobs, oldest first
+	nextId uint32
+}
+
+func (o *clientBulk) init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.timer.SetCB(o, 0, 0)
+}
+
+// addJob queues a new job and returns its id.
+func (o *clientBulk) addJob(job *clientBulkJob) uint32 {
+	o.nextId++
+	job.info.Id = o.nextId
+	job.info.State = ClientBulkRunning
+	if job.batch == 0 {
+		job.batch = clientBulkDefBatch
+	}
+	o.jobs = append(o.jobs, job)
+	if !o.timer.IsRunning() {
+		o.tctx.timerctx.StartTicks(&o.timer, 1)
+	}
+	return job.info.Id
+}
+
+// getJob returns the progress of a running or a finished job.
+func (o *clientBulk) getJob(id uint32) (*CClientBulkJobInfo, error) {
+	for _, job := range o.jobs {
+		if job.info.Id == id {
+			info := job.info
+			return &info, nil
+		}
+	}
+	for i := range o.done {
+		if o.done[i].Id == id {
+			info := o.done[i]
+			return &info, nil
+		}
+	}
+	return nil, fmt.Errorf("bulk job %v does not exist", id)
+}
+
+// OnEvent handles a batch of the first job every tick.
+func (o *clientBulk) OnEvent(a, b interface{}) {
+	if len(o.jobs) == 0 {
+		return
+	}
+	job := o.jobs[0]
+	o.runBatch(job)
+	if job.info.State != ClientBulkRunning {
+		o.jobs = o.jobs[1:]
+		if len(o.done) == clientBulkJobsToKeep {
+			o.done = append(o.done[:0], o.done[1:]...)
+		}
+		o.done = append(o.done, job.info)
+	}
+	if len(o.jobs) > 0 {
+		o.tctx.timerctx.StartTicks(&o.timer, 1)
+	}
+}
+
+func (o *clientBulk) onDelete() {
+	if o.timer.IsRunning() {
+		o.tctx.timerctx.Stop(&o.timer)
+	}
+}
+
+// runBatch adds or removes the next batch of clients of the job.
+func (o *clientBulk) runBatch(job *clientBulkJob) {
+	ns := o.tctx.GetNs(&job.key)
+	if ns == nil {
+		job.info.State = ClientBulkError
+		job.info.Error = "namespace was removed"
+		return
+	}
+	for i := uint32(0); i < job.batch && job.info.Done < job.info.Count; i++ {
+		var err error
+		if job.add != nil {
+			err = job.addClient(ns, job.info.Done)
+		} else {
+			err = job.removeClient(ns, job.info.Done)
+		}
+		if err != nil {
+			job.info.State = ClientBulkError
+			job.info.Error = err.Error()
+			return
+		}
+		job.info.Done++
+	}
+	if job.info.Done == job.info.Count {
+		job.info.State = ClientBulkDone
+	}
+}
+
+// bulkStep returns the step of a range, the default is 1.
+func bulkStep(step uint32) uint64 {
+	if step == 0 {
+		return 1
+	}
+	return uint64(step)
+}
+
+// bulkLastOffset returns the offset of the last client of a range from the start addresses.
+func bulkLastOffset(step uint32, count uint32) uint64 {
+	return uint64(count-1) * bulkStep(step)
+}
+
+// validateBulkMac checks that the MACs of a range do not wrap.
+func validateBulkMac(start MACKey, step uint32, count uint32) error {
+	if start.Uint64()+bulkLastOffset(step, count) > 0xffffffffffff {
+		return fmt.Errorf("MAC range from %v with step %v and count %v overflows", start, bulkStep(step), count)
+	}
+	return nil
+}
+
+// validate checks that the addresses of the range do not wrap, which would give duplicate addresses.
+func (o *CClientBulkAddCmd) validate() error {
+	if err := validateBulkMac(o.Mac, o.Step, o.Count); err != nil {
+		return err
+	}
+	offset := bulkLastOffset(o.Step, o.Count)
+	if !o.Ipv4.IsZero() && uint64(o.Ipv4.Uint32())+offset > 0xffffffff {
+		return fmt.Errorf("IPv4 range from %v with step %v and count %v overflows", o.Ipv4.ToIP(), bulkStep(o.Step), o.Count)
+	}
+	if !o.Ipv6.IsZero() && binary.BigEndian.Uint64(o.Ipv6[8:]) > math.MaxUint64-offset {
+		return fmt.Errorf("IPv6 range from %v with step %v and count %v overflows", o.Ipv6.ToIP(), bulkStep(o.Step), o.Count)
+	}
+	return nil
+}
+
+// bulkMac returns the MAC of the client at index of a range.
+func bulkMac(start MACKey, step uint32, index uint32) MACKey {
+	var mac MACKey
+	mac.SetUint64(start.Uint64() + uint64(index)*bulkStep(step))
+	return mac
+}
+
+// addClient adds the client at index of the range.
+func (o *clientBulkJob) addClient(ns *CNSCtx, index uint32) error {
+	cmd := o.add
+	offset := uint64(index) * bulkStep(cmd.Step)
+	c := CClientCmd{
+		Mac:    bulkMac(cmd.Mac, cmd.Step, index),
+		DgIpv4: cmd.DgIpv4,
+		MTU:    cmd.MTU,
+		DgIpv6: cmd.DgIpv6,
+	}
+	if !cmd.Ipv4.IsZero() {
+		c.Ipv4.SetUint32(cmd.Ipv4.Uint32() + uint32(offset))
+	}
+	if !cmd.Ipv6.IsZero() {
+		c.Ipv6 = cmd.Ipv6
+		binary.BigEndian.PutUint64(c.Ipv6[8:], binary.BigEndian.Uint64(cmd.Ipv6[8:])+offset)
+	}
+	if cmd.Plugins != nil {
+		c.Plugins = bulkPlugins(cmd.Plugins, &c, index)
+	}
+	if err := addClientCmd(ns, &c); err != nil {
+		return fmt.Errorf("failed adding client %v: %v", c.Mac, err.Message)
+	}
+	return nil
+}
+
+// removeClient removes the client at index of the range, a client that does not exist is skipped.
+func (o *clientBulkJob) removeClient(ns *CNSCtx, index uint32) error {
+	mac := bulkMac(o.remove.Mac, o.remove.Step, index)
+	client := ns.CLookupByMac(&mac)
+	if client == nil {
+		o.info.Skipped++
+		return nil
+	}
+	return ns.RemoveClient(client)
+}
+
+// bulkPlugins returns the plugins of a client, with the template variables replaced by the values of the client.
+func bulkPlugins(template *MapJsonPlugs, c *CClientCmd, index uint32) *MapJsonPlugs {
+	replacer := [][2]string{
+		{"{index}", strconv.FormatUint(uint64(index), 10)},
+		{"{mac}", c.Mac.String()},
+		{"{ipv4}", c.Ipv4.ToIP().String()},
+		{"{ipv6}", c.Ipv6.ToIP().String()},
+	}
+	plugs := make(MapJsonPlugs, len(*template))
+	for name, data := range *template {
+		plugs[name] = data
+		if data == nil {
+			continue
+		}
+		b := []byte(*data)
+		for _, r := range replacer {
+			b = bytes.ReplaceAll(b, []byte(r[0]), []byte(r[1]))
+		}
+		if !bytes.Equal(b, *data) {
+			raw := fastjson.RawMessage(b)
+			plugs[name] = &raw
+		}
+	}
+	return &plugs
+}
+
+type (
+	ApiClientBulkAddHandler    struct{}
+	ApiClientBulkRemoveHandler struct{}
+	ApiClientBulkResult        struct {
+		Id uint32 `json:"id"` // Id of the job
+	}
+
+	ApiClientBulkGetHandler struct{}
+	ApiClientBulkGetParams  struct {
+		Id uint32 `json:"id" validate:"required"`
+	}
+)
+
+func (h ApiClientBulkAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	ns, err := tctx.GetNsRpc(params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	var cmd CClientBulkAddCmd
+	err = tctx.UnmarshalValidate(*params, &cmd)
+	if err == nil {
+		err = cmd.validate()
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	job := &clientBulkJob{key: ns.Key, batch: cmd.Batch, add: &cmd}
+	job.info.Op = ClientBulkOpAdd
+	job.info.Count = cmd.Count
+	return &ApiClientBulkResult{Id: tctx.bulk.addJob(job)}, nil
+}
+
+func (h ApiClientBulkRemoveHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	ns, err := tctx.GetNsRpc(params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	var cmd CClientBulkRemoveCmd
+	err = tctx.UnmarshalValidate(*params, &cmd)
+	if err == nil {
+		err = validateBulkMac(cmd.Mac, cmd.Step, cmd.Count)
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	job := &clientBulkJob{key: ns.Key, batch: cmd.Batch, remove: &cmd}
+	job.info.Op = ClientBulkOpRemove
+	job.info.Count = cmd.Count
+	return &ApiClientBulkResult{Id: tctx.bulk.addJob(job)}, nil
+}
+
+func (h ApiClientBulkGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var p ApiClientBulkGetParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	info, err := tctx.bulk.getJob(p.Id)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return info, nil
+}
+
+func init() {
+	RegisterCB("ctx_client_bulk_add", ApiClientBulkAddHandler{}, false)
+	RegisterCB("ctx_client_bulk_remove", ApiClientBulkRemoveHandler{}, false)
+	RegisterCB("ctx_client_bulk_get", ApiClientBulkGetHandler{}, false)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"external/osamingo/jsonrpc"
+	"testing"
+
+	"github.com/intel-go/fastjson"
+)
+
+// runBulkJob runs the timer until the bulk job is finished, returns the num of batches it took.
+func runBulkJob(t *testing.T, tctx *CThreadCtx, res interface{}) (*CClientBulkJobInfo, int) {
+	id := res.(*ApiClientBulkResult).Id
+	batches := 0
+	done := uint32(0)
+	for ticks := 1; ticks < 100; ticks++ {
+		tctx.HandleMainTimerTicks()
+		info, err := tctx.bulk.getJob(id)
+		if err != nil {
+			t.Fatalf("bulk job %v is missing: %v", id, err)
+		}
+		if info.Done != done {
+			done = info.Done
+			batches++
+		}
+		if info.State != ClientBulkRunning {
+			return info, batches
+		}
+	}
+	t.Fatalf("bulk job %v did not finish", id)
+	return nil, 0
+}
+
+func TestClientBulk(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	params := fastjson.RawMessage(`{"tun": {"vport": 1}, "mac": [0, 0, 1, 0, 0, 1], "ipv4": [16, 0, 0, 1],
+		"ipv4_dg": [16, 0, 0, 254], "ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1],
+		"step": 2, "count": 2500}`)
+	res, rpcErr := ApiClientBulkAddHandler{}.ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("bulk add failed: %v", rpcErr.Message)
+	}
+	info, batches := runBulkJob(t, tctx, res)
+	if info.State != ClientBulkDone || info.Done != 2500 || batches != 3 {
+		t.Fatalf("bad bulk add %+v after %v batches", info, batches)
+	}
+
+	last := ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0x13, 0x87})
+	if last == nil {
+		t.Fatalf("last client of the range is missing")
+	}
+	if last.Ipv4 != (Ipv4Key{16, 0, 19, 0x87}) || last.DgIpv4 != (Ipv4Key{16, 0, 0, 254}) {
+		t.Fatalf("bad ipv4 of the last client %v %v", last.Ipv4, last.DgIpv4)
+	}
+	if last.Ipv6 != (Ipv6Key{32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x13, 0x87}) {
+		t.Fatalf("bad ipv6 of the last client %v", last.Ipv6)
+	}
+	if ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0, 2}) != nil {
+		t.Fatalf("client out of the step should not exist")
+	}
+
+	// adding the same range again fails on the first client
+	res, rpcErr = ApiClientBulkAddHandler{}.ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("bulk add failed: %v", rpcErr.Message)
+	}
+	info, _ = runBulkJob(t, tctx, res)
+	if info.State != ClientBulkError || info.Done != 0 || info.Error == "" {
+		t.Fatalf("bulk add of existing clients should fail %+v", info)
+	}
+
+	params = fastjson.RawMessage(`{"tun": {"vport": 1}, "mac": [0, 0, 1, 0, 0, 1], "count": 5000, "batch": 5000}`)
+	res, rpcErr = ApiClientBulkRemoveHandler{}.ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("bulk remove failed: %v", rpcErr.Message)
+	}
+	info, batches = runBulkJob(t, tctx, res)
+	if info.State != ClientBulkDone || info.Done != 5000 || info.Skipped != 2500 || batches != 1 {
+		t.Fatalf("bad bulk remove %+v after %v batches", info, batches)
+	}
+	if ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0, 1}) != nil || ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0x13, 0x87}) != nil {
+		t.Fatalf("clients should be removed")
+	}
+
+	params = fastjson.RawMessage(`{"id": 100}`)
+	if _, rpcErr = (ApiClientBulkGetHandler{}).ServeJSONRPC(tctx, &params); rpcErr == nil {
+		t.Fatalf("get of unknown job should fail")
+	}
+}
+
+func TestClientBulkOverflow(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	for _, p := range []string{
+		`{"tun": {"vport": 1}, "mac": [255, 255, 255, 255, 255, 250], "count": 7}`,
+		`{"tun": {"vport": 1}, "mac": [0, 0, 1, 0, 0, 1], "ipv4": [255, 255, 255, 0], "step": 16, "count": 17}`,
+		`{"tun": {"vport": 1}, "mac": [0, 0, 1, 0, 0, 1], "ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 255, 255, 255, 255, 255, 255, 255, 255], "count": 2}`,
+	} {
+		params := fastjson.RawMessage(p)
+		_, rpcErr := ApiClientBulkAddHandler{}.ServeJSONRPC(tctx, &params)
+		if rpcErr == nil || rpcErr.Code != jsonrpc.ErrorCodeInvalidParams {
+			t.Fatalf("bulk add of an overflowing range should fail %v", p)
+		}
+	}
+	params := fastjson.RawMessage(`{"tun": {"vport": 1}, "mac": [255, 255, 255, 255, 255, 250], "step": 2, "count": 4}`)
+	if _, rpcErr := (ApiClientBulkRemoveHandler{}).ServeJSONRPC(tctx, &params); rpcErr == nil {
+		t.Fatalf("bulk remove of an overflowing range should fail")
+	}
+
+	// the last address of the range is valid
+	params = fastjson.RawMessage(`{"tun": {"vport": 1}, "mac": [255, 255, 255, 255, 255, 250], "ipv4": [255, 255, 255, 0],
+		"step": 5, "count": 2}`)
+	res, rpcErr := ApiClientBulkAddHandler{}.ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("bulk add failed: %v", rpcErr.Message)
+	}
+	info, _ := runBulkJob(t, tctx, res)
+	if info.State != ClientBulkDone || ns.CLookupByMac(&MACKey{255, 255, 255, 255, 255, 255}) == nil {
+		t.Fatalf("bad bulk add up to the last address %+v", info)
+	}
+}
+
+func TestClientBulkPlugins(t *testing.T) {
+	template := fastjson.RawMessage(`{"user": "user{index}", "mac": "{mac}", "ip": "{ipv4}"}`)
+	static := fastjson.RawMessage(`{"timer": 1}`)
+	plugs := MapJsonPlugs{"dot1x": &template, "arp": &static}
+
+	c := CClientCmd{Mac: MACKey{0, 0, 1, 0, 0, 7}, Ipv4: Ipv4Key{16, 0, 0, 7}}
+	res := bulkPlugins(&plugs, &c, 6)
+	if string(*(*res)["dot1x"]) != `{"user": "user6", "mac": "00:00:01:00:00:07", "ip": "16.0.0.7"}` {
+		t.Fatalf("bad plugin template %s", *(*res)["dot1x"])
+	}
+	if (*res)["arp"] != &static {
+		t.Fatalf("plugin without variables should not be copied")
+	}
+	if string(template) != `{"user": "user{index}", "mac": "{mac}", "ip": "{ipv4}"}` {
+		t.Fatalf("template should not change")
+	}
+}
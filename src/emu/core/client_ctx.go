@@ -44,6 +44,7 @@ func NewClientStatsCounterDb(o *CClientStats) *CCounterDb {
 		Help:     "active clients",
 		Unit:     "",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 	return db
 }
@@ -100,6 +101,11 @@ type CClient struct {
 	bitMask            uint8      // Bit mask for resolving message
 	resolveAttempts    uint8      // Counter counting how many times have we tried to resolve
 	maxResolveAttempts uint8      // Maximum amount of resolves allowed
+	active             bool       // Client was activated, false while it waits for the ramp-up
+	msRequired         uint8      // Milestones the client should reach before it is ready
+	msReached          uint8      // Milestones the client reached
+
+	msRequiredCnt [clientMsNum]uint8 // Plugins that require each milestone
 
 	PbitList PbitList //pbit list
 }
@@ -171,6 +177,7 @@ func NewClient(ns *CNSCtx,
 	o.DgIpv4 = DgIpv4
 	o.Maskv4 = [4]byte{0xff, 0xff, 0xff, 0xff}
 	o.MTU = 1500
+	o.active = true
 	o.PluginCtx = NewPluginCtx(o, ns, ns.ThreadCtx, PLUGIN_LEVEL_CLIENT)
 	return o
 }
@@ -200,8 +207,24 @@ func (o *CClient) AttemptResolve() {
 	o.OnEvent(0, 0)                         // Call on Event explicitly the first time
 }
 
+// IsActive returns false while the client waits for the ramp-up. Plugins should not start before the client
+// is active, they get MSG_CLIENT_ACTIVATED when it is.
+func (o *CClient) IsActive() bool {
+	return o.active
+}
+
+// activate is called by the ramp-up, it starts the plugins that waited and the resolve of the default gateway.
+func (o *CClient) activate() {
+	o.active = true
+	o.PluginCtx.BroadcastMsg(nil, MSG_CLIENT_ACTIVATED, nil, nil)
+	o.AttemptResolve()
+}
+
 /*OnRemove called on before removing the client */
 func (o *CClient) OnRemove() {
+	if !o.active {
+		o.Ns.ThreadCtx.ramp.onRemoveClient(o)
+	}
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
@@ -235,6 +258,7 @@ func (o *CClient) OnEvent(a, b interface{}) {
 	if broadcast {
 		// Should broadcast as something new was resolved.
 		o.PluginCtx.BroadcastMsg(nil, MSG_DG_MAC_RESOLVED, o.bitMask, 0)
+		o.publishDgResolved()
 	}
 	if (o.resolveAttempts < o.maxResolveAttempts) && (!ipv4DGResolved || !ipv6DGResolved) {
 		// restart ticks as at least of IPv4/IPv6 isn't resolved and we didn't pass the
@@ -458,6 +482,24 @@ func (o *CClient) ResolveIPv6DGMac() (mac MACKey, ok bool) {
 	return mac, ok
 }
 
+// publishDgResolved publishes the MACs of the default gateways that are resolved.
+func (o *CClient) publishDgResolved() {
+	if !o.Ns.ThreadCtx.IsEventEnabled(EVENT_CLIENT_DG_RESOLVED) {
+		return
+	}
+	var ev struct {
+		Ipv4DgMac *MACKey `json:"ipv4_dg_mac,omitempty"`
+		Ipv6DgMac *MACKey `json:"ipv6_dg_mac,omitempty"`
+	}
+	if mac, ok := o.ResolveIPv4DGMac(); ok {
+		ev.Ipv4DgMac = &mac
+	}
+	if mac, ok := o.ResolveIPv6DGMac(); ok {
+		ev.Ipv6DgMac = &mac
+	}
+	o.PublishEvent(EVENT_CLIENT_DG_RESOLVED, &ev)
+}
+
 func (o *CClient) GetSourceIPv6() (Ipv6Key, error) {
 	if !o.Dhcpv6.IsZero() {
 		return o.Dhcpv6, nil
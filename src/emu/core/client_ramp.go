@@ -0,0 +1,249 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Client ramp-up.
+
+When many clients are added at once all their plugins start together (GARP, DHCP discover, dot1x start ..) and the
+DUT rate limits the storm. With a ramp-up rate the clients that are added are not active, they are queued and
+activated at the rate of the ramp-up (clients/sec, with an optional jitter), and at most at the rate of a namespace
+if it is set. Each rate can be set without the other.
+
+A client is active by default. A plugin that should not start before its client is active checks IsActive() when it
+is created, and if it is not, starts when it gets MSG_CLIENT_ACTIVATED. The default gateway is resolved only after
+the client is activated. IGMP/MLD reports of a namespace wait for its designator client.
+*/
+
+import (
+	"external/osamingo/jsonrpc"
+	"math"
+
+	"github.com/intel-go/fastjson"
+)
+
+const rampEpsilon = 1e-9 // Credit is a sum of fractions of a client per tick
+
+// CRampCfg is the configuration of the ramp-up.
+type CRampCfg struct {
+	Rate   float64 `json:"rate" validate:"gte=0"`         // Clients to activate per sec, 0 for no limit
+	Jitter float64 `json:"jitter" validate:"gte=0,lte=1"` // Relative jitter of the rate, 0.1 is +/-10%
+	NsRate float64 `json:"ns_rate" validate:"gte=0"`      // Clients to activate per sec in a namespace, 0 for no limit
+}
+
+// CRampNsInfo is the progress of the ramp-up in a namespace.
+type CRampNsInfo struct {
+	Tun       CTunnelDataJson `json:"tun"`
+	Pending   uint64          `json:"pending"`   // Clients that wait to be activated
+	Activated uint64          `json:"activated"` // Clients that were activated by the ramp-up
+}
+
+// CRampInfo is the progress of the ramp-up.
+type CRampInfo struct {
+	CRampCfg
+	Pending   uint64        `json:"pending"`
+	Activated uint64        `json:"activated"`
+	Ns        []CRampNsInfo `json:"ns"` // Namespaces with pending clients
+}
+
+// rampNs holds the clients of a namespace that wait to be activated.
+type rampNs struct {
+	key       CTunnelKey
+	queue     []*CClient
+	credit    float64 // Clients that can be activated now, by ns_rate
+	pending   uint64
+	activated uint64
+}
+
+// clientRamp activates the clients of a thread at the rate of the ramp-up. The namespaces are served in turns.
+type clientRamp struct {
+	tctx      *CThreadCtx
+	cfg       CRampCfg
+	timer     CHTimerObj
+	nsList    []*rampNs // Namespaces with pending clients, in the order they were added
+	mapNs     map[CTunnelKey]*rampNs
+	credit    float64 // Clients that can be activated now, by rate
+	next      int     // Next namespace to serve
+	lastTick  uint64  // Tick of the last event, the credit is by the time that passed
+	pending   uint64
+	activated uint64
+}
+
+func (o *clientRamp) init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.mapNs = make(map[CTunnelKey]*rampNs)
+	o.timer.SetCB(o, 0, 0)
+}
+
+// isEnabled returns true if new clients should wait to be activated, by the rate of the thread or of a namespace.
+func (o *clientRamp) isEnabled() bool {
+	return o.cfg.Rate > 0 || o.cfg.NsRate > 0
+}
+
+// setCfg changes the configuration. Disabling the ramp-up activates all the pending clients on the next tick.
+func (o *clientRamp) setCfg(cfg *CRampCfg) {
+	o.cfg = *cfg
+	o.startTimer()
+}
+
+func (o *clientRamp) startTimer() {
+	if o.pending > 0 && !o.timer.IsRunning() {
+		o.lastTick = o.tctx.timerctx.Ticks
+		o.tctx.timerctx.StartTicks(&o.timer, 1)
+	}
+}
+
+// addClient queues a new client to be activated.
+func (o *clientRamp) addClient(client *CClient) {
+	key := client.Ns.Key
+	ns, ok := o.mapNs[key]
+	if !ok {
+		ns = &rampNs{key: key}
+		o.mapNs[key] = ns
+		o.nsList = append(o.nsList, ns)
+	}
+	client.active = false
+	ns.queue = append(ns.queue, client)
+	ns.pending++
+	o.pending++
+	o.startTimer()
+}
+
+// onRemoveClient is called when a client that was not activated is removed, it is skipped in the queue.
+func (o *clientRamp) onRemoveClient(client *CClient) {
+	if ns, ok := o.mapNs[client.Ns.Key]; ok {
+		ns.pending--
+		o.pending--
+	}
+}
+
+// OnEvent activates the clients that the rate allows every tick.
+func (o *clientRamp) OnEvent(a, b interface{}) {
+	now := o.tctx.timerctx.Ticks
+	elapsedSec := float64(now-o.lastTick) * o.tctx.timerctx.TickDuration.Seconds()
+	o.lastTick = now
+	limit := o.pending
+	if o.cfg.Rate > 0 {
+		rate := o.cfg.Rate * elapsedSec
+		if o.cfg.Jitter > 0 {
+			rate *= 1 + o.cfg.Jitter*(2*o.tctx.rnd.Float64()-1)
+		}
+		o.credit += rate
+		limit = uint64(o.credit + rampEpsilon)
+		o.credit -= float64(limit)
+	}
+
+	nsRate := o.cfg.NsRate * elapsedSec
+	if nsRate > 0 {
+		for _, ns := range o.nsList {
+			// don't save credit while the namespace waits for the rate of the thread
+			ns.credit = math.Min(ns.credit+nsRate, nsRate+1)
+		}
+	}
+
+	var activated uint64
+	idle := 0
+	for activated < limit && len(o.nsList) > 0 && idle < len(o.nsList) {
+		if o.next >= len(o.nsList) {
+			o.next = 0
+		}
+		ns := o.nsList[o.next]
+		if nsRate > 0 && ns.credit+rampEpsilon < 1 {
+			idle++
+			o.next++
+			continue
+		}
+		if o.activateNext(ns) {
+			activated++
+			ns.credit--
+			idle = 0
+		}
+		if len(ns.queue) == 0 {
+			o.removeNs(o.next)
+			continue
+		}
+		o.next++
+	}
+	for i := len(o.nsList) - 1; i >= 0; i-- {
+		if o.nsList[i].pending == 0 {
+			// only removed clients are left
+			o.removeNs(i)
+		}
+	}
+	if o.pending == 0 {
+		o.credit = 0
+	}
+	o.startTimer()
+}
+
+// activateNext activates the first client in the queue of the namespace. Returns false if the client was removed.
+func (o *clientRamp) activateNext(ns *rampNs) bool {
+	client := ns.queue[0]
+	ns.queue = ns.queue[1:]
+	if client.active || client.Ns.CLookupByMac(&client.Mac) != client || o.tctx.GetNs(&ns.key) != client.Ns {
+		return false
+	}
+	ns.pending--
+	ns.activated++
+	o.pending--
+	o.activated++
+	client.activate()
+	return true
+}
+
+func (o *clientRamp) removeNs(i int) {
+	ns := o.nsList[i]
+	delete(o.mapNs, ns.key)
+	o.nsList = append(o.nsList[:i], o.nsList[i+1:]...)
+}
+
+func (o *clientRamp) getInfo() *CRampInfo {
+	info := &CRampInfo{CRampCfg: o.cfg, Pending: o.pending, Activated: o.activated}
+	info.Ns = make([]CRampNsInfo, 0, len(o.nsList))
+	for _, ns := range o.nsList {
+		var nsInfo CRampNsInfo
+		ns.key.GetJson(&nsInfo.Tun)
+		nsInfo.Pending = ns.pending
+		nsInfo.Activated = ns.activated
+		info.Ns = append(info.Ns, nsInfo)
+	}
+	return info
+}
+
+func (o *clientRamp) onDelete() {
+	if o.timer.IsRunning() {
+		o.tctx.timerctx.Stop(&o.timer)
+	}
+}
+
+type (
+	ApiRampSetHandler struct{}
+	ApiRampGetHandler struct{}
+)
+
+func (h ApiRampSetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var cfg CRampCfg
+	err := tctx.UnmarshalValidate(*params, &cfg)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	tctx.ramp.setCfg(&cfg)
+	return nil, nil
+}
+
+func (h ApiRampGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	return tctx.ramp.getInfo(), nil
+}
+
+func init() {
+	RegisterCB("ctx_ramp_set", ApiRampSetHandler{}, false)
+	RegisterCB("ctx_ramp_get", ApiRampGetHandler{}, false)
+}
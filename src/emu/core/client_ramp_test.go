@@ -0,0 +1,135 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+func addRampTestClients(t *testing.T, tctx *CThreadCtx, ns *CNSCtx, first uint8, count int) []*CClient {
+	clients := make([]*CClient, 0, count)
+	for i := 0; i < count; i++ {
+		c := CClientCmd{Mac: MACKey{0, 0, 1, 0, first, uint8(i + 1)}}
+		if err := addClientCmd(ns, &c); err != nil {
+			t.Fatalf("failed adding client: %v", err.Message)
+		}
+		clients = append(clients, ns.CLookupByMac(&c.Mac))
+	}
+	return clients
+}
+
+func countActive(clients []*CClient) int {
+	cnt := 0
+	for _, c := range clients {
+		if c.IsActive() {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func runTicks(tctx *CThreadCtx, duration time.Duration) {
+	ticks := tctx.GetTimerCtx().DurationToTicks(duration)
+	for i := uint32(0); i < ticks; i++ {
+		tctx.HandleMainTimerTicks()
+	}
+}
+
+func TestClientRamp(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key1, key2 CTunnelKey
+	key1.Set(&CTunnelData{Vport: 1})
+	key2.Set(&CTunnelData{Vport: 2})
+	ns1 := NewNSCtx(tctx, &key1)
+	tctx.AddNs(&key1, ns1)
+	ns2 := NewNSCtx(tctx, &key2)
+	tctx.AddNs(&key2, ns2)
+
+	// without a rate, clients are active when they are added
+	clients := addRampTestClients(t, tctx, ns1, 0, 5)
+	if countActive(clients) != 5 {
+		t.Fatalf("clients should be active without ramp-up")
+	}
+
+	params := fastjson.RawMessage(`{"rate": 100, "ns_rate": 20}`)
+	if _, err := (ApiRampSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("ramp set failed: %v", err.Message)
+	}
+	clients1 := addRampTestClients(t, tctx, ns1, 1, 50)
+	clients2 := addRampTestClients(t, tctx, ns2, 2, 50)
+	if countActive(clients1)+countActive(clients2) != 0 {
+		t.Fatalf("clients should wait for the ramp-up")
+	}
+
+	// the rate of a namespace limits each one to 20 clients/sec
+	runTicks(tctx, time.Second)
+	active1, active2 := countActive(clients1), countActive(clients2)
+	if active1 < 19 || active1 > 21 || active2 < 19 || active2 > 21 {
+		t.Fatalf("bad ramp-up with ns rate, active %v %v", active1, active2)
+	}
+
+	// removed pending clients are skipped
+	if err := ns2.RemoveClient(clients2[49]); err != nil {
+		t.Fatalf("failed removing client %v", err)
+	}
+	res, _ := (ApiRampGetHandler{}).ServeJSONRPC(tctx, nil)
+	info := res.(*CRampInfo)
+	if info.Pending != uint64(99-active1-active2) || len(info.Ns) != 2 {
+		t.Fatalf("bad ramp info %+v", info)
+	}
+
+	// the rate of the thread limits to 100 clients/sec
+	params = fastjson.RawMessage(`{"rate": 30}`)
+	if _, err := (ApiRampSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("ramp set failed: %v", err.Message)
+	}
+	before := countActive(clients1) + countActive(clients2)
+	runTicks(tctx, time.Second)
+	after := countActive(clients1) + countActive(clients2)
+	if after-before < 29 || after-before > 31 {
+		t.Fatalf("bad ramp-up rate, activated %v", after-before)
+	}
+
+	// disabling the ramp-up activates all the pending clients
+	params = fastjson.RawMessage(`{"rate": 0}`)
+	if _, err := (ApiRampSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("ramp set failed: %v", err.Message)
+	}
+	runTicks(tctx, 100*time.Millisecond)
+	if countActive(clients1) != 50 || countActive(clients2) != 49 {
+		t.Fatalf("all clients should be active %v %v", countActive(clients1), countActive(clients2))
+	}
+	res, _ = (ApiRampGetHandler{}).ServeJSONRPC(tctx, nil)
+	info = res.(*CRampInfo)
+	if info.Pending != 0 || info.Activated != 99 || len(info.Ns) != 0 {
+		t.Fatalf("bad ramp info %+v", info)
+	}
+
+	// the rate of a namespace without a rate of the thread
+	params = fastjson.RawMessage(`{"ns_rate": 10}`)
+	if _, err := (ApiRampSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("ramp set failed: %v", err.Message)
+	}
+	clients1 = addRampTestClients(t, tctx, ns1, 3, 20)
+	clients2 = addRampTestClients(t, tctx, ns2, 4, 20)
+	if countActive(clients1)+countActive(clients2) != 0 {
+		t.Fatalf("clients should wait for the ramp-up of the namespace")
+	}
+	runTicks(tctx, time.Second)
+	active1, active2 = countActive(clients1), countActive(clients2)
+	if active1 < 9 || active1 > 11 || active2 < 9 || active2 > 11 {
+		t.Fatalf("bad ramp-up with ns rate only, active %v %v", active1, active2)
+	}
+
+	params = fastjson.RawMessage(`{"rate": 10, "jitter": 2}`)
+	if _, err := (ApiRampSetHandler{}).ServeJSONRPC(tctx, &params); err == nil {
+		t.Fatalf("jitter above 1 should fail")
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Client lifecycle state.
+
+A client is ready when it reached all the milestones it requires, in this order:
+
+	inactive -> l2_auth -> address -> gateway -> ready
+
+The state of a client is the first milestone it waits for. A plugin that is responsible for a milestone requires it
+when it is created and reports when it is reached (or lost), e.g. dot1x requires l2_auth and DHCP requires address
+and gateway. It releases the milestone when it is removed, the client waits for it as long as a plugin requires it.
+The gateway is required also when the client has a default gateway, it is reached when the MAC of the default
+gateway (IPv4 or IPv6) is resolved.
+*/
+
+import (
+	"external/osamingo/jsonrpc"
+
+	"github.com/intel-go/fastjson"
+)
+
+// Milestones of a client, a plugin requires a milestone and reports when it is reached.
+const (
+	CLIENT_MS_L2_AUTH = 1 << iota // Layer 2 authentication, dot1x
+	CLIENT_MS_ADDRESS             // Address acquired, DHCP
+	CLIENT_MS_GATEWAY             // Default gateway resolved
+	clientMsNum       = iota
+)
+
+// ClientState is the lifecycle state of a client, the milestone it waits for.
+type ClientState uint8
+
+const (
+	ClientStateInactive ClientState = iota // Waits for the ramp-up
+	ClientStateL2Auth
+	ClientStateAddress
+	ClientStateGateway
+	ClientStateReady
+	clientStateMax
+)
+
+var clientStateNames = [clientStateMax]string{"inactive", "l2_auth", "address", "gateway", "ready"}
+
+func (s ClientState) String() string {
+	if s < clientStateMax {
+		return clientStateNames[s]
+	}
+	return "unknown"
+}
+
+// clientStateByName returns the state by its name.
+func clientStateByName(name string) (ClientState, bool) {
+	for i, n := range clientStateNames {
+		if n == name {
+			return ClientState(i), true
+		}
+	}
+	return clientStateMax, false
+}
+
+// RequireMilestone is called by a plugin that the client should reach the milestone before it is ready.
+func (o *CClient) RequireMilestone(ms uint8) {
+	for i := range o.msRequiredCnt {
+		if ms&(1<<i) != 0 {
+			o.msRequiredCnt[i]++
+		}
+	}
+	o.msRequired |= ms
+}
+
+// ReleaseMilestone is called by a plugin that required the milestone when it is removed.
+func (o *CClient) ReleaseMilestone(ms uint8) {
+	for i := range o.msRequiredCnt {
+		bit := uint8(1 << i)
+		if ms&bit == 0 || o.msRequiredCnt[i] == 0 {
+			continue
+		}
+		o.msRequiredCnt[i]--
+		if o.msRequiredCnt[i] == 0 {
+			o.msRequired &^= bit
+			o.msReached &^= bit
+		}
+	}
+}
+
+// ReachMilestone is called by a plugin when the client reached the milestone.
+func (o *CClient) ReachMilestone(ms uint8) {
+	o.msReached |= ms
+}
+
+// ClearMilestone is called by a plugin when the client lost the milestone, e.g. authentication failed.
+func (o *CClient) ClearMilestone(ms uint8) {
+	o.msReached &^= ms
+}
+
+// isGatewayResolved returns true if the MAC of one of the default gateways is known.
+func (o *CClient) isGatewayResolved() bool {
+	if _, ok := o.ResolveIPv4DGMac(); ok {
+		return true
+	}
+	_, ok := o.ResolveIPv6DGMac()
+	return ok
+}
+
+// GetState returns the lifecycle state of the client.
+func (o *CClient) GetState() ClientState {
+	if !o.active {
+		return ClientStateInactive
+	}
+	required := o.msRequired
+	if !o.DgIpv4.IsZero() || !o.DgIpv6.IsZero() || o.ForceDGW || o.Ipv6ForceDGW {
+		required |= CLIENT_MS_GATEWAY
+	}
+	missing := required &^ o.msReached
+	if missing&CLIENT_MS_L2_AUTH != 0 {
+		return ClientStateL2Auth
+	}
+	if missing&CLIENT_MS_ADDRESS != 0 {
+		return ClientStateAddress
+	}
+	if missing&CLIENT_MS_GATEWAY != 0 && !o.isGatewayResolved() {
+		return ClientStateGateway
+	}
+	return ClientStateReady
+}
+
+// CClientStateCnt is the num of clients in each state.
+type CClientStateCnt map[string]uint64
+
+func newClientStateCnt() CClientStateCnt {
+	cnt := make(CClientStateCnt, clientStateMax)
+	for _, n := range clientStateNames {
+		cnt[n] = 0
+	}
+	return cnt
+}
+
+// CNsStateCnt is the num of clients in each state in a namespace.
+type CNsStateCnt struct {
+	Tun    CTunnelDataJson `json:"tun"`
+	States CClientStateCnt `json:"states"`
+}
+
+// GetStateCnt returns the num of clients in each state in the namespace.
+func (o *CNSCtx) GetStateCnt() CClientStateCnt {
+	cnt := newClientStateCnt()
+	for d := o.clientHead.Next(); d != &o.clientHead; d = d.Next() {
+		cnt[castDlistClient(d).GetState().String()]++
+	}
+	return cnt
+}
+
+// GetClientsByState returns up to count clients in the state starting from offset, and the num of clients in
+// the state.
+func (o *CNSCtx) GetClientsByState(state ClientState, offset, count uint32) ([]MACKey, uint32) {
+	r := make([]MACKey, 0)
+	var total uint32
+	for d := o.clientHead.Next(); d != &o.clientHead; d = d.Next() {
+		client := castDlistClient(d)
+		if client.GetState() != state {
+			continue
+		}
+		if total >= offset && uint32(len(r)) < count {
+			r = append(r, client.Mac)
+		}
+		total++
+	}
+	return r, total
+}
+
+type (
+	ApiClientStateCntHandler struct{}
+	ApiClientStateCntResult  struct {
+		Total CClientStateCnt `json:"total"`
+		Ns    []CNsStateCnt   `json:"ns"`
+	}
+
+	ApiClientStateListHandler struct{}
+	ApiClientStateListParams  struct {
+		State  string `json:"state" validate:"required"`
+		Offset uint32 `json:"offset"`
+		Count  uint32 `json:"count" validate:"required,gt=0,lte=1000"`
+	}
+	ApiClientStateListResult struct {
+		Total   uint32   `json:"total"` // Clients in the state, in the namespace
+		Clients []MACKey `json:"clients"`
+	}
+)
+
+func (h ApiClientStateCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var res ApiClientStateCntResult
+	tctx := ctx.(*CThreadCtx)
+
+	res.Total = newClientStateCnt()
+	res.Ns = make([]CNsStateCnt, 0)
+	for d := tctx.nsHead.Next(); d != &tctx.nsHead; d = d.Next() {
+		ns := castDlistNSCtx(d)
+		var nsCnt CNsStateCnt
+		ns.Key.GetJson(&nsCnt.Tun)
+		nsCnt.States = ns.GetStateCnt()
+		for n, v := range nsCnt.States {
+			res.Total[n] += v
+		}
+		res.Ns = append(res.Ns, nsCnt)
+	}
+	return &res, nil
+}
+
+func (h ApiClientStateListHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiClientStateListParams
+	var res ApiClientStateListResult
+	tctx := ctx.(*CThreadCtx)
+
+	ns, err := tctx.GetNsRpc(params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	state, ok := clientStateByName(p.State)
+	if !ok {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: "unknown client state " + p.State,
+		}
+	}
+	res.Clients, res.Total = ns.GetClientsByState(state, p.Offset, p.Count)
+	return &res, nil
+}
+
+func init() {
+	RegisterCB("ctx_client_state_cnt", ApiClientStateCntHandler{}, false)
+	RegisterCB("ctx_client_state_list", ApiClientStateListHandler{}, false)
+}
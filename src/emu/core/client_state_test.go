@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/intel-go/fastjson"
+)
+
+func TestClientState(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	clients := addRampTestClients(t, tctx, ns, 0, 6)
+	if clients[0].GetState() != ClientStateReady {
+		t.Fatalf("client without milestones should be ready, state %v", clients[0].GetState())
+	}
+
+	clients[1].RequireMilestone(CLIENT_MS_L2_AUTH | CLIENT_MS_ADDRESS)
+	clients[2].RequireMilestone(CLIENT_MS_L2_AUTH | CLIENT_MS_ADDRESS)
+	clients[2].ReachMilestone(CLIENT_MS_L2_AUTH)
+	clients[3].DgIpv4 = Ipv4Key{16, 0, 0, 254}
+	clients[4].DgIpv4 = Ipv4Key{16, 0, 0, 254}
+	clients[4].DGW = &CClientDg{IpdgResolved: true}
+	clients[5].active = false
+	states := []ClientState{ClientStateReady, ClientStateL2Auth, ClientStateAddress, ClientStateGateway,
+		ClientStateReady, ClientStateInactive}
+	for i, c := range clients {
+		if c.GetState() != states[i] {
+			t.Fatalf("bad state of client %v: %v, expected %v", i, c.GetState(), states[i])
+		}
+	}
+
+	clients[2].ReachMilestone(CLIENT_MS_ADDRESS)
+	clients[2].ClearMilestone(CLIENT_MS_L2_AUTH)
+	if clients[2].GetState() != ClientStateL2Auth {
+		t.Fatalf("client that lost a milestone should wait for it, state %v", clients[2].GetState())
+	}
+
+	// the milestone is required as long as one of the plugins that required it is not removed
+	clients[1].RequireMilestone(CLIENT_MS_ADDRESS)
+	clients[1].ReleaseMilestone(CLIENT_MS_L2_AUTH | CLIENT_MS_ADDRESS)
+	if clients[1].GetState() != ClientStateAddress {
+		t.Fatalf("client should wait for a milestone that is still required, state %v", clients[1].GetState())
+	}
+	clients[1].ReleaseMilestone(CLIENT_MS_ADDRESS)
+	if clients[1].GetState() != ClientStateReady {
+		t.Fatalf("client without required milestones should be ready, state %v", clients[1].GetState())
+	}
+	clients[1].RequireMilestone(CLIENT_MS_L2_AUTH | CLIENT_MS_ADDRESS)
+
+	res, rpcErr := ApiClientStateCntHandler{}.ServeJSONRPC(tctx, nil)
+	if rpcErr != nil {
+		t.Fatalf("state cnt failed: %v", rpcErr.Message)
+	}
+	cnt := res.(*ApiClientStateCntResult)
+	if len(cnt.Ns) != 1 || cnt.Total["ready"] != 2 || cnt.Total["l2_auth"] != 2 || cnt.Total["address"] != 0 ||
+		cnt.Total["gateway"] != 1 || cnt.Total["inactive"] != 1 || cnt.Ns[0].Tun.Vport != 1 {
+		t.Fatalf("bad state cnt %+v", cnt)
+	}
+
+	params := fastjson.RawMessage(`{"tun": {"vport": 1}, "state": "l2_auth", "offset": 1, "count": 10}`)
+	res, rpcErr = ApiClientStateListHandler{}.ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("state list failed: %v", rpcErr.Message)
+	}
+	list := res.(*ApiClientStateListResult)
+	if list.Total != 2 || len(list.Clients) != 1 || list.Clients[0] != clients[2].Mac {
+		t.Fatalf("bad state list %+v", list)
+	}
+
+	params = fastjson.RawMessage(`{"tun": {"vport": 1}, "state": "stuck", "count": 10}`)
+	if _, rpcErr = (ApiClientStateListHandler{}).ServeJSONRPC(tctx, &params); rpcErr == nil {
+		t.Fatalf("list of unknown state should fail")
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Counter history.
+
+The sampler samples the counters of selected sources every interval into a ring of a limited depth, so the rate
+of a counter can be returned without polling and diffing by the client. A source is one of:
+
+	{}                                  thread counters (ctx_cnt)
+	{"plugin": "p"}                     thread plugin counters
+	{"tun": {..}}                       namespace counters
+	{"tun": {..}, "plugin": "p"}        namespace plugin counters
+	{"tun": {..}, "mac": [..], "plugin": "p"}  client plugin counters
+
+A counter of a source is identified by its path, "<db>.<counter>" e.g. "dhcp.pktRxAck". The source is looked up on
+each sample, a sample is skipped while the namespace, client or plugin does not exist. A counter that shows up
+after the first sample has its own count of samples, its rate is known from its second sample.
+
+An alarm can be set on an error counter (ScERROR), it is raised when the rate of the counter in the last interval
+is above the threshold and cleared when it is back below it. A change of an alarm is published as an event.
+*/
+
+import (
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	cntHistoryDefInterval = 1.0 // sec
+	cntHistoryDefDepth    = 60  // samples
+	cntHistoryMaxSources  = 256
+
+	EVENT_COUNTER_ALARM = "counter.alarm" // published when an alarm is raised or cleared
+)
+
+// CCntSourceJson selects the counters to sample.
+type CCntSourceJson struct {
+	Tun    *CTunnelDataJson `json:"tun"`
+	Mac    *MACKey          `json:"mac"`
+	Plugin string           `json:"plugin"`
+}
+
+// CCntHistoryCfg is the configuration of the sampler.
+type CCntHistoryCfg struct {
+	Interval float64 `json:"interval" validate:"gt=0"`       // Sec between samples
+	Depth    uint32  `json:"depth" validate:"gt=1,lte=3600"` // Samples to keep
+}
+
+// CCntSample is a value of a counter at a time.
+type CCntSample struct {
+	Ts  float64 `json:"ts"` // Sec, by the timer of the thread
+	Val float64 `json:"val"`
+}
+
+// CCntAlarmInfo is the state of an alarm.
+type CCntAlarmInfo struct {
+	Id        uint32  `json:"id"` // Id of the source
+	Path      string  `json:"path"`
+	Threshold float64 `json:"threshold"` // Rate per sec
+	Active    bool    `json:"active"`
+	Raised    uint64  `json:"raised"` // Times that the alarm was raised
+	Rate      float64 `json:"rate"`   // Rate of the last interval
+}
+
+// cntAlarm is an alarm on the rate of an error counter.
+type cntAlarm struct {
+	threshold float64
+	active    bool
+	raised    uint64
+	rate      float64
+}
+
+// cntSource is a source of counters and their ring of samples.
+type cntSource struct {
+	id      uint32
+	sel     CCntSourceJson
+	key     CTunnelKey
+	ts      []float64            // ring of times of the samples
+	vals    map[string][]float64 // ring of values by the path of the counter
+	valCnt  map[string]int       // samples in the ring of each path
+	head    int                  // index of the next sample
+	cnt     int                  // samples in the ring
+	missing uint64               // samples that were skipped because the source did not exist
+	alarms  map[string]*cntAlarm
+}
+
+// cntHistory samples the counters of the sources of a thread.
+type cntHistory struct {
+	tctx    *CThreadCtx
+	cfg     CCntHistoryCfg
+	timer   CHTimerObj
+	sources map[uint32]*cntSource
+	nextId  uint32
+}
+
+func (o *cntHistory) init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.cfg = CCntHistoryCfg{Interval: cntHistoryDefInterval, Depth: cntHistoryDefDepth}
+	o.sources = make(map[uint32]*cntSource)
+	o.nextId = 1
+	o.timer.SetCB(o, 0, 0)
+}
+
+// resolve returns the counters of the source, the namespace and client of the source can be nil.
+func (o *cntHistory) resolve(s *cntSource) ([]*CCounterDb, *CNSCtx, *CClient, error) {
+	var ns *CNSCtx
+	var client *CClient
+	plugCtx := o.tctx.PluginCtx
+	if s.sel.Tun != nil {
+		ns = o.tctx.GetNs(&s.key)
+		if ns == nil {
+			return nil, nil, nil, fmt.Errorf("there is no valid namespace for this tunnel")
+		}
+		plugCtx = ns.PluginCtx
+		if s.sel.Mac != nil {
+			client = ns.CLookupByMac(s.sel.Mac)
+			if client == nil {
+				return nil, nil, nil, fmt.Errorf("there is no valid client %v", *s.sel.Mac)
+			}
+			plugCtx = client.PluginCtx
+		}
+	}
+	if s.sel.Plugin == "" {
+		if ns != nil {
+			return []*CCounterDb{ns.cdb}, ns, client, nil
+		}
+		return o.tctx.cdbv.Vec, nil, nil, nil
+	}
+	pl := plugCtx.Get(s.sel.Plugin)
+	if pl == nil {
+		return nil, nil, nil, fmt.Errorf("there is no valid plugin %s", s.sel.Plugin)
+	}
+	i, ok := pl.Ext.(IPluginCounters)
+	if !ok || i.GetCounters() == nil {
+		return nil, nil, nil, fmt.Errorf("plugin %s does not expose counters", s.sel.Plugin)
+	}
+	return i.GetCounters().Vec, ns, client, nil
+}
+
+// findCounter returns the counter of a path.
+func findCounter(dbs []*CCounterDb, path string) *CCounterRec {
+	for _, db := range dbs {
+		for _, rec := range db.Vec {
+			if db.Name+"."+rec.Name == path {
+				return rec
+			}
+		}
+	}
+	return nil
+}
+
+func (o *cntHistory) addSource(sel *CCntSourceJson) (uint32, error) {
+	if sel.Mac != nil && (sel.Tun == nil || sel.Plugin == "") {
+		return 0, fmt.Errorf("a client source requires a tunnel and a plugin")
+	}
+	if len(o.sources) >= cntHistoryMaxSources {
+		return 0, fmt.Errorf("too many sources, max is %d", cntHistoryMaxSources)
+	}
+	s := &cntSource{sel: *sel, alarms: make(map[string]*cntAlarm)}
+	if sel.Tun != nil {
+		s.key.SetJson(sel.Tun)
+	}
+	if _, _, _, err := o.resolve(s); err != nil {
+		return 0, err
+	}
+	s.id = o.nextId
+	o.nextId++
+	o.resetSource(s)
+	o.sources[s.id] = s
+	if !o.timer.IsRunning() {
+		o.startTimer()
+	}
+	return s.id, nil
+}
+
+func (o *cntHistory) resetSource(s *cntSource) {
+	s.ts = make([]float64, o.cfg.Depth)
+	s.vals = make(map[string][]float64)
+	s.valCnt = make(map[string]int)
+	s.head = 0
+	s.cnt = 0
+}
+
+func (o *cntHistory) removeSource(id uint32) error {
+	if _, ok := o.sources[id]; !ok {
+		return fmt.Errorf("source %d does not exist", id)
+	}
+	delete(o.sources, id)
+	if len(o.sources) == 0 && o.timer.IsRunning() {
+		o.tctx.timerctx.Stop(&o.timer)
+	}
+	return nil
+}
+
+// setCfg changes the configuration, the history of all the sources is cleared.
+func (o *cntHistory) setCfg(cfg *CCntHistoryCfg) {
+	o.cfg = *cfg
+	for _, s := range o.sources {
+		o.resetSource(s)
+	}
+	if o.timer.IsRunning() {
+		o.tctx.timerctx.Stop(&o.timer)
+		o.startTimer()
+	}
+}
+
+func (o *cntHistory) startTimer() {
+	ticks := o.tctx.timerctx.DurationToTicks(time.Duration(o.cfg.Interval * float64(time.Second)))
+	if ticks == 0 {
+		ticks = 1
+	}
+	o.tctx.timerctx.StartTicks(&o.timer, ticks)
+}
+
+// OnEvent samples all the sources every interval.
+func (o *cntHistory) OnEvent(a, b interface{}) {
+	ts := o.tctx.timerctx.TicksInSec()
+	for _, s := range o.sources {
+		o.sample(s, ts)
+	}
+	o.startTimer()
+}
+
+func (o *cntHistory) sample(s *cntSource, ts float64) {
+	dbs, ns, client, err := o.resolve(s)
+	if err != nil {
+		s.missing++
+		return
+	}
+	depth := len(s.ts)
+	for _, db := range dbs {
+		db.Preupdate()
+		for _, rec := range db.Vec {
+			val, ok := counterValue(rec.Counter)
+			if !ok {
+				continue
+			}
+			path := db.Name + "." + rec.Name
+			ring, ok := s.vals[path]
+			if !ok {
+				ring = make([]float64, depth)
+				s.vals[path] = ring
+			}
+			ring[s.head] = val
+			if s.valCnt[path] < depth {
+				s.valCnt[path]++
+			}
+		}
+	}
+	s.ts[s.head] = ts
+	s.head = (s.head + 1) % depth
+	if s.cnt < depth {
+		s.cnt++
+	}
+
+	for path, alarm := range s.alarms {
+		rate, ok := s.rate(path, 2)
+		if !ok {
+			continue
+		}
+		alarm.rate = rate
+		active := rate > alarm.threshold
+		if active == alarm.active {
+			continue
+		}
+		alarm.active = active
+		if active {
+			alarm.raised++
+		}
+		o.tctx.PublishEvent(EVENT_COUNTER_ALARM, ns, client, &CCntAlarmInfo{Id: s.id, Path: path,
+			Threshold: alarm.threshold, Active: active, Raised: alarm.raised, Rate: rate})
+	}
+}
+
+// samples returns the last n samples of a path, oldest first.
+func (o *cntSource) samples(path string, n int) []CCntSample {
+	ring, ok := o.vals[path]
+	if !ok {
+		return nil
+	}
+	if n > o.valCnt[path] {
+		n = o.valCnt[path]
+	}
+	depth := len(o.ts)
+	res := make([]CCntSample, 0, n)
+	for i := n; i > 0; i-- {
+		idx := (o.head - i + depth) % depth
+		res = append(res, CCntSample{Ts: o.ts[idx], Val: ring[idx]})
+	}
+	return res
+}
+
+// rate returns the rate per sec of a path over the last n samples. A counter that was cleared is counted from 0.
+func (o *cntSource) rate(path string, n int) (float64, bool) {
+	samples := o.samples(path, n)
+	if len(samples) < 2 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	if last.Ts <= first.Ts {
+		return 0, false
+	}
+	diff := last.Val - first.Val
+	if diff < 0 {
+		diff = last.Val
+	}
+	return diff / (last.Ts - first.Ts), true
+}
+
+type (
+	ApiCntHistorySetHandler    struct{}
+	ApiCntHistoryAddHandler    struct{}
+	ApiCntHistoryRemoveHandler struct{}
+	ApiCntHistoryGetHandler    struct{}
+	ApiCntAlarmSetHandler      struct{}
+	ApiCntAlarmGetHandler      struct{}
+
+	ApiCntHistoryIdParams struct {
+		Id uint32 `json:"id" validate:"required"`
+	}
+
+	ApiCntHistoryGetParams struct {
+		Id      uint32 `json:"id" validate:"required"`
+		Path    string `json:"path" validate:"required"`
+		History bool   `json:"history"` // return the samples
+	}
+
+	ApiCntHistoryGetResult struct {
+		Path     string       `json:"path"`
+		Interval float64      `json:"interval"`
+		Rate     float64      `json:"rate"`     // Per sec, in the last interval
+		AvgRate  float64      `json:"avg_rate"` // Per sec, over all the samples
+		Missing  uint64       `json:"missing"`  // Samples that were skipped, the source did not exist
+		Samples  []CCntSample `json:"samples,omitempty"`
+	}
+
+	ApiCntAlarmSetParams struct {
+		Id        uint32  `json:"id" validate:"required"`
+		Path      string  `json:"path" validate:"required"`
+		Threshold float64 `json:"threshold" validate:"gte=0"` // Rate per sec, 0 removes the alarm
+	}
+)
+
+func (o *cntHistory) getSource(id uint32) (*cntSource, *jsonrpc.Error) {
+	s, ok := o.sources[id]
+	if !ok {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: fmt.Sprintf("source %d does not exist", id),
+		}
+	}
+	return s, nil
+}
+
+func (h ApiCntHistorySetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	cfg := tctx.cntHistory.cfg
+	err := tctx.UnmarshalValidate(*params, &cfg)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	tctx.cntHistory.setCfg(&cfg)
+	return nil, nil
+}
+
+func (h ApiCntHistoryAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var sel CCntSourceJson
+	tctx := ctx.(*CThreadCtx)
+	err := tctx.UnmarshalValidate(*params, &sel)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	id, err := tctx.cntHistory.addSource(&sel)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &ApiCntHistoryIdParams{Id: id}, nil
+}
+
+func (h ApiCntHistoryRemoveHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiCntHistoryIdParams
+	tctx := ctx.(*CThreadCtx)
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err == nil {
+		err = tctx.cntHistory.removeSource(p.Id)
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	return nil, nil
+}
+
+func (h ApiCntHistoryGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiCntHistoryGetParams
+	tctx := ctx.(*CThreadCtx)
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	s, rpcErr := tctx.cntHistory.getSource(p.Id)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if _, ok := s.vals[p.Path]; !ok && s.cnt > 0 {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: fmt.Sprintf("counter %s does not exist", p.Path),
+		}
+	}
+	res := ApiCntHistoryGetResult{Path: p.Path, Interval: tctx.cntHistory.cfg.Interval, Missing: s.missing}
+	res.Rate, _ = s.rate(p.Path, 2)
+	res.AvgRate, _ = s.rate(p.Path, s.cnt)
+	if p.History {
+		res.Samples = s.samples(p.Path, s.cnt)
+	}
+	return &res, nil
+}
+
+func (h ApiCntAlarmSetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiCntAlarmSetParams
+	tctx := ctx.(*CThreadCtx)
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	s, rpcErr := tctx.cntHistory.getSource(p.Id)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.Threshold == 0 {
+		delete(s.alarms, p.Path)
+		return nil, nil
+	}
+	dbs, _, _, err := tctx.cntHistory.resolve(s)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	rec := findCounter(dbs, p.Path)
+	if rec == nil || rec.Info != ScERROR {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: fmt.Sprintf("counter %s is not an error counter", p.Path),
+		}
+	}
+	if alarm, ok := s.alarms[p.Path]; ok {
+		alarm.threshold = p.Threshold
+	} else {
+		s.alarms[p.Path] = &cntAlarm{threshold: p.Threshold}
+	}
+	return nil, nil
+}
+
+func (h ApiCntAlarmGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	res := make([]CCntAlarmInfo, 0)
+	for id := uint32(1); id < tctx.cntHistory.nextId; id++ {
+		s, ok := tctx.cntHistory.sources[id]
+		if !ok {
+			continue
+		}
+		paths := make([]string, 0, len(s.alarms))
+		for path := range s.alarms {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			alarm := s.alarms[path]
+			res = append(res, CCntAlarmInfo{Id: id, Path: path, Threshold: alarm.threshold,
+				Active: alarm.active, Raised: alarm.raised, Rate: alarm.rate})
+		}
+	}
+	return res, nil
+}
+
+func (o *cntHistory) onDelete() {
+	if o.timer.IsRunning() {
+		o.tctx.timerctx.Stop(&o.timer)
+	}
+}
+
+func init() {
+	RegisterCB("ctx_cnt_history_set", ApiCntHistorySetHandler{}, false)
+	RegisterCB("ctx_cnt_history_add", ApiCntHistoryAddHandler{}, false)
+	RegisterCB("ctx_cnt_history_remove", ApiCntHistoryRemoveHandler{}, false)
+	RegisterCB("ctx_cnt_history_get", ApiCntHistoryGetHandler{}, false)
+	RegisterCB("ctx_cnt_alarm_set", ApiCntAlarmSetHandler{}, false)
+	RegisterCB("ctx_cnt_alarm_get", ApiCntAlarmGetHandler{}, false)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+func getCntHistory(t *testing.T, tctx *CThreadCtx, params string) *ApiCntHistoryGetResult {
+	p := fastjson.RawMessage(params)
+	res, err := (ApiCntHistoryGetHandler{}).ServeJSONRPC(tctx, &p)
+	if err != nil {
+		t.Fatalf("history get failed: %v", err.Message)
+	}
+	return res.(*ApiCntHistoryGetResult)
+}
+
+func TestCntHistory(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	params := fastjson.RawMessage(`{"interval": 0.1, "depth": 5}`)
+	if _, err := (ApiCntHistorySetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("history set failed: %v", err.Message)
+	}
+	params = fastjson.RawMessage(`{"tun": {"vport": 1}}`)
+	res, rpcErr := (ApiCntHistoryAddHandler{}).ServeJSONRPC(tctx, &params)
+	if rpcErr != nil {
+		t.Fatalf("history add failed: %v", rpcErr.Message)
+	}
+	if res.(*ApiCntHistoryIdParams).Id != 1 {
+		t.Fatalf("bad id of the source %+v", res)
+	}
+
+	params = fastjson.RawMessage(`{"id": 1, "path": "ns.addClient", "threshold": 1}`)
+	if _, rpcErr = (ApiCntAlarmSetHandler{}).ServeJSONRPC(tctx, &params); rpcErr == nil {
+		t.Fatalf("alarm on a counter that is not an error should fail")
+	}
+	// an alarm on the half of the rate of the counter
+	threshold := 0.5 / tctx.timerctx.TickDuration.Seconds()
+	params = fastjson.RawMessage(`{"id": 1, "path": "ns.errRemoveMactbl", "threshold": ` +
+		fmt.Sprintf("%v", threshold) + `}`)
+	if _, rpcErr = (ApiCntAlarmSetHandler{}).ServeJSONRPC(tctx, &params); rpcErr != nil {
+		t.Fatalf("alarm set failed: %v", rpcErr.Message)
+	}
+
+	// the counter is incremented every tick
+	ticks := tctx.timerctx.DurationToTicks(time.Second)
+	for i := uint32(0); i < ticks; i++ {
+		ns.stats.errRemoveMactbl++
+		tctx.HandleMainTimerTicks()
+	}
+	expRate := 1 / tctx.timerctx.TickDuration.Seconds()
+	hist := getCntHistory(t, tctx, `{"id": 1, "path": "ns.errRemoveMactbl", "history": true}`)
+	if math.Abs(hist.Rate-expRate) > 0.01 || math.Abs(hist.AvgRate-expRate) > 0.01 {
+		t.Fatalf("bad rate %+v, expected %v", hist, expRate)
+	}
+	if len(hist.Samples) != 5 || hist.Samples[0].Ts >= hist.Samples[4].Ts || hist.Samples[0].Val >= hist.Samples[4].Val {
+		t.Fatalf("bad history %+v", hist.Samples)
+	}
+	alarms, _ := (ApiCntAlarmGetHandler{}).ServeJSONRPC(tctx, nil)
+	if a := alarms.([]CCntAlarmInfo); len(a) != 1 || !a[0].Active || a[0].Raised != 1 {
+		t.Fatalf("alarm should be raised %+v", a)
+	}
+
+	// the counter stops, the rate of the last interval is 0 and the alarm is cleared
+	runTicks(tctx, 300*time.Millisecond)
+	hist = getCntHistory(t, tctx, `{"id": 1, "path": "ns.errRemoveMactbl"}`)
+	if hist.Rate != 0 || hist.AvgRate == 0 || hist.Samples != nil {
+		t.Fatalf("bad rate after the counter stopped %+v", hist)
+	}
+	alarms, _ = (ApiCntAlarmGetHandler{}).ServeJSONRPC(tctx, nil)
+	if a := alarms.([]CCntAlarmInfo); len(a) != 1 || a[0].Active || a[0].Raised != 1 {
+		t.Fatalf("alarm should be cleared %+v", a)
+	}
+
+	// a counter that shows up later has no rate until its second sample
+	s := tctx.cntHistory.sources[1]
+	delete(s.vals, "ns.errRemoveMactbl")
+	delete(s.valCnt, "ns.errRemoveMactbl")
+	runTicks(tctx, 100*time.Millisecond)
+	hist = getCntHistory(t, tctx, `{"id": 1, "path": "ns.errRemoveMactbl", "history": true}`)
+	if hist.Rate != 0 || hist.AvgRate != 0 || len(hist.Samples) != 1 {
+		t.Fatalf("bad rate of a new counter %+v", hist)
+	}
+	alarms, _ = (ApiCntAlarmGetHandler{}).ServeJSONRPC(tctx, nil)
+	if a := alarms.([]CCntAlarmInfo); len(a) != 1 || a[0].Active || a[0].Raised != 1 {
+		t.Fatalf("alarm should not be raised by a new counter %+v", a)
+	}
+
+	params = fastjson.RawMessage(`{"tun": {"vport": 1}, "mac": [0, 0, 1, 0, 0, 1]}`)
+	if _, rpcErr = (ApiCntHistoryAddHandler{}).ServeJSONRPC(tctx, &params); rpcErr == nil {
+		t.Fatalf("client source without a plugin should fail")
+	}
+	params = fastjson.RawMessage(`{"id": 1}`)
+	if _, rpcErr = (ApiCntHistoryRemoveHandler{}).ServeJSONRPC(tctx, &params); rpcErr != nil {
+		t.Fatalf("history remove failed: %v", rpcErr.Message)
+	}
+	if tctx.cntHistory.timer.IsRunning() {
+		t.Fatalf("timer should stop without sources")
+	}
+}
@@ -31,6 +31,7 @@ type CCounterRec struct {
 	Unit     string      `json:"unit"`
 	DumpZero bool        `json:"zero"`
 	Info     uint8       `json:"info"` // see scINFO,scWARNING,scERROR
+	Gauge    bool        `json:"-"`    // the value goes up and down, else it only grows
 }
 
 func (o *CCounterRec) IsValid() bool {
@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Event publisher.
+
+Events that the controller may want to react on (DHCP lease acquired/lost, dot1x success/failure, DG resolved,
+TCP socket errors, ping done ..) are published on a ZMQ PUB socket, so a controller does not have to poll the
+counters. Each event is a message of two frames, the topic and the JSON of the event:
+
+	"dhcp.lease_acquired"
+	{"topic": "dhcp.lease_acquired", "ts": 12.5, "tun": {"vport": 1 ..}, "mac": [0, 0, 1, 0, 0, 1], "data": {..}}
+
+A subscriber filters the events by the prefix of the topic (e.g. "dhcp." or "dot1x.failure"). The topics can be
+filtered also by the server (ctx_event_set), events that are not published are not encoded at all.
+The publisher is disabled until it is bound to a port, by the -e argument or by ctx_event_set.
+*/
+
+import (
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// Topics of the events that are published by the core.
+const (
+	EVENT_CLIENT_DG_RESOLVED = "client.dg_resolved"
+)
+
+// CEvent is an event that is published to the subscribers.
+type CEvent struct {
+	Topic string           `json:"topic"`
+	Ts    float64          `json:"ts"` // Time of the event in sec, by the timer of the thread
+	Tun   *CTunnelDataJson `json:"tun,omitempty"`
+	Mac   *MACKey          `json:"mac,omitempty"`
+	Data  interface{}      `json:"data,omitempty"`
+}
+
+// CEventPubInfo is the state of the publisher.
+type CEventPubInfo struct {
+	Port      uint16   `json:"port"`      // 0 if the publisher is not bound
+	Topics    []string `json:"topics"`    // Prefixes of the topics to publish, empty for all
+	Published uint64   `json:"published"` // Events that were sent
+	Dropped   uint64   `json:"dropped"`   // Events that failed to be encoded or sent
+}
+
+// eventPub publishes the events of a thread on a ZMQ PUB socket.
+type eventPub struct {
+	tctx      *CThreadCtx
+	socket    *zmq.Socket
+	port      uint16
+	topics    []string
+	published uint64
+	dropped   uint64
+}
+
+// bind creates the PUB socket, the publisher can be bound only once.
+func (o *eventPub) bind(tctx *CThreadCtx, port uint16) error {
+	if o.socket != nil {
+		if o.port == port {
+			return nil
+		}
+		return fmt.Errorf("event publisher is already bound to port %d", o.port)
+	}
+	socket, err := tctx.rpc.ctx.NewSocket(zmq.PUB)
+	if err != nil {
+		return err
+	}
+	// don't block the termination of the context on events that were not sent
+	socket.SetLinger(0)
+	if err = socket.Bind(fmt.Sprintf("tcp://*:%d", port)); err != nil {
+		socket.Close()
+		return fmt.Errorf("failed to bind event publisher - %v", err.Error())
+	}
+	o.tctx = tctx
+	o.socket = socket
+	o.port = port
+	return nil
+}
+
+// isEnabled returns true if events of the topic should be published.
+func (o *eventPub) isEnabled(topic string) bool {
+	if o.socket == nil {
+		return false
+	}
+	if len(o.topics) == 0 {
+		return true
+	}
+	for _, t := range o.topics {
+		if strings.HasPrefix(topic, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *eventPub) publish(ev *CEvent) {
+	buf, err := fastjson.Marshal(ev)
+	if err != nil {
+		o.dropped++
+		return
+	}
+	// a PUB socket drops the message when the queue of a subscriber is full, it never blocks
+	if _, err = o.socket.SendMessageDontwait(ev.Topic, buf); err != nil {
+		o.dropped++
+		return
+	}
+	o.published++
+}
+
+func (o *eventPub) getInfo() *CEventPubInfo {
+	topics := o.topics
+	if topics == nil {
+		topics = []string{}
+	}
+	return &CEventPubInfo{Port: o.port, Topics: topics, Published: o.published, Dropped: o.dropped}
+}
+
+func (o *eventPub) onDelete() {
+	if o.socket != nil {
+		o.socket.Close()
+		o.socket = nil
+	}
+}
+
+// StartEventPub binds the event publisher to a port.
+func (o *CThreadCtx) StartEventPub(port uint16) error {
+	return o.events.bind(o, port)
+}
+
+// IsEventEnabled returns true if events of the topic are published. Use it to avoid building the data of an
+// event that is not published.
+func (o *CThreadCtx) IsEventEnabled(topic string) bool {
+	return o.events.isEnabled(topic)
+}
+
+// PublishEvent publishes an event of the namespace and the client, both can be nil. The data is encoded as JSON.
+func (o *CThreadCtx) PublishEvent(topic string, ns *CNSCtx, client *CClient, data interface{}) {
+	if !o.events.isEnabled(topic) {
+		return
+	}
+	ev := CEvent{Topic: topic, Ts: o.timerctx.TicksInSec(), Data: data}
+	if client != nil {
+		ns = client.Ns
+		mac := client.Mac
+		ev.Mac = &mac
+	}
+	if ns != nil {
+		var tun CTunnelDataJson
+		ns.Key.GetJson(&tun)
+		ev.Tun = &tun
+	}
+	o.events.publish(&ev)
+}
+
+// PublishEvent publishes an event of the client.
+func (o *CClient) PublishEvent(topic string, data interface{}) {
+	o.Ns.ThreadCtx.PublishEvent(topic, nil, o, data)
+}
+
+type (
+	ApiEventSetHandler struct{}
+	ApiEventSetParams  struct {
+		Port   uint16    `json:"port"`   // Bind the publisher if it is not bound
+		Topics *[]string `json:"topics"` // Prefixes of the topics to publish, empty for all
+	}
+
+	ApiEventGetHandler struct{}
+)
+
+func (h ApiEventSetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiEventSetParams
+	tctx := ctx.(*CThreadCtx)
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	if p.Port != 0 {
+		if err = tctx.StartEventPub(p.Port); err != nil {
+			return nil, &jsonrpc.Error{
+				Code:    jsonrpc.ErrorCodeInternal,
+				Message: err.Error(),
+			}
+		}
+	}
+	if p.Topics != nil {
+		tctx.events.topics = *p.Topics
+	}
+	return nil, nil
+}
+
+func (h ApiEventGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	return tctx.events.getInfo(), nil
+}
+
+func init() {
+	RegisterCB("ctx_event_set", ApiEventSetHandler{}, false)
+	RegisterCB("ctx_event_get", ApiEventGetHandler{}, false)
+}
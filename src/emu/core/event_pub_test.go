@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+	zmq "github.com/pebbe/zmq4"
+)
+
+func TestEventPub(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	client := addRampTestClients(t, tctx, ns, 0, 1)[0]
+
+	if tctx.IsEventEnabled("client.test") {
+		t.Fatalf("events should be disabled before the publisher is bound")
+	}
+	params := fastjson.RawMessage(`{"port": 4519}`)
+	if _, err := (ApiEventSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("event set failed: %v", err.Message)
+	}
+
+	sub, err := tctx.rpc.ctx.NewSocket(zmq.SUB)
+	if err != nil {
+		t.Fatalf("failed creating subscriber %v", err)
+	}
+	defer sub.Close()
+	sub.SetLinger(0)
+	sub.SetRcvtimeo(time.Second)
+	sub.SetSubscribe("client.")
+	if err = sub.Connect("tcp://127.0.0.1:4519"); err != nil {
+		t.Fatalf("failed connecting subscriber %v", err)
+	}
+	// let the subscription reach the publisher
+	time.Sleep(200 * time.Millisecond)
+
+	tctx.PublishEvent("dhcp.test", nil, client, nil)
+	client.PublishEvent("client.test", map[string]int{"value": 5})
+	msg, err := sub.RecvMessageBytes(0)
+	if err != nil || len(msg) != 2 || string(msg[0]) != "client.test" {
+		t.Fatalf("bad event %q %v", msg, err)
+	}
+	var ev struct {
+		Topic string          `json:"topic"`
+		Tun   CTunnelDataJson `json:"tun"`
+		Mac   MACKey          `json:"mac"`
+		Data  map[string]int  `json:"data"`
+	}
+	if err = json.Unmarshal(msg[1], &ev); err != nil {
+		t.Fatalf("bad event json %v", err)
+	}
+	if ev.Topic != "client.test" || ev.Tun.Vport != 1 || ev.Mac != client.Mac || ev.Data["value"] != 5 {
+		t.Fatalf("bad event %+v", ev)
+	}
+
+	params = fastjson.RawMessage(`{"topics": ["dhcp."]}`)
+	if _, err := (ApiEventSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("event set failed: %v", err.Message)
+	}
+	if tctx.IsEventEnabled("client.test") || !tctx.IsEventEnabled("dhcp.lease_acquired") {
+		t.Fatalf("events should be filtered by the topics")
+	}
+	res, _ := (ApiEventGetHandler{}).ServeJSONRPC(tctx, nil)
+	info := res.(*CEventPubInfo)
+	if info.Port != 4519 || info.Published != 2 || info.Dropped != 0 || len(info.Topics) != 1 {
+		t.Fatalf("bad event publisher info %+v", info)
+	}
+
+	params = fastjson.RawMessage(`{"port": 4520}`)
+	if _, err := (ApiEventSetHandler{}).ServeJSONRPC(tctx, &params); err == nil {
+		t.Fatalf("publisher should not be bound twice")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/*
+Prometheus metrics exporter.
+
+An optional HTTP server exposes the counters on /metrics in the Prometheus text format:
+
+  - thread counters (ctx_cnt), scope="thread"
+  - namespace counters, scope="ns"
+  - namespace plugin counters, scope="ns" and plugin=<name>
+  - client plugin counters, scope="client" and plugin=<name>, summed over the clients of each namespace to keep
+    the cardinality low
+  - CPU and memory of the process, by the ResourceMonitor
+
+The counters of a namespace are labeled by the tunnel, vport and vlans. A plugin exposes its counters by
+implementing IPluginCounters.
+
+The counters are read by the main thread only, the HTTP server sends the request on a channel and waits for the
+response, the same as the RPC server. A scrape walks all the clients of all the namespaces, so with many clients it
+holds the main thread for a while, the scrape interval should be set accordingly.
+
+A counter is typed as a gauge if its CCounterRec is a Gauge, else as a counter.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "emu_"
+
+// metricsUnsupported is the names of the counters with an unsupported type, each one is logged once.
+var metricsUnsupported sync.Map
+
+// IPluginCounters is implemented by plugins that expose their counters in the metrics.
+type IPluginCounters interface {
+	GetCounters() *CCounterDbVec
+}
+
+// metricSample is a value of a metric with its labels.
+type metricSample struct {
+	labels string
+	val    float64
+}
+
+// metricFamily is a metric and all its samples.
+type metricFamily struct {
+	help    string
+	typ     string // counter or gauge
+	samples []metricSample
+	index   map[string]int // labels to the sample, for summing
+}
+
+// metricsBuilder collects the samples of all the metrics for one scrape.
+type metricsBuilder struct {
+	families map[string]*metricFamily
+}
+
+// metricsServer serves /metrics, the response is built by the main thread.
+type metricsServer struct {
+	srv    *http.Server
+	port   uint16
+	chReq  chan chan []byte
+	resMon *ResourceMonitor // own monitor, CPU is measured between scrapes
+}
+
+// metricName returns a valid metric name, invalid chars are replaced by '_'.
+func metricName(parts ...string) string {
+	var b strings.Builder
+	b.WriteString(metricsPrefix)
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		for _, c := range p {
+			if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+				b.WriteRune(c)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return b.String()
+}
+
+// counterValue returns the value of a counter, false if the type is not supported.
+func counterValue(cnt interface{}) (float64, bool) {
+	switch v := cnt.(type) {
+	case *uint:
+		return float64(*v), true
+	case *uint8:
+		return float64(*v), true
+	case *uint16:
+		return float64(*v), true
+	case *uint32:
+		return float64(*v), true
+	case *uint64:
+		return float64(*v), true
+	case *int:
+		return float64(*v), true
+	case *int8:
+		return float64(*v), true
+	case *int16:
+		return float64(*v), true
+	case *int32:
+		return float64(*v), true
+	case *int64:
+		return float64(*v), true
+	case *float32:
+		return float64(*v), true
+	case *float64:
+		return *v, true
+	}
+	return 0, false
+}
+
+func counterSeverity(info uint8) string {
+	switch info {
+	case ScERROR:
+		return "error"
+	case ScWARNING:
+		return "warning"
+	}
+	return "info"
+}
+
+// nsLabels returns the labels of the tunnel of a namespace.
+func nsLabels(ns *CNSCtx) string {
+	var tun CTunnelDataJson
+	ns.Key.GetJson(&tun)
+	vlans := make([]string, 0, len(tun.Tci))
+	for _, tci := range tun.Tci {
+		if tci != 0 {
+			vlans = append(vlans, strconv.Itoa(int(tci&0xfff)))
+		}
+	}
+	return fmt.Sprintf(`vport="%d",vlans="%s"`, tun.Vport, strings.Join(vlans, "."))
+}
+
+func newMetricsBuilder() *metricsBuilder {
+	return &metricsBuilder{families: make(map[string]*metricFamily)}
+}
+
+// add adds a sample, samples with the same name and labels are summed.
+func (o *metricsBuilder) add(name, help, typ, labels string, val float64) {
+	f, ok := o.families[name]
+	if !ok {
+		f = &metricFamily{help: help, typ: typ, index: make(map[string]int)}
+		o.families[name] = f
+	}
+	if i, ok := f.index[labels]; ok {
+		f.samples[i].val += val
+		return
+	}
+	f.index[labels] = len(f.samples)
+	f.samples = append(f.samples, metricSample{labels: labels, val: val})
+}
+
+// addDb adds all the counters of a db, prefix is the scope and the plugin that the db belongs to.
+func (o *metricsBuilder) addDb(prefix string, db *CCounterDb, labels string) {
+	db.Preupdate()
+	for _, rec := range db.Vec {
+		name := metricName(prefix, db.Name, rec.Name)
+		val, ok := counterValue(rec.Counter)
+		if !ok {
+			if _, logged := metricsUnsupported.LoadOrStore(name, true); !logged {
+				log.Printf("metrics: counter %s of type %T is not supported, it is skipped\n", name, rec.Counter)
+			}
+			continue
+		}
+		typ := "counter"
+		if rec.Gauge {
+			typ = "gauge"
+		}
+		o.add(name, rec.Help, typ, labels+`,severity="`+counterSeverity(rec.Info)+`"`, val)
+	}
+}
+
+func (o *metricsBuilder) addDbVec(prefix string, vec *CCounterDbVec, labels string) {
+	for _, db := range vec.Vec {
+		o.addDb(prefix, db, labels)
+	}
+}
+
+// addPlugins adds the counters of the plugins that expose them.
+func (o *metricsBuilder) addPlugins(ctx *PluginCtx, scope, labels string) {
+	for name, pl := range ctx.mapPlugins {
+		if i, ok := pl.Ext.(IPluginCounters); ok {
+			if vec := i.GetCounters(); vec != nil {
+				o.addDbVec(scope, vec, labels+`,plugin="`+name+`"`)
+			}
+		}
+	}
+}
+
+// write writes the metrics in the Prometheus text format, sorted by name.
+func (o *metricsBuilder) write(b *bytes.Buffer) {
+	names := make([]string, 0, len(o.families))
+	for name := range o.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := o.families[name]
+		help := strings.ReplaceAll(strings.ReplaceAll(f.help, `\`, `\\`), "\n", `\n`)
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintf(b, "%s{%s} %s\n", name, s.labels, strconv.FormatFloat(s.val, 'g', -1, 64))
+		}
+	}
+}
+
+// buildMetrics returns all the metrics of the thread.
+func (o *CThreadCtx) buildMetrics(resMon *ResourceMonitor) []byte {
+	m := newMetricsBuilder()
+	thread := `scope="thread"`
+	m.addDbVec("ctx", o.cdbv, thread)
+	m.addPlugins(o.PluginCtx, "thread", thread)
+
+	for d := o.nsHead.Next(); d != &o.nsHead; d = d.Next() {
+		ns := castDlistNSCtx(d)
+		labels := nsLabels(ns)
+		m.addDb("ns", ns.cdb, labels+`,scope="ns"`)
+		m.addPlugins(ns.PluginCtx, "ns", labels+`,scope="ns"`)
+		for c := ns.clientHead.Next(); c != &ns.clientHead; c = c.Next() {
+			m.addPlugins(castDlistClient(c).PluginCtx, "client", labels+`,scope="client"`)
+		}
+	}
+
+	if resMon != nil && resMon.Update(false) == nil {
+		m.addDbVec("process", resMon.GetCountersDbVec(), thread)
+		resMon.Update(true) // the CPU of the next scrape is since this one
+	}
+
+	var b bytes.Buffer
+	m.write(&b)
+	return b.Bytes()
+}
+
+// start starts the HTTP server in its own goroutine.
+func (o *metricsServer) start(port uint16) error {
+	if o.srv != nil {
+		return fmt.Errorf("metrics server is already running on port %d", o.port)
+	}
+	resMon := new(ResourceMonitor)
+	if err := resMon.Init(); err == nil {
+		o.resMon = resMon
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to create metrics server - %v", err.Error())
+	}
+	o.port = port
+	o.chReq = make(chan chan []byte)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", o.serveHTTP)
+	srv := &http.Server{Handler: mux}
+	o.srv = srv
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server failed - %v\n", err)
+		}
+	}()
+	return nil
+}
+
+func (o *metricsServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 1)
+	select {
+	case o.chReq <- ch:
+	case <-time.After(5 * time.Second):
+		http.Error(w, "main thread is busy", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(<-ch)
+}
+
+// GetC returns the channel of the requests, nil if the server is not running.
+func (o *metricsServer) GetC() chan chan []byte {
+	return o.chReq
+}
+
+func (o *metricsServer) onDelete() {
+	if o.srv != nil {
+		o.srv.Close()
+		o.srv = nil
+	}
+}
+
+// StartMetrics starts the HTTP server of the metrics on a port.
+func (o *CThreadCtx) StartMetrics(port uint16) error {
+	return o.metrics.start(port)
+}
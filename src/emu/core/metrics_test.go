@@ -0,0 +1,127 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const metricsTestPlug = "metrics_test"
+
+// metricsTestPlugin is a plugin with one counter for each client.
+type metricsTestPlugin struct {
+	PluginBase
+	pkts  uint64
+	drops int16
+	cdbv  *CCounterDbVec
+}
+
+func (o *metricsTestPlugin) OnEvent(msg string, a, b interface{}) {}
+func (o *metricsTestPlugin) OnRemove(ctx *PluginCtx)              {}
+
+func (o *metricsTestPlugin) GetCounters() *CCounterDbVec {
+	return o.cdbv
+}
+
+type metricsTestReg struct{}
+
+func (o metricsTestReg) NewPlugin(ctx *PluginCtx, initJson []byte) (*PluginBase, error) {
+	o1 := new(metricsTestPlugin)
+	o1.InitPluginBase(ctx, o1)
+	o1.RegisterEvents(ctx, []string{}, o1)
+	o1.pkts = 10
+	o1.drops = 2
+	db := NewCCounterDb("mtest")
+	db.Add(&CCounterRec{
+		Counter:  &o1.pkts,
+		Name:     "pktTx",
+		Help:     "tx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+	db.Add(&CCounterRec{
+		Counter:  &o1.drops,
+		Name:     "pktDrop",
+		Help:     "dropped packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+	o1.cdbv = NewCCounterDbVec("mtest")
+	o1.cdbv.Add(db)
+	return &o1.PluginBase, nil
+}
+
+func init() {
+	PluginRegister(metricsTestPlug, PluginRegisterData{Client: metricsTestReg{}, Ns: metricsTestReg{}})
+}
+
+func TestMetrics(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 3, Vlans: [5]uint32{0x81000064, 0x810000c8}})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	clients := addRampTestClients(t, tctx, ns, 0, 3)
+	for _, c := range clients {
+		if err := c.PluginCtx.CreatePlugins([]string{metricsTestPlug}, [][]byte{nil}); err != nil {
+			t.Fatalf("failed creating plugin %v", err)
+		}
+	}
+	ns.PluginCtx.GetOrCreate(metricsTestPlug)
+
+	metrics := string(tctx.buildMetrics(nil))
+	for _, line := range []string{
+		"# TYPE emu_ns_ns_activeClient gauge",
+		`emu_ns_ns_activeClient{vport="3",vlans="100.200",scope="ns",severity="info"} 3`,
+		"# HELP emu_client_mtest_pktTx tx packets",
+		"# TYPE emu_client_mtest_pktTx counter",
+		// summed over the clients of the namespace
+		`emu_client_mtest_pktTx{vport="3",vlans="100.200",scope="client",plugin="metrics_test",severity="error"} 30`,
+		`emu_ns_mtest_pktTx{vport="3",vlans="100.200",scope="ns",plugin="metrics_test",severity="error"} 10`,
+		`emu_client_mtest_pktDrop{vport="3",vlans="100.200",scope="client",plugin="metrics_test",severity="info"} 6`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Fatalf("metrics are missing %q:\n%s", line, metrics)
+		}
+	}
+	if !strings.Contains(metrics, `emu_ctx_`) {
+		t.Fatalf("metrics are missing the thread counters:\n%s", metrics)
+	}
+
+	// a scrape is served by the main thread
+	if err := tctx.StartMetrics(4521); err != nil {
+		t.Fatalf("failed starting metrics server %v", err)
+	}
+	if err := tctx.StartMetrics(4521); err == nil {
+		t.Fatalf("metrics server should not be started twice")
+	}
+	chBody := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:4521/metrics")
+		if err != nil {
+			chBody <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		chBody <- string(body)
+	}()
+	select {
+	case res := <-tctx.metrics.GetC():
+		res <- tctx.buildMetrics(tctx.metrics.resMon)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("scrape did not arrive")
+	}
+	body := <-chBody
+	if !strings.Contains(body, "emu_client_mtest_pktTx") || !strings.Contains(body, "emu_process_Resource_monitor_rss") {
+		t.Fatalf("bad scrape:\n%s", body)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
-	"time"
 	"unsafe"
 )
 
@@ -154,6 +153,7 @@ func newNsStats(o *CNSCtxStats) *CCounterDb {
 		Help:     "active client",
 		Unit:     "ops",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 
 	db.Add(&CCounterRec{
@@ -212,6 +212,8 @@ type CNSCtx struct {
 	iter           DListIterHead
 	cdb            *CCounterDb
 	DefClientPlugs *MapJsonPlugs // Default plugins for each new client
+	seed           *int64        // Seed of rnd, nil if the ns uses the generator of the thread
+	rnd            *rand.Rand
 }
 
 type CNsInfo struct {
@@ -220,6 +222,7 @@ type CNsInfo struct {
 	Tpid          [5]uint16 `json:"tpid"`
 	ActiveClients uint64    `json:"active_clients"`
 	PlugNames     []string  `json:"plug_names"`
+	Seed          *int64    `json:"seed,omitempty"`
 }
 
 // NewNSCtx create new one
@@ -598,6 +601,7 @@ func (o *CNSCtx) GetInfo() *CNsInfo {
 	info.Tpid = d.Tpid
 	info.ActiveClients = o.stats.activeClient
 	info.PlugNames = o.PluginCtx.GetAllPlugNames()
+	info.Seed = o.seed
 	return &info
 }
 
@@ -652,8 +656,8 @@ func (o *CNSCtx) GetL2Header(broadcast bool, next uint16) []byte {
 	return b
 }
 
-// RandMACKey generates a random MAC address optionally using seed as first bytes
-func RandMACKey(seed ...byte) MACKey {
+// RandMACKey generates a random MAC address by rnd, optionally using seed as first bytes
+func RandMACKey(rnd *rand.Rand, seed ...byte) MACKey {
 
 	var token []byte
 	var arr [6]byte
@@ -664,8 +668,7 @@ func RandMACKey(seed ...byte) MACKey {
 		panic("Base seed cannot be higher than 5 bytes!")
 	}
 	token = make([]byte, 6-seedLength)
-	rand.Seed(time.Now().UTC().UnixNano())
-	rand.Read(token)
+	rnd.Read(token)
 
 	copy(arr[0:seedLength], seed[:])
 	copy(arr[seedLength:], token[:6-seedLength])
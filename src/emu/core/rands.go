@@ -5,15 +5,150 @@
 
 package core
 
-import "math/rand"
+/*
+Seedable random generators.
+
+Each thread has a random generator that is seeded by the --seed argument, or by ctx_seed_set. Every int64 is a
+seed, 0 included. Without the argument the thread is seeded by the time, and in simulation by a fixed seed, so the
+simulations are reproducible by default. The seed is printed at start, returned by ctx_seed_get and is recorded at
+the end of the simulation recording, and it replays the run when it is given back to --seed.
+
+A namespace shares the generator of the thread, unless it is added by ctx_add with its own seed:
+
+	{"tunnels": [{"vport": 1, "tci": [1, 0], "tpid": [0x8100, 0], "seed": 1234}]}
+
+The core and the plugins draw the random values from ns.Rand() (or tctx.Rand() for the thread), never from the
+global generator of math/rand, so the same seed and the same sequence of RPCs replay the same run. The API handler
+of the RPC sessions is not part of the run, it is drawn from crypto/rand so it can't be guessed from the seed.
+*/
+
+import (
+	crand "crypto/rand"
+	"external/osamingo/jsonrpc"
+	"math/rand"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+// defSimSeed is the seed of a thread in simulation, the same as the default seed of math/rand.
+const defSimSeed = 1
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 // RandSeq generate a string in length n
-func RandSeq(n int) string {
+func RandSeq(rnd *rand.Rand, n int) string {
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// RandToken generates a string in length n from crypto/rand, it doesn't depend on the seed.
+func RandToken(n int) string {
+	// bytes above the last multiple of len(letters) are dropped, so all the letters are as likely
+	limit := byte(256 - 256%len(letters))
+	b := make([]rune, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := crand.Read(buf); err != nil {
+			// no entropy, fall back to a generator that is not the seeded one
+			return RandSeq(rand.New(rand.NewSource(time.Now().UnixNano())), n)
+		}
+		for _, c := range buf {
+			if c < limit && len(b) < n {
+				b = append(b, letters[int(c)%len(letters)])
+			}
+		}
 	}
 	return string(b)
 }
+
+// initSeed seeds the thread by the time, or by a fixed seed in simulation.
+func (o *CThreadCtx) initSeed() {
+	if o.Simulation {
+		o.SetSeed(defSimSeed)
+	} else {
+		o.SetSeed(time.Now().UnixNano())
+	}
+}
+
+// SetSeed seeds the random generator of the thread and of the namespaces without their own seed. The generator is
+// reseeded in place, the plugins may keep it.
+func (o *CThreadCtx) SetSeed(seed int64) {
+	o.seed = seed
+	if o.rnd == nil {
+		o.rnd = rand.New(rand.NewSource(seed))
+	} else {
+		o.rnd.Seed(seed)
+	}
+}
+
+// GetSeed returns the seed of the thread.
+func (o *CThreadCtx) GetSeed() int64 {
+	return o.seed
+}
+
+// Rand returns the random generator of the thread.
+func (o *CThreadCtx) Rand() *rand.Rand {
+	return o.rnd
+}
+
+// SetSeed gives the namespace its own random generator, so its values don't depend on the other namespaces.
+func (o *CNSCtx) SetSeed(seed int64) {
+	o.seed = &seed
+	o.rnd = rand.New(rand.NewSource(seed))
+}
+
+// GetSeed returns the seed of the namespace, nil if it shares the generator of the thread.
+func (o *CNSCtx) GetSeed() *int64 {
+	return o.seed
+}
+
+// Rand returns the random generator of the namespace.
+func (o *CNSCtx) Rand() *rand.Rand {
+	if o.rnd != nil {
+		return o.rnd
+	}
+	return o.ThreadCtx.rnd
+}
+
+// Rand returns the random generator of the namespace of the client.
+func (o *CClient) Rand() *rand.Rand {
+	return o.Ns.Rand()
+}
+
+type (
+	ApiSeedSetHandler struct{}
+	ApiSeedParams     struct {
+		Seed int64 `json:"seed"`
+	}
+
+	ApiSeedGetHandler struct{}
+)
+
+func (h ApiSeedSetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiSeedParams
+	tctx := ctx.(*CThreadCtx)
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	tctx.SetSeed(p.Seed)
+	return nil, nil
+}
+
+func (h ApiSeedGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	return &ApiSeedParams{Seed: tctx.GetSeed()}, nil
+}
+
+func init() {
+	RegisterCB("ctx_seed_set", ApiSeedSetHandler{}, false)
+	RegisterCB("ctx_seed_get", ApiSeedGetHandler{}, false)
+}
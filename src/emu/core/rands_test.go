@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/intel-go/fastjson"
+)
+
+// drawSeq returns the next values of the generators of the namespaces, one after the other.
+func drawSeq(nss []*CNSCtx, n int) []uint64 {
+	var res []uint64
+	for i := 0; i < n; i++ {
+		for _, ns := range nss {
+			res = append(res, ns.Rand().Uint64())
+		}
+	}
+	return res
+}
+
+func addSeedTestNs(t *testing.T, tctx *CThreadCtx, tunnels string) []*CNSCtx {
+	params := fastjson.RawMessage(`{"tunnels": ` + tunnels + `}`)
+	if _, err := (ApiNsAddHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("ctx_add failed: %v", err.Message)
+	}
+	var nss []*CNSCtx
+	for d := tctx.nsHead.Next(); d != &tctx.nsHead; d = d.Next() {
+		nss = append(nss, castDlistNSCtx(d))
+	}
+	return nss
+}
+
+func TestRandSeed(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	if tctx.GetSeed() != defSimSeed {
+		t.Fatalf("simulation should be seeded by %d, have %d", defSimSeed, tctx.GetSeed())
+	}
+
+	params := fastjson.RawMessage(`{"seed": 1234}`)
+	if _, err := (ApiSeedSetHandler{}).ServeJSONRPC(tctx, &params); err != nil {
+		t.Fatalf("seed set failed: %v", err.Message)
+	}
+	res, _ := (ApiSeedGetHandler{}).ServeJSONRPC(tctx, nil)
+	if res.(*ApiSeedParams).Seed != 1234 {
+		t.Fatalf("bad seed %+v", res)
+	}
+	nss := addSeedTestNs(t, tctx, `[{"vport": 1}, {"vport": 2, "seed": 99}]`)
+	if nss[0].GetSeed() != nil || nss[0].Rand() != tctx.Rand() {
+		t.Fatalf("ns without a seed should share the generator of the thread")
+	}
+	if info := nss[1].GetInfo(); info.Seed == nil || *info.Seed != 99 {
+		t.Fatalf("bad seed of the ns %+v", info)
+	}
+	seq := drawSeq(nss, 10)
+	token := RandSeq(tctx.Rand(), 10)
+
+	// the same seeds replay the same values
+	tctx1 := NewThreadCtx(0, 4522, true, nil)
+	defer tctx1.Delete()
+	tctx1.SetSeed(1234)
+	nss1 := addSeedTestNs(t, tctx1, `[{"vport": 1}, {"vport": 2, "seed": 99}]`)
+	seq1 := drawSeq(nss1, 10)
+	if token1 := RandSeq(tctx1.Rand(), 10); token1 != token {
+		t.Fatalf("tokens differ %v %v", token, token1)
+	}
+	// the API handler doesn't depend on the seed
+	if api, api1 := RandToken(10), RandToken(10); len(api) != 10 || api == api1 {
+		t.Fatalf("bad API tokens %v %v", api, api1)
+	}
+	for i := range seq {
+		if seq[i] != seq1[i] {
+			t.Fatalf("sequences differ at %d: %v %v", i, seq, seq1)
+		}
+	}
+
+	// the ns with its own seed does not depend on the draws of the others
+	tctx2 := NewThreadCtx(0, 4523, true, nil)
+	defer tctx2.Delete()
+	nss2 := addSeedTestNs(t, tctx2, `[{"vport": 2, "seed": 99}]`)
+	for i := 0; i < 10; i++ {
+		if v := nss2[0].Rand().Uint64(); v != seq[2*i+1] {
+			t.Fatalf("draw %d of the ns with a seed differs %v %v", i, v, seq[2*i+1])
+		}
+	}
+}
@@ -118,6 +118,7 @@ func (p *ResourceMonitor) newCountersDb() {
 		Help:     "Elapsed time since reset",
 		Unit:     "milliseconds",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 	p.countersDb.Add(&CCounterRec{
 		Counter:  &p.counters.cpuPercent,
@@ -125,6 +126,7 @@ func (p *ResourceMonitor) newCountersDb() {
 		Help:     "CPU utilization since reset",
 		Unit:     "percent",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     ScINFO})
 	p.countersDb.Add(&CCounterRec{
 		Counter:  &p.counters.rss,
@@ -132,6 +134,7 @@ func (p *ResourceMonitor) newCountersDb() {
 		Help:     "RSS memory consumption",
 		Unit:     "bytes",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 	p.countersDbVec.Add(p.countersDb)
 }
@@ -193,7 +193,7 @@ func (h ApiSyncHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessag
 	api := tctx.rpc.mr.GetAPI()
 	if len(api) == 0 {
 		// generate handler
-		api = RandSeq(10)
+		api = RandToken(10)
 		tctx.apiHandler = api
 		tctx.rpc.mr.SetAPI(api)
 	}
@@ -377,41 +377,58 @@ func (h ApiClientAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawM
 	}
 
 	for _, c := range newc.Clients {
-		client := NewClientCmd(ns, &c)
-
-		err = ns.AddClient(client)
-		if err != nil {
-			return nil, &jsonrpc.Error{
-				Code:    jsonrpc.ErrorCodeInvalidRequest,
-				Message: err.Error(),
-			}
+		if err := addClientCmd(ns, &c); err != nil {
+			return nil, err
 		}
+	}
+	return nil, nil
+}
+
+// addClientCmd creates a client with its plugins and tries to resolve its default gateway.
+func addClientCmd(ns *CNSCtx, c *CClientCmd) *jsonrpc.Error {
+	client := NewClientCmd(ns, c)
+	ramp := &ns.ThreadCtx.ramp
+	if ramp.isEnabled() {
+		// plugins that are created see the client is not active yet
+		client.active = false
+	}
 
-		var plugMap *MapJsonPlugs
-		if c.Plugins == nil {
-			/* client didn't supply plugins, use defaults */
-			plugMap = ns.DefClientPlugs
-		} else {
-			/* client supply plugins, use them */
-			plugMap = c.Plugins
+	err := ns.AddClient(client)
+	if err != nil {
+		return &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
 		}
+	}
 
-		if plugMap != nil {
-			for plName, plData := range *plugMap {
-				err = client.PluginCtx.addPlugin(plName, *plData)
-				if err != nil {
-					return nil, &jsonrpc.Error{
-						Code:    jsonrpc.ErrorCodeInternal,
-						Message: err.Error(),
-					}
+	var plugMap *MapJsonPlugs
+	if c.Plugins == nil {
+		/* client didn't supply plugins, use defaults */
+		plugMap = ns.DefClientPlugs
+	} else {
+		/* client supply plugins, use them */
+		plugMap = c.Plugins
+	}
+
+	if plugMap != nil {
+		for plName, plData := range *plugMap {
+			err = client.PluginCtx.addPlugin(plName, *plData)
+			if err != nil {
+				return &jsonrpc.Error{
+					Code:    jsonrpc.ErrorCodeInternal,
+					Message: err.Error(),
 				}
 			}
 		}
+	}
 
-		// After creating the clients and adding the plugins, we can try to attempt resolving.
-		client.AttemptResolve()
+	if !client.active {
+		ramp.addClient(client)
+		return nil
 	}
-	return nil, nil
+	// After creating the clients and adding the plugins, we can try to attempt resolving.
+	client.AttemptResolve()
+	return nil
 }
 
 func (h ApiClientRemoveHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
@@ -45,6 +45,7 @@ type CTunnelDataJson struct {
 	Tpid    [5]uint16     `json:"tpid"`
 	Tci     [5]uint16     `json:"tci"`
 	Plugins *MapJsonPlugs `json:"plugs"`
+	Seed    *int64        `json:"seed,omitempty"` // Own random generator for the namespace
 }
 
 type RpcCmdTunnel struct {
@@ -197,6 +198,7 @@ func newThreadCtxStats(o *CThreadCtxStats) *CCounterDb {
 		Help:     "active ns",
 		Unit:     "ops",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 
 	return db
@@ -244,6 +246,13 @@ type CThreadCtx struct {
 	kernelMode      bool
 	resourceMonitor *ResourceMonitor
 	lockMainThread  bool
+	bulk            clientBulk    // Bulk client add/remove jobs
+	ramp            clientRamp    // Ramp-up of new clients
+	events          eventPub      // Publisher of events to the controller
+	metrics         metricsServer // Prometheus metrics
+	cntHistory      cntHistory    // Samples of counters, for rates and alarms
+	seed            int64         // Seed of rnd
+	rnd             *rand.Rand    // Random generator of the thread
 }
 
 func NewThreadCtxProxy() *CThreadCtx {
@@ -251,6 +260,7 @@ func NewThreadCtxProxy() *CThreadCtx {
 	o.timerctx = NewTimerCtx(false)
 	o.MPool.Init(mBUFS_CACHE)
 	o.simRecorder = make([]interface{}, 0)
+	o.initSeed()
 
 	/* counters */
 	o.cdbv = NewCCounterDbVec("ctx")
@@ -268,6 +278,7 @@ func NewThreadCtx(Id uint32, rpcPort uint16, simulation bool, simRx *VethIFSim)
 	o.timerctx = NewTimerCtx(simulation)
 	o.portMap = make(MapPortT)
 	o.Simulation = simulation
+	o.initSeed()
 	o.mapNs = make(MapNsT)
 	o.MPool.Init(mBUFS_CACHE)
 	o.rpc.NewZmqRpc(rpcPort)
@@ -288,6 +299,9 @@ func NewThreadCtx(Id uint32, rpcPort uint16, simulation bool, simRx *VethIFSim)
 
 	// shutdown timer
 	o.shutdownTimer.SetCB(&o.shutdownTimerCb, o, 0) // set callback
+	o.bulk.init(o)
+	o.ramp.init(o)
+	o.cntHistory.init(o)
 
 	resMon := new(ResourceMonitor)
 	err := resMon.Init()
@@ -347,7 +361,7 @@ func (o *CThreadCtx) GetRandNumber(min uint32, max uint32) uint32 {
 	if o.Simulation {
 		return (max + min) >> 1
 	} else {
-		return uint32(rand.Intn((int(max - min)))) + min
+		return uint32(o.rnd.Intn((int(max - min)))) + min
 	}
 }
 
@@ -357,6 +371,7 @@ func (o *CThreadCtx) SimRecordExport(filename string) {
 	}
 	o.SimRecordAppend(o.MPool.GetCdb().MarshalValues(false))
 	o.SimRecordAppend(o.Veth.GetCdb().MarshalValues(false))
+	o.SimRecordAppend(map[string]int64{"seed": o.seed}) // to replay the recording
 	buf, err := fastjson.MarshalIndent(o.simRecorder, "", "\t")
 	if err == nil {
 		ioutil.WriteFile(filename, buf, 0644)
@@ -423,6 +438,8 @@ func (o *CThreadCtx) MainLoop() {
 		select {
 		case req := <-o.rpc.GetC():
 			o.rpc.HandleReqToChan(req) // RPC commands
+		case res := <-o.metrics.GetC():
+			res <- o.buildMetrics(o.metrics.resMon) // scrape of the metrics
 		case <-o.timerctx.GetC():
 			o.timerctx.HandleTicks()
 		case msg := <-o.Veth.GetC(): // batch of rx packets
@@ -574,6 +591,20 @@ func (o *CThreadCtx) UnmarshalTunnelsPlugins(data []byte) ([]*MapJsonPlugs, erro
 	return plugs, nil
 }
 
+// UnmarshalTunnelsSeeds returns the seed of each tunnel, nil for a tunnel without a seed.
+func (o *CThreadCtx) UnmarshalTunnelsSeeds(data []byte) ([]*int64, error) {
+	var tuns RpcCmdTunnels
+	err := o.UnmarshalValidate(data, &tuns)
+	if err != nil {
+		return nil, err
+	}
+	seeds := make([]*int64, len(tuns.Tunnels))
+	for i, tun := range tuns.Tunnels {
+		seeds[i] = tun.Seed
+	}
+	return seeds, nil
+}
+
 func (o *CThreadCtx) RemoveNsRpc(params *fastjson.RawMessage) error {
 	var key CTunnelKey
 	err := o.UnmarshalTunnel(*params, &key)
@@ -642,6 +673,11 @@ func (o *CThreadCtx) AddNsRpcSlice(params *fastjson.RawMessage) error {
 		return err
 	}
 
+	seeds, err := o.UnmarshalTunnelsSeeds(*params)
+	if err != nil {
+		return err
+	}
+
 	for i, key := range keys {
 		ns := o.GetNs(&key)
 		if ns != nil {
@@ -650,6 +686,9 @@ func (o *CThreadCtx) AddNsRpcSlice(params *fastjson.RawMessage) error {
 		}
 
 		ns = NewNSCtx(o, &key)
+		if seeds[i] != nil {
+			ns.SetSeed(*seeds[i])
+		}
 		err := o.AddNs(&key, ns)
 		if err != nil {
 			return err
@@ -765,6 +804,11 @@ func (o *CThreadCtx) Delete() {
 	if o.shutdownTimer.IsRunning() {
 		o.timerctx.Stop(&o.shutdownTimer)
 	}
+	o.bulk.onDelete()
+	o.ramp.onDelete()
+	o.cntHistory.onDelete()
+	o.events.onDelete()
+	o.metrics.onDelete()
 	o.rpc.Delete()
 }
 
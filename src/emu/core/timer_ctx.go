@@ -72,6 +72,7 @@ func NewTimerCtx(simulation bool) *TimerCtx {
 		Help:     "active timers",
 		Unit:     "timers",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 	o.Cdb.Add(&CCounterRec{
 		Counter:  &o.Ticks,
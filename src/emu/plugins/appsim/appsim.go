@@ -25,7 +25,6 @@ import (
 	"encoding/base64"
 	"external/osamingo/jsonrpc"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"emu/plugins/transport"
@@ -167,6 +166,12 @@ type AppsimStats struct {
 	eventDelFlow           uint64
 	eventInvalidApp        uint64
 	eventInvalidtid        uint64
+	httpReqTx              uint64
+	httpReqRx              uint64
+	httpResTx              uint64
+	httpResRx              uint64
+	httpStatusErr          uint64
+	httpParseErr           uint64
 }
 
 func NewAppSimStatsDb(o *AppsimStats) *core.CCounterDb {
@@ -252,6 +257,54 @@ func NewAppSimStatsDb(o *AppsimStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpReqTx,
+		Name:     "httpReqTx",
+		Help:     "http requests tx",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpReqRx,
+		Name:     "httpReqRx",
+		Help:     "http requests rx",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpResTx,
+		Name:     "httpResTx",
+		Help:     "http responses tx",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpResRx,
+		Name:     "httpResRx",
+		Help:     "http responses rx",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpStatusErr,
+		Name:     "httpStatusErr",
+		Help:     "http response with unexpected status code",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpParseErr,
+		Name:     "httpParseErr",
+		Help:     "http message could not be parsed",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -266,6 +319,7 @@ const (
 	te_WAIT_RX          = 18 /* wait for traffic to be Rx */
 	te_DELAY            = 19
 	te_CLOSED           = 20
+	te_WAIT_HTTP_RX     = 21 /* wait for a whole http message to be Rx */
 )
 
 func (o tcp_app_state) String() string {
@@ -282,6 +336,8 @@ func (o tcp_app_state) String() string {
 		return "DELAY"
 	case te_CLOSED:
 		return "CLOSED"
+	case te_WAIT_HTTP_RX:
+		return "WAIT_HTTP_RX"
 	}
 	return fmt.Sprintf("unknow %v", int(o))
 }
@@ -326,6 +382,12 @@ type appL7Sim struct {
 	timerCb            UDPKeepAliveTimer
 	udp_keepalive      bool
 	udp_keepalive_msec uint32
+	str_vars           map[string]string      // string variables, set by set_str_var
+	http_rx            bool                   // the program parses http messages, keep the rx data
+	http_buf           []byte                 // rx data that was not parsed yet
+	http_methods       []string               // methods of the requests that wait for a response
+	http_rx_cmd        map[string]interface{} // the http rx command we wait for
+	http_rx_closed     bool                   // the remote side closed the connection
 }
 
 type UDPKeepAliveTimer struct {
@@ -426,6 +488,7 @@ func (o *appL7Sim) start() {
 	pl := (o.program)["program_list"].([]interface{})
 	cmds := pl[pindex].(map[string]interface{})
 	o.cmda = cmds["commands"].([]interface{})
+	o.http_rx = o.hasHttpRx()
 	if o.socket != nil {
 		o.onNewSocket()
 	}
@@ -459,7 +522,7 @@ func (o *appL7Sim) processDelayRand(min_usec float64, max_usec float64) {
 	if max_usec <= min_usec {
 		choosen = min_usec
 	} else {
-		choosen = (rand.Float64() * (max_usec - min_usec)) + min_usec
+		choosen = (o.tctx.Rand().Float64() * (max_usec - min_usec)) + min_usec
 	}
 
 	ticks := o.timerw.DurationToTicks(time.Duration(int64(choosen)) * time.Microsecond)
@@ -649,6 +712,22 @@ func (o *appL7Sim) processOneCmd(cmd map[string]interface{}) bool {
 		return true
 		break
 
+	case "set_str_var":
+		if o.str_vars == nil {
+			o.str_vars = make(map[string]string)
+		}
+		o.str_vars[cmd["id"].(string)] = o.expandVars(cmd["val"].(string))
+		return true
+
+	case "http_req":
+		return o.processHttpReq(cmd)
+
+	case "http_res":
+		return o.processHttpRes(cmd)
+
+	case "http_rx_res", "http_rx_req":
+		return o.processHttpRx(cmd)
+
 	case "jmp_dp":
 		break
 
@@ -744,6 +823,14 @@ func (o *appL7Sim) OnRxEvent(event transport.SocketEventType) {
 
 	if event&transport.SocketRemoteDisconnect > 0 {
 		// remote disconnected
+		if o.state == te_WAIT_HTTP_RX {
+			// a response without a length ends here
+			o.http_rx_closed = true
+			if o.checkHttpRx() {
+				o.state = te_NONE
+				o.processCmds()
+			}
+		}
 		if o.is_client {
 			if o.state != te_CLOSED {
 				o.socket.Close()
@@ -789,11 +876,22 @@ func (o *appL7Sim) OnRxData(d []byte) {
 		o.cmd_rx_bytes += 1
 	}
 
+	if o.http_rx {
+		o.http_buf = append(o.http_buf, d...)
+	}
+
 	if o.state == te_WAIT_RX {
 		if o.checkRxCondition() {
 			o.processCmds()
 		}
 	}
+
+	if o.state == te_WAIT_HTTP_RX {
+		if o.checkHttpRx() {
+			o.state = te_NONE
+			o.processCmds()
+		}
+	}
 }
 
 func (o *appL7Sim) OnTxEvent(event transport.SocketEventType) {
@@ -863,6 +961,10 @@ type PluginAppsimClient struct {
 	dgMacResolvedIpv6 bool
 }
 
+func (o *PluginAppsimClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 var appsimEvents = []string{core.MSG_DG_MAC_RESOLVED}
 
 func (o *PluginAppsimClientTimer) OnEvent(a, b interface{}) {
@@ -1044,6 +1146,10 @@ type PluginAppsimNs struct {
 	program map[string]interface{} // pointer to the global program
 }
 
+func (o *PluginAppsimNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewAppSimNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginAppsimNs)
 	o.InitPluginBase(ctx, o)
@@ -0,0 +1,273 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package appsim
+
+/*
+HTTP/1.1 commands of the program interpreter.
+
+http_req/http_res build a request/response from the command (method, url, status, headers and a body from buf_list),
+with ${var} replaced by the value of a variable. http_rx_res/http_rx_req wait for a whole message and parse it
+by Content-Length, chunked encoding or the close of the connection, so there is no need to count bytes. The client
+keeps the methods of the requests it sent, so requests can be pipelined on a keep-alive connection and the responses
+received later in the same order.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errHttpIncomplete means the rx data holds only part of the message.
+var errHttpIncomplete = errors.New("incomplete HTTP message")
+
+// parseHttpMessage parses one HTTP message at the start of b, a response to a request of method or a request if
+// method is empty. closed indicates the peer closed the connection, which ends a response without a length.
+// Returns the length of the message and the status code of a response.
+func parseHttpMessage(b []byte, method string, closed bool) (n int, status int, err error) {
+	if !bytes.Contains(b, []byte("\r\n\r\n")) && !bytes.Contains(b, []byte("\n\n")) {
+		// The header is not complete, the parser would fail on the partial line.
+		return 0, 0, errHttpIncomplete
+	}
+	r := bytes.NewReader(b)
+	br := bufio.NewReader(r)
+	var body io.ReadCloser
+	if method != "" {
+		res, err := http.ReadResponse(br, &http.Request{Method: method})
+		if err != nil {
+			return 0, 0, httpParseError(err)
+		}
+		status = res.StatusCode
+		body = res.Body
+		if res.ContentLength < 0 && len(res.TransferEncoding) == 0 && body != http.NoBody && !closed {
+			// The body ends when the connection is closed.
+			return 0, 0, errHttpIncomplete
+		}
+	} else {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return 0, 0, httpParseError(err)
+		}
+		body = req.Body
+	}
+	if _, err = io.Copy(io.Discard, body); err != nil {
+		return 0, 0, httpParseError(err)
+	}
+	return len(b) - r.Len() - br.Buffered(), status, nil
+}
+
+// httpParseError converts the errors of a message cut in the middle to errHttpIncomplete.
+func httpParseError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errHttpIncomplete
+	}
+	return err
+}
+
+// expandVars replaces ${id} with the value of the variable id, and ${name} with the string variable name.
+// Unknown variables are left as is.
+func (o *appL7Sim) expandVars(s string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		name := s[start+2 : end]
+		b.WriteString(s[:start])
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil && id < uint64(apVAR_NUM_SIZE) {
+			b.WriteString(strconv.FormatUint(o.vars[id], 10))
+		} else if val, ok := o.str_vars[name]; ok {
+			b.WriteString(val)
+		} else {
+			b.WriteString(s[start : end+1])
+		}
+		s = s[end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// buildHttpMessage builds an HTTP message with the start line, and the headers and body of the command.
+// Content-Length is added unless the body is chunked or the command provides it, Host is added to requests.
+func (o *appL7Sim) buildHttpMessage(startLine string, cmd map[string]interface{}, isReq bool) []byte {
+	headers := make(map[string]string)
+	if val, ok := cmd["headers"]; ok {
+		for name, value := range val.(map[string]interface{}) {
+			headers[http.CanonicalHeaderKey(name)] = o.expandVars(value.(string))
+		}
+	}
+
+	var body []byte
+	if val, ok := cmd["buf_index"]; ok {
+		body = o.getBuffer(uint32(val.(float64)))
+	}
+	chunked := false
+	if val, ok := cmd["chunked"]; ok {
+		chunked = val.(bool)
+	}
+
+	if _, ok := headers["Host"]; isReq && !ok {
+		headers["Host"] = o.socket.RemoteAddr().String()
+	}
+	_, hasLen := headers["Content-Length"]
+	_, hasEncoding := headers["Transfer-Encoding"]
+	if chunked {
+		headers["Transfer-Encoding"] = "chunked"
+	} else if !hasLen && !hasEncoding && (len(body) > 0 || !isReq) {
+		headers["Content-Length"] = strconv.Itoa(len(body))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(startLine + "\r\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, headers[name])
+	}
+	b.WriteString("\r\n")
+	if chunked {
+		if len(body) > 0 {
+			fmt.Fprintf(&b, "%x\r\n", len(body))
+			b.Write(body)
+			b.WriteString("\r\n")
+		}
+		b.WriteString("0\r\n\r\n")
+	} else {
+		b.Write(body)
+	}
+	return b.Bytes()
+}
+
+// writeHttp writes an HTTP message, returns true if the next command can be processed.
+func (o *appL7Sim) writeHttp(b []byte) bool {
+	o.stat.BytesTx += uint64(len(b))
+	o.stat.eventTx += 1
+	r, queued := o.socket.Write(b)
+	if r != 0 {
+		panic("error to write to socket ")
+	}
+	if !queued {
+		if o.isLog() {
+			fmt.Printf(" client :(%v) tx_queue_full \n", o.is_client)
+		}
+		o.changeState(te_SEND)
+	}
+	return queued
+}
+
+// processHttpReq sends a request, the method is kept to parse the response.
+func (o *appL7Sim) processHttpReq(cmd map[string]interface{}) bool {
+	method := "GET"
+	if val, ok := cmd["method"]; ok {
+		method = val.(string)
+	}
+	url := o.expandVars(cmd["url"].(string))
+	b := o.buildHttpMessage(fmt.Sprintf("%s %s HTTP/1.1", method, url), cmd, true)
+	o.http_methods = append(o.http_methods, method)
+	o.stat.httpReqTx++
+	return o.writeHttp(b)
+}
+
+// processHttpRes sends a response.
+func (o *appL7Sim) processHttpRes(cmd map[string]interface{}) bool {
+	status := http.StatusOK
+	if val, ok := cmd["status"]; ok {
+		status = int(val.(float64))
+	}
+	reason := http.StatusText(status)
+	if val, ok := cmd["reason"]; ok {
+		reason = val.(string)
+	}
+	b := o.buildHttpMessage(fmt.Sprintf("HTTP/1.1 %d %s", status, reason), cmd, false)
+	o.stat.httpResTx++
+	return o.writeHttp(b)
+}
+
+// processHttpRx waits for a response (http_rx_res) or a request (http_rx_req).
+func (o *appL7Sim) processHttpRx(cmd map[string]interface{}) bool {
+	o.http_rx_cmd = cmd
+	if o.checkHttpRx() {
+		return true
+	}
+	if o.state != te_CLOSED {
+		o.changeState(te_WAIT_HTTP_RX)
+	}
+	return false
+}
+
+// checkHttpRx parses the next message of the rx data for the waiting command. Returns true if the command is done.
+// A message that can't be parsed closes the flow.
+func (o *appL7Sim) checkHttpRx() bool {
+	cmd := o.http_rx_cmd
+	isRes := cmd["name"].(string) == "http_rx_res"
+	method := ""
+	if isRes {
+		method = "GET"
+		if len(o.http_methods) > 0 {
+			method = o.http_methods[0]
+		}
+	}
+	n, status, err := parseHttpMessage(o.http_buf, method, o.http_rx_closed)
+	if err == errHttpIncomplete {
+		return false
+	}
+	if err != nil {
+		if o.isLog() {
+			fmt.Printf(" client :(%v) http parse error %v \n", o.is_client, err)
+		}
+		o.stat.httpParseErr++
+		o.changeState(te_CLOSED)
+		o.socket.Close()
+		return false
+	}
+	o.http_buf = append(o.http_buf[:0], o.http_buf[n:]...)
+
+	if !isRes {
+		o.stat.httpReqRx++
+		return true
+	}
+	if len(o.http_methods) > 0 {
+		o.http_methods = o.http_methods[1:]
+	}
+	o.stat.httpResRx++
+	if val, ok := cmd["status"]; ok && int(val.(float64)) != status {
+		o.stat.httpStatusErr++
+	}
+	if val, ok := cmd["status_var"]; ok {
+		varId := uint64(val.(float64))
+		if varId < uint64(apVAR_NUM_SIZE) {
+			o.vars[varId] = uint64(status)
+		}
+	}
+	return true
+}
+
+// hasHttpRx returns true if the program has commands that parse HTTP messages, so the rx data must be kept.
+func (o *appL7Sim) hasHttpRx() bool {
+	for _, cmd := range o.cmda {
+		switch cmd.(map[string]interface{})["name"].(string) {
+		case "http_rx_res", "http_rx_req":
+			return true
+		}
+	}
+	return false
+}
@@ -281,6 +281,115 @@ const schema string = `{
              "required": ["name","flags"]
       },
 
+       "program_command_set_str_var_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["set_str_var"]
+                   },
+
+                  "id" : {
+                       "type" : "string"
+                   },
+
+                  "val" : {
+                       "type" : "string"
+                   }
+             },
+             "required": ["name","id","val"]
+        },
+
+       "http_headers_t" : {
+            "type": "object",
+            "additionalProperties": {
+                 "type" : "string"
+            }
+        },
+
+       "program_command_http_req_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["http_req"]
+                   },
+
+                  "method" : {
+                       "type" : "string"
+                   },
+
+                  "url" : {
+                       "type" : "string"
+                   },
+
+                  "headers" : {
+                       "$ref": "#/definitions/http_headers_t"
+                   },
+
+                  "buf_index" : {
+                       "type" : "integer"
+                   },
+
+                  "chunked" : {
+                       "type" : "boolean"
+                   }
+             },
+             "required": ["name","url"]
+        },
+
+       "program_command_http_res_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["http_res"]
+                   },
+
+                  "status" : {
+                       "type" : "integer",
+                       "minimum": 100,
+                       "maximum": 999
+                   },
+
+                  "reason" : {
+                       "type" : "string"
+                   },
+
+                  "headers" : {
+                       "$ref": "#/definitions/http_headers_t"
+                   },
+
+                  "buf_index" : {
+                       "type" : "integer"
+                   },
+
+                  "chunked" : {
+                       "type" : "boolean"
+                   }
+             },
+             "required": ["name"]
+        },
+
+       "program_command_http_rx_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["http_rx_res", "http_rx_req"]
+                   },
+
+                  "status" : {
+                       "type" : "integer"
+                   },
+
+                  "status_var" : {
+                       "type" : "integer"
+                   }
+             },
+             "required": ["name"]
+        },
+
 
       "program_t" : {
            "type": "object",
@@ -302,7 +411,11 @@ const schema string = `{
                                  {"$ref": "#/definitions/program_command_set_tick_var_t"},
                                  {"$ref": "#/definitions/program_command_jmpnz_t"},
                                  {"$ref": "#/definitions/program_command_jmpdp_t"},
-                                 {"$ref": "#/definitions/program_command_tx_mode_t"}
+                                 {"$ref": "#/definitions/program_command_tx_mode_t"},
+                                 {"$ref": "#/definitions/program_command_set_str_var_t"},
+                                 {"$ref": "#/definitions/program_command_http_req_t"},
+                                 {"$ref": "#/definitions/program_command_http_res_t"},
+                                 {"$ref": "#/definitions/program_command_http_rx_t"}
                                  
                                  ]
                              },
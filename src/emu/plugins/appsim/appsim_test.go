@@ -6,12 +6,15 @@
 package appsim
 
 import (
+	"bytes"
 	"emu/core"
+	"emu/plugins/transport"
 	"encoding/base64"
 	"flag"
 	"fmt"
-	"math/rand"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -60,7 +63,7 @@ func (o *TransimTestBase) Run(t *testing.T) {
 	tctx.MainLoopSim(o.duration)
 	defer tctx.Delete()
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 
 	ns := tctx.GetNs(&key)
 	if ns == nil {
@@ -84,7 +87,7 @@ func (o *TransimTestBase) Run(t *testing.T) {
 func createSimulationEnv(simRx *core.VethIFSim, num int, test *TransimTestBase) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 	ns := core.NewNSCtx(tctx, &key)
 
 	tctx.AddNs(&key, ns)
@@ -287,12 +290,12 @@ type AppL7SimTestBase struct {
 
 // run one flow (client/server) simulation
 func (o *AppL7SimTestBase) Run(t *testing.T, compare bool) {
-	rand.Seed(0x1234)
 	if emu_debug > 0 {
 		o.param.emu_debug = true
 	}
 
 	sim := newTransportSim(&o.param)
+	sim.tctx.SetSeed(0x1234)
 
 	m := false
 	if monitor > 0 {
@@ -1260,6 +1263,214 @@ func TestPluginAppSim36(t *testing.T) {
 	a.Run(t)
 }
 
+const input_json_http string = `
+{
+    "buf_list": [
+        "PGh0bWw+PHByZT4qKioqKioqKioqPC9wcmU+PC9odG1sPg==",
+        "bmFtZT10cmV4JnZhbHVlPWVtdQ=="
+    ],
+    "program_list": [
+        {
+            "commands": [
+                {"name": "set_var", "id": 0, "val": 3},
+                {"name": "set_str_var", "id": "agent", "val": "trex-emu"},
+                {"name": "http_req", "url": "/item/${0}", "headers": {"user-agent": "${agent}"}},
+                {"name": "jmp_nz", "id": 0, "offset": -1},
+                {"name": "set_var", "id": 0, "val": 3},
+                {"name": "http_rx_res", "status": 200, "status_var": 1},
+                {"name": "jmp_nz", "id": 0, "offset": -1},
+                {"name": "http_req", "method": "POST", "url": "/upload", "buf_index": 1, "chunked": true},
+                {"name": "http_rx_res", "status": 201}
+            ]
+        },
+        {
+            "commands": [
+                {"name": "set_var", "id": 0, "val": 3},
+                {"name": "http_rx_req"},
+                {"name": "http_res", "buf_index": 0, "headers": {"Content-Type": "text/html"}},
+                {"name": "jmp_nz", "id": 0, "offset": -2},
+                {"name": "http_rx_req"},
+                {"name": "http_res", "status": 201, "buf_index": 0, "chunked": true}
+            ]
+        }
+    ],
+
+    "templates": [{
+        "client_template" :{"program_index": 0,
+                "port": 80,
+                "cps": 1
+              },
+        "server_template" : {"assoc": [
+                    {
+                        "port": 80
+                    }
+                ],
+                "program_index": 1
+                }
+    }]
+}
+`
+
+// httpTestSocket is one side of an in-memory stream, the data written is queued to the peer.
+type httpTestSocket struct {
+	app     *appL7Sim
+	peer    *httpTestSocket
+	queue   [][]byte
+	closing bool
+	closed  bool
+	tx      bytes.Buffer
+}
+
+// Close closes the socket after the queue is delivered, as a real socket flushes its tx queue.
+func (o *httpTestSocket) Close() transport.SocketErr {
+	o.closing = true
+	return transport.SeOK
+}
+
+func (o *httpTestSocket) flush() {
+	if o.closing && !o.closed && len(o.queue) == 0 {
+		o.closed = true
+		o.peer.app.OnRxEvent(transport.SocketRemoteDisconnect)
+		o.app.OnRxEvent(transport.SocketClosed)
+	}
+}
+func (o *httpTestSocket) Shutdown() transport.SocketErr { return o.Close() }
+func (o *httpTestSocket) LocalAddr() net.Addr           { return &net.TCPAddr{IP: net.IPv4(16, 0, 0, 1)} }
+func (o *httpTestSocket) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(48, 0, 0, 1), Port: 80}
+}
+func (o *httpTestSocket) GetCap() transport.SocketCapType     { return transport.SocketCapStream }
+func (o *httpTestSocket) GetLastError() transport.SocketErr   { return transport.SeOK }
+func (o *httpTestSocket) SetIoctl(m transport.IoctlMap) error { return nil }
+func (o *httpTestSocket) GetIoctl(m transport.IoctlMap) error { return nil }
+func (o *httpTestSocket) GetL7MTU() uint16                    { return 1460 }
+func (o *httpTestSocket) IsIPv6() bool                        { return false }
+func (o *httpTestSocket) GetSocket() interface{}              { return nil }
+func (o *httpTestSocket) Write(buf []byte) (transport.SocketErr, bool) {
+	o.tx.Write(buf)
+	o.queue = append(o.queue, append([]byte{}, buf...))
+	return transport.SeOK, true
+}
+
+func (o *httpTestSocket) OnFinish(app *appL7Sim) {}
+
+// HTTP - pipelined requests on a keep-alive connection, then a chunked request and response
+func TestPluginAppsimHttp(t *testing.T) {
+	var j fastjson.RawMessage
+	j = fastjson.RawMessage(input_json_http)
+
+	var program map[string]interface{}
+	if err := IsValidAppSimJson(&j, &program); err != nil {
+		t.Fatalf(" http program json should be valid %v \n", err)
+	}
+
+	tctx := core.NewThreadCtxProxy()
+	var cstats, sstats AppsimStats
+	var capp, sapp appL7Sim
+	c := &httpTestSocket{app: &capp}
+	s := &httpTestSocket{app: &sapp}
+	c.peer, s.peer = s, c
+	capp.onCreate(program, 0, true, c, tctx, c, &cstats)
+	sapp.onCreate(program, 0, false, s, tctx, s, &sstats)
+	if emu_debug > 0 {
+		capp.flags |= taLOG_ENABLE
+		sapp.flags |= taLOG_ENABLE
+	}
+	capp.start()
+	sapp.start()
+	capp.OnRxEvent(transport.SocketEventConnected)
+	sapp.OnRxEvent(transport.SocketEventConnected)
+
+	// deliver the data in small pieces, so messages are parsed across reads
+	for len(c.queue) > 0 || len(s.queue) > 0 || c.closing != c.closed || s.closing != s.closed {
+		for _, o := range []*httpTestSocket{c, s} {
+			if len(o.queue) == 0 {
+				o.flush()
+				continue
+			}
+			b := o.queue[0]
+			o.queue = o.queue[1:]
+			for len(b) > 0 && !o.peer.closed {
+				n := 7
+				if n > len(b) {
+					n = len(b)
+				}
+				o.peer.app.OnRxData(b[:n])
+				b = b[n:]
+			}
+		}
+	}
+
+	if !c.closed || !s.closed {
+		t.Fatalf(" flow should be closed client: %v server: %v \n", c.closed, s.closed)
+	}
+	if cstats.httpReqTx != 4 || sstats.httpReqRx != 4 || sstats.httpResTx != 4 || cstats.httpResRx != 4 {
+		t.Fatalf(" bad http counters client: %+v server: %+v \n", cstats, sstats)
+	}
+	if cstats.httpStatusErr != 0 || cstats.httpParseErr != 0 || sstats.httpParseErr != 0 {
+		t.Fatalf(" unexpected http errors client: %+v server: %+v \n", cstats, sstats)
+	}
+	if capp.vars[1] != 200 {
+		t.Fatalf(" status_var should hold 200, got %v \n", capp.vars[1])
+	}
+	creq := c.tx.String()
+	for _, exp := range []string{
+		"GET /item/3 HTTP/1.1\r\nHost: 48.0.0.1:80\r\nUser-Agent: trex-emu\r\n\r\n",
+		"GET /item/1 HTTP/1.1\r\n",
+		"POST /upload HTTP/1.1\r\nHost: 48.0.0.1:80\r\nTransfer-Encoding: chunked\r\n\r\n13\r\nname=trex&value=emu\r\n0\r\n\r\n",
+	} {
+		if !strings.Contains(creq, exp) {
+			t.Fatalf(" request %q is missing in %q \n", exp, creq)
+		}
+	}
+	sres := s.tx.String()
+	if !strings.HasPrefix(sres, "HTTP/1.1 200 OK\r\nContent-Length: 34\r\nContent-Type: text/html\r\n\r\n<html>") ||
+		!strings.Contains(sres, "HTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n22\r\n") {
+		t.Fatalf(" bad responses %q \n", sres)
+	}
+}
+
+func TestPluginAppsimHttpParse(t *testing.T) {
+	res := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	chunked := "HTTP/1.1 404 Not Found\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"
+	noLen := "HTTP/1.1 200 OK\r\n\r\nhello"
+
+	n, status, err := parseHttpMessage([]byte(res+chunked), "GET", false)
+	if err != nil || n != len(res) || status != 200 {
+		t.Fatalf(" bad content-length response n: %v status: %v err: %v \n", n, status, err)
+	}
+	n, status, err = parseHttpMessage([]byte(chunked), "GET", false)
+	if err != nil || n != len(chunked) || status != 404 {
+		t.Fatalf(" bad chunked response n: %v status: %v err: %v \n", n, status, err)
+	}
+	for i := 0; i < len(res); i++ {
+		if _, _, err = parseHttpMessage([]byte(res[:i]), "GET", false); err != errHttpIncomplete {
+			t.Fatalf(" partial response of %v bytes should be incomplete, err: %v \n", i, err)
+		}
+	}
+	if _, _, err = parseHttpMessage([]byte(noLen), "GET", false); err != errHttpIncomplete {
+		t.Fatalf(" response without length should wait for close, err: %v \n", err)
+	}
+	if n, _, err = parseHttpMessage([]byte(noLen), "GET", true); err != nil || n != len(noLen) {
+		t.Fatalf(" response without length should end on close n: %v err: %v \n", n, err)
+	}
+	if n, _, err = parseHttpMessage([]byte(res), "HEAD", false); err != nil || n != len(res)-5 {
+		t.Fatalf(" response to HEAD has no body n: %v err: %v \n", n, err)
+	}
+
+	req := "GET /a HTTP/1.1\r\nHost: x\r\n\r\nPOST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nhi"
+	n, _, err = parseHttpMessage([]byte(req), "", false)
+	if err != nil || n != strings.Index(req, "POST") {
+		t.Fatalf(" bad pipelined request n: %v err: %v \n", n, err)
+	}
+	if n2, _, err := parseHttpMessage([]byte(req[n:]), "", false); err != nil || n2 != len(req)-n {
+		t.Fatalf(" bad request with body n: %v err: %v \n", n2, err)
+	}
+	if _, _, err = parseHttpMessage([]byte("NOT HTTP\r\n\r\n"), "GET", false); err == nil || err == errHttpIncomplete {
+		t.Fatalf(" invalid response should fail \n")
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 	flag.IntVar(&emu_debug, "emu_debug", 0, "emu_debug")
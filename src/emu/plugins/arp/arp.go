@@ -255,6 +255,7 @@ func NewArpNsStatsDb(o *ArpNsStats) *core.CCounterDb {
 		Help:     "arp table active",
 		Unit:     "entries",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tblAdd,
@@ -668,7 +669,9 @@ func NewArpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	nsplg := o.Ns.PluginCtx.GetOrCreate(ARP_PLUG)
 	o.arpNsPlug = nsplg.Ext.(*PluginArpNs)
 
-	o.OnCreate()
+	if o.Client.IsActive() {
+		o.OnCreate()
+	} /* else, wait for the ramp-up to activate the client */
 
 	return &o.PluginBase, nil
 }
@@ -676,7 +679,15 @@ func NewArpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 /*OnEvent support event change of IP  */
 func (o *PluginArpClient) OnEvent(msg string, a, b interface{}) {
 
+	if !o.Client.IsActive() {
+		/* the client is associated with the current addresses on activation */
+		return
+	}
+
 	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		o.OnCreate()
+
 	case core.MSG_UPDATE_IPV4_ADDR:
 		oldIPv4 := a.(core.Ipv4Key)
 		newIPv4 := b.(core.Ipv4Key)
@@ -705,7 +716,7 @@ func (o *PluginArpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
-var arpEvents = []string{core.MSG_UPDATE_IPV4_ADDR, core.MSG_UPDATE_DGIPV4_ADDR}
+var arpEvents = []string{core.MSG_UPDATE_IPV4_ADDR, core.MSG_UPDATE_DGIPV4_ADDR, core.MSG_CLIENT_ACTIVATED}
 
 /*OnChangeDGSrcIPv4 - called in case there is a change in DG or srcIPv4 */
 func (o *PluginArpClient) OnChangeDGSrcIPv4(oldDgIpv4 core.Ipv4Key,
@@ -728,10 +739,12 @@ func (o *PluginArpClient) OnRemove(ctx *core.PluginCtx) {
 		o.timerw.Stop(&o.timer)
 	}
 
-	o.OnChangeDGSrcIPv4(o.Client.DgIpv4,
-		o.Client.DgIpv4,
-		!o.Client.Ipv4.IsZero(),
-		false)
+	if o.Client.IsActive() {
+		o.OnChangeDGSrcIPv4(o.Client.DgIpv4,
+			o.Client.DgIpv4,
+			!o.Client.Ipv4.IsZero(),
+			false)
+	}
 	ctx.UnregisterEvents(&o.PluginBase, arpEvents)
 }
 
@@ -799,6 +812,10 @@ type PluginArpNs struct {
 	cdbv      *core.CCounterDbVec
 }
 
+func (o *PluginArpNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewArpNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginArpNs)
 	o.InitPluginBase(ctx, o)
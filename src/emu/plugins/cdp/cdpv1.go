@@ -82,6 +82,10 @@ type PluginCdpClient struct {
 	pktTemplate []byte
 }
 
+func (o *PluginCdpClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 var cdpEvents = []string{}
 
 /*NewCdpClient create plugin */
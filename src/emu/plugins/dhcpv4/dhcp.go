@@ -22,7 +22,6 @@ import (
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
-	"math/rand"
 	"net"
 	"sort"
 	"time"
@@ -40,8 +39,22 @@ const (
 	DHCP_STATE_REBINDING  = 4
 	DHCP_STATE_RENEWING   = 5
 	DHCP_STATE_BOUND      = 6
+
+	/* published events */
+	DHCP_EVENT_LEASE_ACQUIRED = "dhcp.lease_acquired"
+	DHCP_EVENT_LEASE_LOST     = "dhcp.lease_lost"
 )
 
+// DhcpLeaseEvent is the data of the published lease events.
+type DhcpLeaseEvent struct {
+	Ipv4   core.Ipv4Key `json:"ipv4"`
+	DgIpv4 core.Ipv4Key `json:"ipv4_dg"`
+	Server core.Ipv4Key `json:"server"`
+	T1     uint32       `json:"t1,omitempty"`
+	T2     uint32       `json:"t2,omitempty"`
+	Reason string       `json:"reason,omitempty"` // Why the lease was lost, nak or expired
+}
+
 type DhcpOptionsT struct {
 	DiscoverDhcpClassIdOption *string   `json:"discoverDhcpClassIdOption"`
 	RequestDhcpClassIdOption  *string   `json:"requestDhcpClassIdOption"`
@@ -226,6 +239,7 @@ type PluginDhcpClient struct {
 	timerOfferRetransmitSec    uint32
 	t1                         uint32
 	t2                         uint32
+	lease                      uint32 // Lease time of the ack, 0 if not given
 	discoverPktTemplate        []byte
 	requestPktTemplate         []byte
 	requestRenewPktTemplate    []byte
@@ -239,7 +253,11 @@ type PluginDhcpClient struct {
 	serverIdOptOffsetRelease   uint16 // Offset of DHCP Server Identifier Option in DHCP Release
 }
 
-var dhcpEvents = []string{}
+func (o *PluginDhcpClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+var dhcpEvents = []string{core.MSG_CLIENT_ACTIVATED}
 
 /*NewDhcpClient create plugin */
 func NewDhcpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -275,7 +293,10 @@ func (o *PluginDhcpClient) OnCreate() {
 	o.cdbv = core.NewCCounterDbVec("dhcp")
 	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
-	o.SendDiscover()
+	o.Client.RequireMilestone(core.CLIENT_MS_ADDRESS | core.CLIENT_MS_GATEWAY)
+	if o.Client.IsActive() {
+		o.SendDiscover()
+	} /* else, discover when the ramp-up activates the client */
 }
 
 func (o *PluginDhcpClient) preparePacketTemplate() {
@@ -287,7 +308,7 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 
 	var xid uint32
 	if !o.Tctx.Simulation {
-		xid = uint32(rand.Intn(0xffffffff))
+		xid = uint32(o.Ns.Rand().Intn(0xffffffff))
 	} else {
 		xid = 0x12345678
 	}
@@ -505,6 +526,7 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 
 func (o *PluginDhcpClient) SendDiscover() {
 	o.state = DHCP_STATE_INIT
+	o.Client.ClearMilestone(core.CLIENT_MS_ADDRESS)
 	o.cnt = 0
 	o.restartTimer(o.timerDiscoverRetransmitSec)
 	o.stats.pktTxDiscover++
@@ -513,12 +535,18 @@ func (o *PluginDhcpClient) SendDiscover() {
 
 /*OnEvent support event change of IP  */
 func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
-
+	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		o.SendDiscover()
+	}
 }
 
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
-	o.SendRenewRebind(false, true, 0)
+	if o.Client.IsActive() {
+		o.SendRenewRebind(false, true, 0)
+	}
+	o.Client.ReleaseMilestone(core.CLIENT_MS_ADDRESS | core.CLIENT_MS_GATEWAY)
 	ctx.UnregisterEvents(&o.PluginBase, dhcpEvents)
 	// TBD send release message
 	if o.timer.IsRunning() {
@@ -697,9 +725,27 @@ func (o *PluginDhcpClient) onTimerEvent() {
 	case DHCP_STATE_RENEWING:
 		o.state = DHCP_STATE_REBINDING
 		o.stats.pktRxRebind++
-		o.SendRenewRebind(true, false, o.timerOfferRetransmitSec)
+		o.SendRenewRebind(true, false, o.rebindSec())
+	case DHCP_STATE_REBINDING:
+		// no ack to the rebind, the lease expired
+		o.loseLease("expired")
+		o.SendDiscover()
+	}
+
+}
+
+// rebindSec returns the time to wait for an ack to the rebind, until the lease expires if its time is known.
+func (o *PluginDhcpClient) rebindSec() uint32 {
+	if o.lease > o.t2 {
+		return o.lease - o.t2
 	}
+	return o.timerOfferRetransmitSec
+}
 
+// loseLease publishes that the lease of a bound client was lost.
+func (o *PluginDhcpClient) loseLease(reason string) {
+	o.Client.PublishEvent(DHCP_EVENT_LEASE_LOST, &DhcpLeaseEvent{Ipv4: o.ipv4,
+		DgIpv4: o.Client.DgIpv4, Server: o.server, Reason: reason})
 }
 
 func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
@@ -707,6 +753,7 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
 	ipv4 layers.IPv4Header,
 	t1 uint32,
 	t2 uint32,
+	lease uint32,
 	notify bool,
 	server *core.Ipv4Key) int {
 	switch dhcpmt {
@@ -716,6 +763,7 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
 			return -1
 		}
 		o.state = DHCP_STATE_BOUND
+		o.Client.ReachMilestone(core.CLIENT_MS_ADDRESS)
 		if notify {
 			o.stats.pktRxNotify++
 			ipv4addr := ipv4.GetIPDst()
@@ -739,7 +787,15 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
 		if o.t2 < o.t1 {
 			o.t2 = o.t1 + 1
 		}
+		o.lease = lease
+		if notify {
+			o.Client.PublishEvent(DHCP_EVENT_LEASE_ACQUIRED, &DhcpLeaseEvent{Ipv4: o.ipv4,
+				DgIpv4: o.Client.DgIpv4, Server: o.server, T1: o.t1, T2: o.t2})
+		}
 	case layers.DHCPMsgTypeNak:
+		if o.state == DHCP_STATE_BOUND || o.state == DHCP_STATE_RENEWING || o.state == DHCP_STATE_REBINDING {
+			o.loseLease("nak")
+		}
 		o.SendDiscover()
 	}
 	return 0
@@ -776,6 +832,7 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 	var dhcpmt layers.DHCPMsgType
 	var t1 uint32
 	var t2 uint32
+	var lease uint32
 	dhcpmt = layers.DHCPMsgTypeUnspecified
 	t1 = 1811
 	t2 = 3200
@@ -807,6 +864,8 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 			t1 = o.getT1InSec(&op)
 		case layers.DHCPOptT2:
 			t2 = o.getT1InSec(&op)
+		case layers.DHCPOptLeaseTime:
+			lease = o.getT1InSec(&op)
 		default:
 		}
 	}
@@ -827,14 +886,14 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 		}
 
 	case DHCP_STATE_REQUESTING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, lease, true, server)
 	case DHCP_STATE_BOUND:
 		o.stats.pktRxUnhandled++
 	case DHCP_STATE_RENEWING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, lease, true, server)
 
 	case DHCP_STATE_REBINDING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, lease, true, server)
 
 	default:
 		o.stats.pktRxUnhandled++
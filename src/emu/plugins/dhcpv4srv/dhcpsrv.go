@@ -142,6 +142,7 @@ func NewDhcpSrvStatsDb(o *DhcpSrvStats) *core.CCounterDb {
 		Help:     "Num clients that are Offered/Bound/Renewing/Rebinding.",
 		Unit:     "clients",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -726,6 +727,10 @@ type PluginDhcpSrvClient struct {
 	ackReqSubnetMaskOptOff uint16              // Offset for Subnet Mask Option in Options slice for DHCPACK to DHCPREQUEST
 }
 
+func (o *PluginDhcpSrvClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // GetT1T2 calculates T1, T2 times based on lease.
 func GetT1T2(lease uint32) (t1, t2 uint32) {
 	t1 = uint32(0.5 * float64(lease))
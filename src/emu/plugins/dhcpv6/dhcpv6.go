@@ -17,7 +17,6 @@ import (
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
-	"math/rand"
 	"net"
 	"sort"
 	"time"
@@ -351,7 +350,11 @@ type PluginDhcpClient struct {
 	pktIana                    layers.DHCPv6OptionIANA
 }
 
-var dhcpEvents = []string{}
+func (o *PluginDhcpClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+var dhcpEvents = []string{core.MSG_CLIENT_ACTIVATED}
 
 // NewDhcpClient creates a new Dhcpv6 plugin
 func NewDhcpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -399,7 +402,10 @@ func (o *PluginDhcpClient) OnCreate() {
 	o.pktIana.IPv6 = make(net.IP, net.IPv6len)
 	o.sipv6 = make(net.IP, net.IPv6len)
 	o.sid = make([]byte, 0)
-	o.SendDiscover()
+	o.Client.RequireMilestone(core.CLIENT_MS_ADDRESS)
+	if o.Client.IsActive() {
+		o.SendDiscover()
+	} /* else, solicit when the ramp-up activates the client */
 }
 
 func (o *PluginDhcpClient) resetTransactionTimer() {
@@ -455,8 +461,8 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 	var xid uint32
 	var iaid uint32
 	if !o.Tctx.Simulation {
-		xid = uint32(rand.Intn(0xffffff))
-		iaid = uint32(rand.Intn(0xffffffff))
+		xid = uint32(o.Ns.Rand().Intn(0xffffff))
+		iaid = uint32(o.Ns.Rand().Intn(0xffffffff))
 	} else {
 		xid = 0x345678
 		iaid = 0x12345678
@@ -649,6 +655,7 @@ func (o *PluginDhcpClient) SendDhcpPacket(msgType layers.DHCPv6MsgType, serverOp
 
 func (o *PluginDhcpClient) SendDiscover() {
 	o.state = DHCP_STATE_INIT
+	o.Client.ClearMilestone(core.CLIENT_MS_ADDRESS)
 	o.cnt = 0
 	o.restartTimer(o.timerDiscoverRetransmitSec)
 	o.stats.pktTxDiscover++
@@ -656,11 +663,19 @@ func (o *PluginDhcpClient) SendDiscover() {
 }
 
 /*OnEvent support event change of IP  */
-func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {}
+func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		o.SendDiscover()
+	}
+}
 
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
-	o.SendRenewRebind(false, true, 0)
+	if o.Client.IsActive() {
+		o.SendRenewRebind(false, true, 0)
+	}
+	o.Client.ReleaseMilestone(core.CLIENT_MS_ADDRESS)
 	ctx.UnregisterEvents(&o.PluginBase, dhcpEvents)
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
@@ -828,6 +843,7 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	case layers.DHCPv6MsgTypeReply:
 		o.stats.pktRxAck++
 		o.state = DHCP_STATE_BOUND
+		o.Client.ReachMilestone(core.CLIENT_MS_ADDRESS)
 		if notify {
 			o.stats.pktRxNotify++
 			var NewIpv6 core.Ipv6Key
@@ -8,6 +8,7 @@ tree.
 package dns
 
 import (
+	"crypto/tls"
 	"emu/core"
 	utils "emu/plugins/dns_utils"
 	"emu/plugins/transport"
@@ -15,6 +16,7 @@ import (
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"net"
+	"strconv"
 
 	"github.com/intel-go/fastjson"
 )
@@ -52,12 +54,26 @@ Supported types of queries:
 	- AAAA
 	- PTR
 	- TXT
+	- CNAME, MX, SRV, NS, SOA, CAA and ANY by the name server
+
+The name server database can be imported from zone files, and it supports CNAME chasing, wildcards,
+negative answers with SOA and dynamic updates (see dns_zone.go and dns_update.go).
+
+Supported transports of the resolver (see dns_stream.go):
+	- UDP, with fallback to TCP when the response is truncated
+	- TCP
+	- DNS-over-TLS
+	- DNS-over-HTTPS
+
+The name server answers on UDP and TCP. UDP responses larger than 512 bytes
+(or the EDNS0 payload size of the query) are truncated, TC bit set.
 */
 
 const (
 	DNS_PLUG              = "dns" // Plugin name
 	DnsPort               = "53"  // Dns Port
 	DefaultDnsResponseTTL = 240   // Default TTL value
+	DefaultDnsUdpMaxSize  = 512   // Max UDP response size without EDNS0, RFC 1035
 )
 
 type DnsClientStats struct {
@@ -77,6 +93,15 @@ type DnsClientStats struct {
 	pktTxDnsResponse    uint64 // Num of Dns responses transmitted
 	pktRxDnsResponse    uint64 // Num of Dns responses received
 	unsupportedDnsType  uint64 // Num of queries received with an unsupported type
+	invalidEntryInDb    uint64 // Invalid entry found in Database
+	cnameChased         uint64 // Number of CNAME records chased
+	wildcardAnswers     uint64 // Number of names answered by a wildcard
+	zoneRecords         uint64 // Number of records imported from zone files
+	zoneImportError     uint64 // Error while importing a zone file
+	rxUpdates           uint64 // Number of dynamic updates received
+	updateApplied       uint64 // Number of dynamic updates applied
+	updateRefused       uint64 // Number of dynamic updates refused
+	updateFailed        uint64 // Number of dynamic updates failed
 
 }
 
@@ -212,6 +237,78 @@ func NewDnsClientStatsDb(o *DnsClientStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidEntryInDb,
+		Name:     "invalidEntryInDb",
+		Help:     "Invalid entry found in database",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cnameChased,
+		Name:     "cnameChased",
+		Help:     "Num of CNAME records chased",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.wildcardAnswers,
+		Name:     "wildcardAnswers",
+		Help:     "Num of names answered by a wildcard",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.zoneRecords,
+		Name:     "zoneRecords",
+		Help:     "Num of records imported from zone files",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.zoneImportError,
+		Name:     "zoneImportError",
+		Help:     "Error while importing a zone file",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxUpdates,
+		Name:     "rxUpdates",
+		Help:     "Num of dynamic updates received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.updateApplied,
+		Name:     "updateApplied",
+		Help:     "Num of dynamic updates applied",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.updateRefused,
+		Name:     "updateRefused",
+		Help:     "Num of dynamic updates refused",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.updateFailed,
+		Name:     "updateFailed",
+		Help:     "Num of dynamic updates failed, prerequisite or format",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -279,9 +376,10 @@ var dnsEvents = []string{}
 
 // DnsEntry represents a Dns entry in the database of the name server.
 type DnsEntry struct {
-	DnsType  string `json:"type"`   // Dns Type. Defaults to DefaultDnsQueryType.
-	DnsClass string `json:"class"`  // Dns Class. Defaults DefaultDnsQueryClass.
-	Answer   string `json:"answer"` // Answer for this Dns Entry.
+	DnsType  string `json:"type"`          // Dns Type. Defaults to DefaultDnsQueryType.
+	DnsClass string `json:"class"`         // Dns Class. Defaults DefaultDnsQueryClass.
+	Answer   string `json:"answer"`        // Answer for this Dns Entry.
+	TTL      uint32 `json:"ttl,omitempty"` // TTL of the answer. Defaults to DefaultDnsResponseTTL.
 }
 
 // DnsDatabase represents the database of the name server. Each key is a domain that can be queried.
@@ -290,9 +388,19 @@ type DnsDatabase map[string][]DnsEntry
 
 // DnsClientParams holds the Init JSON for a Dns Client/Server.
 type DnsClientParams struct {
-	DnsServerIP string               `json:"dns_server_ip"` // DnsServerIP is the Dns IP for this resolver.
-	NameServer  bool                 `json:"name_server"`   // Is this client a name server? Defaults to False.
-	Database    *fastjson.RawMessage `json:"database"`      // Database of the name server.
+	DnsServerIP   string               `json:"dns_server_ip"`   // DnsServerIP is the Dns IP for this resolver.
+	DnsServerPort uint16               `json:"dns_server_port"` // Defaults to the well known port of the transport.
+	Transport     string               `json:"transport"`       // udp, tcp, dot or doh. Defaults to udp.
+	TcpFallback   bool                 `json:"tcp_fallback"`    // Retry over TCP if an UDP response is truncated. Defaults to True.
+	TlsServerName string               `json:"tls_server_name"` // SNI and name to verify for dot/doh. Defaults to the server IP.
+	TlsVerify     bool                 `json:"tls_verify"`      // Verify the server certificate. Defaults to False.
+	TlsCa         string               `json:"tls_ca"`          // PEM CA certificates to verify with, system pool if empty.
+	DohPath       string               `json:"doh_path"`        // DoH URI path. Defaults to DefaultDohPath.
+	DohMethod     string               `json:"doh_method"`      // POST or GET. Defaults to POST.
+	NameServer    bool                 `json:"name_server"`     // Is this client a name server? Defaults to False.
+	Database      *fastjson.RawMessage `json:"database"`        // Database of the name server.
+	Zones         []string             `json:"zones"`           // Zone files in the BIND format, imported to the database.
+	AllowUpdate   bool                 `json:"allow_update"`    // Accept dynamic updates, RFC 2136. Defaults to False.
 }
 
 // PluginDnsClient represents a DNS client
@@ -307,6 +415,15 @@ type PluginDnsClient struct {
 	socket          transport.SocketApi  // Socket Api (both IPv4 and IPv6)
 	cache           *utils.DnsCache      // Dns cache
 	dnsPktBuilder   *utils.DnsPktBuilder // Dns Packet Builder
+	transStats      DnsTransportStats    // Per transport stats
+	transCdb        *core.CCounterDb     // Per transport counters database
+	stream          *dnsStream           // Stream transport, or TCP fallback of UDP
+	tlsCfg          *tls.Config          // TLS configuration of dot/doh
+	dohHost         string               // Host header of DoH requests
+}
+
+func (o *PluginDnsClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
 }
 
 // NewDnsClient creates a new Dns client.
@@ -317,7 +434,14 @@ func NewDnsClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	o.cdb = NewDnsClientStatsDb(&o.stats) // Register Stats immediately so we can fail safely.
 	o.cdbv = core.NewCCounterDbVec(DNS_PLUG)
 	o.cdbv.Add(o.cdb)
-
+	o.transCdb = NewDnsTransportStatsDb(&o.transStats)
+	o.cdbv.Add(o.transCdb)
+
+	// Set default values prior to unmarshal.
+	o.params.Transport = DnsTransportUdp
+	o.params.TcpFallback = true
+	o.params.DohPath = DefaultDohPath
+	o.params.DohMethod = "POST"
 	err := o.Tctx.UnmarshalValidate(initJson, &o.params)
 	if err != nil {
 		o.stats.invalidInitJson++
@@ -325,10 +449,22 @@ func NewDnsClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	}
 
 	if o.IsNameServer() {
-		err = fastjson.Unmarshal(*o.params.Database, &o.db)
-		if err != nil {
-			o.stats.invalidInitJson++
-			return nil, err
+		if o.params.Database != nil {
+			err = fastjson.Unmarshal(*o.params.Database, &o.db)
+			if err != nil {
+				o.stats.invalidInitJson++
+				return nil, err
+			}
+		}
+		if o.db == nil {
+			o.db = make(DnsDatabase)
+		}
+		for _, zone := range o.params.Zones {
+			err = o.ImportZone(zone, "")
+			if err != nil {
+				o.stats.invalidInitJson++
+				return nil, err
+			}
 		}
 	} else {
 		dnsServer := net.ParseIP(o.params.DnsServerIP)
@@ -345,7 +481,37 @@ func NewDnsClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 				return nil, fmt.Errorf("invalid DNS server IP %s", o.params.DnsServerIP)
 			}
 		}
-		o.dstAddr = net.JoinHostPort(dnsServer.String(), DnsPort)
+		port := o.params.DnsServerPort
+		switch o.params.Transport {
+		case DnsTransportUdp, DnsTransportTcp:
+			if port == 0 {
+				port = DnsTcpPort
+			}
+		case DnsTransportDoT, DnsTransportDoH:
+			if port == 0 {
+				port = DnsDoTPort
+				if o.params.Transport == DnsTransportDoH {
+					port = DnsDoHPort
+				}
+			}
+			if o.params.DohMethod != "POST" && o.params.DohMethod != "GET" {
+				o.stats.invalidInitJson++
+				return nil, fmt.Errorf("invalid DoH method %s", o.params.DohMethod)
+			}
+			o.tlsCfg, err = o.buildTlsConfig(dnsServer)
+			if err != nil {
+				o.stats.invalidInitJson++
+				return nil, err
+			}
+			o.dohHost = o.tlsCfg.ServerName
+			if port != DnsDoHPort {
+				o.dohHost = net.JoinHostPort(o.dohHost, strconv.Itoa(int(port)))
+			}
+		default:
+			o.stats.invalidInitJson++
+			return nil, fmt.Errorf("invalid DNS transport %s", o.params.Transport)
+		}
+		o.dstAddr = net.JoinHostPort(dnsServer.String(), strconv.Itoa(int(port)))
 	}
 
 	err = o.OnCreate()
@@ -359,7 +525,7 @@ func NewDnsClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 // OnCreate is called upon creating a new Dns client.
 func (o *PluginDnsClient) OnCreate() (err error) {
 
-	o.dnsPktBuilder = utils.NewDnsPktBuilder(false) // Create a packet builder for Dns
+	o.dnsPktBuilder = utils.NewDnsPktBuilder(false, o.Ns.Rand()) // Create a packet builder for Dns
 
 	if !o.IsNameServer() {
 		o.cache = utils.NewDnsCache(o.Tctx.GetTimerCtx()) // Create cache
@@ -370,16 +536,25 @@ func (o *PluginDnsClient) OnCreate() (err error) {
 	if transportCtx != nil {
 		if o.IsNameServer() {
 			err = transportCtx.Listen("udp", ":53", o)
+			if err == nil {
+				err = transportCtx.Listen("tcp", ":53", o)
+			}
 			if err != nil {
 				o.stats.invalidSocket++
 				return fmt.Errorf("could not create listening socket: %w", err)
 			}
-		} else {
+		} else if o.params.Transport == DnsTransportUdp {
 			o.socket, err = transportCtx.Dial("udp", o.dstAddr, o, nil, nil, 0)
 			if err != nil {
 				o.stats.invalidSocket++
 				return fmt.Errorf("could not create dialing socket: %w", err)
 			}
+			if o.params.TcpFallback {
+				o.stream = newDnsStream(o, DnsTransportTcp, o.dstAddr)
+			}
+		} else {
+			// the connection is opened by the first query
+			o.stream = newDnsStream(o, o.params.Transport, o.dstAddr)
 		}
 	}
 	return nil
@@ -391,7 +566,12 @@ func (o *PluginDnsClient) OnAccept(socket transport.SocketApi) transport.ISocket
 		return nil
 	}
 	o.stats.dnsFlowAccept++ // New flow for the Name Server.
-	o.socket = socket       // Store socket so we can reply.
+	if socket.GetCap()&transport.SocketCapStream != 0 {
+		// TCP flows are kept open, each one has its own reassembly buffer.
+		o.transStats.tcpFlowAccept++
+		return &dnsServerFlow{plug: o, socket: socket}
+	}
+	o.socket = socket // Store socket so we can reply.
 	return o
 }
 
@@ -405,8 +585,12 @@ func (o *PluginDnsClient) OnRemove(ctx *core.PluginCtx) {
 		transportCtx := transport.GetTransportCtx(o.Client)
 		if transportCtx != nil {
 			transportCtx.UnListen("udp", ":53", o)
+			transportCtx.UnListen("tcp", ":53", o)
 		}
 	} else {
+		if o.stream != nil && o.stream.socket != nil {
+			o.stream.abort()
+		}
 		if o.cache != nil {
 			_ = utils.NewDnsCacheRemover(o.cache, ctx.Tctx.GetTimerCtx())
 			o.cache = nil // GC can remove the client while the cache is removed.
@@ -419,47 +603,82 @@ func (o *PluginDnsClient) OnRxEvent(event transport.SocketEventType) {}
 
 // OnRxData is called when rx data is received for the client.
 func (o *PluginDnsClient) OnRxData(d []byte) {
-	formatError := false
 	o.stats.rxBytes += uint64(len(d))
+	if o.IsNameServer() {
+		o.handleQuery(d, o.socket)
+	} else {
+		o.handleResponse(d, DnsTransportUdp)
+	}
+}
+
+// handleQuery handles a message received by the name server on socket.
+func (o *PluginDnsClient) handleQuery(d []byte, socket transport.SocketApi) {
 	var dns layers.DNS
 	err := dns.DecodeFromBytes(d, o)
 	if err != nil {
 		o.stats.pktRxDecodeError++
-		formatError = true
+		// Reply with format error
+		o.reply(0, []layers.DNSQuestion{}, socket, DefaultDnsUdpMaxSize)
+		return // Done. Can't proceed!
 	}
+	if dns.QR != false {
+		// Response received in name server! Nothing to do.
+		o.stats.pktRxDnsResponse++
+	} else if dns.OpCode == layers.DNSOpCodeUpdate {
+		o.handleUpdate(&dns, socket)
+	} else {
+		// Query received in name server!
+		o.stats.pktRxDnsQuery++
+		if dns.QDCount > 0 {
+			o.stats.rxQuestions += uint64(dns.QDCount)
+			o.reply(dns.ID, dns.Questions, socket, getUdpMaxSize(&dns))
+		}
+	}
+}
 
-	if o.IsNameServer() {
-		if formatError {
-			// Reply with format error
-			o.Reply(0, []layers.DNSQuestion{}, o.socket)
-			return // Done. Can't proceed!
+// getUdpMaxSize returns the max UDP response size the querier supports, EDNS0 (RFC 6891).
+func getUdpMaxSize(dns *layers.DNS) int {
+	for i := range dns.Additionals {
+		if dns.Additionals[i].Type == layers.DNSTypeOPT && int(dns.Additionals[i].Class) > DefaultDnsUdpMaxSize {
+			return int(dns.Additionals[i].Class)
 		}
-		if dns.QR != false {
-			// Response received in name server! Nothing to do.
-			o.stats.pktRxDnsResponse++
-		} else {
-			// Query received in name server!
-			o.stats.pktRxDnsQuery++
-			if dns.QDCount > 0 {
-				o.stats.rxQuestions += uint64(dns.QDCount)
-				o.Reply(dns.ID, dns.Questions, o.socket)
+	}
+	return DefaultDnsUdpMaxSize
+}
+
+// handleResponse handles a message received by the resolver over one of the transports.
+func (o *PluginDnsClient) handleResponse(d []byte, trans string) {
+	var dns layers.DNS
+	err := dns.DecodeFromBytes(d, o)
+	if err != nil {
+		// Nothing to do on client!
+		o.stats.pktRxDecodeError++
+		return
+	}
+	if dns.QR == false {
+		// Query received in simple client! Nothing to do.
+		o.stats.pktRxDnsQuery++
+		return
+	}
+	// Response received in simple client! Cache it.
+	o.stats.pktRxDnsResponse++
+	if trans == DnsTransportUdp {
+		o.transStats.pktRxUdpResponse++
+		if dns.TC {
+			// The answers are partial, retry over TCP with the same transaction ID.
+			o.transStats.pktRxTruncated++
+			if o.stream != nil && len(dns.Questions) > 0 {
+				data := o.dnsPktBuilder.BuildQueryPktWithID(dns.ID, dns.Questions)
+				if o.stream.send(data) == nil {
+					o.transStats.tcpFallback++
+					o.stats.pktTxDnsQuery++
+				}
 			}
-		}
-	} else {
-		if formatError {
-			// Nothing to do on client!
 			return
 		}
-		if dns.QR == false {
-			// Query received in simple client! Nothing to do.
-			o.stats.pktRxDnsQuery++
-		} else {
-			// Response received in simple client! Cache it.
-			o.stats.pktRxDnsResponse++
-			if dns.ResponseCode == layers.DNSResponseCodeNoErr && dns.ANCount > 0 {
-				utils.AddAnswersToCache(o.cache, dns.Answers)
-			}
-		}
+	}
+	if dns.ResponseCode == layers.DNSResponseCodeNoErr && dns.ANCount > 0 {
+		utils.AddAnswersToCache(o.cache, dns.Answers)
 	}
 }
 
@@ -484,7 +703,23 @@ func (o *PluginDnsClient) Query(queries []utils.DnsQueryParams, socket transport
 	}
 
 	if len(questions) > 0 {
+		if o.params.Transport == DnsTransportDoH {
+			// RFC 8484, the ID should be 0 in order to be cache friendly
+			data := o.dnsPktBuilder.BuildQueryPktWithID(0, questions)
+			if err := o.stream.send(data); err != nil {
+				return err
+			}
+			o.stats.pktTxDnsQuery++
+			return nil
+		}
 		data := o.dnsPktBuilder.BuildQueryPkt(questions, o.Tctx.Simulation)
+		if o.params.Transport != DnsTransportUdp {
+			if err := o.stream.send(data); err != nil {
+				return err
+			}
+			o.stats.pktTxDnsQuery++ // bytes are counted by the stream
+			return nil
+		}
 		if socket == nil {
 			return fmt.Errorf("Invalid Socket in Query!")
 		}
@@ -495,69 +730,18 @@ func (o *PluginDnsClient) Query(queries []utils.DnsQueryParams, socket transport
 		}
 		o.stats.pktTxDnsQuery++              // successfully sent query
 		o.stats.txBytes += uint64(len(data)) // number of bytes sent
+		o.transStats.pktTxUdpQuery++
 	}
 	return nil
 }
 
-// isValidAnswer receives a Query Type and Class, and a DnsEntry of the database. It concludes if this
-// entry is a valid answer in terms of Type and Class.
-func (o *PluginDnsClient) isValidAnswer(entry DnsEntry, qType layers.DNSType, qClass layers.DNSClass) bool {
-	// Dns Types must equal. Dns Class should equal in case it isn't Any.
-	return (entry.DnsType == qType.String()) && (entry.DnsClass == qClass.String() || qClass == layers.DNSClassAny)
-}
-
-// BuildAnswers builds answers based on Dns questions
-func (o *PluginDnsClient) BuildAnswers(questions []layers.DNSQuestion) (answers []layers.DNSResourceRecord) {
-
-	for _, q := range questions {
-		domainEntries, ok := o.db[string(q.Name)]
-		if !ok {
-			o.stats.rxQuestionsNxDomain++
-			continue
-		}
-
-		foundAnswer := false
-
-		for i := range domainEntries {
-			if o.isValidAnswer(domainEntries[i], q.Type, q.Class) {
-				foundAnswer = true
-				class, _ := layers.StringToDNSClass(domainEntries[i].DnsClass)
-				answer := layers.DNSResourceRecord{
-					Name:  []byte(q.Name),
-					Type:  q.Type,
-					Class: class,
-					TTL:   DefaultDnsResponseTTL,
-				}
-
-				switch answer.Type {
-				case layers.DNSTypeA, layers.DNSTypeAAAA:
-					ip := net.ParseIP(domainEntries[i].Answer)
-					if ip != nil {
-						answer.IP = ip
-					} else {
-						o.stats.invalidIpInDb++
-						continue // skip the ones we can't answer
-					}
-				case layers.DNSTypePTR:
-					answer.PTR = []byte(domainEntries[i].Answer)
-				case layers.DNSTypeTXT:
-					answer.TXTs = utils.BuildTxtsFromString(domainEntries[i].Answer)
-				default:
-					o.stats.unsupportedDnsType++
-					continue // skip the ones we can't answer
-				}
-				answers = append(answers, answer)
-			}
-		}
-		if !foundAnswer {
-			o.stats.rxQuestionsNxDomain++
-		}
-	}
-	return answers
-}
-
 // Replies replies to questions in a NameServer.
 func (o *PluginDnsClient) Reply(transactionId uint16, questions []layers.DNSQuestion, socket transport.SocketApi) error {
+	return o.reply(transactionId, questions, socket, DefaultDnsUdpMaxSize)
+}
+
+// reply replies to questions, UDP responses larger than udpMaxSize are truncated.
+func (o *PluginDnsClient) reply(transactionId uint16, questions []layers.DNSQuestion, socket transport.SocketApi, udpMaxSize int) error {
 
 	if !o.IsNameServer() {
 		return fmt.Errorf("Only Name Servers can reply!")
@@ -567,19 +751,37 @@ func (o *PluginDnsClient) Reply(transactionId uint16, questions []layers.DNSQues
 		return fmt.Errorf("Invalid Socket in Reply!")
 	}
 
-	respCode := layers.DNSResponseCodeNoErr // We start with no problems.
-
-	answers := []layers.DNSResourceRecord{}
+	var data []byte
 	if len(questions) > 0 {
-		answers = o.BuildAnswers(questions)
-		if len(answers) == 0 {
-			respCode = layers.DNSResponseCodeNXDomain
-		}
+		res := o.buildResult(questions)
+		data = o.dnsPktBuilder.BuildAuthResponsePkt(transactionId, res.answers, res.authorities, res.additionals, questions, res.rcode)
 	} else {
-		respCode = layers.DNSResponseCodeFormErr
+		data = o.dnsPktBuilder.BuildResponsePkt(transactionId, []layers.DNSResourceRecord{}, questions, layers.DNSResponseCodeFormErr)
+	}
+	if socket.GetCap()&transport.SocketCapStream == 0 && len(data) > udpMaxSize {
+		// The querier should retry over TCP.
+		data = o.dnsPktBuilder.BuildTruncatedResponsePkt(transactionId, questions)
+		o.transStats.pktTxTruncated++
+	}
+	return o.write(data, socket)
+}
+
+// write writes a response of the name server on socket.
+func (o *PluginDnsClient) write(data []byte, socket transport.SocketApi) error {
+	if socket.GetCap()&transport.SocketCapStream != 0 {
+		// TCP, the flow is closed by the querier.
+		data = dnsFrame(data)
+		transportErr, _ := socket.Write(data)
+		if transportErr != transport.SeOK {
+			o.stats.socketWriteError++
+			return transportErr.Error()
+		}
+		o.stats.pktTxDnsResponse++
+		o.transStats.pktTxTcpResponse++
+		o.stats.txBytes += uint64(len(data))
+		return nil
 	}
 
-	data := o.dnsPktBuilder.BuildResponsePkt(transactionId, answers, questions, respCode)
 	transportErr, _ := socket.Write(data)
 	if transportErr != transport.SeOK {
 		o.stats.socketWriteError++
@@ -607,17 +809,18 @@ func (o *PluginDnsClient) AddDomainEntries(domain string, newEntries []DnsEntry)
 		// This domain already exists.
 		// Let's convert the entries into a set for fast lookup.
 		// Since the DnsEntry is a trivial structure the autogenerated hash and equals function work.
+		// The TTL is not part of the key, so adding an existing record with another TTL does nothing.
 		entriesSet := make(map[DnsEntry]bool)
 		for _, entry := range entries {
-			entriesSet[entry] = true
+			entriesSet[entry.key()] = true
 		}
 		for _, entry := range newEntries {
-			if entriesSet[entry] {
+			if entriesSet[entry.key()] {
 				// Entry already exists.
 				continue
 			} else {
 				entries = append(entries, entry)
-				entriesSet[entry] = true // malicious user can provide twice the same entry in newEntries
+				entriesSet[entry.key()] = true // malicious user can provide twice the same entry in newEntries
 			}
 		}
 		o.db[domain] = entries
@@ -636,7 +839,7 @@ func (o *PluginDnsClient) RemoveDomainEntries(domain string, entriesToRemove []D
 
 	entriesToRemoveSet := make(map[DnsEntry]bool)
 	for _, entry := range entriesToRemove {
-		entriesToRemoveSet[entry] = true
+		entriesToRemoveSet[entry.key()] = true
 	}
 
 	var validEntries []DnsEntry
@@ -644,7 +847,7 @@ func (o *PluginDnsClient) RemoveDomainEntries(domain string, entriesToRemove []D
 	if ok {
 		// Domain exists
 		for _, entry := range entries {
-			if _, ok := entriesToRemoveSet[entry]; !ok {
+			if _, ok := entriesToRemoveSet[entry.key()]; !ok {
 				// Entry is not in entries to remove.
 				validEntries = append(validEntries, entry)
 			}
@@ -709,6 +912,10 @@ type PluginDnsNs struct {
 	autoPlay        *utils.DnsNsAutoPlay // DNS program autoplay
 }
 
+func (o *PluginDnsNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // NewDnsNs creates a new DNS namespace plugin.
 func NewDnsNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginDnsNs)
@@ -862,8 +1069,13 @@ type (
 	}
 	ApiDnsGetDomainEntriesHandler struct{} // Handler for Get Domain Entries
 	ApiDnsGetDomainsHandler       struct{} // Get Domains of a Dns Name Server.
-	ApiDnsQueryHandler            struct{} // Query RPC Handler
-	ApiDnsCacheIterParams         struct {
+	ApiDnsImportZoneHandler       struct{} // Import a zone file to a Dns Name Server.
+	ApiDnsImportZoneParams        struct {
+		Zone   string `json:"zone" validate:"required"` // Zone file in the BIND format
+		Origin string `json:"origin"`                   // Initial $ORIGIN of the zone file
+	}
+	ApiDnsQueryHandler    struct{} // Query RPC Handler
+	ApiDnsCacheIterParams struct {
 		Reset bool   `json:"reset"`
 		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
 	} // Params for a client cache iteration
@@ -1009,6 +1221,37 @@ func (h ApiDnsGetDomainsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return entries, nil
 }
 
+// ApiDnsImportZoneHandler handles the RPC request to import a zone file to a Name Server.
+func (h ApiDnsImportZoneHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var p ApiDnsImportZoneParams
+	tctx := ctx.(*core.CThreadCtx)
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = c.ImportZone(p.Zone, p.Origin)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	return nil, nil
+}
+
 // ApiDnsQueryHandler handles the RPC query request.
 func (h ApiDnsQueryHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
@@ -1156,6 +1399,7 @@ func init() {
 	core.RegisterCB("dns_c_add_remove_domain_entries", ApiDnsAddRemoveDomainEntriesHandler{}, false) // add or remove entries from a domain in a name server
 	core.RegisterCB("dns_c_get_domain_entries", ApiDnsGetDomainEntriesHandler{}, false)              // get entries of a domain in a name server
 	core.RegisterCB("dns_c_get_domains", ApiDnsGetDomainsHandler{}, false)                           // get domains of name server
+	core.RegisterCB("dns_c_import_zone", ApiDnsImportZoneHandler{}, false)                           // import a zone file to a name server
 	core.RegisterCB("dns_c_query", ApiDnsQueryHandler{}, false)                                      // query
 	core.RegisterCB("dns_c_cache_iter", ApiDnsCacheIterHandler{}, false)                             // iterate client cache
 	core.RegisterCB("dns_c_cache_flush", ApiDnsCacheFlushHandler{}, false)                           // flush the cache
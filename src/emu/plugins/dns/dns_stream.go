@@ -0,0 +1,725 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"emu/core"
+	"emu/plugins/tls_utils"
+	"emu/plugins/transport"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+Stream transports of the Dns client.
+
+	tcp - RFC 7766, each message is prefixed with a two byte length. Also used as
+	      fallback when an UDP response is truncated (TC bit).
+	dot - RFC 7858, the same framing as tcp inside a TLS session.
+	doh - RFC 8484, HTTP/1.1 POST (or GET with ?dns=) of application/dns-message
+	      inside a TLS session.
+
+The connection is opened on the first query and kept open, queries are
+pipelined. Queries sent before the connection (and the TLS handshake) is ready
+are queued. If the server closes the connection, the next query opens a new one.
+*/
+
+const (
+	DnsTransportUdp = "udp"
+	DnsTransportTcp = "tcp"
+	DnsTransportDoT = "dot"
+	DnsTransportDoH = "doh"
+
+	DnsTcpPort          = 53
+	DnsDoTPort          = 853
+	DnsDoHPort          = 443
+	DefaultDohPath      = "/dns-query"
+	DnsMessageMediaType = "application/dns-message"
+
+	maxPendingQueries = 1024      // queries queued while the connection is not ready
+	maxStreamBuffer   = 64 * 1024 // reassembly limit of a single message/HTTP response
+)
+
+// DnsTransportStats holds the per transport counters of a Dns client/name server.
+type DnsTransportStats struct {
+	pktTxUdpQuery      uint64 // Num of queries sent over UDP
+	pktRxUdpResponse   uint64 // Num of responses received over UDP
+	pktRxTruncated     uint64 // Num of UDP responses with TC bit
+	tcpFallback        uint64 // Num of queries retried over TCP after truncation
+	tcpConnect         uint64 // Num of stream connections established
+	tcpConnectErr      uint64 // Num of stream connections that failed
+	tcpClosed          uint64 // Num of stream connections closed
+	pktTxTcpQuery      uint64 // Num of queries sent over TCP
+	pktRxTcpResponse   uint64 // Num of responses received over TCP
+	tlsHandshakeOk     uint64 // Num of successful TLS handshakes
+	tlsHandshakeErr    uint64 // Num of failed TLS handshakes
+	tlsErr             uint64 // Num of TLS errors after the handshake
+	pktTxDotQuery      uint64 // Num of queries sent over DoT
+	pktRxDotResponse   uint64 // Num of responses received over DoT
+	pktTxDohQuery      uint64 // Num of queries sent over DoH
+	pktRxDohResponse   uint64 // Num of responses received over DoH
+	dohHttpErr         uint64 // Num of DoH responses with HTTP status other than 200
+	streamParseErr     uint64 // Num of framing/HTTP parsing errors
+	pendingDropped     uint64 // Num of queued queries dropped because the connection failed
+	tcpFlowAccept      uint64 // Num of TCP flows accepted by the name server
+	pktRxTcpQuery      uint64 // Num of queries received over TCP by the name server
+	pktTxTcpResponse   uint64 // Num of responses sent over TCP by the name server
+	pktTxTruncated     uint64 // Num of UDP responses sent with TC bit by the name server
+	pktRxQueryBadFrame uint64 // Num of TCP frames the name server could not parse
+}
+
+// NewDnsTransportStatsDb creates a new database of Dns transport counters.
+func NewDnsTransportStatsDb(o *DnsTransportStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dns_transport")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxUdpQuery,
+		Name:     "pktTxUdpQuery",
+		Help:     "Num of queries sent over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUdpResponse,
+		Name:     "pktRxUdpResponse",
+		Help:     "Num of responses received over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTruncated,
+		Name:     "pktRxTruncated",
+		Help:     "Num of UDP responses received with TC bit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcpFallback,
+		Name:     "tcpFallback",
+		Help:     "Num of queries retried over TCP after truncation",
+		Unit:     "query",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcpConnect,
+		Name:     "tcpConnect",
+		Help:     "Num of stream connections established",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcpConnectErr,
+		Name:     "tcpConnectErr",
+		Help:     "Num of stream connections that failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcpClosed,
+		Name:     "tcpClosed",
+		Help:     "Num of stream connections closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTcpQuery,
+		Name:     "pktTxTcpQuery",
+		Help:     "Num of queries sent over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTcpResponse,
+		Name:     "pktRxTcpResponse",
+		Help:     "Num of responses received over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsHandshakeOk,
+		Name:     "tlsHandshakeOk",
+		Help:     "Num of successful TLS handshakes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsHandshakeErr,
+		Name:     "tlsHandshakeErr",
+		Help:     "Num of failed TLS handshakes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsErr,
+		Name:     "tlsErr",
+		Help:     "Num of TLS errors after the handshake",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxDotQuery,
+		Name:     "pktTxDotQuery",
+		Help:     "Num of queries sent over DoT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDotResponse,
+		Name:     "pktRxDotResponse",
+		Help:     "Num of responses received over DoT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxDohQuery,
+		Name:     "pktTxDohQuery",
+		Help:     "Num of queries sent over DoH",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDohResponse,
+		Name:     "pktRxDohResponse",
+		Help:     "Num of responses received over DoH",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dohHttpErr,
+		Name:     "dohHttpErr",
+		Help:     "Num of DoH responses with HTTP status other than 200",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.streamParseErr,
+		Name:     "streamParseErr",
+		Help:     "Num of framing/HTTP parsing errors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pendingDropped,
+		Name:     "pendingDropped",
+		Help:     "Num of queued queries dropped because the connection failed",
+		Unit:     "query",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcpFlowAccept,
+		Name:     "tcpFlowAccept",
+		Help:     "Num of TCP flows accepted by the name server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTcpQuery,
+		Name:     "pktRxTcpQuery",
+		Help:     "Num of queries received over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTcpResponse,
+		Name:     "pktTxTcpResponse",
+		Help:     "Num of responses sent over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTruncated,
+		Name:     "pktTxTruncated",
+		Help:     "Num of UDP responses sent with TC bit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxQueryBadFrame,
+		Name:     "pktRxQueryBadFrame",
+		Help:     "Num of TCP frames the name server could not parse",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// dnsFrame prefixes a Dns message with its length, RFC 7766.
+func dnsFrame(msg []byte) []byte {
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	return b
+}
+
+// dnsUnframe returns the first complete message in b and the bytes consumed, 0 if incomplete.
+func dnsUnframe(b []byte) ([]byte, int) {
+	if len(b) < 2 {
+		return nil, 0
+	}
+	l := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+l {
+		return nil, 0
+	}
+	return b[2 : 2+l], 2 + l
+}
+
+// dnsStream is a client connection to the name server over tcp, dot or doh.
+type dnsStream struct {
+	plug      *PluginDnsClient
+	mode      string
+	addr      string
+	socket    transport.SocketApi
+	connected bool
+	tls       *tls_utils.TlsSession
+	ready     bool     // connected and TLS handshake done (if any)
+	pending   [][]byte // queries waiting for the connection to be ready
+	txq       []byte   // bytes waiting for SocketTxMore
+	drain     bool
+	rx        []byte
+}
+
+func newDnsStream(plug *PluginDnsClient, mode string, addr string) *dnsStream {
+	o := new(dnsStream)
+	o.plug = plug
+	o.mode = mode
+	o.addr = addr
+	return o
+}
+
+func (o *dnsStream) stats() *DnsTransportStats { return &o.plug.transStats }
+
+// send sends a Dns message, opening the connection if needed.
+func (o *dnsStream) send(msg []byte) error {
+	if o.socket == nil {
+		if err := o.connect(); err != nil {
+			return err
+		}
+	}
+	if !o.ready {
+		if len(o.pending) >= maxPendingQueries {
+			o.stats().pendingDropped++
+			return fmt.Errorf("too many queries waiting for the %s connection", o.mode)
+		}
+		o.pending = append(o.pending, msg)
+		return nil
+	}
+	return o.sendMsg(msg)
+}
+
+func (o *dnsStream) connect() error {
+	transportCtx := transport.GetTransportCtx(o.plug.Client)
+	if transportCtx == nil {
+		return fmt.Errorf("no transport for %s", o.mode)
+	}
+	s, err := transportCtx.Dial("tcp", o.addr, o, nil, nil, 0)
+	if err != nil {
+		o.plug.stats.invalidSocket++
+		return err
+	}
+	o.socket = s
+	o.connected = false
+	o.ready = false
+	o.rx = o.rx[:0]
+	o.txq = nil
+	o.drain = false
+	return nil
+}
+
+func (o *dnsStream) sendMsg(msg []byte) error {
+	var b []byte
+	switch o.mode {
+	case DnsTransportDoH:
+		b = o.plug.buildDohRequest(msg)
+	default:
+		b = dnsFrame(msg)
+	}
+	if o.tls != nil {
+		if err := o.tls.Write(b); err != nil {
+			o.stats().tlsErr++
+			return err
+		}
+		b = o.tls.TakeOut()
+	}
+	if err := o.write(b); err != nil {
+		return err
+	}
+	switch o.mode {
+	case DnsTransportTcp:
+		o.stats().pktTxTcpQuery++
+	case DnsTransportDoT:
+		o.stats().pktTxDotQuery++
+	case DnsTransportDoH:
+		o.stats().pktTxDohQuery++
+	}
+	return nil
+}
+
+// write writes to the socket, keeping the order if the socket queue is full.
+func (o *dnsStream) write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if o.drain {
+		o.txq = append(o.txq, b...)
+		return nil
+	}
+	res, queued := o.socket.Write(b)
+	if res != transport.SeOK {
+		o.plug.stats.socketWriteError++
+		return res.Error()
+	}
+	if !queued {
+		o.drain = true
+	}
+	o.plug.stats.txBytes += uint64(len(b))
+	return nil
+}
+
+func (o *dnsStream) onReady() {
+	o.ready = true
+	pending := o.pending
+	o.pending = nil
+	for _, msg := range pending {
+		o.sendMsg(msg)
+	}
+}
+
+func (o *dnsStream) startTls() {
+	o.tls = tls_utils.NewTlsSession(o.plug.tlsCfg, false)
+	o.handleTlsEvents(o.tls.Start())
+}
+
+func (o *dnsStream) handleTlsEvents(evs []tls_utils.TlsEvent) {
+	for _, ev := range evs {
+		if ev.Handshake {
+			if ev.Err != nil {
+				o.stats().tlsHandshakeErr++
+				o.write(o.tls.TakeOut()) // alert
+				o.abort()
+				return
+			}
+			o.stats().tlsHandshakeOk++
+			o.write(o.tls.TakeOut())
+			o.onReady()
+			continue
+		}
+		if len(ev.Data) > 0 {
+			o.onData(ev.Data)
+		}
+		if ev.Err != nil {
+			o.stats().tlsErr++
+			o.abort()
+			return
+		}
+	}
+	o.write(o.tls.TakeOut())
+}
+
+// abort closes the connection, queued queries are dropped.
+func (o *dnsStream) abort() {
+	if o.socket != nil {
+		o.socket.Close()
+	}
+	o.reset()
+}
+
+func (o *dnsStream) reset() {
+	o.stats().pendingDropped += uint64(len(o.pending))
+	o.pending = nil
+	if o.tls != nil {
+		o.tls.Close()
+		o.tls = nil
+	}
+	o.socket = nil
+	o.connected = false
+	o.ready = false
+}
+
+// OnRxEvent completes the ISocketCb interface.
+func (o *dnsStream) OnRxEvent(event transport.SocketEventType) {
+	if o.socket == nil {
+		return
+	}
+	if event&transport.SocketEventConnected > 0 {
+		o.connected = true
+		o.stats().tcpConnect++
+		if o.mode == DnsTransportTcp {
+			o.onReady()
+		} else {
+			o.startTls()
+		}
+	}
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+	if event&transport.SocketClosed > 0 {
+		if !o.connected {
+			o.stats().tcpConnectErr++
+		} else {
+			o.stats().tcpClosed++
+		}
+		o.reset()
+	}
+}
+
+// OnRxData completes the ISocketCb interface.
+func (o *dnsStream) OnRxData(d []byte) {
+	o.plug.stats.rxBytes += uint64(len(d))
+	if o.tls != nil {
+		evs, err := o.tls.Feed(d)
+		if err != nil {
+			o.stats().tlsErr++
+			o.abort()
+			return
+		}
+		o.handleTlsEvents(evs)
+		return
+	}
+	o.onData(d)
+}
+
+// OnTxEvent completes the ISocketCb interface.
+func (o *dnsStream) OnTxEvent(event transport.SocketEventType) {
+	if event&transport.SocketTxMore > 0 && o.drain && o.socket != nil {
+		o.drain = false
+		b := o.txq
+		o.txq = nil
+		o.write(b)
+	}
+}
+
+// onData handles the plain stream, after TLS if any.
+func (o *dnsStream) onData(d []byte) {
+	if len(o.rx)+len(d) > maxStreamBuffer {
+		o.stats().streamParseErr++
+		o.abort()
+		return
+	}
+	o.rx = append(o.rx, d...)
+	for o.socket != nil {
+		var msg []byte
+		var n int
+		if o.mode == DnsTransportDoH {
+			var err error
+			msg, n, err = o.parseHttpResponse(o.rx)
+			if err != nil {
+				o.stats().streamParseErr++
+				o.abort()
+				return
+			}
+		} else {
+			msg, n = dnsUnframe(o.rx)
+		}
+		if n == 0 {
+			break
+		}
+		if msg != nil {
+			switch o.mode {
+			case DnsTransportTcp:
+				o.stats().pktRxTcpResponse++
+			case DnsTransportDoT:
+				o.stats().pktRxDotResponse++
+			case DnsTransportDoH:
+				o.stats().pktRxDohResponse++
+			}
+			o.plug.handleResponse(msg, o.mode)
+		}
+		o.rx = o.rx[n:]
+	}
+	if len(o.rx) == 0 {
+		o.rx = nil
+	}
+}
+
+// parseHttpResponse parses a single HTTP/1.1 response. It returns the body if the status
+// is 200, nil otherwise, and the number of bytes consumed (0 if the response is incomplete).
+func (o *dnsStream) parseHttpResponse(b []byte) ([]byte, int, error) {
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, 0, nil
+	}
+	lines := strings.Split(string(b[:end]), "\r\n")
+	status := strings.SplitN(lines[0], " ", 3)
+	if len(status) < 2 || !strings.HasPrefix(status[0], "HTTP/1.") {
+		return nil, 0, fmt.Errorf("invalid status line %q", lines[0])
+	}
+	code, err := strconv.Atoi(status[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid status code %q", status[1])
+	}
+	contentLen := -1
+	chunked := false
+	for _, l := range lines[1:] {
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "content-length":
+			if contentLen, err = strconv.Atoi(value); err != nil || contentLen < 0 {
+				return nil, 0, fmt.Errorf("invalid content-length %q", value)
+			}
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		}
+	}
+
+	var body []byte
+	n := end + 4
+	if chunked {
+		for {
+			eol := bytes.Index(b[n:], []byte("\r\n"))
+			if eol < 0 {
+				return nil, 0, nil
+			}
+			size, err := strconv.ParseUint(strings.SplitN(string(b[n:n+eol]), ";", 2)[0], 16, 32)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid chunk size")
+			}
+			n += eol + 2
+			if len(b) < n+int(size)+2 {
+				return nil, 0, nil
+			}
+			body = append(body, b[n:n+int(size)]...)
+			n += int(size) + 2
+			if size == 0 {
+				break
+			}
+		}
+	} else {
+		if contentLen < 0 {
+			return nil, 0, fmt.Errorf("response without content-length")
+		}
+		if len(b) < n+contentLen {
+			return nil, 0, nil
+		}
+		body = b[n : n+contentLen]
+		n += contentLen
+	}
+
+	if code != 200 {
+		o.stats().dohHttpErr++
+		return nil, n, nil
+	}
+	return body, n, nil
+}
+
+// buildDohRequest builds the HTTP/1.1 request of a DoH query.
+func (o *PluginDnsClient) buildDohRequest(msg []byte) []byte {
+	var b bytes.Buffer
+	if o.params.DohMethod == "GET" {
+		fmt.Fprintf(&b, "GET %s?dns=%s HTTP/1.1\r\n", o.params.DohPath, base64.RawURLEncoding.EncodeToString(msg))
+		fmt.Fprintf(&b, "Host: %s\r\nAccept: %s\r\n\r\n", o.dohHost, DnsMessageMediaType)
+		return b.Bytes()
+	}
+	fmt.Fprintf(&b, "POST %s HTTP/1.1\r\n", o.params.DohPath)
+	fmt.Fprintf(&b, "Host: %s\r\nAccept: %s\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n",
+		o.dohHost, DnsMessageMediaType, DnsMessageMediaType, len(msg))
+	b.Write(msg)
+	return b.Bytes()
+}
+
+// buildTlsConfig builds the TLS configuration of DoT/DoH from the init Json.
+func (o *PluginDnsClient) buildTlsConfig(serverIP net.IP) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: o.params.TlsServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverIP.String()
+	}
+	if o.params.Transport == DnsTransportDoH {
+		cfg.NextProtos = []string{"http/1.1"}
+	} else {
+		cfg.NextProtos = []string{"dot"}
+	}
+	if !o.params.TlsVerify {
+		cfg.InsecureSkipVerify = true
+		return cfg, nil
+	}
+	if o.params.TlsCa != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(o.params.TlsCa)) {
+			return nil, fmt.Errorf("invalid tls_ca")
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// dnsServerFlow is a TCP connection accepted by the name server.
+type dnsServerFlow struct {
+	plug   *PluginDnsClient
+	socket transport.SocketApi
+	rx     []byte
+}
+
+// OnRxEvent completes the ISocketCb interface.
+func (o *dnsServerFlow) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+}
+
+// OnRxData completes the ISocketCb interface.
+func (o *dnsServerFlow) OnRxData(d []byte) {
+	o.plug.stats.rxBytes += uint64(len(d))
+	if len(o.rx)+len(d) > maxStreamBuffer {
+		o.plug.transStats.pktRxQueryBadFrame++
+		o.socket.Close()
+		return
+	}
+	o.rx = append(o.rx, d...)
+	for {
+		msg, n := dnsUnframe(o.rx)
+		if n == 0 {
+			break
+		}
+		o.plug.transStats.pktRxTcpQuery++
+		o.plug.handleQuery(msg, o.socket)
+		o.rx = o.rx[n:]
+	}
+	if len(o.rx) == 0 {
+		o.rx = nil
+	}
+}
+
+// OnTxEvent completes the ISocketCb interface.
+func (o *dnsServerFlow) OnTxEvent(event transport.SocketEventType) {}
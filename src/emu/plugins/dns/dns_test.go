@@ -8,14 +8,29 @@ tree.
 package dns
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"emu/core"
+	utils "emu/plugins/dns_utils"
+	"emu/plugins/tls_utils"
 	"emu/plugins/transport"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"flag"
 	"fmt"
+	"math/big"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +41,7 @@ var monitor int
 type DnsTestBase struct {
 	testname     string
 	dropAll      bool
+	routeByIp    bool
 	monitor      bool
 	capture      bool
 	duration     time.Duration
@@ -47,7 +63,8 @@ type DnsTestCb func(tctx *core.CThreadCtx, test *DnsTestBase) int
 
 // VethDnsSim represents an Dns veth for simulation.
 type VethDnsSim struct {
-	DropAll bool
+	DropAll   bool
+	RouteByIp bool
 }
 
 // ProcessTxToRx decides either to drop packets or support a loopback arch.
@@ -56,6 +73,13 @@ func (o *VethDnsSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 		m.FreeMbuf()
 		return nil
 	}
+	if o.RouteByIp {
+		// Deliver IPv4 16.0.a.b to the client with MAC 00:00:01:00:a:b, so both sides get the packets.
+		p := m.GetData()
+		if len(p) >= 34 && binary.BigEndian.Uint16(p[12:14]) == uint16(layers.EthernetTypeIPv4) {
+			copy(p[0:6], []byte{0, 0, 1, 0, p[32], p[33]})
+		}
+	}
 	// In case we don't DropAll, we loop back
 	return m
 }
@@ -65,6 +89,7 @@ func (o *DnsTestBase) Run(t *testing.T, compare bool) {
 
 	var simVeth VethDnsSim
 	simVeth.DropAll = o.dropAll
+	simVeth.RouteByIp = o.routeByIp
 	var simrx core.VethIFSim
 	simrx = &simVeth
 
@@ -1208,6 +1233,604 @@ func TestPluginDns21(t *testing.T) {
 	a.Run(t, true)
 }
 
+func getServerInitJsonBig() [][]byte {
+	// 40 A records, the response doesn't fit in 512 bytes
+	answers := ""
+	for i := 0; i < 40; i++ {
+		if i > 0 {
+			answers += ","
+		}
+		answers += fmt.Sprintf(`{"type": "A", "class": "IN", "answer": "72.163.4.%v"}`, i+1)
+	}
+	return [][]byte{[]byte(fmt.Sprintf(`{
+		"name_server": true,
+		"database": {
+			"big.cisco.com": [%v],
+			"cisco.com": [
+				{
+					"type": "A",
+					"class": "IN",
+					"answer": "72.163.4.185"
+				}
+			]
+		}
+	}`, answers))}
+}
+
+func TestPluginDns22(t *testing.T) {
+
+	// Test Arch - Two Clients. First one is client, second one is server.
+	// query UDP -> truncated response (TC) -> query TCP -> full response
+
+	initJsonClient := [][]byte{[]byte(`{
+		"dns_server_ip": "16.0.0.1"
+	}`)}
+	var initJsonArray = [][][]byte{initJsonClient, getServerInitJsonBig()}
+
+	query := `[{"name": "big.cisco.com"}]`
+
+	a := &DnsTestBase{
+		testname:     "dns22",
+		dropAll:      false,
+		routeByIp:    true,
+		monitor:      true,
+		capture:      true,
+		initJSON:     initJsonArray,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		query:        query,
+		cb:           queryCb,
+		forceDGW:     true,
+		ForcedgMac:   core.MACKey{0, 0, 1, 0, 0, 1},
+	}
+	a.Run(t, true)
+}
+
+func TestPluginDns23(t *testing.T) {
+
+	// Test Arch - Two Clients. First one is client, second one is server.
+	// Transport TCP, the response is not truncated.
+
+	initJsonClient := [][]byte{[]byte(`{
+		"dns_server_ip": "16.0.0.1",
+		"transport": "tcp"
+	}`)}
+	var initJsonArray = [][][]byte{initJsonClient, getServerInitJsonBig()}
+
+	query := `[{"name": "cisco.com"}, {"name": "big.cisco.com"}]`
+
+	a := &DnsTestBase{
+		testname:     "dns23",
+		dropAll:      false,
+		routeByIp:    true,
+		monitor:      true,
+		capture:      true,
+		initJSON:     initJsonArray,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		query:        query,
+		cb:           queryCb,
+		forceDGW:     true,
+		ForcedgMac:   core.MACKey{0, 0, 1, 0, 0, 1},
+	}
+	a.Run(t, true)
+}
+
+// dnsTlsTestServer answers DoT/DoH queries from the database of a name server.
+type dnsTlsTestServer struct {
+	plug *PluginDnsClient
+	cfg  *tls.Config
+	doh  bool
+}
+
+// dnsTlsTestFlow is a single DoT/DoH connection of dnsTlsTestServer.
+type dnsTlsTestFlow struct {
+	srv     *dnsTlsTestServer
+	socket  transport.SocketApi
+	tls     *tls_utils.TlsSession
+	started bool
+	rx      []byte
+}
+
+func (o *dnsTlsTestServer) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return &dnsTlsTestFlow{srv: o, socket: socket, tls: tls_utils.NewTlsSession(o.cfg, true)}
+}
+
+func (o *dnsTlsTestFlow) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+	if event&transport.SocketClosed > 0 {
+		o.tls.Close()
+	}
+}
+
+func (o *dnsTlsTestFlow) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *dnsTlsTestFlow) OnRxData(d []byte) {
+	if !o.started {
+		o.started = true
+		o.tls.Start()
+	}
+	evs, err := o.tls.Feed(d)
+	if err != nil {
+		return
+	}
+	for _, ev := range evs {
+		o.rx = append(o.rx, ev.Data...)
+	}
+	for {
+		var query []byte
+		var n int
+		if o.srv.doh {
+			query, n = o.parseHttpRequest()
+		} else {
+			query, n = dnsUnframe(o.rx)
+		}
+		if n == 0 {
+			break
+		}
+		o.rx = o.rx[n:]
+		o.answer(query)
+	}
+	o.socket.Write(o.tls.TakeOut())
+}
+
+// parseHttpRequest returns the DoH query of a POST or GET request.
+func (o *dnsTlsTestFlow) parseHttpRequest() ([]byte, int) {
+	end := bytes.Index(o.rx, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, 0
+	}
+	lines := strings.Split(string(o.rx[:end]), "\r\n")
+	req := strings.Fields(lines[0])
+	if req[0] == "GET" {
+		q := strings.SplitN(req[1], "?dns=", 2)
+		query, _ := base64.RawURLEncoding.DecodeString(q[1])
+		return query, end + 4
+	}
+	contentLen := 0
+	for _, l := range lines[1:] {
+		if strings.HasPrefix(strings.ToLower(l), "content-length:") {
+			contentLen, _ = strconv.Atoi(strings.TrimSpace(l[len("content-length:"):]))
+		}
+	}
+	if len(o.rx) < end+4+contentLen {
+		return nil, 0
+	}
+	return o.rx[end+4 : end+4+contentLen], end + 4 + contentLen
+}
+
+func (o *dnsTlsTestFlow) answer(query []byte) {
+	var dns layers.DNS
+	if dns.DecodeFromBytes(query, gopacket.NilDecodeFeedback) != nil {
+		return
+	}
+	plug := o.srv.plug
+	resp := plug.dnsPktBuilder.BuildResponsePkt(dns.ID, plug.BuildAnswers(dns.Questions), dns.Questions, layers.DNSResponseCodeNoErr)
+	if o.srv.doh {
+		hdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", DnsMessageMediaType, len(resp))
+		o.tls.Write(append([]byte(hdr), resp...))
+	} else {
+		o.tls.Write(dnsFrame(resp))
+	}
+}
+
+// newTestTlsConfig creates a server TLS configuration with a self signed certificate.
+func newTestTlsConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.emu.test"},
+		DNSNames:     []string{"dns.emu.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"dot", "http/1.1"},
+	}
+}
+
+// runDnsTlsTest queries the name server over DoT/DoH and returns the transport counters of the resolver.
+func runDnsTlsTest(t *testing.T, clientJson string) (DnsTransportStats, []*utils.DnsCacheEntry) {
+	var simVeth VethDnsSim
+	simVeth.RouteByIp = true
+	var simrx core.VethIFSim = &simVeth
+	test := &DnsTestBase{
+		initJSON:     [][][]byte{{[]byte(clientJson)}, getServerInitJsonBig()},
+		clientsToSim: 2,
+		query:        `[{"name": "cisco.com"}, {"name": "big.cisco.com"}]`,
+		forceDGW:     true,
+		ForcedgMac:   core.MACKey{0, 0, 1, 0, 0, 1},
+	}
+	tctx, _ := createSimulationEnv(&simrx, test)
+	defer tctx.Delete()
+	queryCb(tctx, test)
+
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := tctx.GetNs(&key)
+	client := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0}).PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+	server := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 1}).PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+
+	cfg := newTestTlsConfig(t)
+	transportCtx := transport.GetTransportCtx(server.Client)
+	transportCtx.Listen("tcp", ":853", &dnsTlsTestServer{plug: server, cfg: cfg})
+	transportCtx.Listen("tcp", ":443", &dnsTlsTestServer{plug: server, cfg: cfg, doh: true})
+
+	tctx.MainLoopSim(10 * time.Second)
+	client.cache.IterReset()
+	entries, _ := client.cache.GetNext(100)
+	stats := client.transStats
+	if client.stream.tls != nil {
+		client.stream.tls.Close()
+	}
+	return stats, entries
+}
+
+func TestPluginDnsDoT(t *testing.T) {
+	stats, entries := runDnsTlsTest(t, `{
+		"dns_server_ip": "16.0.0.1",
+		"transport": "dot",
+		"tls_server_name": "dns.emu.test"
+	}`)
+	if stats.tlsHandshakeOk != 1 || stats.pktTxDotQuery != 1 || stats.pktRxDotResponse != 1 {
+		t.Fatalf("bad DoT counters %+v", stats)
+	}
+	if len(entries) != 41 {
+		t.Fatalf("want 41 cache entries, have %v", len(entries))
+	}
+}
+
+func TestPluginDnsDoH(t *testing.T) {
+	for _, method := range []string{"POST", "GET"} {
+		stats, entries := runDnsTlsTest(t, fmt.Sprintf(`{
+			"dns_server_ip": "16.0.0.1",
+			"transport": "doh",
+			"doh_method": "%s"
+		}`, method))
+		if stats.tlsHandshakeOk != 1 || stats.pktTxDohQuery != 1 || stats.pktRxDohResponse != 1 || stats.dohHttpErr != 0 {
+			t.Fatalf("bad DoH %s counters %+v", method, stats)
+		}
+		if len(entries) != 41 {
+			t.Fatalf("want 41 cache entries, have %v", len(entries))
+		}
+	}
+}
+
+func TestPluginDnsDoTVerify(t *testing.T) {
+	// The self signed certificate is not trusted, the handshake must fail.
+	stats, entries := runDnsTlsTest(t, `{
+		"dns_server_ip": "16.0.0.1",
+		"transport": "dot",
+		"tls_server_name": "dns.emu.test",
+		"tls_verify": true
+	}`)
+	if stats.tlsHandshakeErr != 1 || stats.pktRxDotResponse != 0 || stats.pendingDropped != 1 {
+		t.Fatalf("bad DoT counters %+v", stats)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("want empty cache, have %v entries", len(entries))
+	}
+}
+
+// testZone is a zone file of the authoritative name server tests.
+const testZone = `
+$ORIGIN emu.test.
+$TTL 1h
+@       IN  SOA ns1 hostmaster (
+                2021010101 ; serial
+                3600       ; refresh
+                600        ; retry
+                86400      ; expire
+                300 )      ; minimum
+        IN  NS    ns1
+        IN  MX    10 mail
+        IN  CAA   0 issue "letsencrypt.org"
+ns1     IN  A     16.0.0.1
+mail    IN  A     16.0.0.10
+        IN  AAAA  2001:db8::10
+www     IN  CNAME web
+web     300 IN A  16.0.0.20
+_sip._udp IN SRV  0 5 5060 sip.emu.test.
+sip     IN  A     16.0.0.30
+*.dyn   IN  A     16.0.0.40
+txt     IN  TXT   "desc=EMU" "gen=trex"
+loop1   IN  CNAME loop2
+loop2   IN  CNAME loop1
+`
+
+// newTestNameServer creates a name server plugin with the test zone, without a client.
+func newTestNameServer(t *testing.T) *PluginDnsClient {
+	o := new(PluginDnsClient)
+	o.params.NameServer = true
+	o.params.AllowUpdate = true
+	o.db = make(DnsDatabase)
+	if err := o.ImportZone(testZone, ""); err != nil {
+		t.Fatalf("zone import failed: %v", err)
+	}
+	return o
+}
+
+func TestDnsZoneImport(t *testing.T) {
+	db, err := ParseZoneFile(testZone, "")
+	if err != nil {
+		t.Fatalf("zone import failed: %v", err)
+	}
+	expected := map[string][]DnsEntry{
+		"emu.test": {
+			{DnsType: "SOA", DnsClass: "IN", Answer: "ns1.emu.test hostmaster.emu.test 2021010101 3600 600 86400 300", TTL: 3600},
+			{DnsType: "NS", DnsClass: "IN", Answer: "ns1.emu.test", TTL: 3600},
+			{DnsType: "MX", DnsClass: "IN", Answer: "10 mail.emu.test", TTL: 3600},
+			{DnsType: "CAA", DnsClass: "IN", Answer: `0 issue "letsencrypt.org"`, TTL: 3600},
+		},
+		"mail.emu.test":      {{DnsType: "A", DnsClass: "IN", Answer: "16.0.0.10", TTL: 3600}, {DnsType: "AAAA", DnsClass: "IN", Answer: "2001:db8::10", TTL: 3600}},
+		"www.emu.test":       {{DnsType: "CNAME", DnsClass: "IN", Answer: "web.emu.test", TTL: 3600}},
+		"web.emu.test":       {{DnsType: "A", DnsClass: "IN", Answer: "16.0.0.20", TTL: 300}},
+		"_sip._udp.emu.test": {{DnsType: "SRV", DnsClass: "IN", Answer: "0 5 5060 sip.emu.test", TTL: 300}},
+		"*.dyn.emu.test":     {{DnsType: "A", DnsClass: "IN", Answer: "16.0.0.40", TTL: 300}},
+		"txt.emu.test":       {{DnsType: "TXT", DnsClass: "IN", Answer: "desc=EMU, gen=trex", TTL: 300}},
+	}
+	for name, want := range expected {
+		have := db[name]
+		if fmt.Sprint(have) != fmt.Sprint(want) {
+			t.Errorf("bad entries of %v, want %v, have %v", name, want, have)
+		}
+	}
+	if len(db) != 11 {
+		t.Errorf("want 11 names, have %v", len(db))
+	}
+
+	for _, bad := range []string{
+		"www IN A 1.1.1.1",                        // relative name without origin
+		"$ORIGIN emu.test.\nwww IN A 1.1.1",       // bad address
+		"$ORIGIN emu.test.\nwww IN A 2001:db8::1", // AAAA address in A
+		"$ORIGIN emu.test.\n@ IN SOA ns1 host ( 1 2 3",
+		"$ORIGIN emu.test.\nwww IN HINFO a b",
+		"$INCLUDE other.zone",
+	} {
+		if _, err := ParseZoneFile(strings.ReplaceAll(bad, "\\n", "\n"), ""); err == nil {
+			t.Errorf("zone %q should fail", bad)
+		}
+	}
+}
+
+// resolveTest resolves a single question in the test name server.
+func resolveTest(o *PluginDnsClient, name string, dnsType layers.DNSType) *dnsResult {
+	q := []layers.DNSQuestion{{Name: []byte(name), Type: dnsType, Class: layers.DNSClassIN}}
+	return o.buildResult(q)
+}
+
+// rrStrings returns the records as entries, to compare easily.
+func rrStrings(rrs []layers.DNSResourceRecord) []string {
+	var r []string
+	for i := range rrs {
+		e, _ := buildEntry(&rrs[i])
+		r = append(r, fmt.Sprintf("%v %v %v %v", string(rrs[i].Name), e.TTL, e.DnsType, e.Answer))
+	}
+	return r
+}
+
+func TestDnsResolve(t *testing.T) {
+	o := newTestNameServer(t)
+	soa := "emu.test 300 SOA ns1.emu.test hostmaster.emu.test 2021010101 3600 600 86400 300"
+
+	tests := []struct {
+		name        string
+		dnsType     layers.DNSType
+		rcode       layers.DNSResponseCode
+		answers     []string
+		authorities []string
+		additionals []string
+	}{
+		{"web.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]string{"web.emu.test 300 A 16.0.0.20"}, nil, nil},
+		{"WWW.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]string{"WWW.emu.test 3600 CNAME web.emu.test", "web.emu.test 300 A 16.0.0.20"}, nil, nil},
+		{"www.emu.test", layers.DNSTypeCNAME, layers.DNSResponseCodeNoErr,
+			[]string{"www.emu.test 3600 CNAME web.emu.test"}, nil, nil},
+		{"emu.test", layers.DNSTypeMX, layers.DNSResponseCodeNoErr,
+			[]string{"emu.test 3600 MX 10 mail.emu.test"}, nil,
+			[]string{"mail.emu.test 3600 A 16.0.0.10", "mail.emu.test 3600 AAAA 2001:db8::10"}},
+		{"_sip._udp.emu.test", layers.DNSTypeSRV, layers.DNSResponseCodeNoErr,
+			[]string{"_sip._udp.emu.test 300 SRV 0 5 5060 sip.emu.test"}, nil,
+			[]string{"sip.emu.test 300 A 16.0.0.30"}},
+		{"emu.test", layers.DNSTypeCAA, layers.DNSResponseCodeNoErr,
+			[]string{`emu.test 3600 CAA 0 issue "letsencrypt.org"`}, nil, nil},
+		{"host1.dyn.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]string{"host1.dyn.emu.test 300 A 16.0.0.40"}, nil, nil},
+		{"a.b.dyn.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]string{"a.b.dyn.emu.test 300 A 16.0.0.40"}, nil, nil},
+		{"nohost.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNXDomain,
+			nil, []string{soa}, nil},
+		{"web.emu.test", layers.DNSTypeAAAA, layers.DNSResponseCodeNoErr,
+			nil, []string{soa}, nil},
+		{"loop1.emu.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]string{"loop1.emu.test 300 CNAME loop2.emu.test", "loop2.emu.test 300 CNAME loop1.emu.test"}, nil, nil},
+		{"cisco.com", layers.DNSTypeA, layers.DNSResponseCodeNXDomain,
+			nil, nil, nil},
+	}
+	for _, test := range tests {
+		res := resolveTest(o, test.name, test.dnsType)
+		if res.rcode != test.rcode {
+			t.Errorf("%v %v: want rcode %v, have %v", test.name, test.dnsType, test.rcode, res.rcode)
+		}
+		if fmt.Sprint(rrStrings(res.answers)) != fmt.Sprint(test.answers) {
+			t.Errorf("%v %v: want answers %v, have %v", test.name, test.dnsType, test.answers, rrStrings(res.answers))
+		}
+		if fmt.Sprint(rrStrings(res.authorities)) != fmt.Sprint(test.authorities) {
+			t.Errorf("%v %v: want authorities %v, have %v", test.name, test.dnsType, test.authorities, rrStrings(res.authorities))
+		}
+		if fmt.Sprint(rrStrings(res.additionals)) != fmt.Sprint(test.additionals) {
+			t.Errorf("%v %v: want additionals %v, have %v", test.name, test.dnsType, test.additionals, rrStrings(res.additionals))
+		}
+	}
+	if o.stats.cnameChased != 3 || o.stats.wildcardAnswers != 2 {
+		t.Errorf("bad counters %+v", o.stats)
+	}
+}
+
+// buildUpdate serializes and decodes a dynamic update of the test zone.
+func buildUpdate(t *testing.T, prereqs, updates []layers.DNSResourceRecord) *layers.DNS {
+	msg := &layers.DNS{
+		ID:          0x2136,
+		OpCode:      layers.DNSOpCodeUpdate,
+		Questions:   []layers.DNSQuestion{{Name: []byte("emu.test"), Type: layers.DNSTypeSOA, Class: layers.DNSClassIN}},
+		Answers:     prereqs,
+		Authorities: updates,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, msg); err != nil {
+		t.Fatalf("serialize update failed: %v", err)
+	}
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatalf("decode update failed: %v", err)
+	}
+	return &dns
+}
+
+func TestDnsUpdate(t *testing.T) {
+	o := newTestNameServer(t)
+	host := []byte("host.emu.test")
+	hostA := layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IP{16, 0, 0, 50}}
+
+	// Add a host, prerequisite: the name is not in use.
+	notInUse := layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeANY, Class: layers.DNSClassNone}
+	dns := buildUpdate(t, []layers.DNSResourceRecord{notInUse}, []layers.DNSResourceRecord{hostA})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNoErr {
+		t.Fatalf("add: want NoErr, have %v", rcode)
+	}
+	res := resolveTest(o, "host.emu.test", layers.DNSTypeA)
+	if fmt.Sprint(rrStrings(res.answers)) != "[host.emu.test 60 A 16.0.0.50]" {
+		t.Fatalf("bad answers after add %v", rrStrings(res.answers))
+	}
+	_, soa := o.findSoa("emu.test")
+	if !strings.Contains(soa.Answer, " 2021010102 ") {
+		t.Fatalf("serial was not incremented %v", soa.Answer)
+	}
+
+	// An update with an explicit SOA keeps its serial.
+	newSoa := layers.DNSResourceRecord{Name: []byte("emu.test"), Type: layers.DNSTypeSOA, Class: layers.DNSClassIN, TTL: 3600,
+		SOA: layers.DNSSOA{MName: []byte("ns1.emu.test"), RName: []byte("hostmaster.emu.test"), Serial: 2021010200,
+			Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300}}
+	hostAAAA := layers.DNSResourceRecord{Name: []byte("host6.emu.test"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: 60,
+		IP: net.ParseIP("2001:db8::50")}
+	dns = buildUpdate(t, nil, []layers.DNSResourceRecord{hostAAAA, newSoa})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNoErr {
+		t.Fatalf("soa: want NoErr, have %v", rcode)
+	}
+	if _, soa = o.findSoa("emu.test"); !strings.Contains(soa.Answer, " 2021010200 ") {
+		t.Fatalf("serial of an explicit SOA should be kept %v", soa.Answer)
+	}
+
+	// The same update fails now, the name is in use.
+	dns = buildUpdate(t, []layers.DNSResourceRecord{notInUse}, []layers.DNSResourceRecord{hostA})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeYXDomain {
+		t.Fatalf("add again: want YXDomain, have %v", rcode)
+	}
+
+	// Replace the address, prerequisite: the RRset exists with this value.
+	prereq := hostA
+	prereq.TTL = 0
+	delRRset := layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeA, Class: layers.DNSClassAny}
+	hostA2 := hostA
+	hostA2.IP = net.IP{16, 0, 0, 51}
+	dns = buildUpdate(t, []layers.DNSResourceRecord{prereq}, []layers.DNSResourceRecord{delRRset, hostA2})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNoErr {
+		t.Fatalf("replace: want NoErr, have %v", rcode)
+	}
+	res = resolveTest(o, "host.emu.test", layers.DNSTypeA)
+	if fmt.Sprint(rrStrings(res.answers)) != "[host.emu.test 60 A 16.0.0.51]" {
+		t.Fatalf("bad answers after replace %v", rrStrings(res.answers))
+	}
+
+	// The RRset doesn't have the old value anymore.
+	dns = buildUpdate(t, []layers.DNSResourceRecord{prereq}, nil)
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNXRRSet {
+		t.Fatalf("prerequisite: want NXRRSet, have %v", rcode)
+	}
+
+	// Delete a single record, and all the records of the name.
+	delA := hostA2
+	delA.Class = layers.DNSClassNone
+	delA.TTL = 0
+	dns = buildUpdate(t, nil, []layers.DNSResourceRecord{delA})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNoErr {
+		t.Fatalf("delete: want NoErr, have %v", rcode)
+	}
+	if res = resolveTest(o, "host.emu.test", layers.DNSTypeA); res.rcode != layers.DNSResponseCodeNXDomain {
+		t.Fatalf("delete: want NXDomain, have %v", res.rcode)
+	}
+	delAll := layers.DNSResourceRecord{Name: []byte("emu.test"), Type: layers.DNSTypeANY, Class: layers.DNSClassAny}
+	dns = buildUpdate(t, nil, []layers.DNSResourceRecord{delAll})
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNoErr {
+		t.Fatalf("delete all: want NoErr, have %v", rcode)
+	}
+	entries, _ := o.GetDomainEntries("emu.test")
+	if len(entries) != 2 {
+		t.Fatalf("the SOA and NS of the apex should be kept, have %v", entries)
+	}
+
+	// Names out of the zone, unknown zones and disabled updates.
+	out := hostA
+	out.Name = []byte("host.cisco.com")
+	if rcode := o.update(buildUpdate(t, nil, []layers.DNSResourceRecord{out})); rcode != layers.DNSResponseCodeNotZone {
+		t.Fatalf("out of zone: want NotZone, have %v", rcode)
+	}
+	dns = buildUpdate(t, nil, nil)
+	dns.Questions[0].Name = []byte("cisco.com")
+	if rcode := o.update(dns); rcode != layers.DNSResponseCodeNotAuth {
+		t.Fatalf("unknown zone: want NotAuth, have %v", rcode)
+	}
+	o.params.AllowUpdate = false
+	if rcode := o.update(buildUpdate(t, nil, []layers.DNSResourceRecord{hostA})); rcode != layers.DNSResponseCodeRefused {
+		t.Fatalf("disabled: want Refused, have %v", rcode)
+	}
+}
+
+func TestPluginDns24(t *testing.T) {
+
+	// Test Arch - Two Clients. First one is client, second one is server.
+	// The server database is a zone file: CNAME chasing, MX with additional records, NXDOMAIN with SOA.
+
+	initJsonClient := [][]byte{[]byte(`{
+		"dns_server_ip": "16.0.0.1"
+	}`)}
+	zone, _ := json.Marshal(testZone)
+	initJsonServer := [][]byte{[]byte(fmt.Sprintf(`{
+		"name_server": true,
+		"zones": [%s]
+	}`, zone))}
+	var initJsonArray = [][][]byte{initJsonClient, initJsonServer}
+
+	query := `[{"name": "www.emu.test"}, {"name": "emu.test", "dns_type": "MX"}, {"name": "nohost.emu.test"}]`
+
+	a := &DnsTestBase{
+		testname:     "dns24",
+		dropAll:      false,
+		routeByIp:    true,
+		monitor:      true,
+		capture:      true,
+		initJSON:     initJsonArray,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		query:        query,
+		cb:           queryCb,
+		forceDGW:     true,
+		ForcedgMac:   core.MACKey{0, 0, 1, 0, 0, 1},
+	}
+	a.Run(t, true)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -0,0 +1,310 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dns
+
+import (
+	"emu/plugins/transport"
+	"external/google/gopacket/layers"
+	"strconv"
+	"strings"
+)
+
+/*
+Dynamic updates - RFC 2136 - https://datatracker.ietf.org/doc/html/rfc2136
+
+The sections of an update are decoded as the sections of a query:
+
+	Zone         -> Questions    the zone to update, type SOA
+	Prerequisite -> Answers      RRsets that must or must not exist
+	Update       -> Authorities  RRs to add or delete
+	Additional   -> Additionals  ignored, TSIG is not supported
+
+The zone must have a SOA in the database. An update is applied only if all the prerequisites
+hold, and then the serial of the SOA is incremented. Updates are refused unless allow_update is set.
+*/
+
+// handleUpdate handles a dynamic update received by the name server on socket.
+func (o *PluginDnsClient) handleUpdate(dns *layers.DNS, socket transport.SocketApi) {
+	o.stats.rxUpdates++
+	rcode := o.update(dns)
+	switch rcode {
+	case layers.DNSResponseCodeNoErr:
+		o.stats.updateApplied++
+	case layers.DNSResponseCodeRefused, layers.DNSResponseCodeNotAuth:
+		o.stats.updateRefused++
+	default:
+		o.stats.updateFailed++
+	}
+	data := o.dnsPktBuilder.BuildUpdateResponsePkt(dns.ID, dns.Questions, rcode)
+	o.write(data, socket)
+}
+
+// rrsetExists returns true if name has records of type dnsType, or any record if dnsType is ANY.
+func (o *PluginDnsClient) rrsetExists(name string, dnsType layers.DNSType) bool {
+	entries, _ := o.getEntries(name)
+	for i := range entries {
+		if dnsType == layers.DNSTypeANY || entries[i].DnsType == dnsType.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrerequisites checks the prerequisite section of an update, RFC 2136 section 3.2.
+func (o *PluginDnsClient) checkPrerequisites(prereqs []layers.DNSResourceRecord, zone string) layers.DNSResponseCode {
+	// RRsets that must exist with the exact value, by name and type.
+	rrsets := make(map[string]map[DnsEntry]bool)
+	for i := range prereqs {
+		rr := &prereqs[i]
+		name := string(rr.Name)
+		if rr.TTL != 0 {
+			return layers.DNSResponseCodeFormErr
+		}
+		if !isInZone(name, zone) {
+			return layers.DNSResponseCodeNotZone
+		}
+		switch rr.Class {
+		case layers.DNSClassAny:
+			if rr.DataLength != 0 {
+				return layers.DNSResponseCodeFormErr
+			}
+			if !o.rrsetExists(name, rr.Type) {
+				if rr.Type == layers.DNSTypeANY {
+					return layers.DNSResponseCodeNXDomain
+				}
+				return layers.DNSResponseCodeNXRRSet
+			}
+		case layers.DNSClassNone:
+			if rr.DataLength != 0 {
+				return layers.DNSResponseCodeFormErr
+			}
+			if o.rrsetExists(name, rr.Type) {
+				if rr.Type == layers.DNSTypeANY {
+					return layers.DNSResponseCodeYXDomain
+				}
+				return layers.DNSResponseCodeYXRRSet
+			}
+		case layers.DNSClassIN:
+			entry, err := buildEntry(rr)
+			if err != nil {
+				return layers.DNSResponseCodeFormErr
+			}
+			key := strings.ToLower(name) + "/" + entry.DnsType
+			if rrsets[key] == nil {
+				rrsets[key] = make(map[DnsEntry]bool)
+			}
+			rrsets[key][entry.key()] = true
+		default:
+			return layers.DNSResponseCodeFormErr
+		}
+	}
+	// The RRsets must be equal to the ones in the database.
+	for key, want := range rrsets {
+		i := strings.LastIndexByte(key, '/')
+		name, dnsType := key[:i], key[i+1:]
+		have := make(map[DnsEntry]bool)
+		entries, _ := o.getEntries(name)
+		for j := range entries {
+			if entries[j].DnsType == dnsType {
+				have[canonicalEntry(name, entries[j])] = true
+			}
+		}
+		if len(have) != len(want) {
+			return layers.DNSResponseCodeNXRRSet
+		}
+		for entry := range want {
+			if !have[entry] {
+				return layers.DNSResponseCodeNXRRSet
+			}
+		}
+	}
+	return layers.DNSResponseCodeNoErr
+}
+
+// prescanUpdates checks the update section before any change, RFC 2136 section 3.4.1.
+func (o *PluginDnsClient) prescanUpdates(updates []layers.DNSResourceRecord, zone string) layers.DNSResponseCode {
+	for i := range updates {
+		rr := &updates[i]
+		if !isInZone(string(rr.Name), zone) {
+			return layers.DNSResponseCodeNotZone
+		}
+		switch rr.Class {
+		case layers.DNSClassIN:
+			if _, err := buildEntry(rr); err != nil {
+				return layers.DNSResponseCodeFormErr
+			}
+		case layers.DNSClassAny:
+			if rr.TTL != 0 || rr.DataLength != 0 {
+				return layers.DNSResponseCodeFormErr
+			}
+		case layers.DNSClassNone:
+			if rr.TTL != 0 || rr.Type == layers.DNSTypeANY {
+				return layers.DNSResponseCodeFormErr
+			}
+			if _, err := buildEntry(rr); err != nil {
+				return layers.DNSResponseCodeFormErr
+			}
+		default:
+			return layers.DNSResponseCodeFormErr
+		}
+	}
+	return layers.DNSResponseCodeNoErr
+}
+
+// deleteEntries deletes the entries of name for which del returns true, returns true if some were deleted.
+func (o *PluginDnsClient) deleteEntries(name string, del func(entry *DnsEntry) bool) bool {
+	entries := o.db[name]
+	var valid []DnsEntry
+	for i := range entries {
+		if !del(&entries[i]) {
+			valid = append(valid, entries[i])
+		}
+	}
+	if len(valid) == len(entries) {
+		return false
+	}
+	if len(valid) > 0 {
+		o.db[name] = valid
+	} else {
+		delete(o.db, name)
+	}
+	return true
+}
+
+// applyUpdate applies a single record of the update section, RFC 2136 section 3.4.2.
+// Returns true if the database was changed.
+func (o *PluginDnsClient) applyUpdate(rr *layers.DNSResourceRecord, zone string) bool {
+	name := string(rr.Name)
+	if _, ok := o.db[name]; !ok {
+		if _, ok := o.db[strings.ToLower(name)]; ok {
+			name = strings.ToLower(name)
+		}
+	}
+	apex := strings.EqualFold(name, zone)
+	soa := layers.DNSTypeSOA.String()
+	ns := layers.DNSTypeNS.String()
+	cname := layers.DNSTypeCNAME.String()
+
+	switch rr.Class {
+	case layers.DNSClassIN:
+		entry, _ := buildEntry(rr)
+		switch rr.Type {
+		case layers.DNSTypeSOA:
+			// The SOA is replaced only at the apex and with a greater serial.
+			_, cur := o.findSoa(zone)
+			if !apex || cur == nil {
+				return false
+			}
+			curRR, err := buildRecord(zone, cur)
+			if err != nil || int32(rr.SOA.Serial-curRR.SOA.Serial) <= 0 { // serial arithmetic, RFC 1982
+				return false
+			}
+			o.deleteEntries(name, func(e *DnsEntry) bool { return e.DnsType == soa })
+		case layers.DNSTypeCNAME:
+			// A CNAME can't coexist with other data, a CNAME replaces the previous CNAME.
+			if o.rrsetExists(name, layers.DNSTypeANY) && !o.rrsetExists(name, layers.DNSTypeCNAME) {
+				return false
+			}
+			o.deleteEntries(name, func(e *DnsEntry) bool { return e.DnsType == cname })
+		default:
+			if o.rrsetExists(name, layers.DNSTypeCNAME) {
+				return false
+			}
+		}
+		entries, _ := o.getEntries(name)
+		for i := range entries {
+			if canonicalEntry(name, entries[i]) == entry.key() {
+				return false
+			}
+		}
+		o.AddDomainEntries(name, []DnsEntry{entry})
+		return true
+	case layers.DNSClassAny:
+		return o.deleteEntries(name, func(e *DnsEntry) bool {
+			if apex && (e.DnsType == soa || e.DnsType == ns) {
+				// The SOA and NS of the zone are kept.
+				return false
+			}
+			return rr.Type == layers.DNSTypeANY || e.DnsType == rr.Type.String()
+		})
+	case layers.DNSClassNone:
+		if rr.Type == layers.DNSTypeSOA {
+			return false
+		}
+		entry, _ := buildEntry(rr)
+		entry.DnsClass = layers.DNSClassIN.String()
+		if apex && rr.Type == layers.DNSTypeNS {
+			// The last NS of the zone is kept.
+			n := 0
+			entries, _ := o.getEntries(name)
+			for i := range entries {
+				if entries[i].DnsType == ns {
+					n++
+				}
+			}
+			if n <= 1 {
+				return false
+			}
+		}
+		return o.deleteEntries(name, func(e *DnsEntry) bool { return canonicalEntry(name, *e) == entry.key() })
+	}
+	return false
+}
+
+// incSerial increments the serial of the SOA of zone.
+func (o *PluginDnsClient) incSerial(zone string) {
+	_, entry := o.findSoa(zone)
+	if entry == nil {
+		return
+	}
+	f := strings.Fields(entry.Answer)
+	if len(f) != 7 {
+		return
+	}
+	serial, err := strconv.ParseUint(f[2], 10, 32)
+	if err != nil {
+		return
+	}
+	f[2] = strconv.FormatUint(uint64(uint32(serial+1)), 10) // serial arithmetic, RFC 1982
+	entry.Answer = strings.Join(f, " ")
+}
+
+// update processes a dynamic update and returns the response code.
+func (o *PluginDnsClient) update(dns *layers.DNS) layers.DNSResponseCode {
+	if !o.params.AllowUpdate {
+		return layers.DNSResponseCodeRefused
+	}
+	if len(dns.Questions) != 1 || dns.Questions[0].Type != layers.DNSTypeSOA {
+		return layers.DNSResponseCodeFormErr
+	}
+	zone, soa := o.findSoa(dnsName(string(dns.Questions[0].Name)))
+	if soa == nil || !strings.EqualFold(zone, dnsName(string(dns.Questions[0].Name))) {
+		return layers.DNSResponseCodeNotAuth
+	}
+	if rcode := o.checkPrerequisites(dns.Answers, zone); rcode != layers.DNSResponseCodeNoErr {
+		return rcode
+	}
+	if rcode := o.prescanUpdates(dns.Authorities, zone); rcode != layers.DNSResponseCodeNoErr {
+		return rcode
+	}
+	changed, soaSet := false, false
+	for i := range dns.Authorities {
+		rr := &dns.Authorities[i]
+		if o.applyUpdate(rr, zone) {
+			changed = true
+			if rr.Class == layers.DNSClassIN && rr.Type == layers.DNSTypeSOA {
+				soaSet = true
+			}
+		}
+	}
+	if changed && !soaSet {
+		// An explicit SOA sets the serial, RFC 2136 section 3.6.
+		o.incSerial(zone)
+	}
+	return layers.DNSResponseCodeNoErr
+}
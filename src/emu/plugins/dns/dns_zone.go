@@ -0,0 +1,785 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dns
+
+import (
+	utils "emu/plugins/dns_utils"
+	"errors"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+Authoritative data of the name server.
+
+Zone files are imported in the BIND master file format (RFC 1035 section 5): $ORIGIN and $TTL
+directives, '@', relative names, omitted owners, parentheses and comments. Each record becomes a
+DnsEntry whose answer is the presentation format of the RDATA with absolute names, for example:
+
+	A      72.163.4.185
+	CNAME  www.cisco.com
+	MX     10 mail.cisco.com
+	SRV    0 5 5060 sip.cisco.com
+	NS     ns1.cisco.com
+	SOA    ns1.cisco.com hostmaster.cisco.com 2021010101 3600 600 86400 300
+	CAA    0 issue "letsencrypt.org"
+	TXT    desc=Best place to work!, location=Israel
+
+Resolution of a question:
+	- the name is looked up as is, then as a wildcard of its closest encloser (RFC 4592)
+	- if the name has no record of the type but has a CNAME, the CNAME is added and its target is chased
+	- a name that doesn't exist in the class is answered with NXDOMAIN, and a name without records of
+	  the type with NOERROR, both with the SOA of the zone in the authority section (RFC 2308)
+	- the A/AAAA records of MX, SRV and NS targets are added in the additional section
+*/
+
+const (
+	DnsMaxCnameHops = 8 // Max CNAME records chased for a question
+)
+
+var errUnsupportedDnsType = errors.New("unsupported DNS type")
+
+// key returns the entry without TTL, two entries with the same key are the same record.
+func (o DnsEntry) key() DnsEntry {
+	o.TTL = 0
+	return o
+}
+
+// ttl returns the TTL of the entry in answers.
+func (o *DnsEntry) ttl() uint32 {
+	if o.TTL == 0 {
+		return DefaultDnsResponseTTL
+	}
+	return o.TTL
+}
+
+// dnsName returns a name as kept in the database, without the trailing dot.
+func dnsName(name string) string {
+	if len(name) > 1 {
+		return strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// isInZone returns true if name is zone or one of its subdomains.
+func isInZone(name, zone string) bool {
+	name = strings.ToLower(name)
+	zone = strings.ToLower(zone)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// parseUint16 parses a 16 bit field of the RDATA.
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	return uint16(v), err
+}
+
+// splitCaa splits the answer of a CAA entry into flags, tag and value.
+func splitCaa(answer string) (uint8, string, string, error) {
+	f := strings.SplitN(strings.TrimSpace(answer), " ", 3)
+	if len(f) != 3 {
+		return 0, "", "", fmt.Errorf("invalid CAA record %q", answer)
+	}
+	flags, err := strconv.ParseUint(f[0], 10, 8)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid CAA flags %q", f[0])
+	}
+	value := strings.TrimSpace(f[2])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return uint8(flags), f[1], value, nil
+}
+
+// buildRecord converts an entry of name in the database to a resource record.
+func buildRecord(name string, entry *DnsEntry) (layers.DNSResourceRecord, error) {
+	dnsType, err := layers.StringToDNSType(entry.DnsType)
+	if err != nil {
+		return layers.DNSResourceRecord{}, errUnsupportedDnsType
+	}
+	class, _ := layers.StringToDNSClass(entry.DnsClass)
+	rr := layers.DNSResourceRecord{
+		Name:  []byte(name),
+		Type:  dnsType,
+		Class: class,
+		TTL:   entry.ttl(),
+	}
+	f := strings.Fields(entry.Answer)
+	switch dnsType {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		rr.IP = net.ParseIP(entry.Answer)
+		if rr.IP == nil {
+			return rr, fmt.Errorf("invalid IP %q", entry.Answer)
+		}
+		if dnsType == layers.DNSTypeA {
+			rr.IP = rr.IP.To4()
+			if rr.IP == nil {
+				return rr, fmt.Errorf("invalid IPv4 %q", entry.Answer)
+			}
+		}
+	case layers.DNSTypePTR:
+		rr.PTR = []byte(dnsName(entry.Answer))
+	case layers.DNSTypeCNAME:
+		rr.CNAME = []byte(dnsName(entry.Answer))
+	case layers.DNSTypeNS:
+		rr.NS = []byte(dnsName(entry.Answer))
+	case layers.DNSTypeTXT:
+		rr.TXTs = utils.BuildTxtsFromString(entry.Answer)
+	case layers.DNSTypeMX:
+		if len(f) != 2 {
+			return rr, fmt.Errorf("invalid MX record %q", entry.Answer)
+		}
+		if rr.MX.Preference, err = parseUint16(f[0]); err != nil {
+			return rr, fmt.Errorf("invalid MX preference %q", f[0])
+		}
+		rr.MX.Name = []byte(dnsName(f[1]))
+	case layers.DNSTypeSRV:
+		if len(f) != 4 {
+			return rr, fmt.Errorf("invalid SRV record %q", entry.Answer)
+		}
+		v := make([]uint16, 3)
+		for i := range v {
+			if v[i], err = parseUint16(f[i]); err != nil {
+				return rr, fmt.Errorf("invalid SRV record %q", entry.Answer)
+			}
+		}
+		rr.SRV = layers.DNSSRV{Priority: v[0], Weight: v[1], Port: v[2], Name: []byte(dnsName(f[3]))}
+	case layers.DNSTypeSOA:
+		if len(f) != 7 {
+			return rr, fmt.Errorf("invalid SOA record %q", entry.Answer)
+		}
+		v := make([]uint32, 5)
+		for i := range v {
+			t, err := strconv.ParseUint(f[i+2], 10, 32)
+			if err != nil {
+				return rr, fmt.Errorf("invalid SOA record %q", entry.Answer)
+			}
+			v[i] = uint32(t)
+		}
+		rr.SOA = layers.DNSSOA{MName: []byte(dnsName(f[0])), RName: []byte(dnsName(f[1])),
+			Serial: v[0], Refresh: v[1], Retry: v[2], Expire: v[3], Minimum: v[4]}
+	case layers.DNSTypeCAA:
+		flags, tag, value, err := splitCaa(entry.Answer)
+		if err != nil {
+			return rr, err
+		}
+		rr.CAA = layers.DNSCAA{Flags: flags, Tag: []byte(tag), Value: []byte(value)}
+	default:
+		return rr, errUnsupportedDnsType
+	}
+	return rr, nil
+}
+
+// buildEntry converts a resource record to an entry of the database, the reverse of buildRecord.
+func buildEntry(rr *layers.DNSResourceRecord) (DnsEntry, error) {
+	entry := DnsEntry{DnsType: rr.Type.String(), DnsClass: rr.Class.String(), TTL: rr.TTL}
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		ip := net.IP(rr.IP)
+		if (rr.Type == layers.DNSTypeA && len(ip) != net.IPv4len) || (rr.Type == layers.DNSTypeAAAA && len(ip) != net.IPv6len) {
+			return entry, fmt.Errorf("invalid %v record", rr.Type)
+		}
+		entry.Answer = ip.String()
+	case layers.DNSTypePTR:
+		entry.Answer = string(rr.PTR)
+	case layers.DNSTypeCNAME:
+		entry.Answer = string(rr.CNAME)
+	case layers.DNSTypeNS:
+		entry.Answer = string(rr.NS)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i := range rr.TXTs {
+			txts[i] = string(rr.TXTs[i])
+		}
+		entry.Answer = strings.Join(txts, ", ")
+	case layers.DNSTypeMX:
+		entry.Answer = fmt.Sprintf("%v %v", rr.MX.Preference, string(rr.MX.Name))
+	case layers.DNSTypeSRV:
+		entry.Answer = fmt.Sprintf("%v %v %v %v", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, string(rr.SRV.Name))
+	case layers.DNSTypeSOA:
+		entry.Answer = fmt.Sprintf("%v %v %v %v %v %v %v", string(rr.SOA.MName), string(rr.SOA.RName),
+			rr.SOA.Serial, rr.SOA.Refresh, rr.SOA.Retry, rr.SOA.Expire, rr.SOA.Minimum)
+	case layers.DNSTypeCAA:
+		entry.Answer = fmt.Sprintf("%v %v %q", rr.CAA.Flags, string(rr.CAA.Tag), string(rr.CAA.Value))
+	default:
+		return entry, errUnsupportedDnsType
+	}
+	return entry, nil
+}
+
+// canonicalEntry returns the entry with the answer in the format of buildEntry, so entries
+// provided in different formats can be compared.
+func canonicalEntry(name string, entry DnsEntry) DnsEntry {
+	rr, err := buildRecord(name, &entry)
+	if err != nil {
+		return entry.key()
+	}
+	c, err := buildEntry(&rr)
+	if err != nil {
+		return entry.key()
+	}
+	return c.key()
+}
+
+/*======================================================================================================
+										Zone file import
+======================================================================================================*/
+
+// zoneToken is a token of a zone file.
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneParser parses a zone file in the BIND master file format.
+type zoneParser struct {
+	origin string      // Current $ORIGIN, without the trailing dot
+	ttl    uint32      // Current $TTL, or the TTL of the last record
+	owner  string      // Owner of the last record
+	db     DnsDatabase // Parsed records
+	lineNo int         // Current line, for errors
+}
+
+// ParseZoneFile parses a zone file in the BIND format and returns its records. Origin is the initial
+// $ORIGIN, it can be empty if the file sets it or uses absolute names only.
+func ParseZoneFile(zone string, origin string) (DnsDatabase, error) {
+	p := zoneParser{origin: dnsName(origin), db: make(DnsDatabase)}
+	lines := strings.Split(zone, "\n")
+	for i := 0; i < len(lines); i++ {
+		p.lineNo = i + 1
+		tokens, blankOwner, err := p.tokenize(lines[i])
+		if err != nil {
+			return nil, p.error(err)
+		}
+		// Parentheses group several lines into a single entry.
+		for depth := p.depth(tokens); depth > 0; depth = p.depth(tokens) {
+			i++
+			if i == len(lines) {
+				return nil, p.error(errors.New("unbalanced parentheses"))
+			}
+			more, _, err := p.tokenize(lines[i])
+			if err != nil {
+				return nil, p.error(err)
+			}
+			tokens = append(tokens, more...)
+		}
+		tokens = p.removeParentheses(tokens)
+		if len(tokens) == 0 {
+			continue
+		}
+		if err = p.parseEntry(tokens, blankOwner); err != nil {
+			return nil, p.error(err)
+		}
+	}
+	return p.db, nil
+}
+
+func (p *zoneParser) error(err error) error {
+	return fmt.Errorf("zone line %v: %w", p.lineNo, err)
+}
+
+// tokenize splits a line into tokens, comments are removed. blankOwner is true if the line starts
+// with a white space, the owner is the owner of the previous record.
+func (p *zoneParser) tokenize(line string) (tokens []zoneToken, blankOwner bool, err error) {
+	blankOwner = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ';':
+			return tokens, blankOwner, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, zoneToken{text: string(c)})
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, false, errors.New("unterminated quoted string")
+			}
+			i++
+			tokens = append(tokens, zoneToken{text: b.String(), quoted: true})
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, zoneToken{text: line[i:j]})
+			i = j
+		}
+	}
+	return tokens, blankOwner, nil
+}
+
+// depth returns the number of open parentheses.
+func (p *zoneParser) depth(tokens []zoneToken) int {
+	d := 0
+	for _, t := range tokens {
+		if !t.quoted && t.text == "(" {
+			d++
+		} else if !t.quoted && t.text == ")" {
+			d--
+		}
+	}
+	return d
+}
+
+func (p *zoneParser) removeParentheses(tokens []zoneToken) []zoneToken {
+	r := tokens[:0]
+	for _, t := range tokens {
+		if t.quoted || (t.text != "(" && t.text != ")") {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// absName returns the absolute name of a name of the zone file.
+func (p *zoneParser) absName(name string) (string, error) {
+	if name == "@" {
+		if p.origin == "" {
+			return "", errors.New("@ used without $ORIGIN")
+		}
+		return p.origin, nil
+	}
+	if strings.HasSuffix(name, ".") {
+		return dnsName(name), nil
+	}
+	if p.origin == "" {
+		return "", fmt.Errorf("relative name %q without $ORIGIN", name)
+	}
+	return name + "." + p.origin, nil
+}
+
+// parseTtl parses a TTL in seconds or in the BIND format, for example 1h30m.
+func parseTtl(s string) (uint32, error) {
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+	var total, cur uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if unicode.IsDigit(c) {
+			cur = cur*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		switch c {
+		case 's':
+		case 'm':
+			cur *= 60
+		case 'h':
+			cur *= 3600
+		case 'd':
+			cur *= 86400
+		case 'w':
+			cur *= 604800
+		default:
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		total += cur
+		cur = 0
+		digits = false
+	}
+	if digits || total > 0xffffffff {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return uint32(total), nil
+}
+
+// isClass returns true if s is a class of the zone file.
+func isClass(s string) bool {
+	switch strings.ToUpper(s) {
+	case "IN", "CS", "CH", "HS":
+		return true
+	}
+	return false
+}
+
+// parseEntry parses a directive or a record.
+func (p *zoneParser) parseEntry(tokens []zoneToken, blankOwner bool) (err error) {
+	switch strings.ToUpper(tokens[0].text) {
+	case "$ORIGIN":
+		if len(tokens) != 2 || !strings.HasSuffix(tokens[1].text, ".") {
+			return errors.New("$ORIGIN expects an absolute name")
+		}
+		p.origin = dnsName(tokens[1].text)
+		return nil
+	case "$TTL":
+		if len(tokens) != 2 {
+			return errors.New("$TTL expects a TTL")
+		}
+		p.ttl, err = parseTtl(tokens[1].text)
+		return err
+	case "$INCLUDE", "$GENERATE":
+		return fmt.Errorf("%v is not supported", tokens[0].text)
+	}
+
+	owner := p.owner
+	if !blankOwner {
+		if owner, err = p.absName(tokens[0].text); err != nil {
+			return err
+		}
+		tokens = tokens[1:]
+	}
+	if owner == "" {
+		return errors.New("record without owner")
+	}
+	p.owner = owner
+
+	// [TTL] [class] type or [class] [TTL] type
+	ttl := p.ttl
+	class := "IN"
+	for len(tokens) > 0 {
+		if isClass(tokens[0].text) {
+			class = strings.ToUpper(tokens[0].text)
+		} else if v, err := parseTtl(tokens[0].text); err == nil && unicode.IsDigit(rune(tokens[0].text[0])) {
+			ttl = v
+			p.ttl = v // the last stated TTL is the default, RFC 1035
+		} else {
+			break
+		}
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return errors.New("record without type")
+	}
+	dnsType := strings.ToUpper(tokens[0].text)
+	answer, err := p.parseRData(dnsType, tokens[1:])
+	if err != nil {
+		return err
+	}
+	p.db[owner] = append(p.db[owner], DnsEntry{DnsType: dnsType, DnsClass: class, Answer: answer, TTL: ttl})
+	return nil
+}
+
+// parseRData converts the RDATA of a record to the answer of a DnsEntry.
+func (p *zoneParser) parseRData(dnsType string, rdata []zoneToken) (string, error) {
+	args := func(n int) error {
+		if len(rdata) != n {
+			return fmt.Errorf("%v expects %v fields, got %v", dnsType, n, len(rdata))
+		}
+		return nil
+	}
+	var err error
+	f := make([]string, len(rdata))
+	for i := range rdata {
+		f[i] = rdata[i].text
+	}
+	switch dnsType {
+	case "A", "AAAA":
+		if err = args(1); err != nil {
+			return "", err
+		}
+		ip := net.ParseIP(f[0])
+		if ip == nil || (dnsType == "A") != (ip.To4() != nil) {
+			return "", fmt.Errorf("invalid %v address %q", dnsType, f[0])
+		}
+		return ip.String(), nil
+	case "NS", "CNAME", "PTR":
+		if err = args(1); err != nil {
+			return "", err
+		}
+		return p.absName(f[0])
+	case "MX":
+		if err = args(2); err != nil {
+			return "", err
+		}
+		if _, err = parseUint16(f[0]); err != nil {
+			return "", fmt.Errorf("invalid MX preference %q", f[0])
+		}
+		if f[1], err = p.absName(f[1]); err != nil {
+			return "", err
+		}
+		return strings.Join(f, " "), nil
+	case "SRV":
+		if err = args(4); err != nil {
+			return "", err
+		}
+		for i := 0; i < 3; i++ {
+			if _, err = parseUint16(f[i]); err != nil {
+				return "", fmt.Errorf("invalid SRV field %q", f[i])
+			}
+		}
+		if f[3], err = p.absName(f[3]); err != nil {
+			return "", err
+		}
+		return strings.Join(f, " "), nil
+	case "SOA":
+		if err = args(7); err != nil {
+			return "", err
+		}
+		for i := 0; i < 2; i++ {
+			if f[i], err = p.absName(f[i]); err != nil {
+				return "", err
+			}
+		}
+		for i := 2; i < 7; i++ {
+			v, err := parseTtl(f[i])
+			if err != nil {
+				return "", fmt.Errorf("invalid SOA field %q", f[i])
+			}
+			f[i] = strconv.FormatUint(uint64(v), 10)
+		}
+		return strings.Join(f, " "), nil
+	case "TXT":
+		if len(rdata) == 0 {
+			return "", errors.New("TXT expects at least one string")
+		}
+		return strings.Join(f, ", "), nil
+	case "CAA":
+		if err = args(3); err != nil {
+			return "", err
+		}
+		if _, err = strconv.ParseUint(f[0], 10, 8); err != nil {
+			return "", fmt.Errorf("invalid CAA flags %q", f[0])
+		}
+		return fmt.Sprintf("%v %v %q", f[0], strings.ToLower(f[1]), f[2]), nil
+	}
+	return "", fmt.Errorf("type %v is not supported", dnsType)
+}
+
+// ImportZone imports a zone file in the BIND format to the database of the name server.
+func (o *PluginDnsClient) ImportZone(zone string, origin string) error {
+	if !o.IsNameServer() {
+		return fmt.Errorf("This operation is permitted for Dns Name Servers only!")
+	}
+	db, err := ParseZoneFile(zone, origin)
+	if err != nil {
+		o.stats.zoneImportError++
+		return err
+	}
+	for domain, entries := range db {
+		o.AddDomainEntries(domain, entries)
+		o.stats.zoneRecords += uint64(len(entries))
+	}
+	return nil
+}
+
+/*======================================================================================================
+										Resolution
+======================================================================================================*/
+
+// dnsResult is the result of resolving the questions of a query.
+type dnsResult struct {
+	answers     []layers.DNSResourceRecord
+	authorities []layers.DNSResourceRecord
+	additionals []layers.DNSResourceRecord
+	rcode       layers.DNSResponseCode
+}
+
+// getEntries returns the entries of a name, the database is case insensitive.
+func (o *PluginDnsClient) getEntries(name string) ([]DnsEntry, bool) {
+	entries, ok := o.db[name]
+	if !ok {
+		entries, ok = o.db[strings.ToLower(name)]
+	}
+	return entries, ok
+}
+
+// lookup returns the entries of a name, or of the wildcard that matches it (RFC 4592).
+func (o *PluginDnsClient) lookup(name string) ([]DnsEntry, bool) {
+	if entries, ok := o.getEntries(name); ok {
+		return entries, true
+	}
+	parent := name
+	for i := strings.IndexByte(parent, '.'); i >= 0; i = strings.IndexByte(parent, '.') {
+		parent = parent[i+1:]
+		if entries, ok := o.getEntries("*." + parent); ok {
+			o.stats.wildcardAnswers++
+			return entries, true
+		}
+		if _, ok := o.getEntries(parent); ok {
+			// The closest encloser has no wildcard.
+			break
+		}
+	}
+	return nil, false
+}
+
+// findSoa returns the SOA record of the zone of name.
+func (o *PluginDnsClient) findSoa(name string) (string, *DnsEntry) {
+	zone := name
+	for {
+		entries, _ := o.getEntries(zone)
+		for i := range entries {
+			if entries[i].DnsType == layers.DNSTypeSOA.String() {
+				return zone, &entries[i]
+			}
+		}
+		i := strings.IndexByte(zone, '.')
+		if i < 0 {
+			return "", nil
+		}
+		zone = zone[i+1:]
+	}
+}
+
+// addSoa adds the SOA of the zone of name to the authority section of a negative answer.
+func (o *PluginDnsClient) addSoa(name string, res *dnsResult) {
+	zone, entry := o.findSoa(name)
+	if entry == nil {
+		return
+	}
+	rr, err := buildRecord(zone, entry)
+	if err != nil {
+		o.stats.invalidEntryInDb++
+		return
+	}
+	// The TTL of a negative answer is the minimum of the SOA TTL and its minimum field, RFC 2308.
+	if rr.SOA.Minimum < rr.TTL {
+		rr.TTL = rr.SOA.Minimum
+	}
+	for i := range res.authorities {
+		if string(res.authorities[i].Name) == zone {
+			return
+		}
+	}
+	res.authorities = append(res.authorities, rr)
+}
+
+// isValidAnswer receives a Query Type and Class, and a DnsEntry of the database. It concludes if this
+// entry is a valid answer in terms of Type and Class.
+func (o *PluginDnsClient) isValidAnswer(entry DnsEntry, qType layers.DNSType, qClass layers.DNSClass) bool {
+	// Dns Types must equal, unless ANY. Dns Class should equal in case it isn't Any.
+	return (entry.DnsType == qType.String() || qType == layers.DNSTypeANY) && (entry.DnsClass == qClass.String() || qClass == layers.DNSClassAny)
+}
+
+// appendRecord converts an entry to a record and appends it to rrs.
+func (o *PluginDnsClient) appendRecord(rrs []layers.DNSResourceRecord, name string, entry *DnsEntry) ([]layers.DNSResourceRecord, bool) {
+	rr, err := buildRecord(name, entry)
+	if err != nil {
+		switch {
+		case err == errUnsupportedDnsType:
+			o.stats.unsupportedDnsType++
+		case entry.DnsType == layers.DNSTypeA.String() || entry.DnsType == layers.DNSTypeAAAA.String():
+			o.stats.invalidIpInDb++
+		default:
+			o.stats.invalidEntryInDb++
+		}
+		return rrs, false
+	}
+	return append(rrs, rr), true
+}
+
+// resolve resolves a single question into res.
+func (o *PluginDnsClient) resolve(q *layers.DNSQuestion, res *dnsResult) {
+	name := string(q.Name)
+	visited := make(map[string]bool)
+	for hops := 0; ; hops++ {
+		visited[strings.ToLower(name)] = true
+		entries, ok := o.lookup(name)
+		if ok {
+			// Each class is a separate name space.
+			ok = false
+			for i := range entries {
+				ok = ok || entries[i].DnsClass == q.Class.String() || q.Class == layers.DNSClassAny
+			}
+		}
+		if !ok {
+			o.stats.rxQuestionsNxDomain++
+			res.rcode = layers.DNSResponseCodeNXDomain
+			o.addSoa(name, res)
+			return
+		}
+		found := false
+		var cname *DnsEntry
+		for i := range entries {
+			if o.isValidAnswer(entries[i], q.Type, q.Class) {
+				var added bool
+				if res.answers, added = o.appendRecord(res.answers, name, &entries[i]); added {
+					found = true
+				}
+			} else if entries[i].DnsType == layers.DNSTypeCNAME.String() {
+				cname = &entries[i]
+			}
+		}
+		if found {
+			return
+		}
+		if cname == nil || q.Type == layers.DNSTypeANY || hops == DnsMaxCnameHops {
+			// The name exists but has no records of this type.
+			o.stats.rxQuestionsNxDomain++
+			o.addSoa(name, res)
+			return
+		}
+		// RFC 1034 section 3.6.2, the CNAME is added and the canonical name is resolved instead.
+		var added bool
+		if res.answers, added = o.appendRecord(res.answers, name, cname); !added {
+			return
+		}
+		o.stats.cnameChased++
+		name = dnsName(cname.Answer)
+		if visited[strings.ToLower(name)] {
+			// CNAME loop, the answer contains the chain up to the loop.
+			return
+		}
+	}
+}
+
+// addAdditionals adds the addresses of the MX, SRV and NS targets in the answers.
+func (o *PluginDnsClient) addAdditionals(res *dnsResult) {
+	added := make(map[string]bool)
+	for i := range res.answers {
+		var target string
+		switch res.answers[i].Type {
+		case layers.DNSTypeMX:
+			target = string(res.answers[i].MX.Name)
+		case layers.DNSTypeSRV:
+			target = string(res.answers[i].SRV.Name)
+		case layers.DNSTypeNS:
+			target = string(res.answers[i].NS)
+		default:
+			continue
+		}
+		if added[target] {
+			continue
+		}
+		added[target] = true
+		entries, _ := o.getEntries(target)
+		for j := range entries {
+			if entries[j].DnsType == layers.DNSTypeA.String() || entries[j].DnsType == layers.DNSTypeAAAA.String() {
+				res.additionals, _ = o.appendRecord(res.additionals, target, &entries[j])
+			}
+		}
+	}
+}
+
+// buildResult resolves the questions of a query.
+func (o *PluginDnsClient) buildResult(questions []layers.DNSQuestion) *dnsResult {
+	res := &dnsResult{rcode: layers.DNSResponseCodeNoErr}
+	for i := range questions {
+		o.resolve(&questions[i], res)
+	}
+	if len(questions) > 1 && len(res.answers) > 0 {
+		// Some of the questions were answered.
+		res.rcode = layers.DNSResponseCodeNoErr
+	}
+	o.addAdditionals(res)
+	return res
+}
+
+// BuildAnswers builds answers based on Dns questions
+func (o *PluginDnsClient) BuildAnswers(questions []layers.DNSQuestion) (answers []layers.DNSResourceRecord) {
+	return o.buildResult(questions).answers
+}
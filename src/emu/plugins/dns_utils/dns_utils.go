@@ -72,7 +72,7 @@ type DnsCacheEntry struct {
 	Type            string          `json:"dns_type"`  // DNS Type
 	Class           string          `json:"dns_class"` // DNS Class
 	TTL             uint32          `json:"ttl"`       // Time to live in seconds
-	Answer          string          `json:"answer"`    // IP address or Domain Name
+	Answer          string          `json:"answer"`    // IP address, Domain Name or record data
 	TimeLeft        uint32          `json:"time_left"` // Time Left for entry
 	ticksUponCreate float64         `json:"-"`         // Ticks upon create
 	epoch           uint64          `json:"-"`         // Epoch in which the entry was added to the table.
@@ -258,18 +258,59 @@ func (o *DnsCache) Flush() {
 	}
 }
 
+// RemoveRecord removes the entry of a record from the cache table, for example when a goodbye
+// (TTL 0) is received. If the entry is not in the table, nothing to do.
+func (o *DnsCache) RemoveRecord(name string, dnsType layers.DNSType, class layers.DNSClass, answer string) {
+	entry := DnsCacheEntry{
+		Name:   name,
+		Type:   dnsType.String(),
+		Class:  class.String(),
+		Answer: answer,
+		epoch:  o.epoch}
+	o.RemoveEntry(entry.SHA256())
+}
+
+// RecordToAnswer converts the data of a record to the answer kept in the cache. Returns false if the
+// type of the record can't be cached.
+func RecordToAnswer(rr *layers.DNSResourceRecord) (string, bool) {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		// The only types in which we have an IP in response are A, AAAA
+		return rr.IP.String(), true
+	case layers.DNSTypePTR:
+		return string(rr.PTR), true
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%v %v %v %v", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, string(rr.SRV.Name)), true
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i := range rr.TXTs {
+			txts[i] = string(rr.TXTs[i])
+		}
+		return strings.Join(txts, ", "), true
+	}
+	return "", false
+}
+
 // AddAnswersToCache is a helping function that adds Dns Answers to the cache.
-// At the moment, the only supported types are A, AAAA, PTR.
-func AddAnswersToCache(cache *DnsCache, answers []layers.DNSResourceRecord) {
+// At the moment, the only supported types are A, AAAA, PTR, SRV and TXT. An answer with TTL 0
+// is a goodbye, it removes the entry from the cache.
+// Returns the number of answers handled.
+func AddAnswersToCache(cache *DnsCache, answers []layers.DNSResourceRecord) int {
+	handled := 0
 	for i := range answers {
-		ans := answers[i]
-		if ans.Type == layers.DNSTypeA || ans.Type == layers.DNSTypeAAAA {
-			// The only types in which we have an IP in response are A, AAAA
-			cache.AddEntry(string(ans.Name), ans.Type, ans.Class, ans.TTL, ans.IP.String())
-		} else if ans.Type == layers.DNSTypePTR {
-			cache.AddEntry(string(ans.Name), ans.Type, ans.Class, ans.TTL, string(ans.PTR))
+		ans := &answers[i]
+		answer, ok := RecordToAnswer(ans)
+		if !ok {
+			continue
+		}
+		if ans.TTL == 0 {
+			cache.RemoveRecord(string(ans.Name), ans.Type, ans.Class, answer)
+		} else {
+			cache.AddEntry(string(ans.Name), ans.Type, ans.Class, ans.TTL, answer)
 		}
+		handled++
 	}
+	return handled
 }
 
 /*======================================================================================================
@@ -382,18 +423,20 @@ func BuildTxtsFromTxtEntries(txtEntries []TxtEntries) (txts [][]byte) {
 type DnsPktBuilder struct {
 	dnsTemplate layers.DNS // L7 template
 	mdns        bool       // Is mDns?
+	rnd         *rand.Rand // Generates the transaction IDs of Dns
 }
 
-// NewDnsPktBuilder creates and returns new DnsPktBuilder.
-func NewDnsPktBuilder(mdns bool) *DnsPktBuilder {
+// NewDnsPktBuilder creates and returns new DnsPktBuilder, the transaction IDs are generated by rnd.
+func NewDnsPktBuilder(mdns bool, rnd *rand.Rand) *DnsPktBuilder {
 	o := new(DnsPktBuilder)
 	o.mdns = mdns
+	o.rnd = rnd
 	o.dnsTemplate = layers.DNS{
 		ID:           0,                           // ID is 0 for mDns and randomly generated for DNS.
 		QR:           false,                       // False for Query, True for Response
 		OpCode:       layers.DNSOpCodeQuery,       // Standard DNS query, opcode = 0
 		AA:           false,                       // Authoritative answer.
-		TC:           false,                       // Truncated, not supported
+		TC:           false,                       // Truncated, set only in truncated responses
 		RD:           false,                       // Recursion desired, not supported
 		RA:           false,                       // Recursion available, not supported
 		Z:            0,                           // Reserved for future use
@@ -412,7 +455,7 @@ func (o *DnsPktBuilder) BuildQueryPkt(questions []layers.DNSQuestion, simulation
 	if !simulation && !o.mdns {
 		// Generate the transaction ID randomly.
 		// In MDns the transaction ID must be set to 0.
-		o.dnsTemplate.ID = uint16(rand.Uint32())
+		o.dnsTemplate.ID = uint16(o.rnd.Uint32())
 	}
 	o.dnsTemplate.QR = false                                 // Query
 	o.dnsTemplate.AA = false                                 // AA is false for Queries
@@ -425,6 +468,24 @@ func (o *DnsPktBuilder) BuildQueryPkt(questions []layers.DNSQuestion, simulation
 	return core.PacketUtlBuild(&o.dnsTemplate)
 }
 
+// BuildQueryPktWithID builds and returns a query packet with a given transaction ID, for example
+// to retry a truncated query over TCP or for DoH that uses ID 0.
+func (o *DnsPktBuilder) BuildQueryPktWithID(transactionId uint16, questions []layers.DNSQuestion) []byte {
+	data := o.BuildQueryPkt(questions, true)
+	binary.BigEndian.PutUint16(data, transactionId)
+	return data
+}
+
+// BuildProbePkt builds and returns an mDNS probe, a query with the proposed records in the authority section.
+func (o *DnsPktBuilder) BuildProbePkt(questions []layers.DNSQuestion, authorities []layers.DNSResourceRecord) []byte {
+	o.dnsTemplate.NSCount = uint16(len(authorities)) // Number of authorities
+	o.dnsTemplate.Authorities = authorities          // Proposed records
+	data := o.BuildQueryPkt(questions, true)
+	o.dnsTemplate.NSCount = 0
+	o.dnsTemplate.Authorities = nil
+	return data
+}
+
 // BuildResponsePkt builds and returns a response packet with new questions/answers based on the template packet.
 func (o *DnsPktBuilder) BuildResponsePkt(transactionId uint16,
 	answers []layers.DNSResourceRecord,
@@ -443,6 +504,43 @@ func (o *DnsPktBuilder) BuildResponsePkt(transactionId uint16,
 	return core.PacketUtlBuild(&o.dnsTemplate)
 }
 
+// BuildAuthResponsePkt builds and returns a response packet with authority and additional records,
+// for example the SOA of a negative answer.
+func (o *DnsPktBuilder) BuildAuthResponsePkt(transactionId uint16,
+	answers, authorities, additionals []layers.DNSResourceRecord,
+	questions []layers.DNSQuestion,
+	respCode layers.DNSResponseCode) []byte {
+
+	o.dnsTemplate.NSCount = uint16(len(authorities)) // Number of authorities
+	o.dnsTemplate.ARCount = uint16(len(additionals)) // Number of additional records
+	o.dnsTemplate.Authorities = authorities          // Authorities
+	o.dnsTemplate.Additionals = additionals          // Additional records
+	data := o.BuildResponsePkt(transactionId, answers, questions, respCode)
+	o.dnsTemplate.NSCount = 0
+	o.dnsTemplate.ARCount = 0
+	o.dnsTemplate.Authorities = nil
+	o.dnsTemplate.Additionals = nil
+	return data
+}
+
+// BuildUpdateResponsePkt builds and returns the response to a dynamic update, RFC 2136.
+// The zone section is copied from the update.
+func (o *DnsPktBuilder) BuildUpdateResponsePkt(transactionId uint16, zones []layers.DNSQuestion, respCode layers.DNSResponseCode) []byte {
+	o.dnsTemplate.OpCode = layers.DNSOpCodeUpdate
+	data := o.BuildResponsePkt(transactionId, []layers.DNSResourceRecord{}, zones, respCode)
+	o.dnsTemplate.OpCode = layers.DNSOpCodeQuery
+	return data
+}
+
+// BuildTruncatedResponsePkt builds and returns a response without answers and with the TC bit set,
+// as sent over UDP when the complete response doesn't fit.
+func (o *DnsPktBuilder) BuildTruncatedResponsePkt(transactionId uint16, questions []layers.DNSQuestion) []byte {
+	o.dnsTemplate.TC = true
+	data := o.BuildResponsePkt(transactionId, []layers.DNSResourceRecord{}, questions, layers.DNSResponseCodeNoErr)
+	o.dnsTemplate.TC = false
+	return data
+}
+
 /*======================================================================================================
 										AutoPlay
 ======================================================================================================*/
@@ -0,0 +1,618 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+802.1X authenticator (PAE authenticator + local authentication server)
+
+An EMU client configured with "authenticator" answers EAPOL-Start of
+supplicants in the namespace and authenticates them against a local user
+table with EAP-MD5 or EAP-MSCHAPv2. The outcome is kept per supplicant MAC
+for hold_sec after success or failure, a session is freed at once on
+EAPOL-Logoff or when the retransmits are exhausted.
+
+	supplicant                       authenticator
+	EAPOL-Start          ---->
+	                     <----       EAP-Request/Identity
+	EAP-Response/Identity ---->
+	                     <----       EAP-Request/MD5 or MSCHAPv2 challenge
+	EAP-Response          ---->
+	                     <----       (MSCHAPv2 success request/response)
+	                     <----       EAP-Success/Failure
+*/
+
+import (
+	"bytes"
+	"crypto/md5"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	AUTH_STATE_IDLE             = 0
+	AUTH_STATE_WAIT_IDENTITY    = 1
+	AUTH_STATE_WAIT_METHOD      = 2
+	AUTH_STATE_WAIT_SUCCESS_ACK = 3
+	AUTH_STATE_SUCCESS          = 4
+	AUTH_STATE_FAIL             = 5
+	AUTH_STATE_TIMEOUT          = 6
+	AUTH_STATE_LOGOFF           = 7
+
+	AUTH_DEFAULT_TIMEOUT_SEC  = 3
+	AUTH_DEFAULT_MAX_RETRY    = 3
+	AUTH_DEFAULT_MAX_SESSIONS = 4096
+	AUTH_DEFAULT_HOLD_SEC     = 60
+	AUTH_SERVER_NAME          = "trex-emu"
+)
+
+var authStateNames = map[uint8]string{
+	AUTH_STATE_IDLE:             "idle",
+	AUTH_STATE_WAIT_IDENTITY:    "wait_identity",
+	AUTH_STATE_WAIT_METHOD:      "wait_method",
+	AUTH_STATE_WAIT_SUCCESS_ACK: "wait_success_ack",
+	AUTH_STATE_SUCCESS:          "success",
+	AUTH_STATE_FAIL:             "fail",
+	AUTH_STATE_TIMEOUT:          "timeout",
+	AUTH_STATE_LOGOFF:           "logoff",
+}
+
+// Dot1xAuthCfg configuration of the authenticator role
+type Dot1xAuthCfg struct {
+	Users       map[string]string `json:"users"`        // user name -> password
+	Flags       uint32            `json:"flags"`        // mask of methods to disable, EAP_MD5_MASK/EAP_MSCHAPv2_MASK
+	TimeoutSec  uint32            `json:"timeo_sec"`    // retransmit timeout in sec
+	MaxRetry    uint32            `json:"max_retry"`    // max number of retransmits before timeout
+	MaxSessions uint32            `json:"max_sessions"` // max number of supplicants
+	HoldSec     uint32            `json:"hold_sec"`     // time to keep a session after success/failure
+}
+
+type Dot1xAuthStats struct {
+	authRxStart        uint64
+	authRxLogoff       uint64
+	authRxResponse     uint64
+	authRxWrongState   uint64
+	authRxWrongId      uint64
+	authRxNack         uint64
+	authTxRequest      uint64
+	authTxRetransmit   uint64
+	authSuccess        uint64
+	authFailure        uint64
+	authTimeout        uint64
+	authUnknownUser    uint64
+	authSessionsFull   uint64
+	authActiveSessions uint64
+}
+
+func NewDot1xAuthStatsDb(o *Dot1xAuthStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dot1xauth")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxStart,
+		Name:     "authRxStart",
+		Help:     "rx EAPOL-Start",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxLogoff,
+		Name:     "authRxLogoff",
+		Help:     "rx EAPOL-Logoff",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxResponse,
+		Name:     "authRxResponse",
+		Help:     "rx EAP response",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxWrongState,
+		Name:     "authRxWrongState",
+		Help:     "rx EAP response in the wrong state",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxWrongId,
+		Name:     "authRxWrongId",
+		Help:     "rx EAP response with the wrong id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authRxNack,
+		Name:     "authRxNack",
+		Help:     "rx EAP nack",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTxRequest,
+		Name:     "authTxRequest",
+		Help:     "tx EAP request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTxRetransmit,
+		Name:     "authTxRetransmit",
+		Help:     "tx EAP request retransmit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authSuccess,
+		Name:     "authSuccess",
+		Help:     "supplicants authenticated",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFailure,
+		Name:     "authFailure",
+		Help:     "supplicants failed to authenticate",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTimeout,
+		Name:     "authTimeout",
+		Help:     "supplicants timeout",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authUnknownUser,
+		Name:     "authUnknownUser",
+		Help:     "identity is not in the user table",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authSessionsFull,
+		Name:     "authSessionsFull",
+		Help:     "supplicant ignored, max sessions",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authActiveSessions,
+		Name:     "authActiveSessions",
+		Help:     "supplicant sessions",
+		Unit:     "sessions",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// dot1xAuthSession the state of one supplicant
+type dot1xAuthSession struct {
+	auth      *dot1xAuthenticator
+	timer     core.CHTimerObj
+	mac       core.MACKey
+	state     uint8
+	id        uint8
+	eapVer    uint8
+	method    uint8
+	identity  string
+	challenge []byte
+	authResp  string
+	lastReq   []byte // last EAP request for retransmit
+	retries   uint32
+	starts    uint32
+}
+
+// OnEvent retransmit timer, or hold timer once the session has a result
+func (o *dot1xAuthSession) OnEvent(a, b interface{}) {
+	if o.state == AUTH_STATE_SUCCESS || o.state == AUTH_STATE_FAIL {
+		o.auth.removeSession(o)
+		return
+	}
+	o.auth.onTimeout(o)
+}
+
+// Dot1xAuthSessionInfo is the RPC view of one supplicant
+type Dot1xAuthSessionInfo struct {
+	Mac       core.MACKey `json:"mac"`
+	State     uint8       `json:"state"`
+	StateName string      `json:"state_name"`
+	Identity  string      `json:"identity"`
+	Method    uint8       `json:"method"`
+	Starts    uint32      `json:"starts"`
+	Retries   uint32      `json:"retries"`
+}
+
+type dot1xAuthenticator struct {
+	plug     *PluginDot1xClient
+	cfg      *Dot1xAuthCfg
+	timerw   *core.TimerCtx
+	stats    Dot1xAuthStats
+	cdb      *core.CCounterDb
+	sessions map[core.MACKey]*dot1xAuthSession
+	methods  []uint8 // by preference
+	l2       []byte
+	l3Offset uint16
+	b        []byte
+}
+
+func newDot1xAuthenticator(plug *PluginDot1xClient, cfg *Dot1xAuthCfg) *dot1xAuthenticator {
+	o := new(dot1xAuthenticator)
+	o.plug = plug
+	o.cfg = cfg
+	if o.cfg.TimeoutSec == 0 {
+		o.cfg.TimeoutSec = AUTH_DEFAULT_TIMEOUT_SEC
+	}
+	if o.cfg.MaxRetry == 0 {
+		o.cfg.MaxRetry = AUTH_DEFAULT_MAX_RETRY
+	}
+	if o.cfg.MaxSessions == 0 {
+		o.cfg.MaxSessions = AUTH_DEFAULT_MAX_SESSIONS
+	}
+	if o.cfg.HoldSec == 0 {
+		o.cfg.HoldSec = AUTH_DEFAULT_HOLD_SEC
+	}
+	o.timerw = plug.Tctx.GetTimerCtx()
+	o.cdb = NewDot1xAuthStatsDb(&o.stats)
+	o.sessions = make(map[core.MACKey]*dot1xAuthSession)
+	if o.cfg.Flags&EAP_MD5_MASK == 0 {
+		o.methods = append(o.methods, EAP_TYPE_MD5)
+	}
+	if o.cfg.Flags&EAP_MSCHAPv2_MASK == 0 {
+		o.methods = append(o.methods, EAP_TYPE_MSCHAPV2)
+	}
+	o.l2 = plug.Client.GetL2Header(false, uint16(layers.EthernetTypeEAPOL))
+	o.l3Offset = uint16(len(o.l2))
+	o.b = make([]byte, 0)
+	return o
+}
+
+func validateAuthCfg(cfg *Dot1xAuthCfg) error {
+	if cfg.Flags&(EAP_MD5_MASK|EAP_MSCHAPv2_MASK) == (EAP_MD5_MASK | EAP_MSCHAPv2_MASK) {
+		return fmt.Errorf("dot1x authenticator, all methods are disabled")
+	}
+	return nil
+}
+
+func (o *dot1xAuthenticator) OnRemove() {
+	for _, s := range o.sessions {
+		o.stopTimer(s)
+	}
+	o.sessions = nil
+}
+
+func (o *dot1xAuthenticator) stopTimer(s *dot1xAuthSession) {
+	if s.timer.IsRunning() {
+		o.timerw.Stop(&s.timer)
+	}
+}
+
+func (o *dot1xAuthenticator) restartTimer(s *dot1xAuthSession) {
+	o.stopTimer(s)
+	o.timerw.Start(&s.timer, time.Duration(o.cfg.TimeoutSec)*time.Second)
+}
+
+func (o *dot1xAuthenticator) sendEapol(s *dot1xAuthSession, eapolType layers.EAPOLType, eap []byte) {
+	pktsize := uint16(len(o.l2) + 4 + len(eap))
+	m := o.plug.Ns.AllocMbuf(pktsize)
+	m.Append(o.l2)
+	m.Append([]byte{s.eapVer, byte(eapolType), 0, 0})
+	m.Append(eap)
+	p := m.GetData()
+	copy(p[0:6], s.mac[:])
+	l3 := o.l3Offset
+	binary.BigEndian.PutUint16(p[l3+2:l3+4], uint16(len(eap)))
+	o.plug.Tctx.Veth.Send(m)
+}
+
+// sendRequest sends a new EAP request and keeps it for retransmit
+func (o *dot1xAuthenticator) sendRequest(s *dot1xAuthSession, eapType uint8, d []byte) {
+	s.id++
+	l := uint16(len(d) + EAPSIZE_PKT_HEADER)
+	s.lastReq = s.lastReq[:0]
+	s.lastReq = append(s.lastReq, uint8(layers.EAPCodeRequest), s.id, 0, 0, eapType)
+	binary.BigEndian.PutUint16(s.lastReq[2:4], l)
+	s.lastReq = append(s.lastReq, d...)
+	s.retries = 0
+	o.stats.authTxRequest++
+	o.sendEapol(s, layers.EAPOLTypeEAP, s.lastReq)
+	o.restartTimer(s)
+}
+
+func (o *dot1xAuthenticator) sendResult(s *dot1xAuthSession, success bool) {
+	code := layers.EAPCodeFailure
+	if success {
+		code = layers.EAPCodeSuccess
+		s.state = AUTH_STATE_SUCCESS
+		o.stats.authSuccess++
+	} else {
+		s.state = AUTH_STATE_FAIL
+		o.stats.authFailure++
+	}
+	o.sendEapol(s, layers.EAPOLTypeEAP, []byte{uint8(code), s.id, 0, 4})
+	// keep the result for a while, then free the session
+	o.stopTimer(s)
+	o.timerw.Start(&s.timer, time.Duration(o.cfg.HoldSec)*time.Second)
+}
+
+func (o *dot1xAuthenticator) onTimeout(s *dot1xAuthSession) {
+	if s.retries < o.cfg.MaxRetry {
+		s.retries++
+		o.stats.authTxRetransmit++
+		o.sendEapol(s, layers.EAPOLTypeEAP, s.lastReq)
+		o.restartTimer(s)
+		return
+	}
+	s.state = AUTH_STATE_TIMEOUT
+	o.stats.authTimeout++
+	o.removeSession(s)
+}
+
+func (o *dot1xAuthenticator) genChallenge(s *dot1xAuthSession) {
+	if o.plug.Tctx.Simulation {
+		s.challenge = []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}
+	} else {
+		genChalange16B(o.plug.Ns.Rand(), &s.challenge)
+	}
+}
+
+func (o *dot1xAuthenticator) startMethod(s *dot1xAuthSession, method uint8) {
+	s.method = method
+	s.state = AUTH_STATE_WAIT_METHOD
+	o.genChallenge(s)
+	o.b = o.b[:0]
+	switch method {
+	case EAP_TYPE_MD5:
+		o.b = append(o.b, uint8(len(s.challenge)))
+		o.b = append(o.b, s.challenge...)
+		o.b = append(o.b, []byte(AUTH_SERVER_NAME)...)
+	case EAP_TYPE_MSCHAPV2:
+		l := uint16(5 + len(s.challenge) + len(AUTH_SERVER_NAME))
+		o.b = append(o.b, MS_CHAPV2_CHALLENGE, s.id+1, 0, 0, uint8(len(s.challenge)))
+		binary.BigEndian.PutUint16(o.b[2:4], l)
+		o.b = append(o.b, s.challenge...)
+		o.b = append(o.b, []byte(AUTH_SERVER_NAME)...)
+	}
+	o.sendRequest(s, method, o.b)
+}
+
+func (o *dot1xAuthenticator) getSession(mac *core.MACKey, create bool) *dot1xAuthSession {
+	s, ok := o.sessions[*mac]
+	if ok || !create {
+		return s
+	}
+	if uint32(len(o.sessions)) >= o.cfg.MaxSessions {
+		o.stats.authSessionsFull++
+		return nil
+	}
+	s = new(dot1xAuthSession)
+	s.auth = o
+	s.mac = *mac
+	s.timer.SetCB(s, nil, nil)
+	s.lastReq = make([]byte, 0)
+	o.sessions[*mac] = s
+	o.stats.authActiveSessions = uint64(len(o.sessions))
+	return s
+}
+
+// removeSession frees the slot of the supplicant for new ones
+func (o *dot1xAuthenticator) removeSession(s *dot1xAuthSession) {
+	o.stopTimer(s)
+	delete(o.sessions, s.mac)
+	o.stats.authActiveSessions = uint64(len(o.sessions))
+}
+
+func (o *dot1xAuthenticator) onStart(s *dot1xAuthSession) {
+	o.stats.authRxStart++
+	s.starts++
+	s.identity = ""
+	s.method = 0
+	s.state = AUTH_STATE_WAIT_IDENTITY
+	o.sendRequest(s, uint8(layers.EAPTypeIdentity), []byte{})
+}
+
+func (o *dot1xAuthenticator) onIdentity(s *dot1xAuthSession, eap *layers.EAP) {
+	s.identity = string(eap.TypeData)
+	if _, ok := o.cfg.Users[s.identity]; !ok {
+		o.stats.authUnknownUser++
+		o.sendResult(s, false)
+		return
+	}
+	o.startMethod(s, o.methods[0])
+}
+
+func (o *dot1xAuthenticator) onNack(s *dot1xAuthSession, eap *layers.EAP) {
+	o.stats.authRxNack++
+	for _, t := range eap.TypeData {
+		for _, m := range o.methods {
+			if t == m && t != s.method {
+				o.startMethod(s, t)
+				return
+			}
+		}
+	}
+	o.sendResult(s, false)
+}
+
+func (o *dot1xAuthenticator) onMd5(s *dot1xAuthSession, eap *layers.EAP) {
+	td := eap.TypeData
+	if len(td) < 17 || td[0] != 16 {
+		o.sendResult(s, false)
+		return
+	}
+	o.b = o.b[:0]
+	o.b = append(o.b, eap.Id)
+	o.b = append(o.b, []byte(o.cfg.Users[s.identity])...)
+	o.b = append(o.b, s.challenge...)
+	r := md5.Sum(o.b)
+	o.sendResult(s, bytes.Equal(r[:], td[1:17]))
+}
+
+func (o *dot1xAuthenticator) onMschapv2(s *dot1xAuthSession, eap *layers.EAP) {
+	td := eap.TypeData
+	if len(td) < 1 {
+		o.sendResult(s, false)
+		return
+	}
+	if s.state == AUTH_STATE_WAIT_SUCCESS_ACK {
+		o.sendResult(s, td[0] == MS_CHAPV2_SUCCESS)
+		return
+	}
+	// op, id, len(2), value size, peer challenge(16), reserved(8), nt response(24), flags, name
+	if td[0] != MS_CHAPV2_RESPONSE || len(td) < 5+49 || td[4] != 49 {
+		o.sendResult(s, false)
+		return
+	}
+	peerChallenge := td[5:21]
+	ntResponse := td[29:53]
+	name := string(td[54:])
+	res, err := Encryptv2(s.challenge, peerChallenge, name, o.cfg.Users[s.identity])
+	if err != nil || !bytes.Equal(res.ChallengeResponse, ntResponse) {
+		o.sendResult(s, false)
+		return
+	}
+	s.authResp = res.AuthenticatorResponse
+	msg := s.authResp + " M=success"
+	o.b = o.b[:0]
+	o.b = append(o.b, MS_CHAPV2_SUCCESS, td[1], 0, 0)
+	binary.BigEndian.PutUint16(o.b[2:4], uint16(4+len(msg)))
+	o.b = append(o.b, []byte(msg)...)
+	s.state = AUTH_STATE_WAIT_SUCCESS_ACK
+	o.sendRequest(s, EAP_TYPE_MSCHAPV2, o.b)
+}
+
+func (o *dot1xAuthenticator) onResponse(s *dot1xAuthSession, eap *layers.EAP) {
+	o.stats.authRxResponse++
+	if eap.Id != s.id {
+		o.stats.authRxWrongId++
+		return
+	}
+	switch s.state {
+	case AUTH_STATE_WAIT_IDENTITY:
+		if eap.Type != layers.EAPTypeIdentity {
+			o.stats.authRxWrongState++
+			return
+		}
+		o.onIdentity(s, eap)
+	case AUTH_STATE_WAIT_METHOD, AUTH_STATE_WAIT_SUCCESS_ACK:
+		if eap.Type == layers.EAPTypeNACK && s.state == AUTH_STATE_WAIT_METHOD {
+			o.onNack(s, eap)
+			return
+		}
+		if uint8(eap.Type) != s.method {
+			o.stats.authRxWrongState++
+			return
+		}
+		if s.method == EAP_TYPE_MD5 {
+			o.onMd5(s, eap)
+		} else {
+			o.onMschapv2(s, eap)
+		}
+	default:
+		o.stats.authRxWrongState++
+	}
+}
+
+// HandleRxPacket handles EAPOL from a supplicant
+func (o *dot1xAuthenticator) HandleRxPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	l3 := ps.L3
+	if uint32(l3+4) > ps.M.PktLen() {
+		o.plug.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	ver := p[l3]
+	if (ver < 1) || (ver > MAX_EAPOL_VER) {
+		o.plug.stats.pktRxIvalidVersionErr++
+		return core.PARSER_ERR
+	}
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+	eapolType := layers.EAPOLType(p[l3+1])
+
+	switch eapolType {
+	case layers.EAPOLTypeStart:
+		s := o.getSession(&mac, true)
+		if s == nil {
+			return core.PARSER_ERR
+		}
+		s.eapVer = ver
+		o.onStart(s)
+	case layers.EAPOLTypeLogOff:
+		o.stats.authRxLogoff++
+		s := o.getSession(&mac, false)
+		if s != nil {
+			s.state = AUTH_STATE_LOGOFF
+			o.removeSession(s)
+		}
+	case layers.EAPOLTypeEAP:
+		eapolLen := binary.BigEndian.Uint16(p[l3+2 : l3+4])
+		if eapolLen < 4 || uint32(l3+4)+uint32(eapolLen) > ps.M.PktLen() {
+			o.plug.stats.pktRxEAPtooShortErr++
+			return core.PARSER_ERR
+		}
+		var eap layers.EAP
+		err := eap.DecodeFromBytes(p[l3+4:l3+4+eapolLen], nil)
+		if err != nil {
+			o.plug.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+		if eap.Code != layers.EAPCodeResponse {
+			o.plug.stats.pktRxIgnore++
+			return core.PARSER_ERR
+		}
+		s := o.getSession(&mac, false)
+		if s == nil {
+			o.stats.authRxWrongState++
+			return core.PARSER_ERR
+		}
+		o.onResponse(s, &eap)
+	default:
+		o.plug.stats.pktRxIgnore++
+		return core.PARSER_ERR
+	}
+	return 0
+}
+
+// GetSessions returns the supplicants sorted by MAC
+func (o *dot1xAuthenticator) GetSessions() []Dot1xAuthSessionInfo {
+	res := make([]Dot1xAuthSessionInfo, 0, len(o.sessions))
+	for _, s := range o.sessions {
+		res = append(res, Dot1xAuthSessionInfo{
+			Mac:       s.mac,
+			State:     s.state,
+			StateName: authStateNames[s.state],
+			Identity:  s.identity,
+			Method:    s.method,
+			Starts:    s.starts,
+			Retries:   s.retries,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Mac[:], res[j].Mac[:]) < 0
+	})
+	return res
+}
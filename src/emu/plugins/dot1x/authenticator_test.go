@@ -0,0 +1,214 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"emu/core"
+	"os"
+	"testing"
+	"time"
+)
+
+// VethLoopSim loops the packets back, so the authenticator and the supplicant of the same ns talk to each other
+type VethLoopSim struct{}
+
+func (o *VethLoopSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+type Dot1xAuthTestBase struct {
+	testname    string
+	capture     bool
+	duration    time.Duration
+	authInit    string
+	supInit     string
+	expSupState uint8
+	expAuthSm   uint8
+}
+
+var authTestMac = core.MACKey{0, 0, 1, 0, 0, 2}
+var supTestMac = core.MACKey{0, 0, 1, 0, 0, 1}
+
+func getDot1xPlug(t *testing.T, ns *core.CNSCtx, mac *core.MACKey) *PluginDot1xClient {
+	c := ns.CLookupByMac(mac)
+	if c == nil {
+		t.Fatalf(" can't find client %v", *mac)
+	}
+	cplg := c.PluginCtx.Get(DOT1X_PLUG)
+	if cplg == nil {
+		t.Fatalf(" can't find plugin")
+	}
+	return cplg.Ext.(*PluginDot1xClient)
+}
+
+func (o *Dot1xAuthTestBase) Run(t *testing.T) {
+	var simVeth VethLoopSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{})
+	tctx.RegisterParserCb("dot1x")
+
+	// the authenticator must exist before the supplicant sends EAPOL-Start
+	for _, c := range []struct {
+		mac  core.MACKey
+		init string
+	}{{authTestMac, o.authInit}, {supTestMac, o.supInit}} {
+		client := core.NewClient(ns, c.mac, core.Ipv4Key{0, 0, 0, 0}, core.Ipv6Key{}, core.Ipv4Key{0, 0, 0, 0})
+		ns.AddClient(client)
+		client.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{[]byte(c.init)})
+	}
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+
+	auth := getDot1xPlug(t, ns, &authTestMac)
+	sup := getDot1xPlug(t, ns, &supTestMac)
+	auth.cdbv.Dump()
+	sup.cdbv.Dump()
+
+	if sup.smState != o.expSupState {
+		t.Fatalf(" supplicant state %d, expected %d", sup.smState, o.expSupState)
+	}
+	sessions := auth.auth.GetSessions()
+	if len(sessions) != 1 || sessions[0].Mac != supTestMac {
+		t.Fatalf(" unexpected authenticator sessions %+v", sessions)
+	}
+	if sessions[0].State != o.expAuthSm {
+		t.Fatalf(" authenticator session state %s, expected %s", sessions[0].StateName, authStateNames[o.expAuthSm])
+	}
+	tctx.SimRecordAppend(auth.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(sup.cdbv.MarshalValues(false))
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func TestPlugindot1xAuth_md5(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname:    "dot1x_auth_md5",
+		capture:     true,
+		duration:    10 * time.Second,
+		authInit:    `{"authenticator": {"users": {"hhaim": "432768ec1d"}}}`,
+		supInit:     `{"user": "hhaim", "password": "432768ec1d"}`,
+		expSupState: EAP_DONE_OK,
+		expAuthSm:   AUTH_STATE_SUCCESS,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuth_mschapv2(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname:    "dot1x_auth_mschapv2",
+		capture:     true,
+		duration:    10 * time.Second,
+		authInit:    `{"authenticator": {"users": {"hhaim": "432768ec1d"}, "flags": 1}}`,
+		supInit:     `{"user": "hhaim", "password": "432768ec1d"}`,
+		expSupState: EAP_DONE_OK,
+		expAuthSm:   AUTH_STATE_SUCCESS,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuth_wrong_password(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname:    "dot1x_auth_wrong_password",
+		capture:     true,
+		duration:    10 * time.Second,
+		authInit:    `{"authenticator": {"users": {"hhaim": "432768ec1d"}, "flags": 1}}`,
+		supInit:     `{"user": "hhaim", "password": "wrong"}`,
+		expSupState: EAP_DONE_FAIL,
+		expAuthSm:   AUTH_STATE_FAIL,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuth_unknown_user(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname:    "dot1x_auth_unknown_user",
+		capture:     true,
+		duration:    10 * time.Second,
+		authInit:    `{"authenticator": {"users": {"hhaim": "432768ec1d"}}}`,
+		supInit:     `{"user": "nobody", "password": "432768ec1d"}`,
+		expSupState: EAP_DONE_FAIL,
+		expAuthSm:   AUTH_STATE_FAIL,
+	}
+	a.Run(t)
+}
+
+// TestPlugindot1xAuth_sessions - sessions are freed on logoff and after the hold time, so more than max_sessions supplicants can authenticate
+func TestPlugindot1xAuth_sessions(t *testing.T) {
+	var simVeth VethLoopSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{})
+	tctx.RegisterParserCb("dot1x")
+	tctx.Veth.SetDebug(monitor > 0, os.Stdout, false)
+
+	addClient := func(mac core.MACKey, init string) *PluginDot1xClient {
+		client := core.NewClient(ns, mac, core.Ipv4Key{0, 0, 0, 0}, core.Ipv6Key{}, core.Ipv4Key{0, 0, 0, 0})
+		ns.AddClient(client)
+		client.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{[]byte(init)})
+		return getDot1xPlug(t, ns, &mac)
+	}
+	auth := addClient(authTestMac, `{"authenticator": {"users": {"hhaim": "432768ec1d"}, "max_sessions": 1, "hold_sec": 5}}`)
+	supInit := `{"user": "hhaim", "password": "432768ec1d"}`
+
+	// first supplicant authenticates and logs off
+	sup := addClient(supTestMac, supInit)
+	tctx.MainLoopSim(2 * time.Second)
+	if sup.smState != EAP_DONE_OK {
+		t.Fatalf(" first supplicant state %d, expected %d", sup.smState, EAP_DONE_OK)
+	}
+	sup.SendLogoffPacket()
+	tctx.MainLoopSim(1 * time.Second)
+	if len(auth.auth.GetSessions()) != 0 || auth.auth.stats.authActiveSessions != 0 {
+		t.Fatalf(" session was not freed on logoff %+v", auth.auth.GetSessions())
+	}
+
+	// second supplicant takes the free session, it is freed after the hold time
+	sup2Mac := core.MACKey{0, 0, 1, 0, 0, 3}
+	sup2 := addClient(sup2Mac, supInit)
+	tctx.MainLoopSim(2 * time.Second)
+	if sup2.smState != EAP_DONE_OK {
+		t.Fatalf(" second supplicant state %d, expected %d", sup2.smState, EAP_DONE_OK)
+	}
+	sessions := auth.auth.GetSessions()
+	if len(sessions) != 1 || sessions[0].Mac != sup2Mac || sessions[0].State != AUTH_STATE_SUCCESS {
+		t.Fatalf(" unexpected authenticator sessions %+v", sessions)
+	}
+	tctx.MainLoopSim(6 * time.Second)
+	if len(auth.auth.GetSessions()) != 0 || auth.auth.stats.authActiveSessions != 0 {
+		t.Fatalf(" session was not freed after the hold time %+v", auth.auth.GetSessions())
+	}
+
+	// third supplicant after the hold time
+	sup3Mac := core.MACKey{0, 0, 1, 0, 0, 4}
+	sup3 := addClient(sup3Mac, supInit)
+	tctx.MainLoopSim(2 * time.Second)
+	if sup3.smState != EAP_DONE_OK {
+		t.Fatalf(" third supplicant state %d, expected %d", sup3.smState, EAP_DONE_OK)
+	}
+	if auth.auth.stats.authSessionsFull != 0 {
+		t.Fatalf(" %d supplicants were refused", auth.auth.stats.authSessionsFull)
+	}
+	auth.cdbv.Dump()
+}
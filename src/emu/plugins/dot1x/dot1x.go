@@ -10,17 +10,24 @@ RFC 8415  dot1x client with
 
 EAP-MD5
 EAP-MSCHAPv2
+EAP-TLS
+PEAPv0/EAP-MSCHAPv2
+EAP-TTLSv0/PAP
+
+and an authenticator role (see authenticator.go)
 
 
 */
 
 import (
 	"bytes"
+	"crypto/tls"
 	"emu/core"
 	"encoding/binary"
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
+	"fmt"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -47,24 +54,42 @@ const (
 	MAX_STARTS_CNT    = 3
 
 	EAP_TYPE_MD5      = 4
+	EAP_TYPE_TLS      = 13
+	EAP_TYPE_TTLS     = 21
+	EAP_TYPE_PEAP     = 25
 	EAP_TYPE_MSCHAPV2 = 26
 
+	// published events
+	DOT1X_EVENT_SUCCESS = "dot1x.success"
+	DOT1X_EVENT_FAILURE = "dot1x.failure"
+
 	MAX_EAPOL_VER      = 3
 	EAPSIZE_PKT_HEADER = 5
 
 	EAP_MD5_MASK      = 1
 	EAP_MSCHAPv2_MASK = 2
+	EAP_TLS_MASK      = 4
+	EAP_PEAP_MASK     = 8
+	EAP_TTLS_MASK     = 0x10
 )
 
 var dot1xDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
 
 type Dot1xCfg struct {
-	User       *string `json:"user"`       // user name
-	Password   *string `json:"password"`   // password
-	Nthash     *string `json:"nthash"`     // hash string for MSCHAPv2
-	Flags      uint32  `json:"flags"`      // not used
-	TimeoutSec uint32  `json:"timeo_idle"` // timeout for success in sec
-	MaxStart   uint32  `json:"max_start"`  // max number of retries
+	User       *string `json:"user"`        // user name
+	Password   *string `json:"password"`    // password
+	Nthash     *string `json:"nthash"`      // hash string for MSCHAPv2
+	Flags      uint32  `json:"flags"`       // mask of methods to disable
+	TimeoutSec uint32  `json:"timeo_idle"`  // timeout for success in sec
+	MaxStart   uint32  `json:"max_start"`   // max number of retries
+	AnonUser   *string `json:"anon_user"`   // outer identity (e.g. anonymous for PEAP/TTLS), user if not provided
+	Cert       *string `json:"cert"`        // client certificate PEM for EAP-TLS
+	Key        *string `json:"key"`         // client private key PEM for EAP-TLS
+	Ca         *string `json:"ca"`          // CA PEM to verify the server, no verification if not provided
+	ServerName *string `json:"server_name"` // expected server name in the certificate
+	FragSize   uint32  `json:"frag_size"`   // max EAP-TLS fragment size
+
+	Authenticator *Dot1xAuthCfg `json:"authenticator"` // act as authenticator instead of supplicant
 }
 
 type Dot1xStats struct {
@@ -82,6 +107,12 @@ type Dot1xStats struct {
 	pktMethodNoPassword    uint64
 	pktMethodWrongLen      uint64
 	pktMethodFailErr       uint64
+	pktTlsFragTx           uint64
+	pktTlsFragRx           uint64
+	pktTlsRxFragErr        uint64
+	pktTlsHandshakeErr     uint64
+	tlsHandshakeOk         uint64
+	pktInnerMethodErr      uint64
 }
 
 func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
@@ -199,6 +230,54 @@ func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsFragTx,
+		Name:     "pktTlsFragTx",
+		Help:     "tx EAP-TLS fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsFragRx,
+		Name:     "pktTlsFragRx",
+		Help:     "rx EAP-TLS fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsRxFragErr,
+		Name:     "pktTlsRxFragErr",
+		Help:     "rx EAP-TLS reassembly error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsHandshakeErr,
+		Name:     "pktTlsHandshakeErr",
+		Help:     "TLS handshake error",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsHandshakeOk,
+		Name:     "tlsHandshakeOk",
+		Help:     "TLS handshake done",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktInnerMethodErr,
+		Name:     "pktInnerMethodErr",
+		Help:     "tunneled inner method error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -229,9 +308,10 @@ type Dot1xMethodIF interface {
 type MethodToHandler map[uint8]Dot1xMethodIF
 
 type Dot1xClientInfo struct {
-	State          uint8 `json:"state"`
-	SelectedMethod uint8 `json:"method"`
-	EapVer         uint8 `json:"eap_version"`
+	State          uint8  `json:"state"`
+	SelectedMethod uint8  `json:"method"`
+	MethodName     string `json:"method_name"` // e.g. eap-peap/mschapv2
+	EapVer         uint8  `json:"eap_version"`
 }
 
 // PluginDot1xClient information per client
@@ -257,9 +337,15 @@ type PluginDot1xClient struct {
 	eapPktTemplate   []byte
 	l3Offset         uint16
 	nack             []byte
+	tlsCfg           *tls.Config
+	auth             *dot1xAuthenticator
+}
+
+func (o *PluginDot1xClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
 }
 
-var dot1xEvents = []string{}
+var dot1xEvents = []string{core.MSG_CLIENT_ACTIVATED}
 
 /*Dot1x create plugin */
 func NewDot1xClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -272,6 +358,19 @@ func NewDot1xClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 	if err != nil {
 		return nil, err
 	}
+	err = o.buildTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.cfg.Authenticator != nil {
+		err = validateAuthCfg(o.cfg.Authenticator)
+		if err != nil {
+			return nil, err
+		}
+		if o.nsPlug.auth != nil {
+			return nil, fmt.Errorf("dot1x authenticator already exists in this namespace")
+		}
+	}
 	o.OnCreate()
 
 	return &o.PluginBase, nil
@@ -302,16 +401,40 @@ func (o *PluginDot1xClient) OnCreate() {
 	if o.cfg.Flags&EAP_MSCHAPv2_MASK == 0 {
 		o.mapHandler[EAP_TYPE_MSCHAPV2] = NewEapMschapv2()
 	}
+	// EAP-TLS is enabled only with a client certificate
+	if o.cfg.Flags&EAP_TLS_MASK == 0 && len(o.tlsCfg.Certificates) > 0 {
+		o.mapHandler[EAP_TYPE_TLS] = NewEapTls()
+	}
+	if o.cfg.Flags&EAP_PEAP_MASK == 0 {
+		o.mapHandler[EAP_TYPE_PEAP] = NewEapPeap()
+	}
+	if o.cfg.Flags&EAP_TTLS_MASK == 0 {
+		o.mapHandler[EAP_TYPE_TTLS] = NewEapTtls()
+	}
 
-	if o.cfg.Flags&EAP_MD5_MASK == 0 {
-		o.nack = []byte{EAP_TYPE_MD5}
-	} else {
+	// the nack holds the supported methods by preference
+	o.nack = make([]byte, 0)
+	for _, t := range []uint8{EAP_TYPE_MD5, EAP_TYPE_MSCHAPV2, EAP_TYPE_TLS, EAP_TYPE_PEAP, EAP_TYPE_TTLS} {
+		if _, ok := o.mapHandler[t]; ok {
+			o.nack = append(o.nack, t)
+		}
+	}
+	if len(o.nack) == 0 {
 		o.nack = []byte{EAP_TYPE_MSCHAPV2}
 	}
 
 	o.eapVer = MAX_EAPOL_VER
 	o.smCnt = 0
-	o.StartSm()
+	if o.cfg.Authenticator != nil {
+		o.auth = newDot1xAuthenticator(o, o.cfg.Authenticator)
+		o.cdbv.Add(o.auth.cdb)
+		o.nsPlug.auth = o
+		return
+	}
+	o.Client.RequireMilestone(core.CLIENT_MS_L2_AUTH)
+	if o.Client.IsActive() {
+		o.StartSm()
+	} /* else, start when the ramp-up activates the client */
 }
 
 func (o *PluginDot1xClient) changeToInit() {
@@ -322,6 +445,7 @@ func (o *PluginDot1xClient) changeToInit() {
 }
 
 func (o *PluginDot1xClient) StartSm() {
+	o.Client.ClearMilestone(core.CLIENT_MS_L2_AUTH)
 	o.changeToInit()
 	o.SendStartPacket()
 	o.restartTimer()
@@ -396,7 +520,12 @@ func (o *PluginDot1xClient) SendResponsePacket(code uint8,
 
 /*OnEvent support event change of IP  */
 func (o *PluginDot1xClient) OnEvent(msg string, a, b interface{}) {
-
+	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		if o.auth == nil {
+			o.StartSm()
+		}
+	}
 }
 
 func (o *PluginDot1xClient) OnRemove(ctx *core.PluginCtx) {
@@ -405,6 +534,17 @@ func (o *PluginDot1xClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	for _, h := range o.mapHandler {
+		h.OnRemove()
+	}
+	if o.auth != nil {
+		o.auth.OnRemove()
+		if o.nsPlug.auth == o {
+			o.nsPlug.auth = nil
+		}
+	} else {
+		o.Client.ReleaseMilestone(core.CLIENT_MS_L2_AUTH)
+	}
 }
 
 func (o *PluginDot1xClient) makeSurereTimerIsRunning() {
@@ -434,6 +574,8 @@ func (o *PluginDot1xClient) onTimerEvent() {
 	if uint32(o.smCnt) < o.cfg.MaxStart {
 		// restart
 		o.StartSm()
+	} else if uint32(o.smCnt) == o.cfg.MaxStart {
+		o.publishResult(DOT1X_EVENT_FAILURE, "timeout")
 	}
 
 }
@@ -516,12 +658,15 @@ func (o *PluginDot1xClient) sendNack(eap *layers.EAP) {
 }
 
 func (o *PluginDot1xClient) handleFailure(eap *layers.EAP) {
-	if o.smState != EAP_WAIT_FOR_RESULTS {
+	// the authenticator may reject in the middle of the method (e.g. wrong MSCHAPv2 response, unknown user)
+	if o.smState != EAP_WAIT_FOR_RESULTS && o.smState != EAP_WAIT_FOR_METHOD {
 		o.stats.pktSuccessWrongState++
 		return
 	}
 
 	o.smState = EAP_DONE_FAIL
+	o.Client.ClearMilestone(core.CLIENT_MS_L2_AUTH)
+	o.publishResult(DOT1X_EVENT_FAILURE, "failure")
 }
 
 func (o *PluginDot1xClient) handleSuccess(eap *layers.EAP) {
@@ -546,20 +691,36 @@ func (o *PluginDot1xClient) handleSuccess(eap *layers.EAP) {
 
 	if suc {
 		o.smState = EAP_DONE_OK
+		o.Client.ReachMilestone(core.CLIENT_MS_L2_AUTH)
+		o.publishResult(DOT1X_EVENT_SUCCESS, "")
 	} else {
 		o.stats.pktMethodFailErr++
 		o.smState = EAP_DONE_FAIL
+		o.Client.ClearMilestone(core.CLIENT_MS_L2_AUTH)
+		o.publishResult(DOT1X_EVENT_FAILURE, "method")
 	}
 }
 
+// publishResult publishes the result of the authentication, reason is why it failed.
+func (o *PluginDot1xClient) publishResult(topic string, reason string) {
+	o.Client.PublishEvent(topic, &struct {
+		Method uint8  `json:"method"`
+		Reason string `json:"reason,omitempty"`
+	}{o.selectedMethod, reason})
+}
+
 func (o *PluginDot1xClient) handleRequest(eap *layers.EAP) {
 	if eap.Type == layers.EAPTypeIdentity {
 		// accepted on all states
 		if o.cfg.User != nil {
+			user := o.cfg.User
+			if o.cfg.AnonUser != nil {
+				user = o.cfg.AnonUser
+			}
 			o.stats.pktTxIdentity++
 			o.SendResponsePacket(uint8(layers.EAPCodeResponse),
 				eap.Id, uint8(layers.EAPTypeIdentity),
-				[]byte(*o.cfg.User))
+				[]byte(*user))
 			o.smState = EAP_WAIT_FOR_METHOD
 			o.makeSurereTimerIsRunning()
 		} else {
@@ -601,6 +762,7 @@ func (o *PluginDot1xClient) handleRequest(eap *layers.EAP) {
 // PluginDot1xNs information per namespace
 type PluginDot1xNs struct {
 	core.PluginBase
+	auth *PluginDot1xClient // the authenticator client, if exists
 }
 
 func NewDot1xNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -631,6 +793,10 @@ func (o *PluginDot1xNs) HandleRxDot1xPacket(ps *core.ParserPacketState) int {
 	copy(mackey[:], p[0:6])
 	var client *core.CClient
 
+	if o.auth != nil && o.isToAuthenticator(ps) {
+		return o.auth.auth.HandleRxPacket(ps)
+	}
+
 	if bytes.Equal(p[0:6], dot1xDefaultDestMAC) {
 		client = o.Ns.GetFirstClient() // we don't support multicast .. for  // TBD this should be tested
 	} else {
@@ -648,6 +814,28 @@ func (o *PluginDot1xNs) HandleRxDot1xPacket(ps *core.ParserPacketState) int {
 	return plug.HandleRxDot1xPacket(ps)
 }
 
+// isToAuthenticator checks if the packet was sent by a supplicant to the authenticator
+func (o *PluginDot1xNs) isToAuthenticator(ps *core.ParserPacketState) bool {
+	p := ps.M.GetData()
+	amac := o.auth.Client.Mac
+	if bytes.Equal(p[6:12], amac[:]) {
+		return false
+	}
+	if !bytes.Equal(p[0:6], dot1xDefaultDestMAC) && !bytes.Equal(p[0:6], amac[:]) {
+		return false
+	}
+	if uint32(ps.L3+4) >= ps.M.PktLen() {
+		return true
+	}
+	switch layers.EAPOLType(p[ps.L3+1]) {
+	case layers.EAPOLTypeStart, layers.EAPOLTypeLogOff:
+		return true
+	case layers.EAPOLTypeEAP:
+		return p[ps.L3+4] == uint8(layers.EAPCodeResponse)
+	}
+	return false
+}
+
 // HandleRxDot1xPacket Parser call this function with mbuf from the pool
 func HandleRxDot1xPacket(ps *core.ParserPacketState) int {
 
@@ -681,8 +869,9 @@ func (o PluginDot1xNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiDot1xClientCntHandler  struct{}
-	ApiDot1xClientInfoHandler struct{}
+	ApiDot1xClientCntHandler      struct{}
+	ApiDot1xClientInfoHandler     struct{}
+	ApiDot1xClientAuthInfoHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginDot1xNs, *jsonrpc.Error) {
@@ -735,12 +924,33 @@ func (h ApiDot1xClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjso
 		pc = p.Ext.(*PluginDot1xClient)
 		rp.State = pc.smState
 		rp.SelectedMethod = pc.selectedMethod
+		if h, ok := pc.mapHandler[pc.selectedMethod]; ok {
+			rp.MethodName = h.GetName()
+		}
 		rp.EapVer = pc.eapVer
 	}
 
 	return res, nil
 }
 
+func (h ApiDot1xClientAuthInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	if c.auth == nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "client is not a dot1x authenticator",
+		}
+	}
+	return c.auth.GetSessions(), nil
+}
+
 func (h ApiDot1xClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
 	var p core.ApiCntParams
@@ -781,6 +991,8 @@ func init() {
 	core.RegisterCB("dot1x_client_info", ApiDot1xClientInfoHandler{}, false) // get info per array
 
 	core.RegisterCB("dot1x_client_cnt", ApiDot1xClientCntHandler{}, false) // get counters/meta
+
+	core.RegisterCB("dot1x_client_auth_info", ApiDot1xClientAuthInfoHandler{}, false) // get authenticator supplicants
 	// TBD getter for the client info
 
 	/* register callback for rx side*/
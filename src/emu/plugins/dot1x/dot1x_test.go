@@ -14,6 +14,7 @@ import (
 	"external/google/gopacket/layers"
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
 	"testing"
 	"time"
@@ -58,7 +59,7 @@ func (o *Dot1xTestBase) Run(t *testing.T) {
 	tctx.MainLoopSim(o.duration)
 	defer tctx.Delete()
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 
 	ns := tctx.GetNs(&key)
 	if ns == nil {
@@ -82,7 +83,7 @@ func (o *Dot1xTestBase) Run(t *testing.T) {
 func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 	ns := core.NewNSCtx(tctx, &key)
 
 	tctx.AddNs(&key, ns)
@@ -284,9 +285,10 @@ func TestPlugindot1x_2(t *testing.T) {
 func TestPlugindot1x_3(t *testing.T) {
 	var a []byte
 	a = make([]byte, 0)
+	rnd := rand.New(rand.NewSource(1))
 
 	for i := 0; i < 2; i++ {
-		genChalange16B(&a)
+		genChalange16B(rnd, &a)
 		fmt.Printf("%d,%d,%s \n", i, len(a), hex.Dump(a))
 
 	}
@@ -57,7 +57,7 @@ func (o *EapMschapv2Handler) BuildResp(d *Dot1xMethodData) (bool, bool, []byte)
 					if d.plug.Tctx.Simulation {
 						o.peerChallenge = []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}
 					} else {
-						genChalange16B(&o.peerChallenge)
+						genChalange16B(d.plug.Ns.Rand(), &o.peerChallenge)
 					}
 					res, e := Encryptv2(authChallenge, o.peerChallenge, *user, *passwd)
 					if e != nil {
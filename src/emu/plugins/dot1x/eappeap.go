@@ -0,0 +1,147 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+PEAPv0 with inner EAP-MSCHAPv2 (draft-kamath-pppext-peapv0)
+
+Inside the tunnel the EAP header (code, id, length) is omitted, only the
+type and the type data are sent. The Extensions (type 33) packets are sent
+with the full header and carry the Result TLV.
+*/
+
+import (
+	"emu/plugins/tls_utils"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+)
+
+const (
+	EAP_TYPE_EXTENSIONS = 33
+
+	PEAP_TLV_MANDATORY = 0x8000
+	PEAP_TLV_RESULT    = 3
+
+	PEAP_RESULT_SUCCESS = 1
+	PEAP_RESULT_FAILURE = 2
+)
+
+type eapPeapInner struct {
+	mschapv2 Dot1xMethodIF
+	r        []byte
+}
+
+func (o *eapPeapInner) InnerName() string {
+	return "mschapv2"
+}
+
+func (o *eapPeapInner) Reset() {
+	o.mschapv2 = NewEapMschapv2()
+}
+
+func (o *eapPeapInner) OnHandshake(d *Dot1xMethodData, s *tls_utils.TlsSession) bool {
+	// wait for the inner identity
+	return false
+}
+
+func (o *eapPeapInner) write(d *Dot1xMethodData, s *tls_utils.TlsSession, b []byte) {
+	if s.Write(b) != nil {
+		d.plug.stats.pktInnerMethodErr++
+	}
+}
+
+// isExtensions checks for a full EAP Extensions packet
+func (o *eapPeapInner) isExtensions(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	if data[0] != uint8(layers.EAPCodeRequest) || data[4] != EAP_TYPE_EXTENSIONS {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(data[2:4])) == len(data)
+}
+
+func (o *eapPeapInner) handleExtensions(d *Dot1xMethodData, s *tls_utils.TlsSession, data []byte) bool {
+	id := data[1]
+	status := uint16(PEAP_RESULT_FAILURE)
+	tlvs := data[5:]
+	for len(tlvs) >= 4 {
+		t := binary.BigEndian.Uint16(tlvs[0:2]) &^ PEAP_TLV_MANDATORY
+		l := int(binary.BigEndian.Uint16(tlvs[2:4]))
+		if len(tlvs) < 4+l {
+			break
+		}
+		if t == PEAP_TLV_RESULT && l == 2 {
+			status = binary.BigEndian.Uint16(tlvs[4:6])
+		}
+		tlvs = tlvs[4+l:]
+	}
+	if status != PEAP_RESULT_SUCCESS {
+		d.plug.stats.pktInnerMethodErr++
+		status = PEAP_RESULT_FAILURE
+	}
+
+	o.r = o.r[:0]
+	o.r = append(o.r, uint8(layers.EAPCodeResponse), id, 0, 11, EAP_TYPE_EXTENSIONS)
+	o.r = append(o.r, 0x80, PEAP_TLV_RESULT, 0, 2, 0, uint8(status))
+	o.write(d, s, o.r)
+	return true
+}
+
+func (o *eapPeapInner) OnData(d *Dot1xMethodData, s *tls_utils.TlsSession, data []byte) bool {
+	if o.isExtensions(data) {
+		return o.handleExtensions(d, s, data)
+	}
+	if len(data) < 1 || d.plug.cfg.User == nil {
+		d.plug.stats.pktInnerMethodErr++
+		return false
+	}
+
+	t := data[0]
+	o.r = o.r[:0]
+	switch t {
+	case uint8(layers.EAPTypeIdentity):
+		o.r = append(o.r, t)
+		o.r = append(o.r, []byte(*d.plug.cfg.User)...)
+
+	case EAP_TYPE_MSCHAPV2:
+		var obj Dot1xMethodData
+		obj.plug = d.plug
+		obj.eap = &layers.EAP{
+			Code:     layers.EAPCodeRequest,
+			Id:       d.eap.Id,
+			Length:   uint16(len(data) + 4),
+			Type:     layers.EAPType(t),
+			TypeData: data[1:],
+		}
+		ok, _, res := o.mschapv2.BuildResp(&obj)
+		if !ok {
+			d.plug.stats.pktInnerMethodErr++
+			return false
+		}
+		o.r = append(o.r, t)
+		o.r = append(o.r, res...)
+
+	default:
+		d.plug.stats.pktTxNack++
+		o.r = append(o.r, uint8(layers.EAPTypeNACK), EAP_TYPE_MSCHAPV2)
+	}
+	o.write(d, s, o.r)
+	return false
+}
+
+// EapPeapHandler PEAPv0/EAP-MSCHAPv2
+type EapPeapHandler struct {
+	EapTlsBase
+	inner eapPeapInner
+}
+
+func NewEapPeap() Dot1xMethodIF {
+	p := new(EapPeapHandler)
+	p.inner.Reset()
+	p.init("eap-peap", EAP_TYPE_PEAP, &p.inner)
+	return p
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+EAP-TLS (RFC 5216) framing shared by EAP-TLS, PEAP and EAP-TTLS.
+
+The TLS handshake is driven by the synchronous engine of tls_utils: feed a
+reassembled server message, get back the client flight.
+
+EAP-TLS flags
+
+	 0 1 2 3 4 5 6 7
+	+-+-+-+-+-+-+-+-+
+	|L M S R R V V V|
+	+-+-+-+-+-+-+-+-+
+
+	L = Length included
+	M = More fragments
+	S = EAP-TLS start
+	V = version (PEAP/TTLS)
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"emu/plugins/tls_utils"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	EAP_TLS_FLAGS_LEN   = 0x80
+	EAP_TLS_FLAGS_MORE  = 0x40
+	EAP_TLS_FLAGS_START = 0x20
+	EAP_TLS_FLAGS_VER   = 0x07
+
+	EAP_TLS_DEFAULT_FRAG_SIZE = 1000
+	EAP_TLS_MIN_FRAG_SIZE     = 64
+	EAP_TLS_MAX_FRAG_SIZE     = 1400
+	EAP_TLS_MAX_MSG_SIZE      = 64 * 1024
+)
+
+// eapTlsInnerIF is the part of the method that is specific to EAP-TLS/PEAP/TTLS.
+type eapTlsInnerIF interface {
+	// OnHandshake is called once the tunnel is up, returns true if the method is done.
+	OnHandshake(d *Dot1xMethodData, s *tls_utils.TlsSession) bool
+	// OnData is called with application data from the tunnel, returns true if the method is done.
+	OnData(d *Dot1xMethodData, s *tls_utils.TlsSession, data []byte) bool
+	// Reset is called on a new EAP-TLS start.
+	Reset()
+	// InnerName is the name of the inner method, empty if none.
+	InnerName() string
+}
+
+// EapTlsBase implements the EAP-TLS framing, fragmentation and reassembly.
+type EapTlsBase struct {
+	name     string
+	eapType  uint8
+	ver      uint8
+	inner    eapTlsInnerIF
+	session  *tls_utils.TlsSession
+	rxBuf    []byte
+	rxTotal  uint32
+	txBuf    []byte
+	txOffset int
+	hsDone   bool
+	finished bool
+	r        []byte
+}
+
+func (o *EapTlsBase) init(name string, eapType uint8, inner eapTlsInnerIF) {
+	o.name = name
+	o.eapType = eapType
+	o.inner = inner
+	o.r = make([]byte, 0)
+}
+
+func (o *EapTlsBase) GetName() string {
+	if o.inner != nil && o.inner.InnerName() != "" {
+		return o.name + "/" + o.inner.InnerName()
+	}
+	return o.name
+}
+
+func (o *EapTlsBase) reset() {
+	if o.session != nil {
+		o.session.Close()
+		o.session = nil
+	}
+	o.rxBuf = o.rxBuf[:0]
+	o.rxTotal = 0
+	o.txBuf = nil
+	o.txOffset = 0
+	o.hsDone = false
+	o.finished = false
+	if o.inner != nil {
+		o.inner.Reset()
+	}
+}
+
+// buildFrag builds the next fragment of txBuf, an empty txBuf is an ack.
+func (o *EapTlsBase) buildFrag(d *Dot1xMethodData) []byte {
+	fragSize := int(d.plug.cfg.FragSize)
+	o.r = o.r[:0]
+	left := len(o.txBuf) - o.txOffset
+	if left <= 0 {
+		o.txBuf = nil
+		o.txOffset = 0
+		o.r = append(o.r, o.ver)
+		return o.r
+	}
+	flags := o.ver
+	if left > fragSize {
+		flags |= EAP_TLS_FLAGS_MORE
+		left = fragSize
+	}
+	if o.txOffset == 0 && (flags&EAP_TLS_FLAGS_MORE) != 0 {
+		flags |= EAP_TLS_FLAGS_LEN
+		o.r = append(o.r, flags, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(o.r[1:5], uint32(len(o.txBuf)))
+	} else {
+		o.r = append(o.r, flags)
+	}
+	if flags&EAP_TLS_FLAGS_MORE != 0 || o.txOffset > 0 {
+		d.plug.stats.pktTlsFragTx++
+	}
+	o.r = append(o.r, o.txBuf[o.txOffset:o.txOffset+left]...)
+	o.txOffset += left
+	if o.txOffset == len(o.txBuf) {
+		o.txBuf = nil
+		o.txOffset = 0
+	}
+	return o.r
+}
+
+func (o *EapTlsBase) handleEvents(d *Dot1xMethodData, evs []tls_utils.TlsEvent) bool {
+	for _, ev := range evs {
+		if ev.Handshake {
+			if ev.Err != nil {
+				d.plug.stats.pktTlsHandshakeErr++
+				return false
+			}
+			o.hsDone = true
+			d.plug.stats.tlsHandshakeOk++
+			if o.inner == nil || o.inner.OnHandshake(d, o.session) {
+				o.finished = true
+			}
+			continue
+		}
+		if len(ev.Data) > 0 && o.inner != nil {
+			if o.inner.OnData(d, o.session, ev.Data) {
+				o.finished = true
+			}
+		}
+		if ev.Err != nil && ev.Err != io.EOF {
+			d.plug.stats.pktTlsHandshakeErr++
+			return false
+		}
+	}
+	return true
+}
+
+func (o *EapTlsBase) BuildResp(d *Dot1xMethodData) (bool, bool, []byte) {
+	td := d.eap.TypeData
+	if len(td) < 1 {
+		d.plug.stats.pktMethodWrongLen++
+		return false, false, []byte{}
+	}
+	flags := td[0]
+	data := td[1:]
+	if flags&EAP_TLS_FLAGS_LEN != 0 {
+		if len(data) < 4 {
+			d.plug.stats.pktMethodWrongLen++
+			return false, false, []byte{}
+		}
+		o.rxTotal = binary.BigEndian.Uint32(data[0:4])
+		data = data[4:]
+		if o.rxTotal > EAP_TLS_MAX_MSG_SIZE {
+			d.plug.stats.pktTlsRxFragErr++
+			return false, false, []byte{}
+		}
+	}
+
+	if flags&EAP_TLS_FLAGS_START != 0 {
+		o.reset()
+		// PEAP/TTLS negotiate the version, we support only version 0
+		o.ver = 0
+		o.session = tls_utils.NewTlsSession(d.plug.tlsCfg, false)
+		if !o.handleEvents(d, o.session.Start()) {
+			o.reset()
+			return false, false, []byte{}
+		}
+		o.txBuf = o.session.TakeOut()
+		return true, false, o.buildFrag(d)
+	}
+
+	if o.session == nil {
+		d.plug.stats.pktMethodWrongstate++
+		return false, false, []byte{}
+	}
+
+	if len(data) == 0 && o.txBuf != nil {
+		// ack of our fragment, send the next one
+		return true, false, o.buildFrag(d)
+	}
+
+	o.rxBuf = append(o.rxBuf, data...)
+	if len(o.rxBuf) > EAP_TLS_MAX_MSG_SIZE {
+		d.plug.stats.pktTlsRxFragErr++
+		o.reset()
+		return false, false, []byte{}
+	}
+	if flags&EAP_TLS_FLAGS_MORE != 0 {
+		// ack the fragment
+		d.plug.stats.pktTlsFragRx++
+		return true, false, o.buildFrag(d)
+	}
+	if o.rxTotal != 0 && uint32(len(o.rxBuf)) != o.rxTotal {
+		d.plug.stats.pktTlsRxFragErr++
+		o.reset()
+		return false, false, []byte{}
+	}
+
+	msg := o.rxBuf
+	o.rxBuf = make([]byte, 0, len(msg))
+	o.rxTotal = 0
+
+	if len(msg) > 0 {
+		evs, err := o.session.Feed(msg)
+		if err != nil || !o.handleEvents(d, evs) {
+			o.reset()
+			return false, false, []byte{}
+		}
+	}
+	o.txBuf = o.session.TakeOut()
+	r := o.buildFrag(d)
+	// finished only when the last fragment of the last flight is out
+	return true, o.finished && o.txBuf == nil, r
+}
+
+func (o *EapTlsBase) Success(d *Dot1xMethodData) bool {
+	return o.finished
+}
+
+func (o *EapTlsBase) OnRemove() {
+	o.reset()
+}
+
+// EapTlsHandler EAP-TLS, certificate based authentication without inner method
+type EapTlsHandler struct {
+	EapTlsBase
+}
+
+func NewEapTls() Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.init("eap-tls", EAP_TYPE_TLS, nil)
+	return p
+}
+
+// buildTlsConfig builds the client TLS configuration from the PEM in the config.
+func (o *PluginDot1xClient) buildTlsConfig() error {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS10,
+		// EAP-TLS 1.3 (RFC 9190) is not supported
+		MaxVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+	}
+
+	if (o.cfg.Cert != nil) != (o.cfg.Key != nil) {
+		return fmt.Errorf("dot1x both cert and key should be provided")
+	}
+
+	if o.cfg.Cert != nil {
+		cert, err := tls.X509KeyPair([]byte(*o.cfg.Cert), []byte(*o.cfg.Key))
+		if err != nil {
+			return fmt.Errorf("dot1x invalid cert/key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.cfg.Ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(*o.cfg.Ca)) {
+			return fmt.Errorf("dot1x invalid ca, no PEM certificates found")
+		}
+		serverName := ""
+		if o.cfg.ServerName != nil {
+			serverName = *o.cfg.ServerName
+		}
+		// the server name in EAP is usually not a DNS name, verify the
+		// chain and check the name only if it was provided
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no server certificate")
+			}
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				c, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs[i] = c
+			}
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       serverName,
+				Intermediates: x509.NewCertPool(),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			}
+			for _, c := range certs[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+
+	if o.cfg.FragSize == 0 {
+		o.cfg.FragSize = EAP_TLS_DEFAULT_FRAG_SIZE
+	}
+	if o.cfg.FragSize < EAP_TLS_MIN_FRAG_SIZE || o.cfg.FragSize > EAP_TLS_MAX_FRAG_SIZE {
+		return fmt.Errorf("dot1x frag_size %d should be in the range [%d-%d]", o.cfg.FragSize,
+			EAP_TLS_MIN_FRAG_SIZE, EAP_TLS_MAX_FRAG_SIZE)
+	}
+
+	o.tlsCfg = cfg
+	return nil
+}
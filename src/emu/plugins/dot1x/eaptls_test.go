@@ -0,0 +1,504 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"emu/core"
+	"emu/plugins/tls_utils"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"external/google/gopacket/layers"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+const eapTlsTestServerName = "auth.trex-emu"
+
+// testPki is a self-signed CA with the certificates it issued, all in PEM
+type testPki struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPem string
+	serial  int64
+}
+
+func newTestPki(t *testing.T, cn string) *testPki {
+	o := &testPki{serial: 1}
+	o.certPem, _, o.cert, o.key = o.issue(t, cn, nil, true)
+	return o
+}
+
+// issue returns a certificate signed by the CA, or the self-signed CA certificate itself if isCa
+func (o *testPki) issue(t *testing.T, cn string, usage []x509.ExtKeyUsage, isCa bool) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(o.serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           usage,
+		BasicConstraintsValid: true,
+	}
+	parent, signer := tmpl, key
+	if isCa {
+		tmpl.IsCA = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.DNSNames = []string{cn}
+		parent, signer = o.cert, o.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPem := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	return certPem, keyPem, cert, key
+}
+
+// VethEapTlsSim is an authenticator with an EAP-TLS/TTLS/PEAP server, it answers the supplicant 0:0:1:0:0:1
+type VethEapTlsSim struct {
+	tctx     *core.CThreadCtx
+	cfg      *tls.Config
+	eapType  uint8
+	password string // expected inner PAP/MSCHAPv2 password of TTLS/PEAP
+	fragSize int
+	id       uint8
+	session  *tls_utils.TlsSession
+	rx       []byte
+	tx       []byte
+	txOff    int
+	hsDone   bool
+	hsErr    bool
+	innerOk  bool
+	authChal []byte // MSCHAPv2 challenge of PEAP
+	success  int
+	failure  int
+}
+
+func (o *VethEapTlsSim) request(eapType uint8, d []byte) *core.Mbuf {
+	o.id++
+	return genMbuf(o.tctx, GenerateOfferPacket(uint8(layers.EAPCodeRequest), o.id, eapType, d))
+}
+
+func (o *VethEapTlsSim) result(ok bool) *core.Mbuf {
+	code := layers.EAPCodeFailure
+	if ok {
+		code = layers.EAPCodeSuccess
+		o.success++
+	} else {
+		o.failure++
+	}
+	return genMbuf(o.tctx, GenerateOfferPacket(uint8(code), o.id, 0, []byte{}))
+}
+
+// nextFrag returns the next fragment of the pending server flight, the first one has the total length
+func (o *VethEapTlsSim) nextFrag() []byte {
+	var r []byte
+	left := len(o.tx) - o.txOff
+	if left > o.fragSize {
+		if o.txOff == 0 {
+			r = append(r, EAP_TLS_FLAGS_LEN|EAP_TLS_FLAGS_MORE, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(r[1:5], uint32(len(o.tx)))
+		} else {
+			r = append(r, EAP_TLS_FLAGS_MORE)
+		}
+		left = o.fragSize
+	} else {
+		r = append(r, 0)
+	}
+	r = append(r, o.tx[o.txOff:o.txOff+left]...)
+	o.txOff += left
+	if o.txOff == len(o.tx) {
+		o.tx, o.txOff = nil, 0
+	}
+	return r
+}
+
+// checkPap checks the User-Name/User-Password AVPs of TTLS
+func (o *VethEapTlsSim) checkPap(d []byte) {
+	var pass []byte
+	for len(d) >= TTLS_AVP_HEADER_SIZE {
+		code := binary.BigEndian.Uint32(d[0:4])
+		l := int(binary.BigEndian.Uint32(d[4:8]) & 0xffffff)
+		if l < TTLS_AVP_HEADER_SIZE || l > len(d) {
+			return
+		}
+		if code == TTLS_AVP_USER_PASSWORD {
+			pass = d[TTLS_AVP_HEADER_SIZE:l]
+		}
+		l = (l + 3) &^ 3
+		if l > len(d) {
+			break
+		}
+		d = d[l:]
+	}
+	for len(pass) > 0 && pass[len(pass)-1] == 0 {
+		pass = pass[:len(pass)-1]
+	}
+	o.innerOk = string(pass) == o.password
+}
+
+// peapWrite writes an inner PEAP packet, the header is omitted except for Extensions
+func (o *VethEapTlsSim) peapWrite(d []byte) {
+	if o.session.Write(d) != nil {
+		o.hsErr = true
+	}
+}
+
+// peapResult sends the Result TLV
+func (o *VethEapTlsSim) peapResult(status uint8) {
+	o.peapWrite([]byte{uint8(layers.EAPCodeRequest), o.id, 0, 11, EAP_TYPE_EXTENSIONS,
+		0x80, PEAP_TLV_RESULT, 0, 2, 0, status})
+}
+
+// onPeap answers the inner identity, the MSCHAPv2 response and success, and the Result TLV
+func (o *VethEapTlsSim) onPeap(d []byte) {
+	if len(d) == 11 && d[0] == uint8(layers.EAPCodeResponse) && d[4] == EAP_TYPE_EXTENSIONS {
+		o.innerOk = d[10] == PEAP_RESULT_SUCCESS
+		return
+	}
+	switch {
+	case d[0] == uint8(layers.EAPTypeIdentity):
+		o.authChal = []byte{9, 8, 7, 6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}
+		r := []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_CHALLENGE, o.id, 0, 0, 16}
+		r = append(r, o.authChal...)
+		r = append(r, []byte("trex")...)
+		binary.BigEndian.PutUint16(r[3:5], uint16(len(r)-1))
+		o.peapWrite(r)
+	case len(d) >= 55 && d[0] == EAP_TYPE_MSCHAPV2 && d[1] == MS_CHAPV2_RESPONSE:
+		peerChal, ntResp, user := d[6:22], d[30:54], string(d[55:])
+		res, err := Encryptv2(o.authChal, peerChal, user, o.password)
+		if err != nil || string(res.ChallengeResponse) != string(ntResp) {
+			o.peapResult(PEAP_RESULT_FAILURE)
+			return
+		}
+		r := []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_SUCCESS, d[2], 0, 0}
+		r = append(r, []byte(res.AuthenticatorResponse+" M=ok")...)
+		binary.BigEndian.PutUint16(r[3:5], uint16(len(r)-1))
+		o.peapWrite(r)
+	case len(d) == 2 && d[0] == EAP_TYPE_MSCHAPV2 && d[1] == MS_CHAPV2_SUCCESS:
+		o.peapResult(PEAP_RESULT_SUCCESS)
+	default:
+		o.peapResult(PEAP_RESULT_FAILURE)
+	}
+}
+
+func (o *VethEapTlsSim) onTls(flags uint8, data []byte) *core.Mbuf {
+	if flags&EAP_TLS_FLAGS_LEN != 0 {
+		data = data[4:]
+	}
+	if len(data) == 0 && len(o.tx) > 0 {
+		return o.request(o.eapType, o.nextFrag())
+	}
+	o.rx = append(o.rx, data...)
+	if flags&EAP_TLS_FLAGS_MORE != 0 {
+		return o.request(o.eapType, []byte{0})
+	}
+	if len(o.rx) > 0 {
+		evs, err := o.session.Feed(o.rx)
+		o.rx = nil
+		if err != nil {
+			o.hsErr = true
+		}
+		for _, ev := range evs {
+			if ev.Handshake {
+				o.hsDone = ev.Err == nil
+				o.hsErr = ev.Err != nil
+				if o.hsDone && o.eapType == EAP_TYPE_PEAP {
+					o.peapWrite([]byte{uint8(layers.EAPTypeIdentity)})
+				}
+			} else if len(ev.Data) > 0 {
+				if o.eapType == EAP_TYPE_PEAP {
+					o.onPeap(ev.Data)
+				} else {
+					o.checkPap(ev.Data)
+				}
+			}
+		}
+		o.tx = o.session.TakeOut()
+	}
+	if o.hsErr {
+		return o.result(false)
+	}
+	if len(o.tx) > 0 {
+		return o.request(o.eapType, o.nextFrag())
+	}
+	if !o.hsDone {
+		return o.result(false)
+	}
+	return o.result(o.eapType == EAP_TYPE_TLS || o.innerOk)
+}
+
+func (o *VethEapTlsSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	defer m.FreeMbuf()
+	p := m.GetData()
+	l3 := len(getL2())
+	if len(p) < l3+4 {
+		return nil
+	}
+	if layers.EAPOLType(p[l3+1]) == layers.EAPOLTypeStart {
+		o.id = 0
+		return o.request(uint8(layers.EAPTypeIdentity), []byte{})
+	}
+	eap := p[l3+4:]
+	if len(eap) < 5 || eap[0] != uint8(layers.EAPCodeResponse) {
+		return nil
+	}
+	eapLen := int(binary.BigEndian.Uint16(eap[2:4]))
+	if eapLen > len(eap) {
+		return nil
+	}
+	td := eap[5:eapLen]
+	switch eap[4] {
+	case uint8(layers.EAPTypeIdentity):
+		if o.session != nil {
+			o.session.Close()
+		}
+		o.session = tls_utils.NewTlsSession(o.cfg, true)
+		o.session.Start()
+		o.rx, o.tx, o.hsDone, o.hsErr, o.innerOk = nil, nil, false, false, false
+		return o.request(o.eapType, []byte{EAP_TLS_FLAGS_START})
+	case uint8(layers.EAPTypeNACK):
+		return o.result(false)
+	case o.eapType:
+		if len(td) < 1 {
+			return nil
+		}
+		return o.onTls(td[0], td[1:])
+	}
+	return nil
+}
+
+type Dot1xTlsTestBase struct {
+	eapType      uint8
+	duration     time.Duration
+	supCa        *testPki // CA of the supplicant to verify the server
+	supCertCa    *testPki // CA that issues the supplicant certificate
+	srvCertCa    *testPki // CA that issues the server certificate
+	srvClientCa  *testPki // CA of the server to verify the supplicant
+	password     string
+	srvPassword  string
+	expState     uint8
+	expMethod    string
+	expHsOk      uint64
+	expHsErr     uint64
+	expSrvResult bool
+}
+
+func (o *Dot1xTlsTestBase) Run(t *testing.T) {
+	srvCert, srvKey, _, _ := o.srvCertCa.issue(t, eapTlsTestServerName, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, false)
+	supCert, supKey, _, _ := o.supCertCa.issue(t, "hhaim", []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, false)
+	pair, err := tls.X509KeyPair([]byte(srvCert), []byte(srvKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(o.srvClientCa.cert)
+	srvCfg := &tls.Config{Certificates: []tls.Certificate{pair}, ClientCAs: pool}
+	if o.eapType == EAP_TYPE_TLS {
+		srvCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	var simVeth VethEapTlsSim
+	simVeth.cfg = srvCfg
+	simVeth.eapType = o.eapType
+	simVeth.password = o.srvPassword
+	simVeth.fragSize = 300
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	initJson, _ := json.Marshal(map[string]interface{}{
+		"user":        "hhaim",
+		"password":    o.password,
+		"cert":        supCert,
+		"key":         supKey,
+		"ca":          o.supCa.certPem,
+		"server_name": eapTlsTestServerName,
+		"frag_size":   200,
+	})
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	simVeth.tctx = tctx
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	client := core.NewClient(ns, supTestMac, core.Ipv4Key{0, 0, 0, 0}, core.Ipv6Key{}, core.Ipv4Key{0, 0, 0, 0})
+	ns.AddClient(client)
+	ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{})
+	client.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{initJson})
+	tctx.RegisterParserCb("dot1x")
+
+	tctx.Veth.SetDebug(monitor > 0, os.Stdout, false)
+	tctx.MainLoopSim(o.duration)
+	if simVeth.session != nil {
+		simVeth.session.Close()
+	}
+
+	sup := getDot1xPlug(t, ns, &supTestMac)
+	sup.cdbv.Dump()
+	if sup.smState != o.expState {
+		t.Fatalf(" supplicant state %d, expected %d", sup.smState, o.expState)
+	}
+	if o.expMethod != "" {
+		if h, ok := sup.mapHandler[sup.selectedMethod]; !ok || h.GetName() != o.expMethod {
+			t.Fatalf(" supplicant method %d, expected %s", sup.selectedMethod, o.expMethod)
+		}
+	}
+	if sup.stats.tlsHandshakeOk != o.expHsOk || sup.stats.pktTlsHandshakeErr != o.expHsErr {
+		t.Fatalf(" supplicant handshakes ok %d err %d, expected %d %d", sup.stats.tlsHandshakeOk,
+			sup.stats.pktTlsHandshakeErr, o.expHsOk, o.expHsErr)
+	}
+	if o.expSrvResult != (simVeth.success == 1 && simVeth.failure == 0) {
+		t.Fatalf(" server sent success %d failure %d", simVeth.success, simVeth.failure)
+	}
+	if sup.stats.pktTlsRxFragErr != 0 {
+		t.Fatalf(" supplicant reassembly errors %d", sup.stats.pktTlsRxFragErr)
+	}
+	if sup.stats.pktTlsFragRx == 0 || sup.stats.pktTlsFragTx == 0 {
+		t.Fatalf(" fragmentation was not exercised rx %d tx %d", sup.stats.pktTlsFragRx, sup.stats.pktTlsFragTx)
+	}
+}
+
+func TestPlugindot1xTls_ok(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:      EAP_TYPE_TLS,
+		duration:     10 * time.Second,
+		supCa:        ca,
+		supCertCa:    ca,
+		srvCertCa:    ca,
+		srvClientCa:  ca,
+		expState:     EAP_DONE_OK,
+		expHsOk:      1,
+		expSrvResult: true,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xTls_server_cert_rejected(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	rogue := newTestPki(t, "rogue-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:     EAP_TYPE_TLS,
+		duration:    10 * time.Second,
+		supCa:       ca,
+		supCertCa:   ca,
+		srvCertCa:   rogue,
+		srvClientCa: ca,
+		expState:    EAP_DONE_FAIL,
+		expHsErr:    1,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xTls_client_cert_rejected(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	rogue := newTestPki(t, "rogue-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:     EAP_TYPE_TLS,
+		duration:    10 * time.Second,
+		supCa:       ca,
+		supCertCa:   rogue,
+		srvCertCa:   ca,
+		srvClientCa: ca,
+		expState:    EAP_DONE_FAIL,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xTtls_pap(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:      EAP_TYPE_TTLS,
+		duration:     10 * time.Second,
+		supCa:        ca,
+		supCertCa:    ca,
+		srvCertCa:    ca,
+		srvClientCa:  ca,
+		password:     "432768ec1d",
+		srvPassword:  "432768ec1d",
+		expState:     EAP_DONE_OK,
+		expHsOk:      1,
+		expSrvResult: true,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xTtls_pap_wrong_password(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:     EAP_TYPE_TTLS,
+		duration:    10 * time.Second,
+		supCa:       ca,
+		supCertCa:   ca,
+		srvCertCa:   ca,
+		srvClientCa: ca,
+		password:    "wrong",
+		srvPassword: "432768ec1d",
+		expState:    EAP_DONE_FAIL,
+		expHsOk:     1,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xPeap_mschapv2(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:      EAP_TYPE_PEAP,
+		duration:     10 * time.Second,
+		supCa:        ca,
+		supCertCa:    ca,
+		srvCertCa:    ca,
+		srvClientCa:  ca,
+		password:     "432768ec1d",
+		srvPassword:  "432768ec1d",
+		expState:     EAP_DONE_OK,
+		expMethod:    "eap-peap/mschapv2",
+		expHsOk:      1,
+		expSrvResult: true,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xPeap_mschapv2_wrong_password(t *testing.T) {
+	ca := newTestPki(t, "trex-emu-ca")
+	a := &Dot1xTlsTestBase{
+		eapType:     EAP_TYPE_PEAP,
+		duration:    10 * time.Second,
+		supCa:       ca,
+		supCertCa:   ca,
+		srvCertCa:   ca,
+		srvClientCa: ca,
+		password:    "wrong",
+		srvPassword: "432768ec1d",
+		expState:    EAP_DONE_FAIL,
+		expMethod:   "eap-peap/mschapv2",
+		expHsOk:     1,
+	}
+	a.Run(t)
+}
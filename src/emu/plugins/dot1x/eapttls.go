@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+EAP-TTLSv0 with inner PAP (RFC 5281)
+
+Once the tunnel is up the client sends User-Name and User-Password AVPs
+
+	 0                   1                   2                   3
+	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|                           AVP Code                            |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|V M r r r r r r|                  AVP Length                   |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|  Data ...
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+import (
+	"emu/plugins/tls_utils"
+	"encoding/binary"
+)
+
+const (
+	TTLS_AVP_USER_NAME      = 1
+	TTLS_AVP_USER_PASSWORD  = 2
+	TTLS_AVP_FLAG_MANDATORY = 0x40
+	TTLS_AVP_HEADER_SIZE    = 8
+)
+
+func appendTtlsAvp(b []byte, code uint32, data []byte) []byte {
+	var h [TTLS_AVP_HEADER_SIZE]byte
+	binary.BigEndian.PutUint32(h[0:4], code)
+	binary.BigEndian.PutUint32(h[4:8], uint32(len(data)+TTLS_AVP_HEADER_SIZE))
+	h[4] = TTLS_AVP_FLAG_MANDATORY
+	b = append(b, h[:]...)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+type eapTtlsInner struct {
+	r []byte
+}
+
+func (o *eapTtlsInner) InnerName() string {
+	return "pap"
+}
+
+func (o *eapTtlsInner) Reset() {
+}
+
+func (o *eapTtlsInner) OnHandshake(d *Dot1xMethodData, s *tls_utils.TlsSession) bool {
+	if d.plug.cfg.Password == nil || d.plug.cfg.User == nil {
+		d.plug.stats.pktMethodNoPassword++
+		return false
+	}
+	// the password is padded to a multiple of 16 bytes
+	pass := []byte(*d.plug.cfg.Password)
+	for len(pass) == 0 || len(pass)%16 != 0 {
+		pass = append(pass, 0)
+	}
+	o.r = o.r[:0]
+	o.r = appendTtlsAvp(o.r, TTLS_AVP_USER_NAME, []byte(*d.plug.cfg.User))
+	o.r = appendTtlsAvp(o.r, TTLS_AVP_USER_PASSWORD, pass)
+	if s.Write(o.r) != nil {
+		d.plug.stats.pktInnerMethodErr++
+		return false
+	}
+	return true
+}
+
+func (o *eapTtlsInner) OnData(d *Dot1xMethodData, s *tls_utils.TlsSession, data []byte) bool {
+	// PAP has no more round trips, the server answers with EAP-Success/Failure
+	return true
+}
+
+// EapTtlsHandler EAP-TTLSv0/PAP
+type EapTtlsHandler struct {
+	EapTlsBase
+	inner eapTtlsInner
+}
+
+func NewEapTtls() Dot1xMethodIF {
+	p := new(EapTtlsHandler)
+	p.init("eap-ttls", EAP_TYPE_TTLS, &p.inner)
+	return p
+}
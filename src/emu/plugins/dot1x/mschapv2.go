@@ -249,10 +249,10 @@ func authResponse(pass string, ntResponse []byte, peerChallenge []byte, authChal
 	return "S=" + strings.ToUpper(fmt.Sprintf("%x", y))
 }
 
-func genChalange16B(a *[]byte) {
+func genChalange16B(rnd *rand.Rand, a *[]byte) {
 	var r [16]byte
-	u0 := rand.Uint64()
-	u1 := rand.Uint64()
+	u0 := rnd.Uint64()
+	u1 := rnd.Uint64()
 	binary.LittleEndian.PutUint64(r[0:8], u0)
 	binary.LittleEndian.PutUint64(r[8:16], u1)
 	*a = (*a)[:0]
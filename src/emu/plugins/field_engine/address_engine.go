@@ -0,0 +1,225 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package field_engine
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+/* ------------------------------------------------------------------------------
+							Address Engines
+--------------------------------------------------------------------------------*/
+
+// AddressEngineParams is a struct of parameters for the address engines, ipv4, ipv6 and mac.
+type AddressEngineParams struct {
+	Offset uint16 `json:"offset"`                     // Offset in which to write in the packet
+	Prefix string `json:"prefix" validate:"required"` // Prefix of the generated addresses, i.e 10.0.0.0/24, 2001:db8::/64, 00:11:22:00:00:00/24
+	Op     string `json:"op" validate:"required"`     // Operation which provides the generation, can be {inc, dec, rand}
+	Step   uint64 `json:"step"`                       // Step to decrement or increment, rand will be ignored. Default=1.
+	Repeat uint16 `json:"repeat"`                     // Number of times to repeat current address. Default = 1.
+	Init   string `json:"init"`                       // Address in the prefix from where we start the generation. Default = prefix address.
+}
+
+// addressKind is the kind of the addresses an AddressEngine generates.
+type addressKind int
+
+const (
+	addressIPv4 addressKind = iota
+	addressIPv6
+	addressMac
+)
+
+// String returns the name of the address kind.
+func (k addressKind) String() string {
+	switch k {
+	case addressIPv4:
+		return "IPv4"
+	case addressIPv6:
+		return "IPv6"
+	}
+	return "MAC"
+}
+
+// length returns the length of the address in bytes.
+func (k addressKind) length() int {
+	switch k {
+	case addressIPv4:
+		return net.IPv4len
+	case addressIPv6:
+		return net.IPv6len
+	}
+	return 6
+}
+
+// AddressEngine is a field engine that generates IPv4, IPv6 or MAC addresses in a prefix.
+// The addresses are the prefix address + index, where the index is incremented, decremented
+// or randomly picked in the host part of the prefix.
+type AddressEngine struct {
+	par         *AddressEngineParams // Parameters
+	mgr         *FieldEngineManager  // Engine Manager
+	kind        addressKind          // Kind of address
+	base        *big.Int             // Prefix address
+	hosts       *big.Int             // Number of addresses in the prefix
+	step        *big.Int             // Step modulo the number of addresses
+	index       *big.Int             // Index of the current address in the prefix
+	value       *big.Int             // Current address, temporary for update
+	repeatCount uint16               // Number of times current address was repeated
+}
+
+// CreateIPv4Engine creates a new FieldEngineIF interface of type AddressEngine that generates IPv4 addresses.
+func CreateIPv4Engine(params *fastjson.RawMessage, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	return createAddressEngine(params, addressIPv4, mgr)
+}
+
+// CreateIPv6Engine creates a new FieldEngineIF interface of type AddressEngine that generates IPv6 addresses.
+func CreateIPv6Engine(params *fastjson.RawMessage, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	return createAddressEngine(params, addressIPv6, mgr)
+}
+
+// CreateMacEngine creates a new FieldEngineIF interface of type AddressEngine that generates MAC addresses.
+func CreateMacEngine(params *fastjson.RawMessage, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	return createAddressEngine(params, addressMac, mgr)
+}
+
+// createAddressEngine parses the params and creates an address engine.
+func createAddressEngine(params *fastjson.RawMessage, kind addressKind, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	p := AddressEngineParams{Step: 1, Repeat: 1}
+	err := mgr.tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		mgr.counters.invalidJson++
+		return nil, err
+	}
+	return NewAddressEngine(&p, kind, mgr)
+}
+
+// NewAddressEngine creates a new address engine.
+func NewAddressEngine(params *AddressEngineParams, kind addressKind, mgr *FieldEngineManager) (*AddressEngine, error) {
+	o := new(AddressEngine)
+	o.par = params
+	o.mgr = mgr
+	o.kind = kind
+
+	switch params.Op {
+	case "inc", "dec", "rand":
+	default:
+		mgr.counters.badOperation++
+		return nil, fmt.Errorf("Unsupported operation %v.", params.Op)
+	}
+	if params.Repeat == 0 {
+		params.Repeat = 1
+	}
+
+	addr, prefixLen, err := parseAddressPrefix(params.Prefix, kind)
+	if err != nil {
+		mgr.counters.invalidPrefix++
+		return nil, err
+	}
+	hostBits := uint(8*kind.length() - prefixLen)
+	o.hosts = new(big.Int).Lsh(big.NewInt(1), hostBits)
+	o.base = new(big.Int).SetBytes(addr)
+	// Clear the host bits of the prefix address.
+	o.base.Rsh(o.base, hostBits).Lsh(o.base, hostBits)
+	o.step = new(big.Int).SetUint64(params.Step)
+	o.step.Mod(o.step, o.hosts)
+	o.index = new(big.Int)
+	o.value = new(big.Int)
+
+	if params.Init != "" {
+		init, err := parseAddress(params.Init, kind)
+		if err != nil {
+			mgr.counters.badInitValue++
+			return nil, err
+		}
+		o.index.SetBytes(init).Sub(o.index, o.base)
+		if o.index.Sign() < 0 || o.index.Cmp(o.hosts) >= 0 {
+			mgr.counters.badInitValue++
+			return nil, fmt.Errorf("Init address %v is not in prefix %v.", params.Init, params.Prefix)
+		}
+	}
+
+	return o, nil
+}
+
+// parseAddress parses an address of the kind.
+func parseAddress(s string, kind addressKind) ([]byte, error) {
+	if kind == addressMac {
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != kind.length() {
+			return nil, fmt.Errorf("Invalid MAC address %v.", s)
+		}
+		return mac, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || (kind == addressIPv4) != (ip.To4() != nil) {
+		return nil, fmt.Errorf("Invalid %v address %v.", kind, s)
+	}
+	if kind == addressIPv4 {
+		return ip.To4(), nil
+	}
+	return ip.To16(), nil
+}
+
+// parseAddressPrefix parses a prefix of the kind, address/length. The length can be omitted for a single address.
+func parseAddressPrefix(s string, kind addressKind) (addr []byte, prefixLen int, err error) {
+	prefixLen = 8 * kind.length()
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		prefixLen, err = strconv.Atoi(s[i+1:])
+		if err != nil || prefixLen < 0 || prefixLen > 8*kind.length() {
+			return nil, 0, fmt.Errorf("Invalid %v prefix %v.", kind, s)
+		}
+		s = s[:i]
+	}
+	addr, err = parseAddress(s, kind)
+	return addr, prefixLen, err
+}
+
+// PerformOp performs the operation on the index.
+func (o *AddressEngine) PerformOp() {
+	switch o.par.Op {
+	case "inc":
+		o.index.Add(o.index, o.step).Mod(o.index, o.hosts)
+	case "dec":
+		o.index.Sub(o.index, o.step).Mod(o.index, o.hosts)
+	case "rand":
+		// The number of addresses is a power of 2, random bytes modulo it are uniform.
+		b := make([]byte, o.kind.length())
+		o.mgr.rnd.Read(b)
+		o.index.SetBytes(b).Mod(o.index, o.hosts)
+	}
+}
+
+// Update implements the Update function of FieldEngineIF.
+func (o *AddressEngine) Update(b []byte) (int, error) {
+	length := o.kind.length()
+	if len(b) < length {
+		o.mgr.counters.bufferTooShort++
+		return 0, fmt.Errorf("Provided slice is shorter that the size of the variable to write, want at least %v, have %v.", length, len(b))
+	}
+	o.value.Add(o.base, o.index).FillBytes(b[:length])
+
+	o.repeatCount++
+	if o.repeatCount >= o.par.Repeat {
+		o.repeatCount = 0
+		o.PerformOp()
+	}
+	return length, nil
+}
+
+// GetOffset implements the GetOffset function of FieldEngineIF.
+func (o *AddressEngine) GetOffset() uint16 {
+	return o.par.Offset
+}
+
+// GetSize implements the GetSize function of FieldEngineIF.
+func (o *AddressEngine) GetSize() uint16 {
+	return uint16(o.kind.length())
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package field_engine
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+/* ------------------------------------------------------------------------------
+								Distributions
+--------------------------------------------------------------------------------*/
+/*
+Numeric engines can generate values from continuous and heavy tailed distributions, in addition to inc, dec
+and the uniform rand. The distributions are truncated to the domain of the engine [min, max], values out of
+the domain are drawn again. Each engine has its own source, seeded with the seed parameter if provided, else
+from the source of the engine manager, so a seeded simulation is deterministic.
+*/
+
+// DistributionParams are the parameters of the distribution operations of the numeric engines.
+type DistributionParams struct {
+	Mean   float64 `json:"mean"`   // Mean of normal and exponential.
+	StdDev float64 `json:"stddev"` // Standard deviation of normal.
+	Mu     float64 `json:"mu"`     // Mean of the logarithm for lognormal.
+	Sigma  float64 `json:"sigma"`  // Standard deviation of the logarithm for lognormal.
+	Alpha  float64 `json:"alpha"`  // Shape of pareto, the tail index.
+	Scale  float64 `json:"scale"`  // Scale of pareto, the minimal value.
+	S      float64 `json:"s"`      // Exponent of zipf, must be > 1.
+	V      float64 `json:"v"`      // Offset of zipf, must be >= 1. Default = 1.
+	Seed   *int64  `json:"seed"`   // Seed of the engine source, 0 included. Default = seeded from the engine manager source.
+}
+
+// maxRejections is the number of draws out of the domain after which the value is clamped to the domain.
+const maxRejections = 64
+
+// isDistributionOp returns true if the operation is a distribution.
+func isDistributionOp(op string) bool {
+	switch op {
+	case "normal", "lognormal", "exponential", "pareto", "zipf":
+		return true
+	}
+	return false
+}
+
+// distribution generates values of a distribution, truncated to [min, max].
+type distribution struct {
+	op   string              // Distribution operation
+	par  *DistributionParams // Parameters
+	rnd  *rand.Rand          // Source of the engine
+	zipf *rand.Zipf          // Zipf generator of ranks
+	min  float64             // Minimal value
+	max  float64             // Maximal value
+}
+
+// newDistribution validates the parameters and creates a distribution of the op. Zipf is only supported for
+// integers, where ranks is the number of values in the domain.
+func newDistribution(op string, par *DistributionParams, min, max float64, integer bool, ranks uint64, mgr *FieldEngineManager) (*distribution, error) {
+	o := &distribution{op: op, par: par, min: min, max: max}
+
+	var err error
+	switch op {
+	case "normal":
+		if par.StdDev <= 0 {
+			err = fmt.Errorf("Normal distribution requires stddev > 0, have %v.", par.StdDev)
+		}
+	case "lognormal":
+		if par.Sigma <= 0 {
+			err = fmt.Errorf("Lognormal distribution requires sigma > 0, have %v.", par.Sigma)
+		}
+	case "exponential":
+		if par.Mean <= 0 {
+			err = fmt.Errorf("Exponential distribution requires mean > 0, have %v.", par.Mean)
+		}
+	case "pareto":
+		if par.Alpha <= 0 || par.Scale <= 0 {
+			err = fmt.Errorf("Pareto distribution requires alpha > 0 and scale > 0, have %v and %v.", par.Alpha, par.Scale)
+		}
+	case "zipf":
+		if !integer {
+			err = fmt.Errorf("Zipf distribution is supported for integers only.")
+		} else if ranks == 0 {
+			err = fmt.Errorf("Zipf distribution domain [%v - %v] is too large.", min+0.5, max-0.5)
+		} else if par.V == 0 {
+			par.V = 1
+		}
+		if err == nil && (par.S <= 1 || par.V < 1) {
+			err = fmt.Errorf("Zipf distribution requires s > 1 and v >= 1, have %v and %v.", par.S, par.V)
+		}
+	default:
+		err = fmt.Errorf("Unsupported distribution %v.", op)
+	}
+	if err != nil {
+		mgr.counters.invalidDistribution++
+		return nil, err
+	}
+
+	var seed int64
+	if par.Seed != nil {
+		seed = *par.Seed
+	} else {
+		seed = mgr.rnd.Int63()
+	}
+	o.rnd = rand.New(rand.NewSource(seed))
+	if op == "zipf" {
+		o.zipf = rand.NewZipf(o.rnd, par.S, par.V, ranks-1)
+	}
+	return o, nil
+}
+
+// draw draws a value of the distribution, not truncated.
+func (o *distribution) draw() float64 {
+	switch o.op {
+	case "normal":
+		return o.par.Mean + o.rnd.NormFloat64()*o.par.StdDev
+	case "lognormal":
+		return math.Exp(o.par.Mu + o.rnd.NormFloat64()*o.par.Sigma)
+	case "exponential":
+		return o.rnd.ExpFloat64() * o.par.Mean
+	case "pareto":
+		// Inverse transform, 1 - U is in (0, 1].
+		return o.par.Scale / math.Pow(1-o.rnd.Float64(), 1/o.par.Alpha)
+	}
+	return 0
+}
+
+// sample returns a value of the distribution in [min, max].
+func (o *distribution) sample() float64 {
+	var x float64
+	for i := 0; i < maxRejections; i++ {
+		x = o.draw()
+		if x >= o.min && x <= o.max {
+			return x
+		}
+	}
+	return math.Max(o.min, math.Min(x, o.max))
+}
+
+// sampleRank returns a zipf rank, 0 is the most frequent.
+func (o *distribution) sampleRank() uint64 {
+	return o.zipf.Uint64()
+}
+
+// sampleUint returns a value of the distribution in [min, max] for unsigned integers.
+func (o *distribution) sampleUint(min, max uint64) uint64 {
+	if o.zipf != nil {
+		return min + o.sampleRank()
+	}
+	x := math.Round(o.sample())
+	switch {
+	case x >= float64(max):
+		return max
+	case x <= float64(min):
+		return min
+	}
+	return uint64(x)
+}
+
+// sampleInt returns a value of the distribution in [min, max] for signed integers.
+func (o *distribution) sampleInt(min, max int64) int64 {
+	if o.zipf != nil {
+		return min + int64(o.sampleRank())
+	}
+	x := math.Round(o.sample())
+	switch {
+	case x >= float64(max):
+		return max
+	case x <= float64(min):
+		return min
+	}
+	return int64(x)
+}
@@ -53,7 +53,7 @@ type HistogramEntry interface {
 	// in case it is picked. This value will be generated by the engine.
 	// In case the input is incorrect and a value can't be generated, it will
 	// return an error.
-	GetValue(size uint16) ([]byte, error)
+	GetValue(rnd *rand.Rand, size uint16) ([]byte, error)
 }
 
 // maxUInt64 calculates the max between 2 uint64.
@@ -141,9 +141,10 @@ func PutValue(size uint16, value uint64, b []byte, mgr *FieldEngineManager) erro
 type BaseNumericEngineParams struct {
 	Size   uint16 `json:"size"`   // Size of the uint variable in bytes
 	Offset uint16 `json:"offset"` // Offset in which to write in the packet
-	Op     string `json:"op"`     // Operation which provides the generation, can be {inc, dec, rand}
+	Op     string `json:"op"`     // Operation which provides the generation, can be {inc, dec, rand} or a distribution
 	Repeat uint16 `json:"repeat"` // Number of times to repeat current value. Default = 1.
 	Step   uint64 `json:"step"`   // Step to decrement or increment, rand will be ignored. Default=1.
+	DistributionParams
 }
 
 // GetOffset implements the GetOffset function of FieldEngineIF.
@@ -176,6 +177,7 @@ type UIntEngine struct {
 	currValue         uint64              // Current value in the generator
 	domainLen         uint64              // Domain length
 	repeatCount       uint16              // Number of times current value was repeated
+	dist              *distribution       // Distribution of the values, nil for inc, dec and rand
 	mgr               *FieldEngineManager // Field engine manager
 }
 
@@ -214,6 +216,15 @@ func NewUIntEngine(params *UIntEngineParams, mgr *FieldEngineManager) (*UIntEngi
 		o.Step = o.Step % o.domainLen
 	}
 	o.currValue = maxUInt64(o.MinValue, o.InitValue)
+	if isDistributionOp(o.Op) {
+		o.dist, err = newDistribution(o.Op, &o.DistributionParams, float64(o.MinValue)-0.5, float64(o.MaxValue)+0.5, true, o.domainLen, mgr)
+		if err != nil {
+			return nil, err
+		}
+		if o.InitValue == 0 {
+			o.currValue = o.dist.sampleUint(o.MinValue, o.MaxValue)
+		}
+	}
 	return o, nil
 }
 
@@ -240,7 +251,7 @@ func (o *UIntEngine) validateParams(params *UIntEngineParams) (err error) {
 			o.mgr.counters.sizeTooSmall++
 		}
 	}
-	if params.Op != "inc" && params.Op != "dec" && params.Op != "rand" {
+	if params.Op != "inc" && params.Op != "dec" && params.Op != "rand" && !isDistributionOp(params.Op) {
 		err = fmt.Errorf("Unsupported operation %v.", params.Op)
 		o.mgr.counters.badOperation++
 	}
@@ -281,7 +292,7 @@ func (o *UIntEngine) RandValue() {
 	// Generates a uint64 with uniform distribution.
 	// Converts the generated value to a value in the domain by adding the modulus of domainLength
 	// to the minimal value.
-	genValue := rand.Uint64()
+	genValue := o.mgr.rnd.Uint64()
 	o.currValue = o.MinValue + (genValue % o.domainLen)
 }
 
@@ -296,6 +307,10 @@ func (o *UIntEngine) PerformOp() (err error) {
 	case "rand":
 		o.RandValue()
 	default:
+		if o.dist != nil {
+			o.currValue = o.dist.sampleUint(o.MinValue, o.MaxValue)
+			break
+		}
 		o.mgr.counters.badOperation++
 		err = errors.New("Unrecognized operation")
 	}
@@ -344,6 +359,7 @@ type IntEngine struct {
 	currValue        int64               // Current value in the generator
 	domainLen        uint64              // Domain length
 	repeatCount      uint16              // Number of times current value was repeated
+	dist             *distribution       // Distribution of the values, nil for inc, dec and rand
 	mgr              *FieldEngineManager // Field engine manager
 }
 
@@ -377,6 +393,15 @@ func NewIntEngine(params *IntEngineParams, mgr *FieldEngineManager) (*IntEngine,
 		o.Step = o.Step % o.domainLen
 	}
 	o.currValue = maxInt64(o.MinValue, o.InitValue)
+	if isDistributionOp(o.Op) {
+		o.dist, err = newDistribution(o.Op, &o.DistributionParams, float64(o.MinValue)-0.5, float64(o.MaxValue)+0.5, true, o.domainLen, mgr)
+		if err != nil {
+			return nil, err
+		}
+		if o.InitValue == math.MinInt64 {
+			o.currValue = o.dist.sampleInt(o.MinValue, o.MaxValue)
+		}
+	}
 	return o, nil
 }
 
@@ -408,7 +433,7 @@ func (o *IntEngine) validateParams(params *IntEngineParams) (err error) {
 			o.mgr.counters.sizeTooSmall++
 		}
 	}
-	if params.Op != "inc" && params.Op != "dec" && params.Op != "rand" {
+	if params.Op != "inc" && params.Op != "dec" && params.Op != "rand" && !isDistributionOp(params.Op) {
 		err = fmt.Errorf("Unsupported operation %v.", params.Op)
 		o.mgr.counters.badOperation++
 	}
@@ -446,7 +471,7 @@ func (o *IntEngine) DecValue() {
 
 // RandValue generates a random value in the domain [min, max]
 func (o *IntEngine) RandValue() {
-	genValue := rand.Uint64()
+	genValue := o.mgr.rnd.Uint64()
 	// Converting the value to int64 will give a random int64.
 	o.currValue = o.MinValue + int64((genValue % o.domainLen))
 }
@@ -462,6 +487,10 @@ func (o *IntEngine) PerformOp() (err error) {
 	case "rand":
 		o.RandValue()
 	default:
+		if o.dist != nil {
+			o.currValue = o.dist.sampleInt(o.MinValue, o.MaxValue)
+			break
+		}
 		o.mgr.counters.badOperation++
 		err = errors.New("Unrecognized operation")
 	}
@@ -500,11 +529,15 @@ type FloatEngineParams struct {
 	Offset uint16  `json:"offset"`                             // Offset in which to write in the packet
 	Min    float64 `json:"min" validate:"required"`            // Minimal value of the domain
 	Max    float64 `json:"max" validate:"required"`            // Maximal value of the domain
+	Op     string  `json:"op"`                                 // Operation, rand or a distribution except zipf. Default = rand.
+	DistributionParams
 }
 
-// FloatEngine represents a float32 or float64 engine. For float engines only rand makes sense.
+// FloatEngine represents a float32 or float64 engine. For float engines inc and dec don't make sense,
+// the values are uniform (rand) or of a distribution.
 type FloatEngine struct {
 	FloatEngineParams                     // Params as provided by the caller
+	dist              *distribution       // Distribution of the values, nil for rand
 	mgr               *FieldEngineManager // Field engine manager
 }
 
@@ -513,8 +546,13 @@ func (o *FloatEngine) Update(b []byte) (int, error) {
 	if len(b) < int(o.Size) {
 		return -1, fmt.Errorf("Size of provided buffer too small, want %v, have %v.", o.Size, len(b))
 	}
-	factor := rand.Float64()
-	genValue := (o.Min) + (o.Max-o.Min)*factor
+	var genValue float64
+	if o.dist != nil {
+		genValue = o.dist.sample()
+	} else {
+		factor := o.mgr.rnd.Float64()
+		genValue = (o.Min) + (o.Max-o.Min)*factor
+	}
 	if o.Size == 4 {
 		binary.BigEndian.PutUint32(b, math.Float32bits(float32(genValue)))
 	} else if o.Size == 8 {
@@ -555,6 +593,16 @@ func NewFloatEngine(params *FloatEngineParams, mgr *FieldEngineManager) (*FloatE
 	o := new(FloatEngine)
 	o.FloatEngineParams = *params
 	o.mgr = mgr
+	if isDistributionOp(o.Op) {
+		var err error
+		o.dist, err = newDistribution(o.Op, &o.DistributionParams, o.Min, o.Max, false, 0, mgr)
+		if err != nil {
+			return nil, err
+		}
+	} else if o.Op != "" && o.Op != "rand" {
+		mgr.counters.badOperation++
+		return nil, fmt.Errorf("Unsupported operation %v.", o.Op)
+	}
 	return o, nil
 }
 
@@ -615,7 +663,7 @@ func (o *BaseListEngine) DecValue() {
 
 // RandValue generates a new index in the list.
 func (o *BaseListEngine) RandValue() {
-	o.currIndex = rand.Intn(o.listLength)
+	o.currIndex = o.mgr.rnd.Intn(o.listLength)
 }
 
 // PerformOp performs the operation, either it is rand, inc or dec.
@@ -1067,9 +1115,9 @@ func (o *HistogramEngine) Update(b []byte) (int, error) {
 		o.mgr.counters.badCopyToBuffer++
 		return 0, fmt.Errorf("Didn't copy the right amount to the buffer, want %v have %v.", o.par.Size, copiedSize)
 	}
-	entryIndex := o.generator.Generate()
+	entryIndex := o.generator.Generate(o.mgr.rnd)
 	entry := o.par.Entries[entryIndex]
-	newValueBytes, err := entry.GetValue(o.par.Size)
+	newValueBytes, err := entry.GetValue(o.mgr.rnd, o.par.Size)
 	if err != nil {
 		o.mgr.counters.invalidHistogramEntry++
 		return 0, err
@@ -1132,7 +1180,7 @@ type HistogramUInt32Params struct {
 }
 
 // GetValue puts the value in the byte buffer.
-func (o *HistogramUInt32Entry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt32Entry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	return putValueInBuffer(size, o.V)
 }
 
@@ -1196,7 +1244,7 @@ type HistogramInt32Params struct {
 }
 
 // GetValue puts the value in the byte buffer.
-func (o *HistogramInt32Entry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramInt32Entry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	// Converting to uint32 won't change the value, only the representation.
 	return putValueInBuffer(size, uint32(o.V))
 }
@@ -1263,11 +1311,11 @@ type HistogramUInt32RangeParams struct {
 }
 
 // GetValue generates uniformly a value in the range and puts it in the byte buffer.
-func (o *HistogramUInt32RangeEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt32RangeEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if o.Max < o.Min {
 		return nil, fmt.Errorf("Max %v is smaller than min %v in HistogramRuneRangeEntry.", o.Max, o.Min)
 	}
-	v := rand.Uint32()                    // generate random 32 bytes
+	v := rnd.Uint32()                     // generate random 32 bytes
 	v = o.Min + (v % (o.Max - o.Min + 1)) // scale it to the domain
 	return putValueInBuffer(size, v)
 }
@@ -1340,8 +1388,8 @@ type HistogramInt32RangeParams struct {
 }
 
 // GetValue generates uniformly a value in the range and puts it in the byte buffer.
-func (o *HistogramInt32RangeEntry) GetValue(size uint16) (b []byte, err error) {
-	genValue := rand.Uint64()                   // generate random 32 bytes
+func (o *HistogramInt32RangeEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
+	genValue := rnd.Uint64()                    // generate random 32 bytes
 	v := o.Min + int32(genValue%o.domainLength) // scale it to the domain
 	return putValueInBuffer(size, uint32(v))
 }
@@ -1412,11 +1460,11 @@ type HistogramUInt32ListParams struct {
 }
 
 // GetValue picks a random value from the list and puts it in the byte buffer.
-func (o *HistogramUInt32ListEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt32ListEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if o.List == nil || len(o.List) == 0 {
 		return nil, fmt.Errorf("Empty list in HistogramUInt32ListEntry.")
 	}
-	index := rand.Intn(len(o.List))
+	index := rnd.Intn(len(o.List))
 	return putValueInBuffer(size, o.List[index])
 }
 
@@ -1488,11 +1536,11 @@ type HistogramInt32ListParams struct {
 }
 
 // GetValue picks a random value from the list and puts it in the byte buffer.
-func (o *HistogramInt32ListEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramInt32ListEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if o.List == nil || len(o.List) == 0 {
 		return nil, fmt.Errorf("Empty list in HistogramUInt32ListEntry.")
 	}
-	index := rand.Intn(len(o.List))
+	index := rnd.Intn(len(o.List))
 	return putValueInBuffer(size, uint32(o.List[index]))
 }
 
@@ -1563,7 +1611,7 @@ type HistogramUInt64Params struct {
 }
 
 // GetValue puts the value in the byte buffer.
-func (o *HistogramUInt64Entry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt64Entry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if size != 8 {
 		return nil, fmt.Errorf("Size in HistogramUInt64Entry GetValue is %v, want %v.", size, 8)
 	}
@@ -1620,7 +1668,7 @@ type HistogramInt64Params struct {
 }
 
 // GetValue puts the value in the byte buffer.
-func (o *HistogramInt64Entry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramInt64Entry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if size != 8 {
 		return nil, fmt.Errorf("Size in HistogramUInt64Entry GetValue is %v, want %v.", size, 8)
 	}
@@ -1679,9 +1727,9 @@ type HistogramUInt64RangeParams struct {
 }
 
 // GetValue generates uniformly a value in the range and puts it in the byte buffer.
-func (o *HistogramUInt64RangeEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt64RangeEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	b = make([]byte, size)
-	v := rand.Uint64()                    // generate random 64 bytes
+	v := rnd.Uint64()                     // generate random 64 bytes
 	v = o.Min + (v % (o.Max - o.Min + 1)) // scale it to the domain
 	binary.BigEndian.PutUint64(b, v)      // put it in the bytes buffer
 	return b, nil
@@ -1743,9 +1791,9 @@ type HistogramInt64RangeParams struct {
 }
 
 // GetValue generates uniformly a value in the range and puts it in the byte buffer.
-func (o *HistogramInt64RangeEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramInt64RangeEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	b = make([]byte, size)
-	genValue := rand.Uint64()                   // generate random 64 bytes
+	genValue := rnd.Uint64()                    // generate random 64 bytes
 	v := o.Min + int64(genValue%o.domainLength) // scale it to the domain
 	binary.BigEndian.PutUint64(b, uint64(v))    // put it in the bytes buffer
 	return b, nil
@@ -1806,9 +1854,9 @@ type HistogramUInt64ListParams struct {
 }
 
 // GetValue picks a random value from the list and puts it in the byte buffer.
-func (o *HistogramUInt64ListEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramUInt64ListEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	b = make([]byte, size)
-	index := rand.Intn(len(o.List))
+	index := rnd.Intn(len(o.List))
 	binary.BigEndian.PutUint64(b, o.List[index])
 	return b, nil
 }
@@ -1867,9 +1915,9 @@ type HistogramInt64ListParams struct {
 }
 
 // GetValue picks a random value from the list and puts it in the byte buffer.
-func (o *HistogramInt64ListEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramInt64ListEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	b = make([]byte, size)
-	index := rand.Intn(len(o.List))
+	index := rnd.Intn(len(o.List))
 	binary.BigEndian.PutUint64(b, uint64(o.List[index]))
 	return b, nil
 }
@@ -1998,7 +2046,7 @@ func (o *TimeStartEngine) getNewFlowStartValue() (value uint64) {
 	} else {
 		// Generates a uint64 with uniform distribution.
 		// Converts the generated value to a value in the domain [min-max]. This way we get an ipg.
-		genValue := rand.Uint64()
+		genValue := o.mgr.rnd.Uint64()
 		ipg := o.par.InterPacketGapMin + (genValue % (o.par.InterPacketGapMax - o.par.InterPacketGapMin + 1))
 		// Assumes the TimeEnd engine has updated the previous flow end.
 		value = o.previousFlowEnd + ipg
@@ -2011,7 +2059,7 @@ func (o *TimeStartEngine) Update(b []byte) (int, error) {
 
 	// Get the Time End engine. This can't be done upon creation as the engine might not be created yet.
 	if o.timeEndEngine == nil {
-		timeEndEngine, ok := o.mgr.engines[o.par.TimeEndEngineName]
+		timeEndEngine, ok := o.mgr.getEngine(o.par.TimeEndEngineName)
 		if !ok {
 			return 0, fmt.Errorf("TimeEnd engine name %v not found in engine manager database. Must provide this engine.", o.par.TimeEndEngineName)
 		}
@@ -2131,7 +2179,7 @@ func (o *TimeEndEngine) getFlowEndValue() (value uint64) {
 
 	// Generates a uint64 with uniform distribution.
 	// Converts the generated value to a value in the domain [min-max]. This way we get an ipg.
-	genValue := rand.Uint64()
+	genValue := o.mgr.rnd.Uint64()
 	duration := o.par.DurationMin + (genValue % (o.par.DurationMax - o.par.DurationMin + 1))
 	// Assumes the TimeEnd engine has updated the previous flow end.
 	value = o.currentFlowStart + duration
@@ -2144,7 +2192,7 @@ func (o *TimeEndEngine) Update(b []byte) (int, error) {
 
 	// Get the Time Start engine. This can't be done upon creation as the engine might not be created yet.
 	if o.timeStartEngine == nil {
-		timeEndEngine, ok := o.mgr.engines[o.par.TimeStartEngineName]
+		timeEndEngine, ok := o.mgr.getEngine(o.par.TimeStartEngineName)
 		if !ok {
 			return 0, fmt.Errorf("TimeStart engine name %v not found in engine manager database. Must provide this engine.", o.par.TimeStartEngineName)
 		}
@@ -2265,22 +2313,22 @@ func (o *HistogramURLEntry) validateParams(mgr *FieldEngineManager, size uint16)
 	return nil
 }
 
-func (o *HistogramURLEntry) getQuery(size uint16) (query string) {
+func (o *HistogramURLEntry) getQuery(rnd *rand.Rand, size uint16) (query string) {
 	if len(o.Queries) != 0 {
 		// queries doesn't necessarily need to be provided.
-		if rand.Intn(len(o.Queries)+1) != len(o.Queries) {
+		if rnd.Intn(len(o.Queries)+1) != len(o.Queries) {
 			// Even if queries are provided, we don't need to choose them (empty query can be fine too).
 			// Pick the empty query with uniform distribution over other queries.
-			query = o.Queries[rand.Intn(len(o.Queries))]
+			query = o.Queries[rnd.Intn(len(o.Queries))]
 		}
 	} else if o.RandomQuery == true {
 		// build random query of random size
-		querySize := rand.Intn(int(size + 1)) // + 1 to generate in [0, n] instead of [0, n)
+		querySize := rnd.Intn(int(size + 1)) // + 1 to generate in [0, n] instead of [0, n)
 		dictionary := "aAbBcCdDeEfFgGhHiIjJkKlLmMnNoOpPqQrRsStTuUvVwWxXyYzZ0123456789"
 		var s strings.Builder
 		s.Grow(querySize)
 		for i := 0; i < querySize; i++ {
-			rndChar := dictionary[rand.Intn(len(dictionary))]
+			rndChar := dictionary[rnd.Intn(len(dictionary))]
 			fmt.Fprintf(&s, "%c", rndChar)
 		}
 		query = s.String()
@@ -2291,16 +2339,16 @@ func (o *HistogramURLEntry) getQuery(size uint16) (query string) {
 // generateURL generates randomly an URL from the list of given schemes, hosts, paths and queries.
 // Paths and queries can be picked or not, while schemes and hosts and always picked to be part of the url.
 // All the generations are done uniformly from the lists.
-func (o *HistogramURLEntry) generateURL(size uint16) *url.URL {
+func (o *HistogramURLEntry) generateURL(rnd *rand.Rand, size uint16) *url.URL {
 	var scheme, host, path string
-	scheme = o.Schemes[rand.Intn(len(o.Schemes))]
-	host = o.Hosts[rand.Intn(len(o.Hosts))]
+	scheme = o.Schemes[rnd.Intn(len(o.Schemes))]
+	host = o.Hosts[rnd.Intn(len(o.Hosts))]
 	if len(o.Paths) != 0 {
 		// paths doesn't necessarily need to be provided.
-		if rand.Intn(len(o.Paths)+1) != len(o.Paths) {
+		if rnd.Intn(len(o.Paths)+1) != len(o.Paths) {
 			// Even if paths are provided, we don't need to choose them (empty path can be fine too).
 			// Pick the empty path with uniform distribution over other paths.
-			path = o.Paths[rand.Intn(len(o.Paths))]
+			path = o.Paths[rnd.Intn(len(o.Paths))]
 		}
 	}
 	u := &url.URL{
@@ -2310,7 +2358,7 @@ func (o *HistogramURLEntry) generateURL(size uint16) *url.URL {
 	}
 	q := u.Query()
 	queryMaxSize := size - uint16(len([]byte(u.String()))) - 3 // -2 for encoding ?q= in query
-	query := o.getQuery(queryMaxSize)
+	query := o.getQuery(rnd, queryMaxSize)
 	if o.RandomQuery == true {
 		q.Set("q", query)
 		u.RawQuery = q.Encode()
@@ -2323,8 +2371,8 @@ func (o *HistogramURLEntry) generateURL(size uint16) *url.URL {
 }
 
 // GetValue gets a newly generated URL randomly and returns it as a byte buffer.
-func (o *HistogramURLEntry) GetValue(size uint16) (b []byte, err error) {
-	url := o.generateURL(size)
+func (o *HistogramURLEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
+	url := o.generateURL(rnd, size)
 	urlBytes := []byte(url.String())
 
 	if int(size) < len(urlBytes) {
@@ -2415,7 +2463,7 @@ func (o *HistogramStringEntry) processString(shouldPad bool, size uint16) {
 }
 
 // GetValue returns the picked string encoded into the byte parameter.
-func (o *HistogramStringEntry) GetValue(size uint16) (b []byte, err error) {
+func (o *HistogramStringEntry) GetValue(rnd *rand.Rand, size uint16) (b []byte, err error) {
 	if int(size) < len(o.processedStr) {
 		return nil, fmt.Errorf("Size %v is too small for string %v.", size, o.Str)
 	}
@@ -2493,4 +2541,11 @@ func init() {
 
 	fieldEngineRegister("histogram_url", CreateHistogramURLEngine)
 	fieldEngineRegister("histogram_string", CreateHistogramStringEngine)
+
+	fieldEngineRegister("expression", CreateExpressionEngine)
+	fieldEngineRegister("trace", CreateTraceEngine)
+
+	fieldEngineRegister("ipv4", CreateIPv4Engine)
+	fieldEngineRegister("ipv6", CreateIPv6Engine)
+	fieldEngineRegister("mac", CreateMacEngine)
 }
@@ -9,6 +9,7 @@ import (
 	"emu/core"
 	"external/osamingo/jsonrpc"
 	"fmt"
+	"math/rand"
 
 	"github.com/intel-go/fastjson"
 )
@@ -32,6 +33,14 @@ type FieldEngineCounters struct {
 	badCopyToBuffer        uint64 // copying to buffer failed
 	emptyList              uint64 // empty list in histogram entry
 	badEngineType          uint64 // bad engine type provided by the user
+	invalidExpression      uint64 // expression engine couldn't parse its expression
+	invalidReference       uint64 // expression references an unknown or non numeric engine
+	dependencyCycle        uint64 // expressions reference each other in a cycle
+	badExpressionArgs      uint64 // expression function called with invalid arguments, division by zero or a non-finite result
+	invalidPrefix          uint64 // invalid prefix for address engine
+	badTraceFile           uint64 // trace file couldn't be read or doesn't have the column
+	badTraceValue          uint64 // value in trace file couldn't be converted to the engine type
+	invalidDistribution    uint64 // invalid distribution or distribution parameters
 }
 
 // Creates a database of engine counters
@@ -128,6 +137,62 @@ func NewFECountersDb(o *FieldEngineCounters) *core.CCounterDb {
 		Unit:     "ops",
 		DumpZero: false,
 		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidExpression,
+		Name:     "invalidExpression",
+		Help:     "Expression engine failed parsing its expression.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidReference,
+		Name:     "invalidReference",
+		Help:     "Expression references an unknown or non numeric engine.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dependencyCycle,
+		Name:     "dependencyCycle",
+		Help:     "Expressions reference each other in a cycle.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.badExpressionArgs,
+		Name:     "badExpressionArgs",
+		Help:     "Expression failed on invalid function arguments, division by zero or a non-finite result.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidPrefix,
+		Name:     "invalidPrefix",
+		Help:     "Invalid prefix for address engine.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.badTraceFile,
+		Name:     "badTraceFile",
+		Help:     "Trace file couldn't be read or doesn't have the column.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.badTraceValue,
+		Name:     "badTraceValue",
+		Help:     "Value in trace file couldn't be converted to the engine type.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidDistribution,
+		Name:     "invalidDistribution",
+		Help:     "Invalid distribution parameters.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
 
 	return db
 }
@@ -151,12 +216,16 @@ type FieldEngineRequest struct {
 // type of the engine and it's name.  The manager is provided a pointer to all the engines
 // and contains the database of counters for all the engines.
 type FieldEngineManager struct {
-	requests []FieldEngineRequest     // A slice of field engine requests.
-	engines  map[string]FieldEngineIF // A map of engines, maps field engine name to field engine object.
-	counters *FieldEngineCounters     // Field Engine General Counters
-	cdb      *core.CCounterDb         // Counter Database for Field Engine Counters
-	cdbv     *core.CCounterDbVec      // Database Vector
-	tctx     *core.CThreadCtx         // thread context
+	requests   []FieldEngineRequest     // A slice of field engine requests.
+	engines    map[string]FieldEngineIF // A map of engines, maps field engine name to field engine object.
+	deps       map[string][]string      // Maps engine name to the names of the engines it references.
+	order      []string                 // Engine names in an order in which references are updated first.
+	traceFiles map[string]*traceFile    // Trace files read by trace engines, shared between the engines.
+	counters   *FieldEngineCounters     // Field Engine General Counters
+	cdb        *core.CCounterDb         // Counter Database for Field Engine Counters
+	cdbv       *core.CCounterDbVec      // Database Vector
+	tctx       *core.CThreadCtx         // thread context
+	rnd        *rand.Rand               // random generator of the thread
 }
 
 // NewEngineManager creates and returns a new engine manager. The manager will always be non nil,
@@ -164,6 +233,7 @@ type FieldEngineManager struct {
 func NewEngineManager(ctx interface{}, data *fastjson.RawMessage) (*FieldEngineManager, error) {
 	o := new(FieldEngineManager)
 	o.tctx = ctx.(*core.CThreadCtx)
+	o.rnd = o.tctx.Rand()
 	o.engines = make(map[string]FieldEngineIF)
 	o.counters = new(FieldEngineCounters)
 	o.cdb = NewFECountersDb(o.counters)
@@ -190,9 +260,139 @@ func NewEngineManager(ctx interface{}, data *fastjson.RawMessage) (*FieldEngineM
 		}
 		o.engines[o.requests[i].EngineName] = eng
 	}
+
+	err = o.resolveDependencies()
+	if err != nil {
+		return nil, err
+	}
 	return o, nil
 }
 
+// resolveDependencies resolves the engines referenced by expressions, and orders the engines so that each
+// engine is updated after the engines it references.
+func (o *FieldEngineManager) resolveDependencies() error {
+	o.deps = make(map[string][]string)
+	types := make(map[string]string, len(o.requests))
+	var names []string
+	for i := range o.requests {
+		types[o.requests[i].EngineName] = o.requests[i].EngineType
+		names = append(names, o.requests[i].EngineName)
+	}
+
+	// Collect the expressions first, referenced engines are replaced by recording engines.
+	exprs := make(map[string]*ExpressionEngine)
+	for _, name := range names {
+		if expr, ok := o.engines[name].(*ExpressionEngine); ok {
+			exprs[name] = expr
+		}
+	}
+
+	for _, name := range names {
+		expr, ok := exprs[name]
+		if !ok {
+			continue
+		}
+		for _, ref := range expr.references() {
+			if _, ok := o.engines[ref]; !ok {
+				o.counters.invalidReference++
+				return fmt.Errorf("engine '%s' references unknown engine '%s'", name, ref)
+			}
+			if ref == name {
+				o.counters.dependencyCycle++
+				return fmt.Errorf("engine '%s' references itself", name)
+			}
+			rec, ok := o.engines[ref].(*recordedEngine)
+			if !ok {
+				kind, err := getValueKind(types[ref])
+				switch refEng := o.engines[ref].(type) {
+				case *ExpressionEngine:
+					kind = refEng.valueKind()
+				case *TraceEngine:
+					kind, err = refEng.valueKind()
+				}
+				if err != nil {
+					o.counters.invalidReference++
+					return fmt.Errorf("engine '%s' can't reference engine '%s': %w", name, ref, err)
+				}
+				rec = &recordedEngine{FieldEngineIF: o.engines[ref], kind: kind}
+				o.engines[ref] = rec
+			}
+			expr.vals = append(expr.vals, rec)
+		}
+		o.deps[name] = expr.references()
+	}
+
+	var err error
+	o.order, err = o.SortByDependencies(names)
+	if err != nil {
+		o.counters.dependencyCycle++
+	}
+	return err
+}
+
+// getEngine returns the engine by name, without the recording of its values.
+func (o *FieldEngineManager) getEngine(name string) (FieldEngineIF, bool) {
+	eng, ok := o.engines[name]
+	if rec, isRec := eng.(*recordedEngine); isRec {
+		eng = rec.FieldEngineIF
+	}
+	return eng, ok
+}
+
+// HasDependencies returns true if some engine references another engine.
+func (o *FieldEngineManager) HasDependencies() bool {
+	return len(o.deps) > 0
+}
+
+// GetEngineOrder returns the engine names in the order the engines should be updated, the order of the
+// requests where each engine comes after the engines it references.
+func (o *FieldEngineManager) GetEngineOrder() []string {
+	return o.order
+}
+
+// SortByDependencies returns the names sorted so that each engine comes after the engines it references.
+// The sort is stable, names that don't depend on each other keep their order. References to engines
+// which are not in names are ignored.
+func (o *FieldEngineManager) SortByDependencies(names []string) ([]string, error) {
+	inNames := make(map[string]bool, len(names))
+	for _, name := range names {
+		inNames[name] = true
+	}
+	done := make(map[string]bool, len(names))
+	sorted := make([]string, 0, len(names))
+	for len(sorted) < len(names) {
+		progress := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, ref := range o.deps[name] {
+				if inNames[ref] && !done[ref] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[name] = true
+				sorted = append(sorted, name)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, name := range names {
+				if !done[name] {
+					cycle = append(cycle, name)
+				}
+			}
+			return nil, fmt.Errorf("engines %v reference each other in a cycle", cycle)
+		}
+	}
+	return sorted, nil
+}
+
 // GetFEManagerCounters returns the Field Engine Manager counters.
 // The params decides things like the verbosity, filtering or whether to dump zero errors.
 func (o *FieldEngineManager) GetFEManagerCounters(params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
@@ -3,7 +3,6 @@ package field_engine
 import (
 	"emu/core"
 	"encoding/hex"
-	"math/rand"
 	"regexp"
 	"sort"
 	"testing"
@@ -46,7 +45,7 @@ func (o *EngineManagerTestBase) Run(compare bool) {
 	defer tctx.Delete()
 
 	if o.seed != 0 {
-		rand.Seed(o.seed)
+		tctx.SetSeed(o.seed)
 	}
 
 	feMgr, err := NewEngineManager(tctx, &o.inputJson)
@@ -74,6 +73,10 @@ func (o *EngineManagerTestBase) Run(compare bool) {
 		engineNames = append(engineNames, engine_name)
 	}
 	sort.Strings(engineNames)
+	if feMgr.HasDependencies() {
+		// Engines that reference other engines must run after them.
+		engineNames, _ = feMgr.SortByDependencies(engineNames)
+	}
 
 	for i := 0; i < o.iterNumber; i++ {
 		for _, eng_name := range engineNames {
@@ -1646,3 +1649,249 @@ func TestEngineManager16(t *testing.T) {
 	}
 	a.Run(true)
 }
+
+func TestEngineManager17(t *testing.T) {
+	// Expressions which reference engines that are sorted after them by name.
+	a := &EngineManagerTestBase{
+		t:            t,
+		testname:     "fe17",
+		monitor:      true,
+		bufferSize:   16,
+		iterNumber:   50,
+		engineNumber: 5,
+		seed:         0xc15c0c15c0be5be,
+		inputJson: []byte(`[
+			{
+				"engine_type": "expression",
+				"engine_name": "a_octets",
+				"params":
+					{
+						"size": 4,
+						"offset": 0,
+						"expr": "packets * rand(64, 1500)"
+					}
+			},
+			{
+				"engine_type": "expression",
+				"engine_name": "b_port",
+				"params":
+					{
+						"size": 2,
+						"offset": 4,
+						"expr": "protocol == 6 ? choice(80, 443) : 53"
+					}
+			},
+			{
+				"engine_type": "expression",
+				"engine_name": "c_delta",
+				"params":
+					{
+						"size": 2,
+						"offset": 6,
+						"type": "int",
+						"expr": "-(a_octets % 1000) + max(packets, 3) * 2"
+					}
+			},
+			{
+				"engine_type": "uint",
+				"engine_name": "packets",
+				"params":
+					{
+						"size": 4,
+						"offset": 8,
+						"min": 1,
+						"max": 20,
+						"op": "rand"
+					}
+			},
+			{
+				"engine_type": "uint_list",
+				"engine_name": "protocol",
+				"params":
+					{
+						"size": 1,
+						"offset": 12,
+						"list": [6, 17],
+						"op": "inc"
+					}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerExpressionNeg1(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "references unknown engine 'bytes'",
+		inputJson: []byte(`[
+			{
+				"engine_type": "expression",
+				"engine_name": "octets",
+				"params": {"size": 4, "expr": "bytes * 2"}
+			}
+		 ]`),
+		counters: FieldEngineCounters{invalidReference: 1},
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerExpressionNeg2(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "reference each other in a cycle",
+		inputJson: []byte(`[
+			{
+				"engine_type": "expression",
+				"engine_name": "a",
+				"params": {"size": 4, "expr": "b + 1"}
+			},
+			{
+				"engine_type": "expression",
+				"engine_name": "b",
+				"params": {"size": 4, "offset": 4, "expr": "a + 1"}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerExpressionNeg3(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "engine type string_list is not numeric",
+		inputJson: []byte(`[
+			{
+				"engine_type": "string_list",
+				"engine_name": "name",
+				"params": {"size": 4, "offset": 0, "op": "inc", "list": ["a", "b"]}
+			},
+			{
+				"engine_type": "expression",
+				"engine_name": "len",
+				"params": {"size": 4, "offset": 4, "expr": "name"}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerExpressionNeg4(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "invalid expression '1 \\+ \\(2': expected '\\)'",
+		inputJson: []byte(`[
+			{
+				"engine_type": "expression",
+				"engine_name": "a",
+				"params": {"size": 4, "expr": "1 + (2"}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerExpressionNeg5(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "function rand expects 2 arguments, have 1",
+		inputJson: []byte(`[
+			{
+				"engine_type": "expression",
+				"engine_name": "a",
+				"params": {"size": 4, "expr": "rand(5)"}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManager18(t *testing.T) {
+	// Distributions with their own seeds
+	a := &EngineManagerTestBase{
+		t:            t,
+		testname:     "fe18",
+		monitor:      true,
+		bufferSize:   16,
+		iterNumber:   50,
+		engineNumber: 4,
+		inputJson: []byte(`[
+			{
+				"engine_type": "uint",
+				"engine_name": "dst_port",
+				"params":
+					{
+						"size": 2,
+						"offset": 0,
+						"min": 1024,
+						"max": 65535,
+						"op": "zipf",
+						"s": 1.2,
+						"v": 2,
+						"seed": 1
+					}
+			},
+			{
+				"engine_type": "int",
+				"engine_name": "rtt_delta",
+				"params":
+					{
+						"size": 2,
+						"offset": 2,
+						"min": -500,
+						"max": 500,
+						"op": "normal",
+						"mean": 0,
+						"stddev": 100,
+						"seed": 2
+					}
+			},
+			{
+				"engine_type": "float",
+				"engine_name": "duration",
+				"params":
+					{
+						"size": 4,
+						"offset": 4,
+						"min": 0.001,
+						"max": 3600,
+						"op": "lognormal",
+						"mu": 1,
+						"sigma": 2,
+						"seed": 3
+					}
+			},
+			{
+				"engine_type": "uint",
+				"engine_name": "octets",
+				"params":
+					{
+						"size": 8,
+						"offset": 8,
+						"min": 40,
+						"max": 1000000000,
+						"op": "pareto",
+						"alpha": 1.1,
+						"scale": 40,
+						"seed": 4
+					}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
+
+func TestEngineManagerNeg19(t *testing.T) {
+	a := &EngineManagerTestBase{
+		t:            t,
+		expCreateErr: "Zipf distribution requires s > 1",
+		inputJson: []byte(`[
+			{
+				"engine_type": "uint",
+				"engine_name": "port",
+				"params": {"size": 2, "min": 1, "max": 100, "op": "zipf", "s": 0.8}
+			}
+		 ]`),
+	}
+	a.Run(true)
+}
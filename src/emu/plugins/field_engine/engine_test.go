@@ -2,10 +2,15 @@ package field_engine
 
 import (
 	"bytes"
+	"compress/gzip"
 	"emu/core"
 	"encoding/binary"
+	"fmt"
 	"math"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/intel-go/fastjson"
@@ -3235,3 +3240,566 @@ func TestHistogramStringVarLength(t *testing.T) {
 		}
 	}
 }
+
+// TestExpressionEngine
+func TestExpressionEngine(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	tests := []struct {
+		expr     string
+		typ      string
+		size     uint16
+		expected uint64
+	}{
+		{"1 + 2 * 3", "uint", 2, 7},
+		{"(1 + 2) * 3", "uint", 2, 9},
+		{"10 - 4 - 3", "uint", 1, 3},
+		{"17 % 5 + 7 / 2", "uint", 1, 6}, // 2 + 3.5 rounded
+		{"2 > 1 && !(3 == 4) ? 100 : 200", "uint", 2, 100},
+		{"0 || 0 ? 1 : 0 ? 2 : 3", "uint", 1, 3},
+		{"300", "uint", 1, 255},  // clamped to max
+		{"-5", "uint", 4, 0},     // clamped to 0
+		{"-5", "int", 2, 0xfffb}, // -5
+		{"-200", "int", 1, 0x80}, // clamped to -128
+		{"min(3, max(1, 2))", "uint", 1, 2},
+		{"abs(-7) + floor(1.9) + ceil(1.1) + round(2.5)", "uint", 1, 13},
+		{"0x10 + 1", "uint", 1, 17},
+		{"1e-5 * 2.5E+5 + 1e1", "uint", 1, 13},
+		{"0x1e-5", "uint", 1, 25}, // hex minus 5
+		{"1.5", "float", 4, uint64(math.Float32bits(1.5))},
+		{"1.5", "float", 8, math.Float64bits(1.5)},
+	}
+
+	for _, test := range tests {
+		params := ExpressionEngineParams{Size: test.size, Expr: test.expr, Type: test.typ}
+		eng, err := NewExpressionEngine(&params, feMgr)
+		if err != nil {
+			t.Fatalf("Error while generating new engine for '%v'.\n %v.", test.expr, err.Error())
+		}
+		b := make([]byte, 8)
+		eng.Update(b)
+		var value uint64
+		for _, c := range b[:test.size] {
+			value = value<<8 | uint64(c)
+		}
+		if value != test.expected {
+			t.Errorf("Incorrect value for '%v', want %#x, have %#x.", test.expr, test.expected, value)
+		}
+	}
+}
+
+// TestExpressionEngineRandom
+func TestExpressionEngineRandom(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	params := ExpressionEngineParams{Size: 2, Expr: "rand(10, 20) + choice(0, 100)", Type: "uint"}
+	eng, err := NewExpressionEngine(&params, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	b := make([]byte, 2)
+	generated := make(map[uint16]bool)
+	for i := 0; i < 1<<10; i++ {
+		eng.Update(b)
+		value := binary.BigEndian.Uint16(b)
+		if !(value >= 10 && value <= 20) && !(value >= 110 && value <= 120) {
+			t.Fatalf("Expression engine generated %v, not in [10-20] or [110-120].", value)
+		}
+		generated[value] = true
+	}
+	if len(generated) != 22 {
+		t.Fatalf("Expression engine generated %v different values, want 22.", len(generated))
+	}
+}
+
+// TestExpressionEngineRandomRange
+func TestExpressionEngineRandomRange(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	// rand arguments that can't be drawn from return an error instead of panicking.
+	for _, expr := range []string{"rand(20, 10)", "rand(0, 1e30)", "rand(-1e30, 0)"} {
+		params := ExpressionEngineParams{Size: 8, Expr: expr, Type: "int"}
+		eng, err := NewExpressionEngine(&params, feMgr)
+		if err != nil {
+			t.Fatalf("Error while generating new engine for '%v'.\n %v.", expr, err.Error())
+		}
+		b := make([]byte, 8)
+		if _, err = eng.Update(b); err == nil {
+			t.Errorf("Expression '%v' should fail.", expr)
+		}
+	}
+	if feMgr.counters.badExpressionArgs == 0 {
+		t.Errorf("Invalid rand arguments are not counted.")
+	}
+
+	// Division by zero and non-finite results fail instead of writing a value.
+	for _, expr := range []string{"5 / 0", "5 % 0.5", "1e308 * 10", "-1e308 * 10", "1 + 1 / (2 - 2)"} {
+		params := ExpressionEngineParams{Size: 8, Expr: expr, Type: "int"}
+		eng, err := NewExpressionEngine(&params, feMgr)
+		if err != nil {
+			t.Fatalf("Error while generating new engine for '%v'.\n %v.", expr, err.Error())
+		}
+		b := make([]byte, 8)
+		if _, err = eng.Update(b); err == nil {
+			t.Errorf("Expression '%v' should fail.", expr)
+		}
+	}
+
+	// The whole int64 range and a range wider than 2^63.
+	for _, expr := range []string{"rand(-9223372036854775808, 9223372036854775807)", "rand(-9e18, 9e18)", "rand(7, 7)"} {
+		params := ExpressionEngineParams{Size: 8, Expr: expr, Type: "float"}
+		eng, err := NewExpressionEngine(&params, feMgr)
+		if err != nil {
+			t.Fatalf("Error while generating new engine for '%v'.\n %v.", expr, err.Error())
+		}
+		b := make([]byte, 8)
+		for i := 0; i < 1<<8; i++ {
+			if _, err = eng.Update(b); err != nil {
+				t.Fatalf("Expression '%v' failed.\n %v.", expr, err.Error())
+			}
+		}
+	}
+}
+
+// TestSortByDependencies
+func TestSortByDependencies(t *testing.T) {
+	var simrx core.VethIFSim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+
+	data := fastjson.RawMessage(`[
+		{"engine_type": "expression", "engine_name": "c", "params": {"size": 4, "expr": "b + d"}},
+		{"engine_type": "expression", "engine_name": "b", "params": {"size": 4, "expr": "a * 2"}},
+		{"engine_type": "uint", "engine_name": "a", "params": {"size": 4, "min": 0, "max": 10, "op": "inc"}},
+		{"engine_type": "uint", "engine_name": "d", "params": {"size": 4, "min": 0, "max": 10, "op": "inc"}}
+	]`)
+	feMgr, err := NewEngineManager(tctx, &data)
+	if err != nil {
+		t.Fatalf("Error while creating engine manager.\n %v.", err.Error())
+	}
+	order := feMgr.GetEngineOrder()
+	expected := []string{"a", "b", "d", "c"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Bad engine order, want %v, have %v.", expected, order)
+		}
+	}
+	// Names which are not in the list are ignored.
+	order, err = feMgr.SortByDependencies([]string{"d", "c", "b"})
+	if err != nil || order[0] != "d" || order[1] != "b" || order[2] != "c" {
+		t.Fatalf("Bad engine order, want [d b c], have %v, %v.", order, err)
+	}
+}
+
+// validateGeneratedAddresses
+func validateGeneratedAddresses(t *testing.T, eng FieldEngineIF, expected []string) {
+	b := make([]byte, eng.GetSize())
+	for i := range expected {
+		eng.Update(b)
+		var have string
+		if len(b) == 6 {
+			have = net.HardwareAddr(b).String()
+		} else {
+			have = net.IP(b).String()
+		}
+		if have != expected[i] {
+			t.Errorf("Incorrect update no %v, want %v, have %v.", i, expected[i], have)
+		}
+	}
+}
+
+// TestAddressEngines
+func TestAddressEngines(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	eng, err := NewAddressEngine(&AddressEngineParams{Prefix: "10.0.0.7/30", Op: "inc", Step: 3, Repeat: 1, Init: "10.0.0.6"}, addressIPv4, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	validateGeneratedAddresses(t, eng, []string{"10.0.0.6", "10.0.0.5", "10.0.0.4", "10.0.0.7", "10.0.0.6"})
+
+	eng, err = NewAddressEngine(&AddressEngineParams{Prefix: "2001:db8::ffff:ffff:ffff:fffe/63", Op: "dec", Step: 1, Repeat: 2}, addressIPv6, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	validateGeneratedAddresses(t, eng, []string{"2001:db8::", "2001:db8::", "2001:db8:0:1:ffff:ffff:ffff:ffff", "2001:db8:0:1:ffff:ffff:ffff:ffff"})
+
+	eng, err = NewAddressEngine(&AddressEngineParams{Prefix: "00:11:22:00:00:00/24", Op: "inc", Step: 1, Repeat: 1, Init: "00:11:22:ff:ff:ff"}, addressMac, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	validateGeneratedAddresses(t, eng, []string{"00:11:22:ff:ff:ff", "00:11:22:00:00:00", "00:11:22:00:00:01"})
+
+	// Random addresses cover the prefix
+	eng, err = NewAddressEngine(&AddressEngineParams{Prefix: "2001:db8::/124", Op: "rand", Step: 1, Repeat: 1}, addressIPv6, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	_, prefix, _ := net.ParseCIDR("2001:db8::/124")
+	generated := make(map[string]bool)
+	b := make([]byte, 16)
+	for i := 0; i < 1<<10; i++ {
+		eng.Update(b)
+		if !prefix.Contains(net.IP(b)) {
+			t.Fatalf("Address engine generated %v, not in prefix %v.", net.IP(b), prefix)
+		}
+		generated[net.IP(b).String()] = true
+	}
+	if len(generated) != 16 {
+		t.Fatalf("Address engine generated %v different addresses, want 16.", len(generated))
+	}
+}
+
+// TestAddressEnginesNegative
+func TestAddressEnginesNegative(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	tests := []struct {
+		params AddressEngineParams
+		kind   addressKind
+	}{
+		{AddressEngineParams{Prefix: "10.0.0.0/33", Op: "inc"}, addressIPv4},
+		{AddressEngineParams{Prefix: "2001:db8::/64", Op: "inc"}, addressIPv4},
+		{AddressEngineParams{Prefix: "10.0.0.0/8", Op: "inc"}, addressIPv6},
+		{AddressEngineParams{Prefix: "00:11:22/24", Op: "inc"}, addressMac},
+		{AddressEngineParams{Prefix: "10.0.0.0/24", Op: "inc", Init: "10.0.1.0"}, addressIPv4},
+		{AddressEngineParams{Prefix: "10.0.0.0/24", Op: "shuffle"}, addressIPv4},
+	}
+	for _, test := range tests {
+		if _, err := NewAddressEngine(&test.params, test.kind, feMgr); err == nil {
+			t.Errorf("Created %v engine with invalid params %+v.", test.kind, test.params)
+		}
+	}
+	expected := FieldEngineCounters{invalidPrefix: 4, badInitValue: 1, badOperation: 1}
+	if *feMgr.counters != expected {
+		t.Fatalf("Bad counters, want %+v, have %+v.", expected, *feMgr.counters)
+	}
+}
+
+// writeTraceFile writes a trace file in a temporary directory.
+func writeTraceFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	data := []byte(content)
+	if filepath.Ext(name) == ".gz" {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(data)
+		zw.Close()
+		data = b.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed writing trace file: %v", err)
+	}
+	return path
+}
+
+// TestTraceEngine
+func TestTraceEngine(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	csvPath := writeTraceFile(t, "flows.csv.gz", "src, bytes, app, rtt\n"+
+		"10.0.0.1, 1500, http, -1.5\n"+
+		"# comment\n"+
+		"2001:db8::1, 64, dns, 2.25\n")
+
+	eng, err := NewTraceEngine(&TraceEngineParams{File: csvPath, Header: true, Column: "bytes", Type: "uint", Size: 2, Loop: true}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	validateGeneratedUint16(make([]byte, 2), []uint16{1500, 64, 1500}, eng, t)
+
+	eng, err = NewTraceEngine(&TraceEngineParams{File: csvPath, Header: true, Column: "rtt", Type: "int", Size: 1, Loop: true}, feMgr)
+	if err == nil {
+		t.Fatalf("Created int engine with float values.")
+	}
+
+	eng, err = NewTraceEngine(&TraceEngineParams{File: csvPath, Header: true, Column: "app", Type: "string", Size: 6, ShouldPad: true, PaddingValue: '#'}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	b := make([]byte, 6)
+	for _, expected := range []string{"http##", "dns###", "dns###"} {
+		n, _ := eng.Update(b)
+		if string(b[:n]) != expected {
+			t.Errorf("Incorrect string, want %v, have %v.", expected, string(b[:n]))
+		}
+	}
+
+	noHeaderPath := writeTraceFile(t, "macs", "00:11:22:33:44:55,1\n00:11:22:33:44:56,2\n")
+	eng, err = NewTraceEngine(&TraceEngineParams{File: noHeaderPath, Column: "0", Type: "mac", Loop: true}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	validateGeneratedAddresses(t, eng, []string{"00:11:22:33:44:55", "00:11:22:33:44:56", "00:11:22:33:44:55"})
+
+	jsonPath := writeTraceFile(t, "flows.jsonl", `{"src": "10.0.0.1", "rtt": 1.5, "app": "http"}
+
+{"src": "10.0.0.2", "rtt": 3, "app": "dns"}
+`)
+	eng, err = NewTraceEngine(&TraceEngineParams{File: jsonPath, Column: "rtt", Type: "float", Size: 4, ShouldPad: true, Loop: true}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	b = make([]byte, 4)
+	for _, expected := range []float32{1.5, 3, 1.5} {
+		eng.Update(b)
+		if have := math.Float32frombits(binary.BigEndian.Uint32(b)); have != expected {
+			t.Errorf("Incorrect float, want %v, have %v.", expected, have)
+		}
+	}
+	eng, err = NewTraceEngine(&TraceEngineParams{File: jsonPath, Column: "app", Type: "string", Size: 8, ShouldPad: false}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	b = make([]byte, 8)
+	for _, expected := range []string{"http", "dns", "dns"} {
+		n, _ := eng.Update(b)
+		if string(b[:n]) != expected {
+			t.Errorf("Incorrect string, want %v, have %v.", expected, string(b[:n]))
+		}
+	}
+
+	if _, err = NewTraceEngine(&TraceEngineParams{File: jsonPath, Column: "dst", Type: "ipv4"}, feMgr); err == nil {
+		t.Fatalf("Created engine with missing column.")
+	}
+	if _, err = NewTraceEngine(&TraceEngineParams{File: csvPath, Header: true, Column: "src", Type: "ipv4"}, feMgr); err == nil {
+		t.Fatalf("Created IPv4 engine with IPv6 values.")
+	}
+	expected := FieldEngineCounters{badTraceFile: 1, badTraceValue: 2}
+	if *feMgr.counters != expected {
+		t.Fatalf("Bad counters, want %+v, have %+v.", expected, *feMgr.counters)
+	}
+	if len(feMgr.traceFiles) != 3 {
+		t.Fatalf("Trace files were read %v times, want 3.", len(feMgr.traceFiles))
+	}
+}
+
+// sampleEngine updates the engine n times and returns the generated values as float64.
+func sampleEngine(t *testing.T, eng FieldEngineIF, kind valueKind, n int) []float64 {
+	b := make([]byte, eng.GetSize())
+	values := make([]float64, n)
+	for i := range values {
+		if _, err := eng.Update(b); err != nil {
+			t.Fatalf("Error while updating engine.\n %v.", err.Error())
+		}
+		values[i] = decodeValue(b, kind)
+	}
+	return values
+}
+
+// meanStdDev returns the mean and the standard deviation of the values.
+func meanStdDev(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(values)))
+}
+
+// fractionAbove returns the fraction of the values bigger than x.
+func fractionAbove(values []float64, x float64) float64 {
+	count := 0
+	for _, v := range values {
+		if v > x {
+			count++
+		}
+	}
+	return float64(count) / float64(len(values))
+}
+
+// expectClose fails if have is not within tolerance of want, relative or absolute for want = 0.
+func expectClose(t *testing.T, name string, want, have, tolerance float64) {
+	diff := math.Abs(want - have)
+	if want != 0 {
+		diff /= math.Abs(want)
+	}
+	if diff > tolerance {
+		t.Errorf("Bad %v, want %v, have %v.", name, want, have)
+	}
+}
+
+// seedOf returns a pointer to the seed of a distribution.
+func seedOf(seed int64) *int64 {
+	return &seed
+}
+
+// TestDistributionEngines
+func TestDistributionEngines(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+	const n = 1 << 16
+
+	// Normal, int
+	intEng, err := NewIntEngine(&IntEngineParams{
+		BaseNumericEngineParams: BaseNumericEngineParams{Size: 4, Op: "normal", Repeat: 1,
+			DistributionParams: DistributionParams{Mean: -100, StdDev: 20, Seed: seedOf(1)}},
+		MinValue: -1000, MaxValue: 1000, InitValue: math.MinInt64}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	mean, stddev := meanStdDev(sampleEngine(t, intEng, valueSigned, n))
+	expectClose(t, "normal mean", -100, mean, 0.01)
+	expectClose(t, "normal stddev", 20, stddev, 0.02)
+
+	// Exponential, float
+	floatEng, err := NewFloatEngine(&FloatEngineParams{Size: 8, Min: 0, Max: 1e9, Op: "exponential",
+		DistributionParams: DistributionParams{Mean: 250, Seed: seedOf(2)}}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	values := sampleEngine(t, floatEng, valueFloat, n)
+	mean, _ = meanStdDev(values)
+	expectClose(t, "exponential mean", 250, mean, 0.02)
+	expectClose(t, "exponential P(X > mean)", math.Exp(-1), fractionAbove(values, 250), 0.03)
+
+	// Lognormal, float32: the median is e^mu
+	floatEng, err = NewFloatEngine(&FloatEngineParams{Size: 4, Min: 0, Max: 1e9, Op: "lognormal",
+		DistributionParams: DistributionParams{Mu: 5, Sigma: 1.5, Seed: seedOf(3)}}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	values = sampleEngine(t, floatEng, valueFloat, n)
+	expectClose(t, "lognormal P(X > median)", 0.5, fractionAbove(values, math.Exp(5)), 0.02)
+	expectClose(t, "lognormal P(X > e^(mu+sigma))", 0.158655, fractionAbove(values, math.Exp(6.5)), 0.04)
+
+	// Pareto, uint: P(X > x) = (scale / x)^alpha
+	uintEng, err := NewUIntEngine(&UIntEngineParams{
+		BaseNumericEngineParams: BaseNumericEngineParams{Size: 8, Op: "pareto", Repeat: 1,
+			DistributionParams: DistributionParams{Alpha: 1.2, Scale: 1000, Seed: seedOf(4)}},
+		MinValue: 0, MaxValue: math.MaxUint64}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	values = sampleEngine(t, uintEng, valueUnsigned, n)
+	for _, x := range []float64{2000, 10000, 100000} {
+		expectClose(t, fmt.Sprintf("pareto P(X > %v)", x), math.Pow(1000/x, 1.2), fractionAbove(values, x), 0.1)
+	}
+
+	// Zipf, uint16 over [10, 1009]: P(k) is proportional to (v + k)^-s
+	uintEng, err = NewUIntEngine(&UIntEngineParams{
+		BaseNumericEngineParams: BaseNumericEngineParams{Size: 2, Op: "zipf", Repeat: 1,
+			DistributionParams: DistributionParams{S: 1.5, Seed: seedOf(5)}},
+		MinValue: 10, MaxValue: 1009}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	counts := make(map[float64]int)
+	for _, v := range sampleEngine(t, uintEng, valueUnsigned, n) {
+		if v < 10 || v > 1009 {
+			t.Fatalf("Zipf generated %v, not in [10-1009].", v)
+		}
+		counts[v]++
+	}
+	for _, k := range []float64{1, 2, 3} {
+		expectClose(t, fmt.Sprintf("zipf P(%v)/P(0)", k), math.Pow(1+k, -1.5), float64(counts[10+k])/float64(counts[10]), 0.05)
+	}
+
+	// Truncated to the domain
+	uintEng, err = NewUIntEngine(&UIntEngineParams{
+		BaseNumericEngineParams: BaseNumericEngineParams{Size: 1, Op: "normal", Repeat: 1,
+			DistributionParams: DistributionParams{Mean: 100, StdDev: 50, Seed: seedOf(6)}},
+		MinValue: 90, MaxValue: 110}, feMgr)
+	if err != nil {
+		t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+	}
+	for _, v := range sampleEngine(t, uintEng, valueUnsigned, 1<<10) {
+		if v < 90 || v > 110 {
+			t.Fatalf("Normal generated %v, not in [90-110].", v)
+		}
+	}
+}
+
+// TestDistributionEnginesSeed
+func TestDistributionEnginesSeed(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	newEngine := func(seed *int64) FieldEngineIF {
+		eng, err := NewFloatEngine(&FloatEngineParams{Size: 8, Min: 0, Max: 100, Op: "pareto",
+			DistributionParams: DistributionParams{Alpha: 2, Scale: 1, Seed: seed}}, feMgr)
+		if err != nil {
+			t.Fatalf("Error while generating new engine.\n %v.", err.Error())
+		}
+		return eng
+	}
+	equal := func(a, b []float64) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	a := sampleEngine(t, newEngine(seedOf(7)), valueFloat, 100)
+	b := sampleEngine(t, newEngine(seedOf(7)), valueFloat, 100)
+	c := sampleEngine(t, newEngine(seedOf(8)), valueFloat, 100)
+	if !equal(a, b) {
+		t.Fatalf("Engines with the same seed generated different values.")
+	}
+	if equal(a, c) {
+		t.Fatalf("Engines with different seeds generated the same values.")
+	}
+
+	// Without a seed, the engine is seeded from the generator of the thread.
+	tctx.SetSeed(9)
+	a = sampleEngine(t, newEngine(nil), valueFloat, 100)
+	tctx.SetSeed(9)
+	b = sampleEngine(t, newEngine(nil), valueFloat, 100)
+	if !equal(a, b) {
+		t.Fatalf("Engines seeded from the same thread seed generated different values.")
+	}
+
+	// 0 is a seed too, it does not depend on the seed of the thread.
+	tctx.SetSeed(9)
+	a = sampleEngine(t, newEngine(seedOf(0)), valueFloat, 100)
+	tctx.SetSeed(10)
+	b = sampleEngine(t, newEngine(seedOf(0)), valueFloat, 100)
+	if !equal(a, b) {
+		t.Fatalf("Engines with seed 0 generated different values.")
+	}
+}
+
+// TestDistributionEnginesNegative
+func TestDistributionEnginesNegative(t *testing.T) {
+	feMgr, tctx := createEngineManager(t)
+	defer tctx.Delete()
+
+	uintParams := func(op string, dist DistributionParams) *UIntEngineParams {
+		return &UIntEngineParams{BaseNumericEngineParams: BaseNumericEngineParams{Size: 4, Op: op, Repeat: 1,
+			DistributionParams: dist}, MinValue: 0, MaxValue: 1000}
+	}
+	invalid := []*UIntEngineParams{
+		uintParams("normal", DistributionParams{Mean: 10}),
+		uintParams("lognormal", DistributionParams{Mu: 1, Sigma: -1}),
+		uintParams("exponential", DistributionParams{}),
+		uintParams("pareto", DistributionParams{Alpha: 1}),
+		uintParams("zipf", DistributionParams{S: 1}),
+		uintParams("zipf", DistributionParams{S: 2, V: 0.5}),
+	}
+	for _, params := range invalid {
+		if _, err := NewUIntEngine(params, feMgr); err == nil {
+			t.Errorf("Created %v engine with invalid params %+v.", params.Op, params.DistributionParams)
+		}
+	}
+	if _, err := NewFloatEngine(&FloatEngineParams{Size: 4, Min: 0, Max: 10, Op: "zipf",
+		DistributionParams: DistributionParams{S: 2}}, feMgr); err == nil {
+		t.Errorf("Created float engine with zipf distribution.")
+	}
+	if _, err := NewFloatEngine(&FloatEngineParams{Size: 4, Min: 0, Max: 10, Op: "inc"}, feMgr); err == nil {
+		t.Errorf("Created float engine with inc operation.")
+	}
+	expected := FieldEngineCounters{invalidDistribution: 7, badOperation: 1}
+	if *feMgr.counters != expected {
+		t.Fatalf("Bad counters, want %+v, have %+v.", expected, *feMgr.counters)
+	}
+}
@@ -0,0 +1,633 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package field_engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/intel-go/fastjson"
+)
+
+/* ------------------------------------------------------------------------------
+							Expression Engine
+--------------------------------------------------------------------------------*/
+/*
+The expression engine computes the value of a field from the values of other fields of the same record, for
+example:
+
+	octetDeltaCount     = "packetDeltaCount * rand(64, 1500)"
+	destinationPort     = "protocolIdentifier == 6 ? choice(80, 443) : 53"
+	flowEndSysUpTime    = "flowStartSysUpTime + exponential(2000)"
+
+An identifier is the name of another engine, and its value is the last value that engine generated. The engine
+manager orders the engines so that an engine is updated after the engines it references, and rejects cycles.
+
+Operators, from the lowest precedence: ?:, ||, &&, == !=, < <= > >=, + -, * / %, unary - and !.
+Functions: min, max, abs, floor, ceil, round, rand(min, max) (uniform integer), uniform(min, max),
+exponential(mean), normal(mean, stddev) and choice(v1, v2, ...).
+Numbers are decimal, with an optional fraction and exponent (1.5, 1e-5), or hex (0x10).
+
+Division or modulo by zero and a result that is not finite (NaN or Inf) fail the update. A finite result out of
+the range of an int or uint field is clamped to the range.
+*/
+
+// ExpressionEngineParams represents the parameters of an ExpressionEngine.
+type ExpressionEngineParams struct {
+	Size   uint16 `json:"size" validate:"required"` // Size of the field in bytes
+	Offset uint16 `json:"offset"`                   // Offset in which to write in the packet
+	Expr   string `json:"expr" validate:"required"` // The expression
+	Type   string `json:"type"`                     // Type of the result, uint (default), int or float
+}
+
+// ExpressionEngine is an engine that evaluates an expression of other engines.
+type ExpressionEngine struct {
+	par  *ExpressionEngineParams // Parameters
+	mgr  *FieldEngineManager     // Engine Manager
+	expr exprNode                // Parsed expression
+	refs []string                // Names of the engines referenced by the expression
+	vals []*recordedEngine       // Referenced engines, resolved by the manager
+	err  error                   // Error of the last evaluation
+}
+
+// CreateExpressionEngine creates an expression engine.
+func CreateExpressionEngine(params *fastjson.RawMessage, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	p := ExpressionEngineParams{Type: "uint"}
+	err := mgr.tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		mgr.counters.invalidJson++
+		return nil, err
+	}
+	return NewExpressionEngine(&p, mgr)
+}
+
+// NewExpressionEngine creates a new expression engine. The references are resolved by the manager once all
+// the engines are created.
+func NewExpressionEngine(params *ExpressionEngineParams, mgr *FieldEngineManager) (*ExpressionEngine, error) {
+	o := new(ExpressionEngine)
+	o.mgr = mgr
+	err := o.validateParams(params)
+	if err != nil {
+		return nil, err
+	}
+	o.par = params
+
+	p := exprParser{input: params.Expr, engine: o}
+	o.expr, err = p.parse()
+	if err != nil {
+		mgr.counters.invalidExpression++
+		return nil, fmt.Errorf("invalid expression '%s': %w", params.Expr, err)
+	}
+
+	return o, nil
+}
+
+// validateParams validates that the parameters are correct.
+func (o *ExpressionEngine) validateParams(params *ExpressionEngineParams) (err error) {
+	switch params.Type {
+	case "uint", "int":
+		if params.Size != 1 && params.Size != 2 && params.Size != 4 && params.Size != 8 {
+			o.mgr.counters.invalidSize++
+			err = fmt.Errorf("Invalid size %v. Size should be {1, 2, 4, 8}.", params.Size)
+		}
+	case "float":
+		if params.Size != 4 && params.Size != 8 {
+			o.mgr.counters.invalidSize++
+			err = fmt.Errorf("Invalid size %v. Size should be {4, 8}.", params.Size)
+		}
+	default:
+		o.mgr.counters.badOperation++
+		err = fmt.Errorf("Unsupported type %v. Type should be {uint, int, float}.", params.Type)
+	}
+	return err
+}
+
+// references returns the names of the engines that the expression references.
+func (o *ExpressionEngine) references() []string {
+	return o.refs
+}
+
+// valueKind returns the kind of the values the engine writes.
+func (o *ExpressionEngine) valueKind() valueKind {
+	switch o.par.Type {
+	case "int":
+		return valueSigned
+	case "float":
+		return valueFloat
+	}
+	return valueUnsigned
+}
+
+// refIndex returns the index of the reference to name, adding it if needed.
+func (o *ExpressionEngine) refIndex(name string) int {
+	for i := range o.refs {
+		if o.refs[i] == name {
+			return i
+		}
+	}
+	o.refs = append(o.refs, name)
+	return len(o.refs) - 1
+}
+
+// Update implements the Update function of FieldEngineIF.
+func (o *ExpressionEngine) Update(b []byte) (int, error) {
+	if len(b) < int(o.par.Size) {
+		o.mgr.counters.bufferTooShort++
+		return 0, fmt.Errorf("Provided slice is shorter that the size of the variable to write, want at least %v, have %v.", o.par.Size, len(b))
+	}
+
+	o.err = nil
+	value := o.expr(o)
+	if o.err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+		o.setErr(fmt.Errorf("result %v is not finite", value))
+	}
+	if o.err != nil {
+		o.mgr.counters.badExpressionArgs++
+		return 0, fmt.Errorf("expression '%s': %w", o.par.Expr, o.err)
+	}
+
+	switch o.par.Type {
+	case "float":
+		if o.par.Size == 4 {
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(value)))
+		} else {
+			binary.BigEndian.PutUint64(b, math.Float64bits(value))
+		}
+	case "int":
+		bits := 8 * uint(o.par.Size)
+		max := int64(uint64(1)<<(bits-1) - 1)
+		value = math.Round(value)
+		switch {
+		case value >= float64(max):
+			putUint(b, o.par.Size, uint64(max))
+		case value <= float64(-max-1):
+			putUint(b, o.par.Size, uint64(-max-1))
+		default:
+			putUint(b, o.par.Size, uint64(int64(value)))
+		}
+	default:
+		max := uint64(math.MaxUint64) >> (64 - 8*uint(o.par.Size))
+		value = math.Round(value)
+		switch {
+		case value >= float64(max):
+			putUint(b, o.par.Size, max)
+		case value > 0:
+			putUint(b, o.par.Size, uint64(value))
+		default:
+			putUint(b, o.par.Size, 0)
+		}
+	}
+
+	return int(o.par.Size), nil
+}
+
+// setErr keeps the first error of the evaluation.
+func (o *ExpressionEngine) setErr(err error) {
+	if o.err == nil {
+		o.err = err
+	}
+}
+
+// GetOffset implements the GetOffset function of FieldEngineIF.
+func (o *ExpressionEngine) GetOffset() uint16 {
+	return o.par.Offset
+}
+
+// GetSize implements the GetSize function of FieldEngineIF.
+func (o *ExpressionEngine) GetSize() uint16 {
+	return o.par.Size
+}
+
+// putUint writes the value as a big endian unsigned integer of size bytes.
+func putUint(b []byte, size uint16, value uint64) {
+	switch size {
+	case 1:
+		b[0] = uint8(value)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(value))
+	case 4:
+		binary.BigEndian.PutUint32(b, uint32(value))
+	case 8:
+		binary.BigEndian.PutUint64(b, value)
+	}
+}
+
+/* ------------------------------------------------------------------------------
+							Recorded Engines
+--------------------------------------------------------------------------------*/
+
+// valueKind is the interpretation of the bytes an engine writes.
+type valueKind int
+
+const (
+	valueUnsigned valueKind = iota
+	valueSigned
+	valueFloat
+)
+
+// recordedEngine wraps an engine referenced by expressions and keeps the last value it wrote.
+type recordedEngine struct {
+	FieldEngineIF
+	kind  valueKind
+	value float64
+}
+
+// Update implements the Update function of FieldEngineIF, and records the value.
+func (o *recordedEngine) Update(b []byte) (int, error) {
+	n, err := o.FieldEngineIF.Update(b)
+	if err == nil && n > 0 && n <= len(b) {
+		o.value = decodeValue(b[:n], o.kind)
+	}
+	return n, err
+}
+
+// getValueKind returns the kind of the values of the engine type, or an error if they are not numbers.
+func getValueKind(engineType string) (valueKind, error) {
+	switch {
+	case engineType == "float" || engineType == "float_list":
+		return valueFloat, nil
+	case engineType == "int" || engineType == "int_list" || strings.HasPrefix(engineType, "histogram_int"):
+		return valueSigned, nil
+	case engineType == "string_list" || engineType == "histogram_url" || engineType == "histogram_string":
+		return valueUnsigned, fmt.Errorf("engine type %s is not numeric", engineType)
+	}
+	return valueUnsigned, nil
+}
+
+// decodeValue decodes a big endian number of 1 to 8 bytes.
+func decodeValue(b []byte, kind valueKind) float64 {
+	if kind == valueFloat {
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	}
+	if len(b) > 8 {
+		b = b[len(b)-8:]
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	if kind == valueSigned {
+		shift := 64 - 8*uint(len(b))
+		return float64(int64(v<<shift) >> shift)
+	}
+	return float64(v)
+}
+
+/* ------------------------------------------------------------------------------
+							Expression Parser
+--------------------------------------------------------------------------------*/
+
+var errDivByZero = errors.New("division by zero")
+
+// exprNode evaluates a node of the expression.
+type exprNode func(o *ExpressionEngine) float64
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// exprParser is a recursive descent parser of expressions.
+type exprParser struct {
+	input  string
+	pos    int
+	engine *ExpressionEngine
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected '%s' at %d", p.input[p.pos:], p.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes op if it is next.
+func (p *exprParser) accept(op string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.input[p.pos:], op) {
+		p.pos += len(op)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseTernary() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	a, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(":") {
+		return nil, fmt.Errorf("expected ':' at %d", p.pos)
+	}
+	b, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return func(o *ExpressionEngine) float64 {
+		if cond(o) != 0 {
+			return a(o)
+		}
+		return b(o)
+	}, nil
+}
+
+// exprBinaryOps are the binary operators by precedence, from the lowest.
+var exprBinaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprBinaryOps) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range exprBinaryOps[level] {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode(op, left, right)
+	}
+}
+
+func binaryNode(op string, a, b exprNode) exprNode {
+	switch op {
+	case "||":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) != 0 || b(o) != 0) }
+	case "&&":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) != 0 && b(o) != 0) }
+	case "==":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) == b(o)) }
+	case "!=":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) != b(o)) }
+	case "<=":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) <= b(o)) }
+	case ">=":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) >= b(o)) }
+	case "<":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) < b(o)) }
+	case ">":
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) > b(o)) }
+	case "+":
+		return func(o *ExpressionEngine) float64 { return a(o) + b(o) }
+	case "-":
+		return func(o *ExpressionEngine) float64 { return a(o) - b(o) }
+	case "*":
+		return func(o *ExpressionEngine) float64 { return a(o) * b(o) }
+	case "/":
+		return func(o *ExpressionEngine) float64 {
+			d := b(o)
+			if d == 0 {
+				o.setErr(errDivByZero)
+				return 0
+			}
+			return a(o) / d
+		}
+	}
+	// %
+	return func(o *ExpressionEngine) float64 {
+		d := math.Trunc(b(o))
+		if d == 0 {
+			o.setErr(errDivByZero)
+			return 0
+		}
+		return math.Mod(math.Trunc(a(o)), d)
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("-") {
+		a, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(o *ExpressionEngine) float64 { return -a(o) }, nil
+	}
+	if p.accept("!") {
+		a, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(o *ExpressionEngine) float64 { return boolValue(a(o) == 0) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	p.skipSpaces()
+	if p.pos == len(p.input) {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	if p.accept("(") {
+		node, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("expected ')' at %d", p.pos)
+		}
+		return node, nil
+	}
+
+	start := p.pos
+	c := p.input[p.pos]
+	if c >= '0' && c <= '9' || c == '.' {
+		hex := strings.HasPrefix(p.input[p.pos:], "0x") || strings.HasPrefix(p.input[p.pos:], "0X")
+		for p.pos < len(p.input) && (isIdentChar(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+			// sign of the exponent, 0x1e-5 is a subtraction
+			e := p.input[p.pos-1]
+			if !hex && (e == 'e' || e == 'E') && p.pos < len(p.input) && (p.input[p.pos] == '-' || p.input[p.pos] == '+') {
+				p.pos++
+			}
+		}
+		value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			// Hex numbers
+			v, err2 := strconv.ParseUint(p.input[start:p.pos], 0, 64)
+			if err2 != nil {
+				return nil, fmt.Errorf("invalid number '%s'", p.input[start:p.pos])
+			}
+			value = float64(v)
+		}
+		return func(o *ExpressionEngine) float64 { return value }, nil
+	}
+
+	if !isIdentChar(c) {
+		return nil, fmt.Errorf("unexpected '%c' at %d", c, p.pos)
+	}
+	for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+
+	if p.accept("(") {
+		var args []exprNode
+		if !p.accept(")") {
+			for {
+				arg, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.accept(")") {
+					break
+				}
+				if !p.accept(",") {
+					return nil, fmt.Errorf("expected ',' or ')' at %d", p.pos)
+				}
+			}
+		}
+		return callNode(name, args)
+	}
+
+	i := p.engine.refIndex(name)
+	return func(o *ExpressionEngine) float64 { return o.vals[i].value }, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// exprFunc is a function of expressions, with its number of arguments, -1 for any.
+type exprFunc struct {
+	args  int
+	f     func(rnd *rand.Rand, v []float64) float64
+	check func(v []float64) error // Validates the arguments, nil if any are valid
+}
+
+var exprFuncs = map[string]exprFunc{
+	"min":         {2, func(rnd *rand.Rand, v []float64) float64 { return math.Min(v[0], v[1]) }, nil},
+	"max":         {2, func(rnd *rand.Rand, v []float64) float64 { return math.Max(v[0], v[1]) }, nil},
+	"abs":         {1, func(rnd *rand.Rand, v []float64) float64 { return math.Abs(v[0]) }, nil},
+	"floor":       {1, func(rnd *rand.Rand, v []float64) float64 { return math.Floor(v[0]) }, nil},
+	"ceil":        {1, func(rnd *rand.Rand, v []float64) float64 { return math.Ceil(v[0]) }, nil},
+	"round":       {1, func(rnd *rand.Rand, v []float64) float64 { return math.Round(v[0]) }, nil},
+	"rand":        {2, randInt, checkRandInt},
+	"uniform":     {2, func(rnd *rand.Rand, v []float64) float64 { return v[0] + rnd.Float64()*(v[1]-v[0]) }, nil},
+	"exponential": {1, func(rnd *rand.Rand, v []float64) float64 { return rnd.ExpFloat64() * v[0] }, nil},
+	"normal":      {2, func(rnd *rand.Rand, v []float64) float64 { return v[0] + rnd.NormFloat64()*v[1] }, nil},
+	"choice":      {-1, func(rnd *rand.Rand, v []float64) float64 { return v[rnd.Intn(len(v))] }, nil},
+}
+
+// toInt64 converts an argument to int64. float64(math.MaxInt64) rounds up to 2^63, so 2^63 is its maximum.
+func toInt64(x float64) (int64, bool) {
+	switch {
+	case math.IsNaN(x) || x < math.MinInt64 || x > math.MaxInt64:
+		return 0, false
+	case x == math.MaxInt64:
+		return math.MaxInt64, true
+	}
+	return int64(x), true
+}
+
+// checkRandInt validates that the arguments of rand are in the int64 range and min <= max.
+func checkRandInt(v []float64) error {
+	min, okMin := toInt64(v[0])
+	max, okMax := toInt64(v[1])
+	if !okMin || !okMax {
+		return fmt.Errorf("rand arguments %v, %v are out of the int64 range", v[0], v[1])
+	}
+	if min > max {
+		return fmt.Errorf("rand min %v is bigger than max %v", min, max)
+	}
+	return nil
+}
+
+// randInt returns a uniform integer in [min, max], the range can be the whole int64 range.
+func randInt(rnd *rand.Rand, v []float64) float64 {
+	min, _ := toInt64(v[0])
+	max, _ := toInt64(v[1])
+	n := uint64(max-min) + 1 // number of values, 0 if it is the whole 2^64 range
+	var x uint64
+	switch {
+	case n == 0:
+		x = rnd.Uint64()
+	case n <= math.MaxInt64:
+		x = uint64(rnd.Int63n(int64(n)))
+	default:
+		// more than half of the values are in range
+		for x = rnd.Uint64(); x >= n; x = rnd.Uint64() {
+		}
+	}
+	return float64(min + int64(x))
+}
+
+func callNode(name string, args []exprNode) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if fn.args >= 0 && len(args) != fn.args {
+		return nil, fmt.Errorf("function %s expects %d arguments, have %d", name, fn.args, len(args))
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("function %s expects arguments", name)
+	}
+	return func(o *ExpressionEngine) float64 {
+		v := make([]float64, len(args))
+		for i := range args {
+			v[i] = args[i](o)
+		}
+		if fn.check != nil {
+			if err := fn.check(v); err != nil {
+				o.setErr(err)
+				return 0
+			}
+		}
+		return fn.f(o.mgr.rnd, v)
+	}, nil
+}
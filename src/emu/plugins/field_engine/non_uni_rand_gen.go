@@ -18,8 +18,8 @@ using 32-bit fixed point math, with all bits to the right of the decimal point
 // implement this interface as long as they generate integers.
 type GeneratorIF interface {
 	// Generate function that all the types that want to implement this interface must
-	// provide. Our types are quite simple and return an integer.
-	Generate() int
+	// provide. Our types are quite simple and return an integer drawn by rnd.
+	Generate(rnd *rand.Rand) int
 }
 
 // floatToUInt converts a float [0, 1) to a uint32
@@ -57,8 +57,8 @@ func (o *BinDistribution) String() string {
 
 // Generate is the interface implementation that makes BinDistribution an implementation of
 // the generator interface. Generates *a* or *b* according to the probability.
-func (o *BinDistribution) Generate() int {
-	randValue := rand.Uint32()
+func (o *BinDistribution) Generate(rnd *rand.Rand) int {
+	randValue := rnd.Uint32()
 	if randValue <= o.prob {
 		return o.a
 	} else {
@@ -318,10 +318,10 @@ func (o *NonUniformRandGen) decompose() error {
 
 // Generate implements the interface Generator and provives an O(1) time and space
 // complexity generator for non uniform distributions.
-func (o *NonUniformRandGen) Generate() int {
+func (o *NonUniformRandGen) Generate(rnd *rand.Rand) int {
 	// Uniformly choose the binary distribution, all of them have the same probability.
-	binDist := o.binDistributions[rand.Intn(len(o.binDistributions))]
+	binDist := o.binDistributions[rnd.Intn(len(o.binDistributions))]
 	// Generate from that binary distribution.
-	return binDist.Generate()
+	return binDist.Generate(rnd)
 
 }
@@ -6,6 +6,9 @@ import (
 	"testing"
 )
 
+// testRnd draws the values of the generators in the tests.
+var testRnd = rand.New(rand.NewSource(1))
+
 // verifyBinGenerator verifies that the bin generator test results are as expected.
 func verifyBinGenerator(expected, received int, errPerc float64, t *testing.T) {
 	errFloat := errPerc / 100
@@ -77,7 +80,7 @@ func TestBinaryDistribution(t *testing.T) {
 	var res int
 	iterNumber := 1 << 15
 	for i := 0; i < iterNumber; i++ {
-		res += binGen.Generate()
+		res += binGen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber, res, 2, t)
 
@@ -85,7 +88,7 @@ func TestBinaryDistribution(t *testing.T) {
 	res = 0
 	binGen = NewBinDistribution(0, 1, math.MaxUint32)
 	for i := 0; i < iterNumber; i++ {
-		res += binGen.Generate()
+		res += binGen.Generate(testRnd)
 	}
 	verifyBinGenerator(0, res, 2, t)
 
@@ -93,7 +96,7 @@ func TestBinaryDistribution(t *testing.T) {
 	res = 0
 	binGen = NewBinDistribution(0, 1, math.MaxUint32>>1)
 	for i := 0; i < iterNumber; i++ {
-		res += binGen.Generate()
+		res += binGen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber>>1, res, 2, t)
 
@@ -101,7 +104,7 @@ func TestBinaryDistribution(t *testing.T) {
 	res = 0
 	binGen = NewBinDistribution(0, 1, math.MaxUint32>>2)
 	for i := 0; i < iterNumber*4; i++ {
-		res += binGen.Generate()
+		res += binGen.Generate(testRnd)
 	}
 	verifyBinGenerator(3*iterNumber, res, 2, t)
 
@@ -111,7 +114,7 @@ func TestBinaryDistribution(t *testing.T) {
 	expectedFives := int(0.3 * float64(iterNumber))
 	binGen = NewBinDistribution(5, 7, floatToUInt(0.3))
 	for i := 0; i < iterNumber; i++ {
-		res = binGen.Generate()
+		res = binGen.Generate(testRnd)
 		if res == 5 {
 			fives++
 		} else if res == 7 {
@@ -137,7 +140,7 @@ func TestNonUniRandGenBin(t *testing.T) {
 	}
 
 	for i := 0; i < iterNumber; i++ {
-		res += gen.Generate()
+		res += gen.Generate(testRnd)
 	}
 	verifyBinGenerator(2*iterNumber/3, res, 1, t)
 
@@ -150,7 +153,7 @@ func TestNonUniRandGenBin(t *testing.T) {
 	}
 
 	for i := 0; i < iterNumber; i++ {
-		res += gen.Generate()
+		res += gen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber, res, 1, t)
 
@@ -163,7 +166,7 @@ func TestNonUniRandGenBin(t *testing.T) {
 	}
 
 	for i := 0; i < iterNumber; i++ {
-		res += gen.Generate()
+		res += gen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber, iterNumber-res, 1, t)
 
@@ -175,7 +178,7 @@ func TestNonUniRandGenBin(t *testing.T) {
 		t.Fatalf("Failed building generator with input %v.\n %v", dist, err.Error())
 	}
 	for i := 0; i < iterNumber; i++ {
-		res += gen.Generate()
+		res += gen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber>>1, res, 1, t)
 
@@ -187,7 +190,7 @@ func TestNonUniRandGenBin(t *testing.T) {
 		t.Fatalf("Failed building generator with input %v.\n %v", dist, err.Error())
 	}
 	for i := 0; i < iterNumber; i++ {
-		res += gen.Generate()
+		res += gen.Generate(testRnd)
 	}
 	verifyBinGenerator(iterNumber>>1, res, 1, t)
 }
@@ -206,7 +209,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -218,7 +221,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -231,7 +234,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -243,7 +246,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -255,7 +258,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -271,7 +274,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	verifyDistribution(dist, histogram, iterNumber, 1, t)
 
@@ -283,7 +286,7 @@ func TestNonUniRandGen(t *testing.T) {
 	}
 	histogram = make([]uint32, len(dist))
 	for i := 0; i < int(iterNumber); i++ {
-		histogram[gen.Generate()]++
+		histogram[gen.Generate(testRnd)]++
 	}
 	if histogram[0] != iterNumber {
 		t.Errorf("Failed generating with only one distribution, want %v, have %v.", iterNumber, histogram[0])
@@ -0,0 +1,372 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package field_engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+/* ------------------------------------------------------------------------------
+							Trace Engine
+--------------------------------------------------------------------------------*/
+/*
+The trace engine streams the values of one column of a CSV or JSON lines file, one row per update. Engines
+of the same manager which read the same file advance together, row by row, so a record can replay the rows
+of a trace collected in production. The file is read once per engine manager.
+*/
+
+// TraceEngineParams is a struct of parameters for the TraceEngine.
+type TraceEngineParams struct {
+	Size         uint16 `json:"size"`                       // Size of the value in bytes
+	Offset       uint16 `json:"offset"`                     // Offset in which to write in the packet
+	File         string `json:"file" validate:"required"`   // Path of the trace file, can be gzip compressed
+	Format       string `json:"format"`                     // csv or jsonl, default by the file extension
+	Header       bool   `json:"header"`                     // The first row of a csv file is a header. Default = true.
+	Column       string `json:"column" validate:"required"` // Column name, or index of the column in a csv without header
+	Type         string `json:"type" validate:"required"`   // Type of the values, {uint, int, float, string, ipv4, ipv6, mac}
+	Loop         bool   `json:"loop"`                       // Restart from the first row at the end of the file. Default = true.
+	ShouldPad    bool   `json:"should_pad"`                 // Pad strings to size. Default = true.
+	PaddingValue uint8  `json:"padding_value"`              // Value which will be used to pad the strings
+}
+
+// TraceEngine is a field engine that generates the values of a column of a trace file.
+type TraceEngine struct {
+	par    *TraceEngineParams  // Parameters
+	mgr    *FieldEngineManager // Engine Manager
+	values [][]byte            // Encoded values of the column
+	index  int                 // Index of the next value
+}
+
+// CreateTraceEngine creates a new FieldEngineIF interface of type TraceEngine.
+func CreateTraceEngine(params *fastjson.RawMessage, mgr *FieldEngineManager) (FieldEngineIF, error) {
+	p := TraceEngineParams{Header: true, Loop: true, ShouldPad: true}
+	err := mgr.tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		mgr.counters.invalidJson++
+		return nil, err
+	}
+	return NewTraceEngine(&p, mgr)
+}
+
+// NewTraceEngine creates a new trace engine.
+func NewTraceEngine(params *TraceEngineParams, mgr *FieldEngineManager) (*TraceEngine, error) {
+	o := new(TraceEngine)
+	o.par = params
+	o.mgr = mgr
+
+	err := o.validateParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := mgr.getTraceFile(params.File, params.Format, params.Header)
+	if err != nil {
+		mgr.counters.badTraceFile++
+		return nil, err
+	}
+	column, ok := trace.columns[params.Column]
+	if !ok {
+		mgr.counters.badTraceFile++
+		return nil, fmt.Errorf("Column %v not found in trace file %v.", params.Column, params.File)
+	}
+
+	o.values = make([][]byte, len(column))
+	for i := range column {
+		o.values[i], err = o.encode(column[i])
+		if err != nil {
+			mgr.counters.badTraceValue++
+			return nil, fmt.Errorf("Row %v of column %v in trace file %v: %w", i+1, params.Column, params.File, err)
+		}
+	}
+	return o, nil
+}
+
+// validateParams validates the size of the type.
+func (o *TraceEngine) validateParams(params *TraceEngineParams) error {
+	switch params.Type {
+	case "uint", "int":
+		if params.Size != 1 && params.Size != 2 && params.Size != 4 && params.Size != 8 {
+			o.mgr.counters.invalidSize++
+			return fmt.Errorf("Invalid size %v. Size should be {1, 2, 4, 8}.", params.Size)
+		}
+	case "float":
+		if params.Size != 4 && params.Size != 8 {
+			o.mgr.counters.invalidSize++
+			return fmt.Errorf("Invalid size %v. Size should be {4, 8}.", params.Size)
+		}
+	case "string":
+		if params.Size == 0 {
+			o.mgr.counters.invalidSize++
+			return fmt.Errorf("Size must be provided for strings.")
+		}
+	case "ipv4", "ipv6", "mac":
+		length := map[string]uint16{"ipv4": 4, "ipv6": 16, "mac": 6}[params.Type]
+		if params.Size == 0 {
+			params.Size = length
+		}
+		if params.Size != length {
+			o.mgr.counters.invalidSize++
+			return fmt.Errorf("Invalid size %v for %v, size should be %v.", params.Size, params.Type, length)
+		}
+	default:
+		o.mgr.counters.badOperation++
+		return fmt.Errorf("Unsupported type %v. Type should be {uint, int, float, string, ipv4, ipv6, mac}.", params.Type)
+	}
+	return nil
+}
+
+// encode encodes a value of the trace file to the type of the engine.
+func (o *TraceEngine) encode(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	b := make([]byte, o.par.Size)
+	switch o.par.Type {
+	case "uint":
+		v, err := strconv.ParseUint(s, 0, 8*int(o.par.Size))
+		if err != nil {
+			return nil, err
+		}
+		putUint(b, o.par.Size, v)
+	case "int":
+		v, err := strconv.ParseInt(s, 0, 8*int(o.par.Size))
+		if err != nil {
+			return nil, err
+		}
+		putUint(b, o.par.Size, uint64(v))
+	case "float":
+		v, err := strconv.ParseFloat(s, 8*int(o.par.Size))
+		if err != nil {
+			return nil, err
+		}
+		if o.par.Size == 4 {
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+		} else {
+			binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		}
+	case "string":
+		if len(s) > int(o.par.Size) {
+			return nil, fmt.Errorf("string %v is longer than size %v", s, o.par.Size)
+		}
+		if !o.par.ShouldPad {
+			return []byte(s), nil
+		}
+		copy(b, s)
+		for i := len(s); i < len(b); i++ {
+			b[i] = o.par.PaddingValue
+		}
+	case "ipv4":
+		addr, err := parseAddress(s, addressIPv4)
+		if err != nil {
+			return nil, err
+		}
+		copy(b, addr)
+	case "ipv6":
+		addr, err := parseAddress(s, addressIPv6)
+		if err != nil {
+			return nil, err
+		}
+		copy(b, addr)
+	case "mac":
+		addr, err := parseAddress(s, addressMac)
+		if err != nil {
+			return nil, err
+		}
+		copy(b, addr)
+	}
+	return b, nil
+}
+
+// valueKind returns the kind of the values the engine writes, or an error if they are not numbers.
+func (o *TraceEngine) valueKind() (valueKind, error) {
+	switch o.par.Type {
+	case "int":
+		return valueSigned, nil
+	case "float":
+		return valueFloat, nil
+	case "string":
+		return valueUnsigned, fmt.Errorf("trace engine of type %s is not numeric", o.par.Type)
+	}
+	return valueUnsigned, nil
+}
+
+// Update implements the Update function of FieldEngineIF.
+func (o *TraceEngine) Update(b []byte) (int, error) {
+	if len(b) < int(o.par.Size) {
+		o.mgr.counters.bufferTooShort++
+		return 0, fmt.Errorf("Provided slice is shorter that the size of the variable to write, want at least %v, have %v.", o.par.Size, len(b))
+	}
+	if len(o.values) == 0 {
+		return 0, nil
+	}
+	value := o.values[o.index]
+	copy(b, value)
+
+	o.index++
+	if o.index == len(o.values) {
+		if o.par.Loop {
+			o.index = 0
+		} else {
+			// Keep the last value
+			o.index--
+		}
+	}
+	return len(value), nil
+}
+
+// GetOffset implements the GetOffset function of FieldEngineIF.
+func (o *TraceEngine) GetOffset() uint16 {
+	return o.par.Offset
+}
+
+// GetSize implements the GetSize function of FieldEngineIF.
+func (o *TraceEngine) GetSize() uint16 {
+	return o.par.Size
+}
+
+/* ------------------------------------------------------------------------------
+								Trace File
+--------------------------------------------------------------------------------*/
+
+// traceFile is a trace file parsed to its columns.
+type traceFile struct {
+	columns map[string][]string // Maps the column name to its values
+}
+
+// getTraceFile returns the trace file, loading it if it wasn't loaded by the manager.
+func (o *FieldEngineManager) getTraceFile(path, format string, header bool) (*traceFile, error) {
+	key := fmt.Sprintf("%s:%s:%v", path, format, header)
+	if trace, ok := o.traceFiles[key]; ok {
+		return trace, nil
+	}
+	trace, err := loadTraceFile(path, format, header)
+	if err != nil {
+		return nil, err
+	}
+	if o.traceFiles == nil {
+		o.traceFiles = make(map[string]*traceFile)
+	}
+	o.traceFiles[key] = trace
+	return trace, nil
+}
+
+// loadTraceFile reads and parses a trace file.
+func loadTraceFile(path, format string, header bool) (*traceFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := path
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("Failed reading gzip trace file %v: %w", path, err)
+		}
+		data, err = io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("Failed reading gzip trace file %v: %w", path, err)
+		}
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	if format == "" {
+		format = "csv"
+		if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson") {
+			format = "jsonl"
+		}
+	}
+
+	switch format {
+	case "csv":
+		return parseTraceCsv(data, path, header)
+	case "jsonl":
+		return parseTraceJsonl(data, path)
+	}
+	return nil, fmt.Errorf("Unsupported trace file format %v. Format should be {csv, jsonl}.", format)
+}
+
+// parseTraceCsv parses a csv trace file. Without a header, the columns are named by their index.
+func parseTraceCsv(data []byte, path string, header bool) (*traceFile, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing csv trace file %v: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Trace file %v is empty.", path)
+	}
+	names := make([]string, len(rows[0]))
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	if header {
+		names = rows[0]
+		rows = rows[1:]
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Trace file %v has no rows.", path)
+	}
+
+	trace := &traceFile{columns: make(map[string][]string, len(names))}
+	for i, name := range names {
+		column := make([]string, len(rows))
+		for j := range rows {
+			column[j] = rows[j][i]
+		}
+		trace.columns[strings.TrimSpace(name)] = column
+	}
+	return trace, nil
+}
+
+// parseTraceJsonl parses a JSON lines trace file, where each line is an object. Numbers are kept as
+// written and a key missing in a line has an empty value.
+func parseTraceJsonl(data []byte, path string) (*traceFile, error) {
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		d := fastjson.NewDecoder(bytes.NewReader(text))
+		d.UseNumber()
+		var row map[string]interface{}
+		if err := d.Decode(&row); err != nil {
+			return nil, fmt.Errorf("Failed parsing line %v of trace file %v: %w", line, path, err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Trace file %v has no rows.", path)
+	}
+
+	trace := &traceFile{columns: make(map[string][]string)}
+	for i, row := range rows {
+		for key, value := range row {
+			column, ok := trace.columns[key]
+			if !ok {
+				column = make([]string, len(rows))
+				trace.columns[key] = column
+			}
+			column[i] = fmt.Sprint(value)
+		}
+	}
+	return trace, nil
+}
@@ -153,7 +153,7 @@ func (o *PluginIcmpClient) StartPing(data *ApiIcmpClientStartPingHandler) bool {
 	}
 	o.pingData = data
 	params := ping.PingParams{Amount: data.Amount, Pace: data.Pace, Timeout: data.Timeout}
-	o.ping = ping.NewPing(params, o.Ns, o)
+	o.ping = ping.NewPing(params, o.Client, o)
 	o.ping.StartPinging()
 	return true
 }
@@ -257,6 +257,10 @@ type PluginIcmpNs struct {
 	cdbv  *core.CCounterDbVec
 }
 
+func (o *PluginIcmpNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewIcmpNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginIcmpNs)
 	o.InitPluginBase(ctx, o)
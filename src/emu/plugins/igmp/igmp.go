@@ -35,7 +35,6 @@ import (
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"math"
-	"math/rand"
 	"net"
 	"sort"
 	"unsafe"
@@ -159,6 +158,7 @@ type IgmpNsStats struct {
 	pktSndAddRemoveReports    uint64 /* explicit reports dur to add/remove command */
 	pktNoDesignatorClient     uint64 /* There is no designator client with this MAC addr */
 	pktNoDesignatorClientIPv4 uint64 /* there designator client does not have valid IPv4 addr */
+	pktDesignatorNotActive    uint64 /* the designator client waits for the ramp-up */
 
 	opsAdd       uint64 /* add mc addr (*) */
 	opsRemove    uint64 /* add mc addr (*) */
@@ -422,6 +422,14 @@ func NewIgmpNsStatsDb(o *IgmpNsStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktDesignatorNotActive,
+		Name:     "pktDesignatorNotActive",
+		Help:     "designator client is not active yet, reports are sent on activation",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.pktNoDesignatorClientIPv4,
 		Name:     "pktNoDesignatorClientIPv4",
@@ -667,7 +675,7 @@ type PluginIgmpClient struct {
 	igmpNsPlug *PluginIgmpNs
 }
 
-var igmpEvents = []string{}
+var igmpEvents = []string{core.MSG_CLIENT_ACTIVATED}
 
 /*NewIgmpClient create plugin */
 func NewIgmpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -682,7 +690,12 @@ func NewIgmpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 
 /*OnEvent support event change of IP  */
 func (o *PluginIgmpClient) OnEvent(msg string, a, b interface{}) {
-
+	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		if o.Client.Mac == o.igmpNsPlug.designatorMac {
+			o.igmpNsPlug.onDesignatorActivated()
+		}
+	}
 }
 
 func (o *PluginIgmpClient) OnRemove(ctx *core.PluginCtx) {
@@ -738,6 +751,10 @@ type PluginIgmpNs struct {
 	iterReady       bool
 }
 
+func (o *PluginIgmpNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewIgmpNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 
 	o := new(PluginIgmpNs)
@@ -1088,7 +1105,7 @@ func (o *PluginIgmpNs) HandleRxIgmpCmn(isGenQuery bool, igmpAddr uint32) int {
 				if o.Tctx.Simulation {
 					startTick = uint32(1) / o.timerw.MinTickMsec()
 				} else {
-					startTick = uint32(rand.Intn(int(maxRespMsec))+1) / o.timerw.MinTickMsec()
+					startTick = uint32(o.Ns.Rand().Intn(int(maxRespMsec))+1) / o.timerw.MinTickMsec()
 				}
 				o.ticks = 0
 				o.pktPerTick = 1
@@ -1128,12 +1145,24 @@ func (o *PluginIgmpNs) SendMcPacketSGChangeToInclude(vec []uint32) {
 	o.SendMcPacket(vec, true, false)
 }
 
+// onDesignatorActivated reports the groups that were added while the designator client waited for the ramp-up,
+// as if a general query was received
+func (o *PluginIgmpNs) onDesignatorActivated() {
+	if len(o.tbl.mapIgmp) > 0 && !o.activeQuery {
+		o.HandleRxIgmpCmn(true, 0)
+	}
+}
+
 func (o *PluginIgmpNs) getdClient() *core.CClient {
 	client := o.Ns.CLookupByMac(&o.designatorMac)
 	if client == nil {
 		o.stats.pktNoDesignatorClient++
 		return nil
 	}
+	if !client.IsActive() {
+		o.stats.pktDesignatorNotActive++
+		return nil
+	}
 	if client.Ipv4.IsZero() {
 		o.stats.pktNoDesignatorClientIPv4++
 		return nil
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package igmp
+
+import (
+	"emu/core"
+	"external/osamingo/jsonrpc"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+// VethIgmpRampSim counts the IGMP reports that are sent
+type VethIgmpRampSim struct {
+	reports int
+}
+
+func (o *VethIgmpRampSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	// two VLAN tags, IPv4 protocol is IGMP
+	if len(p) > 22+9 && p[20] == 0x08 && p[21] == 0x00 && p[22+9] == 2 {
+		o.reports++
+	}
+	m.FreeMbuf()
+	return nil
+}
+
+func rampTestRpc(t *testing.T, tctx *core.CThreadCtx, h jsonrpc.Handler, params string) {
+	raw := fastjson.RawMessage(params)
+	if _, err := h.ServeJSONRPC(tctx, &raw); err != nil {
+		t.Fatalf(" rpc failed: %v", err.Message)
+	}
+}
+
+/*TestPluginIgmpRamp - the designator client waits for the ramp-up, no report is sent before it is activated */
+func TestPluginIgmpRamp(t *testing.T) {
+	var simVeth VethIgmpRampSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"igmp"}, [][]byte{[]byte(`{"dmac" :[0, 0, 1, 0, 0, 1]  } `)})
+	tctx.RegisterParserCb("igmp")
+	tctx.Veth.SetDebug(monitor > 0, os.Stdout, true)
+
+	rampTestRpc(t, tctx, core.ApiRampSetHandler{}, `{"rate": 1}`)
+	rampTestRpc(t, tctx, core.ApiClientAddHandler{}, `{"tun": {"vport": 1, "tpid": [33024, 33024], "tci": [1, 2]},
+		"clients": [{"mac": [0, 0, 1, 0, 0, 1], "ipv4": [16, 0, 0, 1], "ipv4_dg": [16, 0, 0, 2], "plugs": {"igmp": {}}}]}`)
+
+	client := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 1})
+	if client == nil || client.IsActive() {
+		t.Fatalf(" designator client should wait for the ramp-up")
+	}
+	nsPlug := ns.PluginCtx.Get(IGMP_PLUG).Ext.(*PluginIgmpNs)
+	nsPlug.addMc([]core.Ipv4Key{{239, 0, 0, 1}, {239, 0, 0, 2}, {239, 0, 0, 3}})
+
+	tctx.MainLoopSim(500 * time.Millisecond)
+	if client.IsActive() {
+		t.Fatalf(" designator client was activated too early")
+	}
+	if simVeth.reports != 0 {
+		t.Fatalf(" %d IGMP reports were sent before the designator client was activated", simVeth.reports)
+	}
+	if nsPlug.stats.pktDesignatorNotActive == 0 {
+		t.Fatalf(" reports that wait for the designator are not counted")
+	}
+
+	tctx.MainLoopSim(2 * time.Second)
+	if !client.IsActive() {
+		t.Fatalf(" designator client was not activated")
+	}
+	if simVeth.reports == 0 || nsPlug.stats.pktRxSndReports == 0 {
+		t.Fatalf(" groups were not reported on activation")
+	}
+
+	nsPlug.cdb.Dump()
+	tctx.SimRecordAppend(nsPlug.cdb.MarshalValues(false))
+	tctx.SimRecordCompare("igmp_ramp", t)
+}
@@ -91,7 +91,7 @@ func (o *IgmpTestBase) Run(t *testing.T) {
 	tctx.MainLoopSim(o.duration)
 	defer tctx.Delete()
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 
 	ns := tctx.GetNs(&key)
 	if ns == nil {
@@ -114,7 +114,7 @@ func (o *IgmpTestBase) Run(t *testing.T) {
 func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
 	var key core.CTunnelKey
-	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001, 0x81000002}})
 	ns := core.NewNSCtx(tctx, &key)
 
 	tctx.AddNs(&key, ns)
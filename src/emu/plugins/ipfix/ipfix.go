@@ -15,6 +15,7 @@ A typical flow monitoring setup (using NetFlow) consists of three main component
 * Analysis application: analyzes received flow data in the context of intrusion detection or traffic profiling, for example.
 
 TRex EMU emulates the aforementioned flow exporter for https://tools.ietf.org/html/rfc3954 Netflow v9, RFC 3954 and https://tools.ietf.org/html/rfc7011 Netflow v10 (IPFix), RFC 7011.
+The legacy NetFlow v5 and sFlow v5 formats are supported as well, see ipfix_legacy_formats.go.
 */
 
 import (
@@ -27,7 +28,6 @@ import (
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"math"
-	"math/rand"
 	"net"
 	"net/url"
 	"strings"
@@ -94,6 +94,7 @@ type IPFixGenParams struct {
 	ScopeCount      uint16               `json:"scope_count"`                     // Scope Count for Option Templates, the number of fields that are scoped.
 	Fields          []*IPFixField        `json:"fields" validate:"required"`      // Template Fields of this generator.
 	Engines         *fastjson.RawMessage `json:"engines"`                         // Field Engines for the templates
+	SFlowSample     string               `json:"sflow_sample"`                    // sFlow sample type, flow (default) or counter.
 }
 
 // IPFixGen represents a fixed collection of fields which can change over time depending on the engines
@@ -123,10 +124,12 @@ type IPFixGen struct {
 	fieldNames             map[string]bool                  // Set of field names.
 	engineMgr              *engines.FieldEngineManager      // Field Engine Manager
 	engineMap              map[string]engines.FieldEngineIF // Map of engine name to field engine interface
+	engineOrder            []int                            // Indexes of fields with engines in dependency order, nil if no engine references another.
 	dataTimer              core.CHTimerObj                  // Timer for Data Set packets.
 	templateTimer          core.CHTimerObj                  // Timer for Template Set packets
 	timerw                 *core.TimerCtx                   // Timer Wheel
 	ipfixPlug              *PluginIPFixClient               // Pointer to the IPFixClient that owns this generator.
+	legacy                 *legacyRecord                    // Fixed record of NetFlow v5 and sFlow v5, nil for templates based formats.
 }
 
 // NewIPFixGen creates a new IPFix generator (exporting process) based on the parameters received in the
@@ -264,6 +267,38 @@ func NewIPFixGen(ipfix *PluginIPFixClient, initJson *fastjson.RawMessage) (*IPFi
 		}
 	}
 
+	// Engines that reference other fields must run after them, not in the order of the fields.
+	if o.engineMgr != nil && o.engineMgr.HasDependencies() {
+		var names []string
+		fieldIndex := make(map[string]int, len(o.fields))
+		for i, field := range o.fields {
+			if _, ok := o.engineMap[field.Name]; ok {
+				names = append(names, field.Name)
+				fieldIndex[field.Name] = i
+			}
+		}
+		names, err = o.engineMgr.SortByDependencies(names)
+		if err != nil {
+			o.ipfixPlug.stats.failedBuildingEngineMgr++
+			return nil, err
+		}
+		for _, name := range names {
+			o.engineOrder = append(o.engineOrder, fieldIndex[name])
+		}
+	}
+
+	if !o.ipfixPlug.format.hasTemplates() {
+		if o.optionsTemplate {
+			o.ipfixPlug.stats.invalidLegacyRecord++
+			return nil, fmt.Errorf("%s does not support options templates", o.ipfixPlug.format)
+		}
+		o.legacy, err = newLegacyRecord(o.ipfixPlug.format, init.SFlowSample, o.fields)
+		if err != nil {
+			o.ipfixPlug.stats.invalidLegacyRecord++
+			return nil, err
+		}
+	}
+
 	// Calculate Ticks for Timer.
 	o.templateTicks = o.timerw.DurationToTicks(time.Duration(float32(time.Second) / o.templateRate))
 	o.dataTicks, o.dataPktsPerInterval = o.timerw.DurationToTicksBurst(time.Duration(float32(time.Second) / o.dataRate))
@@ -392,6 +427,10 @@ func (o *IPFixGen) OnResolve() bool {
 
 // calcAvailableRecordPayload calculates the amount of bytes available for record payloads.
 func (o *IPFixGen) calcAvailableRecordPayload() {
+	if o.legacy != nil {
+		o.availableRecordPayload = o.maxPacketSize - o.ipfixPlug.getLegacyHeaderLen()
+		return
+	}
 	ipfixHeaderLen := layers.IpfixHeaderLenVer10
 	if o.ipfixPlug.ver == 9 {
 		ipfixHeaderLen = layers.IpfixHeaderLenVer9
@@ -434,7 +473,14 @@ func (o *IPFixGen) calcLongestRecord() (length int) {
 // This function should be called only on generators that don't contain variable length fields.
 func (o *IPFixGen) calcMaxRecords() uint32 {
 	recordLength := len(o.dataBuffer) // length of 1 record.
-	return uint32(o.availableRecordPayload / recordLength)
+	if o.legacy != nil {
+		recordLength = o.legacy.length
+	}
+	maxRecords := uint32(o.availableRecordPayload / recordLength)
+	if o.ipfixPlug.format == formatNetflowV5 && maxRecords > netflowV5MaxRecords {
+		maxRecords = netflowV5MaxRecords
+	}
+	return maxRecords
 }
 
 // calcMaxRecordsVarLength calculates the maximum number of records we can send in case of variable length.
@@ -479,6 +525,11 @@ func (o *IPFixGen) isReachedMaxTempRecordsToSend() bool {
 
 // sendTemplatePkt sends a Template packet
 func (o *IPFixGen) sendTemplatePkt() {
+	if !o.ipfixPlug.format.hasTemplates() {
+		// NetFlow v5 and sFlow have no templates.
+		return
+	}
+
 	if o.isReachedMaxTempRecordsToSend() {
 		// Max tx template records reached - stop sending template records.
 		return
@@ -520,9 +571,10 @@ func (o *IPFixGen) sendDataPktInt() {
 		o.ipfixPlug.stats.recordsDataSent += uint64(records)
 
 		// updating the flow sequence number must be inside the loop because fixPayload uses the value.
-		if ipfixVer == 9 {
+		// sFlow counts datagrams like v9, NetFlow v5 counts flows like IPFIX.
+		if ipfixVer == 9 || o.ipfixPlug.format == formatSFlowV5 {
 			o.ipfixPlug.flowSeqNum++
-		} else if ipfixVer == 10 {
+		} else if ipfixVer == 10 || ipfixVer == 5 {
 			o.ipfixPlug.flowSeqNum += records
 		}
 		if o.recordsNum > records && !o.isReachedMaxDataRecordsToSend() {
@@ -562,6 +614,11 @@ func (o *IPFixGen) sendDataPkt() {
 
 // fixPayload makes the differential fixes in each packet, like FlowSeq, Timestamp, Length, etc.
 func (o *IPFixGen) fixPayload(pkt []byte) {
+	if o.legacy != nil {
+		o.ipfixPlug.fixLegacyPayload(pkt)
+		return
+	}
+
 	ipfixVer := o.ipfixPlug.ver
 	ipFixHeader := layers.IPFixHeader(pkt)
 
@@ -644,6 +701,11 @@ func (o *IPFixGen) getDataTemplateSets() layers.IPFixSets {
 // This packet teaches the controller how to read the data packets. Template packets can be Data-Templates
 // or Option Templates.
 func (o *IPFixGen) prepareTemplatePayload() bool {
+	if o.legacy != nil {
+		// NetFlow v5 and sFlow have no templates.
+		return true
+	}
+
 	ipfixPlug := o.ipfixPlug
 	var sets layers.IPFixSets
 	if o.optionsTemplate {
@@ -739,6 +801,9 @@ func (o *IPFixGen) getDataRecord() (data []byte) {
 		// Pre calculated data buffer is enough.
 		return o.dataBuffer
 	}
+	if o.engineOrder != nil {
+		return o.getOrderedDataRecord()
+	}
 	currentOffset := 0
 	for _, field := range o.fields {
 		if eng, ok := o.engineMap[field.Name]; ok {
@@ -761,6 +826,41 @@ func (o *IPFixGen) getDataRecord() (data []byte) {
 	return data
 }
 
+// getOrderedDataRecord is getDataRecord for engines that reference other fields. It runs the engines in
+// dependency order and then builds the record in the order of the fields.
+func (o *IPFixGen) getOrderedDataRecord() (data []byte) {
+	offsets := make([]int, len(o.fields))
+	currentOffset := 0
+	for i, field := range o.fields {
+		offsets[i] = currentOffset
+		if !field.isVariableLength() {
+			currentOffset += int(field.Length)
+		}
+	}
+
+	varLengthData := make(map[int][]byte)
+	for _, i := range o.engineOrder {
+		eng := o.engineMap[o.fields[i].Name]
+		if o.fields[i].isVariableLength() {
+			buffer := make([]byte, eng.GetSize())
+			length, _ := eng.Update(buffer)
+			varLengthData[i] = o.encodeVarLengthData(length, buffer)
+		} else {
+			b := o.dataBuffer[offsets[i]:]
+			eng.Update(b[eng.GetOffset() : eng.GetOffset()+eng.GetSize()])
+		}
+	}
+
+	for i, field := range o.fields {
+		if field.isVariableLength() {
+			data = append(data, varLengthData[i]...)
+		} else {
+			data = append(data, o.dataBuffer[offsets[i]:offsets[i]+int(field.Length)]...)
+		}
+	}
+	return data
+}
+
 // getDataSets creates the Sets for the Data outgoing packet.
 func (o *IPFixGen) getDataSets() (layers.IPFixSets, uint32) {
 	var setEntries layers.IPFixSetEntries
@@ -795,6 +895,10 @@ func (o *IPFixGen) getDataSets() (layers.IPFixSets, uint32) {
 // L7 to the base packet created the IPFix Client Plugin.
 // Returns the number of records it added to the data set.
 func (o *IPFixGen) prepareDataPayload() (records uint32) {
+	if o.legacy != nil {
+		return o.prepareLegacyDataPayload()
+	}
+
 	if o.dataPayload != nil {
 		// Clear the Data Payload as we are about to create a new one.
 		o.dataPayload = o.dataPayload[:0]
@@ -912,6 +1016,9 @@ type IPFixStats struct {
 	failedBuildingEngineMgr               uint64 // Failed Building Engine Manager with the provided JSON.
 	invalidEngineName                     uint64 // Invalid Engine Name. Engine name must be a field name.
 	invalidScopeCount                     uint64 // Invalid Scope Count, in case of Options Template user must specify a scope count > 0.
+	invalidVersion                        uint64 // Invalid NetFlow version or format.
+	invalidLegacyRecord                   uint64 // Generator fields don't fit the NetFlow v5 or sFlow v5 record.
+	failedCreatingReplay                  uint64 // Failed creating the replay of a recorded file.
 }
 
 // NewIPFixStatsDb creates a IPFixStats database.
@@ -1126,6 +1233,30 @@ func NewIPFixStatsDb(o *IPFixStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidVersion,
+		Name:     "invalidVersion",
+		Help:     "Invalid NetFlow version or format.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidLegacyRecord,
+		Name:     "invalidLegacyRecord",
+		Help:     "Generator fields don't fit the NetFlow v5 or sFlow v5 record.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.failedCreatingReplay,
+		Name:     "failedCreatingReplay",
+		Help:     "Failed creating the replay of a recorded file.",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -1135,7 +1266,8 @@ func NewIPFixStatsDb(o *IPFixStats) *core.CCounterDb {
 
 // IPFixClientParams defines the json structure for Ipfix plugin.
 type IPFixClientParams struct {
-	Ver            uint16                 `json:"netflow_version"`                // NetFlow version 9 or 10
+	Ver            uint16                 `json:"netflow_version"`                // NetFlow version 5, 9 or 10
+	Format         string                 `json:"format"`                         // Export format, netflow (default) or sflow
 	Dst            string                 `json:"dst" validate:"required"`        // Destination Address. Combination of Host:Port.
 	DomainID       uint32                 `json:"domain_id"`                      // Observation Domain ID
 	MaxDataRecords uint64                 `json:"max_data_records"`               // Max number of data records to send (0 - no limit)
@@ -1144,6 +1276,7 @@ type IPFixClientParams struct {
 	AutoStart      bool                   `json:"auto_start"`                     // Start exporting this client when plugin is loaded (default: true)
 	ExporterParams *fastjson.RawMessage   `json:"exporter_params"`                // Exporter parameters
 	Generators     []*fastjson.RawMessage `json:"generators" validate:"required"` // Ipfix Generators (Template or Data)
+	Replay         *fastjson.RawMessage   `json:"replay"`                         // Replay of a recorded file
 }
 
 // IPFixTimerCallback is an empty struct used as a callback for the timer which resolves the UnixTime.
@@ -1155,7 +1288,8 @@ type IPFixTimerCallback struct{}
 // Each IPFixGen is an exporting process.
 type PluginIPFixClient struct {
 	core.PluginBase                               // Plugin Base
-	ver             uint16                        // NetFlow version 9 or 10
+	ver             uint16                        // NetFlow version 5, 9 or 10, 5 for sFlow as well
+	format          exportFormat                  // Export format, IPFIX, NetFlow v5 or sFlow v5
 	dstUrl          url.URL                       // Destination URL.
 	isIpv6          bool                          // Is destination address IPv6 or IPv4 address
 	sysStartTime    time.Time                     // Start time of the system in order to calculate uptime.
@@ -1175,6 +1309,7 @@ type PluginIPFixClient struct {
 	cdbv            *core.CCounterDbVec           // Counters Database Vector
 	generators      []*IPFixGen                   // List of Generators
 	generatorsMap   map[string]*IPFixGen          // Generator Map for fast lookup with generator name.
+	replay          *IPFixReplay                  // Replay of a recorded file, nil if none
 	templateIDSet   map[uint16]bool               // Set of Template IDs.
 	exporter        Exporter                      // Factory class to create and store exporters based on the given dst URL
 	init            bool                          // Is client initialization succeeded
@@ -1184,11 +1319,15 @@ type PluginIPFixClient struct {
 	trgDeviceInfo   *DevicesAutoTriggerDeviceInfo // Auto triggered device info
 }
 
+func (o *PluginIPFixClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 var ipfixEvents = []string{core.MSG_DG_MAC_RESOLVED}
 
 func isSupportedUrlScheme(scheme string) bool {
 	switch scheme {
-	case "emu-udp", "udp", "file", "http", "https":
+	case "emu-udp", "udp", "emu-tcp", "tcp", "file", "http", "https":
 		return true
 	default:
 	}
@@ -1237,7 +1376,7 @@ func parseDstField(dstField string) (*url.URL, bool, error) {
 	}
 
 	if !isSupportedUrlScheme(dstUrl.Scheme) {
-		return nil, false, fmt.Errorf("Invalid dst URL scheme '%s' in init JSON (should be emu-udp, udp, file, http or https)", dstUrl.Scheme)
+		return nil, false, fmt.Errorf("Invalid dst URL scheme '%s' in init JSON (should be emu-udp, udp, emu-tcp, tcp, file, http or https)", dstUrl.Scheme)
 	}
 
 	// If host is 'localhost' replace it with '127.0.0.1' (in some cases using localhost will not work)
@@ -1342,7 +1481,13 @@ func NewIPFixClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 		return nil, err
 	}
 
-	o.ver = init.Ver
+	o.format, o.ver, err = getExportFormat(init.Format, init.Ver)
+	if err != nil {
+		log.Error("Failed to parse IPFIX client init JSON version, err: ", err)
+		o.stats.invalidVersion++
+		return nil, err
+	}
+
 	o.dstUrl = *dstUrl
 	o.isIpv6 = isIpv6
 	o.domainID = init.DomainID
@@ -1383,6 +1528,16 @@ func NewIPFixClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 		o.updateGenDataRate()
 	}
 
+	if init.Replay != nil {
+		o.replay, err = NewIPFixReplay(o, init.Replay)
+		if err != nil {
+			log.Error("Failed to create replay, err: ", err)
+			o.stats.failedCreatingReplay++
+			return nil, err
+		}
+		o.cdbv.Add(o.replay.cdb)
+	}
+
 	if o.exporter.GetKernelMode() {
 		o.RegisterEvents(ctx, []string{}, o)
 		o.OnResolve()
@@ -1399,6 +1554,29 @@ func (o *PluginIPFixClient) Pause(pause bool) {
 	for _, gen := range o.generators {
 		gen.Pause(pause)
 	}
+
+	if o.replay != nil {
+		o.replay.Pause(pause)
+	}
+}
+
+// resendTemplates announces the templates of the enabled generators again, e.g. when the exporter
+// connects to the collector. Returns the number of template packets sent.
+func (o *PluginIPFixClient) resendTemplates() int {
+	var n int
+
+	if !o.enabled {
+		return 0
+	}
+
+	for _, gen := range o.generators {
+		if gen.enabled && !gen.isReachedMaxTempRecordsToSend() {
+			gen.sendTemplatePktInt()
+			n++
+		}
+	}
+
+	return n
 }
 
 func (o *PluginIPFixClient) Enable(enable bool) {
@@ -1414,6 +1592,10 @@ func (o *PluginIPFixClient) Enable(enable bool) {
 			gen.sendTemplatePkt()
 			gen.sendDataPkt()
 		}
+
+		if o.replay != nil {
+			o.replay.start()
+		}
 	} else if o.enabled == true && enable == false {
 		if o.exporter != nil {
 			o.exporter.Enable(enable)
@@ -1430,8 +1612,8 @@ func (o *PluginIPFixClient) OnCreate() {
 	// Domain ID will be randomly picked in case it is not provided.
 	// Flow sequence number common to all generators is randomized.
 	if !Simulation {
-		o.domainID = rand.Uint32()
-		o.flowSeqNum = rand.Uint32()
+		o.domainID = o.Ns.Rand().Uint32()
+		o.flowSeqNum = o.Ns.Rand().Uint32()
 	} else {
 		o.domainID = 0x87654321
 		o.flowSeqNum = 0x12345678
@@ -1485,6 +1667,10 @@ func (o *PluginIPFixClient) OnRemove(ctx *core.PluginCtx) {
 		gen.OnRemove()
 	}
 
+	if o.replay != nil {
+		o.replay.OnRemove()
+	}
+
 	if o.exporter != nil {
 		o.exporter.Close()
 		o.exporter = nil
@@ -1571,6 +1757,10 @@ type IpfixNsPlugin struct {
 	devicesAutoTrigger *DevicesAutoTrigger
 }
 
+func (o *IpfixNsPlugin) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // NewIpfixNsStatsDb creates a new counter database for IpfixNsStats.
 func NewIpfixNsStatsDb(p *IpfixNsStats) *core.CCounterDb {
 	db := core.NewCCounterDb(IPFIX_PLUG)
@@ -0,0 +1,368 @@
+package ipfix
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"errors"
+	"strconv"
+	"time"
+)
+
+type EmuTcpExporterStats struct {
+	apiWrites             uint64
+	apiWritesFailed       uint64
+	txBytes               uint64
+	txTempRecords         uint64
+	txDataRecords         uint64
+	txTempResent          uint64
+	txDroppedDisconnected uint64
+	txQueueFull           uint64
+	connects              uint64
+	connectsFailed        uint64
+	disconnects           uint64
+}
+
+// EmuTcpExporter exports IPFIX messages over a TCP connection of emu's transport layer. See
+// ipfix_tcp_exporter.go for the framing and the reconnect behavior.
+type EmuTcpExporter struct {
+	params       TcpExporterParams
+	client       *PluginIPFixClient
+	transportCtx *transport.TransportCtx
+	socket       transport.SocketApi
+	mtu          int
+	connected    bool          // Socket is connected, messages can be written
+	draining     bool          // Socket queue is full, the client is paused until SocketTxMore
+	closing      bool          // Close was called, don't reconnect
+	backoff      time.Duration // Time to wait before the next reconnect
+	timer        core.CHTimerObj
+	timerw       *core.TimerCtx
+	counters     EmuTcpExporterStats
+	countersDb   *core.CCounterDb
+	init         bool
+	enabled      bool
+}
+
+type EmuTcpExporterInfoJson struct {
+	ExporterType string `json:"exporter_type"`
+	Enabled      string `json:"enabled"`
+	Connected    string `json:"connected"`
+}
+
+const (
+	emuTcpExporterType           = "emu-tcp"
+	emuTcpExporterCountersDbName = "IPFIX emu-tcp exporter"
+)
+
+var (
+	ErrExporterDisconnected error = errors.New("Failed to write - exporter is not connected")
+	ErrExporterQueueFull    error = errors.New("Failed to write - exporter socket queue is full")
+)
+
+func NewEmuTcpExporter(client *PluginIPFixClient, params *TcpExporterParams) (*EmuTcpExporter, error) {
+	if err := validateTcpExporterParams(params); err != nil {
+		return nil, err
+	}
+
+	transportCtx := transport.GetTransportCtx(client.Client)
+	if transportCtx == nil {
+		return nil, errors.New("Failed to get client's transport layer")
+	}
+
+	p := new(EmuTcpExporter)
+
+	kernelMode := client.Tctx.GetKernelMode()
+	if kernelMode != p.GetKernelMode() {
+		return nil, ErrExporterWrongKernelMode
+	}
+
+	p.params = *params
+	p.client = client
+	p.transportCtx = transportCtx
+	p.backoff = params.ReconnectInitial.Duration
+	p.timerw = client.Tctx.GetTimerCtx()
+	p.timer.SetCB(p, 0, 0)
+
+	p.newEmuTcpExporterCountersDb()
+
+	socket, err := transportCtx.Dial("tcp", params.hostport, p, nil, nil, 0)
+	if err != nil {
+		return nil, errors.New("Failed to create emu-tcp socket")
+	}
+
+	p.socket = socket
+	p.mtu = int(socket.GetL7MTU())
+	p.enabled = true
+	p.init = true
+
+	log.Info("\nIPFIX EMU-TCP exporter created with the following parameters: ",
+		"\n\thostport - ", params.hostport,
+		"\n\treconnect_initial - ", params.ReconnectInitial.Duration,
+		"\n\treconnect_max - ", params.ReconnectMax.Duration)
+
+	return p, nil
+}
+
+func (p *EmuTcpExporter) newEmuTcpExporterCountersDb() {
+	p.countersDb = core.NewCCounterDb(emuTcpExporterCountersDbName)
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.apiWrites,
+		Name:     "apiWrites",
+		Help:     "Num of API calls to write",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.apiWritesFailed,
+		Name:     "apiWritesFailed",
+		Help:     "Num of failed API calls to write",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txBytes,
+		Name:     "txBytes",
+		Help:     "Num of bytes transmitted",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txTempRecords,
+		Name:     "txTempRecords",
+		Help:     "Num of template records transmitted",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txDataRecords,
+		Name:     "txDataRecords",
+		Help:     "Num of data records transmitted",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txTempResent,
+		Name:     "txTempResent",
+		Help:     "Num of template messages announced again after a reconnect",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txDroppedDisconnected,
+		Name:     "txDroppedDisconnected",
+		Help:     "Num of messages dropped while not connected",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txQueueFull,
+		Name:     "txQueueFull",
+		Help:     "Num of times the socket queue was full and the client was paused",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.connects,
+		Name:     "connects",
+		Help:     "Num of established connections",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.connectsFailed,
+		Name:     "connectsFailed",
+		Help:     "Num of failed connect attempts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.disconnects,
+		Name:     "disconnects",
+		Help:     "Num of connections lost",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+}
+
+// dial starts a new connection, in case of an error another attempt is scheduled.
+func (p *EmuTcpExporter) dial() {
+	socket, err := p.transportCtx.Dial("tcp", p.params.hostport, p, nil, nil, 0)
+	if err != nil {
+		p.counters.connectsFailed++
+		p.scheduleReconnect()
+		return
+	}
+	p.socket = socket
+}
+
+// scheduleReconnect starts the reconnect timer and doubles the backoff.
+func (p *EmuTcpExporter) scheduleReconnect() {
+	if p.closing {
+		return
+	}
+	p.timerw.Start(&p.timer, p.backoff)
+	p.backoff = nextReconnectBackoff(p.backoff, p.params.ReconnectMax.Duration)
+}
+
+// OnEvent is called by the reconnect timer.
+func (p *EmuTcpExporter) OnEvent(a, b interface{}) {
+	p.dial()
+}
+
+// OnRxEvent handles the connection events of the socket.
+func (p *EmuTcpExporter) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketEventConnected > 0 {
+		p.connected = true
+		p.counters.connects++
+		p.backoff = p.params.ReconnectInitial.Duration
+		// The collector forgets the templates of a closed session, and templates written before the
+		// connection was established were dropped.
+		p.counters.txTempResent += uint64(p.client.resendTemplates())
+	}
+
+	if event&transport.SocketRemoteDisconnect > 0 && p.socket != nil {
+		p.socket.Close()
+	}
+
+	if event&transport.SocketClosed > 0 {
+		p.socket = nil
+		if p.closing {
+			return
+		}
+		if p.connected {
+			p.counters.disconnects++
+		} else {
+			p.counters.connectsFailed++
+		}
+		p.connected = false
+		if p.draining {
+			p.draining = false
+			p.client.Pause(false)
+		}
+		p.scheduleReconnect()
+	}
+}
+
+// OnRxData function to complete the ISocketCb interface.
+func (p *EmuTcpExporter) OnRxData(d []byte) {
+	// no rx expected in IPFix
+}
+
+// OnTxEvent resumes the client once the socket queue has room.
+func (p *EmuTcpExporter) OnTxEvent(event transport.SocketEventType) {
+	if event&transport.SocketTxMore > 0 && p.draining {
+		p.draining = false
+		p.client.Pause(false)
+	}
+}
+
+func (p *EmuTcpExporter) Write(b []byte, tempRecordsNum uint32, dataRecordsNum uint32) (int, error) {
+	if p.init == false {
+		return 0, errors.New("Failed to write - emu-tcp exporter object is uninitialized")
+	}
+
+	if p.enabled == false {
+		return 0, nil
+	}
+
+	p.counters.apiWrites++
+
+	if !p.connected {
+		p.counters.txDroppedDisconnected++
+		return 0, ErrExporterDisconnected
+	}
+
+	if p.draining {
+		p.counters.apiWritesFailed++
+		return 0, ErrExporterQueueFull
+	}
+
+	// The socket keeps the tail of the buffer in case it is not queued entirely, and the generator
+	// reuses its buffer. The whole message is always accepted, so the framing is kept.
+	serr, queued := p.socket.Write(append([]byte(nil), b...))
+	if serr != transport.SeOK {
+		p.counters.apiWritesFailed++
+		return 0, serr.Error()
+	}
+
+	if !queued {
+		p.draining = true
+		p.counters.txQueueFull++
+		p.client.Pause(true)
+	}
+
+	p.counters.txBytes += uint64(len(b))
+	p.counters.txTempRecords += uint64(tempRecordsNum)
+	p.counters.txDataRecords += uint64(dataRecordsNum)
+
+	return len(b), nil
+}
+
+func (p *EmuTcpExporter) Close() error {
+	if p.init == false {
+		return nil
+	}
+
+	p.closing = true
+	if p.timer.IsRunning() {
+		p.timerw.Stop(&p.timer)
+	}
+
+	if p.socket != nil {
+		err := p.socket.Close()
+		if err != transport.SeOK {
+			return err.Error()
+		}
+	}
+
+	p.init = false
+
+	return nil
+}
+
+func (p *EmuTcpExporter) Enable(enable bool) error {
+	p.enabled = enable
+	return nil
+}
+
+func (p *EmuTcpExporter) GetMaxSize() int {
+	if p.init == false {
+		return 0
+	}
+
+	return p.mtu
+}
+
+func (p *EmuTcpExporter) GetType() string {
+	return emuTcpExporterType
+}
+
+func (p *EmuTcpExporter) GetCountersDbVec() *core.CCounterDbVec {
+	db := core.NewCCounterDbVec(emuTcpExporterCountersDbName)
+	db.Add(p.countersDb)
+	return db
+}
+
+func (p *EmuTcpExporter) GetKernelMode() bool {
+	return false
+}
+
+func (p *EmuTcpExporter) GetInfoJson() interface{} {
+	var res EmuTcpExporterInfoJson
+
+	res.ExporterType = p.GetType()
+	res.Enabled = strconv.FormatBool(p.enabled)
+	res.Connected = strconv.FormatBool(p.connected)
+
+	return &res
+}
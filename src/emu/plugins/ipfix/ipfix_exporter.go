@@ -60,6 +60,8 @@ type exporterCreatorFunc func(client *PluginIPFixClient, dstUrl *url.URL, initJs
 var exporterCreators = map[string]exporterCreatorFunc{
 	"emu-udp": createEmuUdpExporter,
 	"udp":     createUdpExporter,
+	"emu-tcp": createEmuTcpExporter,
+	"tcp":     createTcpExporter,
 	"file":    createFileExporter,
 	"http":    createHttpExporter,
 	"https":   createHttpExporter}
@@ -102,6 +104,64 @@ func createUdpExporter(client *PluginIPFixClient, dstUrl *url.URL, initJson *fas
 	return udpExporter, nil
 }
 
+// newTcpExporterParams returns the params of the TCP exporters, IPFIX only as v9 messages can't be framed.
+func newTcpExporterParams(client *PluginIPFixClient, dstUrl *url.URL, initJson *fastjson.RawMessage) (*TcpExporterParams, error) {
+	if client.ver != 10 {
+		return nil, errors.New("TCP exporters support only IPFIX (netflow_version 10)")
+	}
+
+	params := &TcpExporterParams{
+		ReconnectInitial: Duration{Duration: defaultTcpExporterReconnectInitial},
+		ReconnectMax:     Duration{Duration: defaultTcpExporterReconnectMax},
+		hostport:         dstUrl.Host,
+	}
+
+	if initJson != nil {
+		err := client.Tctx.UnmarshalValidate(*initJson, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+func createEmuTcpExporter(client *PluginIPFixClient, dstUrl *url.URL, initJson *fastjson.RawMessage) (Exporter, error) {
+	if dstUrl.Scheme != "emu-tcp" {
+		return nil, errors.New("Invalid dst URL scheme used to create emu-tcp exporter (should be emu-tcp)")
+	}
+
+	params, err := newTcpExporterParams(client, dstUrl, initJson)
+	if err != nil {
+		return nil, err
+	}
+
+	emuTcpExporter, err := NewEmuTcpExporter(client, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return emuTcpExporter, nil
+}
+
+func createTcpExporter(client *PluginIPFixClient, dstUrl *url.URL, initJson *fastjson.RawMessage) (Exporter, error) {
+	if dstUrl.Scheme != "tcp" {
+		return nil, errors.New("Invalid dst URL scheme used to create tcp exporter (should be tcp)")
+	}
+
+	params, err := newTcpExporterParams(client, dstUrl, initJson)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpExporter, err := NewTcpExporter(client, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return tcpExporter, nil
+}
+
 func createFileExporter(client *PluginIPFixClient, dstUrl *url.URL, initJson *fastjson.RawMessage) (Exporter, error) {
 	if dstUrl.Scheme != "file" {
 		return nil, errors.New("Invalid dst URL scheme used to create file exporter (should be file)")
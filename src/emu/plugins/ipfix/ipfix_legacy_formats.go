@@ -0,0 +1,339 @@
+package ipfix
+
+/*
+NetFlow v5 and sFlow v5 export formats.
+
+Both formats have fixed records and no templates, hence the generator fields are placed in slots of the fixed
+record. A field is matched to a slot by its name, the IPFIX information element name where one exists, e.g.
+sourceIPv4Address, or the sFlow counter name otherwise, e.g. ifInOctets. A field shorter than its slot is zero
+extended, so a one byte protocolIdentifier fills the 4 bytes protocol of sFlow. Slots without a field are zero.
+
+	NetFlow v5 - a header of 24 bytes and up to 30 flow records of 48 bytes.
+	sFlow v5   - a datagram header followed by samples, a flow sample with a sampled IPv4 record or a counter
+	             sample with a generic interface counters record (https://sflow.org/sflow_version_5.txt).
+
+The field engines update the field values as usual, the rate control and exporters are the same as IPFIX.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type exportFormat int
+
+const (
+	formatIPFix     exportFormat = iota // NetFlow v9 or IPFIX, template based
+	formatNetflowV5                     // NetFlow v5, fixed records
+	formatSFlowV5                       // sFlow v5, flow and counter samples
+)
+
+const (
+	netflowV5HeaderLen       = 24
+	netflowV5RecordLen       = 48
+	netflowV5MaxRecords      = 30
+	sflowV5HeaderLenIpv4     = 28
+	sflowV5HeaderLenIpv6     = 40
+	sflowSampleHeaderLen     = 8 // data format and length
+	sflowFlowSampleFormat    = 1
+	sflowCounterSampleFormat = 2
+	sflowSampledIpv4Format   = 3
+	sflowGenericIfFormat     = 1
+	sflowFlowSampleLen       = 72  // flow sample with a single sampled IPv4 record
+	sflowCounterSampleLen    = 108 // counter sample with a single generic interface counters record
+	sflowSampleFlow          = "flow"
+	sflowSampleCounter       = "counter"
+)
+
+// recordSlot is a field of a fixed record.
+type recordSlot struct {
+	name   string // IPFIX information element name, or the sFlow name if there is no such element
+	ie     uint16 // IPFIX information element ID, 0 if none
+	offset int    // Offset in the record
+	length int    // Length in the record
+}
+
+// netflowV5RecordSlots is the NetFlow v5 flow record.
+var netflowV5RecordSlots = []recordSlot{
+	{"sourceIPv4Address", 8, 0, 4},
+	{"destinationIPv4Address", 12, 4, 4},
+	{"ipNextHopIPv4Address", 15, 8, 4},
+	{"ingressInterface", 10, 12, 2},
+	{"egressInterface", 14, 14, 2},
+	{"packetDeltaCount", 2, 16, 4},
+	{"octetDeltaCount", 1, 20, 4},
+	{"flowStartSysUpTime", 22, 24, 4},
+	{"flowEndSysUpTime", 21, 28, 4},
+	{"sourceTransportPort", 7, 32, 2},
+	{"destinationTransportPort", 11, 34, 2},
+	{"tcpControlBits", 6, 37, 1},
+	{"protocolIdentifier", 4, 38, 1},
+	{"ipClassOfService", 5, 39, 1},
+	{"bgpSourceAsNumber", 16, 40, 2},
+	{"bgpDestinationAsNumber", 17, 42, 2},
+	{"sourceIPv4PrefixLength", 9, 44, 1},
+	{"destinationIPv4PrefixLength", 13, 45, 1},
+}
+
+// sflowFlowSampleSlots is the body of a flow sample with a single sampled IPv4 record. The sequence number,
+// source ID, sample pool and record header are filled by the generator.
+var sflowFlowSampleSlots = []recordSlot{
+	{"samplingInterval", 34, 8, 4},
+	{"drops", 0, 16, 4},
+	{"ingressInterface", 10, 20, 4},
+	{"egressInterface", 14, 24, 4},
+	{"ipTotalLength", 224, 40, 4},
+	{"protocolIdentifier", 4, 44, 4},
+	{"sourceIPv4Address", 8, 48, 4},
+	{"destinationIPv4Address", 12, 52, 4},
+	{"sourceTransportPort", 7, 56, 4},
+	{"destinationTransportPort", 11, 60, 4},
+	{"tcpControlBits", 6, 64, 4},
+	{"ipClassOfService", 5, 68, 4},
+}
+
+// sflowCounterSampleSlots is the body of a counter sample with a single generic interface counters record.
+// The sequence number, source ID and record header are filled by the generator.
+var sflowCounterSampleSlots = []recordSlot{
+	{"ifIndex", 0, 20, 4},
+	{"ifType", 0, 24, 4},
+	{"ifSpeed", 0, 28, 8},
+	{"ifDirection", 0, 36, 4},
+	{"ifStatus", 0, 40, 4},
+	{"ifInOctets", 0, 44, 8},
+	{"ifInUcastPkts", 0, 52, 4},
+	{"ifInMulticastPkts", 0, 56, 4},
+	{"ifInBroadcastPkts", 0, 60, 4},
+	{"ifInDiscards", 0, 64, 4},
+	{"ifInErrors", 0, 68, 4},
+	{"ifInUnknownProtos", 0, 72, 4},
+	{"ifOutOctets", 0, 76, 8},
+	{"ifOutUcastPkts", 0, 84, 4},
+	{"ifOutMulticastPkts", 0, 88, 4},
+	{"ifOutBroadcastPkts", 0, 92, 4},
+	{"ifOutDiscards", 0, 96, 4},
+	{"ifOutErrors", 0, 100, 4},
+	{"ifPromiscuousMode", 0, 104, 4},
+}
+
+// String returns the name of the format.
+func (f exportFormat) String() string {
+	switch f {
+	case formatNetflowV5:
+		return "NetFlow v5"
+	case formatSFlowV5:
+		return "sFlow v5"
+	}
+	return "IPFIX"
+}
+
+// hasTemplates returns true if the format sends templates.
+func (f exportFormat) hasTemplates() bool {
+	return f == formatIPFix
+}
+
+// getExportFormat returns the export format of the init JSON format and version.
+func getExportFormat(format string, ver uint16) (exportFormat, uint16, error) {
+	switch format {
+	case "", "netflow":
+		switch ver {
+		case 5:
+			return formatNetflowV5, ver, nil
+		case 9, 10:
+			return formatIPFix, ver, nil
+		}
+		return formatIPFix, ver, fmt.Errorf("Invalid netflow_version %d (should be 5, 9 or 10)", ver)
+	case "sflow":
+		return formatSFlowV5, 5, nil
+	}
+	return formatIPFix, ver, fmt.Errorf("Invalid format '%s' (should be netflow or sflow)", format)
+}
+
+// legacyRecord is the fixed record of a NetFlow v5 or sFlow v5 generator.
+type legacyRecord struct {
+	slots      []*recordSlot // Slot of each generator field, in field order
+	length     int           // Length of a record
+	sample     string        // sFlow sample type, flow or counter
+	sampleSeq  uint32        // sFlow sample sequence number
+	samplePool uint32        // sFlow flow sample pool, total packets that could have been sampled
+}
+
+// newLegacyRecord matches the generator fields to the slots of the record of format.
+func newLegacyRecord(format exportFormat, sample string, fields []*IPFixField) (*legacyRecord, error) {
+	o := new(legacyRecord)
+	var slots []recordSlot
+	switch format {
+	case formatNetflowV5:
+		slots = netflowV5RecordSlots
+		o.length = netflowV5RecordLen
+	case formatSFlowV5:
+		switch sample {
+		case "", sflowSampleFlow:
+			slots = sflowFlowSampleSlots
+			o.length = sflowSampleHeaderLen + sflowFlowSampleLen
+			o.sample = sflowSampleFlow
+		case sflowSampleCounter:
+			slots = sflowCounterSampleSlots
+			o.length = sflowSampleHeaderLen + sflowCounterSampleLen
+			o.sample = sflowSampleCounter
+		default:
+			return nil, fmt.Errorf("invalid sflow_sample '%s' (should be flow or counter)", sample)
+		}
+	}
+
+	o.slots = make([]*recordSlot, len(fields))
+	used := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		for j := range slots {
+			if slots[j].name == field.Name {
+				o.slots[i] = &slots[j]
+				break
+			}
+		}
+		slot := o.slots[i]
+		if slot == nil {
+			return nil, fmt.Errorf("field %s is not part of the %s record", field.Name, format)
+		}
+		if slot.ie != 0 && (field.Type != slot.ie || field.isEnterprise()) {
+			return nil, fmt.Errorf("field %s should be of type %d in the %s record", field.Name, slot.ie, format)
+		}
+		if field.isVariableLength() || int(field.Length) > slot.length {
+			return nil, fmt.Errorf("field %s length %d exceeds %d bytes of the %s record", field.Name, field.Length, slot.length, format)
+		}
+		if used[field.Name] {
+			return nil, fmt.Errorf("duplicate field %s in the %s record", field.Name, format)
+		}
+		used[field.Name] = true
+	}
+	return o, nil
+}
+
+// build writes a record of the field values in data, in field order, to b of o.length bytes.
+func (o *legacyRecord) build(b []byte, fields []*IPFixField, data []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	body := b
+	if o.sample != "" {
+		body = b[sflowSampleHeaderLen:]
+	}
+	off := 0
+	for i, field := range fields {
+		slot := o.slots[i]
+		length := int(field.Length)
+		// zero extend, the values are big endian
+		copy(body[slot.offset+slot.length-length:slot.offset+slot.length], data[off:off+length])
+		off += length
+	}
+
+	switch o.sample {
+	case sflowSampleFlow:
+		o.sampleSeq++
+		binary.BigEndian.PutUint32(b[0:4], sflowFlowSampleFormat)
+		binary.BigEndian.PutUint32(b[4:8], sflowFlowSampleLen)
+		samplingRate := binary.BigEndian.Uint32(body[8:12])
+		o.samplePool += samplingRate
+		binary.BigEndian.PutUint32(body[0:4], o.sampleSeq)
+		binary.BigEndian.PutUint32(body[4:8], binary.BigEndian.Uint32(body[20:24])&0xFFFFFF) // ifIndex of input
+		binary.BigEndian.PutUint32(body[12:16], o.samplePool)
+		binary.BigEndian.PutUint32(body[28:32], 1)
+		binary.BigEndian.PutUint32(body[32:36], sflowSampledIpv4Format)
+		binary.BigEndian.PutUint32(body[36:40], sflowFlowSampleLen-40)
+	case sflowSampleCounter:
+		o.sampleSeq++
+		binary.BigEndian.PutUint32(b[0:4], sflowCounterSampleFormat)
+		binary.BigEndian.PutUint32(b[4:8], sflowCounterSampleLen)
+		binary.BigEndian.PutUint32(body[0:4], o.sampleSeq)
+		binary.BigEndian.PutUint32(body[4:8], binary.BigEndian.Uint32(body[20:24])&0xFFFFFF) // ifIndex
+		binary.BigEndian.PutUint32(body[8:12], 1)
+		binary.BigEndian.PutUint32(body[12:16], sflowGenericIfFormat)
+		binary.BigEndian.PutUint32(body[16:20], sflowCounterSampleLen-20)
+	}
+}
+
+// getLegacyHeaderLen returns the header length of the format.
+func (o *PluginIPFixClient) getLegacyHeaderLen() int {
+	if o.format == formatNetflowV5 {
+		return netflowV5HeaderLen
+	}
+	if o.Client.Ipv4.IsZero() && !o.Client.Ipv6.IsZero() {
+		return sflowV5HeaderLenIpv6
+	}
+	return sflowV5HeaderLenIpv4
+}
+
+// buildLegacyHeader writes the header of a NetFlow v5 packet or sFlow v5 datagram with count records to b.
+// The sequence number and times are set by fixLegacyPayload.
+func (o *PluginIPFixClient) buildLegacyHeader(b []byte, count uint32) {
+	for i := range b {
+		b[i] = 0
+	}
+	if o.format == formatNetflowV5 {
+		binary.BigEndian.PutUint16(b[0:2], 5)
+		binary.BigEndian.PutUint16(b[2:4], uint16(count))
+		b[20] = uint8(o.domainID >> 8) // engine type
+		b[21] = uint8(o.domainID)      // engine ID
+		return
+	}
+	binary.BigEndian.PutUint32(b[0:4], 5)
+	var off int
+	if len(b) == sflowV5HeaderLenIpv6 {
+		binary.BigEndian.PutUint32(b[4:8], 2)
+		copy(b[8:24], o.Client.Ipv6[:])
+		off = 24
+	} else {
+		binary.BigEndian.PutUint32(b[4:8], 1)
+		copy(b[8:12], o.Client.Ipv4[:])
+		off = 12
+	}
+	binary.BigEndian.PutUint32(b[off:off+4], o.domainID) // sub agent ID
+	binary.BigEndian.PutUint32(b[off+12:off+16], count)
+}
+
+// fixLegacyPayload sets the sequence number and times of a NetFlow v5 packet or sFlow v5 datagram.
+func (o *PluginIPFixClient) fixLegacyPayload(b []byte) {
+	var upTime uint32
+	if !Simulation {
+		upTime = o.sysUpTime
+	}
+	if o.format == formatNetflowV5 {
+		binary.BigEndian.PutUint32(b[4:8], upTime)
+		if !Simulation {
+			binary.BigEndian.PutUint32(b[8:12], uint32(o.unixUtcTimeNow))
+		}
+		binary.BigEndian.PutUint32(b[16:20], o.flowSeqNum)
+		return
+	}
+	// the sequence number, uptime and num of samples end the header
+	off := sflowV5HeaderLenIpv4 - 12
+	if binary.BigEndian.Uint32(b[4:8]) == 2 {
+		off = sflowV5HeaderLenIpv6 - 12
+	}
+	binary.BigEndian.PutUint32(b[off:off+4], o.flowSeqNum)
+	binary.BigEndian.PutUint32(b[off+4:off+8], upTime)
+}
+
+// prepareLegacyDataPayload prepares a NetFlow v5 packet or sFlow v5 datagram of data records.
+// Returns the number of records it added.
+func (o *IPFixGen) prepareLegacyDataPayload() (records uint32) {
+	ipfixPlug := o.ipfixPlug
+	recordsNumToSend := o.recordsNumToSent
+	if ipfixPlug.stats.maxDataRecordsToSend > 0 && ipfixPlug.stats.maxDataRecordsToSend-ipfixPlug.stats.recordsDataSent < uint64(recordsNumToSend) {
+		recordsNumToSend = uint32(ipfixPlug.stats.maxDataRecordsToSend - ipfixPlug.stats.recordsDataSent)
+	}
+
+	headerLen := ipfixPlug.getLegacyHeaderLen()
+	length := headerLen + int(recordsNumToSend)*o.legacy.length
+	if cap(o.dataPayload) < length {
+		o.dataPayload = make([]byte, length)
+	}
+	o.dataPayload = o.dataPayload[:length]
+
+	for i := 0; i < int(recordsNumToSend); i++ {
+		off := headerLen + i*o.legacy.length
+		o.legacy.build(o.dataPayload[off:off+o.legacy.length], o.fields, o.getDataRecord())
+	}
+	ipfixPlug.buildLegacyHeader(o.dataPayload[:headerLen], recordsNumToSend)
+
+	return recordsNumToSend
+}
@@ -0,0 +1,572 @@
+package ipfix
+
+/*
+Replay of recorded NetFlow v9 and IPFIX exports.
+
+Instead of (or in addition to) generating records, a client can replay the export packets of a recording,
+for example to reproduce a burst of flows that a real exporter sent to a collector. The recording is a pcap or
+pcapng file with the exports as UDP payloads, or a file of IPFIX messages as written by the file exporter.
+Both may be gzip compressed.
+
+The messages are sent as recorded with the following header fields rewritten, so many clients can replay the
+same recording as different exporters:
+
+	Observation domain ID (source ID in v9) - the domain ID of the client.
+	Sequence number                          - the flow sequence number of the client, advanced by the data
+	                                           records of each message (IPFIX) or by one (v9).
+	Export time and system up time           - the time of the client.
+
+The messages keep the recorded timing, scaled by the rate multiplier. The time of a message is its capture
+time in pcap files and its export time in IPFIX files, which has a resolution of seconds.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"emu/core"
+	"emu/plugins/ipfix_decoder"
+	"encoding/binary"
+	"errors"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	replayCountersDbName = "IPFIX replay"
+)
+
+// IPFixReplayParams are the replay parameters of the client init JSON.
+type IPFixReplayParams struct {
+	File           string  `json:"file" validate:"required"` // pcap, pcapng or IPFIX file, may be gzip compressed
+	RateMultiplier float64 `json:"rate_multiplier"`          // Speed up (> 1) or slow down (< 1) the recorded timing
+	Loops          uint32  `json:"loops"`                    // Number of times to replay the file, 0 - forever
+	Port           uint16  `json:"port"`                     // UDP destination port of the exports in a pcap, 0 - any
+}
+
+// replayMsg is a recorded export message.
+type replayMsg struct {
+	ts          time.Duration // Offset from the first message
+	data        []byte        // The message
+	tempRecords uint32        // Number of template records in the message
+	dataRecords uint32        // Number of data records in the message
+}
+
+// replayFile is a loaded recording, shared by the clients replaying it.
+type replayFile struct {
+	key     string
+	refs    int
+	msgs    []replayMsg
+	skipped uint64 // Packets of the recording that are not exports of the client's version
+}
+
+var (
+	replayFilesMtx sync.Mutex
+	replayFiles    = make(map[string]*replayFile)
+)
+
+type IPFixReplayStats struct {
+	pktsSent        uint64 // Num of messages sent
+	tempRecordsSent uint64 // Num of template records sent
+	dataRecordsSent uint64 // Num of data records sent
+	writeError      uint64 // Num of messages the exporter failed to write
+	loops           uint64 // Num of completed replays of the file
+	pktsSkipped     uint64 // Num of packets of the file that are not exports of the client's version
+	msgsInFile      uint64 // Num of messages in the file
+}
+
+func newIPFixReplayStatsDb(o *IPFixReplayStats) *core.CCounterDb {
+	db := core.NewCCounterDb(replayCountersDbName)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktsSent,
+		Name:     "pktsSent",
+		Help:     "Num of replayed messages sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tempRecordsSent,
+		Name:     "tempRecordsSent",
+		Help:     "Num of replayed template records sent",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dataRecordsSent,
+		Name:     "dataRecordsSent",
+		Help:     "Num of replayed data records sent",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.writeError,
+		Name:     "writeError",
+		Help:     "Num of replayed messages the exporter failed to write",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.loops,
+		Name:     "loops",
+		Help:     "Num of completed replays of the file",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktsSkipped,
+		Name:     "pktsSkipped",
+		Help:     "Num of packets in the file that are not exports of the client's version",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.msgsInFile,
+		Name:     "msgsInFile",
+		Help:     "Num of messages in the file",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// IPFixReplay replays a recording on behalf of an IPFix client.
+type IPFixReplay struct {
+	ipfixPlug *PluginIPFixClient // The client that owns the replay
+	file      *replayFile        // The recording
+	offsets   []uint64           // Ticks of each message from the start of a loop
+	loops     uint32             // Number of loops to replay, 0 - forever
+	loop      uint32             // Current loop
+	next      int                // Index of the next message to send
+	startTick uint64             // Tick of the start of the current loop
+	paused    bool               // Replay is paused by the exporter
+	done      bool               // All the loops were replayed
+	buf       []byte             // Buffer for the rewritten message
+	timer     core.CHTimerObj
+	timerw    *core.TimerCtx
+	stats     IPFixReplayStats
+	cdb       *core.CCounterDb
+}
+
+// NewIPFixReplay creates the replay of a client from the replay parameters of the init JSON.
+func NewIPFixReplay(ipfixPlug *PluginIPFixClient, initJson *fastjson.RawMessage) (*IPFixReplay, error) {
+	init := IPFixReplayParams{RateMultiplier: 1, Loops: 1}
+	err := ipfixPlug.Tctx.UnmarshalValidateDisallowUnknownFields(*initJson, &init)
+	if err != nil {
+		return nil, err
+	}
+
+	if init.RateMultiplier <= 0 {
+		return nil, fmt.Errorf("invalid replay rate_multiplier %v, should be positive", init.RateMultiplier)
+	}
+
+	if !ipfixPlug.format.hasTemplates() {
+		return nil, fmt.Errorf("replay is not supported for %s", ipfixPlug.format)
+	}
+
+	file, err := acquireReplayFile(init.File, init.Port, ipfixPlug.ver)
+	if err != nil {
+		return nil, err
+	}
+
+	o := new(IPFixReplay)
+	o.ipfixPlug = ipfixPlug
+	o.file = file
+	o.loops = init.Loops
+	o.timerw = ipfixPlug.timerw
+	o.timer.SetCB(o, 0, 0)
+	o.offsets = make([]uint64, len(file.msgs))
+	for i := range file.msgs {
+		o.offsets[i] = uint64(o.timerw.DurationToTicks(time.Duration(float64(file.msgs[i].ts) / init.RateMultiplier)))
+	}
+	o.cdb = newIPFixReplayStatsDb(&o.stats)
+	o.stats.pktsSkipped = file.skipped
+	o.stats.msgsInFile = uint64(len(file.msgs))
+
+	return o, nil
+}
+
+// start starts the replay or resumes it from the next message.
+func (o *IPFixReplay) start() {
+	if o.done || o.paused || o.timer.IsRunning() {
+		return
+	}
+	// The next message is sent now and the following ones keep their relative timing.
+	o.startTick = o.timerw.Ticks - o.offsets[o.next]
+	o.sendDue()
+}
+
+// Pause pauses the replay while the exporter can't accept more messages.
+func (o *IPFixReplay) Pause(pause bool) {
+	o.paused = pause
+	if pause {
+		if o.timer.IsRunning() {
+			o.timerw.Stop(&o.timer)
+		}
+	} else if o.ipfixPlug.enabled && !o.done && !o.timer.IsRunning() {
+		// Called by the exporter, so the next message is sent on the next tick rather than now.
+		o.startTick = o.timerw.Ticks + 1 - o.offsets[o.next]
+		o.timerw.StartTicks(&o.timer, 1)
+	}
+}
+
+// OnEvent is called by the timer when the next message is due.
+func (o *IPFixReplay) OnEvent(a, b interface{}) {
+	if !o.ipfixPlug.enabled {
+		return
+	}
+
+	if o.ipfixPlug.maxTime > 0 {
+		if time.Since(o.ipfixPlug.enabledTime) >= o.ipfixPlug.maxTime {
+			o.ipfixPlug.Enable(false)
+			return
+		}
+	}
+
+	o.sendDue()
+}
+
+// OnRemove stops the replay and releases the recording.
+func (o *IPFixReplay) OnRemove() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	releaseReplayFile(o.file)
+}
+
+// sendDue sends the messages that are due and restarts the timer for the next one.
+func (o *IPFixReplay) sendDue() {
+	msgs := o.file.msgs
+	for !o.done {
+		for o.next < len(msgs) {
+			due := o.startTick + o.offsets[o.next]
+			if due > o.timerw.Ticks {
+				o.timerw.StartTicks(&o.timer, uint32(due-o.timerw.Ticks))
+				return
+			}
+			if o.isReachedMaxRecordsToSend() {
+				o.done = true
+				return
+			}
+			o.sendMsg(&msgs[o.next])
+			o.next++
+			if o.paused {
+				// The exporter is full, the replay resumes with the next message.
+				return
+			}
+		}
+
+		o.stats.loops++
+		o.loop++
+		o.next = 0
+		if o.loops > 0 && o.loop >= o.loops {
+			o.done = true
+			return
+		}
+		// The next loop starts on the next tick.
+		o.startTick = o.timerw.Ticks + 1
+	}
+}
+
+func (o *IPFixReplay) isReachedMaxRecordsToSend() bool {
+	stats := &o.ipfixPlug.stats
+	if stats.maxDataRecordsToSend > 0 && stats.recordsDataSent >= stats.maxDataRecordsToSend {
+		return true
+	}
+	return stats.maxTempRecordsToSend > 0 && stats.recordsTempSent >= stats.maxTempRecordsToSend
+}
+
+// sendMsg rewrites the header of a recorded message and writes it to the exporter.
+func (o *IPFixReplay) sendMsg(msg *replayMsg) {
+	ipfixPlug := o.ipfixPlug
+	o.buf = append(o.buf[:0], msg.data...)
+	ipFixHeader := layers.IPFixHeader(o.buf)
+
+	if ipfixPlug.ver == 9 {
+		ipFixHeader.SetSourceID(ipfixPlug.domainID)
+		if !Simulation {
+			ipFixHeader.SetSysUptime(ipfixPlug.sysUpTime)
+		} else {
+			ipFixHeader.SetSysUptime(0)
+		}
+	} else {
+		binary.BigEndian.PutUint32(o.buf[12:16], ipfixPlug.domainID)
+	}
+	ipFixHeader.SetFlowSeq(ipfixPlug.flowSeqNum)
+	if !Simulation {
+		ipFixHeader.SetTimestamp(uint32(ipfixPlug.unixUtcTimeNow))
+	}
+
+	_, err := ipfixPlug.exporter.Write(o.buf, msg.tempRecords, msg.dataRecords)
+	if err != nil {
+		o.stats.writeError++
+		ipfixPlug.stats.exporterWriteError++
+		return
+	}
+
+	o.stats.pktsSent++
+	o.stats.tempRecordsSent += uint64(msg.tempRecords)
+	o.stats.dataRecordsSent += uint64(msg.dataRecords)
+	ipfixPlug.stats.recordsTempSent += uint64(msg.tempRecords)
+	ipfixPlug.stats.recordsDataSent += uint64(msg.dataRecords)
+
+	if ipfixPlug.ver == 9 {
+		ipfixPlug.flowSeqNum++
+	} else {
+		ipfixPlug.flowSeqNum += msg.dataRecords
+	}
+}
+
+/*======================================================================================================
+										Recordings
+======================================================================================================*/
+
+// acquireReplayFile returns the loaded recording of path, loading it if no other client replays it.
+func acquireReplayFile(path string, port uint16, ver uint16) (*replayFile, error) {
+	key := fmt.Sprintf("%s|%d|%d", path, port, ver)
+
+	replayFilesMtx.Lock()
+	defer replayFilesMtx.Unlock()
+
+	if file, ok := replayFiles[key]; ok {
+		file.refs++
+		return file, nil
+	}
+
+	file, err := loadReplayFile(path, port, ver)
+	if err != nil {
+		return nil, err
+	}
+	file.key = key
+	file.refs = 1
+	replayFiles[key] = file
+
+	return file, nil
+}
+
+// releaseReplayFile releases the recording, which is freed when the last client releases it.
+func releaseReplayFile(file *replayFile) {
+	replayFilesMtx.Lock()
+	defer replayFilesMtx.Unlock()
+
+	file.refs--
+	if file.refs == 0 {
+		delete(replayFiles, file.key)
+	}
+}
+
+// packetDataSource reads the packets of pcap and pcapng files.
+type packetDataSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// loadReplayFile loads the messages of version ver in the recording.
+func loadReplayFile(path string, port uint16, ver uint16) (*replayFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		b, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+	}
+
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%s is not a pcap or IPFIX file", path)
+	}
+
+	var file *replayFile
+	switch binary.BigEndian.Uint32(b[0:4]) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		var r *pcapgo.Reader
+		if r, err = pcapgo.NewReader(bytes.NewReader(b)); err == nil {
+			file, err = loadReplayPcap(r, port, ver)
+		}
+	case 0x0a0d0d0a:
+		var r *pcapgo.NgReader
+		if r, err = pcapgo.NewNgReader(bytes.NewReader(b), pcapgo.DefaultNgReaderOptions); err == nil {
+			file, err = loadReplayPcap(r, port, ver)
+		}
+	default:
+		file, err = loadReplayIPFix(b, ver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+
+	if len(file.msgs) == 0 {
+		return nil, fmt.Errorf("%s has no NetFlow version %d exports", path, ver)
+	}
+
+	return file, nil
+}
+
+// loadReplayPcap loads the UDP payloads of the pcap that are exports of version ver.
+func loadReplayPcap(r packetDataSource, port uint16, ver uint16) (*replayFile, error) {
+	file := new(replayFile)
+	templates := make(replayTemplates)
+	var first, last time.Time
+
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		packet := gopacket.NewPacket(data, r.LinkType(), gopacket.Default)
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok || (port != 0 && uint16(udp.DstPort) != port) || !isExportMsg(udp.Payload, ver) {
+			file.skipped++
+			continue
+		}
+
+		if len(file.msgs) == 0 {
+			first = ci.Timestamp
+		}
+		// Keep the messages in order even if the capture times are not.
+		if ci.Timestamp.Before(last) {
+			ci.Timestamp = last
+		}
+		last = ci.Timestamp
+
+		source := packet.NetworkLayer().NetworkFlow().Src().String() + ":" + udp.SrcPort.String()
+		file.addMsg(ci.Timestamp.Sub(first), udp.Payload, source, templates)
+	}
+
+	return file, nil
+}
+
+// loadReplayIPFix loads a file of IPFIX messages, the file exporter format.
+func loadReplayIPFix(b []byte, ver uint16) (*replayFile, error) {
+	if binary.BigEndian.Uint16(b[0:2]) != 10 {
+		return nil, errors.New("not a pcap or IPFIX file, NetFlow v9 can be replayed from pcap only")
+	}
+	if ver != 10 {
+		return nil, fmt.Errorf("IPFIX file can't be replayed by a NetFlow version %d client", ver)
+	}
+
+	file := new(replayFile)
+	templates := make(replayTemplates)
+	var first, last uint32
+
+	for off := 0; off < len(b); {
+		if len(b)-off < layers.IpfixHeaderLenVer10 {
+			return nil, fmt.Errorf("truncated message at offset %d", off)
+		}
+		length := int(binary.BigEndian.Uint16(b[off+2 : off+4]))
+		if off+length > len(b) || !isExportMsg(b[off:off+length], 10) {
+			return nil, fmt.Errorf("malformed message at offset %d", off)
+		}
+		msg := b[off : off+length]
+
+		exportTime := binary.BigEndian.Uint32(msg[4:8])
+		if len(file.msgs) == 0 {
+			first = exportTime
+		}
+		if exportTime < last {
+			exportTime = last
+		}
+		last = exportTime
+
+		file.addMsg(time.Duration(exportTime-first)*time.Second, msg, "", templates)
+		off += length
+	}
+
+	return file, nil
+}
+
+// isExportMsg returns true if b is a message of version ver.
+func isExportMsg(b []byte, ver uint16) bool {
+	if ver == 9 {
+		return len(b) >= layers.IpfixHeaderLenVer9 && binary.BigEndian.Uint16(b[0:2]) == 9
+	}
+	return len(b) >= layers.IpfixHeaderLenVer10 && binary.BigEndian.Uint16(b[0:2]) == 10 &&
+		int(binary.BigEndian.Uint16(b[2:4])) == len(b)
+}
+
+// addMsg adds a copy of the message and counts its records.
+func (o *replayFile) addMsg(ts time.Duration, b []byte, source string, templates replayTemplates) {
+	msg := replayMsg{ts: ts, data: append([]byte(nil), b...)}
+	msg.tempRecords, msg.dataRecords = templates.countRecords(msg.data, source)
+	o.msgs = append(o.msgs, msg)
+}
+
+// replayTemplates maps the templates of the recording by exporter, domain and ID, to count the data records.
+type replayTemplates map[string]*ipfix_decoder.Template
+
+func (o replayTemplates) key(source string, domainID uint32, templateID uint16) string {
+	return fmt.Sprintf("%s|%d|%d", source, domainID, templateID)
+}
+
+// countRecords learns the templates of the message and counts its template and data records.
+func (o replayTemplates) countRecords(b []byte, source string) (tempRecords, dataRecords uint32) {
+	h, err := ipfix_decoder.DecodeHeader(b)
+	if err != nil {
+		return 0, 0
+	}
+	// The valid sets are counted anyway.
+	sets, _ := ipfix_decoder.DecodeSets(b[h.Len:])
+	for i := range sets {
+		switch {
+		case sets[i].IsTemplateSet(h.Ver):
+			tempRecords += o.learnTemplates(&sets[i], h.Ver, source, h.DomainID)
+		case sets[i].ID >= ipfix_decoder.MinDataSetID:
+			if t, ok := o[o.key(source, h.DomainID, sets[i].ID)]; ok {
+				dataRecords += t.CountRecords(sets[i].Body)
+			}
+		}
+	}
+
+	return tempRecords, dataRecords
+}
+
+// learnTemplates learns the (options) templates of a template set, and returns the number of template records.
+func (o replayTemplates) learnTemplates(set *ipfix_decoder.Set, ver uint16, source string, domainID uint32) (n uint32) {
+	templates, _ := ipfix_decoder.DecodeTemplates(set, ver)
+	for _, t := range templates {
+		n++
+		if !t.IsWithdrawal() {
+			o[o.key(source, domainID, t.ID)] = t
+			continue
+		}
+		if t.ID != set.ID {
+			delete(o, o.key(source, domainID, t.ID))
+			continue
+		}
+		// Withdrawal of all the templates of the set.
+		prefix := fmt.Sprintf("%s|%d|", source, domainID)
+		for key, old := range o {
+			if strings.HasPrefix(key, prefix) && old.Options == t.Options {
+				delete(o, key)
+			}
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,252 @@
+package ipfix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"emu/plugins/ipfix_decoder"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// replayTestMsg builds an IPFIX message with export time and sets.
+func replayTestMsg(exportTime uint32, seq uint32, sets ...[]byte) []byte {
+	b := make([]byte, layers.IpfixHeaderLenVer10)
+	binary.BigEndian.PutUint16(b[0:2], 10)
+	binary.BigEndian.PutUint32(b[4:8], exportTime)
+	binary.BigEndian.PutUint32(b[8:12], seq)
+	binary.BigEndian.PutUint32(b[12:16], 99)
+	for _, set := range sets {
+		b = append(b, set...)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// replayTestSet builds a set with id and body.
+func replayTestSet(id uint16, body ...byte) []byte {
+	b := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint16(b[0:2], id)
+	binary.BigEndian.PutUint16(b[2:4], uint16(4+len(body)))
+	return append(b, body...)
+}
+
+// replayTestMsgs returns a recording of a customer exporter. Template 300 has sourceIPv4Address,
+// octetDeltaCount and a variable length interfaceName.
+func replayTestMsgs(exportTimes []uint32) [][]byte {
+	template := replayTestSet(ipfix_decoder.TemplateSetIDVer10,
+		0x01, 0x2C, 0x00, 0x03,
+		0x00, 0x08, 0x00, 0x04,
+		0x00, 0x01, 0x00, 0x04,
+		0x00, 0x52, 0xFF, 0xFF)
+	record := func(ip byte, octets byte, name string) []byte {
+		return append([]byte{10, 0, 0, ip, 0, 0, 0, octets, byte(len(name))}, name...)
+	}
+	data := func(records ...[]byte) []byte {
+		return replayTestSet(300, bytes.Join(records, nil)...)
+	}
+
+	return [][]byte{
+		replayTestMsg(exportTimes[0], 1000, template, data(record(1, 100, "eth0"), record(2, 200, "eth1"))),
+		replayTestMsg(exportTimes[1], 1002, data(record(3, 50, "Te0/0/0"), record(4, 60, ""))),
+		replayTestMsg(exportTimes[2], 1004, data(record(5, 70, "Gi0"))),
+	}
+}
+
+// writeReplayTestPcap writes the messages as UDP packets to port 4739 sent at times, with a DNS packet
+// in the middle.
+func writeReplayTestPcap(t *testing.T, path string, msgs [][]byte, times []time.Duration) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed creating pcap: %v", err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(ts time.Duration, dstPort uint16, payload []byte) {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.IP{192, 168, 0, 1}, DstIP: net.IP{192, 168, 0, 2}}
+		udp := &layers.UDP{SrcPort: 50000, DstPort: layers.UDPPort(dstPort)}
+		udp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+			t.Fatalf("Failed serializing packet: %v", err)
+		}
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(ts), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Failed writing packet: %v", err)
+		}
+	}
+
+	for i := range msgs {
+		write(times[i], 4739, msgs[i])
+		if i == 0 {
+			write(times[i], 53, []byte{0, 1, 2, 3})
+		}
+	}
+}
+
+func TestPluginIPFixReplayPcap(t *testing.T) {
+	// Two exporters replay a pcap twice, at twice the recorded rate.
+	path := filepath.Join(t.TempDir(), "exports.pcap")
+	writeReplayTestPcap(t, path, replayTestMsgs([]uint32{100, 100, 101}),
+		[]time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond})
+
+	initJson := fmt.Sprintf(`
+	{
+		"netflow_version": 10,
+		"dst": "48.0.0.0:4739",
+		"domain_id": 7777,
+		"generators": [],
+		"replay": {
+			"file": "%s",
+			"rate_multiplier": 2,
+			"loops": 2,
+			"port": 4739
+		}
+	}
+	`, path)
+
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix_replay_pcap",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     3 * time.Second,
+		clientsToSim: 2,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixReplayFile(t *testing.T) {
+	// A compressed file of the file exporter is replayed until the max data records.
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	for _, msg := range replayTestMsgs([]uint32{100, 100, 101}) {
+		zw.Write(msg)
+	}
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "exports.ipfix.gz")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed writing file: %v", err)
+	}
+
+	initJson := fmt.Sprintf(`
+	{
+		"netflow_version": 10,
+		"dst": "48.0.0.0:4739",
+		"domain_id": 7777,
+		"max_data_records": 12,
+		"generators": [],
+		"replay": {
+			"file": "%s",
+			"loops": 0
+		}
+	}
+	`, path)
+
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix_replay_file",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixReplayNeg1(t *testing.T) {
+	// IPFIX file replayed by a NetFlow v9 client
+	path := filepath.Join(t.TempDir(), "exports.ipfix")
+	if err := os.WriteFile(path, bytes.Join(replayTestMsgs([]uint32{100, 100, 101}), nil), 0644); err != nil {
+		t.Fatalf("Failed writing file: %v", err)
+	}
+
+	initJson := fmt.Sprintf(`
+	{
+		"netflow_version": 9,
+		"dst": "48.0.0.0:4739",
+		"generators": [],
+		"replay": {"file": "%s"}
+	}
+	`, path)
+
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "IPFIX file can't be replayed by a NetFlow version 9 client",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixReplayNeg2(t *testing.T) {
+	// pcap without exports on the port
+	path := filepath.Join(t.TempDir(), "exports.pcap")
+	writeReplayTestPcap(t, path, replayTestMsgs([]uint32{100, 100, 101}), []time.Duration{0, 0, 0})
+
+	initJson := fmt.Sprintf(`
+	{
+		"netflow_version": 10,
+		"dst": "48.0.0.0:4739",
+		"generators": [],
+		"replay": {"file": "%s", "port": 2055}
+	}
+	`, path)
+
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "has no NetFlow version 10 exports",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestIPFixReplayCountRecordsVer9(t *testing.T) {
+	// NetFlow v9 message with a template, an options template and data of both.
+	b := make([]byte, layers.IpfixHeaderLenVer9)
+	binary.BigEndian.PutUint16(b[0:2], 9)
+	b = append(b, replayTestSet(layers.IpfixTemplateSetIDVer9,
+		0x01, 0x00, 0x00, 0x02,
+		0x00, 0x08, 0x00, 0x04,
+		0x00, 0x04, 0x00, 0x01)...)
+	b = append(b, replayTestSet(layers.IpfixOptionsTemplateSetIDVer9,
+		0x01, 0x01, 0x00, 0x04, 0x00, 0x04,
+		0x00, 0x01, 0x00, 0x04,
+		0x00, 0x22, 0x00, 0x02,
+		0x00, 0x00)...)
+	// 3 records of 5 bytes and 1 byte of padding, 1 record of 6 bytes and 2 bytes of padding.
+	b = append(b, replayTestSet(256, 1, 1, 1, 1, 6, 2, 2, 2, 2, 17, 3, 3, 3, 3, 6, 0)...)
+	b = append(b, replayTestSet(257, 0, 0, 0, 1, 0, 100, 0, 0)...)
+
+	templates := make(replayTemplates)
+	tempRecords, dataRecords := templates.countRecords(b, "")
+	if tempRecords != 2 || dataRecords != 4 {
+		t.Fatalf("Expected 2 template records and 4 data records, have %d and %d", tempRecords, dataRecords)
+	}
+}
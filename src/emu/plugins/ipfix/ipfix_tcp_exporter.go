@@ -0,0 +1,530 @@
+package ipfix
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+IPFIX over TCP, RFC 7011 section 10.4.
+
+IPFIX messages carry their length in the message header, hence the messages are written back to back on the
+stream and the collector splits them by the header. NetFlow v9 has no such length and is not supported over TCP.
+
+A message is written entirely to the stream or not at all, so a disconnect never leaves the collector with a
+partial message on the new connection. After a reconnect the templates are announced again before any data
+record, as the collector drops the templates of a closed session (RFC 7011 section 8.1).
+*/
+
+type TcpExporterParams struct {
+	hostport string
+	// Initial time to wait before reconnecting after a failed connect or a disconnect.
+	// The time is doubled after each failed attempt up to reconnect_max. Default value is 1s.
+	ReconnectInitial Duration `json:"reconnect_initial"`
+	// Maximal time to wait between reconnect attempts. Default value is 30s.
+	ReconnectMax Duration `json:"reconnect_max"`
+}
+
+const (
+	defaultTcpExporterReconnectInitial = 1 * time.Second
+	defaultTcpExporterReconnectMax     = 30 * time.Second
+)
+
+// validateTcpExporterParams validates the reconnect backoff params.
+func validateTcpExporterParams(params *TcpExporterParams) error {
+	if params.ReconnectInitial.Duration <= 0 {
+		return errors.New("Invalid reconnect_initial, should be positive")
+	}
+
+	if params.ReconnectMax.Duration < params.ReconnectInitial.Duration {
+		return errors.New("Invalid reconnect_max, should not be less than reconnect_initial")
+	}
+
+	return nil
+}
+
+// nextReconnectBackoff doubles the backoff up to max.
+func nextReconnectBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// ipfixTemplateCache keeps the last template message written per template ID, so the templates can be
+// announced again on a new connection. It also tracks the sequence number of the next data record, the
+// re-announced templates carry it in order not to look like a sequence number gap to the collector.
+type ipfixTemplateCache struct {
+	templates map[uint16][]byte
+	nextSeq   uint32
+}
+
+// update learns a message that was written to the stream.
+func (o *ipfixTemplateCache) update(b []byte, tempRecordsNum uint32, dataRecordsNum uint32) {
+	if len(b) < ipfixTcpTemplateIdOffset+2 {
+		return
+	}
+
+	o.nextSeq = binary.BigEndian.Uint32(b[8:12]) + dataRecordsNum
+
+	if tempRecordsNum > 0 {
+		if o.templates == nil {
+			o.templates = make(map[uint16][]byte)
+		}
+		id := binary.BigEndian.Uint16(b[ipfixTcpTemplateIdOffset : ipfixTcpTemplateIdOffset+2])
+		o.templates[id] = append([]byte(nil), b...)
+	}
+}
+
+// messages returns copies of the cached template messages ordered by template ID.
+func (o *ipfixTemplateCache) messages() [][]byte {
+	ids := make([]int, 0, len(o.templates))
+	for id := range o.templates {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	res := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		b := append([]byte(nil), o.templates[uint16(id)]...)
+		binary.BigEndian.PutUint32(b[8:12], o.nextSeq)
+		res = append(res, b)
+	}
+	return res
+}
+
+type TcpExporterCounters struct {
+	apiWrites                uint64
+	apiWritesFailed          uint64
+	txWrites                 uint64
+	txWritesFailed           uint64
+	txBytes                  uint64
+	txMsgs                   uint64
+	txTempRecords            uint64
+	txDataRecords            uint64
+	txTempResent             uint64
+	connects                 uint64
+	connectsFailed           uint64
+	disconnects              uint64
+	writeChanEvLowWatermark  uint64
+	writeChanEvHighWatermark uint64
+	writeChanLen             uint64
+	writeChanPeakLen         uint64
+}
+
+type TcpExporter struct {
+	params     TcpExporterParams
+	conn       net.Conn // Owned by the writer thread
+	templates  ipfixTemplateCache
+	init       bool
+	enabled    bool
+	writeChan  *core.NonBlockingChan
+	done       chan struct{} // Closed to stop reconnect attempts
+	wg         sync.WaitGroup
+	client     *PluginIPFixClient
+	counters   TcpExporterCounters
+	countersDb *core.CCounterDb
+}
+
+type TcpExporterInfoJson struct {
+	ExporterType string `json:"exporter_type"`
+	Enabled      string `json:"enabled"`
+}
+
+const (
+	tcpExporterType                 = "tcp"
+	tcpExporterCountersDbName       = "IPFIX tcp exporter"
+	tcpExporterMaxSize              = 1500
+	tcpExporterDialTimeout          = 5 * time.Second
+	tcpExporterWriteTimeout         = 5 * time.Second
+	tcpExporterChanCapacity         = 2000
+	tcpExporterChanLowWatermarkThr  = 400
+	tcpExporterChanHighWatermarkThr = 1600
+	ipfixTcpTemplateIdOffset        = 20 // IPFIX header and set header
+)
+
+type tcpExporterWriteInfo struct {
+	buffer         []byte
+	tempRecordsNum uint32
+	dataRecordsNum uint32
+}
+
+func NewTcpExporter(client *PluginIPFixClient, params *TcpExporterParams) (*TcpExporter, error) {
+	if client == nil {
+		return nil, errors.New("Client param is nil")
+	}
+
+	if params == nil {
+		return nil, errors.New("Params is nil")
+	}
+
+	if _, _, err := net.SplitHostPort(params.hostport); err != nil {
+		return nil, errors.New("Invalid hostport params")
+	}
+
+	if err := validateTcpExporterParams(params); err != nil {
+		return nil, err
+	}
+
+	p := new(TcpExporter)
+
+	kernelMode := client.Tctx.GetKernelMode()
+	if kernelMode != p.GetKernelMode() {
+		return nil, ErrExporterWrongKernelMode
+	}
+
+	p.params = *params
+	p.client = client
+
+	p.newTcpExporterCountersDb()
+
+	var err error
+	p.writeChan, err = core.NewNonBlockingChan(
+		tcpExporterChanCapacity,
+		tcpExporterChanLowWatermarkThr,
+		tcpExporterChanHighWatermarkThr,
+		client.Tctx.GetTimerCtx())
+	if err != nil {
+		return nil, errors.New("Failed to create non-blocking channel")
+	}
+
+	p.writeChan.RegisterObserver(p)
+	p.done = make(chan struct{})
+
+	// The connection is established by the writer thread, so a collector which is not up yet
+	// doesn't fail the exporter creation.
+	p.wg.Add(1)
+	go p.writerThread()
+	p.enabled = true
+	p.init = true
+
+	log.Info("\nIPFIX TCP exporter created with the following parameters: ",
+		"\n\thostport - ", params.hostport,
+		"\n\treconnect_initial - ", params.ReconnectInitial.Duration,
+		"\n\treconnect_max - ", params.ReconnectMax.Duration)
+
+	return p, nil
+}
+
+func (p *TcpExporter) newTcpExporterCountersDb() {
+	p.countersDb = core.NewCCounterDb(tcpExporterCountersDbName)
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.apiWrites,
+		Name:     "apiWrites",
+		Help:     "Num of API calls to write",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.apiWritesFailed,
+		Name:     "apiWritesFailed",
+		Help:     "Num of failed API calls to write",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txWrites,
+		Name:     "txWrites",
+		Help:     "Num of socket writes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txWritesFailed,
+		Name:     "txWritesFailed",
+		Help:     "Num of failed socket writes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txBytes,
+		Name:     "txBytes",
+		Help:     "Num of bytes transmitted",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txMsgs,
+		Name:     "txMsgs",
+		Help:     "Num of messages transmitted",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txTempRecords,
+		Name:     "txTempRecords",
+		Help:     "Num of template records transmitted",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txDataRecords,
+		Name:     "txDataRecords",
+		Help:     "Num of data records transmitted",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.txTempResent,
+		Name:     "txTempResent",
+		Help:     "Num of template messages announced again after a reconnect",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.connects,
+		Name:     "connects",
+		Help:     "Num of established connections",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.connectsFailed,
+		Name:     "connectsFailed",
+		Help:     "Num of failed connect attempts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.disconnects,
+		Name:     "disconnects",
+		Help:     "Num of connections lost",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.writeChanEvLowWatermark,
+		Name:     "writeChanEvLowWatermark",
+		Help:     "Num of write channel low watermark events",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.writeChanEvHighWatermark,
+		Name:     "writeChanEvHighWatermark",
+		Help:     "Num of write channel high watermark events",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.writeChanLen,
+		Name:     "writeChanLen",
+		Help:     "Write channel current length",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	p.countersDb.Add(&core.CCounterRec{
+		Counter:  &p.counters.writeChanPeakLen,
+		Name:     "writeChanPeakLen",
+		Help:     "Write channel peak length",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+}
+
+func (p *TcpExporter) Notify(event core.NonBlockingChanEvent) {
+	switch event {
+	case core.EvLowWatermark:
+		p.counters.writeChanEvLowWatermark++
+		p.client.Pause(false)
+	case core.EvHighWatermark:
+		p.counters.writeChanEvHighWatermark++
+		p.client.Pause(true)
+	default:
+	}
+}
+
+// write writes a whole message to the connection with a deadline, a stalled collector fails the write.
+func (p *TcpExporter) write(b []byte) error {
+	p.counters.txWrites++
+	p.conn.SetWriteDeadline(time.Now().Add(tcpExporterWriteTimeout))
+	_, err := p.conn.Write(b)
+	if err != nil {
+		p.counters.txWritesFailed++
+		return fmt.Errorf("Failed to write to socket - %s", err)
+	}
+	p.counters.txBytes += uint64(len(b))
+	p.counters.txMsgs++
+	return nil
+}
+
+// disconnect closes the connection after a failure.
+func (p *TcpExporter) disconnect() {
+	p.counters.disconnects++
+	p.conn.Close()
+	p.conn = nil
+}
+
+// connect connects to the collector and announces the templates again. It retries with backoff until it
+// succeeds, returns false if the exporter is closed meanwhile.
+func (p *TcpExporter) connect() bool {
+	backoff := p.params.ReconnectInitial.Duration
+	for {
+		conn, err := net.DialTimeout("tcp", p.params.hostport, tcpExporterDialTimeout)
+		if err == nil {
+			p.conn = conn
+			p.counters.connects++
+			if p.resendTemplates() {
+				return true
+			}
+		} else {
+			p.counters.connectsFailed++
+		}
+
+		// While waiting, the write channel fills up and the client is paused by the high watermark.
+		select {
+		case <-p.done:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = nextReconnectBackoff(backoff, p.params.ReconnectMax.Duration)
+	}
+}
+
+// resendTemplates announces the cached templates on a new connection.
+func (p *TcpExporter) resendTemplates() bool {
+	for _, b := range p.templates.messages() {
+		if err := p.write(b); err != nil {
+			p.disconnect()
+			return false
+		}
+		p.counters.txTempResent++
+	}
+	return true
+}
+
+func (p *TcpExporter) writerThread() {
+	defer p.wg.Done()
+	for {
+		obj, err, more := p.writeChan.Read(true)
+		if err != nil || !more {
+			break
+		}
+
+		p.counters.writeChanLen = uint64(p.writeChan.GetLen())
+
+		writeInfo := obj.(*tcpExporterWriteInfo)
+		for {
+			if p.conn == nil && !p.connect() {
+				return
+			}
+			if err = p.write(writeInfo.buffer); err == nil {
+				break
+			}
+			// The message is written again in whole on the new connection.
+			p.disconnect()
+		}
+
+		p.templates.update(writeInfo.buffer, writeInfo.tempRecordsNum, writeInfo.dataRecordsNum)
+		p.counters.txTempRecords += uint64(writeInfo.tempRecordsNum)
+		p.counters.txDataRecords += uint64(writeInfo.dataRecordsNum)
+	}
+
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *TcpExporter) Write(b []byte, tempRecordsNum uint32, dataRecordsNum uint32) (int, error) {
+	if p.init == false {
+		return 0, errors.New("Failed to write - tcp exporter object is uninitialized")
+	}
+
+	if p.enabled == false {
+		return 0, nil
+	}
+
+	p.counters.apiWrites++
+
+	// The generator reuses its buffer, keep a copy until the writer thread sends it.
+	writeInfo := new(tcpExporterWriteInfo)
+	writeInfo.buffer = append([]byte(nil), b...)
+	writeInfo.tempRecordsNum = tempRecordsNum
+	writeInfo.dataRecordsNum = dataRecordsNum
+
+	err := p.writeChan.Write(writeInfo, false)
+	if err != nil {
+		p.counters.apiWritesFailed++
+		return 0, err
+	}
+
+	p.counters.writeChanLen = uint64(p.writeChan.GetLen())
+	p.counters.writeChanPeakLen = uint64(p.writeChan.GetPeakLen())
+
+	return len(b), nil
+}
+
+func (p *TcpExporter) Close() error {
+	if p.init == false {
+		return nil
+	}
+
+	close(p.done)
+	p.writeChan.Close()
+	p.wg.Wait()
+
+	p.init = false
+
+	return nil
+}
+
+func (p *TcpExporter) Enable(enable bool) error {
+	p.enabled = enable
+	return nil
+}
+
+func (p *TcpExporter) GetMaxSize() int {
+	if p.init == false {
+		return 0
+	}
+
+	return tcpExporterMaxSize
+}
+
+func (p *TcpExporter) GetType() string {
+	return tcpExporterType
+}
+
+func (p *TcpExporter) GetCountersDbVec() *core.CCounterDbVec {
+	db := core.NewCCounterDbVec(tcpExporterCountersDbName)
+	db.Add(p.countersDb)
+	return db
+}
+
+func (p *TcpExporter) GetKernelMode() bool {
+	return true
+}
+
+func (p *TcpExporter) GetInfoJson() interface{} {
+	var res TcpExporterInfoJson
+
+	res.ExporterType = p.GetType()
+	res.Enabled = strconv.FormatBool(p.enabled)
+
+	return &res
+}
@@ -0,0 +1,300 @@
+package ipfix
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// ipfixTcpTestStats are the stats of the test collector, one entry per connection.
+type ipfixTcpTestStats struct {
+	Msgs          []int  `json:"msgs"`           // Num of messages received
+	TemplateFirst []bool `json:"template_first"` // Is the first message of the connection a template
+	LastSeq       []int  `json:"last_seq"`       // Sequence number of the last message
+	Partial       []int  `json:"partial"`        // Bytes left of a partial message
+}
+
+// ipfixTcpTestServer is a collector which splits the stream into messages and closes the first connection
+// after closeAfter messages.
+type ipfixTcpTestServer struct {
+	closeAfter int
+	stats      ipfixTcpTestStats
+}
+
+type ipfixTcpTestConn struct {
+	server *ipfixTcpTestServer
+	socket transport.SocketApi
+	index  int
+	buf    []byte
+}
+
+func (o *ipfixTcpTestServer) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	o.stats.Msgs = append(o.stats.Msgs, 0)
+	o.stats.TemplateFirst = append(o.stats.TemplateFirst, false)
+	o.stats.LastSeq = append(o.stats.LastSeq, 0)
+	o.stats.Partial = append(o.stats.Partial, 0)
+	return &ipfixTcpTestConn{server: o, socket: socket, index: len(o.stats.Msgs) - 1}
+}
+
+func (o *ipfixTcpTestConn) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+}
+
+func (o *ipfixTcpTestConn) OnRxData(d []byte) {
+	stats := &o.server.stats
+	o.buf = append(o.buf, d...)
+	for len(o.buf) >= 16 {
+		length := int(binary.BigEndian.Uint16(o.buf[2:4]))
+		if len(o.buf) < length {
+			break
+		}
+		if stats.Msgs[o.index] == 0 {
+			stats.TemplateFirst[o.index] = binary.BigEndian.Uint16(o.buf[16:18]) == 2
+		}
+		stats.Msgs[o.index]++
+		stats.LastSeq[o.index] = int(binary.BigEndian.Uint32(o.buf[8:12]))
+		o.buf = o.buf[length:]
+	}
+	stats.Partial[o.index] = len(o.buf)
+	if o.index == 0 && stats.Msgs[0] == o.server.closeAfter {
+		o.socket.Close()
+	}
+}
+
+func (o *ipfixTcpTestConn) OnTxEvent(event transport.SocketEventType) {}
+
+// ipfixTcpTestStop closes the exporter before the end of the simulation, the sockets hold mbufs while open.
+type ipfixTcpTestStop struct {
+	ns *core.CNSCtx
+}
+
+func (o *ipfixTcpTestStop) OnEvent(a, b interface{}) {
+	c := o.ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+	ipfixPlug := c.PluginCtx.Get(IPFIX_PLUG).Ext.(*PluginIPFixClient)
+	ipfixPlug.Enable(false)
+	ipfixPlug.exporter.Close()
+}
+
+type VethIPFixTcpSim struct{}
+
+func (o *VethIPFixTcpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+func TestPluginIPFixEmuTcp(t *testing.T) {
+	initJson := `{
+		"netflow_version": 10,
+		"dst": "emu-tcp://16.0.0.1:4739",
+		"exporter_params": {"reconnect_initial": "500ms"},
+		"generators": [
+			{
+				"name": "dns",
+				"rate_pps": 2,
+				"data_records_num": 3,
+				"template_id": 261,
+				"fields": [
+					{"name": "clientIPv4Address", "type": 45004, "length": 4, "enterprise_number": 9, "data": [16, 0, 0, 1]},
+					{"name": "protocolIdentifier", "type": 4, "length": 1, "data": [17]}
+				]
+			}
+		]
+	}`
+
+	var simVeth VethIPFixTcpSim
+	var simrx core.VethIFSim = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	// Client 1 is the collector and listens before client 0 connects.
+	server := &ipfixTcpTestServer{closeAfter: 4}
+	for _, j := range []uint8{1, 0} {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, j},
+			core.Ipv4Key{16, 0, 0, j},
+			core.Ipv6Key{},
+			core.Ipv4Key{16, 0, 0, 1})
+		// Each client reaches the other one through the default gateway.
+		client.ForceDGW = true
+		client.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1 - j}
+		ns.AddClient(client)
+		var err error
+		if j == 0 {
+			err = client.PluginCtx.CreatePlugins([]string{IPFIX_PLUG, transport.TRANS_PLUG}, [][]byte{[]byte(initJson)})
+		} else {
+			err = client.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{})
+			if err == nil {
+				err = transport.GetTransportCtx(client).Listen("tcp", ":4739", server)
+			}
+		}
+		if err != nil {
+			t.Fatalf("failed creating client: %v", err)
+		}
+		client.AttemptResolve()
+	}
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, true)
+	var stopTimer core.CHTimerObj
+	stop := ipfixTcpTestStop{ns: ns}
+	stopTimer.SetCB(&stop, 0, 0)
+	tctx.GetTimerCtx().Start(&stopTimer, 4*time.Second)
+	tctx.MainLoopSim(5 * time.Second)
+
+	c := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+	ipfixPlug := c.PluginCtx.Get(IPFIX_PLUG).Ext.(*PluginIPFixClient)
+	ipfixPlug.cdbv.Dump()
+	tctx.SimRecordAppend(ipfixPlug.cdb.MarshalValues(false))
+	tctx.SimRecordAppend(ipfixPlug.exporter.(*EmuTcpExporter).countersDb.MarshalValues(false))
+	tctx.SimRecordAppend(server.stats)
+	tctx.SimRecordCompare("ipfix_emu_tcp", t)
+}
+
+func TestPluginIPFixTcpNeg(t *testing.T) {
+	// NetFlow v9 can't be framed over TCP.
+	templateParams := TemplateParams{
+		autoStart:  true,
+		rate:       2,
+		recordsNum: 7,
+	}
+
+	initJson := fmt.Sprintf(`
+	{
+		"netflow_version": 9,
+		"dst": "emu-tcp://48.0.0.0:4739",
+		"domain_id": 7777,
+		"generators": [%s]
+	}
+	`, getTemplate261(&templateParams))
+
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "TCP exporters support only IPFIX",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestIPFixTcpTemplateCache(t *testing.T) {
+	var cache ipfixTemplateCache
+
+	msg := func(seq uint32, setID, templateID uint16) []byte {
+		b := make([]byte, 24)
+		binary.BigEndian.PutUint16(b[0:2], 10)
+		binary.BigEndian.PutUint16(b[2:4], 24)
+		binary.BigEndian.PutUint32(b[8:12], seq)
+		binary.BigEndian.PutUint16(b[16:18], setID)
+		binary.BigEndian.PutUint16(b[20:22], templateID)
+		return b
+	}
+
+	cache.update(msg(100, 2, 300), 1, 0)
+	cache.update(msg(100, 2, 256), 1, 0)
+	cache.update(msg(100, 300, 0), 0, 5)
+	cache.update(msg(105, 2, 300), 1, 0)
+	cache.update(msg(105, 300, 0), 0, 7)
+
+	msgs := cache.messages()
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 templates, have %d", len(msgs))
+	}
+	for i, id := range []uint16{256, 300} {
+		if binary.BigEndian.Uint16(msgs[i][20:22]) != id || binary.BigEndian.Uint32(msgs[i][8:12]) != 112 {
+			t.Fatalf("Bad template message %d: %v", i, msgs[i])
+		}
+	}
+
+	backoff := time.Second
+	for _, exp := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		backoff = nextReconnectBackoff(backoff, 5*time.Second)
+		if backoff != exp {
+			t.Fatalf("Expected backoff %v, have %v", exp, backoff)
+		}
+	}
+}
+
+func TestIPFixTcpExporterReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Can't listen on localhost: %v", err)
+	}
+	defer listener.Close()
+
+	tctx := core.NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	tctx.SetKernelMode(true)
+	client := new(PluginIPFixClient)
+	client.Tctx = tctx
+
+	exporter, err := NewTcpExporter(client, &TcpExporterParams{
+		hostport:         listener.Addr().String(),
+		ReconnectInitial: Duration{Duration: 10 * time.Millisecond},
+		ReconnectMax:     Duration{Duration: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating exporter: %v", err)
+	}
+	defer exporter.Close()
+
+	msg := func(seq uint32, setID uint16) []byte {
+		b := make([]byte, 24)
+		binary.BigEndian.PutUint16(b[0:2], 10)
+		binary.BigEndian.PutUint16(b[2:4], 24)
+		binary.BigEndian.PutUint32(b[8:12], seq)
+		binary.BigEndian.PutUint16(b[16:18], setID)
+		binary.BigEndian.PutUint16(b[20:22], 256)
+		return b
+	}
+
+	exporter.Write(msg(100, 2), 1, 0)
+	exporter.Write(msg(100, 256), 0, 3)
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	b := make([]byte, 48)
+	if _, err = io.ReadFull(conn, b); err != nil || binary.BigEndian.Uint16(b[16:18]) != 2 {
+		t.Fatalf("Expected a template and a data message, have %v %v", b, err)
+	}
+	conn.Close()
+
+	// The writes fail on the closed connection and the exporter reconnects.
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	seq := uint32(103)
+	for conn = nil; conn == nil; {
+		select {
+		case conn = <-accepted:
+		case <-time.After(10 * time.Millisecond):
+			exporter.Write(msg(seq, 256), 0, 3)
+			seq += 3
+		}
+	}
+	defer conn.Close()
+
+	b = b[:24]
+	if _, err = io.ReadFull(conn, b); err != nil || binary.BigEndian.Uint16(b[16:18]) != 2 || binary.BigEndian.Uint32(b[8:12]) < 103 {
+		t.Fatalf("Expected the template first on the new connection, have %v %v", b, err)
+	}
+	if exporter.counters.connects < 2 || exporter.counters.txTempResent != 1 {
+		t.Fatalf("Bad counters %+v", exporter.counters)
+	}
+}
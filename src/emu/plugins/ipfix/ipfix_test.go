@@ -4,8 +4,8 @@ import (
 	"emu/core"
 	"flag"
 	"fmt"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -63,10 +63,6 @@ func expErrCheck(t testing.TB, expErrStr string, err error) {
 }
 
 func (o *IPFixTestBase) Run(compare bool) {
-	if o.seed != 0 {
-		rand.Seed(o.seed)
-	}
-
 	var simVeth VethIPFixSim
 	simVeth.DropAll = o.dropAll
 	var simrx core.VethIFSim
@@ -114,6 +110,9 @@ func (o *IPFixTestBase) Run(compare bool) {
 		ipfixPlug = clplg.Ext.(*PluginIPFixClient)
 		ipfixPlug.cdbv.Dump()
 		tctx.SimRecordAppend(ipfixPlug.cdb.MarshalValues(false))
+		if ipfixPlug.replay != nil {
+			tctx.SimRecordAppend(ipfixPlug.replay.cdb.MarshalValues(false))
+		}
 	}
 
 	if compare {
@@ -131,6 +130,9 @@ func (o *IPFixTestBase) Run(compare bool) {
 
 func createSimulationEnv(simRx *core.VethIFSim, t *IPFixTestBase) (*core.CThreadCtx, error) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	if t.seed != 0 {
+		tctx.SetSeed(t.seed)
+	}
 	var key core.CTunnelKey
 	key.Set(&core.CTunnelData{Vport: 1})
 	ns := core.NewNSCtx(tctx, &key)
@@ -2480,6 +2482,447 @@ func TestPluginIPFix23(t *testing.T) {
 	a.Run(true)
 }
 
+func TestPluginIPFix24(t *testing.T) {
+	// expression engines which reference fields that come after them
+	initJson := `
+		{
+			"netflow_version": 10,
+			"dst": "48.0.0.0:4739",
+			"domain_id": 24,
+			"generators": [
+				{
+					"name": "expressions",
+					"auto_start": true,
+					"rate_pps": 2,
+					"data_records_num": 3,
+					"template_id": 261,
+					"fields": [
+						{
+							"name": "octetDeltaCount",
+							"type": 1,
+							"length": 8,
+							"data": [0, 0, 0, 0, 0, 0, 0, 0]
+						},
+						{
+							"name": "destinationTransportPort",
+							"type": 11,
+							"length": 2,
+							"data": [0, 0]
+						},
+						{
+							"name": "interfaceName",
+							"type": 82,
+							"length": 65535
+						},
+						{
+							"name": "packetDeltaCount",
+							"type": 2,
+							"length": 4,
+							"data": [0, 0, 0, 0]
+						},
+						{
+							"name": "protocolIdentifier",
+							"type": 4,
+							"length": 1,
+							"data": [0]
+						}
+					],
+					"engines": [
+						{
+							"engine_name": "octetDeltaCount",
+							"engine_type": "expression",
+							"params": {
+								"size": 8,
+								"offset": 0,
+								"expr": "packetDeltaCount * (protocolIdentifier == 6 ? rand(64, 1500) : 512)"
+							}
+						},
+						{
+							"engine_name": "destinationTransportPort",
+							"engine_type": "expression",
+							"params": {
+								"size": 2,
+								"offset": 0,
+								"expr": "protocolIdentifier == 6 ? choice(80, 443) : 53"
+							}
+						},
+						{
+							"engine_name": "interfaceName",
+							"engine_type": "string_list",
+							"params": {
+								"size": 8,
+								"offset": 0,
+								"op": "inc",
+								"list": ["Gi0", "Te0/0/0"]
+							}
+						},
+						{
+							"engine_name": "packetDeltaCount",
+							"engine_type": "uint",
+							"params": {
+								"size": 4,
+								"offset": 0,
+								"min": 1,
+								"max": 10,
+								"op": "rand"
+							}
+						},
+						{
+							"engine_name": "protocolIdentifier",
+							"engine_type": "uint_list",
+							"params": {
+								"size": 1,
+								"offset": 0,
+								"op": "inc",
+								"list": [6, 17]
+							}
+						}
+					]
+				}
+			]
+		}
+		`
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix24",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+		seed:         0xc15c0be524,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFix25(t *testing.T) {
+	// trace and address engines
+	path := filepath.Join(t.TempDir(), "flows.csv")
+	trace := "src,octets\n2001:db8::10,1500\n2001:db8::20,64\n2001:db8::30,576\n"
+	if err := os.WriteFile(path, []byte(trace), 0644); err != nil {
+		t.Fatalf("Failed writing trace file: %v", err)
+	}
+
+	initJson := fmt.Sprintf(`
+		{
+			"netflow_version": 10,
+			"dst": "48.0.0.0:4739",
+			"domain_id": 25,
+			"generators": [
+				{
+					"name": "addresses",
+					"auto_start": true,
+					"rate_pps": 2,
+					"data_records_num": 2,
+					"template_id": 262,
+					"fields": [
+						{
+							"name": "sourceIPv6Address",
+							"type": 27,
+							"length": 16,
+							"data": [0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]
+						},
+						{
+							"name": "destinationIPv6Address",
+							"type": 28,
+							"length": 16,
+							"data": [0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]
+						},
+						{
+							"name": "octetDeltaCount",
+							"type": 1,
+							"length": 4,
+							"data": [0, 0, 0, 0]
+						},
+						{
+							"name": "sourceMacAddress",
+							"type": 56,
+							"length": 6,
+							"data": [0, 0, 0, 0, 0, 0]
+						},
+						{
+							"name": "exporterIPv4Address",
+							"type": 130,
+							"length": 4,
+							"data": [0, 0, 0, 0]
+						}
+					],
+					"engines": [
+						{
+							"engine_name": "sourceIPv6Address",
+							"engine_type": "trace",
+							"params": {"file": "%s", "column": "src", "type": "ipv6"}
+						},
+						{
+							"engine_name": "octetDeltaCount",
+							"engine_type": "trace",
+							"params": {"file": "%s", "column": "octets", "type": "uint", "size": 4}
+						},
+						{
+							"engine_name": "destinationIPv6Address",
+							"engine_type": "ipv6",
+							"params": {"prefix": "2001:db8:1::/120", "op": "rand"}
+						},
+						{
+							"engine_name": "sourceMacAddress",
+							"engine_type": "mac",
+							"params": {"prefix": "00:11:22:00:00:00/24", "op": "inc", "step": 2}
+						},
+						{
+							"engine_name": "exporterIPv4Address",
+							"engine_type": "ipv4",
+							"params": {"prefix": "192.168.0.0/30", "op": "dec", "init": "192.168.0.1"}
+						}
+					]
+				}
+			]
+		}
+		`, path, path)
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix25",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     3 * time.Second,
+		clientsToSim: 1,
+		seed:         0xc15c0be525,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixNetflowV5(t *testing.T) {
+	// NetFlow v5 - 3 flows in each packet, protocol and ports are updated by engines
+	initJson := `
+		{
+			"netflow_version": 5,
+			"dst": "48.0.0.0:2055",
+			"domain_id": 258,
+			"generators": [
+				{
+					"name": "v5",
+					"auto_start": true,
+					"rate_pps": 1,
+					"data_records_num": 3,
+					"template_id": 256,
+					"fields": [
+						{"name": "sourceIPv4Address", "type": 8, "length": 4, "data": [16, 0, 0, 1]},
+						{"name": "destinationIPv4Address", "type": 12, "length": 4, "data": [48, 0, 0, 1]},
+						{"name": "packetDeltaCount", "type": 2, "length": 4, "data": [0, 0, 0, 10]},
+						{"name": "octetDeltaCount", "type": 1, "length": 4, "data": [0, 0, 5, 220]},
+						{"name": "sourceTransportPort", "type": 7, "length": 2, "data": [4, 0]},
+						{"name": "destinationTransportPort", "type": 11, "length": 2, "data": [0, 53]},
+						{"name": "protocolIdentifier", "type": 4, "length": 1, "data": [17]}
+					],
+					"engines": [
+						{
+							"engine_name": "sourceTransportPort",
+							"engine_type": "uint",
+							"params": {
+								"size": 2,
+								"offset": 0,
+								"min": 1024,
+								"max": 1030,
+								"op": "inc",
+								"step": 1
+							}
+						},
+						{
+							"engine_name": "protocolIdentifier",
+							"engine_type": "uint_list",
+							"params": {
+								"size": 1,
+								"offset": 0,
+								"list": [6, 17],
+								"op": "inc"
+							}
+						}
+					]
+				}
+			]
+		}
+		`
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix_netflow_v5",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixSFlow(t *testing.T) {
+	// sFlow v5 - a flow sample generator and a counter sample generator
+	initJson := `
+		{
+			"format": "sflow",
+			"dst": "48.0.0.0:6343",
+			"domain_id": 3,
+			"generators": [
+				{
+					"name": "flows",
+					"auto_start": true,
+					"rate_pps": 2,
+					"data_records_num": 2,
+					"template_id": 256,
+					"fields": [
+						{"name": "samplingInterval", "type": 34, "length": 4, "data": [0, 0, 4, 0]},
+						{"name": "ingressInterface", "type": 10, "length": 4, "data": [0, 0, 0, 1]},
+						{"name": "egressInterface", "type": 14, "length": 4, "data": [0, 0, 0, 2]},
+						{"name": "ipTotalLength", "type": 224, "length": 2, "data": [0, 64]},
+						{"name": "protocolIdentifier", "type": 4, "length": 1, "data": [6]},
+						{"name": "sourceIPv4Address", "type": 8, "length": 4, "data": [16, 0, 0, 1]},
+						{"name": "destinationIPv4Address", "type": 12, "length": 4, "data": [48, 0, 0, 1]},
+						{"name": "sourceTransportPort", "type": 7, "length": 2, "data": [4, 0]},
+						{"name": "destinationTransportPort", "type": 11, "length": 2, "data": [0, 80]}
+					],
+					"engines": [
+						{
+							"engine_name": "sourceIPv4Address",
+							"engine_type": "uint",
+							"params": {
+								"size": 1,
+								"offset": 3,
+								"min": 1,
+								"max": 10,
+								"op": "inc",
+								"step": 1
+							}
+						}
+					]
+				},
+				{
+					"name": "counters",
+					"auto_start": true,
+					"rate_pps": 1,
+					"data_records_num": 1,
+					"template_id": 257,
+					"sflow_sample": "counter",
+					"fields": [
+						{"name": "ifIndex", "type": 1, "length": 4, "data": [0, 0, 0, 1]},
+						{"name": "ifType", "type": 3, "length": 4, "data": [0, 0, 0, 6]},
+						{"name": "ifSpeed", "type": 5, "length": 8, "data": [0, 0, 0, 2, 84, 11, 228, 0]},
+						{"name": "ifStatus", "type": 8, "length": 4, "data": [0, 0, 0, 3]},
+						{"name": "ifInOctets", "type": 10, "length": 8, "data": [0, 0, 0, 0, 0, 0, 16, 0]},
+						{"name": "ifOutOctets", "type": 16, "length": 8, "data": [0, 0, 0, 0, 0, 0, 32, 0]}
+					],
+					"engines": [
+						{
+							"engine_name": "ifInOctets",
+							"engine_type": "uint",
+							"params": {
+								"size": 2,
+								"offset": 6,
+								"min": 4096,
+								"max": 65535,
+								"op": "inc",
+								"step": 1500
+							}
+						}
+					]
+				}
+			]
+		}
+		`
+	a := &IPFixTestBase{
+		goldenfile:   "ipfix_sflow",
+		t:            t,
+		dropAll:      false,
+		monitor:      true,
+		match:        0,
+		capture:      true,
+		initJSON:     [][]byte{[]byte(initJson)},
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixLegacyNeg1(t *testing.T) {
+	// Invalid NetFlow version
+	initJson := `
+		{
+			"netflow_version": 8,
+			"dst": "48.0.0.0:2055",
+			"generators": []
+		}
+		`
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "Invalid netflow_version 8",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixLegacyNeg2(t *testing.T) {
+	// Field that is not part of the NetFlow v5 record
+	initJson := `
+		{
+			"netflow_version": 5,
+			"dst": "48.0.0.0:2055",
+			"generators": [
+				{
+					"name": "v5",
+					"rate_pps": 1,
+					"data_records_num": 1,
+					"template_id": 256,
+					"fields": [
+						{"name": "sourceIPv4Address", "type": 8, "length": 4, "data": [16, 0, 0, 1]},
+						{"name": "applicationId", "type": 95, "length": 4, "data": [3, 0, 0, 53]}
+					]
+				}
+			]
+		}
+		`
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "field applicationId is not part of the NetFlow v5 record",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
+func TestPluginIPFixLegacyNeg3(t *testing.T) {
+	// Field longer than the slot of the sFlow counter sample
+	initJson := `
+		{
+			"format": "sflow",
+			"dst": "48.0.0.0:6343",
+			"generators": [
+				{
+					"name": "counters",
+					"rate_pps": 1,
+					"data_records_num": 1,
+					"template_id": 256,
+					"sflow_sample": "counter",
+					"fields": [
+						{"name": "ifIndex", "type": 1, "length": 8, "data": [0, 0, 0, 0, 0, 0, 0, 1]}
+					]
+				}
+			]
+		}
+		`
+	a := &IPFixTestBase{
+		t:            t,
+		expEnvErr:    "field ifIndex length 8 exceeds 4 bytes",
+		initJSON:     [][]byte{[]byte(initJson)},
+		clientsToSim: 1,
+	}
+	a.Run(true)
+}
+
 //func TestPluginIPFix24(t *testing.T) {
 //
 //	//host, port, err := net.SplitHostPort("file://localhost/auto/srg-sce-swinfra-usr/emb/users/obarash/sdflow/fnf_agg_files")
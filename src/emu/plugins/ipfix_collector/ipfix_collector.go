@@ -0,0 +1,745 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipfix_collector
+
+/*
+IPFIX/NetFlow v9 collector, the other side of the ipfix plugin.
+
+The client listens on a UDP port through the transport layer. Exporters are identified by their source address
+and observation domain. For each exporter the collector learns the templates, decodes the data records, including
+enterprise and variable length fields, and checks the sequence numbers for loss. The last decoded records are
+kept and can be read with an RPC, together with per exporter and per template statistics. The messages are
+decoded by the ipfix_decoder package.
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/ipfix_decoder"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	IPFIX_COLLECTOR_PLUG   = "ipfix_collector" // IPFIX Collector Plugin name
+	DefaultCollectorPort   = 4739              // IANA port of IPFIX
+	DefaultRecordsToKeep   = 100               // Default num of decoded records to keep
+	seqOutOfOrderThreshold = 1 << 31           // Sequence differences from here on are considered older messages
+)
+
+// IPFixCollectorStats represents the counters of an IPFIX collector.
+type IPFixCollectorStats struct {
+	invalidInitJson     uint64 // Error while decoding the init Json
+	invalidSocket       uint64 // Error while listening
+	pktRx               uint64 // Num of messages received
+	bytesRx             uint64 // Num of bytes received
+	pktMalformed        uint64 // Num of messages with an invalid header or set
+	pktUnsupportedVer   uint64 // Num of messages which are not NetFlow v9 or IPFIX
+	exporters           uint64 // Num of exporters learned
+	templatesRx         uint64 // Num of template records received
+	optionsTemplatesRx  uint64 // Num of options template records received
+	templateWithdrawals uint64 // Num of template withdrawals received
+	templateChanged     uint64 // Num of templates redefined with different fields
+	templateInvalid     uint64 // Num of template records which couldn't be decoded
+	dataRecordsRx       uint64 // Num of data records decoded
+	optionsRecordsRx    uint64 // Num of options data records decoded
+	dataSetNoTemplate   uint64 // Num of data sets dropped because the template is unknown
+	recordsNoTemplate   uint64 // Num of data records dropped because the template is unknown
+	recordMalformed     uint64 // Num of data records which couldn't be decoded
+	setUnknown          uint64 // Num of sets with a reserved ID
+	seqLostPkts         uint64 // Num of v9 packets lost according to the sequence number
+	seqLostRecords      uint64 // Num of IPFIX data records lost according to the sequence number
+	seqOutOfOrder       uint64 // Num of messages received with an older sequence number
+}
+
+// NewIPFixCollectorStatsDb creates a new counter database for IPFixCollectorStats.
+func NewIPFixCollectorStatsDb(o *IPFixCollectorStats) *core.CCounterDb {
+	db := core.NewCCounterDb(IPFIX_COLLECTOR_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidSocket,
+		Name:     "invalidSocket",
+		Help:     "Error while listening",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "Messages received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesRx,
+		Name:     "bytesRx",
+		Help:     "Bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktMalformed,
+		Name:     "pktMalformed",
+		Help:     "Messages with an invalid header or set",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktUnsupportedVer,
+		Name:     "pktUnsupportedVer",
+		Help:     "Messages which are not NetFlow v9 or IPFIX",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.exporters,
+		Name:     "exporters",
+		Help:     "Exporters learned",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.templatesRx,
+		Name:     "templatesRx",
+		Help:     "Template records received",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.optionsTemplatesRx,
+		Name:     "optionsTemplatesRx",
+		Help:     "Options template records received",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.templateWithdrawals,
+		Name:     "templateWithdrawals",
+		Help:     "Template withdrawals received",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.templateChanged,
+		Name:     "templateChanged",
+		Help:     "Templates redefined with different fields",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.templateInvalid,
+		Name:     "templateInvalid",
+		Help:     "Template records which couldn't be decoded",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dataRecordsRx,
+		Name:     "dataRecordsRx",
+		Help:     "Data records decoded",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.optionsRecordsRx,
+		Name:     "optionsRecordsRx",
+		Help:     "Options data records decoded",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dataSetNoTemplate,
+		Name:     "dataSetNoTemplate",
+		Help:     "Data sets dropped because the template is unknown",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.recordsNoTemplate,
+		Name:     "recordsNoTemplate",
+		Help:     "Data records dropped because the template is unknown, they are not lost",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.recordMalformed,
+		Name:     "recordMalformed",
+		Help:     "Data records which couldn't be decoded",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.setUnknown,
+		Name:     "setUnknown",
+		Help:     "Sets with a reserved ID",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.seqLostPkts,
+		Name:     "seqLostPkts",
+		Help:     "NetFlow v9 packets lost according to the sequence number",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.seqLostRecords,
+		Name:     "seqLostRecords",
+		Help:     "IPFIX data records lost according to the sequence number",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.seqOutOfOrder,
+		Name:     "seqOutOfOrder",
+		Help:     "Messages received with an older sequence number",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// IPFixCollectorParams defines the init json of the collector.
+type IPFixCollectorParams struct {
+	Port          uint16 `json:"port"`            // UDP port to listen on, defaults to 4739
+	RecordsToKeep uint32 `json:"records_to_keep"` // Num of last decoded records to keep, defaults to 100
+}
+
+// template is a (options) template learned from an exporter, with its statistics.
+type template struct {
+	*ipfix_decoder.Template
+	dataRecords uint64 // Num of data records decoded with this template
+	refreshes   uint64 // Num of times the template was received again
+}
+
+// DecodedRecord is a decoded data record.
+type DecodedRecord struct {
+	Exporter   string                       `json:"exporter"`    // Address of the exporter
+	Version    uint16                       `json:"version"`     // NetFlow version 9 or 10
+	DomainID   uint32                       `json:"domain_id"`   // Observation domain ID (source ID in v9)
+	TemplateID uint16                       `json:"template_id"` // Template ID
+	Options    bool                         `json:"options"`     // Is it an options data record
+	Fields     []ipfix_decoder.DecodedField `json:"fields"`      // Fields in template order
+}
+
+// exporter is an exporting process, identified by its address and observation domain.
+type exporter struct {
+	addr            string               // Source address of the exporter, ip:port
+	ver             uint16               // NetFlow version 9 or 10
+	domainID        uint32               // Observation domain ID (source ID in v9)
+	templates       map[uint16]*template // Templates learned, by template ID
+	nextSeq         uint32               // Expected sequence number of the next message
+	seqInit         bool                 // Was a message received already
+	pkts            uint64               // Num of messages received
+	templateRecords uint64               // Num of (options) template records received
+	dataRecords     uint64               // Num of data records decoded
+	lost            uint64               // Num of packets (v9) or records (IPFIX) lost
+	outOfOrder      uint64               // Num of messages received with an older sequence number
+	noTemplate      uint64               // Num of data records dropped because the template is unknown
+	seqNoTemplate   bool                 // IPFIX, the last message has records without a template, not in nextSeq
+}
+
+// PluginIPFixCollectorClient represents an IPFIX collector client.
+type PluginIPFixCollectorClient struct {
+	core.PluginBase                         // Embedded plugin base
+	params          IPFixCollectorParams    // Init Json params
+	stats           IPFixCollectorStats     // Collector statistics
+	cdb             *core.CCounterDb        // Counters database
+	cdbv            *core.CCounterDbVec     // Counters database vector
+	exporters       map[string]*exporter    // Exporters, by address and observation domain
+	records         []DecodedRecord         // Last decoded records, a ring of RecordsToKeep
+	recordsHead     int                     // Index of the oldest record once the ring is full
+	transportCtx    *transport.TransportCtx // Transport context to listen
+	listenAddr      string                  // Address to listen on
+}
+
+func (o *PluginIPFixCollectorClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+// ipfixCollectorFlow is a flow of an exporter. The flow gives the address of the exporter.
+type ipfixCollectorFlow struct {
+	plug   *PluginIPFixCollectorClient // Collector
+	socket transport.SocketApi         // Socket of the flow
+}
+
+// OnRxEvent function to complete the ISocketCb interface.
+func (o *ipfixCollectorFlow) OnRxEvent(event transport.SocketEventType) {}
+
+// OnRxData is called when a message is received from the exporter.
+func (o *ipfixCollectorFlow) OnRxData(d []byte) {
+	o.plug.HandleRxMessage(o.socket.RemoteAddr().String(), d)
+}
+
+// OnTxEvent function to complete the ISocketCb interface.
+func (o *ipfixCollectorFlow) OnTxEvent(event transport.SocketEventType) { /* No Tx expected */ }
+
+// NewIPFixCollectorClient creates a new IPFIX collector client.
+func NewIPFixCollectorClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginIPFixCollectorClient)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewIPFixCollectorStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(IPFIX_COLLECTOR_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	o.params = IPFixCollectorParams{Port: DefaultCollectorPort, RecordsToKeep: DefaultRecordsToKeep}
+	if len(initJson) > 0 {
+		err := o.Tctx.UnmarshalValidateDisallowUnknownFields(initJson, &o.params)
+		if err != nil {
+			o.stats.invalidInitJson++
+			return nil, err
+		}
+	}
+	o.exporters = make(map[string]*exporter)
+
+	o.transportCtx = transport.GetTransportCtx(o.Client)
+	if o.transportCtx == nil {
+		o.stats.invalidSocket++
+		return nil, fmt.Errorf("failed to get client's transport layer")
+	}
+	o.listenAddr = ":" + strconv.Itoa(int(o.params.Port))
+	if err := o.transportCtx.Listen("udp", o.listenAddr, o); err != nil {
+		o.stats.invalidSocket++
+		return nil, fmt.Errorf("could not create listening socket: %w", err)
+	}
+
+	return &o.PluginBase, nil
+}
+
+// OnAccept is called when a new exporter flow is received. This completes the IServerSocketCb interface.
+func (o *PluginIPFixCollectorClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return &ipfixCollectorFlow{plug: o, socket: socket}
+}
+
+// OnEvent callback of the collector, no events are registered.
+func (o *PluginIPFixCollectorClient) OnEvent(msg string, a, b interface{}) {}
+
+// OnRemove is called when we remove the collector.
+func (o *PluginIPFixCollectorClient) OnRemove(ctx *core.PluginCtx) {
+	o.transportCtx.UnListen("udp", o.listenAddr, o)
+}
+
+// getExporter returns the exporter of a message, a new exporter is learned if needed.
+func (o *PluginIPFixCollectorClient) getExporter(addr string, h *ipfix_decoder.Header) *exporter {
+	key := fmt.Sprintf("%v/%v", addr, h.DomainID)
+	e, ok := o.exporters[key]
+	if !ok {
+		o.stats.exporters++
+	}
+	if !ok || e.ver != h.Ver {
+		// A new exporter, or the exporter restarted with another version and the templates are not valid anymore.
+		e = &exporter{addr: addr, ver: h.Ver, domainID: h.DomainID, templates: make(map[uint16]*template)}
+		o.exporters[key] = e
+	}
+	return e
+}
+
+// addNoTemplate counts data records of the exporter that were dropped because their template is unknown.
+func (o *PluginIPFixCollectorClient) addNoTemplate(e *exporter, records uint64) {
+	e.noTemplate += records
+	o.stats.recordsNoTemplate += records
+}
+
+// checkSeq checks the sequence number of a message with dataRecords data records. noTemplate is set if the message
+// has data sets without a template, IPFIX counts their records but they are not decoded.
+func (o *PluginIPFixCollectorClient) checkSeq(e *exporter, seq uint32, dataRecords uint32, noTemplate bool) {
+	next := seq + 1 // v9 counts packets
+	if e.ver == 10 {
+		next = seq + dataRecords // IPFIX counts data records
+	}
+	if e.seqInit {
+		diff := seq - e.nextSeq
+		if diff >= seqOutOfOrderThreshold {
+			// Older message, reordered or duplicated. Don't go back.
+			e.outOfOrder++
+			o.stats.seqOutOfOrder++
+			return
+		}
+		switch {
+		case diff == 0:
+		case e.seqNoTemplate:
+			// The gap are the records of the previous message that had no template.
+			o.addNoTemplate(e, uint64(diff))
+		case e.ver == 10:
+			e.lost += uint64(diff)
+			o.stats.seqLostRecords += uint64(diff)
+		default:
+			e.lost += uint64(diff)
+			o.stats.seqLostPkts += uint64(diff)
+		}
+	}
+	e.seqInit = true
+	e.seqNoTemplate = noTemplate && e.ver == 10
+	e.nextSeq = next
+}
+
+// HandleRxMessage decodes a message received from an exporter at addr.
+func (o *PluginIPFixCollectorClient) HandleRxMessage(addr string, b []byte) {
+	o.stats.pktRx++
+	o.stats.bytesRx += uint64(len(b))
+
+	h, err := ipfix_decoder.DecodeHeader(b)
+	if err != nil {
+		o.stats.pktMalformed++
+		return
+	}
+	if h.Ver != 9 && h.Ver != 10 {
+		o.stats.pktUnsupportedVer++
+		return
+	}
+	sets, err := ipfix_decoder.DecodeSets(b[h.Len:])
+	if err != nil {
+		// Decode the valid sets anyway.
+		o.stats.pktMalformed++
+	}
+
+	e := o.getExporter(addr, &h)
+	e.pkts++
+	var dataRecords, templateRecords uint32
+	noTemplate := false
+	for i := range sets {
+		switch {
+		case sets[i].IsTemplateSet(h.Ver):
+			templateRecords += o.handleTemplateSet(e, &sets[i])
+		case sets[i].ID >= ipfix_decoder.MinDataSetID:
+			if _, ok := e.templates[sets[i].ID]; !ok {
+				o.stats.dataSetNoTemplate++
+				noTemplate = true
+				continue
+			}
+			dataRecords += o.handleDataSet(e, &sets[i])
+		default:
+			o.stats.setUnknown++
+		}
+	}
+	if noTemplate && h.Ver == 9 && uint32(h.Count) > templateRecords+dataRecords {
+		// The v9 header counts all the records of the packet.
+		o.addNoTemplate(e, uint64(uint32(h.Count)-templateRecords-dataRecords))
+	}
+	o.checkSeq(e, h.Seq, dataRecords, noTemplate)
+}
+
+// handleTemplateSet learns the templates of a (options) template set. Returns the number of template records.
+func (o *PluginIPFixCollectorClient) handleTemplateSet(e *exporter, set *ipfix_decoder.Set) (records uint32) {
+	templates, err := ipfix_decoder.DecodeTemplates(set, e.ver)
+	if err != nil {
+		// Learn the valid templates anyway.
+		o.stats.templateInvalid++
+	}
+	for _, t := range templates {
+		records++
+		e.templateRecords++
+		if t.IsWithdrawal() {
+			o.stats.templateWithdrawals++
+			if t.ID == set.ID {
+				for id, old := range e.templates {
+					if old.Options == t.Options {
+						delete(e.templates, id)
+					}
+				}
+			} else {
+				delete(e.templates, t.ID)
+			}
+			continue
+		}
+		if t.Options {
+			o.stats.optionsTemplatesRx++
+		} else {
+			o.stats.templatesRx++
+		}
+		if old, ok := e.templates[t.ID]; ok {
+			if old.Equal(t) {
+				old.refreshes++
+				continue
+			}
+			o.stats.templateChanged++
+		}
+		e.templates[t.ID] = &template{Template: t}
+	}
+	return records
+}
+
+// handleDataSet decodes the records of a data set with a known template. Returns the number of records decoded.
+func (o *PluginIPFixCollectorClient) handleDataSet(e *exporter, set *ipfix_decoder.Set) (records uint32) {
+	t := e.templates[set.ID]
+	b := set.Body
+	// Anything shorter than the shortest record is padding.
+	for len(b) >= t.MinLen {
+		fields, n, err := t.DecodeRecord(b)
+		if err != nil {
+			o.stats.recordMalformed++
+			return records
+		}
+		b = b[n:]
+		records++
+		t.dataRecords++
+		e.dataRecords++
+		if t.Options {
+			o.stats.optionsRecordsRx++
+		} else {
+			o.stats.dataRecordsRx++
+		}
+		o.addRecord(DecodedRecord{Exporter: e.addr, Version: e.ver, DomainID: e.domainID,
+			TemplateID: t.ID, Options: t.Options, Fields: fields})
+	}
+	return records
+}
+
+// addRecord keeps a decoded record, the oldest record is dropped if there are RecordsToKeep already.
+func (o *PluginIPFixCollectorClient) addRecord(r DecodedRecord) {
+	if o.params.RecordsToKeep == 0 {
+		return
+	}
+	if len(o.records) < int(o.params.RecordsToKeep) {
+		o.records = append(o.records, r)
+		return
+	}
+	o.records[o.recordsHead] = r
+	o.recordsHead = (o.recordsHead + 1) % len(o.records)
+}
+
+// GetRecords returns the last count decoded records, from the oldest to the newest. 0 returns all of them.
+func (o *PluginIPFixCollectorClient) GetRecords(count int) []DecodedRecord {
+	n := len(o.records)
+	if count <= 0 || count > n {
+		count = n
+	}
+	records := make([]DecodedRecord, 0, count)
+	for i := n - count; i < n; i++ {
+		records = append(records, o.records[(o.recordsHead+i)%n])
+	}
+	return records
+}
+
+// TemplateInfo represents the statistics of a template of an exporter.
+type TemplateInfo struct {
+	TemplateID  uint16                    `json:"template_id"`  // Template ID
+	Options     bool                      `json:"options"`      // Is options template
+	ScopeCount  uint16                    `json:"scope_count"`  // Scope count in case of options template
+	Fields      []ipfix_decoder.FieldSpec `json:"fields"`       // Field specifiers
+	DataRecords uint64                    `json:"data_records"` // Data records decoded with this template
+	Refreshes   uint64                    `json:"refreshes"`    // Times the template was received again
+}
+
+// ExporterInfo represents the statistics of an exporter.
+type ExporterInfo struct {
+	Addr            string         `json:"addr"`             // Address of the exporter
+	Version         uint16         `json:"version"`          // NetFlow version 9 or 10
+	DomainID        uint32         `json:"domain_id"`        // Observation domain ID (source ID in v9)
+	Pkts            uint64         `json:"pkts"`             // Messages received
+	TemplateRecords uint64         `json:"template_records"` // (Options) template records received
+	DataRecords     uint64         `json:"data_records"`     // Data records decoded
+	Lost            uint64         `json:"lost"`             // Packets (v9) or data records (IPFIX) lost
+	OutOfOrder      uint64         `json:"out_of_order"`     // Messages received with an older sequence number
+	NoTemplate      uint64         `json:"no_template"`      // Data records dropped because the template is unknown
+	Templates       []TemplateInfo `json:"templates"`        // Templates learned, sorted by ID
+}
+
+// GetExporters returns the statistics of the exporters, sorted by address and observation domain.
+func (o *PluginIPFixCollectorClient) GetExporters() []ExporterInfo {
+	keys := make([]string, 0, len(o.exporters))
+	for key := range o.exporters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	infos := make([]ExporterInfo, 0, len(keys))
+	for _, key := range keys {
+		e := o.exporters[key]
+		info := ExporterInfo{Addr: e.addr, Version: e.ver, DomainID: e.domainID, Pkts: e.pkts,
+			TemplateRecords: e.templateRecords, DataRecords: e.dataRecords, Lost: e.lost, OutOfOrder: e.outOfOrder,
+			NoTemplate: e.noTemplate, Templates: make([]TemplateInfo, 0, len(e.templates))}
+		for _, t := range e.templates {
+			info.Templates = append(info.Templates, TemplateInfo{TemplateID: t.ID, Options: t.Options,
+				ScopeCount: t.ScopeCount, Fields: t.Fields, DataRecords: t.dataRecords, Refreshes: t.refreshes})
+		}
+		sort.Slice(info.Templates, func(i, j int) bool { return info.Templates[i].TemplateID < info.Templates[j].TemplateID })
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// PluginIPFixCollectorNs represents the namespace level of the collector, it has no state.
+type PluginIPFixCollectorNs struct {
+	core.PluginBase
+}
+
+// NewIPFixCollectorNs creates a new namespace plugin of the collector.
+func NewIPFixCollectorNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginIPFixCollectorNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+// OnRemove when removing the namespace plugin.
+func (o *PluginIPFixCollectorNs) OnRemove(ctx *core.PluginCtx) {}
+
+// OnEvent for events the namespace plugin is registered.
+func (o *PluginIPFixCollectorNs) OnEvent(msg string, a, b interface{}) {}
+
+/*
+======================================================================================================
+
+	Generate Plugin
+
+======================================================================================================
+*/
+type PluginIPFixCollectorCReg struct{}
+type PluginIPFixCollectorNsReg struct{}
+
+func (o PluginIPFixCollectorCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewIPFixCollectorClient(ctx, initJson)
+}
+
+func (o PluginIPFixCollectorNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewIPFixCollectorNs(ctx, initJson)
+}
+
+/*
+======================================================================================================
+
+	RPC Methods
+
+======================================================================================================
+*/
+type (
+	ApiIPFixCollectorClientCntHandler struct{}
+
+	ApiIPFixCollectorClientGetExportersHandler struct{}
+
+	ApiIPFixCollectorClientGetRecordsHandler struct{}
+	ApiIPFixCollectorClientGetRecordsParams  struct {
+		Count int `json:"count"` // Num of last records, 0 for all the records kept
+	}
+)
+
+// getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginIPFixCollectorClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, IPFIX_COLLECTOR_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginIPFixCollectorClient)
+
+	return pClient, nil
+}
+
+// ApiIPFixCollectorClientCntHandler gets the counters of the collector.
+func (h ApiIPFixCollectorClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+// ApiIPFixCollectorClientGetExportersHandler gets the statistics of the exporters and their templates.
+func (h ApiIPFixCollectorClientGetExportersHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetExporters(), nil
+}
+
+// ApiIPFixCollectorClientGetRecordsHandler gets the last decoded records.
+func (h ApiIPFixCollectorClientGetRecordsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiIPFixCollectorClientGetRecordsParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetRecords(p.Count), nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(IPFIX_COLLECTOR_PLUG,
+		core.PluginRegisterData{Client: PluginIPFixCollectorCReg{},
+			Ns:     PluginIPFixCollectorNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("ipfix_collector_c_cnt", ApiIPFixCollectorClientCntHandler{}, false) // get counters / meta per client
+	core.RegisterCB("ipfix_collector_c_get_exporters", ApiIPFixCollectorClientGetExportersHandler{}, false)
+	core.RegisterCB("ipfix_collector_c_get_records", ApiIPFixCollectorClientGetRecordsHandler{}, false)
+}
+
+func Register(ctx *core.CThreadCtx) {
+	// In order for this plugin to be included in the EMU compilation one must provide this empty register
+	// function. In case you remove the function call, then the core will not include EMU.
+}
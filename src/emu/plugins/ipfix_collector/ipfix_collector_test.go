@@ -0,0 +1,307 @@
+package ipfix_collector
+
+import (
+	"emu/core"
+	"emu/plugins/ipfix"
+	"emu/plugins/ipfix_decoder"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+// IPFixCollectorTestBase represents the base parameters for a collector test.
+type IPFixCollectorTestBase struct {
+	testname     string
+	monitor      bool
+	capture      bool
+	duration     time.Duration
+	exporterJson []byte
+	initJson     []byte
+}
+
+// VethIPFixCollectorSim loops back the packets, the exporter sends to the collector in the same namespace.
+type VethIPFixCollectorSim struct{}
+
+func (o *VethIPFixCollectorSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// Run creates an exporter client and a collector client, and dumps the collector counters and exporters.
+func (o *IPFixCollectorTestBase) Run(t *testing.T) {
+	var simVeth VethIPFixCollectorSim
+	var simrx core.VethIFSim = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	// Client 0 is the exporter, client 1 is the collector.
+	for j := 0; j < 2; j++ {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, uint8(j)},
+			core.Ipv4Key{16, 0, 0, uint8(j)},
+			core.Ipv6Key{},
+			core.Ipv4Key{16, 0, 0, 1})
+		client.ForceDGW = true
+		client.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1}
+		ns.AddClient(client)
+		var err error
+		if j == 0 {
+			err = client.PluginCtx.CreatePlugins([]string{ipfix.IPFIX_PLUG, transport.TRANS_PLUG}, [][]byte{o.exporterJson})
+		} else {
+			err = client.PluginCtx.CreatePlugins([]string{IPFIX_COLLECTOR_PLUG, transport.TRANS_PLUG}, [][]byte{o.initJson})
+		}
+		if err != nil {
+			t.Fatalf("failed creating plugins: %v", err)
+		}
+		client.AttemptResolve()
+	}
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+
+	c := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 1})
+	plug := c.PluginCtx.Get(IPFIX_COLLECTOR_PLUG).Ext.(*PluginIPFixCollectorClient)
+	plug.cdbv.Dump()
+	tctx.SimRecordAppend(plug.cdb.MarshalValues(false))
+	tctx.SimRecordAppend(plug.GetExporters())
+	tctx.SimRecordAppend(plug.GetRecords(2))
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func TestPluginIPFixCollector1(t *testing.T) {
+
+	// IPFIX exporter with a data template with enterprise fields and an options template.
+	exporterJson := `{
+		"netflow_version": 10,
+		"dst": "16.0.0.1:4739",
+		"domain_id": 7777,
+		"generators": [
+			{
+				"name": "dns",
+				"rate_pps": 2,
+				"data_records_num": 3,
+				"template_id": 261,
+				"fields": [
+					{"name": "clientIPv4Address", "type": 45004, "length": 4, "enterprise_number": 9, "data": [16, 0, 0, 1]},
+					{"name": "protocolIdentifier", "type": 4, "length": 1, "data": [17]},
+					{"name": "applicationId", "type": 95, "length": 4, "data": [3, 0, 0, 53]}
+				]
+			},
+			{
+				"name": "options",
+				"rate_pps": 1,
+				"data_records_num": 1,
+				"template_id": 262,
+				"is_options_template": true,
+				"scope_count": 1,
+				"fields": [
+					{"name": "observationDomainId", "type": 149, "length": 4, "data": [0, 0, 30, 97]},
+					{"name": "exportedMessageTotalCount", "type": 41, "length": 8, "data": [0, 0, 0, 0, 0, 0, 0, 9]}
+				]
+			}
+		]
+	}`
+
+	a := &IPFixCollectorTestBase{
+		testname:     "ipfix_collector1",
+		monitor:      true,
+		capture:      true,
+		duration:     5 * time.Second,
+		exporterJson: []byte(exporterJson),
+		initJson:     []byte(`{"records_to_keep": 10}`),
+	}
+	a.Run(t)
+}
+
+func TestPluginIPFixCollector2(t *testing.T) {
+
+	// NetFlow v9 exporter on a non default port.
+	exporterJson := `{
+		"netflow_version": 9,
+		"dst": "16.0.0.1:2055",
+		"domain_id": 5,
+		"generators": [
+			{
+				"name": "v9",
+				"rate_pps": 1,
+				"data_records_num": 2,
+				"template_id": 300,
+				"fields": [
+					{"name": "sourceIPv4Address", "type": 8, "length": 4, "data": [16, 0, 0, 0]},
+					{"name": "destinationIPv4Address", "type": 12, "length": 4, "data": [48, 0, 0, 1]},
+					{"name": "octetDeltaCount", "type": 1, "length": 4, "data": [0, 0, 5, 220]}
+				]
+			}
+		]
+	}`
+
+	a := &IPFixCollectorTestBase{
+		testname:     "ipfix_collector2",
+		monitor:      true,
+		capture:      true,
+		duration:     3 * time.Second,
+		exporterJson: []byte(exporterJson),
+		initJson:     []byte(`{"port": 2055}`),
+	}
+	a.Run(t)
+}
+
+// newTestCollector creates a collector without a client to decode messages directly.
+func newTestCollector(recordsToKeep uint32) *PluginIPFixCollectorClient {
+	o := new(PluginIPFixCollectorClient)
+	o.params = IPFixCollectorParams{Port: DefaultCollectorPort, RecordsToKeep: recordsToKeep}
+	o.exporters = make(map[string]*exporter)
+	return o
+}
+
+// ipfixMsg builds an IPFIX message with sequence number seq and the given sets.
+func ipfixMsg(seq uint32, sets ...[]byte) []byte {
+	b := make([]byte, ipfix_decoder.HeaderLenVer10)
+	binary.BigEndian.PutUint16(b[0:2], 10)
+	binary.BigEndian.PutUint32(b[8:12], seq)
+	binary.BigEndian.PutUint32(b[12:16], 1)
+	for _, set := range sets {
+		b = append(b, set...)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// ipfixSetBytes builds a set with id and body.
+func ipfixSetBytes(id uint16, body ...byte) []byte {
+	b := make([]byte, ipfix_decoder.SetHeaderLen, ipfix_decoder.SetHeaderLen+len(body))
+	binary.BigEndian.PutUint16(b[0:2], id)
+	binary.BigEndian.PutUint16(b[2:4], uint16(ipfix_decoder.SetHeaderLen+len(body)))
+	return append(b, body...)
+}
+
+func TestIPFixCollectorDecode(t *testing.T) {
+	o := newTestCollector(3)
+	addr := "16.0.0.0:4739"
+
+	// Template 256: enterprise field of 2 bytes, variable length field.
+	tmpl := ipfixSetBytes(ipfix_decoder.TemplateSetIDVer10,
+		0x01, 0x00, 0x00, 0x02,
+		0x80, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x52, 0xFF, 0xFF)
+	// Two records, "abc" and a 300 bytes long string, and 2 bytes of padding.
+	long := make([]byte, 300)
+	body := []byte{0x12, 0x34, 3, 'a', 'b', 'c', 0x56, 0x78, 255, 0x01, 0x2C}
+	body = append(body, long...)
+	body = append(body, 0, 0)
+	data := ipfixSetBytes(256, body...)
+	o.HandleRxMessage(addr, ipfixMsg(100, tmpl, data))
+
+	if o.stats.templatesRx != 1 || o.stats.dataRecordsRx != 2 || o.stats.recordMalformed != 0 {
+		t.Fatalf("Bad counters %+v", o.stats)
+	}
+	records := o.GetRecords(0)
+	if len(records) != 2 || records[0].Fields[0].EnterpriseNumber != 9 || records[0].Fields[0].Type != 1 ||
+		records[0].Fields[1].Value != "616263" || len(records[1].Fields[1].Value) != 600 {
+		t.Fatalf("Bad records %+v", records)
+	}
+
+	// Data records 102 and 103 are lost, then an older message.
+	o.HandleRxMessage(addr, ipfixMsg(104, data))
+	o.HandleRxMessage(addr, ipfixMsg(50, data))
+	if o.stats.seqLostRecords != 2 || o.stats.seqOutOfOrder != 1 {
+		t.Fatalf("Bad sequence counters %+v", o.stats)
+	}
+	if records := o.GetRecords(0); len(records) != 3 {
+		t.Fatalf("Expected the last 3 records, have %d", len(records))
+	}
+
+	// Unknown template, withdrawal and a set longer than the message.
+	o.HandleRxMessage(addr, ipfixMsg(106, ipfixSetBytes(257, 1, 2, 3, 4)))
+	o.HandleRxMessage(addr, ipfixMsg(106, ipfixSetBytes(ipfix_decoder.TemplateSetIDVer10, 0x01, 0x00, 0x00, 0x00)))
+	o.HandleRxMessage(addr, ipfixMsg(106, data))
+	msg := ipfixMsg(106, data)
+	binary.BigEndian.PutUint16(msg[ipfix_decoder.HeaderLenVer10+2:], 0xFFF0)
+	o.HandleRxMessage(addr, msg)
+	if o.stats.dataSetNoTemplate != 2 || o.stats.templateWithdrawals != 1 || o.stats.pktMalformed != 1 {
+		t.Fatalf("Bad counters %+v", o.stats)
+	}
+	exporters := o.GetExporters()
+	if len(exporters) != 1 || exporters[0].Lost != 2 || exporters[0].OutOfOrder != 1 || len(exporters[0].Templates) != 0 {
+		t.Fatalf("Bad exporters %+v", exporters)
+	}
+}
+
+func TestIPFixCollectorNoTemplate(t *testing.T) {
+	o := newTestCollector(DefaultRecordsToKeep)
+	addr := "16.0.0.0:4739"
+
+	// Template 256 of one field of 4 bytes and two records.
+	tmpl := ipfixSetBytes(ipfix_decoder.TemplateSetIDVer10, 0x01, 0x00, 0x00, 0x01, 0x00, 0x08, 0x00, 0x04)
+	o.HandleRxMessage(addr, ipfixMsg(10, tmpl, ipfixSetBytes(256, 16, 0, 0, 1, 16, 0, 0, 2)))
+	// Three records of template 300 that didn't arrive, they are counted by the sequence number of the next message.
+	o.HandleRxMessage(addr, ipfixMsg(12, ipfixSetBytes(300, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3)))
+	o.HandleRxMessage(addr, ipfixMsg(15, ipfixSetBytes(256, 16, 0, 0, 3)))
+	if o.stats.recordsNoTemplate != 3 || o.stats.dataSetNoTemplate != 1 || o.stats.seqLostRecords != 0 {
+		t.Fatalf("Records without a template are counted as lost %+v", o.stats)
+	}
+	// Records 16 to 19 are lost.
+	o.HandleRxMessage(addr, ipfixMsg(20, ipfixSetBytes(256, 16, 0, 0, 4)))
+	if o.stats.recordsNoTemplate != 3 || o.stats.seqLostRecords != 4 {
+		t.Fatalf("Bad sequence counters %+v", o.stats)
+	}
+
+	// The exporter restarts with NetFlow v9, the v9 header counts the records without a template.
+	b := make([]byte, ipfix_decoder.HeaderLenVer9)
+	binary.BigEndian.PutUint16(b[0:2], 9)
+	binary.BigEndian.PutUint16(b[2:4], 2)
+	binary.BigEndian.PutUint32(b[16:20], 1)
+	b = append(b, ipfixSetBytes(256, 16, 0, 0, 1, 16, 0, 0, 2)...)
+	o.HandleRxMessage(addr, b)
+	if o.stats.exporters != 1 || o.stats.recordsNoTemplate != 5 || o.stats.seqLostPkts != 0 {
+		t.Fatalf("Bad counters after a version change %+v", o.stats)
+	}
+	exporters := o.GetExporters()
+	if len(exporters) != 1 || exporters[0].Version != 9 || exporters[0].NoTemplate != 2 || exporters[0].Lost != 0 {
+		t.Fatalf("Bad exporters %+v", exporters)
+	}
+}
+
+func TestIPFixCollectorDecodeV9Options(t *testing.T) {
+	o := newTestCollector(DefaultRecordsToKeep)
+	b := make([]byte, ipfix_decoder.HeaderLenVer9)
+	binary.BigEndian.PutUint16(b[0:2], 9)
+	binary.BigEndian.PutUint32(b[12:16], 7)
+	binary.BigEndian.PutUint32(b[16:20], 3)
+	// Options template 260, scope of 1 field, 1 option field, 2 bytes of padding.
+	b = append(b, ipfixSetBytes(ipfix_decoder.OptionsTemplateSetIDVer9,
+		0x01, 0x04, 0x00, 0x04, 0x00, 0x04,
+		0x00, 0x01, 0x00, 0x04,
+		0x00, 0x22, 0x00, 0x02,
+		0x00, 0x00)...)
+	b = append(b, ipfixSetBytes(260, 0, 0, 0, 1, 0, 100, 0, 0)...)
+	o.HandleRxMessage("16.0.0.0:2055", b)
+
+	binary.BigEndian.PutUint32(b[12:16], 9)
+	o.HandleRxMessage("16.0.0.0:2055", b)
+
+	if o.stats.optionsTemplatesRx != 2 || o.stats.optionsRecordsRx != 2 || o.stats.seqLostPkts != 1 {
+		t.Fatalf("Bad counters %+v", o.stats)
+	}
+	exporters := o.GetExporters()
+	if len(exporters) != 1 || exporters[0].DomainID != 3 || len(exporters[0].Templates) != 1 ||
+		exporters[0].Templates[0].ScopeCount != 1 || exporters[0].Templates[0].Refreshes != 1 {
+		t.Fatalf("Bad exporters %+v", exporters)
+	}
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipfix_decoder
+
+/*
+Decoding of NetFlow v9 (RFC 3954) and IPFIX (RFC 7011) messages, shared by the collector and the replay.
+
+A message is a header followed by sets. Template sets teach the receiver how to decode the data sets, hence
+templates are learned per exporter and observation domain. Each set has an ID and a length:
+
+	v9  - 0 template, 1 options template, >= 256 data
+	v10 - 2 template, 3 options template, >= 256 data
+
+The sequence number counts export packets in v9 and data records in IPFIX, a gap means a loss.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	HeaderLenVer10            = 16     // IPFIX message header length
+	HeaderLenVer9             = 20     // NetFlow v9 packet header length
+	SetHeaderLen              = 4      // Set ID and length
+	TemplateSetIDVer9         = 0      // Template FlowSet ID in v9
+	OptionsTemplateSetIDVer9  = 1      // Options Template FlowSet ID in v9
+	TemplateSetIDVer10        = 2      // Template Set ID in IPFIX
+	OptionsTemplateSetIDVer10 = 3      // Options Template Set ID in IPFIX
+	MinDataSetID              = 256    // Data Sets IDs start here, it is also the minimal template ID
+	VariableLength            = 0xFFFF // Field length of a variable length field
+	EnterpriseBit             = 0x8000 // Enterprise bit of the field type
+)
+
+// FieldSpec is a field specifier of a template.
+type FieldSpec struct {
+	Type             uint16 `json:"type"`                        // Information element ID, without the enterprise bit
+	Length           uint16 `json:"length"`                      // Field length, 65535 for variable length
+	EnterpriseNumber uint32 `json:"enterprise_number,omitempty"` // Enterprise number, 0 for IANA fields
+}
+
+// IsVariableLength indicates if this field is variable length.
+func (o *FieldSpec) IsVariableLength() bool {
+	return o.Length == VariableLength
+}
+
+// Template is a (options) template learned from an exporter.
+type Template struct {
+	ID         uint16      // Template ID
+	Options    bool        // Is options template
+	ScopeCount uint16      // Num of scope fields in an options template
+	Fields     []FieldSpec // Field specifiers
+	MinLen     int         // Length of the shortest record, variable length fields count as 1 byte
+}
+
+// NewTemplate creates a template and calculates the length of its shortest record.
+func NewTemplate(id uint16, options bool, scopeCount uint16, fields []FieldSpec) *Template {
+	o := &Template{ID: id, Options: options, ScopeCount: scopeCount, Fields: fields}
+	for i := range fields {
+		if fields[i].IsVariableLength() {
+			o.MinLen++
+		} else {
+			o.MinLen += int(fields[i].Length)
+		}
+	}
+	return o
+}
+
+// IsWithdrawal returns true if the template has no fields. With the set ID as template ID it withdraws all
+// the templates of the set.
+func (o *Template) IsWithdrawal() bool {
+	return len(o.Fields) == 0
+}
+
+// Equal returns true if both templates define the same record.
+func (o *Template) Equal(t *Template) bool {
+	if o.Options != t.Options || o.ScopeCount != t.ScopeCount || len(o.Fields) != len(t.Fields) {
+		return false
+	}
+	for i := range o.Fields {
+		if o.Fields[i] != t.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodedField is a field of a decoded data record.
+type DecodedField struct {
+	FieldSpec
+	Value string `json:"value"` // Value of the field as a hex string
+}
+
+// decode walks a single record of the template in b, the fields are decoded unless fields is nil.
+// Returns the length of the record.
+func (o *Template) decode(b []byte, fields []DecodedField) (int, error) {
+	off := 0
+	for i := range o.Fields {
+		length := int(o.Fields[i].Length)
+		if o.Fields[i].IsVariableLength() {
+			// RFC 7011 section 7, the length is carried in one octet, or 255 followed by two octets.
+			if off >= len(b) {
+				return 0, fmt.Errorf("record truncated in length of field %d", i)
+			}
+			length = int(b[off])
+			off++
+			if length == 255 {
+				if off+2 > len(b) {
+					return 0, fmt.Errorf("record truncated in length of field %d", i)
+				}
+				length = int(binary.BigEndian.Uint16(b[off : off+2]))
+				off += 2
+			}
+		}
+		if off+length > len(b) {
+			return 0, fmt.Errorf("record truncated in field %d", i)
+		}
+		if fields != nil {
+			fields[i] = DecodedField{FieldSpec: o.Fields[i], Value: hex.EncodeToString(b[off : off+length])}
+		}
+		off += length
+	}
+	return off, nil
+}
+
+// DecodeRecord decodes a single record of the template from b. Returns the record and its length.
+func (o *Template) DecodeRecord(b []byte) ([]DecodedField, int, error) {
+	fields := make([]DecodedField, len(o.Fields))
+	n, err := o.decode(b, fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	return fields, n, nil
+}
+
+// CountRecords counts the records of a data set of the template, without decoding them. Anything shorter than
+// the shortest record is padding, a truncated record ends the count.
+func (o *Template) CountRecords(b []byte) (n uint32) {
+	if o.MinLen == 0 {
+		return 0
+	}
+	for len(b) >= o.MinLen {
+		length, err := o.decode(b, nil)
+		if err != nil {
+			break
+		}
+		b = b[length:]
+		n++
+	}
+	return n
+}
+
+// Header is the decoded header of a message.
+type Header struct {
+	Ver      uint16 // NetFlow version 9 or 10
+	Seq      uint32 // Sequence number
+	DomainID uint32 // Observation domain ID (source ID in v9)
+	Count    uint16 // Num of template and data records in a v9 packet
+	Len      int    // Header length, the sets follow
+}
+
+// DecodeHeader decodes and validates the header of a message. The header of other versions only has Ver.
+func DecodeHeader(b []byte) (h Header, err error) {
+	if len(b) < 2 {
+		return h, fmt.Errorf("message of %d bytes is too short", len(b))
+	}
+	h.Ver = binary.BigEndian.Uint16(b[0:2])
+	switch h.Ver {
+	case 10:
+		if len(b) < HeaderLenVer10 {
+			return h, fmt.Errorf("message of %d bytes is too short", len(b))
+		}
+		if length := int(binary.BigEndian.Uint16(b[2:4])); length != len(b) {
+			return h, fmt.Errorf("message length %d differs from received length %d", length, len(b))
+		}
+		h.Seq = binary.BigEndian.Uint32(b[8:12])
+		h.DomainID = binary.BigEndian.Uint32(b[12:16])
+		h.Len = HeaderLenVer10
+	case 9:
+		if len(b) < HeaderLenVer9 {
+			return h, fmt.Errorf("packet of %d bytes is too short", len(b))
+		}
+		h.Count = binary.BigEndian.Uint16(b[2:4])
+		h.Seq = binary.BigEndian.Uint32(b[12:16])
+		h.DomainID = binary.BigEndian.Uint32(b[16:20])
+		h.Len = HeaderLenVer9
+	}
+	return h, nil
+}
+
+// DecodeFields decodes count field specifiers from b. Enterprise fields are supported only in IPFIX.
+// Returns the fields and the number of bytes consumed.
+func DecodeFields(b []byte, count int, ver uint16) ([]FieldSpec, int, error) {
+	fields := make([]FieldSpec, count)
+	off := 0
+	for i := 0; i < count; i++ {
+		if off+4 > len(b) {
+			return nil, 0, fmt.Errorf("template truncated in field %d", i)
+		}
+		fields[i].Type = binary.BigEndian.Uint16(b[off : off+2])
+		fields[i].Length = binary.BigEndian.Uint16(b[off+2 : off+4])
+		off += 4
+		if ver == 10 && fields[i].Type&EnterpriseBit != 0 {
+			if off+4 > len(b) {
+				return nil, 0, fmt.Errorf("template truncated in enterprise number of field %d", i)
+			}
+			fields[i].Type &^= EnterpriseBit
+			fields[i].EnterpriseNumber = binary.BigEndian.Uint32(b[off : off+4])
+			off += 4
+		}
+		if fields[i].Length == 0 {
+			return nil, 0, fmt.Errorf("field %d has zero length", i)
+		}
+		if ver == 9 && fields[i].IsVariableLength() {
+			return nil, 0, fmt.Errorf("NetFlow version 9 does not support var len field %d", i)
+		}
+	}
+	return fields, off, nil
+}
+
+// DecodeTemplate decodes a single (options) template record of set setID from b, at least 4 bytes. A template
+// with no fields is a withdrawal, with the set ID as template ID it withdraws all the templates of the set.
+// Returns the template and the number of bytes consumed.
+func DecodeTemplate(b []byte, setID, ver uint16) (*Template, int, error) {
+	var id, fieldCount, scopeCount uint16
+	var options bool
+	var off int
+	switch setID {
+	case TemplateSetIDVer9, TemplateSetIDVer10:
+		id = binary.BigEndian.Uint16(b[0:2])
+		fieldCount = binary.BigEndian.Uint16(b[2:4])
+		off = 4
+	case OptionsTemplateSetIDVer10:
+		options = true
+		id = binary.BigEndian.Uint16(b[0:2])
+		fieldCount = binary.BigEndian.Uint16(b[2:4])
+		off = 4
+		if fieldCount == 0 {
+			// withdrawal, no scope field count
+			break
+		}
+		if len(b) < 6 {
+			return nil, 0, fmt.Errorf("options template truncated")
+		}
+		scopeCount = binary.BigEndian.Uint16(b[4:6])
+		off = 6
+		if scopeCount == 0 || scopeCount > fieldCount {
+			return nil, 0, fmt.Errorf("invalid scope count %d", scopeCount)
+		}
+	case OptionsTemplateSetIDVer9:
+		if len(b) < 6 {
+			return nil, 0, fmt.Errorf("options template truncated")
+		}
+		options = true
+		id = binary.BigEndian.Uint16(b[0:2])
+		scopeLen := binary.BigEndian.Uint16(b[2:4])
+		optionLen := binary.BigEndian.Uint16(b[4:6])
+		off = 6
+		if scopeLen%4 != 0 || optionLen%4 != 0 || scopeLen == 0 {
+			return nil, 0, fmt.Errorf("invalid option scope length %d or option length %d", scopeLen, optionLen)
+		}
+		scopeCount = scopeLen / 4
+		fieldCount = (scopeLen + optionLen) / 4
+	}
+	if fieldCount == 0 && id == setID && ver == 10 {
+		// withdrawal of all the templates of this set, RFC 7011 section 8.1
+		return NewTemplate(id, options, 0, nil), off, nil
+	}
+	if id < MinDataSetID {
+		return nil, 0, fmt.Errorf("invalid template ID %d", id)
+	}
+	fields, n, err := DecodeFields(b[off:], int(fieldCount), ver)
+	if err != nil {
+		return nil, 0, err
+	}
+	return NewTemplate(id, options, scopeCount, fields), off + n, nil
+}
+
+// Set is a set of a message.
+type Set struct {
+	ID   uint16 // Set ID
+	Body []byte // Set content without the set header
+}
+
+// DecodeSets splits the sets of a message. On error, the sets before the invalid one are returned.
+func DecodeSets(b []byte) ([]Set, error) {
+	var sets []Set
+	for len(b) > 0 {
+		if len(b) < SetHeaderLen {
+			return sets, fmt.Errorf("set header truncated")
+		}
+		id := binary.BigEndian.Uint16(b[0:2])
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if length < SetHeaderLen || length > len(b) {
+			return sets, fmt.Errorf("invalid set length %d", length)
+		}
+		sets = append(sets, Set{ID: id, Body: b[SetHeaderLen:length]})
+		b = b[length:]
+	}
+	return sets, nil
+}
+
+// IsTemplateSet returns true if the set contains (options) templates for version ver.
+func (o *Set) IsTemplateSet(ver uint16) bool {
+	if ver == 9 {
+		return o.ID == TemplateSetIDVer9 || o.ID == OptionsTemplateSetIDVer9
+	}
+	return o.ID == TemplateSetIDVer10 || o.ID == OptionsTemplateSetIDVer10
+}
+
+// DecodeTemplates decodes the (options) template records of a template set. Anything shorter than a template
+// header is padding. On error, the templates before the invalid one are returned.
+func DecodeTemplates(set *Set, ver uint16) ([]*Template, error) {
+	var templates []*Template
+	b := set.Body
+	for len(b) >= 4 {
+		t, n, err := DecodeTemplate(b, set.ID, ver)
+		if err != nil {
+			return templates, err
+		}
+		b = b[n:]
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
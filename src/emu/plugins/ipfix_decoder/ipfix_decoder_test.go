@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipfix_decoder
+
+import (
+	"testing"
+)
+
+// TestDecodeTemplates decodes a template set with a variable length field, a withdrawal and padding.
+func TestDecodeTemplates(t *testing.T) {
+	set := Set{ID: TemplateSetIDVer10, Body: []byte{
+		0x01, 0x00, 0x00, 0x02, // template 256, 2 fields
+		0x00, 0x08, 0x00, 0x04, // sourceIPv4Address, 4 bytes
+		0x80, 0x52, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x09, // enterprise field 82, variable length
+		0x01, 0x01, 0x00, 0x00, // withdrawal of template 257
+		0x00, 0x00, // padding
+	}}
+	templates, err := DecodeTemplates(&set, 10)
+	if err != nil || len(templates) != 2 {
+		t.Fatalf("Expected 2 templates, have %v, error %v", templates, err)
+	}
+	tmpl := templates[0]
+	if tmpl.ID != 256 || tmpl.MinLen != 5 || !tmpl.Fields[1].IsVariableLength() || tmpl.Fields[1].EnterpriseNumber != 9 {
+		t.Fatalf("Bad template %+v", tmpl)
+	}
+	if !templates[1].IsWithdrawal() || templates[1].ID != 257 {
+		t.Fatalf("Bad withdrawal %+v", templates[1])
+	}
+
+	// Records of 4 + 1 + 2, 4 + 3 + 255 bytes of long length, and 3 bytes of padding.
+	data := []byte{1, 1, 1, 1, 2, 0xAA, 0xBB}
+	data = append(data, 2, 2, 2, 2, 255, 0x00, 0xFF)
+	data = append(data, make([]byte, 255)...)
+	data = append(data, 0, 0, 0)
+	if n := tmpl.CountRecords(data); n != 2 {
+		t.Fatalf("Expected 2 records, have %v", n)
+	}
+	fields, n, err := tmpl.DecodeRecord(data)
+	if err != nil || n != 7 || fields[0].Value != "01010101" || fields[1].Value != "aabb" {
+		t.Fatalf("Bad record %+v of length %v, error %v", fields, n, err)
+	}
+	// A truncated record ends the count.
+	if n := tmpl.CountRecords(data[:7+4+3+100]); n != 1 {
+		t.Fatalf("Expected 1 record, have %v", n)
+	}
+}
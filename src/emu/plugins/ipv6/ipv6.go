@@ -125,7 +125,8 @@ type PluginIpv6Client struct {
 
 var icmpEvents = []string{core.MSG_UPDATE_IPV6_ADDR,
 	core.MSG_UPDATE_DGIPV6_ADDR,
-	core.MSG_UPDATE_DIPV6_ADDR}
+	core.MSG_UPDATE_DIPV6_ADDR,
+	core.MSG_CLIENT_ACTIVATED}
 
 /*NewIpv6Client create plugin */
 func NewIpv6Client(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -141,6 +142,12 @@ func NewIpv6Client(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 
 /*OnEvent support event change of IP  */
 func (o *PluginIpv6Client) OnEvent(msg string, a, b interface{}) {
+	if msg == core.MSG_CLIENT_ACTIVATED {
+		if o.Client.Mac == o.ipv6NsPlug.mld.designatorMac {
+			o.ipv6NsPlug.mld.onDesignatorActivated()
+		}
+		return
+	}
 	o.nd.OnEvent(msg, a, b)
 }
 
@@ -161,7 +168,7 @@ func (o *PluginIpv6Client) StartPing(data *ApiIpv6StartPingHandler) bool {
 	}
 	o.pingData = data
 	params := ping.PingParams{Amount: data.Amount, Pace: data.Pace, Timeout: data.Timeout}
-	o.ping = ping.NewPing(params, o.Ns, o)
+	o.ping = ping.NewPing(params, o.Client, o)
 	o.ping.StartPinging()
 	return true
 }
@@ -284,6 +291,10 @@ type PluginIpv6Ns struct {
 	nd    NdNsCtx
 }
 
+func (o *PluginIpv6Ns) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewIpv6Ns(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginIpv6Ns)
 	o.InitPluginBase(ctx, o)
@@ -24,7 +24,6 @@ import (
 	"external/google/gopacket/layers"
 	"fmt"
 	"math"
-	"math/rand"
 	"net"
 	"sort"
 	"unsafe"
@@ -150,6 +149,7 @@ type mldNsStats struct {
 	pktSndAddRemoveReports    uint64 /* explicit reports dur to add/remove command */
 	pktNoDesignatorClient     uint64 /* There is no designator client with this MAC addr */
 	pktNoDesignatorClientIPv6 uint64 /* there designator client does not have valid IPv4 addr */
+	pktDesignatorNotActive    uint64 /* the designator client waits for the ramp-up */
 
 	opsAdd       uint64 /* add mc addr*/
 	opsRemove    uint64 /* add mc addr*/
@@ -412,6 +412,14 @@ func NewMldNsStatsDb(o *mldNsStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktDesignatorNotActive,
+		Name:     "pktDesignatorNotActive",
+		Help:     "designator client is not active yet, reports are sent on activation",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.pktNoDesignatorClientIPv6,
 		Name:     "pktNoDesignatorClientIPv6",
@@ -1180,7 +1188,7 @@ func (o *mldNsCtx) HandleRxMldCmn(isGenQuery bool, mldAddr core.Ipv6Key) int {
 				if o.base.Tctx.Simulation {
 					startTick = uint32(1) / o.timerw.MinTickMsec()
 				} else {
-					startTick = uint32(rand.Intn(int(maxRespMsec))+1) / o.timerw.MinTickMsec()
+					startTick = uint32(o.base.Ns.Rand().Intn(int(maxRespMsec))+1) / o.timerw.MinTickMsec()
 				}
 				o.ticks = 0
 				o.pktPerTick = 1
@@ -1273,12 +1281,24 @@ func (o *mldNsCtx) SendMcPacketv1(client *core.CClient,
 	o.base.Tctx.Veth.Send(m)
 }
 
+// onDesignatorActivated reports the groups that were added while the designator client waited for the ramp-up,
+// as if a general query was received
+func (o *mldNsCtx) onDesignatorActivated() {
+	if len(o.tbl.mapIgmp) > 0 && !o.activeQuery {
+		o.HandleRxMldCmn(true, core.Ipv6Key{})
+	}
+}
+
 func (o *mldNsCtx) getClient() *core.CClient {
 	client := o.base.Ns.CLookupByMac(&o.designatorMac)
 	if client == nil {
 		o.stats.pktNoDesignatorClient++
 		return nil
 	}
+	if !client.IsActive() {
+		o.stats.pktDesignatorNotActive++
+		return nil
+	}
 	return client
 }
 
@@ -236,6 +236,7 @@ func NewIpv6NsStatsDb(o *Ipv6NsStats) *core.CCounterDb {
 		Help:     "ipv6 nd table active",
 		Unit:     "entries",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tblAdd,
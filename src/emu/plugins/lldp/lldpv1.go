@@ -78,6 +78,10 @@ type PluginLldpClient struct {
 	pktTemplate []byte
 }
 
+func (o *PluginLldpClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 var lldpEvents = []string{}
 
 /*NewLldpClient create plugin */
@@ -21,6 +21,7 @@ import (
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/intel-go/fastjson"
 )
@@ -37,18 +38,30 @@ var Ipv6McastMacAddress core.MACKey = core.MACKey{0x33, 0x33, 0x00, 0x00, 0x00,
 
 // MDnsClientStats defines a number of stats for an mDNS client.
 type MDnsClientStats struct {
-	invalidInitJson      uint64 // Error while decoding client init Json
-	invalidSocket        uint64 // Error while creating socket
-	socketWriteError     uint64 // Error while writing on a socket
-	pktTxMDnsQuery       uint64 // Num of mDNS queries transmitted
-	pktRxMDnsQuery       uint64 // Num of mDNS queries received
-	pktTxMDnsResponse    uint64 // Num of mDNS responses transmitted
-	ipv6QueryNoPlugin    uint64 // Num of Ipv6 queries that can't be sent because no IPv6
-	ipv6ResponseNoPlugin uint64 // Num of Ipv6 queries that can't be answered because no IPv6
-	queryAAAANoIpv6      uint64 // Num of AAAA queries that can't be answered because of no IPv6
-	queryPTRNoDomainName uint64 // Num of PTR queries that can't be answered because domain name unspecified
-	queryTXTNoTxtDefined uint64 // Num of TXT queries that can't be answered because txt unspecified
-	unsupportedDnsType   uint64 // Num of queries received with an unsupported type
+	invalidInitJson       uint64 // Error while decoding client init Json
+	invalidSocket         uint64 // Error while creating socket
+	socketWriteError      uint64 // Error while writing on a socket
+	pktTxMDnsQuery        uint64 // Num of mDNS queries transmitted
+	pktRxMDnsQuery        uint64 // Num of mDNS queries received
+	pktTxMDnsResponse     uint64 // Num of mDNS responses transmitted
+	ipv6QueryNoPlugin     uint64 // Num of Ipv6 queries that can't be sent because no IPv6
+	ipv6ResponseNoPlugin  uint64 // Num of Ipv6 queries that can't be answered because no IPv6
+	queryAAAANoIpv6       uint64 // Num of AAAA queries that can't be answered because of no IPv6
+	queryPTRNoDomainName  uint64 // Num of PTR queries that can't be answered because domain name unspecified
+	queryTXTNoTxtDefined  uint64 // Num of TXT queries that can't be answered because txt unspecified
+	unsupportedDnsType    uint64 // Num of queries received with an unsupported type
+	invalidService        uint64 // Num of services that couldn't be added
+	pktTxMDnsAnnounce     uint64 // Num of mDNS service announcements transmitted
+	pktTxMDnsGoodbye      uint64 // Num of mDNS service goodbyes transmitted
+	pktTxMDnsProbe        uint64 // Num of mDNS probes transmitted
+	namesClaimed          uint64 // Num of names claimed after probing
+	probeConflict         uint64 // Num of conflicts detected while probing
+	probeTieBreakWon      uint64 // Num of simultaneous probe tie-breaks won
+	probeTieBreakLost     uint64 // Num of simultaneous probe tie-breaks lost
+	probeRateLimited      uint64 // Num of times probing was rate limited because of too many conflicts
+	claimConflict         uint64 // Num of conflicts detected for claimed names
+	nameRenamed           uint64 // Num of hosts and service instances renamed because of a conflict
+	knownAnswerSuppressed uint64 // Num of records not sent because the querier knows them
 }
 
 // NewMDnsClientStatsDb creates a new counter database for MDnsClientStats.
@@ -151,6 +164,102 @@ func NewMDnsClientStatsDb(o *MDnsClientStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidService,
+		Name:     "invalidService",
+		Help:     "Num of services that couldn't be added",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxMDnsAnnounce,
+		Name:     "pktTxMDnsAnnounce",
+		Help:     "Num of mDNS service announcements transmitted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxMDnsGoodbye,
+		Name:     "pktTxMDnsGoodbye",
+		Help:     "Num of mDNS service goodbyes transmitted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxMDnsProbe,
+		Name:     "pktTxMDnsProbe",
+		Help:     "Num of mDNS probes transmitted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.namesClaimed,
+		Name:     "namesClaimed",
+		Help:     "Num of names claimed after probing",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.probeConflict,
+		Name:     "probeConflict",
+		Help:     "Num of conflicts detected while probing",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.probeTieBreakWon,
+		Name:     "probeTieBreakWon",
+		Help:     "Num of simultaneous probe tie-breaks won",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.probeTieBreakLost,
+		Name:     "probeTieBreakLost",
+		Help:     "Num of simultaneous probe tie-breaks lost",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.probeRateLimited,
+		Name:     "probeRateLimited",
+		Help:     "Num of times probing was rate limited because of too many conflicts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.claimConflict,
+		Name:     "claimConflict",
+		Help:     "Num of conflicts detected for claimed names",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nameRenamed,
+		Name:     "nameRenamed",
+		Help:     "Num of hosts and service instances renamed because of a conflict",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.knownAnswerSuppressed,
+		Name:     "knownAnswerSuppressed",
+		Help:     "Num of records not sent because the querier knows them",
+		Unit:     "records",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	return db
 }
 
@@ -167,6 +276,11 @@ type MDnsNsStats struct {
 	rxAnswers              uint64 // Num of mDNS answers received in namespace
 	rxAuthoritiesUnhandled uint64 // Num of authorities unhandled in Rx packets
 	rxAddRecordsUnhandled  uint64 // Num of additional records unhandled in Rx packets
+	rxAddRecords           uint64 // Num of mDNS additional records received in namespace
+	rxGoodbyes             uint64 // Num of mDNS goodbye records (TTL 0) received in namespace
+	rxProbes               uint64 // Num of mDNS probes received in namespace
+	rxKnownAnswers         uint64 // Num of known answers received in queries
+	rxTruncatedQueries     uint64 // Num of queries received with the TC bit, followed by more known answers
 	autoPlayClientNotFound uint64 // Auto Play client was not found
 	clientNoMDns           uint64 // Auto Play client doesn't have MDns plugin
 	autoPlayQueries        uint64 // Number of queries sent by Auto Play
@@ -265,6 +379,46 @@ func NewMDnsNsStatsDb(o *MDnsNsStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxAddRecords,
+		Name:     "rxAddRecords",
+		Help:     "Num of mDNS additional records received in namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxGoodbyes,
+		Name:     "rxGoodbyes",
+		Help:     "Num of mDNS goodbye records (TTL 0) received in namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxProbes,
+		Name:     "rxProbes",
+		Help:     "Num of mDNS probes received in namespace",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxKnownAnswers,
+		Name:     "rxKnownAnswers",
+		Help:     "Num of known answers received in queries",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxTruncatedQueries,
+		Name:     "rxTruncatedQueries",
+		Help:     "Num of queries received with the TC bit, followed by more known answers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.autoPlayClientNotFound,
 		Name:     "autoPlayClientNotFound",
@@ -304,26 +458,36 @@ var mdnsEvents = []string{}
 
 // MDnsClientParams represents the entries of the Init Json passed to a new MDns client.
 type MDnsClientParams struct {
-	Hosts       []string           `json:"hosts"`               // Hosts owned by this client. Note that this can include IP addresses for PTR support.
-	DomainName  string             `json:"domain_name"`         // Domain name for the client in case of PTR query.
-	Txt         []utils.TxtEntries `json:"txt" validate:"dive"` // Txt to answer in case the TXT query.
-	ResponseTTL uint32             `json:"ttl"`                 // TTL for response. Will override the default value if provided.
+	Hosts       []string            `json:"hosts"`                    // Hosts owned by this client. Note that this can include IP addresses for PTR support.
+	DomainName  string              `json:"domain_name"`              // Domain name for the client in case of PTR query.
+	Txt         []utils.TxtEntries  `json:"txt" validate:"dive"`      // Txt to answer in case the TXT query.
+	ResponseTTL uint32              `json:"ttl"`                      // TTL for response. Will override the default value if provided.
+	Services    []MDnsServiceParams `json:"services" validate:"dive"` // DNS-SD service instances advertised by this client.
+	Probe       bool                `json:"probe"`                    // Probe the hosts and service instances before claiming them, rename them on conflict.
 }
 
 // PluginMDNsClient represents a MDns client.
 type PluginMDnsClient struct {
-	core.PluginBase                      // Plugin Base embedded struct so we get all the base functionality
-	mDnsNsPlugin    *PluginMDnsNs        // Pointer to mDNS namespace plugin
-	params          MDnsClientParams     // Init Json params
-	socketIpv4      transport.SocketApi  // Socket API for IPv4
-	socketIpv6      transport.SocketApi  // Socket API for IPv6. Might be nil in case there is no IPv6.
-	stats           MDnsClientStats      // mDNS client statistics
-	cdb             *core.CCounterDb     // Counters database
-	cdbv            *core.CCounterDbVec  // Counters database vector
-	hosts           map[string]bool      // Keep a set of hosts for fast lookup
-	dnsPktBuilder   *utils.DnsPktBuilder // Dns Packet Builder.
-	domainName      []byte               // Domain Name as a byte slice if provided.
-	txts            [][]byte             // Txt byte array for TXT queries
+	core.PluginBase                         // Plugin Base embedded struct so we get all the base functionality
+	mDnsNsPlugin    *PluginMDnsNs           // Pointer to mDNS namespace plugin
+	params          MDnsClientParams        // Init Json params
+	socketIpv4      transport.SocketApi     // Socket API for IPv4
+	socketIpv6      transport.SocketApi     // Socket API for IPv6. Might be nil in case there is no IPv6.
+	stats           MDnsClientStats         // mDNS client statistics
+	cdb             *core.CCounterDb        // Counters database
+	cdbv            *core.CCounterDbVec     // Counters database vector
+	hosts           map[string]bool         // Keep a set of hosts for fast lookup
+	dnsPktBuilder   *utils.DnsPktBuilder    // Dns Packet Builder.
+	domainName      []byte                  // Domain Name as a byte slice if provided.
+	txts            [][]byte                // Txt byte array for TXT queries
+	services        map[string]*mDnsService // DNS-SD services by instance name (lower case)
+	serviceNames    map[string]int          // Num of services answering for each DNS-SD name (lower case)
+	announcer       mDnsAnnouncer           // Announces the services
+	prober          mDnsProber              // Probes the hosts and service instances
+}
+
+func (o *PluginMDnsClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
 }
 
 // NewMDnsClient creates a new MDns client.
@@ -358,7 +522,7 @@ func (o *PluginMDnsClient) OnCreate() (err error) {
 	o.mDnsNsPlugin.RegisterClientHosts(o.params.Hosts, o)
 
 	// pktBuilder
-	o.dnsPktBuilder = utils.NewDnsPktBuilder(true)
+	o.dnsPktBuilder = utils.NewDnsPktBuilder(true, o.Ns.Rand())
 
 	var ioctlMap transport.IoctlMap = make(map[string]interface{})
 	// According to RFC 3171 a packet sent to the Local Network Control Block (224.0.0.0/24)
@@ -395,6 +559,40 @@ func (o *PluginMDnsClient) OnCreate() (err error) {
 		o.domainName = []byte(o.params.DomainName)
 	}
 	o.txts = utils.BuildTxtsFromTxtEntries(o.params.Txt) // Convert Txt entries to []byte
+
+	// probe the hosts, a host already owned by another client is renamed as in case of a conflict
+	o.prober.client = o
+	o.prober.timerw = o.Tctx.GetTimerCtx()
+	o.prober.timer.SetCB(&o.prober, 0, 0)
+	o.prober.names = make(map[string]string)
+	if o.params.Probe {
+		for _, host := range o.params.Hosts {
+			if o.mDnsNsPlugin.mapHostClient[host] != o {
+				o.stats.nameRenamed++
+				o.renameHost(host)
+			}
+		}
+		o.prober.add(o.params.Hosts)
+	}
+
+	// add the DNS-SD services
+	o.services = make(map[string]*mDnsService)
+	o.serviceNames = make(map[string]int)
+	o.announcer.client = o
+	o.announcer.timerw = o.Tctx.GetTimerCtx()
+	o.announcer.timer.SetCB(&o.announcer, 0, 0)
+	err = o.AddServices(o.params.Services)
+	if err != nil {
+		// the client is rejected, it must not probe or announce the hosts
+		o.prober.stop()
+		o.announcer.stop()
+		for _, socket := range o.sockets() {
+			socket.Close()
+		}
+		o.socketIpv4, o.socketIpv6 = nil, nil
+		o.mDnsNsPlugin.UnregisterHosts(o.params.Hosts)
+		return err
+	}
 	return nil
 }
 
@@ -404,6 +602,10 @@ func (o *PluginMDnsClient) OnEvent(msg string, a, b interface{}) {}
 // OnRemove is called when we remove the mDNS client.
 func (o *PluginMDnsClient) OnRemove(ctx *core.PluginCtx) {
 	ctx.UnregisterEvents(&o.PluginBase, mdnsEvents)
+	// Send goodbye for the services and unregister them from namespace database.
+	o.goodbye(o.getServices())
+	o.announcer.stop()
+	o.prober.stop()
 	// Unregister hosts from namespace database.
 	o.mDnsNsPlugin.UnregisterHosts(o.params.Hosts)
 }
@@ -475,8 +677,27 @@ func (o *PluginMDnsClient) QueryHandler(queries []MDnsQueryParams) error {
 
 // BuildAnswers builds answers based on mDNS questions.
 func (o *PluginMDnsClient) BuildAnswers(questions []layers.DNSQuestion) []layers.DNSResourceRecord {
-	var answers []layers.DNSResourceRecord
+	answers, _ := o.buildRecords(questions)
+	return answers
+}
+
+// buildRecords builds the answers and additional records based on mDNS questions.
+func (o *PluginMDnsClient) buildRecords(questions []layers.DNSQuestion) (answers, additionals []layers.DNSResourceRecord) {
 	for _, q := range questions {
+		if o.isServiceName(string(q.Name)) {
+			serviceAnswers, serviceAdditionals := o.buildServiceRecords(&q)
+			answers = append(answers, serviceAnswers...)
+			additionals = append(additionals, serviceAdditionals...)
+			continue
+		}
+		if q.Type == layers.DNSTypeANY {
+			// Answer with the addresses of the host, for example to defend it against a probe.
+			for _, answer := range o.addressRecords(string(q.Name), o.params.ResponseTTL) {
+				answer.Class = q.Class
+				answers = append(answers, answer)
+			}
+			continue
+		}
 		answer := layers.DNSResourceRecord{
 			Name:  []byte(q.Name),
 			Type:  q.Type,
@@ -512,23 +733,33 @@ func (o *PluginMDnsClient) BuildAnswers(questions []layers.DNSQuestion) []layers
 		}
 		answers = append(answers, answer)
 	}
-	return answers
+	if additionals != nil {
+		answers = uniqueRecords(answers, nil)
+		additionals = uniqueRecords(additionals, answers)
+	}
+	return answers, additionals
 }
 
-// Reply sends a mDNS response after a query was received.
-func (o *PluginMDnsClient) Reply(transactionId uint16, questions []layers.DNSQuestion, socket transport.SocketApi) error {
+// Reply sends a mDNS response after a query was received. The records in knownAnswers are not sent.
+func (o *PluginMDnsClient) Reply(transactionId uint16, questions []layers.DNSQuestion, knownAnswers []layers.DNSResourceRecord, socket transport.SocketApi) error {
 
-	answers := o.BuildAnswers(questions)
+	answers, additionals := o.buildRecords(questions)
 	if answers == nil {
-		// Nothing we can answer, respective error counters are set in BuildAnswers.
+		// Nothing we can answer, respective error counters are set in buildRecords.
 		return fmt.Errorf("Couldn't answer any query.")
 	}
+	answers = o.suppressKnownAnswers(answers, knownAnswers)
+	if len(answers) == 0 {
+		// The querier knows all the answers.
+		return nil
+	}
+	additionals = o.suppressKnownAnswers(additionals, knownAnswers)
 
 	if socket == nil {
 		return fmt.Errorf("Invalid Socket in Reply!")
 	}
 
-	transportErr, _ := socket.Write(o.dnsPktBuilder.BuildResponsePkt(0, answers, []layers.DNSQuestion{}, layers.DNSResponseCodeNoErr))
+	transportErr, _ := socket.Write(o.dnsPktBuilder.BuildAuthResponsePkt(0, answers, nil, additionals, []layers.DNSQuestion{}, layers.DNSResponseCodeNoErr))
 	if transportErr != transport.SeOK {
 		o.stats.socketWriteError++
 		return transportErr.Error()
@@ -537,13 +768,17 @@ func (o *PluginMDnsClient) Reply(transactionId uint16, questions []layers.DNSQue
 	return nil
 }
 
-// HandleRxMDnsQuestions filters the questions and replies to the ones the client can.
-func (o *PluginMDnsClient) HandleRxMDnsQuestions(questions []layers.DNSQuestion, ipv6 bool) {
+// HandleRxMDnsQuestions filters the questions and replies to the ones the client can, without the known answers.
+func (o *PluginMDnsClient) HandleRxMDnsQuestions(questions []layers.DNSQuestion, knownAnswers []layers.DNSResourceRecord, ipv6 bool) {
 	// filter the questions so we get only this client's questions (cQuestions)
 	var cQuestions []layers.DNSQuestion
 	for i := range questions {
 		q := questions[i]
-		if _, ok := o.hosts[string(q.Name)]; ok {
+		if o.prober.isProbing(string(q.Name)) {
+			// Names are not answered before they are claimed.
+			continue
+		}
+		if _, ok := o.hosts[string(q.Name)]; ok || o.isServiceName(string(q.Name)) {
 			cQuestions = append(cQuestions, q)
 		}
 	}
@@ -560,9 +795,9 @@ func (o *PluginMDnsClient) HandleRxMDnsQuestions(questions []layers.DNSQuestion,
 				o.stats.ipv6ResponseNoPlugin++
 				return
 			}
-			o.Reply(0, cQuestions, o.socketIpv6)
+			o.Reply(0, cQuestions, knownAnswers, o.socketIpv6)
 		} else {
-			o.Reply(0, cQuestions, o.socketIpv4)
+			o.Reply(0, cQuestions, knownAnswers, o.socketIpv4)
 		}
 	}
 }
@@ -583,6 +818,9 @@ func (o *PluginMDnsClient) AddHosts(hosts []string) []string {
 			alreadyExistingHosts = append(alreadyExistingHosts, host) // already existing
 		}
 	}
+	if o.params.Probe {
+		o.prober.add(newHosts)
+	}
 	return alreadyExistingHosts
 }
 
@@ -595,6 +833,7 @@ func (o *PluginMDnsClient) RemoveHosts(hosts []string) []string {
 			nonExistingHosts = append(nonExistingHosts, host)
 		}
 		delete(o.hosts, host)
+		o.prober.remove(host)
 	}
 	o.mDnsNsPlugin.UnregisterHosts(hosts)
 	return nonExistingHosts
@@ -640,25 +879,35 @@ type MDnsNsParams struct {
 
 // PluginMDnsNs represents the mDNS plugin in namespace level.
 type PluginMDnsNs struct {
-	core.PluginBase                              // Embed plugin base
-	params          MDnsNsParams                 // Namespace Paramaters
-	mapHostClient   map[string]*PluginMDnsClient // Map hosts to client database
-	stats           MDnsNsStats                  // mDns namespace statistics
-	autoPlayParams  MDnsAutoPlayParams           // mDns auto play params in case provided
-	cdb             *core.CCounterDb             // mDns counters
-	cdbv            *core.CCounterDbVec          // mDns counter vector
-	cache           *utils.DnsCache              // mDns cache
-	autoPlay        *utils.DnsNsAutoPlay         // mDNS program autoplay
+	core.PluginBase                                         // Embed plugin base
+	params            MDnsNsParams                          // Namespace Paramaters
+	mapHostClient     map[string]*PluginMDnsClient          // Map hosts to client database
+	mapServiceClients map[string]map[*PluginMDnsClient]bool // Map DNS-SD names (lower case) to clients database
+	truncatedQueries  map[string]*mDnsTruncatedQuery        // Queries waiting for more known answers, by source
+	timerw            *core.TimerCtx                        // Timer wheel
+	stats             MDnsNsStats                           // mDns namespace statistics
+	autoPlayParams    MDnsAutoPlayParams                    // mDns auto play params in case provided
+	cdb               *core.CCounterDb                      // mDns counters
+	cdbv              *core.CCounterDbVec                   // mDns counter vector
+	cache             *utils.DnsCache                       // mDns cache
+	autoPlay          *utils.DnsNsAutoPlay                  // mDNS program autoplay
+}
+
+func (o *PluginMDnsNs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
 }
 
 // NewMDnsNs creates a new mDNS namespace plugin.
 func NewMDnsNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginMDnsNs)
-	o.InitPluginBase(ctx, o)                             // Init the base plugin
-	o.RegisterEvents(ctx, []string{}, o)                 // No events to register in namespace level.
-	o.cache = utils.NewDnsCache(ctx.Tctx.GetTimerCtx())  // Create cache
-	o.mapHostClient = make(map[string]*PluginMDnsClient) // Create hosts -> client database
-	o.cdb = NewMDnsNsStatsDb(&o.stats)                   // Create new stats database
+	o.InitPluginBase(ctx, o)                                          // Init the base plugin
+	o.RegisterEvents(ctx, []string{}, o)                              // No events to register in namespace level.
+	o.cache = utils.NewDnsCache(ctx.Tctx.GetTimerCtx())               // Create cache
+	o.mapHostClient = make(map[string]*PluginMDnsClient)              // Create hosts -> client database
+	o.mapServiceClients = make(map[string]map[*PluginMDnsClient]bool) // Create DNS-SD names -> clients database
+	o.truncatedQueries = make(map[string]*mDnsTruncatedQuery)         // Create truncated queries database
+	o.timerw = ctx.Tctx.GetTimerCtx()
+	o.cdb = NewMDnsNsStatsDb(&o.stats) // Create new stats database
 	o.cdbv = core.NewCCounterDbVec(MDNS_PLUG)
 	o.cdbv.Add(o.cdb)
 
@@ -694,6 +943,9 @@ func (o *PluginMDnsNs) OnRemove(ctx *core.PluginCtx) {
 	if o.autoPlay != nil {
 		o.autoPlay.OnRemove()
 	}
+	for _, q := range o.truncatedQueries {
+		o.timerw.Stop(&q.timer)
+	}
 	_ = utils.NewDnsCacheRemover(o.cache, ctx.Tctx.GetTimerCtx())
 	o.cache = nil // GC can now remove the namespace plugin.
 }
@@ -701,8 +953,8 @@ func (o *PluginMDnsNs) OnRemove(ctx *core.PluginCtx) {
 // OnEvent for events the namespace plugin is registered.
 func (o *PluginMDnsNs) OnEvent(msg string, a, b interface{}) {}
 
-// HandleRxMDnsQuestions handles an incoming mDns packet's questions.
-func (o *PluginMDnsNs) HandleRxMDnsQuestions(questions []layers.DNSQuestion, ipv6 bool) {
+// HandleRxMDnsQuestions handles an incoming mDns packet's questions and known answers.
+func (o *PluginMDnsNs) HandleRxMDnsQuestions(questions []layers.DNSQuestion, knownAnswers []layers.DNSResourceRecord, ipv6 bool) {
 	// Collect clients that can answer at least one question by hostname in a set.
 	// Then let all these clients answer all the questions they can.
 	relevantClients := make(map[*PluginMDnsClient]bool, 0)
@@ -715,9 +967,15 @@ func (o *PluginMDnsNs) HandleRxMDnsQuestions(questions []layers.DNSQuestion, ipv
 		}
 		relevantClients[c] = true // Event it already exists
 	}
+	// DNS-SD names can be shared by many clients, for example the service type.
+	for i := range questions {
+		for c := range o.GetClientsByServiceName(string(questions[i].Name)) {
+			relevantClients[c] = true
+		}
+	}
 	// all the clients in relevantClients can answer at least one question
 	for c := range relevantClients {
-		c.HandleRxMDnsQuestions(questions, ipv6)
+		c.HandleRxMDnsQuestions(questions, knownAnswers, ipv6)
 	}
 }
 
@@ -747,6 +1005,7 @@ func (o *PluginMDnsNs) HandleRxMDnsPacket(ps *core.ParserPacketState) int {
 	ethType = layers.EthernetType(ethHeader.GetNextProtocol())
 
 	var ipv6 bool
+	var src string // source IP, to collect the known answers of a truncated query
 
 	if ethType == layers.EthernetTypeIPv6 {
 		ipv6 = true
@@ -776,6 +1035,7 @@ func (o *PluginMDnsNs) HandleRxMDnsPacket(ps *core.ParserPacketState) int {
 		expDstIp := net.IP(net.ParseIP(host))
 		ipv6 := layers.IPv6Header(p[ps.L3 : ps.L3+40])
 		dstIp := net.IP(ipv6.DstIP())
+		src = net.IP(ipv6.SrcIP()).String()
 		if !expDstIp.Equal(dstIp) {
 			o.stats.pktRxBadDstIp++
 			return core.PARSER_ERR
@@ -792,6 +1052,9 @@ func (o *PluginMDnsNs) HandleRxMDnsPacket(ps *core.ParserPacketState) int {
 		ipv4 := layers.IPv4Header(p[ps.L3 : ps.L3+20])
 		var dstIp core.Ipv4Key
 		dstIp.SetUint32(ipv4.GetIPDst())
+		var srcIp core.Ipv4Key
+		srcIp.SetUint32(ipv4.GetIPSrc())
+		src = srcIp.ToIP().String()
 		if !expDstIp.Equal(dstIp.ToIP()) {
 			o.stats.pktRxBadDstIp++
 			return core.PARSER_ERR
@@ -817,24 +1080,53 @@ func (o *PluginMDnsNs) HandleRxMDnsPacket(ps *core.ParserPacketState) int {
 		return core.PARSER_ERR
 	}
 
+	if !dns.QR {
+		// Query, the answers are known answers and the authorities are the proposed records of a probe.
+		o.stats.rxQuestions += uint64(dns.QDCount)
+		o.stats.rxKnownAnswers += uint64(dns.ANCount)
+		if dns.NSCount > 0 {
+			o.handleProbe(dns.Authorities)
+		}
+		o.stats.rxAddRecordsUnhandled += uint64(dns.ARCount)
+		o.handleQuery(&dns, src, ipv6)
+		return 0
+	}
+
 	if dns.QDCount > 0 {
 		o.stats.rxQuestions += uint64(dns.QDCount)
-		o.HandleRxMDnsQuestions(dns.Questions, ipv6)
+		o.HandleRxMDnsQuestions(dns.Questions, nil, ipv6)
 	}
 	if dns.ANCount > 0 {
 		o.stats.rxAnswers += uint64(dns.ANCount)
-		utils.AddAnswersToCache(o.cache, dns.Answers)
+		o.handleResponse(dns.Answers)
+		o.addRecordsToCache(dns.Answers)
 	}
 	if dns.NSCount > 0 {
 		o.stats.rxAuthoritiesUnhandled += uint64(dns.NSCount)
 	}
 	if dns.ARCount > 0 {
-		o.stats.rxAddRecordsUnhandled += uint64(dns.ARCount)
+		o.stats.rxAddRecords += uint64(dns.ARCount)
+		o.handleResponse(dns.Additionals)
+		handled := o.addRecordsToCache(dns.Additionals)
+		o.stats.rxAddRecordsUnhandled += uint64(len(dns.Additionals) - handled)
 	}
 
 	return 0
 }
 
+// addRecordsToCache adds the records of a response to the cache, records with TTL 0 are goodbyes.
+// Returns the number of records handled.
+func (o *PluginMDnsNs) addRecordsToCache(records []layers.DNSResourceRecord) int {
+	for i := range records {
+		// The cache flush bit is not part of the class, RFC 6762 section 10.2.
+		records[i].Class &^= MDnsCacheFlushBit
+		if records[i].TTL == 0 {
+			o.stats.rxGoodbyes++
+		}
+	}
+	return utils.AddAnswersToCache(o.cache, records)
+}
+
 // SetTruncated to complete the gopacket.DecodeFeedback interface.
 func (o *PluginMDnsNs) SetTruncated() {}
 
@@ -859,6 +1151,29 @@ func (o *PluginMDnsNs) UnregisterHosts(hosts []string) {
 	}
 }
 
+// RegisterServiceName registers a DNS-SD name of a client. Many clients can register the same name.
+func (o *PluginMDnsNs) RegisterServiceName(name string, c *PluginMDnsClient) {
+	name = strings.ToLower(name)
+	if o.mapServiceClients[name] == nil {
+		o.mapServiceClients[name] = make(map[*PluginMDnsClient]bool)
+	}
+	o.mapServiceClients[name][c] = true
+}
+
+// UnregisterServiceName removes a DNS-SD name of a client from the map.
+func (o *PluginMDnsNs) UnregisterServiceName(name string, c *PluginMDnsClient) {
+	name = strings.ToLower(name)
+	delete(o.mapServiceClients[name], c)
+	if len(o.mapServiceClients[name]) == 0 {
+		delete(o.mapServiceClients, name)
+	}
+}
+
+// GetClientsByServiceName returns the set of clients which registered a DNS-SD name, nil if none.
+func (o *PluginMDnsNs) GetClientsByServiceName(name string) map[*PluginMDnsClient]bool {
+	return o.mapServiceClients[strings.ToLower(name)]
+}
+
 // GetClientByHost returns the client owning the hostname if such one exits. If not, it will return an error.
 func (o *PluginMDnsNs) GetClientByHost(host string) (*PluginMDnsClient, error) {
 	c, ok := o.mapHostClient[host]
@@ -994,8 +1309,17 @@ type (
 		Op    bool     `json:"op"`    // false for add, true for remove
 		Hosts []string `json:"hosts"` // hosts to add/remove
 	}
-	ApiMDnsGetHostsHandler struct{} // Get Hosts of a client
-	ApiMDnsCacheIterParams struct {
+	ApiMDnsGetHostsHandler    struct{} // Get Hosts of a client
+	ApiMDnsAddServicesHandler struct{} // Add DNS-SD services handler.
+	ApiMDnsAddServicesParams  struct {
+		Services []MDnsServiceParams `json:"services" validate:"required,dive"` // services to add
+	}
+	ApiMDnsRemoveServicesHandler struct{} // Remove DNS-SD services handler.
+	ApiMDnsRemoveServicesParams  struct {
+		Instances []string `json:"instances" validate:"required"` // instance names (<Instance>.<Service>.<Domain>) to remove
+	}
+	ApiMDnsGetServicesHandler struct{} // Get DNS-SD services of a client
+	ApiMDnsCacheIterParams    struct {
 		Reset bool   `json:"reset"`
 		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
 	} // Params for a namespace cache iteration
@@ -1143,6 +1467,76 @@ func (h ApiMDnsGetHostsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return c.GetHosts(), nil
 }
 
+// ApiMDnsAddServicesHandler handles the RPC add services request.
+func (h ApiMDnsAddServicesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var p ApiMDnsAddServicesParams
+	tctx := ctx.(*core.CThreadCtx)
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = c.AddServices(p.Services)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	return nil, nil
+}
+
+// ApiMDnsRemoveServicesHandler handles the RPC remove services request.
+func (h ApiMDnsRemoveServicesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var p ApiMDnsRemoveServicesParams
+	tctx := ctx.(*core.CThreadCtx)
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	nonExistingInstances := c.RemoveServices(p.Instances)
+	return nonExistingInstances, nil
+}
+
+// ApiMDnsGetServicesHandler handles the RPC get services request.
+func (h ApiMDnsGetServicesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	return c.GetServices(), nil
+}
+
 // ApiMDnsCacheIterHandler handles the namespace cache iteration.
 func (h ApiMDnsCacheIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
@@ -1227,6 +1621,9 @@ func init() {
 	core.RegisterCB("mdns_c_add_remove_hosts", ApiMDnsAddRemoveHostsHandler{}, false) // add or remove hosts from client
 	core.RegisterCB("mdns_c_get_hosts", ApiMDnsGetHostsHandler{}, false)              // show the hosts of a client
 	core.RegisterCB("mdns_c_query", ApiMDnsQueryHandler{}, false)                     // query
+	core.RegisterCB("mdns_c_add_services", ApiMDnsAddServicesHandler{}, false)        // add DNS-SD services to client
+	core.RegisterCB("mdns_c_remove_services", ApiMDnsRemoveServicesHandler{}, false)  // remove DNS-SD services from client
+	core.RegisterCB("mdns_c_get_services", ApiMDnsGetServicesHandler{}, false)        // show the DNS-SD services of a client
 	core.RegisterCB("mdns_ns_cnt", ApiMDnsNsCntHandler{}, true)                       // get counters / meta per ns
 	core.RegisterCB("mdns_ns_cache_iter", ApiMDnsCacheIterHandler{}, false)           // iterate namespace cache
 	core.RegisterCB("mdns_ns_cache_flush", ApiMDnsCacheFlushHandler{}, false)         // flush the cache
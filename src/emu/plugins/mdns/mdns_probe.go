@@ -0,0 +1,535 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package mdns
+
+/*
+Probing and conflict resolution - RFC 6762 section 8 and 9 - https://tools.ietf.org/html/rfc6762#section-8
+
+When probing is enabled, a client doesn't answer for its unique names (hosts and service instances) before
+claiming them. It sends three probes, 250 ms apart, each a query of type ANY for the names with the proposed
+records in the authority section. If no conflict is received, the names are claimed and announced.
+
+	response with a record of a name being probed    -> rename (host-2, Instance (2)) and probe again
+	simultaneous probe with lexicographically later  -> lost the tie-break, wait one second and probe again
+	response with different data for a claimed name  -> probe the name again
+
+Known-answer suppression - RFC 6762 section 7.1 and 7.2
+
+A client doesn't answer with a record the querier already knows with at least half of the TTL. A query with
+the TC bit is followed by more known answers, the namespace collects them before answering.
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	utils "emu/plugins/dns_utils"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MDnsProbeCount        = 3                      // Num of probes, RFC 6762 section 8.1
+	MDnsProbeInterval     = 250 * time.Millisecond // Interval between probes
+	MDnsProbeDeferTime    = time.Second            // Wait after losing a simultaneous probe tie-break, RFC 6762 section 8.2
+	MDnsMaxProbeConflicts = 15                     // Num of conflicts after which probing is rate limited
+	MDnsProbeRateLimit    = 5 * time.Second        // Wait after too many conflicts
+	MDnsKnownAnswerDelay  = 450 * time.Millisecond // Wait for more known answers of a truncated query, RFC 6762 section 7.2
+)
+
+// mDnsProber probes the unique names of a client before they are claimed.
+type mDnsProber struct {
+	client    *PluginMDnsClient // Client whose names are probed
+	timerw    *core.TimerCtx    // Timer wheel
+	timer     core.CHTimerObj   // Timer between probes
+	names     map[string]string // Names being probed, lower case to name
+	left      int               // Num of probes left
+	conflicts int               // Num of conflicts since the names were last claimed
+}
+
+// isProbing returns true if name is being probed.
+func (o *mDnsProber) isProbing(name string) bool {
+	_, ok := o.names[strings.ToLower(name)]
+	return ok
+}
+
+// add adds names to probe and restarts probing.
+func (o *mDnsProber) add(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, name := range names {
+		o.names[strings.ToLower(name)] = name
+	}
+	o.restart(o.initialDelay())
+}
+
+// remove stops probing name, for example when the host is removed.
+func (o *mDnsProber) remove(name string) {
+	delete(o.names, strings.ToLower(name))
+}
+
+// initialDelay returns the delay before the first probe, random up to 250 ms, RFC 6762 section 8.1.
+func (o *mDnsProber) initialDelay() time.Duration {
+	if o.client.Tctx.Simulation {
+		return MDnsProbeInterval
+	}
+	return time.Duration(o.client.Ns.Rand().Int63n(int64(MDnsProbeInterval))) + time.Millisecond
+}
+
+// restart starts probing from the beginning after delay.
+func (o *mDnsProber) restart(delay time.Duration) {
+	o.left = MDnsProbeCount
+	o.stop()
+	o.timerw.Start(&o.timer, delay)
+}
+
+// stop stops probing.
+func (o *mDnsProber) stop() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+}
+
+// conflict restarts probing after a conflict. Probing is rate limited after too many conflicts.
+func (o *mDnsProber) conflict() {
+	o.conflicts++
+	if o.conflicts >= MDnsMaxProbeConflicts {
+		o.conflicts = 0
+		o.client.stats.probeRateLimited++
+		o.restart(MDnsProbeRateLimit)
+		return
+	}
+	o.restart(o.initialDelay())
+}
+
+// getNames returns the names being probed sorted.
+func (o *mDnsProber) getNames() []string {
+	names := make([]string, 0, len(o.names))
+	for _, name := range o.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OnEvent sends a probe, or claims the names and announces them once all the probes were sent.
+func (o *mDnsProber) OnEvent(a, b interface{}) {
+	if len(o.names) == 0 {
+		return
+	}
+	if o.left == 0 {
+		o.client.stats.namesClaimed += uint64(len(o.names))
+		o.names = make(map[string]string)
+		o.conflicts = 0
+		o.client.announcer.start()
+		return
+	}
+	o.client.probe(o.getNames())
+	o.left--
+	o.timerw.Start(&o.timer, MDnsProbeInterval)
+}
+
+// probe sends a probe for names on IPv4 and on IPv6 if the client has IPv6.
+func (o *PluginMDnsClient) probe(names []string) {
+	var questions []layers.DNSQuestion
+	var authorities []layers.DNSResourceRecord
+	for _, name := range names {
+		questions = append(questions, layers.DNSQuestion{Name: []byte(name), Type: layers.DNSTypeANY, Class: layers.DNSClassIN})
+		authorities = append(authorities, o.ownRecords(name)...)
+	}
+	for i := range authorities {
+		// The cache-flush bit is not set in the authority section of a probe, RFC 6762 section 10.2.
+		authorities[i].Class &^= MDnsCacheFlushBit
+	}
+	data := o.dnsPktBuilder.BuildProbePkt(questions, authorities)
+	for _, socket := range o.sockets() {
+		transportErr, _ := socket.Write(data)
+		if transportErr != transport.SeOK {
+			o.stats.socketWriteError++
+			continue
+		}
+		o.stats.pktTxMDnsProbe++
+	}
+}
+
+// uniqueName returns the host or service instance of the client that matches name, case insensitive.
+func (o *PluginMDnsClient) uniqueName(name string) (string, bool) {
+	if o.hosts[name] {
+		return name, true
+	}
+	for host := range o.hosts {
+		if strings.EqualFold(host, name) {
+			return host, true
+		}
+	}
+	if s, ok := o.services[strings.ToLower(name)]; ok {
+		return s.instance, true
+	}
+	return "", false
+}
+
+// ownRecords returns the unique records of a host or a service instance of the client.
+func (o *PluginMDnsClient) ownRecords(name string) []layers.DNSResourceRecord {
+	if s, ok := o.services[strings.ToLower(name)]; ok {
+		return []layers.DNSResourceRecord{s.srvRecord(o.params.ResponseTTL), s.txtRecord(o.params.ResponseTTL)}
+	}
+	return o.addressRecords(name, o.params.ResponseTTL)
+}
+
+// HandleRxMDnsProbe handles the proposed records of a probe received for a name of the client.
+func (o *PluginMDnsClient) HandleRxMDnsProbe(name string, records []layers.DNSResourceRecord) {
+	name, ok := o.uniqueName(name)
+	if !ok || !o.prober.isProbing(name) {
+		// Claimed names are defended by answering the question of the probe.
+		return
+	}
+	switch compareRecordSets(o.ownRecords(name), records) {
+	case 1:
+		o.stats.probeTieBreakWon++
+	case -1:
+		// Simultaneous probe tie-breaking, RFC 6762 section 8.2.
+		o.stats.probeTieBreakLost++
+		o.prober.restart(MDnsProbeDeferTime)
+	}
+}
+
+// HandleRxMDnsResponse handles the records of a response received for a name of the client.
+func (o *PluginMDnsClient) HandleRxMDnsResponse(name string, records []layers.DNSResourceRecord) {
+	name, ok := o.uniqueName(name)
+	if !ok {
+		return
+	}
+	own := make(map[string]bool)
+	types := make(map[layers.DNSType]bool)
+	ownRecords := o.ownRecords(name)
+	for i := range ownRecords {
+		own[knownAnswerKey(&ownRecords[i])] = true
+		types[ownRecords[i].Type] = true
+	}
+	if o.prober.isProbing(name) {
+		// Any record of the name which is not ours is a conflict, RFC 6762 section 8.1.
+		for i := range records {
+			if !own[knownAnswerKey(&records[i])] {
+				o.stats.probeConflict++
+				o.rename(name)
+				return
+			}
+		}
+		return
+	}
+	// A record of a claimed name with different data is a conflict, RFC 6762 section 9.
+	for i := range records {
+		if types[records[i].Type] && !own[knownAnswerKey(&records[i])] {
+			o.stats.claimConflict++
+			o.prober.add([]string{name})
+			return
+		}
+	}
+}
+
+// rename renames a host or a service instance after a conflict and probes the new name.
+func (o *PluginMDnsClient) rename(name string) {
+	o.stats.nameRenamed++
+	o.prober.remove(name)
+	var newName string
+	if s, ok := o.services[strings.ToLower(name)]; ok {
+		newName = o.renameService(s)
+	} else {
+		newName = o.renameHost(name)
+	}
+	o.prober.names[strings.ToLower(newName)] = newName
+	o.prober.conflict()
+}
+
+// renameHost renames a host to the next free name, updating the services that target it.
+// Returns the new name.
+func (o *PluginMDnsClient) renameHost(host string) string {
+	newHost := nextHostName(host)
+	for o.hosts[newHost] || o.mDnsNsPlugin.mapHostClient[newHost] != nil {
+		newHost = nextHostName(newHost)
+	}
+	delete(o.hosts, host)
+	if o.mDnsNsPlugin.mapHostClient[host] == o {
+		o.mDnsNsPlugin.UnregisterHosts([]string{host})
+	}
+	o.hosts[newHost] = true
+	o.mDnsNsPlugin.RegisterClientHosts([]string{newHost}, o)
+	for i := range o.params.Hosts {
+		if o.params.Hosts[i] == host {
+			o.params.Hosts[i] = newHost
+		}
+	}
+	for _, s := range o.services {
+		if s.params.Host == host {
+			s.params.Host = newHost
+		}
+	}
+	return newHost
+}
+
+// renameService renames a service instance to the next free name. Returns the new instance name.
+func (o *PluginMDnsClient) renameService(s *mDnsService) string {
+	o.unregisterService(s)
+	params := s.params
+	for {
+		params.Instance = nextInstanceName(params.Instance)
+		renamed, _ := newMDnsService(params)
+		if o.services[renamed.key()] == nil && o.mDnsNsPlugin.GetClientsByServiceName(renamed.instance) == nil {
+			o.registerService(renamed)
+			return renamed.instance
+		}
+	}
+}
+
+// nextHostName returns the next name of a host after a conflict, for example printer.local -> printer-2.local.
+func nextHostName(host string) string {
+	name, suffix := host, ""
+	if strings.HasSuffix(strings.ToLower(host), ".local") {
+		name, suffix = host[:len(host)-len(".local")], host[len(host)-len(".local"):]
+	}
+	n := 2
+	if i := strings.LastIndexByte(name, '-'); i >= 0 {
+		if v, err := strconv.Atoi(name[i+1:]); err == nil && v >= 2 {
+			name, n = name[:i], v+1
+		}
+	}
+	return fmt.Sprintf("%v-%v%v", name, n, suffix)
+}
+
+// nextInstanceName returns the next name of a service instance after a conflict, for example
+// Office Printer -> Office Printer (2), RFC 6763 appendix D.
+func nextInstanceName(instance string) string {
+	n := 2
+	if strings.HasSuffix(instance, ")") {
+		if i := strings.LastIndex(instance, " ("); i >= 0 {
+			if v, err := strconv.Atoi(instance[i+2 : len(instance)-1]); err == nil && v >= 2 {
+				instance, n = instance[:i], v+1
+			}
+		}
+	}
+	return fmt.Sprintf("%v (%v)", instance, n)
+}
+
+// wireName converts a name to its uncompressed wire format.
+func wireName(name []byte) []byte {
+	var b []byte
+	for _, label := range bytes.Split(name, []byte(".")) {
+		if len(label) > 0 {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// rdata returns the uncompressed data of a record for the tie-breaking.
+func rdata(rr *layers.DNSResourceRecord) []byte {
+	switch rr.Type {
+	case layers.DNSTypeA:
+		return rr.IP.To4()
+	case layers.DNSTypeAAAA:
+		return rr.IP.To16()
+	case layers.DNSTypePTR:
+		return wireName(rr.PTR)
+	case layers.DNSTypeSRV:
+		b := make([]byte, 6)
+		binary.BigEndian.PutUint16(b[0:], rr.SRV.Priority)
+		binary.BigEndian.PutUint16(b[2:], rr.SRV.Weight)
+		binary.BigEndian.PutUint16(b[4:], rr.SRV.Port)
+		return append(b, wireName(rr.SRV.Name)...)
+	case layers.DNSTypeTXT:
+		var b []byte
+		for _, txt := range rr.TXTs {
+			b = append(b, byte(len(txt)))
+			b = append(b, txt...)
+		}
+		return b
+	}
+	return rr.Data
+}
+
+// compareRecords compares two records by class (without the cache flush bit), type and data.
+func compareRecords(a, b *layers.DNSResourceRecord) int {
+	aClass, bClass := a.Class&^MDnsCacheFlushBit, b.Class&^MDnsCacheFlushBit
+	switch {
+	case aClass != bClass:
+		if aClass > bClass {
+			return 1
+		}
+		return -1
+	case a.Type != b.Type:
+		if a.Type > b.Type {
+			return 1
+		}
+		return -1
+	}
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+// compareRecordSets compares the proposed records of two probes for the tie-breaking, RFC 6762 section 8.2.
+// Returns 1 if ours is lexicographically later, -1 if earlier and 0 if identical.
+func compareRecordSets(ours, theirs []layers.DNSResourceRecord) int {
+	sortRecords := func(records []layers.DNSResourceRecord) []layers.DNSResourceRecord {
+		sorted := append([]layers.DNSResourceRecord{}, records...)
+		sort.SliceStable(sorted, func(i, j int) bool { return compareRecords(&sorted[i], &sorted[j]) < 0 })
+		return sorted
+	}
+	ours, theirs = sortRecords(ours), sortRecords(theirs)
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if cmp := compareRecords(&ours[i], &theirs[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	switch {
+	case len(ours) > len(theirs):
+		return 1
+	case len(ours) < len(theirs):
+		return -1
+	}
+	return 0
+}
+
+// knownAnswerKey returns a key which identifies the data of a record.
+func knownAnswerKey(rr *layers.DNSResourceRecord) string {
+	answer, ok := utils.RecordToAnswer(rr)
+	if !ok {
+		answer = string(rr.Data)
+	}
+	return fmt.Sprintf("%v/%v/%v/%v", strings.ToLower(string(rr.Name)), rr.Type, rr.Class&^MDnsCacheFlushBit, answer)
+}
+
+// suppressKnownAnswers removes the records the querier already knows with at least half of the TTL,
+// RFC 6762 section 7.1.
+func (o *PluginMDnsClient) suppressKnownAnswers(records, knownAnswers []layers.DNSResourceRecord) []layers.DNSResourceRecord {
+	if len(knownAnswers) == 0 {
+		return records
+	}
+	known := make(map[string]uint32)
+	for i := range knownAnswers {
+		known[knownAnswerKey(&knownAnswers[i])] = knownAnswers[i].TTL
+	}
+	var unknown []layers.DNSResourceRecord
+	for i := range records {
+		if ttl, ok := known[knownAnswerKey(&records[i])]; ok && ttl >= records[i].TTL/2 {
+			o.stats.knownAnswerSuppressed++
+			continue
+		}
+		unknown = append(unknown, records[i])
+	}
+	return unknown
+}
+
+// mDnsTruncatedQuery is a query with the TC bit, waiting for the rest of its known answers.
+type mDnsTruncatedQuery struct {
+	ns           *PluginMDnsNs              // Namespace plugin
+	key          string                     // Source of the query
+	questions    []layers.DNSQuestion       // Questions
+	knownAnswers []layers.DNSResourceRecord // Known answers collected so far
+	ipv6         bool                       // Received on IPv6
+	timer        core.CHTimerObj            // Timer to answer if no more known answers arrive
+}
+
+// OnEvent answers the query with the known answers collected.
+func (o *mDnsTruncatedQuery) OnEvent(a, b interface{}) {
+	o.ns.answerTruncatedQuery(o)
+}
+
+// handleQuery handles a query received from src. The questions of a query with the TC bit are answered
+// once all the known answers are received, RFC 6762 section 7.2.
+func (o *PluginMDnsNs) handleQuery(dns *layers.DNS, src string, ipv6 bool) {
+	key := fmt.Sprintf("%v-%v", src, ipv6)
+	if q, ok := o.truncatedQueries[key]; ok {
+		q.questions = append(q.questions, dns.Questions...)
+		q.knownAnswers = append(q.knownAnswers, dns.Answers...)
+		if dns.TC {
+			o.timerw.Stop(&q.timer)
+			o.timerw.Start(&q.timer, MDnsKnownAnswerDelay)
+		} else {
+			o.answerTruncatedQuery(q)
+		}
+		return
+	}
+	if dns.TC {
+		o.stats.rxTruncatedQueries++
+		q := &mDnsTruncatedQuery{ns: o, key: key, questions: dns.Questions, knownAnswers: dns.Answers, ipv6: ipv6}
+		q.timer.SetCB(q, 0, 0)
+		o.timerw.Start(&q.timer, MDnsKnownAnswerDelay)
+		o.truncatedQueries[key] = q
+		return
+	}
+	if len(dns.Questions) > 0 {
+		o.HandleRxMDnsQuestions(dns.Questions, dns.Answers, ipv6)
+	}
+}
+
+// answerTruncatedQuery answers a truncated query with all its known answers.
+func (o *PluginMDnsNs) answerTruncatedQuery(q *mDnsTruncatedQuery) {
+	if q.timer.IsRunning() {
+		o.timerw.Stop(&q.timer)
+	}
+	delete(o.truncatedQueries, q.key)
+	if len(q.questions) > 0 {
+		o.HandleRxMDnsQuestions(q.questions, q.knownAnswers, q.ipv6)
+	}
+}
+
+// getOwners returns the clients which own name, as host or as DNS-SD name.
+func (o *PluginMDnsNs) getOwners(name string) []*PluginMDnsClient {
+	var owners []*PluginMDnsClient
+	if c, err := o.GetClientByHost(name); err == nil {
+		owners = append(owners, c)
+	}
+	for c := range o.GetClientsByServiceName(name) {
+		if len(owners) == 0 || owners[0] != c {
+			owners = append(owners, c)
+		}
+	}
+	return owners
+}
+
+// groupByName groups records by name, case insensitive, keeping the order of the names.
+func groupByName(records []layers.DNSResourceRecord) (names []string, groups map[string][]layers.DNSResourceRecord) {
+	groups = make(map[string][]layers.DNSResourceRecord)
+	for i := range records {
+		name := strings.ToLower(string(records[i].Name))
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], records[i])
+	}
+	return names, groups
+}
+
+// handleProbe hands the proposed records of a probe to the clients which own their names.
+func (o *PluginMDnsNs) handleProbe(authorities []layers.DNSResourceRecord) {
+	o.stats.rxProbes++
+	names, groups := groupByName(authorities)
+	for _, name := range names {
+		for _, c := range o.getOwners(name) {
+			c.HandleRxMDnsProbe(name, groups[name])
+		}
+	}
+}
+
+// handleResponse hands the records of a response to the clients which own their names.
+func (o *PluginMDnsNs) handleResponse(records []layers.DNSResourceRecord) {
+	names, groups := groupByName(records)
+	for _, name := range names {
+		for _, c := range o.getOwners(name) {
+			if c.params.Probe {
+				c.HandleRxMDnsResponse(name, groups[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,463 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package mdns
+
+/*
+DNS-SD - DNS-Based Service Discovery - RFC 6763 - https://tools.ietf.org/html/rfc6763
+
+A client can advertise service instances. Each service instance is described by the following records:
+
+	_services._dns-sd._udp.<Domain>        PTR  <Service>.<Domain>                 service type enumeration
+	<Service>.<Domain>                     PTR  <Instance>.<Service>.<Domain>      browsing
+	<Subtype>._sub.<Service>.<Domain>      PTR  <Instance>.<Service>.<Domain>      selective browsing
+	<Instance>.<Service>.<Domain>          SRV  <Priority> <Weight> <Port> <Host>  resolving
+	<Instance>.<Service>.<Domain>          TXT  <Key>=<Value> ...                  resolving
+
+PTR records are shared, many clients can advertise instances of the same service type, hence they are sent
+without the cache flush bit. SRV, TXT and the address records of the host are unique and sent with the cache
+flush bit. Responses to a browsing query carry the SRV, TXT and address records in the additional section.
+
+The services are announced when added, after probing if enabled, and a goodbye (TTL 0) is sent when they are removed.
+*/
+
+import (
+	"emu/core"
+	utils "emu/plugins/dns_utils"
+	"emu/plugins/transport"
+	"external/google/gopacket/layers"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultServiceDomain                 = "local"                  // Default domain of a service
+	ServicesEnumName                     = "_services._dns-sd._udp" // Service type enumeration, RFC 6763 section 9
+	MDnsAnnounceCount                    = 2                        // Num of announcements, RFC 6762 section 8.3
+	MDnsAnnounceInterval                 = time.Second              // Interval between announcements
+	MDnsCacheFlushBit    layers.DNSClass = 0x8000                   // Cache flush bit of the class, RFC 6762 section 10.2
+)
+
+// MDnsServiceParams represents a DNS-SD service instance advertised by a client.
+type MDnsServiceParams struct {
+	Instance string             `json:"instance" validate:"required"` // Instance name, for example "Office Printer". Can't contain dots.
+	Type     string             `json:"type" validate:"required"`     // Service type, for example "_ipp._tcp".
+	Domain   string             `json:"domain"`                       // Domain of the service, defaults to "local".
+	Host     string             `json:"host"`                         // Target host of the SRV, must be owned by the client. Defaults to the first host.
+	Port     uint16             `json:"port" validate:"required"`     // Port of the service.
+	Priority uint16             `json:"priority"`                     // Priority of the SRV.
+	Weight   uint16             `json:"weight"`                       // Weight of the SRV.
+	Txt      []utils.TxtEntries `json:"txt" validate:"dive"`          // Key/Values of the TXT.
+	Subtypes []string           `json:"subtypes"`                     // Subtypes for selective browsing, for example "_printer".
+}
+
+// mDnsService is a service instance advertised by a client, with its names built from the parameters.
+type mDnsService struct {
+	params   MDnsServiceParams // Parameters of the service
+	instance string            // <Instance>.<Service>.<Domain>
+	service  string            // <Service>.<Domain>
+	subtypes []string          // <Subtype>._sub.<Service>.<Domain>
+	enum     string            // _services._dns-sd._udp.<Domain>
+	txts     [][]byte          // TXT of the instance
+}
+
+// newMDnsService validates the parameters of a service and builds its names.
+func newMDnsService(params MDnsServiceParams) (*mDnsService, error) {
+	if strings.Contains(params.Instance, ".") {
+		return nil, fmt.Errorf("invalid instance %q, instance can't contain dots", params.Instance)
+	}
+	labels := strings.Split(params.Type, ".")
+	if len(labels) != 2 || !strings.HasPrefix(labels[0], "_") || (labels[1] != "_tcp" && labels[1] != "_udp") {
+		return nil, fmt.Errorf("invalid service type %q, expected _<service>._tcp or _<service>._udp", params.Type)
+	}
+	if params.Domain == "" {
+		params.Domain = DefaultServiceDomain
+	}
+	s := &mDnsService{params: params}
+	s.service = params.Type + "." + params.Domain
+	s.instance = params.Instance + "." + s.service
+	s.enum = ServicesEnumName + "." + params.Domain
+	for _, subtype := range params.Subtypes {
+		s.subtypes = append(s.subtypes, subtype+"._sub."+s.service)
+	}
+	s.txts = utils.BuildTxtsFromTxtEntries(params.Txt)
+	if s.txts == nil {
+		// An instance without key/values has a TXT with a single empty string, RFC 6763 section 6.1.
+		s.txts = [][]byte{{}}
+	}
+	return s, nil
+}
+
+// names returns the names a service answers for.
+func (s *mDnsService) names() []string {
+	names := []string{s.enum, s.service, s.instance}
+	return append(names, s.subtypes...)
+}
+
+// key returns the key of the service, the instance name is case insensitive.
+func (s *mDnsService) key() string {
+	return strings.ToLower(s.instance)
+}
+
+// mDnsAnnouncer sends the announcements of the services of a client.
+type mDnsAnnouncer struct {
+	client *PluginMDnsClient // Client whose services are announced
+	timerw *core.TimerCtx    // Timer wheel
+	timer  core.CHTimerObj   // Timer between announcements
+	left   int               // Num of announcements left
+}
+
+// start starts (or restarts) announcing the services.
+func (o *mDnsAnnouncer) start() {
+	o.left = MDnsAnnounceCount
+	if !o.timer.IsRunning() {
+		o.timerw.StartTicks(&o.timer, 1)
+	}
+}
+
+// stop stops announcing.
+func (o *mDnsAnnouncer) stop() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+}
+
+// OnEvent sends an announcement and restarts the timer if more announcements are left.
+func (o *mDnsAnnouncer) OnEvent(a, b interface{}) {
+	var hosts []string
+	if o.client.params.Probe {
+		// The hosts are announced too once claimed.
+		hosts = o.client.GetHosts()
+		sort.Strings(hosts)
+	}
+	o.client.announce(o.client.getServices(), hosts, o.client.params.ResponseTTL)
+	o.left--
+	if o.left > 0 {
+		o.timerw.Start(&o.timer, MDnsAnnounceInterval)
+	}
+}
+
+// AddServices validates and adds services to the client, registers their names in the namespace database
+// and announces them, after probing them if probing is enabled. Either all the services are added or none.
+func (o *PluginMDnsClient) AddServices(params []MDnsServiceParams) error {
+	var services []*mDnsService
+	keys := make(map[string]bool)
+	for i := range params {
+		if params[i].Host == "" && len(o.params.Hosts) > 0 {
+			params[i].Host = o.params.Hosts[0]
+		}
+		if !o.hosts[params[i].Host] {
+			o.stats.invalidService++
+			return fmt.Errorf("invalid host %q for instance %q, host must be owned by the client", params[i].Host, params[i].Instance)
+		}
+		s, err := newMDnsService(params[i])
+		if err != nil {
+			o.stats.invalidService++
+			return err
+		}
+		exists := func(s *mDnsService) bool {
+			return keys[s.key()] || o.services[s.key()] != nil || o.mDnsNsPlugin.GetClientsByServiceName(s.instance) != nil
+		}
+		for o.params.Probe && exists(s) {
+			// The instance is renamed as in case of a conflict.
+			o.stats.nameRenamed++
+			params[i].Instance = nextInstanceName(params[i].Instance)
+			s, _ = newMDnsService(params[i])
+		}
+		if exists(s) {
+			o.stats.invalidService++
+			return fmt.Errorf("instance %q already exists", s.instance)
+		}
+		keys[s.key()] = true
+		services = append(services, s)
+	}
+	var instances []string
+	for _, s := range services {
+		o.registerService(s)
+		instances = append(instances, s.instance)
+	}
+	if len(services) > 0 {
+		if o.params.Probe {
+			o.prober.add(instances)
+		} else {
+			o.announcer.start()
+		}
+	}
+	return nil
+}
+
+// registerService adds a service to the client and registers its names in the namespace database.
+func (o *PluginMDnsClient) registerService(s *mDnsService) {
+	o.services[s.key()] = s
+	for _, name := range s.names() {
+		name = strings.ToLower(name)
+		if o.serviceNames[name] == 0 {
+			o.mDnsNsPlugin.RegisterServiceName(name, o)
+		}
+		o.serviceNames[name]++
+	}
+}
+
+// unregisterService removes a service from the client and unregisters its names from the namespace database.
+func (o *PluginMDnsClient) unregisterService(s *mDnsService) {
+	delete(o.services, s.key())
+	o.prober.remove(s.instance)
+	for _, name := range s.names() {
+		name = strings.ToLower(name)
+		o.serviceNames[name]--
+		if o.serviceNames[name] == 0 {
+			delete(o.serviceNames, name)
+			o.mDnsNsPlugin.UnregisterServiceName(name, o)
+		}
+	}
+}
+
+// RemoveServices removes services by instance name (<Instance>.<Service>.<Domain>) from the client, unregisters
+// their names from the namespace database and sends a goodbye. Returns slice of non existing instances.
+func (o *PluginMDnsClient) RemoveServices(instances []string) []string {
+	var nonExistingInstances []string
+	var services []*mDnsService
+	for _, instance := range instances {
+		s, ok := o.services[strings.ToLower(instance)]
+		if !ok {
+			nonExistingInstances = append(nonExistingInstances, instance)
+			continue
+		}
+		services = append(services, s)
+	}
+	o.goodbye(services)
+	return nonExistingInstances
+}
+
+// goodbye sends a goodbye for services and unregisters their names.
+func (o *PluginMDnsClient) goodbye(services []*mDnsService) {
+	if len(services) == 0 {
+		return
+	}
+	o.announce(services, nil, 0)
+	for _, s := range services {
+		o.unregisterService(s)
+	}
+	if len(o.services) == 0 {
+		o.announcer.stop()
+	}
+}
+
+// sockets returns the sockets of the client, IPv4 and IPv6 if the client has IPv6.
+func (o *PluginMDnsClient) sockets() []transport.SocketApi {
+	var sockets []transport.SocketApi
+	if o.socketIpv4 != nil {
+		sockets = append(sockets, o.socketIpv4)
+	}
+	if o.socketIpv6 != nil {
+		sockets = append(sockets, o.socketIpv6)
+	}
+	return sockets
+}
+
+// getServices returns the services of the client sorted by instance name.
+func (o *PluginMDnsClient) getServices() []*mDnsService {
+	services := make([]*mDnsService, 0, len(o.services))
+	for _, s := range o.services {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].key() < services[j].key() })
+	return services
+}
+
+// GetServices returns the parameters of the services a client advertises.
+func (o *PluginMDnsClient) GetServices() []MDnsServiceParams {
+	services := o.getServices()
+	params := make([]MDnsServiceParams, len(services))
+	for i, s := range services {
+		params[i] = s.params
+	}
+	return params
+}
+
+// isServiceName returns true if the client advertises a service that answers for name.
+func (o *PluginMDnsClient) isServiceName(name string) bool {
+	return o.serviceNames[strings.ToLower(name)] > 0
+}
+
+// ptrRecord builds a shared PTR record.
+func ptrRecord(name, ptr string, ttl uint32) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{
+		Name:  []byte(name),
+		Type:  layers.DNSTypePTR,
+		Class: layers.DNSClassIN,
+		TTL:   ttl,
+		PTR:   []byte(ptr),
+	}
+}
+
+// srvRecord builds the SRV record of a service.
+func (s *mDnsService) srvRecord(ttl uint32) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{
+		Name:  []byte(s.instance),
+		Type:  layers.DNSTypeSRV,
+		Class: layers.DNSClassIN | MDnsCacheFlushBit,
+		TTL:   ttl,
+		SRV: layers.DNSSRV{
+			Priority: s.params.Priority,
+			Weight:   s.params.Weight,
+			Port:     s.params.Port,
+			Name:     []byte(s.params.Host),
+		},
+	}
+}
+
+// txtRecord builds the TXT record of a service.
+func (s *mDnsService) txtRecord(ttl uint32) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{
+		Name:  []byte(s.instance),
+		Type:  layers.DNSTypeTXT,
+		Class: layers.DNSClassIN | MDnsCacheFlushBit,
+		TTL:   ttl,
+		TXTs:  s.txts,
+	}
+}
+
+// addressRecords builds the A and AAAA (if the client has IPv6) records of host.
+func (o *PluginMDnsClient) addressRecords(host string, ttl uint32) []layers.DNSResourceRecord {
+	records := []layers.DNSResourceRecord{{
+		Name:  []byte(host),
+		Type:  layers.DNSTypeA,
+		Class: layers.DNSClassIN | MDnsCacheFlushBit,
+		TTL:   ttl,
+		IP:    o.Client.Ipv4.ToIP(),
+	}}
+	if ipv6, err := o.Client.GetSourceIPv6(); err == nil {
+		records = append(records, layers.DNSResourceRecord{
+			Name:  []byte(host),
+			Type:  layers.DNSTypeAAAA,
+			Class: layers.DNSClassIN | MDnsCacheFlushBit,
+			TTL:   ttl,
+			IP:    ipv6.ToIP(),
+		})
+	}
+	return records
+}
+
+// buildServiceRecords builds the answers and additional records for a question on a service name.
+func (o *PluginMDnsClient) buildServiceRecords(q *layers.DNSQuestion) (answers, additionals []layers.DNSResourceRecord) {
+	name := strings.ToLower(string(q.Name))
+	ttl := o.params.ResponseTTL
+	isPtr := q.Type == layers.DNSTypePTR || q.Type == layers.DNSTypeANY
+	for _, s := range o.getServices() {
+		if o.prober.isProbing(s.instance) {
+			// The instance is not claimed yet.
+			continue
+		}
+		switch {
+		case name == strings.ToLower(s.enum):
+			if isPtr {
+				answers = append(answers, ptrRecord(s.enum, s.service, ttl))
+			}
+		case name == strings.ToLower(s.instance):
+			// Resolving, RFC 6763 section 12.2 and 12.3.
+			if q.Type == layers.DNSTypeSRV || q.Type == layers.DNSTypeANY {
+				answers = append(answers, s.srvRecord(ttl))
+				additionals = append(additionals, o.addressRecords(s.params.Host, ttl)...)
+			}
+			if q.Type == layers.DNSTypeTXT || q.Type == layers.DNSTypeANY {
+				answers = append(answers, s.txtRecord(ttl))
+			}
+		default:
+			// Browsing, RFC 6763 section 12.1.
+			browse := name == strings.ToLower(s.service)
+			for _, subtype := range s.subtypes {
+				if name == strings.ToLower(subtype) {
+					browse = true
+				}
+			}
+			if browse && isPtr {
+				answers = append(answers, ptrRecord(string(q.Name), s.instance, ttl))
+				additionals = append(additionals, s.srvRecord(ttl), s.txtRecord(ttl))
+				additionals = append(additionals, o.addressRecords(s.params.Host, ttl)...)
+			}
+		}
+	}
+	if answers == nil {
+		o.stats.unsupportedDnsType++
+	}
+	return answers, additionals
+}
+
+// recordKey returns a key which identifies a record in a response.
+func recordKey(rr *layers.DNSResourceRecord) string {
+	return fmt.Sprintf("%v/%v/%v", strings.ToLower(string(rr.Name)), rr.Type, string(rr.PTR))
+}
+
+// uniqueRecords removes the duplicate records, and the records in exclude.
+func uniqueRecords(records, exclude []layers.DNSResourceRecord) []layers.DNSResourceRecord {
+	seen := make(map[string]bool)
+	for i := range exclude {
+		seen[recordKey(&exclude[i])] = true
+	}
+	var unique []layers.DNSResourceRecord
+	for i := range records {
+		key := recordKey(&records[i])
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, records[i])
+		}
+	}
+	return unique
+}
+
+// announce sends an unsolicited response with all the records of services and the address records of hosts,
+// on IPv4 and on IPv6 if the client has IPv6. Names being probed are not announced. A TTL 0 means a goodbye.
+func (o *PluginMDnsClient) announce(services []*mDnsService, hosts []string, ttl uint32) {
+	// Num of instances of each service type in services.
+	types := make(map[string]int)
+	for _, s := range services {
+		types[strings.ToLower(s.service)]++
+	}
+	var answers []layers.DNSResourceRecord
+	for _, host := range hosts {
+		if !o.prober.isProbing(host) {
+			answers = append(answers, o.addressRecords(host, ttl)...)
+		}
+	}
+	for _, s := range services {
+		if o.prober.isProbing(s.instance) {
+			continue
+		}
+		if ttl != 0 || o.serviceNames[strings.ToLower(s.service)] == types[strings.ToLower(s.service)] {
+			// The service type is enumerated as long as some instance of it is left.
+			answers = append(answers, ptrRecord(s.enum, s.service, ttl))
+		}
+		answers = append(answers, ptrRecord(s.service, s.instance, ttl))
+		for _, subtype := range s.subtypes {
+			answers = append(answers, ptrRecord(subtype, s.instance, ttl))
+		}
+		answers = append(answers, s.srvRecord(ttl), s.txtRecord(ttl))
+		if ttl != 0 {
+			// The hosts remain after a goodbye.
+			answers = append(answers, o.addressRecords(s.params.Host, ttl)...)
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	data := o.dnsPktBuilder.BuildResponsePkt(0, uniqueRecords(answers, nil), []layers.DNSQuestion{}, layers.DNSResponseCodeNoErr)
+	for _, socket := range o.sockets() {
+		transportErr, _ := socket.Write(data)
+		if transportErr != transport.SeOK {
+			o.stats.socketWriteError++
+			continue
+		}
+		if ttl == 0 {
+			o.stats.pktTxMDnsGoodbye++
+		} else {
+			o.stats.pktTxMDnsAnnounce++
+		}
+	}
+}
@@ -1244,6 +1244,283 @@ func TestPluginMDns22(t *testing.T) {
 	a.Run(t, true)
 }
 
+// MDnsRpcCtx simulates an RPC.
+type MDnsRpcCtx struct {
+	rpc   string
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+// OnEvent sends the RPC.
+func (o *MDnsRpcCtx) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(o.rpc))
+}
+
+// sdCb simulates DNS-SD browsing and resolving queries, and then removes a service which sends a goodbye.
+func sdCb(tctx *core.CThreadCtx, test *MDnsTestBase) int {
+	queryCb(tctx, test)
+	timerw := tctx.GetTimerCtx()
+	var rpcCtx MDnsRpcCtx
+	rpcCtx.rpc = `{"jsonrpc": "2.0",
+	"method":"mdns_c_remove_services",
+	"params": {"tun": {"vport":1}, "mac": [0, 0, 1, 0, 0, 1], "instances": ["Printer Web._http._tcp.local", "invalid._http._tcp.local"]},
+	"id": 4}`
+	rpcCtx.tctx = tctx
+	rpcCtx.timer.SetCB(&rpcCtx, 0, 0)
+	timerw.StartTicks(&rpcCtx.timer, timerw.DurationToTicks(4*time.Second))
+	return 0
+}
+
+func TestPluginMDns23(t *testing.T) {
+
+	// DNS-SD, client 1 advertises two services, client 0 browses and resolves.
+	// Announcements are sent upon creation and a goodbye when a service is removed.
+	initJson1 := [][]byte{[]byte(`{
+		"hosts": ["client-0"]
+	}`)}
+
+	initJson2 := [][]byte{[]byte(`{
+		"hosts": ["printer.local"],
+		"services": [
+			{
+				"instance": "Office Printer",
+				"type": "_ipp._tcp",
+				"port": 631,
+				"priority": 1,
+				"weight": 2,
+				"subtypes": ["_universal"],
+				"txt": [
+					{
+						"field": "ty",
+						"value": "Brother HL-L2340D series"
+					},
+					{
+						"field": "rp",
+						"value": "ipp/print"
+					}
+				]
+			},
+			{
+				"instance": "Printer Web",
+				"type": "_http._tcp",
+				"port": 80
+			}
+		]
+	}`)}
+
+	var initJsonArray = [][][]byte{initJson1, initJson2}
+
+	query := `[{"name": "_services._dns-sd._udp.local", "dns_type": "PTR"},
+		{"name": "_universal._sub._ipp._tcp.local", "dns_type": "PTR"},
+		{"name": "Office Printer._ipp._tcp.local", "dns_type": "SRV", "ipv6": true},
+		{"name": "office printer._ipp._tcp.local", "dns_type": "TXT", "ipv6": true}]`
+
+	nsInitJson := [][]byte{[]byte(`{}`)}
+
+	a := &MDnsTestBase{
+		testname:     "mdns23",
+		dropAll:      false,
+		monitor:      true,
+		capture:      true,
+		initJSON:     initJsonArray,
+		nsInitJson:   nsInitJson,
+		query:        query,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		cb:           sdCb,
+	}
+	a.Run(t, true)
+}
+
+// MDnsPktCtx sends a mDNS packet from a host which isn't emulated.
+type MDnsPktCtx struct {
+	dns   *layers.DNS
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+// OnEvent serializes and sends the packet.
+func (o *MDnsPktCtx) OnEvent(a, b interface{}) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	ipv4 := &layers.IPv4{Version: 4,
+		IHL:      5,
+		TTL:      255,
+		SrcIP:    net.IPv4(16, 0, 0, 100),
+		DstIP:    net.IPv4(224, 0, 0, 251),
+		Protocol: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ipv4)
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 2, 0, 0, 0},
+			DstMAC:       net.HardwareAddr{0x01, 0x00, 0x5E, 0x00, 0x00, 0xFB},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ipv4,
+		udp,
+		o.dns,
+	)
+	m := o.tctx.MPool.Alloc(uint16(len(buf.Bytes())))
+	m.SetVPort(1)
+	m.Append(buf.Bytes())
+	o.tctx.Veth.OnRx(m)
+}
+
+// sendMDnsPkt schedules a mDNS packet to be sent after d.
+func sendMDnsPkt(tctx *core.CThreadCtx, d time.Duration, dns *layers.DNS) {
+	timerw := tctx.GetTimerCtx()
+	ctx := &MDnsPktCtx{dns: dns, tctx: tctx}
+	ctx.timer.SetCB(ctx, 0, 0)
+	timerw.StartTicks(&ctx.timer, timerw.DurationToTicks(d))
+}
+
+// probeCb simulates a host which isn't emulated: it claims the name of a service while it is probed, probes
+// a claimed host, sends a query with known answers and a truncated query followed by its known answers.
+func probeCb(tctx *core.CThreadCtx, test *MDnsTestBase) int {
+	instance := []byte("Office Printer._ipp._tcp.local")
+	sendMDnsPkt(tctx, 600*time.Millisecond, &layers.DNS{QR: true, AA: true, Answers: []layers.DNSResourceRecord{
+		{Name: instance, Type: layers.DNSTypeSRV, Class: layers.DNSClassIN, TTL: 120,
+			SRV: layers.DNSSRV{Port: 631, Name: []byte("other.local")}},
+	}})
+	sendMDnsPkt(tctx, 3*time.Second, &layers.DNS{
+		Questions: []layers.DNSQuestion{{Name: []byte("printer.local"), Type: layers.DNSTypeANY, Class: layers.DNSClassIN}},
+		Authorities: []layers.DNSResourceRecord{
+			{Name: []byte("printer.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 120, IP: net.IPv4(16, 0, 0, 100)},
+		}})
+	sendMDnsPkt(tctx, 4*time.Second, &layers.DNS{
+		Questions: []layers.DNSQuestion{{Name: []byte("printer.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("printer.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 200, IP: net.IPv4(16, 0, 0, 0)},
+		}})
+	ptr := []byte("_ipp._tcp.local")
+	sendMDnsPkt(tctx, 5*time.Second, &layers.DNS{TC: true,
+		Questions: []layers.DNSQuestion{{Name: ptr, Type: layers.DNSTypePTR, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			{Name: ptr, Type: layers.DNSTypePTR, Class: layers.DNSClassIN, TTL: 4500, PTR: []byte("Other Printer._ipp._tcp.local")},
+		}})
+	sendMDnsPkt(tctx, 5*time.Second+100*time.Millisecond, &layers.DNS{
+		Answers: []layers.DNSResourceRecord{
+			{Name: ptr, Type: layers.DNSTypePTR, Class: layers.DNSClassIN, TTL: 4500, PTR: []byte("Office Printer (2)._ipp._tcp.local")},
+		}})
+	return 0
+}
+
+func TestPluginMDns24(t *testing.T) {
+
+	// Probing, both clients want printer.local, client 1 is renamed on creation. The service of client 0 is
+	// renamed after a conflicting response. A probe for a claimed name is defended, known answers suppress
+	// the response and the known answers of a truncated query are collected.
+	initJson := [][]byte{[]byte(`{
+		"hosts": ["printer.local"],
+		"probe": true,
+		"services": [
+			{
+				"instance": "Office Printer",
+				"type": "_ipp._tcp",
+				"port": 631
+			}
+		]
+	}`)}
+
+	initJson2 := [][]byte{[]byte(`{
+		"hosts": ["printer.local"],
+		"probe": true
+	}`)}
+
+	var initJsonArray = [][][]byte{initJson, initJson2}
+
+	nsInitJson := [][]byte{[]byte(`{}`)}
+
+	a := &MDnsTestBase{
+		testname:     "mdns24",
+		dropAll:      false,
+		monitor:      true,
+		capture:      true,
+		initJSON:     initJsonArray,
+		nsInitJson:   nsInitJson,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		cb:           probeCb,
+	}
+	a.Run(t, true)
+}
+
+func TestMDnsRenaming(t *testing.T) {
+	hosts := [][2]string{
+		{"printer.local", "printer-2.local"},
+		{"printer-2.local", "printer-3.local"},
+		{"Printer-9.LOCAL", "Printer-10.LOCAL"},
+		{"my-host.local", "my-host-2.local"},
+		{"UCS", "UCS-2"},
+	}
+	for _, h := range hosts {
+		if name := nextHostName(h[0]); name != h[1] {
+			t.Errorf("Bad host name for %v, want %v, have %v.\n", h[0], h[1], name)
+		}
+	}
+	instances := [][2]string{
+		{"Office Printer", "Office Printer (2)"},
+		{"Office Printer (2)", "Office Printer (3)"},
+		{"Printer (Lobby)", "Printer (Lobby) (2)"},
+	}
+	for _, i := range instances {
+		if name := nextInstanceName(i[0]); name != i[1] {
+			t.Errorf("Bad instance name for %v, want %v, have %v.\n", i[0], i[1], name)
+		}
+	}
+}
+
+func TestMDnsProbeTieBreak(t *testing.T) {
+	record := func(ip net.IP) layers.DNSResourceRecord {
+		return layers.DNSResourceRecord{Name: []byte("printer.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: ip}
+	}
+	a, b := record(net.IPv4(169, 254, 99, 200)), record(net.IPv4(169, 254, 200, 50))
+	flush := a
+	flush.Class |= MDnsCacheFlushBit
+	tests := []struct {
+		ours, theirs []layers.DNSResourceRecord
+		want         int
+	}{
+		{[]layers.DNSResourceRecord{b}, []layers.DNSResourceRecord{a}, 1},
+		{[]layers.DNSResourceRecord{a}, []layers.DNSResourceRecord{b}, -1},
+		{[]layers.DNSResourceRecord{flush}, []layers.DNSResourceRecord{a}, 0},
+		{[]layers.DNSResourceRecord{b, a}, []layers.DNSResourceRecord{a, b}, 0},
+		{[]layers.DNSResourceRecord{a, b}, []layers.DNSResourceRecord{a}, 1},
+	}
+	for i := range tests {
+		if cmp := compareRecordSets(tests[i].ours, tests[i].theirs); cmp != tests[i].want {
+			t.Errorf("Bad tie-break %v, want %v, have %v.\n", i, tests[i].want, cmp)
+		}
+	}
+}
+
+func TestMDnsServiceNames(t *testing.T) {
+	s, err := newMDnsService(MDnsServiceParams{Instance: "Living Room", Type: "_airplay._tcp", Port: 7000, Subtypes: []string{"_tv"}})
+	if err != nil {
+		t.Fatalf("newMDnsService failed: %v", err)
+	}
+	names := []string{"_services._dns-sd._udp.local", "_airplay._tcp.local", "Living Room._airplay._tcp.local", "_tv._sub._airplay._tcp.local"}
+	if fmt.Sprint(s.names()) != fmt.Sprint(names) {
+		t.Errorf("Bad names, want %v, have %v.\n", names, s.names())
+	}
+	if len(s.txts) != 1 || len(s.txts[0]) != 0 {
+		t.Errorf("Bad TXT, want a single empty string, have %q.\n", s.txts)
+	}
+
+	invalid := []MDnsServiceParams{
+		{Instance: "Living.Room", Type: "_airplay._tcp", Port: 7000},
+		{Instance: "Living Room", Type: "airplay._tcp", Port: 7000},
+		{Instance: "Living Room", Type: "_airplay._sctp", Port: 7000},
+		{Instance: "Living Room", Type: "_airplay", Port: 7000},
+	}
+	for i := range invalid {
+		if _, err := newMDnsService(invalid[i]); err == nil {
+			t.Errorf("Expected an error for %+v.\n", invalid[i])
+		}
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
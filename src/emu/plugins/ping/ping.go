@@ -12,7 +12,6 @@ import (
 	"encoding/binary"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
-	"math/rand"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -41,6 +40,8 @@ const (
 	DefaultPingTimeout     = 5   // Default timeout from when the last packet was sent till the stats are deleted
 	DefaultPingPayloadSize = 16  // Default payload size for Echo-Requests.
 	DefaultPingTTL         = 64  // Default TTL on an Echo-Request packet.
+
+	PingEventDone = "ping.done" // Published when the ping is finished, with the statistics
 )
 
 // PingParams contains a part of the RPC params that are independent of the ICMP version.
@@ -177,20 +178,23 @@ type Ping struct {
 	cdbv               *core.CCounterDbVec // Database Vector
 	tctx               *core.CThreadCtx    // thread context
 	ns                 *core.CNSCtx        // namespace context
+	client             *core.CClient       // the client that pings
 	pingClient         PingClientIF        // an interface that the ping client must implement either it is ICMPv4 or ICMPv6
 	pingPkt            []byte              // the ping packet that will be sent
+	removed            bool                // OnRemove was called, the ping is done
 }
 
 // NewPing creates a new Ping instance and returns a pointer to it.
-func NewPing(params PingParams, ns *core.CNSCtx, pingClient PingClientIF) *Ping {
+func NewPing(params PingParams, client *core.CClient, pingClient PingClientIF) *Ping {
 	o := new(Ping)
 	o.params = params
-	o.ns = ns
+	o.client = client
+	o.ns = client.Ns
 	o.tctx = o.ns.ThreadCtx
 	o.pingClient = pingClient
 	if !o.tctx.Simulation {
-		o.identifier = uint16(rand.Intn(0xffff))
-		o.sequenceNumber = uint16(rand.Intn(0xffff))
+		o.identifier = uint16(o.ns.Rand().Intn(0xffff))
+		o.sequenceNumber = uint16(o.ns.Rand().Intn(0xffff))
 	} else {
 		o.identifier = 0x1234
 		o.sequenceNumber = 0xabcd
@@ -215,11 +219,16 @@ func (o *Ping) StartPinging() {
 	o.timerw.StartTicks(&o.timer, o.ticksPerInterval)
 }
 
-// OnRemove should be called when Ping has finished or it is stopped.
+// OnRemove should be called when Ping has finished or it is stopped. Publishes ping.done once.
 func (o *Ping) OnRemove() {
+	if o.removed {
+		return
+	}
+	o.removed = true
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.publishDone()
 	o.pingClient.OnPingRemove()
 }
 
@@ -242,6 +251,24 @@ func (o *Ping) OnEvent(a, b interface{}) {
 	}
 }
 
+// publishDone publishes the statistics of a ping that was finished or stopped.
+func (o *Ping) publishDone() {
+	if !o.tctx.IsEventEnabled(PingEventDone) {
+		return
+	}
+	o.updateStats()
+	o.client.PublishEvent(PingEventDone, &struct {
+		RequestsSent      uint32 `json:"requests_sent"`
+		RepliesInOrder    uint32 `json:"replies_in_order"`
+		RepliesOutOfOrder uint32 `json:"replies_out_of_order"`
+		DstUnreachable    uint32 `json:"dst_unreachable"`
+		AvgLatencyUsec    int64  `json:"avg_latency_usec"`
+		MinLatencyUsec    int64  `json:"min_latency_usec"`
+		MaxLatencyUsec    int64  `json:"max_latency_usec"`
+	}{o.stats.requestsSent, o.stats.repliesInOrder, o.stats.repliesOutOfOrder, o.stats.dstUnreachable,
+		o.stats.avgLatencyUsec, o.stats.minLatencyUsec, o.stats.maxLatencyUsec})
+}
+
 //HandleEchoReply handles an Echo Reply received upon an Echo Request we sent.
 func (o *Ping) HandleEchoReply(seq, id uint16, payload []byte) {
 	if id != o.identifier {
@@ -12,13 +12,47 @@ import (
 )
 
 type (
-	ApiClientGetPPPSessionID struct {}
-	ApiClientGetPPPClientIP struct {}
-	ApiClientGetPPPServerMac struct {}
+	ApiClientGetPPPSessionID struct{}
+	ApiClientGetPPPClientIP  struct{}
+	ApiClientGetPPPServerMac struct{}
+	ApiClientGetPPPInfo      struct{}
+	ApiClientPPPCnt          struct{}
+	ApiClientPPPDisconnect   struct{}
+	ApiServerGetPPPSessions  struct{}
+	ApiServerPPPCnt          struct{}
+	ApiServerPPPDisconnect   struct{}
 )
 
-/* ServeJSONRPC for ApiIcmpClientGetPingStatsHandler returns the statistics of an ongoing ping. If there is no ongoing ping
-it will return an error */
+type ApiServerPPPDisconnectParams struct {
+	SessionID uint16 `json:"session_id"`
+}
+
+func getServer(ctx interface{}, params *fastjson.RawMessage) (*pppServer, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, PPPPlugin)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pppClient := plug.Ext.(*PluginPPPClient)
+	if pppClient.srv == nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "ppp: client is not a server",
+		}
+	}
+	return pppClient.srv, nil
+}
+
+/*
+	ServeJSONRPC for ApiIcmpClientGetPingStatsHandler returns the statistics of an ongoing ping. If there is no ongoing ping
+
+it will return an error
+*/
 func (h ApiClientGetPPPSessionID) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
 	tctx := ctx.(*core.CThreadCtx)
@@ -70,6 +104,114 @@ func (h ApiClientGetPPPServerMac) ServeJSONRPC(ctx interface{}, params *fastjson
 	return pppClient.GetPPPServerMac(), nil
 }
 
+func (h ApiClientGetPPPInfo) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, PPPPlugin)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pppClient := plug.Ext.(*PluginPPPClient)
+
+	return pppClient.GetPPPClientInfo(), nil
+}
+
+func (h ApiClientPPPCnt) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, PPPPlugin)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pppClient := plug.Ext.(*PluginPPPClient)
+	if pppClient.srv != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "ppp: client is a server, use ppp_c_server_cnt",
+		}
+	}
+
+	return pppClient.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+/* ServeJSONRPC for ApiClientPPPDisconnect tears down the session with a PADT */
+func (h ApiClientPPPDisconnect) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, PPPPlugin)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pppClient := plug.Ext.(*PluginPPPClient)
+
+	if !pppClient.hasSession() {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "ppp: client has no session to disconnect",
+		}
+	}
+	pppClient.disconnect()
+
+	return nil, nil
+}
+
+func (h ApiServerGetPPPSessions) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	srv, err := getServer(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetSessions(), nil
+}
+
+func (h ApiServerPPPCnt) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	srv, err := getServer(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return srv.cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+/* ServeJSONRPC for ApiServerPPPDisconnect tears down one session of the server with a PADT */
+func (h ApiServerPPPDisconnect) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiServerPPPDisconnectParams
+	tctx := ctx.(*core.CThreadCtx)
+	srv, err := getServer(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err1 := tctx.UnmarshalValidate(*params, &p); err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	if err1 := srv.Disconnect(p.SessionID); err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	return nil, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -82,6 +224,12 @@ func init() {
 	core.RegisterCB("ppp_c_client_session", ApiClientGetPPPSessionID{}, false)
 	core.RegisterCB("ppp_c_client_ip", ApiClientGetPPPClientIP{}, false)
 	core.RegisterCB("ppp_c_server_mac", ApiClientGetPPPServerMac{}, false)
+	core.RegisterCB("ppp_c_client_info", ApiClientGetPPPInfo{}, false)
+	core.RegisterCB("ppp_c_client_cnt", ApiClientPPPCnt{}, false)
+	core.RegisterCB("ppp_c_client_disconnect", ApiClientPPPDisconnect{}, false)
+	core.RegisterCB("ppp_c_server_sessions", ApiServerGetPPPSessions{}, false)
+	core.RegisterCB("ppp_c_server_cnt", ApiServerPPPCnt{}, false)
+	core.RegisterCB("ppp_c_server_disconnect", ApiServerPPPDisconnect{}, false)
 
 	/* register callback for rx side*/
 	core.ParserRegister("ppp", HandleRxPPPPacket)
@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Eolo S.p.A. and Altran Italia S.p.A. and/or them affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package point2point
+
+/*
+CHAP authentication, the algorithm is selected by the server in the LCP
+Authentication-Protocol option
+
+  5    - CHAP with MD5 (rfc1994), Response = MD5(Identifier + secret + Challenge)
+  0x81 - MS-CHAPv2 (rfc2759), Response = Peer-Challenge(16) + Reserved(8) + NT-Response(24) + Flags(1)
+*/
+
+import (
+	"crypto/md5"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"strings"
+)
+
+const (
+	mschapv2ChallengeLen = 16
+	mschapv2ResponseLen  = 49
+)
+
+func (o *PluginPPPClient) handleCHAP(ps *core.ParserPacketState) {
+	tmp := gopacket.NewPacket(ps.M.GetData(), layers.LayerTypeEthernet, gopacket.Default)
+
+	chap, ok := tmp.Layer(layers.LayerTypeCHAP).(*layers.CHAP)
+	if !ok {
+		o.stats.pktRxChapInvalid++
+		return
+	}
+
+	switch chap.Code {
+	case layers.CHAPTypeChallenge:
+		o.sendCHAPResponse(chap)
+	case layers.CHAPTypeSuccess:
+		if o.chapAlgorithm == layers.CHAPAlgorithmMSCHAPv2 &&
+			!strings.HasPrefix(string(chap.Message), o.chapAuthResp) {
+			// the server does not know our password
+			LogTimeFormatted(WARNING, ">> handleCHAP >> Wrong MS-CHAPv2 authenticator response on Mac %v",
+				o.Client.Mac)
+			o.stats.authFail++
+			o.disconnect()
+			return
+		}
+		o.stats.authOk++
+		o.onAuthSuccess()
+	case layers.CHAPTypeFailure:
+		LogTimeFormatted(WARNING, ">> handleCHAP >> CHAP failure on Mac %v : %s",
+			o.Client.Mac, string(chap.Message))
+		o.stats.authFail++
+		o.disconnect()
+	default:
+		o.stats.pktRxChapInvalid++
+	}
+}
+
+func (o *PluginPPPClient) sendCHAPResponse(challenge *layers.CHAP) {
+	var value []byte
+
+	switch o.chapAlgorithm {
+	case layers.CHAPAlgorithmMD5:
+		h := md5.New()
+		h.Write([]byte{challenge.Identifier})
+		h.Write([]byte(o.password))
+		h.Write(challenge.Value)
+		value = h.Sum(nil)
+
+	case layers.CHAPAlgorithmMSCHAPv2:
+		if len(challenge.Value) != mschapv2ChallengeLen {
+			o.stats.pktRxChapInvalid++
+			return
+		}
+		peerChallenge := make([]byte, mschapv2ChallengeLen)
+		binary.BigEndian.PutUint64(peerChallenge[0:8], o.Ns.Rand().Uint64())
+		binary.BigEndian.PutUint64(peerChallenge[8:16], o.Ns.Rand().Uint64())
+		res, err := dot1x.Encryptv2(challenge.Value, peerChallenge, o.userID, o.password)
+		if err != nil {
+			o.stats.pktRxChapInvalid++
+			return
+		}
+		o.chapAuthResp = res.AuthenticatorResponse
+		value = make([]byte, 0, mschapv2ResponseLen)
+		value = append(value, peerChallenge...)
+		value = append(value, make([]byte, 8)...) // reserved
+		value = append(value, res.ChallengeResponse...)
+		value = append(value, 0) // flags
+
+	default:
+		o.stats.pktRxChapInvalid++
+		return
+	}
+
+	// PPPoES
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.pppSessionID,
+		Length:    0x0, // filled in next lines of code
+		Tags:      []layers.PPPoEDTag{},
+	}
+	// PPP
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeCHAP,
+	}
+	// CHAP
+	chap := &layers.CHAP{
+		Code:       layers.CHAPTypeResponse,
+		Identifier: challenge.Identifier,
+		Value:      value,
+		Name:       []byte(o.userID),
+	}
+	chap.Length = chap.GetCHAPSize()
+	pppoes.Length = chap.Length + 2 // PPP layer size in byte
+
+	// build raw CHAP with layerTwoSession structure and send it
+	tmpChap := append(o.layerTwoSession, pppoes, ppp, chap)
+	chapRes := core.PacketUtlBuild(tmpChap...)
+
+	o.stats.pktTxChapResp++
+	o.restartTimer(o.maxTimerRetransmitSec)
+	o.Tctx.Veth.SendBuffer(false, o.Client, chapRes, false)
+}
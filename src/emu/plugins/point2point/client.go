@@ -12,7 +12,6 @@ import (
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -33,7 +32,51 @@ func (o *PluginPPPClient) GetPPPServerMac() string {
 	return o.serverMac.String()
 }
 
-var pppEvents = []string{}
+// GetPPPClientInfo is a wrapper to return the session information to JSONRPC
+func (o *PluginPPPClient) GetPPPClientInfo() *PPPClientInfo {
+	var info PPPClientInfo
+	info.State = o.state
+	if int(o.state) < len(pppStateNames) {
+		info.StateName = pppStateNames[o.state]
+	}
+	info.SessionID = o.pppSessionID
+	info.ServerMac = o.serverMac.String()
+	info.Mru = o.mru
+	switch o.authMethod {
+	case layers.PPPTypePAP:
+		info.Auth = "pap"
+	case layers.PPPTypeCHAP:
+		if o.chapAlgorithm == layers.CHAPAlgorithmMSCHAPv2 {
+			info.Auth = "mschapv2"
+		} else {
+			info.Auth = "chap-md5"
+		}
+	default:
+		info.Auth = "none"
+	}
+	info.Ipv4 = o.clientIP.ToIP().String()
+	if o.ipv6.enabled {
+		info.Ipv6cp = ipv6cpStateNames[o.ipv6.state]
+		if o.ipv6.state == IPV6CP_STATE_OPENED {
+			info.Ipv6LinkLocal = o.ipv6.linkLocal.ToIP().String()
+		}
+		if o.ipv6.slaacValid {
+			info.Ipv6Slaac = o.ipv6.slaac.ToIP().String()
+		}
+		if o.ipv6.dhcpValid {
+			info.Ipv6Dhcp = o.ipv6.dhcpAddr.ToIP().String()
+		}
+		if o.ipv6.prefixLen > 0 {
+			info.Ipv6Prefix = fmt.Sprintf("%s/%d", o.ipv6.prefix.ToIP().String(), o.ipv6.prefixLen)
+		}
+	}
+	return &info
+}
+
+var pppEvents = []string{core.MSG_CLIENT_ACTIVATED}
+
+// pppMilestones are reached by the session, PAP/CHAP authentication and the IPCP address
+const pppMilestones = core.CLIENT_MS_L2_AUTH | core.CLIENT_MS_ADDRESS
 
 // NewPPPClient create plugin
 func NewPPPClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -43,13 +86,28 @@ func NewPPPClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 		return nil, err
 	}
 
+	if init.Mru != 0 && (init.Mru < 128 || init.Mru > 1492) {
+		return nil, fmt.Errorf("ppp: mru %d is out of range [128-1492]", init.Mru)
+	}
+
 	o := new(PluginPPPClient)
 	o.InitPluginBase(ctx, o)            /* init base object*/
 	o.RegisterEvents(ctx, pppEvents, o) /* register events, only if exits*/
 	// TO BE UNDERSTAND!
 	nsplg := o.Ns.PluginCtx.GetOrCreate(PPPPlugin)
 	o.pppNsPlug = nsplg.Ext.(*PluginPPPNs)
-	o.OnCreate()
+
+	if init.Server != nil {
+		if o.pppNsPlug.server != nil {
+			return nil, fmt.Errorf("ppp: only one server is allowed per namespace")
+		}
+		o.srv, err = newPPPServer(o, init.Server)
+		if err != nil {
+			return nil, err
+		}
+		o.pppNsPlug.server = o
+		return &o.PluginBase, nil
+	}
 
 	// init JSON is provided and correctly parsed
 	if len(init.UserID) > 0 {
@@ -70,6 +128,25 @@ func NewPPPClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 		o.timeout = 3
 	}
 
+	o.mru = 1492
+	if init.Mru != 0 {
+		o.mru = init.Mru
+	}
+	o.serviceName = init.ServiceName
+	if len(init.HostUniq) > 0 {
+		o.hostUniq = []byte(init.HostUniq)
+	}
+	o.echoInterval = uint32(init.EchoInterval)
+	o.echoMaxFail = 3
+	if init.EchoMaxFail > 0 {
+		o.echoMaxFail = init.EchoMaxFail
+	}
+	o.ipv6.enabled = init.Ipv6
+	o.ipv6.pd = init.Dhcpv6Pd
+
+	o.Client.RequireMilestone(pppMilestones)
+	o.OnCreate()
+
 	return &o.PluginBase, nil
 }
 
@@ -80,19 +157,24 @@ func (o *PluginPPPClient) OnCreate() {
 	o.timerw = o.Tctx.GetTimerCtx()
 
 	// create local magic number uint32 and save as []byte
-	tmpLocalMagicNumber := rand.Uint32()
+	tmpLocalMagicNumber := o.Ns.Rand().Uint32()
 	o.localMagicNumber = make([]byte, 4)
 	binary.BigEndian.PutUint32(o.localMagicNumber[0:], tmpLocalMagicNumber)
 
 	// Maximum Received Unit
 	o.maxRecUnitBytes = make([]byte, 2)
-	binary.BigEndian.PutUint16(o.maxRecUnitBytes[0:], uint16(1492))
+	binary.BigEndian.PutUint16(o.maxRecUnitBytes[0:], o.mru)
+
+	o.cdb = NewPPPClientStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(PPPPlugin)
+	o.cdbv.Add(o.cdb)
 
 	o.minTimerRetransmitSec = 1
 	o.medTimerRetransmitSec = 3
 	o.maxTimerRetransmitSec = 5
 
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
+	o.ipv6.timer.SetCB(&o.ipv6.timerCb, o, 0)
 
 	clientMac := o.Client.GetInfo().Mac
 
@@ -116,9 +198,16 @@ func (o *PluginPPPClient) OnCreate() {
 	}
 
 	o.state = PPPStateInit
+	o.padtSent = 0
+	if o.Client.IsActive() {
+		o.startDiscovery()
+	} /* else, send PADI when the ramp-up activates the client */
+}
+
+// startDiscovery sends the first PADI
+func (o *PluginPPPClient) startDiscovery() {
 	o.sendPADI()
 	o.state = PPPStatePADI
-	o.padtSent = 0
 }
 
 func (o *PluginPPPClient) restartTimer(sec uint32) {
@@ -147,10 +236,17 @@ func (o *PluginPPPClient) sendPADI() {
 		Length:    0x0, // filled in next line of code
 		Tags: []layers.PPPoEDTag{{
 			Type:   layers.PPPoEDTagTypeServiceName,
-			Length: 0x0000,
-			Value:  []uint8{},
+			Length: uint16(len(o.serviceName)),
+			Value:  []uint8(o.serviceName),
 		}},
 	}
+	if len(o.hostUniq) > 0 {
+		pppoed.Tags = append(pppoed.Tags, layers.PPPoEDTag{
+			Type:   layers.PPPoEDTagTypeHostUniq,
+			Length: uint16(len(o.hostUniq)),
+			Value:  o.hostUniq,
+		})
+	}
 	pppoed.Length = pppoed.GetPPPoEDTagsSize()
 
 	// ethernet layer by default include broadcast destination
@@ -184,9 +280,10 @@ func (o *PluginPPPClient) sendPADI() {
 	// build raw PADI with layerTwoDiscovery structure and send it
 	tmpPadi := append(padiLayerTwo, pppoed)
 	rawPadi := core.PacketUtlBuild(tmpPadi...)
-	padi := append(rawPadi)
+	padi := rawPadi
 
 	o.restartTimer(o.maxTimerRetransmitSec)
+	o.stats.pktTxPADI++
 	o.Tctx.Veth.SendBuffer(false, o.Client, padi, false)
 }
 
@@ -235,10 +332,11 @@ func (o *PluginPPPClient) sendPADR() {
 	// build raw PADR with layerTwoDiscovery structure and send it
 	tmpPadr := append(o.layerTwoDiscovery, pppoed)
 	rawPadr := core.PacketUtlBuild(tmpPadr...)
-	padr := append(rawPadr)
+	padr := rawPadr
 
 	o.state = PPPStatePADR
 	o.restartTimer(o.maxTimerRetransmitSec)
+	o.stats.pktTxPADR++
 	o.Tctx.Veth.SendBuffer(false, o.Client, padr, false)
 }
 
@@ -280,19 +378,48 @@ func (o *PluginPPPClient) sendPADT() {
 	// build raw PADT with layerTwoDiscovery structure and send it
 	tmpPadt := append(o.layerTwoDiscovery, pppoed)
 	rawPadt := core.PacketUtlBuild(tmpPadt...)
-	padt := append(rawPadt)
+	padt := rawPadt
 
 	o.state = PPPStatePADTSent
 	o.restartTimer(o.maxTimerRetransmitSec)
+	o.stats.pktTxPADT++
 	o.Tctx.Veth.SendBuffer(false, o.Client, padt, false)
 	o.padtSent += 1
 }
 
+// hasSession returns true if PPPoE discovery has completed and the session is not closed
+func (o *PluginPPPClient) hasSession() bool {
+	return o.state >= PPPStatePADS && o.state <= PPPStateLinkUp
+}
+
+// disconnect tears down the session with a PADT
+func (o *PluginPPPClient) disconnect() {
+	o.Client.ClearMilestone(pppMilestones)
+	o.releaseIpv6()
+	o.sendPADT()
+}
+
+// onPeerTerminate handles a PADT received from the server
+func (o *PluginPPPClient) onPeerTerminate() {
+	o.stats.pktRxPADT++
+	o.Client.ClearMilestone(pppMilestones)
+	o.releaseIpv6()
+	o.state = PPPStatePADTReceived
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+}
+
 /*OnEvent support event change of IP  */
 func (o *PluginPPPClient) OnEvent(msg string, a, b interface{}) {
-	clientMac := o.Client.GetInfo().Mac
-	LogTimeFormatted(INFO, ">> OnEvent >> Created PPP Client at Mac -> %s",
-		clientMac)
+	switch msg {
+	case core.MSG_CLIENT_ACTIVATED:
+		if o.srv == nil && o.state == PPPStateInit {
+			LogTimeFormatted(INFO, ">> OnEvent >> Activated PPP Client at Mac -> %s",
+				o.Client.GetInfo().Mac)
+			o.startDiscovery()
+		}
+	}
 }
 
 // OnRemove support events handling on plugin remove
@@ -302,9 +429,17 @@ func (o *PluginPPPClient) OnRemove(ctx *core.PluginCtx) {
 	LogTimeFormatted(INFO, ">> OnRemove >> Destroyed PPP Client at Mac -> %s",
 		clientMac)
 
+	if o.srv != nil {
+		o.srv.OnRemove()
+		o.pppNsPlug.server = nil
+		ctx.UnregisterEvents(&o.PluginBase, pppEvents)
+		return
+	}
+
 	if o.state == PPPStateLinkUp {
 		o.sendPADT()
 	}
+	o.Client.ReleaseMilestone(pppMilestones)
 
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, pppEvents)
@@ -312,16 +447,36 @@ func (o *PluginPPPClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.releaseIpv6()
 }
 
 // HandleRxPPPPacket handled Rx packets at client
 func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
+	if o.srv != nil {
+		return o.srv.HandleRxPacket(ps)
+	}
+	// the server can close the session at any time
+	if o.hasSession() && o.isPPPoED(ps, layers.PPPoECodePADT) {
+		o.onPeerTerminate()
+		return 0
+	}
+	// IPv6CP runs in parallel to IPCP once the authentication is over
+	if o.ipv6.enabled && (o.state == PPPStateIPCPNegotiation || o.state == PPPStateLinkUp) {
+		if o.isPPP(ps, layers.PPPTypeIPv6CP) {
+			o.handleIPv6CP(ps)
+			return 0
+		} else if o.isPPP(ps, layers.PPPTypeIPv6) {
+			o.handleIPv6(ps)
+			return 0
+		}
+	}
+
 	switch o.state {
 	case PPPStateInit:
 		// it's first state and change asap to PADI, impossible to receive pkt in this status
 	case PPPStatePADI:
 		// after sending PADI, parse incoming pkt as candidate PADO
-		if o.isPPPoED(ps, layers.PPPoECodePADO) {
+		if o.isPPPoED(ps, layers.PPPoECodePADO) && o.isValidDiscovery(ps) {
 			o.prepareLayerTemplate(ps)
 			o.handlePADO(ps)
 		}
@@ -329,7 +484,7 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 		// this state is used when received PADO and before send PADR, it's difficult to reveive a second PADO
 	case PPPStatePADR:
 		// after sending PADR, parse incoming pkt as candidate PADS
-		if o.isPPPoED(ps, layers.PPPoECodePADS) {
+		if o.isPPPoED(ps, layers.PPPoECodePADS) && o.isValidDiscovery(ps) {
 			o.handlePADS(ps)
 		}
 	case PPPStatePADS:
@@ -344,7 +499,8 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 	case PPPStateLCPNegotiation:
 		// After first LCP message received and during entire LCP Negotiation
 		// Parsing candidate PPP LCP Negotiation pkt
-		if o.isLCP(ps, layers.LCPTypeConfigurationRequest, layers.LCPTypeConfigurationAck) {
+		if o.isLCP(ps, layers.LCPTypeConfigurationRequest, layers.LCPTypeConfigurationAck,
+			layers.LCPTypeConfigurationNak, layers.LCPTypeConfigurationReject) {
 			// based on internal status it's possible to enter in PPPStatePAPSent
 			o.handleLCPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
@@ -356,6 +512,9 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 		if o.isPPP(ps, layers.PPPTypePAP) {
 			// verify PAP Auth and move to PPPStateIPCPNegotiation
 			o.handlePAPRes(ps)
+		} else if o.isPPP(ps, layers.PPPTypeCHAP) {
+			// verify CHAP challenge/result and move to PPPStateIPCPNegotiation
+			o.handleCHAP(ps)
 		} else if o.isLCP(ps, layers.LCPTypeConfigurationRequest) {
 			o.handleLCPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
@@ -368,6 +527,8 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 			o.handleIPCPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
 			o.answerLCPEcho(ps)
+		} else if o.isLCP(ps, layers.LCPTypeProtocolReject) {
+			o.handleProtocolReject(ps)
 		}
 	case PPPStateLinkUp:
 		// IPCP Neg is completed and client has assigned an IP address
@@ -379,18 +540,21 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 			o.handleIPCPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
 			o.answerLCPEcho(ps)
+		} else if o.isLCP(ps, layers.LCPTypeEchoReply) {
+			o.stats.pktRxEchoReply++
+			o.echoOutstanding = 0
 		} else if o.isLCP(ps, layers.LCPTypeTerminateRequest) {
 			o.answerLCPTerminate(ps)
-			o.sendPADT()
-			o.state = PPPStatePADTSent
+			o.disconnect()
+		} else if o.isLCP(ps, layers.LCPTypeProtocolReject) {
+			o.handleProtocolReject(ps)
 		}
 	case PPPStatePADTSent:
 		// this state is reachable only via EMU API to drive client disconnect
 		// Parse PADT response
 		if o.isPPPoED(ps, layers.PPPoECodePADT) {
 			// ok conclude
-			o.state = PPPStatePADTReceived
-			o.OnRemove(o.tctx.PluginCtx)
+			o.onPeerTerminate()
 		}
 	case PPPStatePADTReceived:
 		// it's impossible to receive other pkt here because client shutdown quickly
@@ -399,6 +563,31 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 	return 0
 }
 
+// isValidDiscovery checks the Host-Uniq and the error tags of a PADO/PADS
+func (o *PluginPPPClient) isValidDiscovery(ps *core.ParserPacketState) bool {
+	tmp := gopacket.NewPacket(ps.M.GetData(), layers.LayerTypeEthernet, gopacket.Default)
+	pppoed, ok := tmp.Layer(layers.LayerTypePPPoE).(*layers.PPPoE)
+	if !ok {
+		return false
+	}
+
+	var hostUniq []byte
+	for _, tag := range pppoed.Tags {
+		switch tag.Type {
+		case layers.PPPoEDTagTypeServiceNameError, layers.PPPoEDTagTypeACSystemError, layers.PPPoEDTagTypeGenericError:
+			o.stats.pktRxServiceErr++
+			return false
+		case layers.PPPoEDTagTypeHostUniq:
+			hostUniq = tag.Value
+		}
+	}
+	if !bytes.Equal(hostUniq, o.hostUniq) {
+		o.stats.pktRxBadHostUniq++
+		return false
+	}
+	return true
+}
+
 // prepareLayerTemplate processes first received packet (PADO) and prepare layers used many times after
 func (o *PluginPPPClient) prepareLayerTemplate(ps *core.ParserPacketState) {
 
@@ -575,27 +764,77 @@ func (o *PluginPPPClient) handleLCPNegotiation(ps *core.ParserPacketState) {
 
 	switch lcp.Code {
 	case layers.LCPTypeConfigurationRequest:
-		if len(o.peerMagicNumber) == 0 || o.authMethod == 0x0 {
-			for _, option := range lcp.Options {
-				if option.Type == layers.LCPOptionTypeMagicNumber {
-					o.peerMagicNumber = make([]byte, 4)
-					copy(o.peerMagicNumber, option.Value[0:])
-				} else if option.Type == layers.LCPOptionTypeAuthenticationProtocol {
-					tmp := binary.BigEndian.Uint16(option.Value[0:])
-					o.authMethod = layers.PPPType(tmp)
+		if nak := o.checkLCPAuth(lcp.Options); nak != nil {
+			// suggest an authentication protocol we can handle
+			o.stats.pktTxLCPNak++
+			o.lcpNakOptions = nak
+			o.sendLCPMsg(layers.LCPTypeConfigurationNak, lcp.Identifier)
+			return
+		}
+		o.authMethod = 0
+		o.chapAlgorithm = 0
+		for _, option := range lcp.Options {
+			if option.Type == layers.LCPOptionTypeMagicNumber {
+				o.peerMagicNumber = make([]byte, 4)
+				copy(o.peerMagicNumber, option.Value[0:])
+			} else if option.Type == layers.LCPOptionTypeAuthenticationProtocol {
+				tmp := binary.BigEndian.Uint16(option.Value[0:])
+				o.authMethod = layers.PPPType(tmp)
+				if o.authMethod == layers.PPPTypeCHAP {
+					o.chapAlgorithm = option.Value[2]
 				}
 			}
 		}
+		// the Ack carries the same options of the request
+		o.lcpPeerOptions = lcp.Options
 		o.sendLCPAck(lcp.Identifier)
 		o.lcpAckSent = true
 	case layers.LCPTypeConfigurationAck:
 		o.lcpAckReceived = true
-		o.sendLCPConfReq(false)
+	case layers.LCPTypeConfigurationNak:
+		o.stats.pktRxLCPNakRej++
+		for _, option := range lcp.Options {
+			if option.Type == layers.LCPOptionTypeMaximumReceiveUnit && len(option.Value) == 2 {
+				o.mru = binary.BigEndian.Uint16(option.Value)
+				copy(o.maxRecUnitBytes, option.Value)
+			}
+		}
+	case layers.LCPTypeConfigurationReject:
+		o.stats.pktRxLCPNakRej++
+		for _, option := range lcp.Options {
+			if option.Type == layers.LCPOptionTypeMaximumReceiveUnit {
+				o.mruRejected = true
+			}
+		}
 	}
 	// final check on LCP negotiation status
 	o.evaluateLCPNegotiationOver()
 }
 
+// checkLCPAuth returns the Nak options in case the requested authentication protocol is not supported
+func (o *PluginPPPClient) checkLCPAuth(options []layers.LCPOption) []layers.LCPOption {
+	for _, option := range options {
+		if option.Type != layers.LCPOptionTypeAuthenticationProtocol || len(option.Value) < 2 {
+			continue
+		}
+		switch layers.PPPType(binary.BigEndian.Uint16(option.Value[0:])) {
+		case layers.PPPTypePAP:
+			return nil
+		case layers.PPPTypeCHAP:
+			if len(option.Value) == 3 &&
+				(option.Value[2] == layers.CHAPAlgorithmMD5 || option.Value[2] == layers.CHAPAlgorithmMSCHAPv2) {
+				return nil
+			}
+		}
+		return []layers.LCPOption{{
+			Type:   layers.LCPOptionTypeAuthenticationProtocol,
+			Length: 0x5,
+			Value:  []byte{0xc2, 0x23, layers.CHAPAlgorithmMD5},
+		}}
+	}
+	return nil
+}
+
 func (o *PluginPPPClient) evaluateLCPNegotiationOver() {
 
 	if o.lcpAckSent && o.lcpAckReceived {
@@ -690,11 +929,6 @@ func (o *PluginPPPClient) sendLCPMsg(lcpCode layers.LCPType, identifier uint8) {
 			Identifier: identifier,
 			Length:     0, // filled after
 			Options: []layers.LCPOption{
-				{
-					Type:   layers.LCPOptionTypeMaximumReceiveUnit,
-					Length: 0x4,
-					Value:  o.maxRecUnitBytes,
-				},
 				{
 					Type:   layers.LCPOptionTypeMagicNumber,
 					Length: 0x6,
@@ -702,30 +936,34 @@ func (o *PluginPPPClient) sendLCPMsg(lcpCode layers.LCPType, identifier uint8) {
 				},
 			},
 		}
+		if !o.mruRejected {
+			lcp.Options = append([]layers.LCPOption{{
+				Type:   layers.LCPOptionTypeMaximumReceiveUnit,
+				Length: 0x4,
+				Value:  o.maxRecUnitBytes,
+			}}, lcp.Options...)
+		}
 	case layers.LCPTypeConfigurationAck:
-		authMethod := make([]byte, 2)
-		binary.BigEndian.PutUint16(authMethod[0:], uint16(o.authMethod))
 		lcp = &layers.LCP{
 			Code:       layers.LCPTypeConfigurationAck,
 			Identifier: identifier,
 			Length:     0, // filled after
-			Options: []layers.LCPOption{
-				{
-					Type:   layers.LCPOptionTypeMaximumReceiveUnit,
-					Length: 0x4,
-					Value:  o.maxRecUnitBytes, // 1492
-				},
-				{
-					Type:   layers.LCPOptionTypeMagicNumber,
-					Length: 0x6,
-					Value:  o.peerMagicNumber,
-				},
-				{
-					Type:   layers.LCPOptionTypeAuthenticationProtocol,
-					Length: 0x4,
-					Value:  authMethod,
-				},
-			},
+			Options:    o.lcpPeerOptions,
+		}
+	case layers.LCPTypeConfigurationNak:
+		lcp = &layers.LCP{
+			Code:       layers.LCPTypeConfigurationNak,
+			Identifier: identifier,
+			Length:     0, // filled after
+			Options:    o.lcpNakOptions,
+		}
+	case layers.LCPTypeEchoRequest:
+		lcp = &layers.LCP{
+			Code:        layers.LCPTypeEchoRequest,
+			Identifier:  identifier,
+			Length:      0, // filled after
+			Options:     []layers.LCPOption{},
+			MagicNumber: o.localMagicNumber,
 		}
 	case layers.LCPTypeEchoReply:
 		lcp = &layers.LCP{
@@ -760,9 +998,12 @@ func (o *PluginPPPClient) sendLCPMsg(lcpCode layers.LCPType, identifier uint8) {
 	// build raw LCP Msg with layerTwoSession structure and send it
 	tmpLcpMsg := append(o.layerTwoSession, pppoes, ppp, lcp)
 	rawLcpMsg := core.PacketUtlBuild(tmpLcpMsg...)
-	lcpMsg := append(rawLcpMsg)
+	lcpMsg := rawLcpMsg
 
-	o.restartTimer(o.minTimerRetransmitSec)
+	// once the link is up the timer drives the LCP Echo-Request
+	if o.state != PPPStateLinkUp {
+		o.restartTimer(o.minTimerRetransmitSec)
+	}
 	o.Tctx.Veth.SendBuffer(false, o.Client, lcpMsg, false)
 }
 
@@ -793,7 +1034,7 @@ func (o *PluginPPPClient) sendPAPReq() {
 	// build raw PAP with layerTwoSession structure and send it
 	tmpPap := append(o.layerTwoSession, pppoes, ppp, pap)
 	rawPap := core.PacketUtlBuild(tmpPap...)
-	papReq := append(rawPap)
+	papReq := rawPap
 
 	o.restartTimer(o.maxTimerRetransmitSec)
 	o.Tctx.Veth.SendBuffer(false, o.Client, papReq, false)
@@ -810,14 +1051,42 @@ func (o *PluginPPPClient) handlePAPRes(ps *core.ParserPacketState) {
 	pap, _ := papLayer.(*layers.PAP)
 
 	if code := pap.Code; code == layers.PAPTypeAuthAck {
-		o.state = PPPStateIPCPNegotiation
-		o.restartTimer(o.minTimerRetransmitSec)
+		o.stats.authOk++
+		o.onAuthSuccess()
 	} else {
 		LogTimeFormatted(WARNING, ">> PluginPPPClient.handlePAPRes >> Unexpected PAP returned code [%d] on Mac %v",
 			code, o.Client.Mac)
+		o.stats.authFail++
+		o.disconnect()
+	}
+}
+
+// onAuthSuccess moves to the network phase
+func (o *PluginPPPClient) onAuthSuccess() {
+	o.Client.ReachMilestone(core.CLIENT_MS_L2_AUTH)
+	o.state = PPPStateIPCPNegotiation
+	o.restartTimer(o.minTimerRetransmitSec)
+	if o.ipv6.enabled {
+		o.startIpv6cp()
 	}
 }
 
+// sendLCPEchoRequest is called by the timer when the link is up, too many unanswered requests bring the link down
+func (o *PluginPPPClient) sendLCPEchoRequest() {
+	if o.echoOutstanding >= o.echoMaxFail {
+		LogTimeFormatted(WARNING, ">> sendLCPEchoRequest >> No LCP Echo-Reply on Mac %v, link is down",
+			o.Client.Mac)
+		o.stats.echoTimeout++
+		o.disconnect()
+		return
+	}
+	o.echoIdentifier++
+	o.echoOutstanding++
+	o.stats.pktTxEchoReq++
+	o.sendLCPMsg(layers.LCPTypeEchoRequest, o.echoIdentifier)
+	o.restartTimer(o.echoInterval)
+}
+
 func (o *PluginPPPClient) handleIPCPNegotiation(ps *core.ParserPacketState) {
 	m := ps.M
 	p := m.GetData()
@@ -845,6 +1114,12 @@ func (o *PluginPPPClient) handleIPCPNegotiation(ps *core.ParserPacketState) {
 				o.Client.Mac, o.negClientIP, o.pppSessionID)
 			copy(o.clientIP[:], ipcp.GetProposedIPAddress())
 			o.state = PPPStateLinkUp
+			o.Client.ReachMilestone(core.CLIENT_MS_ADDRESS)
+			if o.echoInterval > 0 {
+				o.restartTimer(o.echoInterval)
+			} else if o.timer.IsRunning() {
+				o.timerw.Stop(&o.timer)
+			}
 		} else {
 			// get proposed IP Address as candidate
 			copy(o.negClientIP[:], ipcp.GetProposedIPAddress())
@@ -902,7 +1177,7 @@ func (o *PluginPPPClient) sendIPCPMsg(ipcpCode2Send layers.IPCPType, identifier
 	// build raw IPCP Msg with layerTwoSession structure and send it
 	tmpIpcpMsg := append(o.layerTwoSession, pppoes, ppp, ipcp)
 	rawIpcpMsg := core.PacketUtlBuild(tmpIpcpMsg...)
-	ipcpMsg := append(rawIpcpMsg)
+	ipcpMsg := rawIpcpMsg
 
 	o.restartTimer(o.maxTimerRetransmitSec)
 	o.Tctx.Veth.SendBuffer(false, o.Client, ipcpMsg, false)
@@ -0,0 +1,629 @@
+// Copyright (c) 2021 Eolo S.p.A. and Altran Italia S.p.A. and/or them affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package point2point
+
+/*
+IPv6 over PPP (rfc5072)
+
+IPv6CP negotiates the Interface-Identifier, by default it is the EUI-64 of the
+client MAC. Once IPv6CP is opened the client sends a Router Solicitation
+from its link-local address, an autonomous /64 Prefix Information in the Router
+Advertisement gives a SLAAC address. DHCPv6 runs over the session when the RA
+has the Managed flag (IA_NA) or a delegated prefix is requested (IA_PD).
+
+The SLAAC prefix is installed as the router of the client and the IA_NA address
+as its DHCPv6 address, both are removed when the session is closed. Unsolicited
+RAs refresh the prefix (or withdraw it with a zero valid lifetime), the DHCPv6
+lease is renewed at T1, rebound at T2 and solicited again once it expires.
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"time"
+)
+
+const (
+	IPV6CP_STATE_INIT     = 0
+	IPV6CP_STATE_REQ_SENT = 1
+	IPV6CP_STATE_OPENED   = 2
+	IPV6CP_STATE_REJECTED = 3
+
+	IPV6_ADDR_STATE_IDLE         = 0
+	IPV6_ADDR_STATE_RS_SENT      = 1
+	IPV6_ADDR_STATE_DHCP_SOLICIT = 2
+	IPV6_ADDR_STATE_DHCP_REQUEST = 3
+	IPV6_ADDR_STATE_DONE         = 4
+	IPV6_ADDR_STATE_DHCP_RENEW   = 5
+	IPV6_ADDR_STATE_DHCP_REBIND  = 6
+
+	ipv6cpMaxRetries  = 10
+	ipv6RsMaxRetries  = 3
+	ipv6RsIntervalSec = 4
+	dhcpv6MaxRetries  = 10
+	dhcpv6IaID        = 1
+	dhcpv6InfiniteLft = 0xffffffff
+	dhcpv6MaxLftSec   = 30 * 24 * 3600 // longer leases are renewed as if they were that long
+
+	dhcpv6OptIAAddr = 5
+	ndPrefixFlagA   = 0x40
+)
+
+var ipv6cpStateNames = []string{"init", "req-sent", "opened", "rejected"}
+
+// PluginPPPIpv6Timer drives IPv6CP/RS/DHCPv6 retransmission
+type PluginPPPIpv6Timer struct {
+}
+
+func (o *PluginPPPIpv6Timer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginPPPClient)
+	pi.onIpv6TimerEvent()
+}
+
+// pppIpv6Ctx IPv6 state of a PPP client
+type pppIpv6Ctx struct {
+	enabled    bool
+	pd         bool
+	state      uint8 // IPv6CP state
+	addrState  uint8
+	ackSent    bool
+	ackRecv    bool
+	identifier uint8
+	retries    uint8
+	localIfID  [8]byte
+	peerIfID   [8]byte
+	linkLocal  core.Ipv6Key
+	slaac      core.Ipv6Key
+	slaacValid bool
+	router     core.CClientIpv6Nd // the SLAAC prefix, installed as the router of the client
+	managed    bool
+	dhcpAddr   core.Ipv6Key
+	dhcpValid  bool
+	prefix     core.Ipv6Key
+	prefixLen  uint8
+	t1         uint32 // DHCPv6 renew time in sec, 0 if the lease is not renewed
+	t2         uint32 // DHCPv6 rebind time in sec
+	validLft   uint32 // DHCPv6 lease valid lifetime in sec
+	elapsed    uint32 // sec since the lease was bound, while renewing/rebinding
+	xid        [3]byte
+	serverID   []byte
+	timer      core.CHTimerObj
+	timerCb    PluginPPPIpv6Timer
+}
+
+func (o *PluginPPPClient) restartIpv6Timer(sec uint32) {
+	if o.ipv6.timer.IsRunning() {
+		o.timerw.Stop(&o.ipv6.timer)
+	}
+	o.timerw.Start(&o.ipv6.timer, time.Duration(sec)*time.Second)
+}
+
+func (o *PluginPPPClient) stopIpv6() {
+	if o.ipv6.timer.IsRunning() {
+		o.timerw.Stop(&o.ipv6.timer)
+	}
+}
+
+// releaseIpv6 stops IPv6 and removes the addresses of the session from the client
+func (o *PluginPPPClient) releaseIpv6() {
+	o.stopIpv6()
+	if o.ipv6.dhcpValid {
+		o.Client.UpdateDIPv6(core.Ipv6Key{})
+		o.ipv6.dhcpValid = false
+	}
+	o.withdrawSlaac()
+	o.ipv6.prefixLen = 0
+	o.ipv6.addrState = IPV6_ADDR_STATE_IDLE
+}
+
+func (o *PluginPPPClient) onIpv6TimerEvent() {
+	o.ipv6.retries++
+	switch o.ipv6.state {
+	case IPV6CP_STATE_REQ_SENT:
+		if o.ipv6.retries < ipv6cpMaxRetries {
+			o.sendIPv6CPConfReq()
+		}
+		return
+	case IPV6CP_STATE_OPENED:
+	default:
+		return
+	}
+
+	switch o.ipv6.addrState {
+	case IPV6_ADDR_STATE_RS_SENT:
+		if o.ipv6.retries < ipv6RsMaxRetries {
+			o.sendRS()
+		} else if o.ipv6.pd {
+			// no router advertisement, try to get at least the delegated prefix
+			o.startDhcpv6()
+		}
+	case IPV6_ADDR_STATE_DHCP_SOLICIT:
+		if o.ipv6.retries < dhcpv6MaxRetries {
+			o.sendDhcpv6(layers.DHCPv6MsgTypeSolicit)
+		}
+	case IPV6_ADDR_STATE_DHCP_REQUEST:
+		if o.ipv6.retries < dhcpv6MaxRetries {
+			o.sendDhcpv6(layers.DHCPv6MsgTypeRequest)
+		}
+	case IPV6_ADDR_STATE_DONE:
+		// T1 of the lease
+		o.ipv6.elapsed = o.ipv6.t1
+		o.ipv6.addrState = IPV6_ADDR_STATE_DHCP_RENEW
+		o.newDhcpv6Xid()
+		o.sendDhcpv6(layers.DHCPv6MsgTypeRenew)
+	case IPV6_ADDR_STATE_DHCP_RENEW:
+		o.ipv6.elapsed += o.dhcpv6RetransmitSec()
+		if o.ipv6.elapsed >= o.ipv6.t2 {
+			// the server does not answer, ask any server
+			o.ipv6.serverID = nil
+			o.ipv6.addrState = IPV6_ADDR_STATE_DHCP_REBIND
+			o.newDhcpv6Xid()
+			o.sendDhcpv6(layers.DHCPv6MsgTypeRebind)
+		} else {
+			o.sendDhcpv6(layers.DHCPv6MsgTypeRenew)
+		}
+	case IPV6_ADDR_STATE_DHCP_REBIND:
+		o.ipv6.elapsed += o.dhcpv6RetransmitSec()
+		if o.ipv6.elapsed >= o.ipv6.validLft {
+			o.expireDhcpv6()
+		} else {
+			o.sendDhcpv6(layers.DHCPv6MsgTypeRebind)
+		}
+	}
+}
+
+// startIpv6cp starts IPv6CP with an EUI-64 Interface-Identifier
+func (o *PluginPPPClient) startIpv6cp() {
+	mac := o.Client.Mac
+	o.ipv6.localIfID = [8]byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	o.ipv6.state = IPV6CP_STATE_REQ_SENT
+	o.ipv6.addrState = IPV6_ADDR_STATE_IDLE
+	o.ipv6.ackSent = false
+	o.ipv6.ackRecv = false
+	o.ipv6.retries = 0
+	o.sendIPv6CPConfReq()
+}
+
+func (o *PluginPPPClient) sendIPv6CPConfReq() {
+	o.ipv6.identifier++
+	o.sendIPv6CPMsg(layers.LCPTypeConfigurationRequest, o.ipv6.identifier, []layers.IPv6CPOption{{
+		Type:  layers.IPv6CPOptionTypeInterfaceID,
+		Value: o.ipv6.localIfID[:],
+	}})
+	o.restartIpv6Timer(o.minTimerRetransmitSec)
+}
+
+func (o *PluginPPPClient) sendIPv6CPMsg(code layers.LCPType, identifier uint8, options []layers.IPv6CPOption) {
+	// PPPoES
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.pppSessionID,
+		Length:    0x0, // filled in next lines of code
+		Tags:      []layers.PPPoEDTag{},
+	}
+	// PPP
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeIPv6CP,
+	}
+	// IPv6CP
+	ipv6cp := &layers.IPv6CP{
+		Code:       code,
+		Identifier: identifier,
+		Options:    options,
+	}
+	ipv6cp.Length = ipv6cp.GetIPv6CPSize()
+	pppoes.Length = ipv6cp.Length + 2 // PPP layer size in byte
+
+	tmpMsg := append(o.layerTwoSession, pppoes, ppp, ipv6cp)
+	msg := core.PacketUtlBuild(tmpMsg...)
+	o.Tctx.Veth.SendBuffer(false, o.Client, msg, false)
+}
+
+func (o *PluginPPPClient) handleIPv6CP(ps *core.ParserPacketState) {
+	o.stats.pktRxIPv6CP++
+
+	tmp := gopacket.NewPacket(ps.M.GetData(), layers.LayerTypeEthernet, gopacket.Default)
+	ipv6cp, ok := tmp.Layer(layers.LayerTypeIPv6CP).(*layers.IPv6CP)
+	if !ok {
+		o.stats.pktRxIPv6ParserErr++
+		return
+	}
+
+	switch ipv6cp.Code {
+	case layers.LCPTypeConfigurationRequest:
+		if id := ipv6cp.GetInterfaceID(); id != nil {
+			copy(o.ipv6.peerIfID[:], id)
+		}
+		o.sendIPv6CPMsg(layers.LCPTypeConfigurationAck, ipv6cp.Identifier, ipv6cp.Options)
+		o.ipv6.ackSent = true
+	case layers.LCPTypeConfigurationAck:
+		if ipv6cp.Identifier == o.ipv6.identifier {
+			o.ipv6.ackRecv = true
+		}
+	case layers.LCPTypeConfigurationNak:
+		// the server suggests another Interface-Identifier
+		if id := ipv6cp.GetInterfaceID(); id != nil {
+			copy(o.ipv6.localIfID[:], id)
+		}
+		if o.ipv6.state == IPV6CP_STATE_REQ_SENT {
+			o.sendIPv6CPConfReq()
+		}
+	case layers.LCPTypeConfigurationReject:
+		o.stats.ipv6cpReject++
+		o.ipv6.state = IPV6CP_STATE_REJECTED
+		o.stopIpv6()
+	case layers.LCPTypeTerminateRequest:
+		o.sendIPv6CPMsg(layers.LCPTypeTerminateAck, ipv6cp.Identifier, nil)
+		o.ipv6.state = IPV6CP_STATE_INIT
+		o.releaseIpv6()
+	}
+
+	if o.ipv6.state == IPV6CP_STATE_REQ_SENT && o.ipv6.ackSent && o.ipv6.ackRecv {
+		o.onIpv6cpOpened()
+	}
+}
+
+// handleProtocolReject handles LCP Protocol-Reject, only IPv6CP can be rejected by the server
+func (o *PluginPPPClient) handleProtocolReject(ps *core.ParserPacketState) {
+	p := ps.M.GetData()
+	offset := 26 // LCP data after code, identifier and length
+	if o.vlanTagged {
+		offset = 30
+	}
+	if len(p) < offset+2 {
+		return
+	}
+	if layers.PPPType(binary.BigEndian.Uint16(p[offset:offset+2])) == layers.PPPTypeIPv6CP &&
+		o.ipv6.enabled && o.ipv6.state == IPV6CP_STATE_REQ_SENT {
+		o.stats.ipv6cpReject++
+		o.ipv6.state = IPV6CP_STATE_REJECTED
+		o.stopIpv6()
+	}
+}
+
+func (o *PluginPPPClient) onIpv6cpOpened() {
+	o.stats.ipv6cpUp++
+	o.ipv6.state = IPV6CP_STATE_OPENED
+	o.ipv6.linkLocal = core.Ipv6Key{0xfe, 0x80}
+	copy(o.ipv6.linkLocal[8:], o.ipv6.localIfID[:])
+	o.ipv6.addrState = IPV6_ADDR_STATE_RS_SENT
+	o.ipv6.retries = 0
+	o.sendRS()
+}
+
+// sendIPv6Pkt sends an IPv6 packet over the session, lengths and checksums are calculated
+func (o *PluginPPPClient) sendIPv6Pkt(ipv6 *layers.IPv6, l ...gopacket.SerializableLayer) {
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.pppSessionID,
+		Tags:      []layers.PPPoEDTag{},
+	}
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeIPv6,
+	}
+
+	var pkt []gopacket.SerializableLayer
+	pkt = append(pkt, o.layerTwoSession...)
+	pkt = append(pkt, pppoes, ppp, ipv6)
+	pkt = append(pkt, l...)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if gopacket.SerializeLayers(buf, opts, pkt...) != nil {
+		return
+	}
+	o.Tctx.Veth.SendBuffer(false, o.Client, buf.Bytes(), false)
+}
+
+func (o *PluginPPPClient) sendRS() {
+	ipv6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+		SrcIP:      o.ipv6.linkLocal.ToIP(),
+		DstIP:      net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02},
+	}
+	icmp := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterSolicitation, 0),
+	}
+	icmp.SetNetworkLayerForChecksum(ipv6)
+
+	o.stats.pktTxRs++
+	o.sendIPv6Pkt(ipv6, icmp, &layers.ICMPv6RouterSolicitation{})
+	o.restartIpv6Timer(ipv6RsIntervalSec)
+}
+
+func (o *PluginPPPClient) handleIPv6(ps *core.ParserPacketState) {
+	if o.ipv6.state != IPV6CP_STATE_OPENED {
+		return
+	}
+
+	tmp := gopacket.NewPacket(ps.M.GetData(), layers.LayerTypeEthernet, gopacket.Default)
+	if ra, ok := tmp.Layer(layers.LayerTypeICMPv6RouterAdvertisement).(*layers.ICMPv6RouterAdvertisement); ok {
+		o.handleRA(ra)
+		return
+	}
+	if dhcp, ok := tmp.Layer(layers.LayerTypeDHCPv6).(*layers.DHCPv6); ok {
+		if udp, ok := tmp.Layer(layers.LayerTypeUDP).(*layers.UDP); ok && udp.DstPort == 546 {
+			o.handleDhcpv6(dhcp)
+		}
+		return
+	}
+	if tmp.ErrorLayer() != nil {
+		o.stats.pktRxIPv6ParserErr++
+	}
+}
+
+// installSlaac sets the SLAAC address of the prefix and installs the prefix as the router of the client
+func (o *PluginPPPClient) installSlaac(prefix []byte) {
+	copy(o.ipv6.slaac[0:8], prefix[0:8])
+	copy(o.ipv6.slaac[8:], o.ipv6.localIfID[:])
+	o.ipv6.slaacValid = true
+	o.ipv6.router = core.CClientIpv6Nd{MTU: o.mru, PrefixLen: 64, IPv6: o.ipv6.slaac}
+	copy(o.ipv6.router.PrefixIpv6[0:8], prefix[0:8])
+	o.Client.Ipv6Router = &o.ipv6.router
+}
+
+func (o *PluginPPPClient) withdrawSlaac() {
+	o.ipv6.slaacValid = false
+	if o.Client.Ipv6Router == &o.ipv6.router {
+		o.Client.Ipv6Router = nil
+	}
+}
+
+func (o *PluginPPPClient) handleRA(ra *layers.ICMPv6RouterAdvertisement) {
+	o.stats.pktRxRa++
+	if o.ipv6.addrState == IPV6_ADDR_STATE_IDLE {
+		return
+	}
+
+	for _, opt := range ra.Options {
+		if opt.Type != layers.ICMPv6OptPrefixInfo || len(opt.Data) < 30 {
+			continue
+		}
+		prefixLen := opt.Data[0]
+		if opt.Data[1]&ndPrefixFlagA == 0 || prefixLen != 64 {
+			continue
+		}
+		if binary.BigEndian.Uint32(opt.Data[2:6]) == 0 {
+			// the router withdraws the prefix
+			if o.ipv6.slaacValid && bytes.Equal(o.ipv6.slaac[0:8], opt.Data[14:22]) {
+				o.stats.slaacWithdrawn++
+				o.withdrawSlaac()
+			}
+			continue
+		}
+		o.installSlaac(opt.Data[14:22])
+		break
+	}
+
+	if o.ipv6.addrState != IPV6_ADDR_STATE_RS_SENT {
+		// an unsolicited RA only refreshes the prefix
+		return
+	}
+
+	o.ipv6.managed = ra.ManagedAddressConfig()
+	if o.ipv6.managed || o.ipv6.pd {
+		o.startDhcpv6()
+	} else {
+		o.ipv6.addrState = IPV6_ADDR_STATE_DONE
+		o.stopIpv6()
+	}
+}
+
+func (o *PluginPPPClient) newDhcpv6Xid() {
+	binary.BigEndian.PutUint16(o.ipv6.xid[0:2], uint16(o.Ns.Rand().Uint32()))
+	o.ipv6.xid[2] = uint8(o.Ns.Rand().Uint32())
+}
+
+func (o *PluginPPPClient) dhcpv6RetransmitSec() uint32 {
+	return o.minTimerRetransmitSec * 2
+}
+
+func (o *PluginPPPClient) startDhcpv6() {
+	o.newDhcpv6Xid()
+	o.ipv6.serverID = nil
+	o.ipv6.retries = 0
+	o.ipv6.addrState = IPV6_ADDR_STATE_DHCP_SOLICIT
+	o.sendDhcpv6(layers.DHCPv6MsgTypeSolicit)
+}
+
+func (o *PluginPPPClient) sendDhcpv6(msgType layers.DHCPv6MsgType) {
+	clientID := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: o.Client.Mac[:]}
+	ia := make([]byte, 12) // IAID, T1, T2
+	binary.BigEndian.PutUint32(ia[0:4], dhcpv6IaID)
+	iaNa, iaPd := ia, ia
+	if msgType == layers.DHCPv6MsgTypeRenew || msgType == layers.DHCPv6MsgTypeRebind {
+		// the leases to extend
+		if o.ipv6.dhcpValid {
+			iaAddr := make([]byte, 24) // address, preferred and valid lifetime
+			copy(iaAddr[0:16], o.ipv6.dhcpAddr[:])
+			iaNa = append(append([]byte{}, ia...), dhcpv6SubOption(dhcpv6OptIAAddr, iaAddr)...)
+		}
+		if o.ipv6.prefixLen > 0 {
+			iaPrefix := make([]byte, 25) // preferred and valid lifetime, prefix length, prefix
+			iaPrefix[8] = o.ipv6.prefixLen
+			copy(iaPrefix[9:25], o.ipv6.prefix[:])
+			iaPd = append(append([]byte{}, ia...), dhcpv6SubOption(uint16(layers.DHCPv6OptIAPrefix), iaPrefix)...)
+		}
+	}
+
+	dhcp := &layers.DHCPv6{MsgType: msgType, TransactionID: o.ipv6.xid[:]}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientID.Encode()))
+	if o.ipv6.serverID != nil {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.ipv6.serverID))
+	}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0, 0}))
+	if o.ipv6.managed {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iaNa))
+	}
+	if o.ipv6.pd {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iaPd))
+	}
+
+	ipv6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolUDP,
+		HopLimit:   1,
+		SrcIP:      o.ipv6.linkLocal.ToIP(),
+		DstIP:      net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0, 0x02},
+	}
+	udp := &layers.UDP{SrcPort: 546, DstPort: 547}
+	udp.SetNetworkLayerForChecksum(ipv6)
+
+	o.stats.pktTxDhcpv6++
+	o.sendIPv6Pkt(ipv6, udp, dhcp)
+	o.restartIpv6Timer(o.dhcpv6RetransmitSec())
+}
+
+// dhcpv6SubOptions walks the options found inside IA_NA/IA_PD
+func dhcpv6SubOptions(data []byte, cb func(code uint16, value []byte)) {
+	for len(data) >= 4 {
+		code := binary.BigEndian.Uint16(data[0:2])
+		l := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+l {
+			return
+		}
+		cb(code, data[4:4+l])
+		data = data[4+l:]
+	}
+}
+
+// dhcpv6SubOption encodes an option carried inside IA_NA/IA_PD
+func dhcpv6SubOption(code uint16, value []byte) []byte {
+	b := make([]byte, 4+len(value))
+	binary.BigEndian.PutUint16(b[0:2], code)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(value)))
+	copy(b[4:], value)
+	return b
+}
+
+// minLft returns the shorter lifetime, 0 stands for none
+func minLft(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (o *PluginPPPClient) handleDhcpv6(dhcp *layers.DHCPv6) {
+	o.stats.pktRxDhcpv6++
+	if !bytes.Equal(dhcp.TransactionID, o.ipv6.xid[:]) {
+		return
+	}
+
+	var serverID []byte
+	var addr, prefix []byte
+	var prefixLen uint8
+	var t1, t2, validLft uint32
+	for _, opt := range dhcp.Options {
+		switch opt.Code {
+		case layers.DHCPv6OptServerID:
+			serverID = opt.Data
+		case layers.DHCPv6OptIANA:
+			if len(opt.Data) >= 12 {
+				t1 = minLft(t1, binary.BigEndian.Uint32(opt.Data[4:8]))
+				t2 = minLft(t2, binary.BigEndian.Uint32(opt.Data[8:12]))
+				dhcpv6SubOptions(opt.Data[12:], func(code uint16, value []byte) {
+					if code == dhcpv6OptIAAddr && len(value) >= 24 {
+						addr = value[0:16]
+						validLft = minLft(validLft, binary.BigEndian.Uint32(value[20:24]))
+					}
+				})
+			}
+		case layers.DHCPv6OptIAPD:
+			if len(opt.Data) >= 12 {
+				t1 = minLft(t1, binary.BigEndian.Uint32(opt.Data[4:8]))
+				t2 = minLft(t2, binary.BigEndian.Uint32(opt.Data[8:12]))
+				dhcpv6SubOptions(opt.Data[12:], func(code uint16, value []byte) {
+					if code == uint16(layers.DHCPv6OptIAPrefix) && len(value) >= 25 {
+						prefixLen = value[8]
+						prefix = value[9:25]
+						validLft = minLft(validLft, binary.BigEndian.Uint32(value[4:8]))
+					}
+				})
+			}
+		}
+	}
+	if serverID == nil {
+		return
+	}
+
+	switch {
+	case dhcp.MsgType == layers.DHCPv6MsgTypeAdverstise && o.ipv6.addrState == IPV6_ADDR_STATE_DHCP_SOLICIT:
+		o.ipv6.serverID = append([]byte{}, serverID...)
+		o.ipv6.retries = 0
+		o.ipv6.addrState = IPV6_ADDR_STATE_DHCP_REQUEST
+		o.sendDhcpv6(layers.DHCPv6MsgTypeRequest)
+	case dhcp.MsgType == layers.DHCPv6MsgTypeReply && o.ipv6.addrState == IPV6_ADDR_STATE_DHCP_REQUEST:
+		o.stats.dhcpv6Bound++
+		o.bindDhcpv6(serverID, addr, prefix, prefixLen, t1, t2, validLft)
+	case dhcp.MsgType == layers.DHCPv6MsgTypeReply &&
+		(o.ipv6.addrState == IPV6_ADDR_STATE_DHCP_RENEW || o.ipv6.addrState == IPV6_ADDR_STATE_DHCP_REBIND):
+		if addr == nil && prefix == nil {
+			// the server does not extend the lease
+			o.expireDhcpv6()
+			return
+		}
+		o.stats.dhcpv6Renewed++
+		o.bindDhcpv6(serverID, addr, prefix, prefixLen, t1, t2, validLft)
+	}
+}
+
+// bindDhcpv6 installs the leased address on the client and schedules the renewal at T1
+func (o *PluginPPPClient) bindDhcpv6(serverID, addr, prefix []byte, prefixLen uint8, t1, t2, validLft uint32) {
+	o.ipv6.serverID = append([]byte{}, serverID...)
+	if addr != nil {
+		copy(o.ipv6.dhcpAddr[:], addr)
+		o.ipv6.dhcpValid = true
+		o.Client.UpdateDIPv6(o.ipv6.dhcpAddr)
+	}
+	if prefix != nil {
+		copy(o.ipv6.prefix[:], prefix)
+		o.ipv6.prefixLen = prefixLen
+	}
+	o.ipv6.addrState = IPV6_ADDR_STATE_DONE
+	o.ipv6.retries = 0
+	o.stopIpv6()
+
+	if validLft == 0 || validLft == dhcpv6InfiniteLft {
+		return
+	}
+	if validLft > dhcpv6MaxLftSec {
+		validLft = dhcpv6MaxLftSec
+	}
+	// RFC 8415 18.2.4, the client picks T1/T2 when the server leaves them to it
+	if t1 == 0 || t1 >= validLft {
+		t1 = validLft / 2
+	}
+	if t2 == 0 || t2 <= t1 || t2 >= validLft {
+		t2 = validLft / 5 * 4
+	}
+	if t1 == 0 || t2 <= t1 {
+		return
+	}
+	o.ipv6.t1, o.ipv6.t2, o.ipv6.validLft = t1, t2, validLft
+	o.restartIpv6Timer(t1)
+}
+
+// expireDhcpv6 drops the lease and solicits a new one
+func (o *PluginPPPClient) expireDhcpv6() {
+	o.stats.dhcpv6Expired++
+	if o.ipv6.dhcpValid {
+		o.Client.UpdateDIPv6(core.Ipv6Key{})
+		o.ipv6.dhcpValid = false
+	}
+	o.ipv6.prefixLen = 0
+	o.startDhcpv6()
+}
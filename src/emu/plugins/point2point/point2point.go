@@ -7,7 +7,6 @@ package point2point
 
 import (
 	"emu/core"
-	"fmt"
 
 	"external/google/gopacket/layers"
 )
@@ -62,20 +61,24 @@ func (o *PluginPPPClient) onTimerEvent() {
 		// if one ack for direction is sent step next status
 		o.evaluateLCPNegotiationOver()
 	case PPPStatePAPSent:
-		// send Again PAP Request
-		if o.authMethod == layers.PPPTypePAP {
+		switch o.authMethod {
+		case layers.PPPTypePAP:
+			// send Again PAP Request
 			o.sendPAPReq()
-		} else {
-			msg := fmt.Sprintf("Unsupported Authentication Protocol on Mac %v", o.Client.Mac)
-			LogTimeFormatted(ERROR, msg)
-			panic(msg)
+		case layers.PPPTypeCHAP:
+			// wait for the challenge of the authenticator
+		default:
+			// the server did not ask for authentication
+			o.onAuthSuccess()
 		}
 	case PPPStateIPCPNegotiation:
 		// send Again IPCP configuration request/ack
 		o.sendIPCPConfReq()
 	case PPPStateLinkUp:
 		// send LCP Echo Request
-		//o.SendLCPEchoRequest()
+		if o.echoInterval > 0 {
+			o.sendLCPEchoRequest()
+		}
 	case PPPStatePADTSent:
 		// Send Again PADT (max nr of times)
 		o.sendPADT()
@@ -95,6 +98,10 @@ func (o *PluginPPPNs) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 	client := o.Ns.CLookupByMac(&mackey)
 
 	if client == nil {
+		// PADI is broadcast, it is handled by the access concentrator
+		if o.server != nil {
+			return o.server.srv.HandleRxPacket(ps)
+		}
 		return core.PARSER_ERR
 	}
 
@@ -109,6 +116,7 @@ func (o *PluginPPPNs) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 // PluginPPPNs information per namespace
 type PluginPPPNs struct {
 	core.PluginBase
+	server *PluginPPPClient // access concentrator of the namespace
 }
 
 // NewPPPNs handles creation of namespace
@@ -0,0 +1,547 @@
+// Copyright (c) 2021 Eolo S.p.A. and Altran Italia S.p.A. and/or them affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package point2point
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+var monitor int
+
+var srvTestMac = core.MACKey{0, 0, 1, 0, 0, 0x10}
+
+func clientTestMac(i int) core.MACKey {
+	return core.MACKey{0, 0, 1, 0, 0, byte(i + 1)}
+}
+
+var (
+	dhcpv6TestAddr   = net.IP{0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00}
+	dhcpv6TestPrefix = net.IP{0x20, 0x01, 0x0d, 0xb8, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+)
+
+/*
+VethPPPSim loops the packets back, so the access concentrator and the clients of the same ns
+talk to each other. It can drop PADS to make the clients retransmit PADR, and answer DHCPv6 of
+the clients in place of the server (the access concentrator does not run a DHCPv6 server).
+*/
+type VethPPPSim struct {
+	tctx        *core.CThreadCtx
+	dropPADS    int    // PADS to drop
+	managed     bool   // set the Managed flag in the RAs of the server
+	dhcpv6      bool   // answer DHCPv6 of the clients
+	dhcpv6Reply int    // answer only the first requests, then let the lease expire, -1 means always
+	t1, t2, lft uint32 // lease times
+}
+
+func (o *VethPPPSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	pkt := gopacket.NewPacket(m.GetData(), layers.LayerTypeEthernet, gopacket.Default)
+	if l := pkt.Layer(layers.LayerTypePPPoE); l != nil && o.dropPADS > 0 {
+		if l.(*layers.PPPoE).Code == layers.PPPoECodePADS {
+			o.dropPADS--
+			m.FreeMbuf()
+			return nil
+		}
+	}
+	if l := pkt.Layer(layers.LayerTypeICMPv6RouterAdvertisement); l != nil && o.managed {
+		ra := l.(*layers.ICMPv6RouterAdvertisement)
+		ra.Flags |= 0x80
+		m1 := o.rebuild(m, pkt, ra)
+		m.FreeMbuf()
+		return m1
+	}
+	if l := pkt.Layer(layers.LayerTypeDHCPv6); l != nil && o.dhcpv6 {
+		var m1 *core.Mbuf
+		if r := o.dhcpv6Answer(l.(*layers.DHCPv6)); r != nil {
+			m1 = o.rebuild(m, pkt, r)
+		}
+		m.FreeMbuf()
+		return m1
+	}
+	return m
+}
+
+// rebuild returns the packet with a new payload, the reply of a DHCPv6 request goes back to the client
+func (o *VethPPPSim) rebuild(m *core.Mbuf, pkt gopacket.Packet, payload gopacket.SerializableLayer) *core.Mbuf {
+	eth := *pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ipv6 := *pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	pppoe := *pkt.Layer(layers.LayerTypePPPoE).(*layers.PPPoE)
+	ppp := *pkt.Layer(layers.LayerTypePPP).(*layers.PPP)
+	l := []gopacket.SerializableLayer{&eth}
+	for _, d := range pkt.Layers() {
+		if d.LayerType() == layers.LayerTypeDot1Q {
+			dot1q := *d.(*layers.Dot1Q)
+			l = append(l, &dot1q)
+		}
+	}
+	l = append(l, &pppoe, &ppp, &ipv6)
+	if _, ok := payload.(*layers.DHCPv6); ok {
+		eth.SrcMAC, eth.DstMAC = eth.DstMAC, eth.SrcMAC
+		ipv6.DstIP = ipv6.SrcIP
+		ipv6.SrcIP = net.IP{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+		udp := &layers.UDP{SrcPort: 547, DstPort: 546}
+		udp.SetNetworkLayerForChecksum(&ipv6)
+		l = append(l, udp)
+	} else {
+		icmp := *pkt.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+		icmp.SetNetworkLayerForChecksum(&ipv6)
+		l = append(l, &icmp)
+	}
+	l = append(l, payload)
+
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, l...)
+	b := buf.Bytes()
+	m1 := o.tctx.MPool.Alloc(uint16(len(b)))
+	m1.SetVPort(m.VPort())
+	m1.Append(b)
+	return m1
+}
+
+func (o *VethPPPSim) dhcpv6Answer(req *layers.DHCPv6) *layers.DHCPv6 {
+	var msgType layers.DHCPv6MsgType
+	switch req.MsgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		msgType = layers.DHCPv6MsgTypeAdverstise
+	case layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+		if o.dhcpv6Reply == 0 {
+			return nil
+		}
+		if o.dhcpv6Reply > 0 {
+			o.dhcpv6Reply--
+		}
+		msgType = layers.DHCPv6MsgTypeReply
+	default:
+		return nil
+	}
+
+	serverID := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: srvTestMac[:]}
+	r := &layers.DHCPv6{MsgType: msgType, TransactionID: req.TransactionID}
+	r.Options = append(r.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, serverID.Encode()))
+	for _, opt := range req.Options {
+		ia := make([]byte, 12)
+		copy(ia[0:4], opt.Data)
+		binary.BigEndian.PutUint32(ia[4:8], o.t1)
+		binary.BigEndian.PutUint32(ia[8:12], o.t2)
+		switch opt.Code {
+		case layers.DHCPv6OptClientID:
+			r.Options = append(r.Options, layers.NewDHCPv6Option(opt.Code, opt.Data))
+		case layers.DHCPv6OptIANA:
+			iaAddr := make([]byte, 24)
+			copy(iaAddr[0:16], dhcpv6TestAddr)
+			binary.BigEndian.PutUint32(iaAddr[16:20], o.lft)
+			binary.BigEndian.PutUint32(iaAddr[20:24], o.lft)
+			r.Options = append(r.Options, layers.NewDHCPv6Option(opt.Code, append(ia, dhcpv6SubOption(dhcpv6OptIAAddr, iaAddr)...)))
+		case layers.DHCPv6OptIAPD:
+			iaPrefix := make([]byte, 25)
+			binary.BigEndian.PutUint32(iaPrefix[0:4], o.lft)
+			binary.BigEndian.PutUint32(iaPrefix[4:8], o.lft)
+			iaPrefix[8] = 56
+			copy(iaPrefix[9:25], dhcpv6TestPrefix)
+			r.Options = append(r.Options, layers.NewDHCPv6Option(opt.Code, append(ia, dhcpv6SubOption(uint16(layers.DHCPv6OptIAPrefix), iaPrefix)...)))
+		}
+	}
+	return r
+}
+
+// pppTestAction runs an action in the middle of the simulation
+type pppTestAction struct {
+	timer core.CHTimerObj
+	fn    func()
+}
+
+func (o *pppTestAction) OnEvent(a, b interface{}) {
+	o.fn()
+}
+
+type PPPTestBase struct {
+	testname string
+	duration time.Duration
+	srvInit  string
+	clients  []string
+	veth     VethPPPSim
+	after    time.Duration                              // when to run the action
+	action   func(srv *pppServer, c []*PluginPPPClient) // optional
+	check    func(t *testing.T, srv *pppServer, c []*PluginPPPClient)
+}
+
+func getPPPPlug(t *testing.T, ns *core.CNSCtx, mac *core.MACKey) *PluginPPPClient {
+	c := ns.CLookupByMac(mac)
+	if c == nil {
+		t.Fatalf(" can't find client %v", *mac)
+	}
+	cplg := c.PluginCtx.Get(PPPPlugin)
+	if cplg == nil {
+		t.Fatalf(" can't find plugin")
+	}
+	return cplg.Ext.(*PluginPPPClient)
+}
+
+func (o *PPPTestBase) Run(t *testing.T) {
+	simVeth := o.veth
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	simVeth.tctx = tctx
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	tctx.RegisterParserCb("ppp")
+
+	// the access concentrator must exist before the clients send PADI
+	macs := []core.MACKey{srvTestMac}
+	inits := []string{o.srvInit}
+	for i, init := range o.clients {
+		macs = append(macs, clientTestMac(i))
+		inits = append(inits, init)
+	}
+	for i := range macs {
+		client := core.NewClient(ns, macs[i], core.Ipv4Key{0, 0, 0, 0}, core.Ipv6Key{}, core.Ipv4Key{0, 0, 0, 0})
+		ns.AddClient(client)
+		if err := client.PluginCtx.CreatePlugins([]string{PPPPlugin}, [][]byte{[]byte(inits[i])}); err != nil {
+			t.Fatalf(" can't create plugin: %v", err)
+		}
+	}
+
+	srv := getPPPPlug(t, ns, &srvTestMac).srv
+	var clients []*PluginPPPClient
+	for i := range o.clients {
+		mac := clientTestMac(i)
+		clients = append(clients, getPPPPlug(t, ns, &mac))
+	}
+
+	var action pppTestAction
+	if o.action != nil {
+		action.fn = func() { o.action(srv, clients) }
+		action.timer.SetCB(&action, nil, nil)
+		tctx.GetTimerCtx().Start(&action.timer, o.after)
+	}
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, true)
+	tctx.MainLoopSim(o.duration)
+
+	srv.cdbv.Dump()
+	tctx.SimRecordAppend(srv.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(srv.GetSessions())
+	for _, c := range clients {
+		c.cdbv.Dump()
+		tctx.SimRecordAppend(c.cdbv.MarshalValues(false))
+		tctx.SimRecordAppend(c.GetPPPClientInfo())
+	}
+	o.check(t, srv, clients)
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func checkLinkUp(t *testing.T, c *PluginPPPClient, auth string) {
+	if c.state != PPPStateLinkUp {
+		t.Fatalf(" client %v state %s, expected link up", c.Client.Mac, pppStateNames[c.state])
+	}
+	if info := c.GetPPPClientInfo(); info.Auth != auth {
+		t.Fatalf(" client %v auth %s, expected %s", c.Client.Mac, info.Auth, auth)
+	}
+}
+
+func checkSessions(t *testing.T, srv *pppServer, n int) {
+	if s := srv.GetSessions(); len(s) != n {
+		t.Fatalf(" %d server sessions, expected %d: %+v", len(s), n, s)
+	}
+}
+
+const pppSrvUsers = `"users": {"alice": "secret", "bob": "secret2", "carol": "secret3"}, "pool_start": "10.0.0.100", "pool_size": 16, "local_ip": "10.0.0.1"`
+
+func TestPluginPPPServer_pap(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_server_pap",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"ac_name": "emu", "auth": "pap", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret"}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "pap")
+			checkSessions(t, srv, 1)
+			if ip := c[0].GetPPPClientIP(); ip != "10.0.0.100" {
+				t.Fatalf(" client ip %s", ip)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_chap(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_chap",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"auth": "chap", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret"}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "chap-md5")
+			checkSessions(t, srv, 1)
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_mschapv2(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_mschapv2",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"auth": "mschapv2", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret"}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "mschapv2")
+			checkSessions(t, srv, 1)
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_chap_wrong_password(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_chap_wrong_password",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"auth": "chap", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "wrong"}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			if c[0].state == PPPStateLinkUp {
+				t.Fatalf(" client is up with a wrong password")
+			}
+			if c[0].stats.authFail == 0 {
+				t.Fatalf(" authentication failure is not counted")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPServer_max_sessions(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_server_max_sessions",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"max_sessions": 2, ` + pppSrvUsers + `}}`,
+		clients: []string{
+			`{"user": "alice", "password": "secret"}`,
+			`{"user": "bob", "password": "secret2"}`,
+			`{"user": "carol", "password": "secret3"}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkSessions(t, srv, 2)
+			checkLinkUp(t, c[0], "pap")
+			checkLinkUp(t, c[1], "pap")
+			if c[2].hasSession() {
+				t.Fatalf(" third client has a session, state %s", pppStateNames[c[2].state])
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPServer_padr_retransmit(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_server_padr_retransmit",
+		duration: 15 * time.Second,
+		srvInit:  `{"server": {` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret", "host_uniq": "emu-1"}`},
+		veth:     VethPPPSim{dropPADS: 1},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "pap")
+			checkSessions(t, srv, 1)
+			if srv.stats.pktRxPADRRetransmit != 1 {
+				t.Fatalf(" %d PADR retransmits, expected 1", srv.stats.pktRxPADRRetransmit)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPServer_teardown(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_server_teardown",
+		duration: 15 * time.Second,
+		srvInit:  `{"server": {` + pppSrvUsers + `}}`,
+		clients: []string{
+			`{"user": "alice", "password": "secret"}`,
+			`{"user": "bob", "password": "secret2"}`},
+		after: 10 * time.Second,
+		action: func(srv *pppServer, c []*PluginPPPClient) {
+			// the server closes the first session, the second client leaves by itself
+			srv.Disconnect(c[0].GetPPPSessionID())
+			c[1].disconnect()
+		},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkSessions(t, srv, 0)
+			if c[0].state != PPPStatePADTReceived {
+				t.Fatalf(" client state %s, expected PADT received", pppStateNames[c[0].state])
+			}
+			if c[1].hasSession() {
+				t.Fatalf(" client still has a session, state %s", pppStateNames[c[1].state])
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_ipv6_slaac(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_ipv6_slaac",
+		duration: 10 * time.Second,
+		srvInit:  `{"server": {"ipv6_prefix": "2001:db8:10::/64", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret", "ipv6": true}`},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "pap")
+			info := c[0].GetPPPClientInfo()
+			if info.Ipv6cp != "opened" || info.Ipv6Slaac == "" {
+				t.Fatalf(" unexpected IPv6 state %+v", info)
+			}
+			r := c[0].Client.Ipv6Router
+			if r == nil || r.IPv6 != c[0].ipv6.slaac || r.PrefixLen != 64 {
+				t.Fatalf(" SLAAC prefix is not installed on the client %+v", r)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_ipv6_slaac_teardown(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_ipv6_slaac_teardown",
+		duration: 15 * time.Second,
+		srvInit:  `{"server": {"ipv6_prefix": "2001:db8:10::/64", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret", "ipv6": true}`},
+		after:    10 * time.Second,
+		action: func(srv *pppServer, c []*PluginPPPClient) {
+			srv.Disconnect(c[0].GetPPPSessionID())
+		},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			if c[0].Client.Ipv6Router != nil || c[0].ipv6.slaacValid {
+				t.Fatalf(" SLAAC prefix is not removed from the client")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_dhcpv6(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_dhcpv6",
+		duration: 30 * time.Second,
+		srvInit:  `{"server": {"ipv6_prefix": "2001:db8:10::/64", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret", "ipv6": true, "dhcpv6_pd": true}`},
+		veth:     VethPPPSim{managed: true, dhcpv6: true, dhcpv6Reply: -1, t1: 8, t2: 12, lft: 20},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			checkLinkUp(t, c[0], "pap")
+			info := c[0].GetPPPClientInfo()
+			if info.Ipv6Dhcp != dhcpv6TestAddr.String() || info.Ipv6Prefix != dhcpv6TestPrefix.String()+"/56" {
+				t.Fatalf(" unexpected IPv6 state %+v", info)
+			}
+			var addr core.Ipv6Key
+			copy(addr[:], dhcpv6TestAddr)
+			if c[0].Client.Dhcpv6 != addr {
+				t.Fatalf(" DHCPv6 address is not installed on the client")
+			}
+			if c[0].stats.dhcpv6Renewed < 2 || c[0].stats.dhcpv6Expired != 0 {
+				t.Fatalf(" lease is not renewed, %d renewed %d expired", c[0].stats.dhcpv6Renewed, c[0].stats.dhcpv6Expired)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginPPPClient_dhcpv6_expire(t *testing.T) {
+	a := &PPPTestBase{
+		testname: "ppp_client_dhcpv6_expire",
+		duration: 30 * time.Second,
+		srvInit:  `{"server": {"ipv6_prefix": "2001:db8:10::/64", ` + pppSrvUsers + `}}`,
+		clients:  []string{`{"user": "alice", "password": "secret", "ipv6": true}`},
+		veth:     VethPPPSim{managed: true, dhcpv6: true, dhcpv6Reply: 1, t1: 4, t2: 6, lft: 10},
+		check: func(t *testing.T, srv *pppServer, c []*PluginPPPClient) {
+			if c[0].stats.dhcpv6Bound != 1 || c[0].stats.dhcpv6Expired == 0 {
+				t.Fatalf(" lease did not expire, %d bound %d expired", c[0].stats.dhcpv6Bound, c[0].stats.dhcpv6Expired)
+			}
+			if c[0].ipv6.dhcpValid || !c[0].Client.Dhcpv6.IsZero() {
+				t.Fatalf(" expired DHCPv6 address is still installed")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func pppTestRpc(t *testing.T, tctx *core.CThreadCtx, h jsonrpc.Handler, params string) {
+	raw := fastjson.RawMessage(params)
+	if _, err := h.ServeJSONRPC(tctx, &raw); err != nil {
+		t.Fatalf(" rpc failed: %v", err.Message)
+	}
+}
+
+/*TestPluginPPPClient_ramp - the clients send PADI only when the ramp-up activates them */
+func TestPluginPPPClient_ramp(t *testing.T) {
+	var simVeth VethPPPSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	simVeth.tctx = tctx
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [5]uint32{0x81000001}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	tctx.RegisterParserCb("ppp")
+	tctx.Veth.SetDebug(monitor > 0, os.Stdout, true)
+
+	const tun = `{"vport": 1, "tpid": [33024], "tci": [1]}`
+	pppTestRpc(t, tctx, core.ApiClientAddHandler{}, `{"tun": `+tun+`, "clients": [{"mac": [0, 0, 1, 0, 0, 16],
+		"plugs": {"ppp": {"server": {`+pppSrvUsers+`}}}}]}`)
+	pppTestRpc(t, tctx, core.ApiRampSetHandler{}, `{"rate": 1}`)
+	users := [][2]string{{"alice", "secret"}, {"bob", "secret2"}, {"carol", "secret3"}}
+	var clients []*PluginPPPClient
+	for i, u := range users {
+		mac := clientTestMac(i)
+		pppTestRpc(t, tctx, core.ApiClientAddHandler{}, fmt.Sprintf(`{"tun": %s, "clients": [{"mac": [0, 0, 1, 0, 0, %d],
+			"plugs": {"ppp": {"user": "%s", "password": "%s"}}}]}`, tun, mac[5], u[0], u[1]))
+		clients = append(clients, getPPPPlug(t, ns, &mac))
+	}
+	srv := getPPPPlug(t, ns, &srvTestMac).srv
+
+	tctx.MainLoopSim(500 * time.Millisecond)
+	for _, c := range clients {
+		if c.Client.IsActive() || c.state != PPPStateInit || c.stats.pktTxPADI != 0 {
+			t.Fatalf(" client %v sent PADI before it was activated, state %s", c.Client.Mac, pppStateNames[c.state])
+		}
+	}
+
+	tctx.MainLoopSim(15 * time.Second)
+	checkSessions(t, srv, len(clients))
+	for _, c := range clients {
+		checkLinkUp(t, c, "pap")
+	}
+
+	srv.cdbv.Dump()
+	tctx.SimRecordAppend(srv.cdbv.MarshalValues(false))
+	for _, c := range clients {
+		c.cdbv.Dump()
+		tctx.SimRecordAppend(c.cdbv.MarshalValues(false))
+	}
+	tctx.SimRecordCompare("ppp_client_ramp", t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
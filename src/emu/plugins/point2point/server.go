@@ -0,0 +1,1225 @@
+// Copyright (c) 2021 Eolo S.p.A. and Altran Italia S.p.A. and/or them affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package point2point
+
+/*
+PPPoE access concentrator (rfc2516)
+
+A client configured with "server" answers PADI/PADR of the namespace, assigns
+session IDs and runs LCP, PAP/CHAP against a local user table and IPCP with an
+address from a local pool. Only one server is allowed per namespace.
+
+	PADI  -> PADO (AC-Name, Service-Name, AC-Cookie, Host-Uniq)
+	PADR  -> PADS (Session-ID)
+	LCP   <-> Configure-Request/Ack, Echo, Terminate
+	PAP   -> Authenticate-Ack/Nak
+	CHAP  <- Challenge, -> Response, <- Success/Failure (MD5 and MS-CHAPv2)
+	IPCP  <-> Configure-Request/Nak/Ack
+	IPv6CP <-> Configure-Request/Nak/Ack, then RA with ipv6_prefix (only if configured)
+	PADT  <-> session removed, address back to the pool
+*/
+
+import (
+	"bytes"
+	"crypto/md5"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	SRV_STATE_LCP  = 0
+	SRV_STATE_AUTH = 1
+	SRV_STATE_IPCP = 2
+	SRV_STATE_UP   = 3
+
+	srvMaxRetries       = 10
+	srvRetransmitSec    = 3
+	srvChallengeLen     = 16
+	srvDefaultMru       = 1492
+	srvDefaultPoolSize  = 256
+	srvMaxSessionID     = 0xfffe
+	srvIpcpOptPrimaryNs = 0x81
+)
+
+var srvStateNames = []string{"lcp", "auth", "ipcp", "up"}
+
+// PPPServerCfg describes the access concentrator, part of PPPInit
+type PPPServerCfg struct {
+	AcName       string            `json:"ac_name"`
+	ServiceNames []string          `json:"service_names"` // offered services, empty means any
+	Auth         string            `json:"auth"`          // none, pap (default), chap or mschapv2
+	Users        map[string]string `json:"users"`         // user -> password
+	LocalIP      string            `json:"local_ip"`      // server address in IPCP, default is the client IPv4
+	PoolStart    string            `json:"pool_start"`
+	PoolSize     uint32            `json:"pool_size"`
+	Dns          string            `json:"dns"`          // primary DNS offered in IPCP
+	MaxSessions  uint32            `json:"max_sessions"` // 0 or above the session ID space means the whole space
+	Mru          uint16            `json:"mru"`
+	EchoInterval uint16            `json:"echo_interval"` // LCP Echo-Request interval in sec, 0 disables it
+	Ipv6Prefix   string            `json:"ipv6_prefix"`   // /64 advertised in RA, enables IPv6CP
+}
+
+// PPPServerStats describes the access concentrator counters
+type PPPServerStats struct {
+	pktRxPADI           uint64
+	pktTxPADO           uint64
+	pktRxPADR           uint64
+	pktRxPADRRetransmit uint64
+	pktTxPADS           uint64
+	pktRxPADT           uint64
+	pktTxPADT           uint64
+	pktRxServiceErr     uint64
+	pktRxBadCookie      uint64
+	pktRxUnknownSession uint64
+	pktRxParserErr      uint64
+	pktTxProtoReject    uint64
+	pktTxRA             uint64
+	maxSessionsReached  uint64
+	poolEmpty           uint64
+	authOk              uint64
+	authFail            uint64
+	sessionsUp          uint64
+	sessionsTimeout     uint64
+	activeSessions      uint64
+}
+
+func NewPPPServerStatsDb(o *PPPServerStats) *core.CCounterDb {
+	db := core.NewCCounterDb("pppsrv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPADI,
+		Name:     "pktRxPADI",
+		Help:     "rx PADI",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADO,
+		Name:     "pktTxPADO",
+		Help:     "tx PADO",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPADR,
+		Name:     "pktRxPADR",
+		Help:     "rx PADR",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPADRRetransmit,
+		Name:     "pktRxPADRRetransmit",
+		Help:     "rx PADR of an existing session, PADS is sent again",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADS,
+		Name:     "pktTxPADS",
+		Help:     "tx PADS",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPADT,
+		Name:     "pktRxPADT",
+		Help:     "rx PADT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADT,
+		Name:     "pktTxPADT",
+		Help:     "tx PADT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxServiceErr,
+		Name:     "pktRxServiceErr",
+		Help:     "rx PADI/PADR with a service name that is not offered",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadCookie,
+		Name:     "pktRxBadCookie",
+		Help:     "rx PADR with a wrong AC-Cookie",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnknownSession,
+		Name:     "pktRxUnknownSession",
+		Help:     "rx session packet with unknown session id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "rx parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxProtoReject,
+		Name:     "pktTxProtoReject",
+		Help:     "tx LCP Protocol-Reject",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRA,
+		Name:     "pktTxRA",
+		Help:     "tx IPv6 Router Advertisement",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.maxSessionsReached,
+		Name:     "maxSessionsReached",
+		Help:     "PADI/PADR dropped, max sessions reached",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.poolEmpty,
+		Name:     "poolEmpty",
+		Help:     "no free address in the pool",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authOk,
+		Name:     "authOk",
+		Help:     "authentication succeeded",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFail,
+		Name:     "authFail",
+		Help:     "authentication failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.sessionsUp,
+		Name:     "sessionsUp",
+		Help:     "sessions that reached up state",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.sessionsTimeout,
+		Name:     "sessionsTimeout",
+		Help:     "sessions closed by retransmit/echo timeout",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.activeSessions,
+		Name:     "activeSessions",
+		Help:     "active sessions",
+		Unit:     "sessions",
+		DumpZero: true,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// PPPServerSessionInfo is returned by ppp_c_server_sessions
+type PPPServerSessionInfo struct {
+	SessionID uint16 `json:"session_id"`
+	Mac       string `json:"mac"`
+	State     string `json:"state"`
+	User      string `json:"user"`
+	Ip        string `json:"ip"`
+}
+
+// PluginPPPServerTimer handles the per session retransmission
+type PluginPPPServerTimer struct {
+}
+
+func (o *PluginPPPServerTimer) OnEvent(a, b interface{}) {
+	s := a.(*pppServerSession)
+	s.srv.onSessionTimer(s)
+}
+
+type pppServerSession struct {
+	srv         *pppServer
+	id          uint16
+	mac         core.MACKey
+	state       uint8
+	magic       []byte
+	lcpId       uint8
+	lcpAckSent  bool
+	lcpAckRecv  bool
+	chapId      uint8
+	challenge   []byte
+	user        string
+	ip          core.Ipv4Key
+	ipValid     bool
+	ipcpId      uint8
+	ipcpAckSent bool
+	ipcpAckRecv bool
+	ipv6cpId    uint8
+	ipv6cpAck   bool
+	ipv6cpRecv  bool
+	ipv6Up      bool
+	hostUniq    string
+	retries     uint8
+	echoId      uint8
+	timer       core.CHTimerObj
+	timerCb     PluginPPPServerTimer
+}
+
+// pppServer is the access concentrator role of a PPP client plugin
+type pppServer struct {
+	plug       *PluginPPPClient
+	cfg        PPPServerCfg
+	authMethod layers.PPPType
+	chapAlg    uint8
+	localIP    core.Ipv4Key
+	dns        core.Ipv4Key
+	ipv6Prefix net.IP
+	ifID       [8]byte // IPv6CP Interface-Identifier of the server
+	poolStart  uint32
+	poolFree   []uint32 // free pool offsets, used as a stack
+	secret     []byte   // AC-Cookie secret
+	sessions   map[uint16]*pppServerSession
+	peers      map[string]*pppServerSession // by peer MAC and Host-Uniq, for PADR retransmits
+	nextID     uint16
+	timerw     *core.TimerCtx
+	stats      PPPServerStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+}
+
+func parseIpv4(s string) (core.Ipv4Key, error) {
+	var ip core.Ipv4Key
+	v4 := net.ParseIP(s).To4()
+	if v4 == nil {
+		return ip, fmt.Errorf("ppp server: invalid IPv4 address %q", s)
+	}
+	copy(ip[:], v4)
+	return ip, nil
+}
+
+func newPPPServer(plug *PluginPPPClient, cfg *PPPServerCfg) (*pppServer, error) {
+	o := new(pppServer)
+	o.plug = plug
+	o.cfg = *cfg
+	o.timerw = plug.Tctx.GetTimerCtx()
+	o.sessions = make(map[uint16]*pppServerSession)
+	o.peers = make(map[string]*pppServerSession)
+	o.nextID = 1
+
+	switch cfg.Auth {
+	case "none":
+	case "", "pap":
+		o.authMethod = layers.PPPTypePAP
+	case "chap":
+		o.authMethod = layers.PPPTypeCHAP
+		o.chapAlg = layers.CHAPAlgorithmMD5
+	case "mschapv2":
+		o.authMethod = layers.PPPTypeCHAP
+		o.chapAlg = layers.CHAPAlgorithmMSCHAPv2
+	default:
+		return nil, fmt.Errorf("ppp server: unsupported auth %q", cfg.Auth)
+	}
+
+	if o.cfg.Mru == 0 {
+		o.cfg.Mru = srvDefaultMru
+	}
+	if o.cfg.MaxSessions == 0 || o.cfg.MaxSessions > srvMaxSessionID {
+		o.cfg.MaxSessions = srvMaxSessionID
+	}
+	if len(o.cfg.AcName) == 0 {
+		o.cfg.AcName = "emu-ac"
+	}
+
+	var err error
+	if len(cfg.LocalIP) > 0 {
+		if o.localIP, err = parseIpv4(cfg.LocalIP); err != nil {
+			return nil, err
+		}
+	} else {
+		o.localIP = plug.Client.Ipv4
+	}
+	if len(cfg.Dns) > 0 {
+		if o.dns, err = parseIpv4(cfg.Dns); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Ipv6Prefix) > 0 {
+		ip, ipnet, err := net.ParseCIDR(cfg.Ipv6Prefix)
+		if err != nil || ip.To4() != nil {
+			return nil, fmt.Errorf("ppp server: invalid ipv6_prefix %q", cfg.Ipv6Prefix)
+		}
+		if ones, _ := ipnet.Mask.Size(); ones != 64 {
+			return nil, fmt.Errorf("ppp server: ipv6_prefix %q must be a /64", cfg.Ipv6Prefix)
+		}
+		o.ipv6Prefix = ipnet.IP
+		mac := plug.Client.Mac
+		o.ifID = [8]byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	}
+	if len(cfg.PoolStart) == 0 {
+		return nil, fmt.Errorf("ppp server: pool_start is missing")
+	}
+	start, err := parseIpv4(cfg.PoolStart)
+	if err != nil {
+		return nil, err
+	}
+	o.poolStart = binary.BigEndian.Uint32(start[:])
+	size := cfg.PoolSize
+	if size == 0 {
+		size = srvDefaultPoolSize
+	}
+	if uint64(o.poolStart)+uint64(size) > 1<<32 {
+		return nil, fmt.Errorf("ppp server: pool from %s with size %d overflows", cfg.PoolStart, size)
+	}
+	o.poolFree = make([]uint32, 0, size)
+	for i := size; i > 0; i-- {
+		o.poolFree = append(o.poolFree, i-1)
+	}
+
+	o.secret = make([]byte, 8)
+	binary.BigEndian.PutUint64(o.secret, o.plug.Ns.Rand().Uint64())
+
+	o.cdb = NewPPPServerStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("pppsrv")
+	o.cdbv.Add(o.cdb)
+	return o, nil
+}
+
+func (o *pppServer) allocIP() (core.Ipv4Key, bool) {
+	var ip core.Ipv4Key
+	if len(o.poolFree) == 0 {
+		o.stats.poolEmpty++
+		return ip, false
+	}
+	off := o.poolFree[len(o.poolFree)-1]
+	o.poolFree = o.poolFree[:len(o.poolFree)-1]
+	binary.BigEndian.PutUint32(ip[:], o.poolStart+off)
+	return ip, true
+}
+
+func (o *pppServer) freeIP(ip core.Ipv4Key) {
+	o.poolFree = append(o.poolFree, binary.BigEndian.Uint32(ip[:])-o.poolStart)
+}
+
+func (o *pppServer) cookie(mac []byte) []byte {
+	h := md5.New()
+	h.Write(o.secret)
+	h.Write(mac)
+	return h.Sum(nil)
+}
+
+func (o *pppServer) offersService(name []byte) bool {
+	if len(name) == 0 || len(o.cfg.ServiceNames) == 0 {
+		return true
+	}
+	for _, s := range o.cfg.ServiceNames {
+		if s == string(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// l2 returns the L2 header of the server toward a client
+func (o *pppServer) l2(dst core.MACKey, ethType layers.EthernetType) []byte {
+	l2 := o.plug.Client.GetL2Header(false, uint16(ethType))
+	copy(l2[0:6], dst[:])
+	return l2
+}
+
+func (o *pppServer) send(dst core.MACKey, ethType layers.EthernetType, l ...gopacket.SerializableLayer) {
+	pkt := append(o.l2(dst, ethType), core.PacketUtlBuild(l...)...)
+	o.plug.Tctx.Veth.SendBuffer(false, o.plug.Client, pkt, false)
+}
+
+func (o *pppServer) sendDiscovery(dst core.MACKey, code layers.PPPoECode, sessionID uint16, tags []layers.PPPoEDTag) {
+	pppoed := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      code,
+		SessionID: sessionID,
+		Tags:      tags,
+	}
+	pppoed.Length = pppoed.GetPPPoEDTagsSize()
+	o.send(dst, layers.EthernetTypePPPoEDiscovery, pppoed)
+}
+
+func (o *pppServer) sendSession(s *pppServerSession, pppType layers.PPPType, l gopacket.SerializableLayer, length uint16) {
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: s.id,
+		Length:    length + 2, // PPP layer size in byte
+		Tags:      []layers.PPPoEDTag{},
+	}
+	ppp := &layers.PPP{
+		PPPType: pppType,
+	}
+	o.send(s.mac, layers.EthernetTypePPPoESession, pppoes, ppp, l)
+}
+
+// HandleRxPacket handles the PPPoE packets sent to the access concentrator
+func (o *pppServer) HandleRxPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	tmp := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+	pppoe, ok := tmp.Layer(layers.LayerTypePPPoE).(*layers.PPPoE)
+	if !ok {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+
+	switch pppoe.Code {
+	case layers.PPPoECodePADI:
+		o.handlePADI(mac, pppoe)
+	case layers.PPPoECodePADR:
+		o.handlePADR(mac, pppoe)
+	case layers.PPPoECodePADT:
+		s := o.sessions[pppoe.SessionID]
+		if s != nil && s.mac == mac {
+			o.stats.pktRxPADT++
+			o.removeSession(s)
+		}
+	case layers.PPPoECodeSession:
+		s := o.sessions[pppoe.SessionID]
+		if s == nil || s.mac != mac {
+			o.stats.pktRxUnknownSession++
+			return 0
+		}
+		o.handleSession(s, tmp)
+	}
+	return 0
+}
+
+// replyTags echoes the Host-Uniq and Relay-Session-Id tags
+func (o *pppServer) replyTags(req *layers.PPPoE, service []byte) ([]layers.PPPoEDTag, []byte) {
+	tags := []layers.PPPoEDTag{{
+		Type:   layers.PPPoEDTagTypeServiceName,
+		Length: uint16(len(service)),
+		Value:  service,
+	}}
+	var cookie []byte
+	for _, tag := range req.Tags {
+		switch tag.Type {
+		case layers.PPPoEDTagTypeHostUniq, layers.PPPoEDTagTypeRelaySessionID:
+			tags = append(tags, tag)
+		case layers.PPPoEDTagTypeACCookie:
+			cookie = tag.Value
+		}
+	}
+	return tags, cookie
+}
+
+func getServiceName(req *layers.PPPoE) []byte {
+	for _, tag := range req.Tags {
+		if tag.Type == layers.PPPoEDTagTypeServiceName {
+			return tag.Value
+		}
+	}
+	return []byte{}
+}
+
+func (o *pppServer) handlePADI(mac core.MACKey, req *layers.PPPoE) {
+	o.stats.pktRxPADI++
+	service := getServiceName(req)
+	if !o.offersService(service) {
+		o.stats.pktRxServiceErr++
+		return
+	}
+	if uint32(len(o.sessions)) >= o.cfg.MaxSessions {
+		o.stats.maxSessionsReached++
+		return
+	}
+
+	tags, _ := o.replyTags(req, service)
+	tags = append(tags,
+		layers.PPPoEDTag{
+			Type:   layers.PPPoEDTagTypeACName,
+			Length: uint16(len(o.cfg.AcName)),
+			Value:  []byte(o.cfg.AcName),
+		},
+		layers.PPPoEDTag{
+			Type:   layers.PPPoEDTagTypeACCookie,
+			Length: md5.Size,
+			Value:  o.cookie(mac[:]),
+		})
+	o.stats.pktTxPADO++
+	o.sendDiscovery(mac, layers.PPPoECodePADO, 0, tags)
+}
+
+func getHostUniq(req *layers.PPPoE) []byte {
+	for _, tag := range req.Tags {
+		if tag.Type == layers.PPPoEDTagTypeHostUniq {
+			return tag.Value
+		}
+	}
+	return []byte{}
+}
+
+// peerKey identifies the peer of a session, a client may run several sessions with different Host-Uniq
+func peerKey(mac core.MACKey, hostUniq []byte) string {
+	return string(mac[:]) + string(hostUniq)
+}
+
+// sendPADSError rejects a PADR with an error tag
+func (o *pppServer) sendPADSError(mac core.MACKey, tags []layers.PPPoEDTag, tagType layers.PPPoEDTagType, msg string) {
+	tags = append(tags, layers.PPPoEDTag{
+		Type:   tagType,
+		Length: uint16(len(msg)),
+		Value:  []byte(msg),
+	})
+	o.sendDiscovery(mac, layers.PPPoECodePADS, 0, tags)
+}
+
+func (o *pppServer) handlePADR(mac core.MACKey, req *layers.PPPoE) {
+	o.stats.pktRxPADR++
+	service := getServiceName(req)
+	tags, cookie := o.replyTags(req, service)
+
+	if !bytes.Equal(cookie, o.cookie(mac[:])) {
+		o.stats.pktRxBadCookie++
+		return
+	}
+	hostUniq := getHostUniq(req)
+	if s := o.peers[peerKey(mac, hostUniq)]; s != nil {
+		// the PADS was lost, the client retransmits the PADR
+		o.stats.pktRxPADRRetransmit++
+		o.stats.pktTxPADS++
+		o.sendDiscovery(mac, layers.PPPoECodePADS, s.id, tags)
+		return
+	}
+	if !o.offersService(service) {
+		o.stats.pktRxServiceErr++
+		o.sendPADSError(mac, tags, layers.PPPoEDTagTypeServiceNameError, "service not offered")
+		return
+	}
+	if uint32(len(o.sessions)) >= o.cfg.MaxSessions {
+		o.stats.maxSessionsReached++
+		o.sendPADSError(mac, tags, layers.PPPoEDTagTypeACSystemError, "too many sessions")
+		return
+	}
+
+	s, err := o.newSession(mac, hostUniq)
+	if err != nil {
+		o.stats.maxSessionsReached++
+		o.sendPADSError(mac, tags, layers.PPPoEDTagTypeGenericError, err.Error())
+		return
+	}
+	o.stats.pktTxPADS++
+	o.sendDiscovery(mac, layers.PPPoECodePADS, s.id, tags)
+	o.sendLCPConfReq(s)
+}
+
+// newSession allocates the next free session ID, round robin
+func (o *pppServer) newSession(mac core.MACKey, hostUniq []byte) (*pppServerSession, error) {
+	for i := 0; o.sessions[o.nextID] != nil; i++ {
+		if i == srvMaxSessionID {
+			return nil, fmt.Errorf("no free session id")
+		}
+		o.nextID++
+		if o.nextID > srvMaxSessionID {
+			o.nextID = 1
+		}
+	}
+	s := new(pppServerSession)
+	s.srv = o
+	s.id = o.nextID
+	s.mac = mac
+	s.hostUniq = string(hostUniq)
+	s.state = SRV_STATE_LCP
+	s.magic = make([]byte, 4)
+	binary.BigEndian.PutUint32(s.magic, o.plug.Ns.Rand().Uint32())
+	s.timer.SetCB(&s.timerCb, s, 0)
+	o.sessions[s.id] = s
+	o.peers[peerKey(mac, hostUniq)] = s
+	o.stats.activeSessions++
+
+	o.nextID++
+	if o.nextID > srvMaxSessionID {
+		o.nextID = 1
+	}
+	return s, nil
+}
+
+func (o *pppServer) removeSession(s *pppServerSession) {
+	if s.timer.IsRunning() {
+		o.timerw.Stop(&s.timer)
+	}
+	if s.ipValid {
+		o.freeIP(s.ip)
+	}
+	delete(o.sessions, s.id)
+	delete(o.peers, peerKey(s.mac, []byte(s.hostUniq)))
+	o.stats.activeSessions--
+}
+
+// terminate closes the session with a PADT
+func (o *pppServer) terminate(s *pppServerSession) {
+	msg := []byte("session closed")
+	o.stats.pktTxPADT++
+	o.sendDiscovery(s.mac, layers.PPPoECodePADT, s.id, []layers.PPPoEDTag{{
+		Type:   layers.PPPoEDTagTypeGenericError,
+		Length: uint16(len(msg)),
+		Value:  msg,
+	}})
+	o.removeSession(s)
+}
+
+func (o *pppServer) restartTimer(s *pppServerSession, sec uint32) {
+	if s.timer.IsRunning() {
+		o.timerw.Stop(&s.timer)
+	}
+	o.timerw.Start(&s.timer, time.Duration(sec)*time.Second)
+}
+
+func (o *pppServer) onSessionTimer(s *pppServerSession) {
+	if s.state == SRV_STATE_UP {
+		// LCP echo, retries counts the unanswered requests
+		if s.retries >= 3 {
+			o.stats.sessionsTimeout++
+			o.terminate(s)
+			return
+		}
+		s.retries++
+		s.echoId++
+		o.sendLCP(s, &layers.LCP{
+			Code:        layers.LCPTypeEchoRequest,
+			Identifier:  s.echoId,
+			Options:     []layers.LCPOption{},
+			MagicNumber: s.magic,
+		})
+		o.restartTimer(s, uint32(o.cfg.EchoInterval))
+		return
+	}
+
+	s.retries++
+	if s.retries >= srvMaxRetries {
+		o.stats.sessionsTimeout++
+		o.terminate(s)
+		return
+	}
+	switch s.state {
+	case SRV_STATE_LCP:
+		if !s.lcpAckRecv {
+			o.sendLCPConfReq(s)
+			return
+		}
+	case SRV_STATE_AUTH:
+		if o.authMethod == layers.PPPTypeCHAP {
+			o.sendChallenge(s)
+			return
+		}
+	case SRV_STATE_IPCP:
+		if o.ipv6Prefix != nil && !s.ipv6cpRecv {
+			o.sendIPv6CPConfReq(s)
+		}
+		if !s.ipcpAckRecv {
+			o.sendIPCPConfReq(s)
+			return
+		}
+	}
+	o.restartTimer(s, srvRetransmitSec)
+}
+
+func (o *pppServer) handleSession(s *pppServerSession, pkt gopacket.Packet) {
+	ppp, ok := pkt.Layer(layers.LayerTypePPP).(*layers.PPP)
+	if !ok {
+		o.stats.pktRxParserErr++
+		return
+	}
+
+	switch ppp.PPPType {
+	case layers.PPPTypeLCP:
+		if lcp, ok := pkt.Layer(layers.LayerTypeLCP).(*layers.LCP); ok {
+			o.handleLCP(s, lcp)
+			return
+		}
+	case layers.PPPTypePAP:
+		if pap, ok := pkt.Layer(layers.LayerTypePAP).(*layers.PAP); ok && s.state == SRV_STATE_AUTH {
+			o.handlePAP(s, pap)
+		}
+		return
+	case layers.PPPTypeCHAP:
+		if chap, ok := pkt.Layer(layers.LayerTypeCHAP).(*layers.CHAP); ok && s.state == SRV_STATE_AUTH {
+			o.handleCHAP(s, chap)
+		}
+		return
+	case layers.PPPTypeIPCP:
+		if ipcp, ok := pkt.Layer(layers.LayerTypeIPCP).(*layers.IPCP); ok && s.state >= SRV_STATE_IPCP {
+			o.handleIPCP(s, ipcp)
+		}
+		return
+	case layers.PPPTypeIPv6CP:
+		if ipv6cp, ok := pkt.Layer(layers.LayerTypeIPv6CP).(*layers.IPv6CP); ok && o.ipv6Prefix != nil && s.state >= SRV_STATE_IPCP {
+			o.handleIPv6CP(s, ipv6cp)
+		} else if o.ipv6Prefix == nil && s.state >= SRV_STATE_IPCP {
+			o.sendProtocolReject(s, ppp)
+		}
+		return
+	case layers.PPPTypeIPv6:
+		// only Router Solicitation is answered, data traffic is not handled by the access concentrator
+		if _, ok := pkt.Layer(layers.LayerTypeICMPv6RouterSolicitation).(*layers.ICMPv6RouterSolicitation); ok && s.ipv6Up {
+			o.sendRA(s)
+		}
+		return
+	case layers.PPPTypeIPv4:
+		// data traffic is not handled by the access concentrator
+		return
+	default:
+		if s.state >= SRV_STATE_IPCP {
+			o.sendProtocolReject(s, ppp)
+		}
+		return
+	}
+	o.stats.pktRxParserErr++
+}
+
+func (o *pppServer) sendLCP(s *pppServerSession, lcp *layers.LCP) {
+	lcp.Length = lcp.GetLCPSize()
+	o.sendSession(s, layers.PPPTypeLCP, lcp, lcp.Length)
+}
+
+func (o *pppServer) sendLCPConfReq(s *pppServerSession) {
+	mru := make([]byte, 2)
+	binary.BigEndian.PutUint16(mru, o.cfg.Mru)
+	options := []layers.LCPOption{
+		{Type: layers.LCPOptionTypeMaximumReceiveUnit, Length: 4, Value: mru},
+	}
+	switch o.authMethod {
+	case layers.PPPTypePAP:
+		options = append(options, layers.LCPOption{Type: layers.LCPOptionTypeAuthenticationProtocol, Length: 4, Value: []byte{0xc0, 0x23}})
+	case layers.PPPTypeCHAP:
+		options = append(options, layers.LCPOption{Type: layers.LCPOptionTypeAuthenticationProtocol, Length: 5, Value: []byte{0xc2, 0x23, o.chapAlg}})
+	}
+	options = append(options, layers.LCPOption{Type: layers.LCPOptionTypeMagicNumber, Length: 6, Value: s.magic})
+
+	s.lcpId++
+	o.sendLCP(s, &layers.LCP{
+		Code:       layers.LCPTypeConfigurationRequest,
+		Identifier: s.lcpId,
+		Options:    options,
+	})
+	o.restartTimer(s, srvRetransmitSec)
+}
+
+func (o *pppServer) handleLCP(s *pppServerSession, lcp *layers.LCP) {
+	switch lcp.Code {
+	case layers.LCPTypeConfigurationRequest:
+		var reject []layers.LCPOption
+		for _, opt := range lcp.Options {
+			switch opt.Type {
+			case layers.LCPOptionTypeMaximumReceiveUnit, layers.LCPOptionTypeMagicNumber:
+			default:
+				reject = append(reject, opt)
+			}
+		}
+		if reject != nil {
+			o.sendLCP(s, &layers.LCP{Code: layers.LCPTypeConfigurationReject, Identifier: lcp.Identifier, Options: reject})
+			return
+		}
+		o.sendLCP(s, &layers.LCP{Code: layers.LCPTypeConfigurationAck, Identifier: lcp.Identifier, Options: lcp.Options})
+		s.lcpAckSent = true
+	case layers.LCPTypeConfigurationAck:
+		if lcp.Identifier == s.lcpId {
+			s.lcpAckRecv = true
+		}
+	case layers.LCPTypeConfigurationNak, layers.LCPTypeConfigurationReject:
+		// the authentication protocol is mandatory
+		for _, opt := range lcp.Options {
+			if opt.Type == layers.LCPOptionTypeAuthenticationProtocol {
+				o.stats.authFail++
+				o.terminate(s)
+				return
+			}
+		}
+		o.sendLCPConfReq(s)
+	case layers.LCPTypeEchoRequest:
+		o.sendLCP(s, &layers.LCP{Code: layers.LCPTypeEchoReply, Identifier: lcp.Identifier, Options: []layers.LCPOption{}, MagicNumber: s.magic})
+	case layers.LCPTypeEchoReply:
+		s.retries = 0
+	case layers.LCPTypeTerminateRequest:
+		o.sendLCP(s, &layers.LCP{Code: layers.LCPTypeTerminateAck, Identifier: lcp.Identifier, Options: []layers.LCPOption{}})
+		o.terminate(s)
+	}
+
+	if s.state == SRV_STATE_LCP && s.lcpAckSent && s.lcpAckRecv {
+		s.retries = 0
+		s.state = SRV_STATE_AUTH
+		switch o.authMethod {
+		case layers.PPPTypePAP:
+			o.restartTimer(s, srvRetransmitSec)
+		case layers.PPPTypeCHAP:
+			o.sendChallenge(s)
+		default:
+			o.startIPCP(s)
+		}
+	}
+}
+
+func (o *pppServer) authFailed(s *pppServerSession) {
+	o.stats.authFail++
+	o.terminate(s)
+}
+
+func (o *pppServer) handlePAP(s *pppServerSession, pap *layers.PAP) {
+	if pap.Code != layers.PAPTypeAuthRequest || len(pap.Data) != 2 {
+		return
+	}
+	user := string(pap.Data[0].Value)
+	pass, ok := o.cfg.Users[user]
+	code := layers.PAPTypeAuthAck
+	msg := "Login ok"
+	if !ok || pass != string(pap.Data[1].Value) {
+		code = layers.PAPTypeAuthNak
+		msg = "Authentication failed"
+	}
+	res := &layers.PAP{
+		Code:       code,
+		Identifier: pap.Identifier,
+		Data:       []layers.PAPData{{Length: uint8(len(msg)), Value: []byte(msg)}},
+	}
+	res.Length = res.GetPAPSize()
+	o.sendSession(s, layers.PPPTypePAP, res, res.Length)
+
+	if code != layers.PAPTypeAuthAck {
+		o.authFailed(s)
+		return
+	}
+	s.user = user
+	o.stats.authOk++
+	o.startIPCP(s)
+}
+
+func (o *pppServer) sendCHAP(s *pppServerSession, chap *layers.CHAP) {
+	chap.Length = chap.GetCHAPSize()
+	o.sendSession(s, layers.PPPTypeCHAP, chap, chap.Length)
+}
+
+func (o *pppServer) sendChallenge(s *pppServerSession) {
+	if s.challenge == nil {
+		s.chapId++
+		s.challenge = make([]byte, srvChallengeLen)
+		binary.BigEndian.PutUint64(s.challenge[0:8], o.plug.Ns.Rand().Uint64())
+		binary.BigEndian.PutUint64(s.challenge[8:16], o.plug.Ns.Rand().Uint64())
+	}
+	o.sendCHAP(s, &layers.CHAP{
+		Code:       layers.CHAPTypeChallenge,
+		Identifier: s.chapId,
+		Value:      s.challenge,
+		Name:       []byte(o.cfg.AcName),
+	})
+	o.restartTimer(s, srvRetransmitSec)
+}
+
+func (o *pppServer) handleCHAP(s *pppServerSession, chap *layers.CHAP) {
+	if chap.Code != layers.CHAPTypeResponse || chap.Identifier != s.chapId || s.challenge == nil {
+		return
+	}
+	user := string(chap.Name)
+	pass, ok := o.cfg.Users[user]
+
+	var msg string
+	if ok {
+		switch o.chapAlg {
+		case layers.CHAPAlgorithmMD5:
+			h := md5.New()
+			h.Write([]byte{chap.Identifier})
+			h.Write([]byte(pass))
+			h.Write(s.challenge)
+			ok = bytes.Equal(h.Sum(nil), chap.Value)
+		case layers.CHAPAlgorithmMSCHAPv2:
+			ok = false
+			if len(chap.Value) == mschapv2ResponseLen {
+				peerChallenge := chap.Value[0:16]
+				res, err := dot1x.Encryptv2(s.challenge, peerChallenge, user, pass)
+				if err == nil && bytes.Equal(res.ChallengeResponse, chap.Value[24:48]) {
+					ok = true
+					msg = res.AuthenticatorResponse + " M=Access granted"
+				}
+			}
+		}
+	}
+
+	if !ok {
+		o.sendCHAP(s, &layers.CHAP{Code: layers.CHAPTypeFailure, Identifier: chap.Identifier, Message: []byte("E=691 R=0 V=3")})
+		o.authFailed(s)
+		return
+	}
+	if len(msg) == 0 {
+		msg = "Access granted"
+	}
+	o.sendCHAP(s, &layers.CHAP{Code: layers.CHAPTypeSuccess, Identifier: chap.Identifier, Message: []byte(msg)})
+	s.user = user
+	o.stats.authOk++
+	o.startIPCP(s)
+}
+
+func (o *pppServer) startIPCP(s *pppServerSession) {
+	s.retries = 0
+	s.state = SRV_STATE_IPCP
+	if !s.ipValid {
+		if s.ip, s.ipValid = o.allocIP(); !s.ipValid {
+			o.terminate(s)
+			return
+		}
+	}
+	o.sendIPCPConfReq(s)
+	if o.ipv6Prefix != nil {
+		o.sendIPv6CPConfReq(s)
+	}
+}
+
+func (o *pppServer) sendIPCP(s *pppServerSession, code layers.IPCPType, id uint8, options []layers.IPCPOption) {
+	ipcp := &layers.IPCP{
+		Code:       code,
+		Identifier: id,
+		Options:    options,
+	}
+	ipcp.Length = ipcp.GetIPCPSize()
+	o.sendSession(s, layers.PPPTypeIPCP, ipcp, ipcp.Length)
+}
+
+func (o *pppServer) sendIPCPConfReq(s *pppServerSession) {
+	s.ipcpId++
+	o.sendIPCP(s, layers.IPCPTypeConfigurationRequest, s.ipcpId, []layers.IPCPOption{
+		{Type: layers.IPCPOptionTypeIPAddress, Length: 6, Value: o.localIP[:]},
+	})
+	o.restartTimer(s, srvRetransmitSec)
+}
+
+func (o *pppServer) handleIPCP(s *pppServerSession, ipcp *layers.IPCP) {
+	switch ipcp.Code {
+	case layers.IPCPTypeConfigurationRequest:
+		var nak, reject []layers.IPCPOption
+		for _, opt := range ipcp.Options {
+			switch {
+			case opt.Type == layers.IPCPOptionTypeIPAddress && len(opt.Value) == 4:
+				if !bytes.Equal(opt.Value, s.ip[:]) {
+					nak = append(nak, layers.IPCPOption{Type: opt.Type, Length: 6, Value: s.ip[:]})
+				}
+			case opt.Type == srvIpcpOptPrimaryNs && len(opt.Value) == 4 && len(o.cfg.Dns) > 0:
+				if !bytes.Equal(opt.Value, o.dns[:]) {
+					nak = append(nak, layers.IPCPOption{Type: opt.Type, Length: 6, Value: o.dns[:]})
+				}
+			default:
+				reject = append(reject, opt)
+			}
+		}
+		if reject != nil {
+			o.sendIPCP(s, 4, ipcp.Identifier, reject) // Configure-Reject
+		} else if nak != nil {
+			o.sendIPCP(s, layers.IPCPTypeConfigurationNak, ipcp.Identifier, nak)
+		} else {
+			o.sendIPCP(s, layers.IPCPTypeConfigurationAck, ipcp.Identifier, ipcp.Options)
+			s.ipcpAckSent = true
+		}
+	case layers.IPCPTypeConfigurationAck:
+		if ipcp.Identifier == s.ipcpId {
+			s.ipcpAckRecv = true
+		}
+	}
+
+	if s.state == SRV_STATE_IPCP && s.ipcpAckSent && s.ipcpAckRecv {
+		s.state = SRV_STATE_UP
+		s.retries = 0
+		o.stats.sessionsUp++
+		if s.timer.IsRunning() {
+			o.timerw.Stop(&s.timer)
+		}
+		if o.cfg.EchoInterval > 0 {
+			o.restartTimer(s, uint32(o.cfg.EchoInterval))
+		}
+	}
+}
+
+func (o *pppServer) sendIPv6CP(s *pppServerSession, code layers.LCPType, id uint8, options []layers.IPv6CPOption) {
+	ipv6cp := &layers.IPv6CP{
+		Code:       code,
+		Identifier: id,
+		Options:    options,
+	}
+	ipv6cp.Length = ipv6cp.GetIPv6CPSize()
+	o.sendSession(s, layers.PPPTypeIPv6CP, ipv6cp, ipv6cp.Length)
+}
+
+func (o *pppServer) sendIPv6CPConfReq(s *pppServerSession) {
+	s.ipv6cpId++
+	o.sendIPv6CP(s, layers.LCPTypeConfigurationRequest, s.ipv6cpId, []layers.IPv6CPOption{
+		{Type: layers.IPv6CPOptionTypeInterfaceID, Value: o.ifID[:]},
+	})
+}
+
+func (o *pppServer) handleIPv6CP(s *pppServerSession, ipv6cp *layers.IPv6CP) {
+	switch ipv6cp.Code {
+	case layers.LCPTypeConfigurationRequest:
+		id := ipv6cp.GetInterfaceID()
+		if len(id) != 8 || bytes.Equal(id, make([]byte, 8)) || bytes.Equal(id, o.ifID[:]) {
+			// suggest a unique Interface-Identifier
+			suggest := make([]byte, 8)
+			binary.BigEndian.PutUint64(suggest, o.plug.Ns.Rand().Uint64())
+			suggest[0] &^= 0x02
+			o.sendIPv6CP(s, layers.LCPTypeConfigurationNak, ipv6cp.Identifier, []layers.IPv6CPOption{
+				{Type: layers.IPv6CPOptionTypeInterfaceID, Value: suggest},
+			})
+			return
+		}
+		o.sendIPv6CP(s, layers.LCPTypeConfigurationAck, ipv6cp.Identifier, ipv6cp.Options)
+		s.ipv6cpAck = true
+	case layers.LCPTypeConfigurationAck:
+		if ipv6cp.Identifier == s.ipv6cpId {
+			s.ipv6cpRecv = true
+		}
+	case layers.LCPTypeTerminateRequest:
+		o.sendIPv6CP(s, layers.LCPTypeTerminateAck, ipv6cp.Identifier, nil)
+		s.ipv6cpAck, s.ipv6cpRecv, s.ipv6Up = false, false, false
+		return
+	}
+
+	if ipv6cp.Code == layers.LCPTypeConfigurationRequest && !s.ipv6cpRecv {
+		// our request may have been dropped by a peer that was not ready yet
+		o.sendIPv6CPConfReq(s)
+	}
+	if !s.ipv6Up && s.ipv6cpAck && s.ipv6cpRecv {
+		s.ipv6Up = true
+		o.sendRA(s)
+	}
+}
+
+// sendRA advertises ipv6_prefix for SLAAC, the source is the link-local of the IPv6CP Interface-Identifier
+func (o *pppServer) sendRA(s *pppServerSession) {
+	prefixInfo := make([]byte, 30)
+	prefixInfo[0] = 64
+	prefixInfo[1] = 0xc0 // on-link, autonomous
+	binary.BigEndian.PutUint32(prefixInfo[2:6], 86400)
+	binary.BigEndian.PutUint32(prefixInfo[6:10], 14400)
+	copy(prefixInfo[14:30], o.ipv6Prefix)
+
+	src := append(net.IP{0xfe, 0x80, 0, 0, 0, 0, 0, 0}, o.ifID[:]...)
+	ipv6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+		SrcIP:      src,
+		DstIP:      net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01},
+	}
+	icmp := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterAdvertisement, 0),
+	}
+	icmp.SetNetworkLayerForChecksum(ipv6)
+	ra := &layers.ICMPv6RouterAdvertisement{
+		HopLimit:       64,
+		RouterLifetime: 1800,
+		Options:        layers.ICMPv6Options{{Type: layers.ICMPv6OptPrefixInfo, Data: prefixInfo}},
+	}
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: s.id,
+		Tags:      []layers.PPPoEDTag{},
+	}
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeIPv6,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, pppoes, ppp, ipv6, icmp, ra); err != nil {
+		return
+	}
+	o.stats.pktTxRA++
+	pkt := append(o.l2(s.mac, layers.EthernetTypePPPoESession), buf.Bytes()...)
+	o.plug.Tctx.Veth.SendBuffer(false, o.plug.Client, pkt, false)
+}
+
+// sendProtocolReject rejects a network protocol we do not support, e.g. IPv6CP
+func (o *pppServer) sendProtocolReject(s *pppServerSession, ppp *layers.PPP) {
+	o.stats.pktTxProtoReject++
+	s.lcpId++
+	data := make([]byte, 6, 6+len(ppp.Payload))
+	data[0] = uint8(layers.LCPTypeProtocolReject)
+	data[1] = s.lcpId
+	binary.BigEndian.PutUint16(data[4:6], uint16(ppp.PPPType))
+	data = append(data, ppp.Payload...)
+	if len(data) > int(o.cfg.Mru) {
+		data = data[:o.cfg.Mru]
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	o.sendSession(s, layers.PPPTypeLCP, gopacket.Payload(data), uint16(len(data)))
+}
+
+// GetSessions returns the session table sorted by session id
+func (o *pppServer) GetSessions() []PPPServerSessionInfo {
+	res := make([]PPPServerSessionInfo, 0, len(o.sessions))
+	for _, s := range o.sessions {
+		var info PPPServerSessionInfo
+		info.SessionID = s.id
+		info.Mac = s.mac.String()
+		info.State = srvStateNames[s.state]
+		info.User = s.user
+		if s.ipValid {
+			info.Ip = s.ip.ToIP().String()
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].SessionID < res[j].SessionID })
+	return res
+}
+
+// Disconnect tears down a session with a PADT
+func (o *pppServer) Disconnect(sessionID uint16) error {
+	s := o.sessions[sessionID]
+	if s == nil {
+		return fmt.Errorf("ppp server: session %d does not exist", sessionID)
+	}
+	o.terminate(s)
+	return nil
+}
+
+func (o *pppServer) OnRemove() {
+	for _, s := range o.sessions {
+		o.terminate(s)
+	}
+}
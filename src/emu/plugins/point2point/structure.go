@@ -29,7 +29,7 @@ const (
 	PPPStatePADS PluginState = 4
 	// PPPStateLCPNegotiation describes LCP negotiation
 	PPPStateLCPNegotiation PluginState = 5
-	// PPPStatePAPSent describes sent PAP
+	// PPPStatePAPSent describes authentication phase (PAP or CHAP)
 	PPPStatePAPSent PluginState = 6
 	// PPPStateIPCPNegotiation describes IPCP negotiation
 	PPPStateIPCPNegotiation PluginState = 7
@@ -41,11 +41,274 @@ const (
 	PPPStatePADTReceived PluginState = 10
 )
 
+var pppStateNames = []string{"init", "padi", "pado", "padr", "pads", "lcp", "auth", "ipcp", "up", "padt-sent", "padt-received"}
+
 // PPPInit describes structure of input json
 type PPPInit struct {
-	UserID   string `json:"user"`
-	Password string `json:"password"`
-	Timeout  uint8  `json:"timeout"`
+	UserID       string        `json:"user"`
+	Password     string        `json:"password"`
+	Timeout      uint8         `json:"timeout"`
+	Mru          uint16        `json:"mru"`           // LCP Maximum-Receive-Unit, default 1492
+	ServiceName  string        `json:"service_name"`  // PADI Service-Name tag, empty means any service
+	HostUniq     string        `json:"host_uniq"`     // PADI/PADR Host-Uniq tag, not sent when empty
+	EchoInterval uint16        `json:"echo_interval"` // LCP Echo-Request interval in sec, 0 disables it
+	EchoMaxFail  uint8         `json:"echo_max_fail"` // unanswered LCP Echo-Requests before link is down, default 3
+	Ipv6         bool          `json:"ipv6"`          // negotiate IPv6CP and get a global address by SLAAC/DHCPv6
+	Dhcpv6Pd     bool          `json:"dhcpv6_pd"`     // ask for a delegated prefix (IA_PD) over the session
+	Server       *PPPServerCfg `json:"server"`        // act as access concentrator instead of a client
+}
+
+// PPPClientStats describes PPP client counters
+type PPPClientStats struct {
+	pktTxPADI          uint64
+	pktTxPADR          uint64
+	pktTxPADT          uint64
+	pktRxPADT          uint64
+	pktRxBadHostUniq   uint64
+	pktRxServiceErr    uint64
+	pktTxLCPNak        uint64
+	pktRxLCPNakRej     uint64
+	pktTxEchoReq       uint64
+	pktRxEchoReply     uint64
+	echoTimeout        uint64
+	pktTxChapResp      uint64
+	pktRxChapInvalid   uint64
+	authOk             uint64
+	authFail           uint64
+	pktRxIPv6CP        uint64
+	ipv6cpReject       uint64
+	ipv6cpUp           uint64
+	pktTxRs            uint64
+	pktRxRa            uint64
+	pktTxDhcpv6        uint64
+	pktRxDhcpv6        uint64
+	dhcpv6Bound        uint64
+	dhcpv6Renewed      uint64
+	dhcpv6Expired      uint64
+	slaacWithdrawn     uint64
+	pktRxIPv6ParserErr uint64
+}
+
+func NewPPPClientStatsDb(o *PPPClientStats) *core.CCounterDb {
+	db := core.NewCCounterDb(PPPPlugin)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADI,
+		Name:     "pktTxPADI",
+		Help:     "tx PADI",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADR,
+		Name:     "pktTxPADR",
+		Help:     "tx PADR",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPADT,
+		Name:     "pktTxPADT",
+		Help:     "tx PADT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPADT,
+		Name:     "pktRxPADT",
+		Help:     "rx PADT, session terminated by the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadHostUniq,
+		Name:     "pktRxBadHostUniq",
+		Help:     "rx discovery packet with wrong Host-Uniq",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxServiceErr,
+		Name:     "pktRxServiceErr",
+		Help:     "rx discovery packet with an error tag",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxLCPNak,
+		Name:     "pktTxLCPNak",
+		Help:     "tx LCP Configure-Nak for unsupported authentication",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLCPNakRej,
+		Name:     "pktRxLCPNakRej",
+		Help:     "rx LCP Configure-Nak/Reject",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxEchoReq,
+		Name:     "pktTxEchoReq",
+		Help:     "tx LCP Echo-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxEchoReply,
+		Name:     "pktRxEchoReply",
+		Help:     "rx LCP Echo-Reply",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.echoTimeout,
+		Name:     "echoTimeout",
+		Help:     "link down, too many unanswered LCP Echo-Request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxChapResp,
+		Name:     "pktTxChapResp",
+		Help:     "tx CHAP response",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxChapInvalid,
+		Name:     "pktRxChapInvalid",
+		Help:     "rx invalid CHAP packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authOk,
+		Name:     "authOk",
+		Help:     "authentication succeeded",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFail,
+		Name:     "authFail",
+		Help:     "authentication failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxIPv6CP,
+		Name:     "pktRxIPv6CP",
+		Help:     "rx IPv6CP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ipv6cpReject,
+		Name:     "ipv6cpReject",
+		Help:     "IPv6CP rejected by the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ipv6cpUp,
+		Name:     "ipv6cpUp",
+		Help:     "IPv6CP opened",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRs,
+		Name:     "pktTxRs",
+		Help:     "tx router solicitation",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRa,
+		Name:     "pktRxRa",
+		Help:     "rx router advertisement",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxDhcpv6,
+		Name:     "pktTxDhcpv6",
+		Help:     "tx DHCPv6",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDhcpv6,
+		Name:     "pktRxDhcpv6",
+		Help:     "rx DHCPv6",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dhcpv6Bound,
+		Name:     "dhcpv6Bound",
+		Help:     "DHCPv6 reply with address/prefix",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dhcpv6Renewed,
+		Name:     "dhcpv6Renewed",
+		Help:     "DHCPv6 lease renewed/rebound",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dhcpv6Expired,
+		Name:     "dhcpv6Expired",
+		Help:     "DHCPv6 lease expired or not extended, solicit again",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacWithdrawn,
+		Name:     "slaacWithdrawn",
+		Help:     "SLAAC prefix withdrawn by an RA with zero valid lifetime",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxIPv6ParserErr,
+		Name:     "pktRxIPv6ParserErr",
+		Help:     "rx IPv6/IPv6CP parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
 }
 
 // PluginPPPClient information per client
@@ -74,12 +337,45 @@ type PluginPPPClient struct {
 	lcpAckSent            bool
 	lcpAckReceived        bool
 	maxRecUnitBytes       []byte
-	negClientIP           core.Ipv4Key     // this variable is used during IPCP Negotiation
-	rcvByNak              bool             // IP negotiated is received by Nak
-	clientIP              core.Ipv4Key     // this variable is final IP assigned to client
+	negClientIP           core.Ipv4Key // this variable is used during IPCP Negotiation
+	rcvByNak              bool         // IP negotiated is received by Nak
+	clientIP              core.Ipv4Key // this variable is final IP assigned to client
 	timerCb               PluginPPPClientTimer
 	vlanTagged            bool
-	layerTwoDiscovery     []gopacket.SerializableLayer    // support array during PPPoED
-	layerTwoSession       []gopacket.SerializableLayer    // support array during PPPoES
+	layerTwoDiscovery     []gopacket.SerializableLayer // support array during PPPoED
+	layerTwoSession       []gopacket.SerializableLayer // support array during PPPoES
 	padtSent              uint8
-}
\ No newline at end of file
+	mru                   uint16
+	serviceName           string
+	hostUniq              []byte
+	lcpPeerOptions        []layers.LCPOption // options of the last acked peer Configure-Request
+	lcpNakOptions         []layers.LCPOption
+	mruRejected           bool
+	echoInterval          uint32
+	echoMaxFail           uint8
+	echoOutstanding       uint8
+	echoIdentifier        uint8
+	chapAlgorithm         uint8
+	chapAuthResp          string // expected MS-CHAPv2 authenticator response
+	ipv6                  pppIpv6Ctx
+	srv                   *pppServer // access concentrator role
+	stats                 PPPClientStats
+	cdb                   *core.CCounterDb
+	cdbv                  *core.CCounterDbVec
+}
+
+// PPPClientInfo is returned by ppp_c_client_info
+type PPPClientInfo struct {
+	State         PluginState `json:"state"`
+	StateName     string      `json:"state_name"`
+	SessionID     uint16      `json:"session_id"`
+	ServerMac     string      `json:"server_mac"`
+	Mru           uint16      `json:"mru"`
+	Auth          string      `json:"auth"`
+	Ipv4          string      `json:"ipv4"`
+	Ipv6cp        string      `json:"ipv6cp_state,omitempty"`
+	Ipv6LinkLocal string      `json:"ipv6_link_local,omitempty"`
+	Ipv6Slaac     string      `json:"ipv6_slaac,omitempty"`
+	Ipv6Dhcp      string      `json:"ipv6_dhcp,omitempty"`
+	Ipv6Prefix    string      `json:"ipv6_pd,omitempty"`
+}
@@ -51,6 +51,12 @@ type TdlStats struct {
 	payloadUpdateErr          uint64 // Error happened while updating the payload
 	duplicateLuid             uint64 // Duplicate Luid upon registration
 	pktsTx                    uint64 // Number of transmitted packets.
+	pktsRx                    uint64 // Number of received packets, in receiver mode.
+	bytesRx                   uint64 // Number of bytes of the received packets.
+	objectsRx                 uint64 // Number of objects decoded successfully.
+	unknownLuid               uint64 // Number of received packets with an unregistered Luid.
+	decodeError               uint64 // Number of received objects which failed decoding.
+	pktTruncated              uint64 // Number of received packets shorter than their type.
 }
 
 // NewTdlStatsDb creates a TdlStats database.
@@ -193,6 +199,54 @@ func NewTdlStatsDb(o *TdlStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktsRx,
+		Name:     "pktsRx",
+		Help:     "Number of packets received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesRx,
+		Name:     "bytesRx",
+		Help:     "Number of bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.objectsRx,
+		Name:     "objectsRx",
+		Help:     "Number of objects decoded",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.unknownLuid,
+		Name:     "unknownLuid",
+		Help:     "Received packets with an unregistered LUID",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.decodeError,
+		Name:     "decodeError",
+		Help:     "Received objects which failed decoding",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTruncated,
+		Name:     "pktTruncated",
+		Help:     "Received packets shorter than their type",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -259,6 +313,21 @@ type TdlClientParams struct {
 	Engines    *fastjson.RawMessage   `json:"engines"`                         // Engine Json to pass to the engine manager.
 }
 
+// TdlMetaDataParams defines the params to hot-swap the metadata and the object of a Tdl client.
+type TdlMetaDataParams struct {
+	Header     *TdlHeader             `json:"header"`                          // New Tdl header options. Default=keep the current header, required if the object type changes.
+	Meta       *fastjson.RawMessage   `json:"meta_data" validate:"required"`   // Tdl meta data Json to pass to the metadata manager.
+	Object     TdlObject              `json:"object" validate:"required"`      // Tdl object data
+	InitValues []UnconstructedTdlType `json:"init_values" validate:"required"` // List of initialization values for unconstructed types.
+	Engines    *fastjson.RawMessage   `json:"engines"`                         // Engine Json to pass to the engine manager.
+}
+
+// TdlClientModeParams selects the mode of the Tdl client. Clients with a receiver decode the packets they receive,
+// else they send the object to the destination.
+type TdlClientModeParams struct {
+	Receiver *fastjson.RawMessage `json:"receiver"` // Receiver Json, see TdlReceiverParams.
+}
+
 // PluginTdlClient represents a Tdl Client.
 type PluginTdlClient struct {
 	core.PluginBase                                      // Plugin Base embedded struct so we get all the base functionality
@@ -270,6 +339,9 @@ type PluginTdlClient struct {
 	transportCtx       *transport.TransportCtx           // Transport Layer Context
 	socket             transport.SocketApi               // Socket API
 	dgMacResolved      bool                              // Is the default gateway MAC address resolved?
+	socketReady        bool                              // Is the socket ready to send packets?
+	redial             bool                              // Should we dial the destination once the socket is closed?
+	paused             bool                              // Is sending paused?
 	stats              TdlStats                          // Tdl statistics
 	cdb                *core.CCounterDb                  // Counters database
 	cdbv               *core.CCounterDbVec               // Counters database vector
@@ -277,6 +349,7 @@ type PluginTdlClient struct {
 	unconstructedTypes map[string]UnconstructedTdlTypeIF // Absolute path to interface of an unconstructed Tdl type
 	header             TdlHeader                         // Tdl Header as defined by client
 	objInstance        TdlTypeIF                         // Object instance
+	objType            string                            // Type of the object instance
 	encodedHeader      []byte                            // Tdl header encoded, doesn't change
 	payload            []byte                            // L7 - Tdl payload
 	pktTicks           uint32                            // Ticks between two subsequent packets
@@ -287,6 +360,11 @@ type PluginTdlClient struct {
 	engineMgr          *engines.FieldEngineManager       // Engine Manager
 	engineMap          map[string]engines.FieldEngineIF  // Map of engines name -> engine
 	luidTypeMap        map[LUID]string                   // Map of Luid to type
+	receiver           *tdlReceiver                      // Receiver state, nil unless the client runs in receiver mode
+}
+
+func (o *PluginTdlClient) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
 }
 
 // TdlTimerCallback is an empty struct used as a callback for the timer that sends the packets.
@@ -321,6 +399,14 @@ func NewTdlClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	o.RegisterEvents(ctx, tdlEvents, o) // Register events
 	o.OnCreate()
 
+	var mode TdlClientModeParams
+	if fastjson.Unmarshal(initJson, &mode) == nil && mode.Receiver != nil {
+		if err := o.initReceiver(mode.Receiver); err != nil {
+			return nil, err
+		}
+		return &o.PluginBase, nil
+	}
+
 	params := TdlClientParams{Rate: DefaultRatePps}
 	err := o.Tctx.UnmarshalValidate(initJson, &params)
 
@@ -344,31 +430,43 @@ func NewTdlClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	o.pktTicks, o.pktsPerInterval = o.timerw.DurationToTicksBurst(time.Duration(float32(time.Second) / params.Rate))
 	o.transportCtx = transport.GetTransportCtx(o.Client)
 
+	err = o.loadObject(params.Meta, params.Object, params.InitValues, params.Engines)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o.PluginBase, nil
+}
+
+// loadObject creates the metadata manager, builds the object and its engines, and sets the initial values.
+func (o *PluginTdlClient) loadObject(meta *fastjson.RawMessage, obj TdlObject, initValues []UnconstructedTdlType, enginesJson *fastjson.RawMessage) error {
+	var err error
 	// Create Metadata Manager
-	o.metaDataMgr, err = NewTdlMetaDataMgr(o, params.Meta)
+	o.metaDataMgr, err = NewTdlMetaDataMgr(o, meta)
 	if err != nil {
 		o.stats.failedBuildingMetaDataMgr++
-		return nil, err
+		return err
 	}
 	RegisterPrimitiveLuid(o)     // Register primitive types
 	o.metaDataMgr.registerLuid() // Register meta data types
 
 	// Build the main object instance
-	err = o.buildObjectInstance(params.Object)
+	err = o.buildObjectInstance(obj)
 	if err != nil {
 		o.stats.failedBuildingTdlType++
-		return nil, err
+		return err
 	}
+	o.objType = obj.Type
 
 	// build the map
-	o.buildUnconstructedTypesMap(params.Object)
+	o.buildUnconstructedTypesMap(obj)
 
 	// Create Engine Manager
-	if params.Engines != nil {
-		o.engineMgr, err = engines.NewEngineManager(o.Tctx, params.Engines)
+	if enginesJson != nil {
+		o.engineMgr, err = engines.NewEngineManager(o.Tctx, enginesJson)
 		if err != nil {
 			o.stats.failedBuildingEngineMgr++
-			return nil, fmt.Errorf("could not create engine manager: %w", err)
+			return fmt.Errorf("could not create engine manager: %w", err)
 		}
 		o.engineMap = o.engineMgr.GetEngineMap()
 	}
@@ -377,22 +475,21 @@ func NewTdlClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	for engineName := range o.engineMap {
 		if _, ok := o.unconstructedTypes[engineName]; !ok {
 			o.stats.failedBuildingEngineMgr++
-			return nil, fmt.Errorf("Got engine for unexisting field %s", engineName)
+			return fmt.Errorf("Got engine for unexisting field %s", engineName)
 		}
 	}
 
 	// Set initial values for unconstructed types.
-	err = o.setInitialValues(params.InitValues)
+	err = o.setInitialValues(initValues)
 	if err != nil {
 		o.stats.invalidInitValues++
-		return nil, err
+		return err
 	}
 
 	if o.simulation {
 		o.formattedJson = BuildJson(o.unconstructedTypes)
 	}
-
-	return &o.PluginBase, nil
+	return nil
 }
 
 // OnCreate is called upon creating a new Tdl client.
@@ -417,6 +514,9 @@ func (o *PluginTdlClient) OnRemove(ctx *core.PluginCtx) {
 	if o.socket != nil {
 		o.socket.Close()
 	}
+	if o.receiver != nil && o.transportCtx != nil {
+		o.transportCtx.UnListen(o.receiver.network, o.receiver.listenAddr, o)
+	}
 }
 
 // OnEvent callback of the Tdl client in case of events.
@@ -428,9 +528,10 @@ func (o *PluginTdlClient) OnEvent(msg string, a, b interface{}) {
 			// failed at type assertion
 			return
 		}
-		if o.dgMacResolved {
+		if o.dgMacResolved || o.receiver != nil {
 			// already resolved, nothing to do
 			// shouldn't call OnResolve twice
+			// a receiver doesn't send
 			return
 		}
 		resolvedIPv4 := (bitMask & core.RESOLVED_IPV4_DG_MAC) == core.RESOLVED_IPV4_DG_MAC
@@ -444,6 +545,11 @@ func (o *PluginTdlClient) OnEvent(msg string, a, b interface{}) {
 // OnResolve is called when the default gateway Mac address is resolved.
 func (o *PluginTdlClient) OnResolve() {
 	o.dgMacResolved = true
+	o.dial()
+}
+
+// dial creates the socket to the destination.
+func (o *PluginTdlClient) dial() {
 	if o.transportCtx != nil {
 		var err error
 		l4Protocol := "tcp"
@@ -457,11 +563,26 @@ func (o *PluginTdlClient) OnResolve() {
 		}
 		if o.socket.GetCap()&transport.SocketCapConnection == 0 {
 			// socket isn't connection oriented, we can start ticks
-			o.timerw.StartTicks(&o.pktTimer, o.pktTicks)
+			o.socketReady = true
+			o.startTx()
 		}
 	}
 }
 
+// startTx starts the timer that sends packets, if the socket is ready and sending isn't paused.
+func (o *PluginTdlClient) startTx() {
+	if o.socketReady && !o.paused && !o.pktTimer.IsRunning() {
+		o.timerw.StartTicks(&o.pktTimer, o.pktTicks)
+	}
+}
+
+// stopTx stops the timer that sends packets.
+func (o *PluginTdlClient) stopTx() {
+	if o.pktTimer.IsRunning() {
+		o.timerw.Stop(&o.pktTimer)
+	}
+}
+
 // OnRxEvent function to complete the ISocketCb interface.
 func (o *PluginTdlClient) OnRxEvent(event transport.SocketEventType) {
 
@@ -472,14 +593,14 @@ func (o *PluginTdlClient) OnRxEvent(event transport.SocketEventType) {
 
 	if (event & transport.SocketEventConnected) > 0 {
 		// connection established, we can start timer
-		o.timerw.StartTicks(&o.pktTimer, o.pktTicks)
+		o.socketReady = true
+		o.startTx()
 	}
 
 	if event&transport.SocketRemoteDisconnect > 0 {
 		// remote disconnected before connection
-		if o.pktTimer.IsRunning() {
-			o.timerw.Stop(&o.pktTimer)
-		}
+		o.socketReady = false
+		o.stopTx()
 		o.socket.Close()
 	}
 
@@ -489,6 +610,11 @@ func (o *PluginTdlClient) OnRxEvent(event transport.SocketEventType) {
 			fmt.Printf("==> ERROR   %v \n", err.String())
 		}
 		o.socket = nil
+		if o.redial {
+			// destination changed while the socket was open
+			o.redial = false
+			o.dial()
+		}
 	}
 
 }
@@ -589,10 +715,12 @@ func (o *PluginTdlClient) setInitialValues(initValues []UnconstructedTdlType) er
 func (o *PluginTdlClient) updatePayload() error {
 	o.payload = o.payload[:len(o.encodedHeader)] // remove old encoded object
 
-	// Update all the unconstructed types
-	for name, engine := range o.engineMap {
-		unconstructedTdlType := o.unconstructedTypes[name]
-		unconstructedTdlType.Update(engine)
+	// Update all the unconstructed types, engines that reference others are updated after them.
+	if o.engineMgr != nil {
+		for _, name := range o.engineMgr.GetEngineOrder() {
+			unconstructedTdlType := o.unconstructedTypes[name]
+			unconstructedTdlType.Update(o.engineMap[name])
+		}
 	}
 
 	// Then encode the new object
@@ -626,6 +754,142 @@ func (o *PluginTdlClient) DumpFormattedJson() {
 	o.Tctx.SimRecordAppend(copyMap)
 }
 
+/*
+======================================================================================================
+
+	Runtime Updates
+
+======================================================================================================
+*/
+
+// SetValues sets the values of unconstructed types by absolute path. Either all the values are set or none of them.
+// Types updated by engines are overwritten by their engines when the next packet is sent.
+func (o *PluginTdlClient) SetValues(values []UnconstructedTdlType) error {
+	// Keep the current values encoded, to restore them if a value is invalid.
+	saved := make(map[string][]byte, len(values))
+	for i := range values {
+		absPath := values[i].AbsPath
+		variable, ok := o.unconstructedTypes[absPath]
+		if !ok {
+			return fmt.Errorf("There is no such variable %v", absPath)
+		}
+		b := make([]byte, variable.GetLength())
+		if err := variable.Encode(b); err != nil {
+			return fmt.Errorf("Failed saving the value of %v: %w", absPath, err)
+		}
+		saved[absPath] = b
+	}
+	err := o.setInitialValues(values)
+	if err != nil {
+		for absPath, b := range saved {
+			if restoreErr := o.unconstructedTypes[absPath].Decode(b); restoreErr != nil {
+				return fmt.Errorf("%v, and failed restoring the value of %v: %w", err, absPath, restoreErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// GetValues gets the formatted values of unconstructed types by absolute path. No paths gets all of them.
+func (o *PluginTdlClient) GetValues(absPaths []string) (map[string]*TdlFormattedType, error) {
+	if len(absPaths) == 0 {
+		return getFormatted(o.unconstructedTypes), nil
+	}
+	values := make(map[string]*TdlFormattedType, len(absPaths))
+	for _, absPath := range absPaths {
+		variable, ok := o.unconstructedTypes[absPath]
+		if !ok {
+			return nil, fmt.Errorf("There is no such variable %v", absPath)
+		}
+		values[absPath] = variable.FormatTdlType()
+	}
+	return values, nil
+}
+
+// SetRate sets the rate of Tx in PPS.
+func (o *PluginTdlClient) SetRate(rate float32) error {
+	if rate <= 0 {
+		return fmt.Errorf("Invalid rate %v, rate must be positive", rate)
+	}
+	o.pktTicks, o.pktsPerInterval = o.timerw.DurationToTicksBurst(time.Duration(float32(time.Second) / rate))
+	if o.pktTimer.IsRunning() {
+		o.stopTx()
+		o.startTx()
+	}
+	return nil
+}
+
+// SetDst sets the destination address. The socket to the current destination is closed, and the new destination
+// is dialed once it is closed. The default gateway of the new destination must be resolved already.
+func (o *PluginTdlClient) SetDst(dst string) error {
+	host, _, err := net.SplitHostPort(dst)
+	if err != nil {
+		o.stats.invalidDst++
+		return err
+	}
+	o.isIpv6 = strings.Contains(host, ":")
+	o.dstAddress = dst
+	if o.socket == nil {
+		if o.dgMacResolved && !o.redial {
+			o.dial()
+		}
+		// else the destination is dialed upon resolving.
+		return nil
+	}
+	o.socketReady = false
+	o.stopTx()
+	if o.udpDebug {
+		// UDP sockets are closed right away, no events.
+		o.socket.Close()
+		o.socket = nil
+		o.dial()
+		return nil
+	}
+	o.redial = true
+	o.socket.Close()
+	return nil
+}
+
+// Pause pauses sending packets.
+func (o *PluginTdlClient) Pause() {
+	o.paused = true
+	o.stopTx()
+}
+
+// Resume resumes sending packets, once the socket is ready.
+func (o *PluginTdlClient) Resume() {
+	o.paused = false
+	o.startTx()
+}
+
+// SetMetaData hot-swaps the metadata and the object. If the new object fails building, the current one is kept.
+// The header is required if the type of the object changes, since its LUID identifies the type to the receivers.
+func (o *PluginTdlClient) SetMetaData(params *TdlMetaDataParams) error {
+	if params.Header == nil && params.Object.Type != o.objType {
+		return fmt.Errorf("Object type changes from %v to %v, the header with the LUID of the new type is required", o.objType, params.Object.Type)
+	}
+	metaDataMgr, luidTypeMap, objInstance, objType := o.metaDataMgr, o.luidTypeMap, o.objInstance, o.objType
+	unconstructedTypes, engineMgr, engineMap, formattedJson := o.unconstructedTypes, o.engineMgr, o.engineMap, o.formattedJson
+
+	o.luidTypeMap = nil
+	o.unconstructedTypes = make(map[string]UnconstructedTdlTypeIF)
+	o.engineMgr, o.engineMap = nil, nil
+	err := o.loadObject(params.Meta, params.Object, params.InitValues, params.Engines)
+	if err != nil {
+		o.metaDataMgr, o.luidTypeMap, o.objInstance, o.objType = metaDataMgr, luidTypeMap, objInstance, objType
+		o.unconstructedTypes, o.engineMgr, o.engineMap, o.formattedJson = unconstructedTypes, engineMgr, engineMap, formattedJson
+		return err
+	}
+
+	if params.Header != nil {
+		o.header = *params.Header
+		o.encodedHeader = o.header.Encode()
+		o.payload = append(o.payload[:0], o.encodedHeader...)
+	}
+	return nil
+}
+
 /*
 ======================================================================================================
 
@@ -654,6 +918,37 @@ func (o PluginTdlNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.P
 */
 type (
 	ApiTdlClientCntHandler struct{}
+
+	ApiTdlClientGetRxObjectsHandler struct{}
+	ApiTdlClientGetRxErrorsHandler  struct{}
+	ApiTdlClientGetRxParams         struct {
+		Count int `json:"count"` // Num of last objects/errors, 0 for all of them
+	}
+
+	ApiTdlClientSetValuesHandler struct{}
+	ApiTdlClientSetValuesParams  struct {
+		Values []UnconstructedTdlType `json:"values" validate:"required,dive"` // Values to set
+	}
+
+	ApiTdlClientGetValuesHandler struct{}
+	ApiTdlClientGetValuesParams  struct {
+		Paths []string `json:"paths"` // Absolute paths of the types, empty for all of them
+	}
+
+	ApiTdlClientSetRateHandler struct{}
+	ApiTdlClientSetRateParams  struct {
+		Rate float32 `json:"rate_pps" validate:"required"` // Rate of Tx in PPS
+	}
+
+	ApiTdlClientSetDstHandler struct{}
+	ApiTdlClientSetDstParams  struct {
+		Dst string `json:"dst" validate:"required"` // Destination address. Combination of Host:Port
+	}
+
+	ApiTdlClientPauseHandler  struct{}
+	ApiTdlClientResumeHandler struct{}
+
+	ApiTdlClientSetMetaHandler struct{}
 )
 
 // getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
@@ -686,6 +981,143 @@ func (h ApiTdlClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+// getModePlugin gets the client plugin given the client parameters. The client must be a receiver or a sender
+// as required by the RPC. The RPC params are decoded into p, unless it is nil.
+func getModePlugin(ctx interface{}, params *fastjson.RawMessage, receiver bool, p interface{}) (*PluginTdlClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err == nil && receiver && c.receiver == nil {
+		err = fmt.Errorf("Tdl client is not a receiver")
+	}
+	if err == nil && !receiver && c.receiver != nil {
+		err = fmt.Errorf("Tdl client is a receiver")
+	}
+	if err == nil && p != nil {
+		err = tctx.UnmarshalValidate(*params, p)
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c, nil
+}
+
+// invalidParams converts an error of a runtime update to an RPC error.
+func invalidParams(err error) *jsonrpc.Error {
+	return &jsonrpc.Error{
+		Code:    jsonrpc.ErrorCodeInvalidParams,
+		Message: err.Error(),
+	}
+}
+
+// ApiTdlClientGetRxObjectsHandler gets the last objects decoded by a Tdl receiver.
+func (h ApiTdlClientGetRxObjectsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientGetRxParams
+	c, err := getModePlugin(ctx, params, true, &p)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetDecodedObjects(p.Count), nil
+}
+
+// ApiTdlClientGetRxErrorsHandler gets the last decode errors of a Tdl receiver.
+func (h ApiTdlClientGetRxErrorsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientGetRxParams
+	c, err := getModePlugin(ctx, params, true, &p)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetDecodeErrors(p.Count), nil
+}
+
+// ApiTdlClientSetValuesHandler sets the values of unconstructed types by absolute path.
+func (h ApiTdlClientSetValuesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientSetValuesParams
+	c, err := getModePlugin(ctx, params, false, &p)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetValues(p.Values); err != nil {
+		return nil, invalidParams(err)
+	}
+	return true, nil
+}
+
+// ApiTdlClientGetValuesHandler gets the values of unconstructed types by absolute path.
+func (h ApiTdlClientGetValuesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientGetValuesParams
+	c, err := getModePlugin(ctx, params, false, &p)
+	if err != nil {
+		return nil, err
+	}
+	values, valuesErr := c.GetValues(p.Paths)
+	if valuesErr != nil {
+		return nil, invalidParams(valuesErr)
+	}
+	return values, nil
+}
+
+// ApiTdlClientSetRateHandler sets the rate of the Tdl client.
+func (h ApiTdlClientSetRateHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientSetRateParams
+	c, err := getModePlugin(ctx, params, false, &p)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetRate(p.Rate); err != nil {
+		return nil, invalidParams(err)
+	}
+	return true, nil
+}
+
+// ApiTdlClientSetDstHandler sets the destination of the Tdl client.
+func (h ApiTdlClientSetDstHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiTdlClientSetDstParams
+	c, err := getModePlugin(ctx, params, false, &p)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetDst(p.Dst); err != nil {
+		return nil, invalidParams(err)
+	}
+	return true, nil
+}
+
+// ApiTdlClientPauseHandler pauses sending packets.
+func (h ApiTdlClientPauseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getModePlugin(ctx, params, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Pause()
+	return true, nil
+}
+
+// ApiTdlClientResumeHandler resumes sending packets.
+func (h ApiTdlClientResumeHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getModePlugin(ctx, params, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Resume()
+	return true, nil
+}
+
+// ApiTdlClientSetMetaHandler hot-swaps the metadata and the object of the Tdl client.
+func (h ApiTdlClientSetMetaHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p TdlMetaDataParams
+	c, err := getModePlugin(ctx, params, false, &p)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetMetaData(&p); err != nil {
+		return nil, invalidParams(err)
+	}
+	return true, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -710,6 +1142,15 @@ func init() {
 	*/
 
 	core.RegisterCB("tdl_c_cnt", ApiTdlClientCntHandler{}, false) // get counters / meta
+	core.RegisterCB("tdl_c_get_rx_objects", ApiTdlClientGetRxObjectsHandler{}, false)
+	core.RegisterCB("tdl_c_get_rx_errors", ApiTdlClientGetRxErrorsHandler{}, false)
+	core.RegisterCB("tdl_c_set_values", ApiTdlClientSetValuesHandler{}, false)
+	core.RegisterCB("tdl_c_get_values", ApiTdlClientGetValuesHandler{}, false)
+	core.RegisterCB("tdl_c_set_rate", ApiTdlClientSetRateHandler{}, false)
+	core.RegisterCB("tdl_c_set_dst", ApiTdlClientSetDstHandler{}, false)
+	core.RegisterCB("tdl_c_pause", ApiTdlClientPauseHandler{}, false)
+	core.RegisterCB("tdl_c_resume", ApiTdlClientResumeHandler{}, false)
+	core.RegisterCB("tdl_c_set_meta", ApiTdlClientSetMetaHandler{}, false)
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -0,0 +1,297 @@
+package tdl
+
+/**
+Cisco's TDL - The Definition Language
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+/*
+Tdl receiver mode.
+
+A Tdl client configured with a receiver listens on a port instead of sending to a destination. Incoming packets
+are parsed with the same metadata the sender uses: the header is decoded, the LUID following it is verified against
+the registered LUIDs, and the object of that type is decoded and validated. In TCP the packets are a stream, the
+length of each packet is known from the type of its LUID. The last decoded objects and the last decode errors are
+kept and can be read through RPC.
+*/
+
+import (
+	"emu/plugins/transport"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	TdlHeaderLen         = 43  // Length of an encoded Tdl header, including the LUID
+	DefaultObjectsToKeep = 100 // Default num of decoded objects to keep
+	DefaultErrorsToKeep  = 100 // Default num of decode errors to keep
+)
+
+// Decode a byte array into a TdlHeader.
+func (o *TdlHeader) Decode(b []byte) error {
+	if len(b) < TdlHeaderLen {
+		return fmt.Errorf("Byte Array provided to decode is too short.\n")
+	}
+	o.Magic = b[0]
+	o.Fru = b[1]
+	o.SrcChassis = b[2]
+	o.SrcSlot = b[3]
+	o.DstChassis = b[4]
+	o.DstSlot = b[5]
+	o.Bay = b[6]
+	o.StateTracking = b[7]
+	o.Flag = b[8]
+	o.DomainHash = int32(binary.BigEndian.Uint32(b[9:]))
+	o.Len = int32(binary.BigEndian.Uint32(b[13:]))
+	o.Uuid = int64(binary.BigEndian.Uint64(b[17:]))
+	o.TenantId = int16(binary.BigEndian.Uint16(b[25:]))
+	copy(o.Luid[:], b[27:TdlHeaderLen])
+	return nil
+}
+
+// TdlReceiverParams defines the init Json params of a Tdl client in receiver mode.
+type TdlReceiverParams struct {
+	Port          uint16               `json:"port" validate:"required"`      // Port to listen on
+	UdpDebug      bool                 `json:"udp_debug"`                     // Should we listen on UDP because we are debugging?
+	Meta          *fastjson.RawMessage `json:"meta_data" validate:"required"` // Tdl meta data Json to pass to the metadata manager.
+	ObjectsToKeep uint32               `json:"objects_to_keep"`               // Num of last decoded objects to keep. Default=100.
+	ErrorsToKeep  uint32               `json:"errors_to_keep"`                // Num of last decode errors to keep. Default=100.
+}
+
+// TdlDecodedObject represents an object decoded by the receiver.
+type TdlDecodedObject struct {
+	Src    string      `json:"src"`    // Address of the sender
+	Header TdlHeader   `json:"header"` // Tdl header of the packet
+	Type   string      `json:"type"`   // Type of the object, as registered with its LUID
+	Value  interface{} `json:"value"`  // Object formatted as in the simulation dump
+}
+
+// TdlDecodeError represents a packet the receiver failed to decode.
+type TdlDecodeError struct {
+	Src   string `json:"src"`   // Address of the sender
+	Luid  LUID   `json:"luid"`  // LUID of the packet, zero if the header was not decoded
+	Error string `json:"error"` // Decode error
+}
+
+// tdlReceiver holds the state of a Tdl client in receiver mode.
+type tdlReceiver struct {
+	params     TdlReceiverParams  // Receiver params
+	network    string             // Network we listen on, tcp or udp
+	listenAddr string             // Address we listen on
+	objects    []TdlDecodedObject // Last decoded objects, oldest first
+	errors     []TdlDecodeError   // Last decode errors, oldest first
+}
+
+// tdlReceiverFlow is a flow of a sender. The flow gives the address of the sender and keeps the partial packet in TCP.
+type tdlReceiverFlow struct {
+	plug   *PluginTdlClient    // Tdl client in receiver mode
+	socket transport.SocketApi // Socket of the flow
+	stream bool                // Is the flow a stream, where packets can span several reads
+	buf    []byte              // Partial packet of a stream
+}
+
+// OnRxEvent function to complete the ISocketCb interface.
+func (o *tdlReceiverFlow) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+}
+
+// OnRxData is called when data is received from the sender.
+func (o *tdlReceiverFlow) OnRxData(d []byte) {
+	src := o.socket.RemoteAddr().String()
+	if !o.stream {
+		o.plug.HandleRxPacket(src, d)
+		return
+	}
+	o.buf = append(o.buf, d...)
+	n := o.plug.decodePackets(src, o.buf, true)
+	o.buf = append(o.buf[:0], o.buf[n:]...)
+}
+
+// OnTxEvent function to complete the ISocketCb interface.
+func (o *tdlReceiverFlow) OnTxEvent(event transport.SocketEventType) { /* No Tx expected */ }
+
+// OnAccept is called when a new sender flow is received. This completes the IServerSocketCb interface.
+func (o *PluginTdlClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return &tdlReceiverFlow{plug: o, socket: socket, stream: o.receiver.network == "tcp"}
+}
+
+// initReceiver initializes the Tdl client in receiver mode.
+func (o *PluginTdlClient) initReceiver(receiverJson *fastjson.RawMessage) error {
+	r := new(tdlReceiver)
+	r.params = TdlReceiverParams{ObjectsToKeep: DefaultObjectsToKeep, ErrorsToKeep: DefaultErrorsToKeep}
+	err := o.Tctx.UnmarshalValidate(*receiverJson, &r.params)
+	if err != nil {
+		o.stats.badOrNoInitJson++
+		return err
+	}
+	o.receiver = r
+
+	// Create Metadata Manager
+	o.metaDataMgr, err = NewTdlMetaDataMgr(o, r.params.Meta)
+	if err != nil {
+		o.stats.failedBuildingMetaDataMgr++
+		return err
+	}
+	RegisterPrimitiveLuid(o)     // Register primitive types
+	o.metaDataMgr.registerLuid() // Register meta data types
+
+	o.transportCtx = transport.GetTransportCtx(o.Client)
+	if o.transportCtx == nil {
+		o.stats.invalidSocket++
+		return fmt.Errorf("failed to get client's transport layer")
+	}
+	r.network = "tcp"
+	if r.params.UdpDebug {
+		r.network = "udp"
+	}
+	r.listenAddr = ":" + strconv.Itoa(int(r.params.Port))
+	if err := o.transportCtx.Listen(r.network, r.listenAddr, o); err != nil {
+		o.stats.invalidSocket++
+		return fmt.Errorf("could not create listening socket: %w", err)
+	}
+	return nil
+}
+
+// HandleRxPacket decodes a datagram received from src. The datagram should hold whole Tdl packets.
+func (o *PluginTdlClient) HandleRxPacket(src string, b []byte) {
+	o.decodePackets(src, b, false)
+}
+
+// decodePackets decodes the Tdl packets in b received from src. In a stream, a partial packet at the end of b
+// is left for the next read. Returns the number of bytes consumed.
+func (o *PluginTdlClient) decodePackets(src string, b []byte, stream bool) int {
+	consumed := 0
+	for consumed < len(b) {
+		n, err := o.decodePacket(src, b[consumed:])
+		if n == 0 {
+			if stream {
+				// Wait for the rest of the packet.
+				break
+			}
+			o.stats.pktsRx++
+			o.stats.pktTruncated++
+			err = fmt.Errorf("Packet of %v bytes is truncated.", len(b)-consumed)
+			n = len(b) - consumed
+		}
+		if err != nil {
+			var luid LUID
+			if len(b)-consumed >= TdlHeaderLen {
+				copy(luid[:], b[consumed+TdlHeaderLen-len(luid):consumed+TdlHeaderLen])
+			}
+			o.addDecodeError(TdlDecodeError{Src: src, Luid: luid, Error: err.Error()})
+		}
+		consumed += n
+	}
+	// Bytes of a partial packet are counted once the packet is complete.
+	o.stats.bytesRx += uint64(consumed)
+	return consumed
+}
+
+// decodePacket decodes one Tdl packet at the start of b. Returns the length of the packet, or 0 if b holds only
+// part of it. If the packet can't be parsed, the length is all of b since there is no way to find the next packet.
+func (o *PluginTdlClient) decodePacket(src string, b []byte) (int, error) {
+	var header TdlHeader
+	if header.Decode(b) != nil {
+		return 0, nil
+	}
+	typeName, ok := o.luidTypeMap[header.Luid]
+	if !ok {
+		o.stats.pktsRx++
+		o.stats.unknownLuid++
+		return len(b), fmt.Errorf("LUID %v is not registered.", header.Luid)
+	}
+	obj, err := o.newTdlTypeInstance(typeName)
+	if err != nil {
+		o.stats.pktsRx++
+		o.stats.decodeError++
+		return len(b), err
+	}
+	length := TdlHeaderLen + obj.GetLength()
+	if len(b) < length {
+		return 0, nil
+	}
+	o.stats.pktsRx++
+	if err = obj.Decode(b[TdlHeaderLen:length]); err != nil {
+		o.stats.decodeError++
+		return length, fmt.Errorf("Failed decoding %v: %w", typeName, err)
+	}
+	o.stats.objectsRx++
+	o.addDecodedObject(TdlDecodedObject{Src: src, Header: header, Type: typeName, Value: formatTdlObject(obj)})
+	return length, nil
+}
+
+// newTdlTypeInstance creates a new instance of a primitive type or of a type defined in metadata.
+func (o *PluginTdlClient) newTdlTypeInstance(typeName string) (TdlTypeIF, error) {
+	if IsPrimitiveTdlType(typeName) {
+		return CreatePrimitiveTdlType(typeName), nil
+	}
+	meta, ok := o.metaDataMgr.metaMap[typeName]
+	if !ok {
+		return nil, fmt.Errorf("Type %v is not primitive and not present in meta", typeName)
+	}
+	ctor, err := getTdlInstanceCtor(meta.GetType())
+	if err != nil {
+		return nil, err
+	}
+	return ctor(meta)
+}
+
+// formatTdlObject formats a Tdl object as in the simulation dump.
+func formatTdlObject(obj TdlTypeIF) interface{} {
+	if obj.IsConstructedType() {
+		return BuildJson(obj.(ConstructedTdlTypeIF).GetUnconstructedTypes())
+	}
+	return obj.(UnconstructedTdlTypeIF).FormatTdlType()
+}
+
+// addDecodedObject keeps a decoded object, the oldest object is dropped if there are ObjectsToKeep already.
+func (o *PluginTdlClient) addDecodedObject(obj TdlDecodedObject) {
+	r := o.receiver
+	max := int(r.params.ObjectsToKeep)
+	if max == 0 {
+		return
+	}
+	if len(r.objects) == max {
+		r.objects = append(r.objects[:0], r.objects[1:]...)
+	}
+	r.objects = append(r.objects, obj)
+}
+
+// addDecodeError keeps a decode error, the oldest error is dropped if there are ErrorsToKeep already.
+func (o *PluginTdlClient) addDecodeError(e TdlDecodeError) {
+	r := o.receiver
+	max := int(r.params.ErrorsToKeep)
+	if max == 0 {
+		return
+	}
+	if len(r.errors) == max {
+		r.errors = append(r.errors[:0], r.errors[1:]...)
+	}
+	r.errors = append(r.errors, e)
+}
+
+// GetDecodedObjects returns the last count decoded objects, from the oldest to the newest. 0 returns all of them.
+func (o *PluginTdlClient) GetDecodedObjects(count int) []TdlDecodedObject {
+	objects := o.receiver.objects
+	if count <= 0 || count > len(objects) {
+		count = len(objects)
+	}
+	return append([]TdlDecodedObject{}, objects[len(objects)-count:]...)
+}
+
+// GetDecodeErrors returns the last count decode errors, from the oldest to the newest. 0 returns all of them.
+func (o *PluginTdlClient) GetDecodeErrors(count int) []TdlDecodeError {
+	errors := o.receiver.errors
+	if count <= 0 || count > len(errors) {
+		count = len(errors)
+	}
+	return append([]TdlDecodeError{}, errors[len(errors)-count:]...)
+}
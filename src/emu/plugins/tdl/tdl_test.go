@@ -3,10 +3,11 @@ package tdl
 import (
 	"emu/core"
 	"fmt"
-	"math/rand"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/intel-go/fastjson"
 )
 
 var monitor int
@@ -44,6 +45,9 @@ type TdlTestCb func(tctx *core.CThreadCtx, test *TdlTestBase) int
 
 func createSimulationEnv(simRx *core.VethIFSim, t *TdlTestBase) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	if t.seed != 0 {
+		tctx.SetSeed(t.seed)
+	}
 	var key core.CTunnelKey
 	key.Set(&core.CTunnelData{Vport: 1})
 	ns := core.NewNSCtx(tctx, &key)
@@ -88,10 +92,6 @@ func createSimulationEnv(simRx *core.VethIFSim, t *TdlTestBase) (*core.CThreadCt
 
 func (o *TdlTestBase) Run(t *testing.T, compare bool) {
 
-	if o.seed != 0 {
-		rand.Seed(o.seed)
-	}
-
 	var simVeth VethTdlSim
 	simVeth.DropAll = o.dropAll
 	var simrx core.VethIFSim
@@ -564,3 +564,253 @@ func TestTypeDef1(t *testing.T) {
 	}
 	a.Run(t, true)
 }
+
+// receiverMeta defines an enum, a flag and a type with all of them and counter64.
+var receiverMeta = `[
+	{
+		"name": "oper_state",
+		"type": "enum_def",
+		"data": {
+			"luid": [1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1],
+			"entries": [{"name": "DOWN", "value": 0}, {"name": "UP", "value": 1}]
+		}
+	},
+	{
+		"name": "if_flags",
+		"type": "flag_def",
+		"data": {
+			"luid": [2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2],
+			"entries": ["FLAG0", "FLAG1", "FLAG2"]
+		}
+	},
+	{
+		"name": "if_stats",
+		"type": "type_def",
+		"data": {
+			"luid": [3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3],
+			"entries": [
+				{"name": "if_index", "type": "uint32"},
+				{"name": "state", "type": "oper_state"},
+				{"name": "flags", "type": "if_flags"},
+				{"name": "rx_pkts", "type": "counter64"}
+			]
+		}
+	}
+]`
+
+// newTestReceiver creates a Tdl client in receiver mode.
+func newTestReceiver(t *testing.T, objectsToKeep int) (*core.CThreadCtx, *PluginTdlClient) {
+	initJson := fmt.Sprintf(`{"receiver": {"port": 8080, "udp_debug": true, "objects_to_keep": %v, "meta_data": %v}}`,
+		objectsToKeep, receiverMeta)
+	var simVeth VethTdlSim
+	var simrx core.VethIFSim = &simVeth
+	tctx, _ := createSimulationEnv(&simrx, &TdlTestBase{clientsToSim: 1, initJSON: [][]byte{[]byte(initJson)}})
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	c := tctx.GetNs(&key).CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+	plug := c.PluginCtx.Get(TDL_PLUG)
+	if plug == nil {
+		t.Fatalf("Can't find plugin")
+	}
+	return tctx, plug.Ext.(*PluginTdlClient)
+}
+
+// encodeTestPacket encodes a Tdl packet of an if_stats object.
+func encodeTestPacket(t *testing.T, o *PluginTdlClient, state string, rxPkts int64) []byte {
+	obj, err := o.newTdlTypeInstance("if_stats")
+	if err != nil {
+		t.Fatalf("Failed creating if_stats %v", err)
+	}
+	types := obj.(ConstructedTdlTypeIF).GetUnconstructedTypes()
+	values := map[string]string{"if_index": `7`, "state": `"` + state + `"`, "flags": `["FLAG0", "FLAG2"]`,
+		"rx_pkts": fmt.Sprintf(`{"counter": %v, "residual": 0}`, rxPkts)}
+	for path, value := range values {
+		raw := fastjson.RawMessage(value)
+		if err := types[path].SetValue(&raw); err != nil {
+			t.Fatalf("Failed setting %v %v", path, err)
+		}
+	}
+	header := TdlHeader{Magic: 255, Uuid: 3650, TenantId: 5, Luid: o.metaDataMgr.metaMap["if_stats"].GetLuid()}
+	b := header.Encode()
+	encoded := make([]byte, obj.GetLength())
+	if err := obj.Encode(encoded); err != nil {
+		t.Fatalf("Failed encoding if_stats %v", err)
+	}
+	return append(b, encoded...)
+}
+
+func TestTdlReceiver(t *testing.T) {
+	tctx, o := newTestReceiver(t, 2)
+	defer tctx.Delete()
+	src := "16.0.0.1:5000"
+
+	// Two packets in a datagram, then a third one drops the oldest object.
+	pkt := encodeTestPacket(t, o, "UP", 100)
+	o.HandleRxPacket(src, append(pkt, encodeTestPacket(t, o, "DOWN", 200)...))
+	o.HandleRxPacket(src, encodeTestPacket(t, o, "UP", 300))
+	objects := o.GetDecodedObjects(0)
+	if len(objects) != 2 || objects[0].Type != "if_stats" || objects[0].Src != src || objects[0].Header.Uuid != 3650 {
+		t.Fatalf("Bad objects %+v", objects)
+	}
+	value := objects[0].Value.(map[string]interface{})
+	state := value["state"].(*TdlFormattedType).Value.(TdlEnumEntry)
+	flags := value["flags"].(*TdlFormattedType).Value.([]string)
+	rxPkts := value["rx_pkts"].(*TdlFormattedType).Value.(TdlCounter64)
+	if state.Name != "DOWN" || len(flags) != 2 || flags[1] != "FLAG2" || rxPkts.Counter != 200 {
+		t.Fatalf("Bad object %+v %+v %+v", state, flags, rxPkts)
+	}
+	if last := o.GetDecodedObjects(1); len(last) != 1 || last[0].Value.(map[string]interface{})["rx_pkts"].(*TdlFormattedType).Value.(TdlCounter64).Counter != 300 {
+		t.Fatalf("Bad last object %+v", last)
+	}
+
+	// Unknown LUID, truncated packet, invalid enum value and undefined flag.
+	unknown := append([]byte{}, pkt...)
+	unknown[TdlHeaderLen-1] = 9
+	o.HandleRxPacket(src, unknown)
+	o.HandleRxPacket(src, pkt[:len(pkt)-1])
+	badEnum := append([]byte{}, pkt...)
+	badEnum[TdlHeaderLen+6+4+4] = 5 // enum value follows the typedef header, the uint32 and the enum variant
+	o.HandleRxPacket(src, badEnum)
+	badFlag := append([]byte{}, pkt...)
+	badFlag[TdlHeaderLen+6+4+5+5] = 0x80 // bit array follows the enum, the flag variant and jump
+	o.HandleRxPacket(src, badFlag)
+
+	// A stream, the packet is split between reads.
+	n := o.decodePackets(src, pkt[:20], true)
+	if n != 0 {
+		t.Fatalf("Partial packet consumed %v bytes", n)
+	}
+	if n = o.decodePackets(src, append(pkt, pkt[:50]...), true); n != len(pkt) {
+		t.Fatalf("Stream consumed %v bytes, want %v", n, len(pkt))
+	}
+
+	errors := o.GetDecodeErrors(0)
+	if len(errors) != 4 || errors[0].Luid[15] != 9 || errors[1].Error != fmt.Sprintf("Packet of %v bytes is truncated.", len(pkt)-1) {
+		t.Fatalf("Bad errors %+v", errors)
+	}
+	expected := TdlStats{pktsRx: 8, bytesRx: uint64(8*len(pkt) - 1), objectsRx: 4, unknownLuid: 1, decodeError: 2, pktTruncated: 1}
+	if o.stats != expected {
+		t.Fatalf("Bad counters, want %+v, have %+v", expected, o.stats)
+	}
+}
+
+// newTestSender creates a Tdl client sending an if_stats object.
+func newTestSender(t *testing.T) (*core.CThreadCtx, *PluginTdlClient) {
+	initJson := fmt.Sprintf(`{
+		"dst": "1.1.1.1:8080",
+		"udp_debug": true,
+		"rate_pps": 2,
+		"header": {"magic": 255, "uuid": 3650, "tenant_id": 5, "luid": [3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3]},
+		"meta_data": %v,
+		"object": {"name": "intf", "type": "if_stats"},
+		"init_values": [
+			{"path": "intf.if_index", "value": 7},
+			{"path": "intf.state", "value": "UP"},
+			{"path": "intf.flags", "value": ["FLAG0"]},
+			{"path": "intf.rx_pkts", "value": {"counter": 100, "residual": 0}}
+		]
+	}`, receiverMeta)
+	var simVeth VethTdlSim
+	var simrx core.VethIFSim = &simVeth
+	tctx, _ := createSimulationEnv(&simrx, &TdlTestBase{clientsToSim: 1, initJSON: [][]byte{[]byte(initJson)}})
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	c := tctx.GetNs(&key).CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+	plug := c.PluginCtx.Get(TDL_PLUG)
+	if plug == nil {
+		t.Fatalf("Can't find plugin")
+	}
+	return tctx, plug.Ext.(*PluginTdlClient)
+}
+
+func TestTdlRuntimeUpdate(t *testing.T) {
+	tctx, o := newTestSender(t)
+	defer tctx.Delete()
+	tctx.MainLoopSim(2 * time.Second)
+	if o.stats.pktsTx == 0 {
+		t.Fatalf("No packets sent")
+	}
+
+	// Link down and counter wrap.
+	var values []UnconstructedTdlType
+	for path, value := range map[string]string{"intf.state": `"DOWN"`, "intf.rx_pkts": `{"counter": -1, "residual": 5}`} {
+		raw := fastjson.RawMessage(value)
+		values = append(values, UnconstructedTdlType{AbsPath: path, Value: &raw})
+	}
+	if err := o.SetValues(values); err != nil {
+		t.Fatalf("Failed setting values %v", err)
+	}
+	got, err := o.GetValues([]string{"intf.state", "intf.rx_pkts"})
+	if err != nil || got["intf.state"].Value.(TdlEnumEntry).Name != "DOWN" || got["intf.rx_pkts"].Value.(TdlCounter64).Counter != -1 {
+		t.Fatalf("Bad values %+v %v", got, err)
+	}
+	// An invalid value, none of the values is set.
+	badState := fastjson.RawMessage(`"UNKNOWN"`)
+	index := fastjson.RawMessage(`9`)
+	err = o.SetValues([]UnconstructedTdlType{{AbsPath: "intf.if_index", Value: &index}, {AbsPath: "intf.state", Value: &badState}})
+	got, _ = o.GetValues(nil)
+	if err == nil || len(got) != 4 || got["intf.if_index"].Value.(TdlUint32) != 7 || got["intf.state"].Value.(TdlEnumEntry).Name != "DOWN" {
+		t.Fatalf("Invalid values were set %+v %v", got, err)
+	}
+	if _, err = o.GetValues([]string{"intf.none"}); err == nil {
+		t.Fatalf("Got value of unexisting path")
+	}
+
+	// Pause, then resume at a higher rate.
+	o.Pause()
+	pktsTx := o.stats.pktsTx
+	tctx.MainLoopSim(2 * time.Second)
+	if o.stats.pktsTx != pktsTx {
+		t.Fatalf("Sent %v packets while paused", o.stats.pktsTx-pktsTx)
+	}
+	if err = o.SetRate(10); err != nil || o.SetRate(0) == nil {
+		t.Fatalf("Bad set rate %v", err)
+	}
+	o.Resume()
+	tctx.MainLoopSim(1 * time.Second)
+	if sent := o.stats.pktsTx - pktsTx; sent < 8 {
+		t.Fatalf("Sent %v packets in a second at 10 PPS", sent)
+	}
+
+	// New destination.
+	if err = o.SetDst("2.2.2.2:9090"); err != nil || o.SetDst("2.2.2.2") == nil {
+		t.Fatalf("Bad set dst %v", err)
+	}
+	pktsTx = o.stats.pktsTx
+	tctx.MainLoopSim(1 * time.Second)
+	if o.socket == nil || o.socket.RemoteAddr().String() != "2.2.2.2:9090" || o.stats.pktsTx == pktsTx {
+		t.Fatalf("Not sending to the new destination")
+	}
+
+	// Invalid metadata keeps the current object, then swap to a single counter.
+	meta := fastjson.RawMessage(`[{"name": "x", "type": "type_def", "data": {"luid": [4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4], "entries": [{"name": "a", "type": "none"}]}}]`)
+	xHeader := TdlHeader{Magic: 255, Uuid: 1, Luid: LUID{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4}}
+	params := TdlMetaDataParams{Header: &xHeader, Meta: &meta, Object: TdlObject{Name: "obj", Type: "x"}}
+	if err = o.SetMetaData(&params); err == nil {
+		t.Fatalf("Invalid metadata was set")
+	}
+	if _, err = o.GetValues([]string{"intf.state"}); err != nil {
+		t.Fatalf("Current object was not kept %v", err)
+	}
+	meta = fastjson.RawMessage(`[]`)
+	counter := fastjson.RawMessage(`{"counter": 1, "residual": 2}`)
+	params = TdlMetaDataParams{Meta: &meta, Object: TdlObject{Name: "cnt", Type: "counter64"},
+		InitValues: []UnconstructedTdlType{{AbsPath: "cnt", Value: &counter}}}
+	if err = o.SetMetaData(&params); err == nil {
+		t.Fatalf("Object type changed without a header with its LUID")
+	}
+	header := TdlHeader{Magic: 255, Uuid: 1, Luid: LUID{198, 110, 87, 174, 241, 19, 164, 204, 7, 190, 188, 114, 20, 219, 19, 248}}
+	params.Header = &header
+	if err = o.SetMetaData(&params); err != nil {
+		t.Fatalf("Failed setting metadata %v", err)
+	}
+	got, _ = o.GetValues(nil)
+	if len(got) != 1 || got["cnt"].Value.(TdlCounter64).Residual != 2 {
+		t.Fatalf("Bad values after metadata swap %+v", got)
+	}
+	pktsTx = o.stats.pktsTx
+	tctx.MainLoopSim(1 * time.Second)
+	if o.stats.pktsTx == pktsTx || len(o.payload) != TdlHeaderLen+16 || o.stats.payloadUpdateErr != 0 {
+		t.Fatalf("Bad payload after metadata swap, %v bytes", len(o.payload))
+	}
+}
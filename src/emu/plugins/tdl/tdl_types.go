@@ -1006,9 +1006,14 @@ func (o *TdlEnumInstance) Decode(b []byte) error {
 	if len(b) < o.GetLength() {
 		return fmt.Errorf("Byte Array provided to decode is too short.\n")
 	}
+	value := int32(binary.BigEndian.Uint32(b[1:]))
+	name, ok := o.meta.valueToName[value]
+	if !ok {
+		return fmt.Errorf("Value %v not a valid enum entry.", value)
+	}
 	o.Variant = b[0]
-	o.val.Value = int32(binary.BigEndian.Uint32(b[1:]))
-	o.val.Name = o.meta.valueToName[o.val.Value]
+	o.val.Value = value
+	o.val.Name = name
 	return nil
 }
 
@@ -1194,6 +1199,10 @@ func (o *TdlFlagInstance) Decode(b []byte) error {
 	if copied != o.meta.bitArrayLen {
 		return fmt.Errorf("Failed decoding TdlFlagDef.")
 	}
+	// Bits after the last flag must be clear.
+	if unused := len(o.meta.Entries) % 8; unused != 0 && o.bitArray[o.meta.bitArrayLen-1]>>unused != 0 {
+		return fmt.Errorf("Bit array %v sets undefined flags.", o.bitArray)
+	}
 	return nil
 }
 
@@ -1375,6 +1384,9 @@ func (o *TdlTypeInstance) Encode(b []byte) error {
 
 // Decode a byte array into a TdlTypeDef.
 func (o *TdlTypeInstance) Decode(b []byte) error {
+	if len(b) < o.GetLength() {
+		return fmt.Errorf("Byte Array provided to decode is too short.\n")
+	}
 	o.Variant = b[0]
 	o.Flags = b[1]
 	o.JumpVariant = int32(binary.BigEndian.Uint32(b[2:]))
@@ -1473,7 +1485,10 @@ func NewTdlTypeInstance(meta TdlMetaDataIF) (TdlTypeIF, error) {
 	}
 	o := new(TdlTypeInstance)
 	o.meta = typeDefMeta
-	o.build()
+	err := o.build()
+	if err != nil {
+		return nil, err
+	}
 	return o, nil
 }
 
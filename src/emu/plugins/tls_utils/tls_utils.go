@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package tls_utils
+
+/*
+In-memory TLS engine shared by the plugins that carry TLS over their own transport (EAP-TLS, DoT/DoH).
+
+The EMU is event driven while crypto/tls expects a blocking net.Conn.
+The tls.Conn runs in a worker goroutine over an in-memory connection, and the
+main loop blocks until the worker asks for more input. The engine is therefore
+synchronous from the plugin point of view:
+
+	Start()  -> the first flight (ClientHello for a client, nothing for a server)
+	Feed(d)  -> events (handshake done, decrypted data), records to send in TakeOut()
+	Write(d) -> application data, records to send in TakeOut()
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type tlsAddr struct{}
+
+func (o tlsAddr) Network() string { return "emu" }
+func (o tlsAddr) String() string  { return "emu" }
+
+// tlsConn is the in-memory net.Conn between the tls.Conn and the plugin.
+type tlsConn struct {
+	in     bytes.Buffer
+	out    bytes.Buffer
+	rxCh   chan []byte   // main -> worker, bytes received from the peer
+	waitCh chan struct{} // worker -> main, worker needs more input
+	quit   chan struct{}
+}
+
+func (o *tlsConn) Read(b []byte) (int, error) {
+	for o.in.Len() == 0 {
+		select {
+		case o.waitCh <- struct{}{}:
+		case <-o.quit:
+			return 0, io.EOF
+		}
+		select {
+		case d := <-o.rxCh:
+			o.in.Write(d)
+		case <-o.quit:
+			return 0, io.EOF
+		}
+	}
+	return o.in.Read(b)
+}
+
+func (o *tlsConn) Write(b []byte) (int, error)        { return o.out.Write(b) }
+func (o *tlsConn) Close() error                       { return nil }
+func (o *tlsConn) LocalAddr() net.Addr                { return tlsAddr{} }
+func (o *tlsConn) RemoteAddr() net.Addr               { return tlsAddr{} }
+func (o *tlsConn) SetDeadline(t time.Time) error      { return nil }
+func (o *tlsConn) SetReadDeadline(t time.Time) error  { return nil }
+func (o *tlsConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// TlsEvent is reported by the worker, either the handshake result or decrypted data.
+type TlsEvent struct {
+	Handshake bool   // handshake finished, Err is the handshake result
+	Data      []byte // application data from the peer
+	Err       error
+}
+
+// TlsSession is a single TLS session, client or server.
+type TlsSession struct {
+	conn      tlsConn
+	tc        *tls.Conn
+	evCh      chan TlsEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTlsSession creates a client session, or a server session if server is true.
+func NewTlsSession(cfg *tls.Config, server bool) *TlsSession {
+	o := new(TlsSession)
+	o.conn.rxCh = make(chan []byte)
+	o.conn.waitCh = make(chan struct{})
+	o.conn.quit = make(chan struct{})
+	if server {
+		o.tc = tls.Server(&o.conn, cfg)
+	} else {
+		o.tc = tls.Client(&o.conn, cfg)
+	}
+	o.evCh = make(chan TlsEvent)
+	o.done = make(chan struct{})
+	return o
+}
+
+func (o *TlsSession) post(ev TlsEvent) bool {
+	select {
+	case o.evCh <- ev:
+		return true
+	case <-o.conn.quit:
+		return false
+	}
+}
+
+func (o *TlsSession) worker() {
+	defer close(o.done)
+	err := o.tc.Handshake()
+	if !o.post(TlsEvent{Handshake: true, Err: err}) || err != nil {
+		return
+	}
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := o.tc.Read(buf)
+		d := make([]byte, n)
+		copy(d, buf[:n])
+		if !o.post(TlsEvent{Data: d, Err: err}) || err != nil {
+			return
+		}
+	}
+}
+
+// wait blocks until the worker needs more input or exits.
+func (o *TlsSession) wait() []TlsEvent {
+	var evs []TlsEvent
+	for {
+		select {
+		case <-o.conn.waitCh:
+			return evs
+		case ev := <-o.evCh:
+			evs = append(evs, ev)
+		case <-o.done:
+			return evs
+		}
+	}
+}
+
+// Start runs the handshake up to the point it waits for the peer.
+func (o *TlsSession) Start() []TlsEvent {
+	go o.worker()
+	return o.wait()
+}
+
+// Feed pushes bytes received from the peer to the TLS engine.
+func (o *TlsSession) Feed(d []byte) ([]TlsEvent, error) {
+	b := make([]byte, len(d)) // the caller owns d
+	copy(b, d)
+	select {
+	case o.conn.rxCh <- b:
+	case <-o.done:
+		return nil, errors.New("tls session is closed")
+	}
+	return o.wait(), nil
+}
+
+// Write encrypts application data, the records are collected by TakeOut.
+func (o *TlsSession) Write(d []byte) error {
+	_, err := o.tc.Write(d)
+	return err
+}
+
+// TakeOut returns the pending records for the peer.
+func (o *TlsSession) TakeOut() []byte {
+	if o.conn.out.Len() == 0 {
+		return nil
+	}
+	r := make([]byte, o.conn.out.Len())
+	copy(r, o.conn.out.Bytes())
+	o.conn.out.Reset()
+	return r
+}
+
+// Close stops the worker, it is safe to call it more than once.
+func (o *TlsSession) Close() {
+	o.closeOnce.Do(func() { close(o.conn.quit) })
+}
@@ -317,6 +317,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active source ports",
 		Unit:     "event",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -357,6 +358,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active v4 flows",
 		Unit:     "flows",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -413,6 +415,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active v6 flows",
 		Unit:     "flows",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
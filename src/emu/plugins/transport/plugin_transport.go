@@ -58,6 +58,13 @@ func NewTransClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 	return &o.PluginBase, nil
 }
 
+func (o *PluginTransClient) GetCounters() *core.CCounterDbVec {
+	if tx := getTransportCtxIfExist(o.Client); tx != nil {
+		return tx.cdbv
+	}
+	return nil
+}
+
 func (o *PluginTransClient) OnEvent(msg string, a, b interface{}) {
 
 }
@@ -21,6 +21,8 @@ const (
 	TCP_IOCTL_DELAY_ACK_MSEC = "delay_ack_msec"   // msec of fast tcp time
 	TCP_IOCTL_TX_BUF_SIZE    = "txbufsize"        // tx queue in bytes, can be change only in case the queue if empty
 	TCP_IOCTL_RX_BUF_SIZE    = "rxbufsize"        // rx queue in bytes
+
+	TCP_EVENT_ERROR = "tcp.error" // published when a connection is dropped with an error
 )
 
 func (o *TcpSocket) SetIoctl(m IoctlMap) error {
@@ -488,6 +490,21 @@ func (o *TcpSocket) close() {
 	/* free the reassembly queue, if any */
 	/* mark it as close and return zero */
 	o.changeStateToClose()
+	if o.socket != nil && o.socket.so_error != SeOK {
+		o.publishError()
+	}
+}
+
+// publishError publishes the error that the connection was dropped with.
+func (o *TcpSocket) publishError() {
+	if !o.tctx.IsEventEnabled(TCP_EVENT_ERROR) {
+		return
+	}
+	o.client.PublishEvent(TCP_EVENT_ERROR, &struct {
+		Error  string `json:"error"`
+		Local  string `json:"local"`
+		Remote string `json:"remote"`
+	}{o.socket.so_error.String(), o.LocalAddr().String(), o.RemoteAddr().String()})
 }
 
 func (o *TcpSocket) quench() {
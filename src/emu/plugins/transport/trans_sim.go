@@ -11,7 +11,6 @@ import (
 	"encoding/hex"
 	"external/google/gopacket/layers"
 	"fmt"
-	"math/rand"
 	"time"
 )
 
@@ -130,7 +129,7 @@ func (o *SocketAppTx1) sendChunk() {
 	if o.params.sendRandom == false {
 		csize = int(o.params.chunkSize)
 	} else {
-		csize = rand.Intn(int(o.params.chunkSize-1)) + 1
+		csize = o.tctx.Rand().Intn(int(o.params.chunkSize-1)) + 1
 	}
 
 	if o.cnt+uint32(csize) > o.params.totalClientToServerSize {
@@ -714,7 +713,7 @@ func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 	}
 	ps.L7Len = ps.M.DataLen() - ps.L7
 
-	if (o.sim.param.drop > 0.0) && (rand.Float32() < o.sim.param.drop) {
+	if (o.sim.param.drop > 0.0) && (o.sim.tctx.Rand().Float32() < o.sim.param.drop) {
 		fmt.Printf(" drop pkt : %d, to_server: %v\n", o.cnt, o.sendToServer)
 		o.m.FreeMbuf()
 		return
@@ -285,8 +285,8 @@ type TransportSimTestBase struct {
 }
 
 func (o *TransportSimTestBase) Run(t *testing.T, compare bool) {
-	rand.Seed(0x1234)
 	sim := newTransportSim(&o.param)
+	sim.tctx.SetSeed(0x1234)
 
 	m := false
 	if monitor > 0 {
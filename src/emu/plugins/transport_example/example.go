@@ -243,6 +243,10 @@ type PluginTransportENs struct {
 	cdbv  *core.CCounterDbVec
 }
 
+func (o *PluginTransportENs) GetCounters() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func NewTransportENs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginTransportENs)
 	o.InitPluginBase(ctx, o)
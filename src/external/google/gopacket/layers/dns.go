@@ -22,11 +22,12 @@ type DNSClass uint16
 
 // DNSClass known values.
 const (
-	DNSClassIN  DNSClass = 1   // Internet
-	DNSClassCS  DNSClass = 2   // the CSNET class (Obsolete)
-	DNSClassCH  DNSClass = 3   // the CHAOS class
-	DNSClassHS  DNSClass = 4   // Hesiod [Dyer 87]
-	DNSClassAny DNSClass = 255 // AnyClass
+	DNSClassIN   DNSClass = 1   // Internet
+	DNSClassCS   DNSClass = 2   // the CSNET class (Obsolete)
+	DNSClassCH   DNSClass = 3   // the CHAOS class
+	DNSClassHS   DNSClass = 4   // Hesiod [Dyer 87]
+	DNSClassNone DNSClass = 254 // NoneClass [RFC2136]
+	DNSClassAny  DNSClass = 255 // AnyClass
 )
 
 func (dc DNSClass) String() string {
@@ -41,6 +42,8 @@ func (dc DNSClass) String() string {
 		return "CH"
 	case DNSClassHS:
 		return "HS"
+	case DNSClassNone:
+		return "None"
 	case DNSClassAny:
 		return "Any"
 	}
@@ -59,6 +62,8 @@ func StringToDNSClass(dc string) (DNSClass, error) {
 		return DNSClassCH, nil
 	case "HS":
 		return DNSClassHS, nil
+	case "None":
+		return DNSClassNone, nil
 	case "Any":
 		return DNSClassAny, nil
 	}
@@ -70,25 +75,27 @@ type DNSType uint16
 
 // DNSType known values.
 const (
-	DNSTypeA     DNSType = 1  // a host address
-	DNSTypeNS    DNSType = 2  // an authoritative name server
-	DNSTypeMD    DNSType = 3  // a mail destination (Obsolete - use MX)
-	DNSTypeMF    DNSType = 4  // a mail forwarder (Obsolete - use MX)
-	DNSTypeCNAME DNSType = 5  // the canonical name for an alias
-	DNSTypeSOA   DNSType = 6  // marks the start of a zone of authority
-	DNSTypeMB    DNSType = 7  // a mailbox domain name (EXPERIMENTAL)
-	DNSTypeMG    DNSType = 8  // a mail group member (EXPERIMENTAL)
-	DNSTypeMR    DNSType = 9  // a mail rename domain name (EXPERIMENTAL)
-	DNSTypeNULL  DNSType = 10 // a null RR (EXPERIMENTAL)
-	DNSTypeWKS   DNSType = 11 // a well known service description
-	DNSTypePTR   DNSType = 12 // a domain name pointer
-	DNSTypeHINFO DNSType = 13 // host information
-	DNSTypeMINFO DNSType = 14 // mailbox or mail list information
-	DNSTypeMX    DNSType = 15 // mail exchange
-	DNSTypeTXT   DNSType = 16 // text strings
-	DNSTypeAAAA  DNSType = 28 // a IPv6 host address [RFC3596]
-	DNSTypeSRV   DNSType = 33 // server discovery [RFC2782] [RFC6195]
-	DNSTypeOPT   DNSType = 41 // OPT Pseudo-RR [RFC6891]
+	DNSTypeA     DNSType = 1   // a host address
+	DNSTypeNS    DNSType = 2   // an authoritative name server
+	DNSTypeMD    DNSType = 3   // a mail destination (Obsolete - use MX)
+	DNSTypeMF    DNSType = 4   // a mail forwarder (Obsolete - use MX)
+	DNSTypeCNAME DNSType = 5   // the canonical name for an alias
+	DNSTypeSOA   DNSType = 6   // marks the start of a zone of authority
+	DNSTypeMB    DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
+	DNSTypeMG    DNSType = 8   // a mail group member (EXPERIMENTAL)
+	DNSTypeMR    DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
+	DNSTypeNULL  DNSType = 10  // a null RR (EXPERIMENTAL)
+	DNSTypeWKS   DNSType = 11  // a well known service description
+	DNSTypePTR   DNSType = 12  // a domain name pointer
+	DNSTypeHINFO DNSType = 13  // host information
+	DNSTypeMINFO DNSType = 14  // mailbox or mail list information
+	DNSTypeMX    DNSType = 15  // mail exchange
+	DNSTypeTXT   DNSType = 16  // text strings
+	DNSTypeAAAA  DNSType = 28  // a IPv6 host address [RFC3596]
+	DNSTypeSRV   DNSType = 33  // server discovery [RFC2782] [RFC6195]
+	DNSTypeOPT   DNSType = 41  // OPT Pseudo-RR [RFC6891]
+	DNSTypeANY   DNSType = 255 // a request for all records [RFC1035]
+	DNSTypeCAA   DNSType = 257 // certification authority authorization [RFC8659]
 )
 
 func (dt DNSType) String() string {
@@ -133,6 +140,10 @@ func (dt DNSType) String() string {
 		return "SRV"
 	case DNSTypeOPT:
 		return "OPT"
+	case DNSTypeANY:
+		return "ANY"
+	case DNSTypeCAA:
+		return "CAA"
 	}
 }
 
@@ -179,6 +190,10 @@ func StringToDNSType(dt string) (DNSType, error) {
 		return DNSTypeSRV, nil
 	case "OPT":
 		return DNSTypeOPT, nil
+	case "ANY":
+		return DNSTypeANY, nil
+	case "CAA":
+		return DNSTypeCAA, nil
 	}
 }
 
@@ -468,6 +483,9 @@ func b2i(b bool) int {
 }
 
 func recSize(rr *DNSResourceRecord) int {
+	if hasEmptyRData(rr) {
+		return 0
+	}
 	switch rr.Type {
 	case DNSTypeA:
 		return 4
@@ -497,11 +515,45 @@ func recSize(rr *DNSResourceRecord) int {
 			l += len(opt.Data)
 		}
 		return l
+	case DNSTypeCAA:
+		return 2 + len(rr.CAA.Tag) + len(rr.CAA.Value)
 	}
 
 	return 0
 }
 
+// hasEmptyRData returns true for the records of a dynamic update that have no RDATA, RFC 2136:
+// type ANY, or class ANY/NONE without data.
+func hasEmptyRData(rr *DNSResourceRecord) bool {
+	if rr.Type == DNSTypeANY {
+		return true
+	}
+	if rr.Class != DNSClassAny && rr.Class != DNSClassNone {
+		return false
+	}
+	switch rr.Type {
+	case DNSTypeA, DNSTypeAAAA:
+		return rr.IP == nil
+	case DNSTypeNS:
+		return rr.NS == nil
+	case DNSTypeCNAME:
+		return rr.CNAME == nil
+	case DNSTypePTR:
+		return rr.PTR == nil
+	case DNSTypeTXT:
+		return rr.TXTs == nil
+	case DNSTypeSOA:
+		return rr.SOA.MName == nil
+	case DNSTypeMX:
+		return rr.MX.Name == nil
+	case DNSTypeSRV:
+		return rr.SRV.Name == nil
+	case DNSTypeCAA:
+		return rr.CAA.Tag == nil
+	}
+	return false
+}
+
 func computeSize(recs []DNSResourceRecord) int {
 	sz := 0
 	for _, rr := range recs {
@@ -747,6 +799,7 @@ type DNSResourceRecord struct {
 	SOA            DNSSOA
 	SRV            DNSSRV
 	MX             DNSMX
+	CAA            DNSCAA
 	OPT            []DNSOPT // See RFC 6891, section 6.1.2
 
 	// Undecoded TXT for backward compatibility
@@ -771,6 +824,10 @@ func (rr *DNSResourceRecord) decode(data []byte, offset int, df gopacket.DecodeF
 	}
 	rr.Data = data[endq+10 : end]
 
+	if rr.DataLength == 0 {
+		// Prerequisites and deletions of a dynamic update have no RDATA, RFC 2136.
+		return end, nil
+	}
 	if err = rr.decodeRData(data, endq+10, buffer); err != nil {
 		return 0, err
 	}
@@ -811,6 +868,14 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 	binary.BigEndian.PutUint16(data[noff+2:], uint16(rr.Class))
 	binary.BigEndian.PutUint32(data[noff+4:], uint32(rr.TTL))
 
+	if hasEmptyRData(rr) {
+		binary.BigEndian.PutUint16(data[noff+8:], 0)
+		if opts.FixLengths {
+			rr.DataLength = 0
+		}
+		return nSz + 10, nil
+	}
+
 	switch rr.Type {
 	case DNSTypeA:
 		copy(data[noff+10:], rr.IP.To4())
@@ -853,6 +918,11 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 			copy(data[noff2+4:], opt.Data)
 			noff2 += 4 + len(opt.Data)
 		}
+	case DNSTypeCAA:
+		data[noff+10] = rr.CAA.Flags
+		data[noff+11] = byte(len(rr.CAA.Tag))
+		copy(data[noff+12:], rr.CAA.Tag)
+		copy(data[noff+12+len(rr.CAA.Tag):], rr.CAA.Value)
 	default:
 		return 0, fmt.Errorf("serializing resource record of type %v not supported", rr.Type)
 	}
@@ -1003,6 +1073,13 @@ func (rr *DNSResourceRecord) decodeRData(data []byte, offset int, buffer *[]byte
 			return err
 		}
 		rr.OPT = allOPT
+	case DNSTypeCAA:
+		if len(rr.Data) < 2 || 2+int(rr.Data[1]) > len(rr.Data) {
+			return errDecodeRecordLength
+		}
+		rr.CAA.Flags = rr.Data[0]
+		rr.CAA.Tag = rr.Data[2 : 2+int(rr.Data[1])]
+		rr.CAA.Value = rr.Data[2+int(rr.Data[1]):]
 	}
 	return nil
 }
@@ -1028,6 +1105,13 @@ type DNSMX struct {
 	Name       []byte
 }
 
+// DNSCAA is a Certification Authority Authorization record, RFC 8659.
+type DNSCAA struct {
+	Flags uint8
+	Tag   []byte
+	Value []byte
+}
+
 // DNSOptionCode represents the code of a DNS Option, see RFC6891, section 6.1.2
 type DNSOptionCode uint16
 
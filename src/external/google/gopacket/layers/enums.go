@@ -161,6 +161,8 @@ const (
 	PPPTypeLCP           PPPType = 0xc021
 	PPPTypeIPCP          PPPType = 0x8021
 	PPPTypePAP           PPPType = 0xc023
+	PPPTypeCHAP          PPPType = 0xc223
+	PPPTypeIPv6CP        PPPType = 0x8057
 )
 
 // SCTPChunkType is an enumeration of chunk types inside SCTP packets.
@@ -377,6 +379,8 @@ func initActualTypeData() {
 	PPPTypeMetadata[PPPTypeLCP] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodeLCP), Name: "LCP"}
 	PPPTypeMetadata[PPPTypePAP] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodePAP), Name: "PAP"}
 	PPPTypeMetadata[PPPTypeIPCP] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodeIPCP), Name: "IPCP"}
+	PPPTypeMetadata[PPPTypeCHAP] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodeCHAP), Name: "CHAP"}
+	PPPTypeMetadata[PPPTypeIPv6CP] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodeIPv6CP), Name: "IPv6CP"}
 
 	PPPoECodeMetadata[PPPoECodeSession] = EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodePPP), Name: "PPP"}
 
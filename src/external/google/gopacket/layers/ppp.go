@@ -7,6 +7,7 @@
 // - added PPP LCP layer at lines from 67 to 220
 // - added PPP PAP layer at lines from 222 to 325
 // - added PPP IPCP layer at lines from 327 to 434
+// - added PPP CHAP and IPv6CP layers at the end of the file
 
 package layers
 
@@ -86,8 +87,11 @@ func (p *LCP) LayerType() gopacket.LayerType {
 const (
 	LCPTypeConfigurationRequest LCPType = 0x01
 	LCPTypeConfigurationAck     LCPType = 0x02
+	LCPTypeConfigurationNak     LCPType = 0x03
+	LCPTypeConfigurationReject  LCPType = 0x04
 	LCPTypeTerminateRequest     LCPType = 0x05
 	LCPTypeTerminateAck         LCPType = 0x06
+	LCPTypeProtocolReject       LCPType = 0x08
 	LCPTypeEchoRequest          LCPType = 0x09
 	LCPTypeEchoReply            LCPType = 0x0a
 )
@@ -108,7 +112,7 @@ type LCPOptionType uint8
 const (
 	LCPOptionTypeMaximumReceiveUnit     LCPOptionType = 0x01
 	LCPOptionTypeAuthenticationProtocol LCPOptionType = 0x03
-	LCPOptionQualityProtocol            LCPOptionType = 0x04	// https://www.freesoft.org/CIE/RFC/1661/33.htm
+	LCPOptionQualityProtocol            LCPOptionType = 0x04 // https://www.freesoft.org/CIE/RFC/1661/33.htm
 	LCPOptionTypeMagicNumber            LCPOptionType = 0x05
 )
 
@@ -432,3 +436,207 @@ func (p *IPCP) GetProposedIPAddress() []byte {
 	}
 	return ans
 }
+
+// CHAP describes layer for Challenge Handshake Authentication Protocol (rfc1994)
+type CHAP struct {
+	BaseLayer
+	Code       CHAPType
+	Identifier uint8
+	Length     uint16
+	Value      []byte // Challenge/Response only
+	Name       []byte // Challenge/Response only
+	Message    []byte // Success/Failure only
+}
+
+// CHAPType describes CHAP message type
+type CHAPType uint8
+
+// LayerType returns gopacket.LayerTypeCHAP
+func (p *CHAP) LayerType() gopacket.LayerType {
+	return LayerTypeCHAP
+}
+
+// set of supported CHAP message type
+const (
+	CHAPTypeChallenge CHAPType = 0x01
+	CHAPTypeResponse  CHAPType = 0x02
+	CHAPTypeSuccess   CHAPType = 0x03
+	CHAPTypeFailure   CHAPType = 0x04
+)
+
+// set of supported CHAP algorithms carried by the LCP Authentication-Protocol option
+const (
+	CHAPAlgorithmMD5      uint8 = 0x05
+	CHAPAlgorithmMSCHAPv2 uint8 = 0x81
+)
+
+func (p *CHAP) hasValue() bool {
+	return p.Code == CHAPTypeChallenge || p.Code == CHAPTypeResponse
+}
+
+// GetCHAPSize returns size in byte of CHAP layer
+func (p *CHAP) GetCHAPSize() uint16 {
+	ans := uint16(4) // code, identifier, length
+	if p.hasValue() {
+		ans += 1 + uint16(len(p.Value)) + uint16(len(p.Name))
+	} else {
+		ans += uint16(len(p.Message))
+	}
+	return ans
+}
+
+func decodeCHAP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 4 {
+		return errors.New("CHAP packet too small")
+	}
+	chap := &CHAP{
+		Code:       CHAPType(data[0]),
+		Identifier: data[1],
+		Length:     binary.BigEndian.Uint16(data[2:4]),
+	}
+	if int(chap.Length) < 4 || int(chap.Length) > len(data) {
+		return errors.New("CHAP has invalid length")
+	}
+	body := data[4:chap.Length]
+	if chap.hasValue() {
+		if len(body) < 1 || int(body[0])+1 > len(body) {
+			return errors.New("CHAP has invalid value size")
+		}
+		chap.Value = body[1 : 1+body[0]]
+		chap.Name = body[1+body[0]:]
+	} else {
+		chap.Message = body
+	}
+	chap.BaseLayer = BaseLayer{data[:chap.Length], data[chap.Length:]}
+	p.AddLayer(chap)
+	return nil
+}
+
+// SerializeTo for CHAP layer
+func (p *CHAP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := p.GetCHAPSize()
+	bytes, err := b.PrependBytes(int(size))
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		p.Length = size
+	}
+	bytes[0] = uint8(p.Code)
+	bytes[1] = p.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], p.Length)
+	if p.hasValue() {
+		bytes[4] = uint8(len(p.Value))
+		copy(bytes[5:], p.Value)
+		copy(bytes[5+len(p.Value):], p.Name)
+	} else {
+		copy(bytes[4:], p.Message)
+	}
+	return nil
+}
+
+// IPv6CP describes layer for IPv6 Control Protocol (rfc5072)
+type IPv6CP struct {
+	BaseLayer
+	Code       LCPType
+	Identifier uint8
+	Length     uint16
+	Options    []IPv6CPOption
+}
+
+// IPv6CPOption holds an IPv6CP configuration option
+type IPv6CPOption struct {
+	Type   IPv6CPOptionType
+	Length uint8
+	Value  []uint8
+}
+
+// IPv6CPOptionType describes IPv6CP Option type
+type IPv6CPOptionType uint8
+
+// set of supported IPv6CP Option type
+const (
+	IPv6CPOptionTypeInterfaceID IPv6CPOptionType = 0x01
+)
+
+// LayerType returns gopacket.LayerTypeIPv6CP
+func (p *IPv6CP) LayerType() gopacket.LayerType {
+	return LayerTypeIPv6CP
+}
+
+// GetIPv6CPSize returns size in byte of IPv6CP layer
+func (p *IPv6CP) GetIPv6CPSize() uint16 {
+	ans := uint16(4) // code, identifier, length
+	for _, opt := range p.Options {
+		ans += 2 + uint16(len(opt.Value))
+	}
+	return ans
+}
+
+// GetInterfaceID returns the Interface-Identifier option value or nil
+func (p *IPv6CP) GetInterfaceID() []byte {
+	for _, opt := range p.Options {
+		if opt.Type == IPv6CPOptionTypeInterfaceID && len(opt.Value) == 8 {
+			return opt.Value
+		}
+	}
+	return nil
+}
+
+func decodeIPv6CP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 4 {
+		return errors.New("IPv6CP packet too small")
+	}
+	ipv6cp := &IPv6CP{
+		Code:       LCPType(data[0]),
+		Identifier: data[1],
+		Length:     binary.BigEndian.Uint16(data[2:4]),
+		Options:    []IPv6CPOption{},
+	}
+	if int(ipv6cp.Length) < 4 || int(ipv6cp.Length) > len(data) {
+		return errors.New("IPv6CP has invalid length")
+	}
+	switch ipv6cp.Code {
+	case LCPTypeConfigurationRequest, LCPTypeConfigurationAck,
+		LCPTypeConfigurationNak, LCPTypeConfigurationReject:
+		opts := data[4:ipv6cp.Length]
+		for len(opts) >= 2 {
+			l := int(opts[1])
+			if l < 2 || l > len(opts) {
+				return errors.New("IPv6CP has invalid option length")
+			}
+			ipv6cp.Options = append(ipv6cp.Options, IPv6CPOption{
+				Type:   IPv6CPOptionType(opts[0]),
+				Length: opts[1],
+				Value:  opts[2:l],
+			})
+			opts = opts[l:]
+		}
+	}
+	ipv6cp.BaseLayer = BaseLayer{data[:ipv6cp.Length], data[ipv6cp.Length:]}
+	p.AddLayer(ipv6cp)
+	return nil
+}
+
+// SerializeTo for IPv6CP layer
+func (p *IPv6CP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := p.GetIPv6CPSize()
+	bytes, err := b.PrependBytes(int(size))
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		p.Length = size
+	}
+	bytes[0] = uint8(p.Code)
+	bytes[1] = p.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], p.Length)
+	offset := 4
+	for _, opt := range p.Options {
+		bytes[offset] = uint8(opt.Type)
+		bytes[offset+1] = uint8(2 + len(opt.Value))
+		copy(bytes[offset+2:], opt.Value)
+		offset += 2 + len(opt.Value)
+	}
+	return nil
+}
@@ -37,11 +37,15 @@ type PPPoEDTagType uint16
 
 // set of supported PPPoED Tags
 const (
-	PPPoEDTagTypeEndOfList    PPPoEDTagType = 0x0000
-	PPPoEDTagTypeServiceName  PPPoEDTagType = 0x0101
-	PPPoEDTagTypeACName       PPPoEDTagType = 0x0102
-	PPPoEDTagTypeACCookie     PPPoEDTagType = 0x0104
-	PPPoEDTagTypeGenericError PPPoEDTagType = 0x0203
+	PPPoEDTagTypeEndOfList        PPPoEDTagType = 0x0000
+	PPPoEDTagTypeServiceName      PPPoEDTagType = 0x0101
+	PPPoEDTagTypeACName           PPPoEDTagType = 0x0102
+	PPPoEDTagTypeHostUniq         PPPoEDTagType = 0x0103
+	PPPoEDTagTypeACCookie         PPPoEDTagType = 0x0104
+	PPPoEDTagTypeRelaySessionID   PPPoEDTagType = 0x0110
+	PPPoEDTagTypeServiceNameError PPPoEDTagType = 0x0201
+	PPPoEDTagTypeACSystemError    PPPoEDTagType = 0x0202
+	PPPoEDTagTypeGenericError     PPPoEDTagType = 0x0203
 )
 
 // GetPPPoEDTagsSize returns size in byte of PPPoED Tags otherwise go to panic